@@ -118,6 +118,12 @@ func main() {
 				login()
 			},
 		},
+		{
+			Name:        "admin",
+			Aliases:     []string{"a"},
+			Usage:       "manage a running abot server",
+			Subcommands: adminCommands,
+		},
 		{
 			Name:    "console",
 			Aliases: []string{"c"},
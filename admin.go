@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/codegangsta/cli"
+	"github.com/itsabot/abot/core"
+	"github.com/itsabot/abot/core/log"
+)
+
+// adminConf holds an operator's credentials for a running Abot server, saved
+// to ~/.abot_admin.conf by `abot admin login`.
+type adminConf struct {
+	URL       string
+	ID        uint64
+	Email     string
+	Scopes    []string
+	AuthToken string
+	IssuedAt  int64
+	CSRFToken string
+}
+
+// errAdminLogin is returned when the operator needs to log in again.
+var errAdminLogin = errors.New("not logged in. run: abot admin login")
+
+// adminCommands are day-two operations run through the admin API of a
+// running Abot server.
+var adminCommands = []cli.Command{
+	{
+		Name:  "login",
+		Usage: "log into a running abot server as an admin",
+		Action: func(c *cli.Context) {
+			adminAction(adminLogin(c.Args().First()))
+		},
+	},
+	{
+		Name:    "users",
+		Aliases: []string{"u"},
+		Usage:   "list users, optionally matching a name, email or phone",
+		Action: func(c *cli.Context) {
+			adminAction(adminUsers(c.Args().First()))
+		},
+	},
+	{
+		Name:    "conversation",
+		Aliases: []string{"c"},
+		Usage:   "view a user's conversation: abot admin conversation {userID}",
+		Action: func(c *cli.Context) {
+			adminAction(adminConversation(c.Args().First()))
+		},
+	},
+	{
+		Name:  "resend",
+		Usage: "resend a message abot sent: abot admin resend {messageID}",
+		Action: func(c *cli.Context) {
+			id, err := strconv.ParseUint(c.Args().First(), 10, 64)
+			if err != nil {
+				adminAction(errors.New("usage: abot admin resend {messageID}"))
+			}
+			adminAction(adminPost("/api/admin/resend.json",
+				struct{ MessageID uint64 }{MessageID: id}, nil))
+		},
+	},
+	{
+		Name:  "dead-letters",
+		Usage: "list messages abot failed to process or send",
+		Action: func(c *cli.Context) {
+			adminAction(adminDeadLetters())
+		},
+	},
+	{
+		Name:  "reprocess",
+		Usage: "retry a dead letter: abot admin reprocess {inbound|outbound} {id}",
+		Action: func(c *cli.Context) {
+			id, err := strconv.ParseUint(c.Args().Get(1), 10, 64)
+			if err != nil {
+				adminAction(errors.New("usage: abot admin reprocess {inbound|outbound} {id}"))
+			}
+			adminAction(adminPost("/api/admin/dead_letters/reprocess.json",
+				struct {
+					Kind string
+					ID   uint64
+				}{Kind: c.Args().First(), ID: id}, nil))
+		},
+	},
+	{
+		Name:  "audit",
+		Usage: "list a user's sensitive actions: abot admin audit {userID}",
+		Action: func(c *cli.Context) {
+			adminAction(adminAudit(c.Args().First()))
+		},
+	},
+	{
+		Name:  "disable",
+		Usage: "stop routing messages to a plugin: abot admin disable {plugin}",
+		Action: func(c *cli.Context) {
+			adminAction(adminSetPlugin(c.Args().First(), false))
+		},
+	},
+	{
+		Name:  "enable",
+		Usage: "resume routing messages to a plugin: abot admin enable {plugin}",
+		Action: func(c *cli.Context) {
+			adminAction(adminSetPlugin(c.Args().First(), true))
+		},
+	},
+	{
+		Name:  "migrate",
+		Usage: "apply pending database migrations",
+		Action: func(c *cli.Context) {
+			adminAction(adminMigrate())
+		},
+	},
+	{
+		Name:  "rotate-keys",
+		Usage: "rotate the key signing auth tokens, logging everyone out",
+		Action: func(c *cli.Context) {
+			err := adminPost("/api/admin/rotate_keys.json", nil, nil)
+			if err == nil {
+				fmt.Println("Rotated keys. Log in again to continue.")
+			}
+			adminAction(err)
+		},
+	},
+}
+
+// adminAction exits with an error message if an admin command failed.
+func adminAction(err error) {
+	if err == nil {
+		return
+	}
+	l := log.New("")
+	l.SetFlags(0)
+	l.Fatal(err)
+}
+
+func adminConfPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".abot_admin.conf")
+}
+
+// adminLogin logs into an Abot server, defaulting to ABOT_URL, and saves the
+// credentials for later commands.
+func adminLogin(addr string) error {
+	if len(addr) == 0 {
+		addr = os.Getenv("ABOT_URL")
+	}
+	if len(addr) == 0 {
+		addr = "http://localhost:" + os.Getenv("PORT")
+	}
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Email: ")
+	email, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	fmt.Print("Password: ")
+	pass, err := terminal.ReadPassword(0)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	byt, err := json.Marshal(struct {
+		Email    string
+		Password string
+	}{
+		Email:    strings.TrimSpace(email),
+		Password: string(pass),
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(addr+"/api/login.json", "application/json",
+		bytes.NewBuffer(byt))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errors.New("invalid email/password combination")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("login failed with status %d", resp.StatusCode)
+	}
+	conf := &adminConf{URL: addr}
+	if err = json.NewDecoder(resp.Body).Decode(conf); err != nil {
+		return err
+	}
+	var admin bool
+	for _, s := range conf.Scopes {
+		admin = admin || s == "admin"
+	}
+	if !admin {
+		return errors.New("user is not an admin")
+	}
+	byt, err = json.Marshal(conf)
+	if err != nil {
+		return err
+	}
+	// The file holds credentials, so only the operator may read it.
+	if err = ioutil.WriteFile(adminConfPath(), byt, 0600); err != nil {
+		return err
+	}
+	fmt.Println("Success!")
+	return nil
+}
+
+// adminRequest calls the admin API with the saved credentials, decoding the
+// JSON response into out if it isn't nil.
+func adminRequest(method, path string, body, out interface{}) error {
+	fi, err := os.Open(adminConfPath())
+	if os.IsNotExist(err) {
+		return errAdminLogin
+	}
+	if err != nil {
+		return err
+	}
+	conf := &adminConf{}
+	err = json.NewDecoder(fi).Decode(conf)
+	if cerr := fi.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if body != nil {
+		if err = json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, conf.URL+path, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+conf.AuthToken)
+	cookies := map[string]string{
+		"id":        strconv.FormatUint(conf.ID, 10),
+		"email":     url.QueryEscape(conf.Email),
+		"issuedAt":  strconv.FormatInt(conf.IssuedAt, 10),
+		"scopes":    strings.Join(conf.Scopes, " "),
+		"csrfToken": conf.CSRFToken,
+	}
+	for name, val := range cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: val})
+	}
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errAdminLogin
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct{ Msg string }
+		if err = json.NewDecoder(resp.Body).Decode(&e); err == nil &&
+			len(e.Msg) > 0 {
+			return errors.New(e.Msg)
+		}
+		return fmt.Errorf("request failed with status %d",
+			resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func adminGet(path string, out interface{}) error {
+	return adminRequest("GET", path, nil, out)
+}
+
+func adminPost(path string, body, out interface{}) error {
+	return adminRequest("POST", path, body, out)
+}
+
+func adminUsers(query string) error {
+	var data struct{ Users []core.AdminUser }
+	err := adminGet("/api/admin/users.json?q="+url.QueryEscape(query), &data)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tEMAIL\tCREATED")
+	for _, u := range data.Users {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", u.ID, u.Name, u.Email,
+			u.CreatedAt.Format("2006-01-02"))
+	}
+	return w.Flush()
+}
+
+func adminConversation(uid string) error {
+	if _, err := strconv.ParseUint(uid, 10, 64); err != nil {
+		return errors.New("usage: abot admin conversation {userID}")
+	}
+	var data struct{ Messages []core.TranscriptMsg }
+	if err := adminGet("/api/admin/transcript.json?userid="+uid,
+		&data); err != nil {
+		return err
+	}
+	// Messages arrive newest first, but read best oldest first.
+	for i := len(data.Messages) - 1; i >= 0; i-- {
+		m := data.Messages[i]
+		from := "User"
+		if m.AbotSent {
+			from = "Abot"
+		}
+		fmt.Printf("[%d %s] %s> %s\n", m.ID,
+			m.CreatedAt.Format("Jan 2 15:04"), from, m.Sentence)
+		if !m.AbotSent {
+			fmt.Printf("\troute: %q, commands: %v, objects: %v\n",
+				m.Route, []string(m.Commands), []string(m.Objects))
+		}
+	}
+	return nil
+}
+
+func adminDeadLetters() error {
+	var data struct{ DeadLetters []core.DeadLetter }
+	if err := adminGet("/api/admin/dead_letters.json", &data); err != nil {
+		return err
+	}
+	if len(data.DeadLetters) == 0 {
+		fmt.Println("No dead letters.")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tID\tDEAD\tTO/FROM\tCONTENT\tERROR")
+	for _, d := range data.DeadLetters {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", d.Kind, d.ID,
+			d.DeadAt.Format("Jan 2 15:04"), d.FlexID,
+			shorten(d.Content, 40), shorten(d.Error, 40))
+	}
+	return w.Flush()
+}
+
+func adminAudit(uid string) error {
+	if _, err := strconv.ParseUint(uid, 10, 64); err != nil {
+		return errors.New("usage: abot admin audit {userID}")
+	}
+	var data struct{ Entries []core.AuditEntry }
+	if err := adminGet("/api/admin/audit.json?userid="+uid, &data); err != nil {
+		return err
+	}
+	if len(data.Entries) == 0 {
+		fmt.Println("No audit entries.")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTION\tFLEXID\tDETAIL\tSENTENCE")
+	for _, e := range data.Entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			e.CreatedAt.Format("Jan 2 15:04"), e.Action, e.FlexID,
+			shorten(e.Detail, 40), shorten(e.Sentence, 40))
+	}
+	return w.Flush()
+}
+
+func adminSetPlugin(name string, enabled bool) error {
+	if len(name) == 0 {
+		return errors.New("missing plugin name")
+	}
+	return adminRequest("PUT", "/api/admin/plugins.json", struct {
+		Name    string
+		Enabled bool
+	}{
+		Name:    name,
+		Enabled: enabled,
+	}, nil)
+}
+
+func adminMigrate() error {
+	var data struct{ Applied []string }
+	if err := adminPost("/api/admin/migrate.json", nil, &data); err != nil {
+		return err
+	}
+	if len(data.Applied) == 0 {
+		fmt.Println("No pending migrations.")
+	}
+	for _, name := range data.Applied {
+		fmt.Println("Applied", name)
+	}
+	return nil
+}
+
+// shorten cuts s to n characters for a table cell, on one line.
+func shorten(s string, n int) string {
+	s = strings.Replace(s, "\n", " ", -1)
+	rs := []rune(s)
+	if len(rs) <= n {
+		return s
+	}
+	return string(rs[:n-3]) + "..."
+}
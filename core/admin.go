@@ -0,0 +1,494 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// maxAdminResults limits how many users or messages admin endpoints return per
+// page.
+const maxAdminResults = 100
+
+// settings are runtime settings operators can change from the admin console
+// without restarting Abot, such as which plugins are enabled.
+var settings = map[string]string{}
+var settingsMu sync.RWMutex
+
+// loadSettings caches every saved setting in memory.
+func loadSettings() error {
+	var rows []struct {
+		Name  string
+		Value string
+	}
+	if err := db.Select(&rows, `SELECT name, value FROM settings`); err != nil {
+		return err
+	}
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	for _, row := range rows {
+		settings[row.Name] = row.Value
+	}
+	return nil
+}
+
+// Setting returns the value of a runtime setting, or an empty string if it
+// hasn't been set.
+func Setting(name string) string {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return settings[name]
+}
+
+// SetSetting saves a runtime setting, taking effect immediately.
+func SetSetting(name, value string) error {
+	q := `INSERT INTO settings (name, value) VALUES ($1, $2)
+	      ON CONFLICT (name) DO UPDATE SET value=$2, updatedat=CURRENT_TIMESTAMP`
+	if _, err := db.Exec(q, name, value); err != nil {
+		return err
+	}
+	settingsMu.Lock()
+	settings[name] = value
+	settingsMu.Unlock()
+	return nil
+}
+
+// PluginEnabled reports whether operators have left a plugin enabled. Disabled
+// plugins stay installed but never receive messages.
+func PluginEnabled(name string) bool {
+	return Setting("plugin_"+name+"_enabled") != "false"
+}
+
+// Retrain rebuilds the classifier from the dictionaries in data/ner, picking
+// up any words trainers have added without restarting Abot. The model of the
+// classifier driver named by ABOT_CLASSIFIER, if any, is reloaded, too.
+func Retrain() error {
+	c, err := buildClassifier()
+	if err != nil {
+		return err
+	}
+	nerMu.Lock()
+	ner = c
+	nerMu.Unlock()
+	if err = reloadClassifierDriver(); err != nil {
+		return err
+	}
+	log.Info("retrained classifier")
+	return nil
+}
+
+// AdminUser summarizes a user for the admin console.
+type AdminUser struct {
+	ID        uint64
+	Name      string
+	Email     string
+	Admin     bool
+	Trainer   bool
+	Roles     nlp.StringSlice
+	CreatedAt time.Time
+}
+
+// TranscriptMsg is a message in a transcript along with how it was
+// classified and routed.
+type TranscriptMsg struct {
+	ID            uint64
+	Sentence      string
+	AbotSent      bool
+	Commands      nlp.StringSlice
+	Objects       nlp.StringSlice
+	Plugin        string
+	Route         string
+	NeedsTraining bool
+	CreatedAt     time.Time
+}
+
+// adminGuard ensures the request comes from a logged in admin, also checking
+// its CSRF token if it changes anything.
+func adminGuard(w http.ResponseWriter, r *http.Request, csrf bool) bool {
+	if os.Getenv("ABOT_ENV") == "test" {
+		return true
+	}
+	if !Admin(w, r) {
+		return false
+	}
+	if !LoggedIn(w, r) {
+		return false
+	}
+	if csrf && !CSRF(w, r) {
+		return false
+	}
+	return true
+}
+
+// pageParams parses the offset and limit query parameters, limiting pages to
+// maxAdminResults.
+func pageParams(r *http.Request) (offset, limit int, err error) {
+	v := r.URL.Query()
+	limit = maxAdminResults
+	if s := v.Get("limit"); len(s) > 0 {
+		if limit, err = strconv.Atoi(s); err != nil {
+			return 0, 0, err
+		}
+		if limit <= 0 || limit > maxAdminResults {
+			limit = maxAdminResults
+		}
+	}
+	if s := v.Get("offset"); len(s) > 0 {
+		if offset, err = strconv.Atoi(s); err != nil {
+			return 0, 0, err
+		}
+	}
+	return offset, limit, nil
+}
+
+// HAPIUsers lists users newest first. The q query parameter searches names,
+// emails and phone numbers, and tag limits results to users with a tag or in
+// a segment.
+func HAPIUsers(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	offset, limit, err := pageParams(r)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	v := r.URL.Query()
+	var uids []int64
+	if tag := v.Get("tag"); len(tag) > 0 {
+		tagged, err := UsersTagged(tag)
+		if err != nil {
+			writeErrorInternal(w, err)
+			return
+		}
+		for _, uid := range tagged {
+			uids = append(uids, int64(uid))
+		}
+		if len(uids) == 0 {
+			writeBytes(w, struct{ Users []AdminUser }{})
+			return
+		}
+	}
+	search := "%" + v.Get("q") + "%"
+	q := `SELECT id, name, email, admin, trainer, createdat FROM users
+	      WHERE (name ILIKE $1 OR email ILIKE $1 OR id IN (
+	          SELECT userid FROM userflexids WHERE flexid ILIKE $1))
+	        AND ($2::text IS NULL OR id=ANY($2::integer[]))
+	      ORDER BY createdat DESC
+	      OFFSET $3 LIMIT $4`
+	var filter interface{}
+	if uids != nil {
+		filter = intArray(uids)
+	}
+	var us []AdminUser
+	if err = db.Select(&us, q, search, filter, offset, limit); err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Users []AdminUser }{Users: us})
+}
+
+// HAPIUser responds with a user's details, contact information and tags.
+func HAPIUser(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	uid, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	var resp struct {
+		User    AdminUser
+		FlexIDs []struct {
+			FlexID     string
+			FlexIDType dt.FlexIDType
+		}
+		Tags []string
+	}
+	q := `SELECT id, name, email, admin, trainer, roles, createdat FROM users
+	      WHERE id=$1`
+	if err = db.Get(&resp.User, q, uid); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	q = `SELECT flexid, flexidtype FROM userflexids WHERE userid=$1`
+	if err = db.Select(&resp.FlexIDs, q, uid); err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	u := &dt.User{ID: uid}
+	if resp.Tags, err = u.Tags(db); err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, resp)
+}
+
+// HAPIUserFlexIDsSubmit links a FlexID, like a Slack user ID, to a user, so
+// their conversations on each channel are one. A FlexID already linked to
+// another user, like the account created when they first texted Abot, is moved
+// to this one. Pass Remove to unlink it instead.
+func HAPIUserFlexIDsSubmit(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !Admin(w, r) {
+			return
+		}
+		if !LoggedIn(w, r) {
+			return
+		}
+		if !CSRF(w, r) {
+			return
+		}
+	}
+	var req struct {
+		UserID     uint64
+		FlexID     string
+		FlexIDType dt.FlexIDType
+		Remove     bool
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	u := &dt.User{ID: req.UserID}
+	var err error
+	if req.Remove {
+		err = u.UnlinkFlexID(db, req.FlexID, req.FlexIDType)
+	} else {
+		err = u.LinkFlexID(db, req.FlexID, req.FlexIDType)
+	}
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusOK)
+	case dt.ErrMissingFlexID, dt.ErrInvalidFlexIDType, dt.ErrUnknownFlexID:
+		writeErrorBadRequest(w, err)
+	default:
+		writeErrorInternal(w, err)
+	}
+}
+
+// HAPIUserRolesSubmit replaces a user's roles, granting them the plugins
+// restricted to those roles. See dt.RequireRoles.
+func HAPIUserRolesSubmit(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !Admin(w, r) {
+			return
+		}
+		if !LoggedIn(w, r) {
+			return
+		}
+		if !CSRF(w, r) {
+			return
+		}
+	}
+	var req struct {
+		UserID uint64
+		Roles  []string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	u := &dt.User{ID: req.UserID}
+	if err := u.SetRoles(db, req.Roles); err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	Audit(AuditEntry{
+		UserID: u.ID,
+		Action: AuditRoles,
+		Detail: strings.Join(u.Roles, ", "),
+	})
+	w.WriteHeader(http.StatusOK)
+}
+
+// HAPIUsersMerge merges a duplicate user into a primary one, such as when
+// someone who texted Abot later emailed it. See MergeUsers.
+func HAPIUsersMerge(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !Admin(w, r) {
+			return
+		}
+		if !LoggedIn(w, r) {
+			return
+		}
+		if !CSRF(w, r) {
+			return
+		}
+	}
+	var req struct {
+		PrimaryID   uint64
+		DuplicateID uint64
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := MergeUsers(req.PrimaryID, req.DuplicateID)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusOK)
+	case ErrSameUser, sql.ErrNoRows:
+		writeErrorBadRequest(w, err)
+	default:
+		writeErrorInternal(w, err)
+	}
+}
+
+// HAPITranscript responds with a user's messages newest first, along with how
+// each was classified and routed. Pass the ID of the oldest message seen as
+// the before query parameter to page back through the conversation.
+func HAPITranscript(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	v := r.URL.Query()
+	uid, err := strconv.ParseUint(v.Get("userid"), 10, 64)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	var before uint64
+	if s := v.Get("before"); len(s) > 0 {
+		if before, err = strconv.ParseUint(s, 10, 64); err != nil {
+			writeErrorBadRequest(w, err)
+			return
+		}
+	}
+	_, limit, err := pageParams(r)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	q := `SELECT id, COALESCE(sentence, '') AS sentence, abotsent,
+	          COALESCE(commands, '{}') AS commands,
+	          COALESCE(objects, '{}') AS objects,
+	          COALESCE(plugin, '') AS plugin, COALESCE(route, '') AS route,
+	          COALESCE(needstraining, FALSE) AS needstraining, createdat
+	      FROM messages
+	      WHERE userid=$1 AND ($2=0 OR id<$2)
+	      ORDER BY id DESC
+	      LIMIT $3`
+	var msgs []TranscriptMsg
+	if err = db.Select(&msgs, q, uid, before, limit); err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	for i := range msgs {
+		msgs[i].Sentence = openSentence(msgs[i].Sentence)
+	}
+	writeBytes(w, struct{ Messages []TranscriptMsg }{Messages: msgs})
+}
+
+// HAPISettings responds with every runtime setting.
+func HAPISettings(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	writeBytes(w, settings)
+}
+
+// HAPISettingsSubmit saves a runtime setting.
+func HAPISettingsSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct {
+		Name  string
+		Value string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if len(req.Name) == 0 {
+		writeErrorBadRequest(w, errors.New("missing setting name"))
+		return
+	}
+	if err := SetSetting(req.Name, req.Value); err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HAPIPluginsSubmit enables or disables an installed plugin by name.
+func HAPIPluginsSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct {
+		Name    string
+		Enabled bool
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	var found bool
+	for _, p := range AllPlugins {
+		if p.Config.Name == req.Name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		writeErrorBadRequest(w, errors.New("unknown plugin "+req.Name))
+		return
+	}
+	err := SetSetting("plugin_"+req.Name+"_enabled",
+		strconv.FormatBool(req.Enabled))
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HAPIRetrain rebuilds the classifier.
+func HAPIRetrain(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	if err := Retrain(); err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// operatorName identifies the operator making a request in records of their
+// changes, e.g. "operator 12".
+func operatorName(r *http.Request) string {
+	if cookie, err := r.Cookie("id"); err == nil {
+		if _, err = strconv.ParseUint(cookie.Value, 10, 64); err == nil {
+			return "operator " + cookie.Value
+		}
+	}
+	return "operator"
+}
+
+// intArray writes IDs as a Postgres array.
+func intArray(ids []int64) string {
+	s := "{"
+	for i, id := range ids {
+		if i > 0 {
+			s += ","
+		}
+		s += strconv.FormatInt(id, 10)
+	}
+	return s + "}"
+}
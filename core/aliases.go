@@ -0,0 +1,114 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// maxAliasLen is the longest an alias's expansion may be.
+const maxAliasLen = 255
+
+var (
+	regexAliasDefine = regexp.MustCompile(`(?i)^\s*(?:` +
+		`["'“‘]([^"'“”‘’]{1,40})["'”’]\s+(?:means|is short for)|` +
+		`when i say\s+["'“‘]?([^"'“”‘’,]{1,40}?)["'”’]?,?\s+i mean)` +
+		`\s+(.+?)\s*\.?\s*$`)
+	regexAliasList   = regexp.MustCompile(`(?i)^\s*(?:(?:list|show)(?: me)?(?: all)? my|what are my)\s+(?:shortcuts|aliases)\s*[.!?]*\s*$`)
+	regexAliasDelete = regexp.MustCompile(`(?i)^\s*(?:delete|remove|forget)\s+(?:the\s+|my\s+)?(?:shortcut|alias)\s+["'“‘]?([^"'“”‘’]{1,40}?)["'”’]?\s*[.!]*\s*$`)
+)
+
+// aliasStage expands the user's aliases in their sentence, so "order the
+// usual" becomes "order a large pepperoni to my home address." Messages
+// defining, listing or deleting aliases are left alone.
+func aliasStage(in *dt.Msg) error {
+	if in.User == nil || !in.User.Registered() || isAliasCommand(in.Sentence) {
+		return nil
+	}
+	as, err := in.User.Aliases(db)
+	if err != nil {
+		// Aliases are a convenience, so don't stop the message.
+		log.Info("failed to get aliases", err)
+		return nil
+	}
+	in.Sentence = expandAliases(in.Sentence, as)
+	return nil
+}
+
+// expandAliases replaces each alias mentioned in a sentence, including any
+// "the" or "my" before it, with its expansion.
+func expandAliases(s string, as []dt.Alias) string {
+	for _, a := range as {
+		re, err := regexp.Compile(`(?i)\b(?:the\s+|my\s+)?` +
+			regexp.QuoteMeta(a.Name) + `\b`)
+		if err != nil {
+			log.Info("invalid alias", a.Name, err)
+			continue
+		}
+		s = re.ReplaceAllLiteralString(s, a.Expansion)
+	}
+	return s
+}
+
+func isAliasCommand(s string) bool {
+	return regexAliasDefine.MatchString(s) ||
+		regexAliasList.MatchString(s) || regexAliasDelete.MatchString(s)
+}
+
+// manageAliases answers requests to define, list and delete the user's
+// aliases, e.g. "'usual' means a large pepperoni to my home address," "list
+// my shortcuts" and "delete shortcut usual." An empty string is returned if
+// the message isn't such a request.
+func manageAliases(msg *dt.Msg) string {
+	if !msg.User.Registered() {
+		return ""
+	}
+	u := msg.User
+	if m := regexAliasDefine.FindStringSubmatch(msg.Sentence); m != nil {
+		name := strings.ToLower(strings.TrimSpace(m[1] + m[2]))
+		if len(m[3]) > maxAliasLen {
+			return "I'm sorry, that's too long for a shortcut."
+		}
+		if err := u.SetAlias(db, name, m[3]); err != nil {
+			log.Info("failed to set alias", err)
+			return "I'm sorry, I couldn't save that shortcut right now."
+		}
+		auditMsg(msg, AuditPreference, "set alias "+name)
+		return fmt.Sprintf("Got it. When you say %q, I'll take it to mean %q.",
+			name, m[3])
+	}
+	if regexAliasList.MatchString(msg.Sentence) {
+		as, err := u.Aliases(db)
+		if err != nil {
+			log.Info("failed to get aliases", err)
+			return "I'm sorry, I couldn't find your shortcuts right now."
+		}
+		if len(as) == 0 {
+			return `You don't have any shortcuts yet. To add one, say something like "'usual' means a large pepperoni."`
+		}
+		lines := []string{"Your shortcuts:"}
+		for _, a := range as {
+			lines = append(lines, fmt.Sprintf("%q means %q", a.Name,
+				a.Expansion))
+		}
+		return strings.Join(lines, "\n")
+	}
+	if m := regexAliasDelete.FindStringSubmatch(msg.Sentence); m != nil {
+		name := strings.ToLower(strings.TrimSpace(m[1]))
+		err := u.DeleteAlias(db, name)
+		if err == dt.ErrNoAlias {
+			return fmt.Sprintf("You don't have a shortcut called %q.",
+				name)
+		}
+		if err != nil {
+			log.Info("failed to delete alias", err)
+			return "I'm sorry, I couldn't delete that shortcut right now."
+		}
+		auditMsg(msg, AuditPreference, "deleted alias "+name)
+		return fmt.Sprintf("OK, I've forgotten %q.", name)
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/itsabot/abot/core/log"
+)
+
+// metric computes a value for Abot's analytics, such as a plugin's average
+// rating. Values must be marshalable to JSON.
+type metric func() (interface{}, error)
+
+var metrics = map[string]metric{}
+var metricsMu sync.RWMutex
+
+// RegisterMetric makes a metric available in Abot's analytics under a name,
+// which should be prefixed by the plugin's name to avoid collisions, e.g.
+// "survey_nps". Registering a name twice replaces the earlier metric.
+func RegisterMetric(name string, fn func() (interface{}, error)) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metrics[name] = fn
+}
+
+// Metrics computes every registered metric. Metrics that fail are logged and
+// left out.
+func Metrics() map[string]interface{} {
+	metricsMu.RLock()
+	defer metricsMu.RUnlock()
+	var names []string
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	vals := map[string]interface{}{}
+	for _, name := range names {
+		v, err := metrics[name]()
+		if err != nil {
+			log.Info("failed to compute metric", name, err)
+			continue
+		}
+		vals[name] = v
+	}
+	return vals
+}
+
+// HAPIAnalytics returns the values of all registered metrics.
+func HAPIAnalytics(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !Admin(w, r) {
+			return
+		}
+		if !LoggedIn(w, r) {
+			return
+		}
+	}
+	writeBytes(w, Metrics())
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/interface/annotation"
+	"github.com/itsabot/abot/shared/interface/annotation/driver"
+)
+
+// annotationBatch is the most messages pushed to the annotation tool each
+// time the job runs.
+const annotationBatch = 100
+
+var annotationConn *annotation.Conn
+
+// ErrMissingAnnotationDriver is returned when receiving annotations without an
+// annotation driver imported.
+var ErrMissingAnnotationDriver = errors.New("no annotation driver imported")
+
+func init() {
+	RegisterJob("annotation_push", 10*time.Minute, pushFallbacks)
+	RegisterMetric("annotations", func() (interface{}, error) {
+		var s struct {
+			Pushed    int
+			Annotated int
+		}
+		q := `SELECT COUNT(*) AS pushed, COUNT(annotatedat) AS annotated
+		      FROM annotationtasks
+		      WHERE pushedat>$1`
+		err := db.Get(&s, q, time.Now().AddDate(0, 0, -30))
+		return s, err
+	})
+}
+
+// pushFallbacks sends messages Abot didn't understand to the annotation tool,
+// so people can label them with the plugin that should have handled them.
+// Each message is pushed once. Messages labeled through the admin panel or
+// corrected by their users first leave the fallback queue and aren't pushed.
+func pushFallbacks() error {
+	if annotationConn == nil {
+		return nil
+	}
+	q := `SELECT m.id, COALESCE(m.sentence, '') AS sentence
+	      FROM messages AS m
+	      WHERE m.needstraining AND NOT EXISTS (
+	          SELECT 1 FROM classificationchecks AS cc
+	          WHERE cc.messageid=m.id AND cc.source IN ($1, $2))
+	      AND NOT EXISTS (
+	          SELECT 1 FROM annotationtasks AS at
+	          WHERE at.messageid=m.id)
+	      ORDER BY m.id
+	      LIMIT $3`
+	var msgs []struct {
+		ID       uint64
+		Sentence string
+	}
+	err := db.Select(&msgs, q, CheckFallback, CheckCorrection,
+		annotationBatch)
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	for i := range msgs {
+		msgs[i].Sentence = openSentence(msgs[i].Sentence)
+	}
+	choices := annotationChoices()
+	tasks := make([]driver.Task, len(msgs))
+	for i, m := range msgs {
+		tasks[i] = driver.Task{
+			MessageID: m.ID,
+			Text:      m.Sentence,
+			Choices:   choices,
+		}
+	}
+	if err := annotationConn.Push(tasks); err != nil {
+		return err
+	}
+	drv := annotation.Drivers()[0]
+	q = `INSERT INTO annotationtasks (messageid, drivername) VALUES ($1, $2)`
+	for _, m := range msgs {
+		if _, err := db.Exec(q, m.ID, drv); err != nil {
+			log.Info("failed to save annotation task", m.ID, err)
+		}
+	}
+	return nil
+}
+
+// annotationChoices returns the names of the enabled plugins a message may be
+// labeled with, sorted, followed by driver.NoPlugin.
+func annotationChoices() []string {
+	var names []string
+	for _, p := range AllPlugins {
+		if PluginEnabled(p.Config.Name) {
+			names = append(names, p.Config.Name)
+		}
+	}
+	sort.Strings(names)
+	return append(names, driver.NoPlugin)
+}
+
+// HAPIAnnotationWebhook receives labels completed in the annotation tool and
+// adds them to the training corpus, as though labeled through the admin
+// panel. The annotation driver is responsible for verifying that the request
+// came from the annotation tool.
+func HAPIAnnotationWebhook(w http.ResponseWriter, r *http.Request) {
+	if annotationConn == nil {
+		writeErrorBadRequest(w, ErrMissingAnnotationDriver)
+		return
+	}
+	as, err := annotationConn.ParseAnnotations(r)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	for _, a := range as {
+		err = RecordClassificationCheck(a.MessageID, "", a.Plugin,
+			CheckFallback, a.Annotator)
+		if err != nil {
+			writeErrorInternal(w, err)
+			return
+		}
+		q := `UPDATE annotationtasks SET annotatedat=CURRENT_TIMESTAMP
+		      WHERE messageid=$1`
+		if _, err = db.Exec(q, a.MessageID); err != nil {
+			log.Info("failed to mark annotation task", a.MessageID, err)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,316 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// anomalyInterval is how often conversation metrics are sampled and checked
+// against their baselines.
+const anomalyInterval = time.Hour
+
+// anomalyBaselineWindow is how far back samples make up a metric's baseline.
+const anomalyBaselineWindow = 7 * 24 * time.Hour
+
+// minAnomalySamples is the fewest samples in a metric's baseline needed to
+// check it, so a new deploy doesn't alert about its first quiet hours.
+const minAnomalySamples = 24
+
+// minFallbackRateMessages is the fewest messages in an interval needed to
+// sample the fallback rate, so a single misunderstood message at night isn't
+// taken as a spike.
+const minFallbackRateMessages = 20
+
+// defaultAnomalySigma is how many standard deviations from its baseline a
+// metric must move before operators are alerted. Operators can change it with
+// the anomaly_alert_sigma setting, e.g. "4."
+const defaultAnomalySigma = 3.0
+
+// AnomalyMetric is a conversation metric watched for sharp changes, like the
+// share of messages Abot didn't understand jumping after a broken model is
+// deployed.
+type AnomalyMetric struct {
+	// Sample returns the metric's values over a period by series, e.g.
+	// the messages received through each channel. Series without a value
+	// are taken as 0. A nil map skips the period, e.g. when too few
+	// messages were received to compute a rate.
+	Sample func(from, to time.Time) (map[string]float64, error)
+
+	// MinChange is the least change from the baseline worth an alert,
+	// so a metric that's always 0 doesn't alert at its first blip.
+	MinChange float64
+}
+
+// Anomaly is a metric that moved sharply from its baseline.
+type Anomaly struct {
+	Series   string
+	Value    float64
+	Baseline float64
+	StdDev   float64
+}
+
+var anomalyMetrics = map[string]AnomalyMetric{}
+var anomalyMetricsMu sync.RWMutex
+
+// anomalyClient sends alerts to ABOT_ALERT_WEBHOOK.
+var anomalyClient = &http.Client{Timeout: 10 * time.Second}
+
+func init() {
+	RegisterJob("anomaly_detection", anomalyInterval, checkAnomalies)
+	RegisterAnomalyMetric("fallback_rate", AnomalyMetric{
+		Sample:    fallbackRate,
+		MinChange: 0.1,
+	})
+	RegisterAnomalyMetric("message_volume", AnomalyMetric{
+		Sample:    messageVolume,
+		MinChange: 20,
+	})
+	RegisterAnomalyMetric("outbound_failures", AnomalyMetric{
+		Sample: func(from, to time.Time) (map[string]float64, error) {
+			return outboundFailures.take(), nil
+		},
+		MinChange: 5,
+	})
+}
+
+// RegisterAnomalyMetric watches a metric for sharp changes, alerting
+// ADMIN_EMAIL and ABOT_ALERT_WEBHOOK, e.g. a plugin's failed bookings. Each
+// series is named after the metric, like "message_volume/sms."
+func RegisterAnomalyMetric(name string, m AnomalyMetric) {
+	anomalyMetricsMu.Lock()
+	defer anomalyMetricsMu.Unlock()
+	anomalyMetrics[name] = m
+}
+
+// checkAnomalies samples each metric over the past interval, alerting
+// operators of series far from their baselines, then saves the samples as
+// part of future baselines.
+func checkAnomalies() error {
+	now := time.Now()
+	from := now.Add(-anomalyInterval)
+	sigma := anomalySigma()
+	anomalyMetricsMu.RLock()
+	defer anomalyMetricsMu.RUnlock()
+	var anomalies []Anomaly
+	for name, m := range anomalyMetrics {
+		vals, err := m.Sample(from, now)
+		if err != nil {
+			log.Info("failed to sample metric", name, err)
+			continue
+		}
+		if vals == nil {
+			continue
+		}
+		series, err := knownSeries(name)
+		if err != nil {
+			return err
+		}
+		for s := range vals {
+			series[name+"/"+s] = struct{}{}
+		}
+		for s := range series {
+			v := vals[s[len(name)+1:]]
+			a, err := checkSeries(s, v, m.MinChange, sigma, now)
+			if err != nil {
+				return err
+			}
+			if a != nil {
+				anomalies = append(anomalies, *a)
+			}
+			q := `INSERT INTO metricsamples (name, value) VALUES ($1, $2)`
+			if _, err = db.Exec(q, s, v); err != nil {
+				return err
+			}
+		}
+	}
+	if len(anomalies) == 0 {
+		return nil
+	}
+	sort.Sort(anomaliesBySeries(anomalies))
+	return alertAnomalies(anomalies)
+}
+
+// knownSeries returns the series of a metric sampled within the baseline
+// window, so a series that stops altogether, like a channel going quiet, is
+// still checked.
+func knownSeries(name string) (map[string]struct{}, error) {
+	q := `SELECT DISTINCT name FROM metricsamples
+	      WHERE LEFT(name, LENGTH($1))=$1 AND createdat>$2`
+	var names []string
+	err := db.Select(&names, q, name+"/",
+		time.Now().Add(-anomalyBaselineWindow))
+	if err != nil {
+		return nil, err
+	}
+	series := map[string]struct{}{}
+	for _, n := range names {
+		series[n] = struct{}{}
+	}
+	return series, nil
+}
+
+// checkSeries compares a series' value with its baseline, returning an
+// Anomaly if it's more than sigma standard deviations and MinChange away.
+func checkSeries(series string, v, minChange, sigma float64,
+	now time.Time) (*Anomaly, error) {
+
+	var samples []float64
+	q := `SELECT value FROM metricsamples
+	      WHERE name=$1 AND createdat>$2`
+	err := db.Select(&samples, q, series, now.Add(-anomalyBaselineWindow))
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) < minAnomalySamples {
+		return nil, nil
+	}
+	mean, std := meanStdDev(samples)
+	diff := math.Abs(v - mean)
+	if diff < minChange || diff <= sigma*std {
+		return nil, nil
+	}
+	return &Anomaly{Series: series, Value: v, Baseline: mean, StdDev: std},
+		nil
+}
+
+// anomalySigma returns the anomaly_alert_sigma setting, or
+// defaultAnomalySigma. Unlike rates read with settingFloat, it may exceed 1.
+func anomalySigma() float64 {
+	s := Setting("anomaly_alert_sigma")
+	if len(s) == 0 {
+		return defaultAnomalySigma
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 {
+		log.Info("invalid setting", "anomaly_alert_sigma", s)
+		return defaultAnomalySigma
+	}
+	return f
+}
+
+func meanStdDev(xs []float64) (float64, float64) {
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	mean := sum / float64(len(xs))
+	var sq float64
+	for _, x := range xs {
+		sq += (x - mean) * (x - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(xs)))
+}
+
+// alertAnomalies notifies ADMIN_EMAIL of anomalies and POSTs them to
+// ABOT_ALERT_WEBHOOK, if set. The webhook's text field is shown by Slack's
+// incoming webhooks.
+func alertAnomalies(as []Anomaly) error {
+	var content string
+	for _, a := range as {
+		content += fmt.Sprintf("%s: %.2f this hour, usually %.2f (±%.2f)\n",
+			a.Series, a.Value, a.Baseline, a.StdDev)
+	}
+	if err := notifyAdmin("Unusual activity detected", content); err != nil {
+		log.Info("failed to email anomalies", err)
+	}
+	webhook := os.Getenv("ABOT_ALERT_WEBHOOK")
+	if len(webhook) == 0 {
+		return nil
+	}
+	byt, err := json.Marshal(struct {
+		Text      string `json:"text"`
+		Anomalies []Anomaly
+	}{Text: "Unusual activity detected\n" + content, Anomalies: as})
+	if err != nil {
+		return err
+	}
+	resp, err := anomalyClient.Post(webhook, "application/json",
+		bytes.NewBuffer(byt))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// fallbackRate is the share of users' messages Abot didn't understand.
+func fallbackRate(from, to time.Time) (map[string]float64, error) {
+	var r struct {
+		Total    int
+		Fallback int
+	}
+	q := `SELECT COUNT(*) AS total,
+	          COUNT(*) FILTER (WHERE needstraining) AS fallback
+	      FROM messages
+	      WHERE abotsent IS FALSE AND createdat>=$1 AND createdat<$2`
+	if err := db.Get(&r, q, from, to); err != nil {
+		return nil, err
+	}
+	if r.Total < minFallbackRateMessages {
+		return nil, nil
+	}
+	return map[string]float64{
+		"all": float64(r.Fallback) / float64(r.Total),
+	}, nil
+}
+
+// messageVolume is the number of messages users sent through each channel.
+func messageVolume(from, to time.Time) (map[string]float64, error) {
+	var rows []struct {
+		FlexIDType dt.FlexIDType
+		Count      int
+	}
+	q := `SELECT COALESCE(flexidtype, 0) AS flexidtype, COUNT(*) AS count
+	      FROM messages
+	      WHERE abotsent IS FALSE AND createdat>=$1 AND createdat<$2
+	      GROUP BY flexidtype`
+	if err := db.Select(&rows, q, from, to); err != nil {
+		return nil, err
+	}
+	vals := map[string]float64{}
+	for _, r := range rows {
+		vals[dt.ChannelName(r.FlexIDType)] += float64(r.Count)
+	}
+	return vals, nil
+}
+
+// failureCounts counts messages that failed to send through each channel
+// since they were last taken.
+type failureCounts struct {
+	mu     sync.Mutex
+	counts map[string]float64
+}
+
+var outboundFailures = &failureCounts{counts: map[string]float64{}}
+
+// add counts a message that failed to send through a channel.
+func (f *failureCounts) add(channel string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counts[channel]++
+}
+
+// take returns the counts, resetting them.
+func (f *failureCounts) take() map[string]float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := f.counts
+	f.counts = map[string]float64{}
+	return counts
+}
+
+type anomaliesBySeries []Anomaly
+
+func (a anomaliesBySeries) Len() int           { return len(a) }
+func (a anomaliesBySeries) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a anomaliesBySeries) Less(i, j int) bool { return a[i].Series < a[j].Series }
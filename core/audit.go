@@ -0,0 +1,145 @@
+package core
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditLogin           = "login"
+	AuditLoginFailed     = "login_failed"
+	AuditChallengePassed = "challenge_passed"
+	AuditChallengeFailed = "challenge_failed"
+	AuditTOTPEnrolled    = "totp_enrolled"
+	AuditTOTPDisabled    = "totp_disabled"
+	AuditPurchase        = "purchase"
+	AuditRefund          = "refund"
+	AuditPreference      = "preference"
+	AuditRoles           = "roles"
+	AuditPlugin          = "plugin"
+	AuditDeletion        = "deletion"
+)
+
+// AuditEntry records a sensitive action taken by or for a user, like a
+// purchase, with the sentence that led to it, so disputes like "the bot
+// ordered the wrong thing" can be traced to what the user said.
+type AuditEntry struct {
+	ID         uint64
+	UserID     uint64
+	FlexID     string
+	FlexIDType dt.FlexIDType
+	Action     string
+
+	// Detail describes the action, e.g. the plugin invoked or the amount
+	// charged.
+	Detail string
+
+	// Sentence is what the user said that led to the action, if anything.
+	Sentence  string
+	CreatedAt time.Time
+}
+
+// Audit records an entry in the audit log. Sentences are sealed like those of
+// messages. Failures are logged rather than returned, since auditing mustn't
+// stop the action.
+func Audit(e AuditEntry) {
+	sentence, err := dt.SealSentence(e.Sentence)
+	if err != nil {
+		log.Info("failed to seal audit sentence", err)
+		sentence = ""
+	}
+	q := `INSERT INTO auditentries
+	      (userid, flexid, flexidtype, action, detail, sentence)
+	      VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = db.Exec(q, e.UserID, e.FlexID, e.FlexIDType, e.Action, e.Detail,
+		sentence)
+	if err != nil {
+		log.Info("failed to record audit entry", e.Action, err)
+	}
+}
+
+// auditMsg records an action taken in response to a user's message.
+func auditMsg(in *dt.Msg, action, detail string) {
+	e := AuditEntry{Action: action, Detail: detail, Sentence: in.Sentence}
+	if in.User != nil {
+		e.UserID = in.User.ID
+		e.FlexID, e.FlexIDType = in.User.FlexID, in.User.FlexIDType
+	}
+	Audit(e)
+}
+
+// auditUser records an action taken for a user outside of a message, like a
+// charge made by a plugin, with the user's most recent message as its
+// sentence.
+func auditUser(u *dt.User, action, detail string) {
+	e := AuditEntry{
+		UserID:     u.ID,
+		FlexID:     u.FlexID,
+		FlexIDType: u.FlexIDType,
+		Action:     action,
+		Detail:     detail,
+	}
+	q := `SELECT sentence FROM messages
+	      WHERE userid=$1 AND abotsent IS FALSE
+	      ORDER BY createdat DESC
+	      LIMIT 1`
+	var sentence sql.NullString
+	if err := db.Get(&sentence, q, u.ID); err != nil &&
+		err != sql.ErrNoRows {
+
+		log.Info("failed to get sentence for audit", err)
+	}
+	e.Sentence = openSentence(sentence.String)
+	Audit(e)
+}
+
+// AuditEntriesForUser returns a page of a user's audit entries, most recent
+// first.
+func AuditEntriesForUser(uid uint64, offset, limit int) ([]AuditEntry,
+	error) {
+
+	q := `SELECT id, userid, flexid, flexidtype, action, detail, sentence,
+	          createdat
+	      FROM auditentries
+	      WHERE userid=$1
+	      ORDER BY createdat DESC, id DESC
+	      OFFSET $2 LIMIT $3`
+	var es []AuditEntry
+	if err := db.Select(&es, q, uid, offset, limit); err != nil {
+		return nil, err
+	}
+	for i := range es {
+		es[i].Sentence = openSentence(es[i].Sentence)
+	}
+	return es, nil
+}
+
+// HAPIAuditEntries responds with a page of a user's audit entries, most
+// recent first.
+func HAPIAuditEntries(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	uid, err := strconv.ParseUint(r.URL.Query().Get("userid"), 10, 64)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	offset, limit, err := pageParams(r)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	es, err := AuditEntriesForUser(uid, offset, limit)
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Entries []AuditEntry }{Entries: es})
+}
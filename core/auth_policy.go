@@ -0,0 +1,80 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// pluginAuthMaxAges are the stricter windows plugins demanded with
+// RequireAuthWithin, by action.
+var pluginAuthMaxAges = map[string]time.Duration{}
+var pluginAuthMaxAgesMu sync.RWMutex
+
+// RequireAuthWithin demands that users authenticated within d before an
+// action, like a plugin's name or dt.ActionPurchase. Operators may make the
+// window shorter still with the auth_action_max_age_hours setting, but not
+// longer, so a plugin handling addresses can't be loosened by accident.
+func RequireAuthWithin(action string, d time.Duration) {
+	pluginAuthMaxAgesMu.Lock()
+	defer pluginAuthMaxAgesMu.Unlock()
+	if cur, ok := pluginAuthMaxAges[action]; !ok || d < cur {
+		pluginAuthMaxAges[action] = d
+	}
+}
+
+// AuthPolicy returns the policy users' authentication is checked against. It's
+// built from these settings, so operators can change it without redeploying:
+//
+//	auth_max_age_hours: hours, or ABOT_REQUIRE_AUTH_IN_HOURS if unset
+//	auth_action_max_age_hours: JSON of actions to hours, e.g. {"purchase": 1}
+//	auth_require_for_purchases: "true" to check before charging users
+//
+// Windows demanded by plugins with RequireAuthWithin apply where they're
+// stricter. Invalid settings are logged and ignored.
+func AuthPolicy() *dt.AuthPolicy {
+	p := &dt.AuthPolicy{
+		MaxAge:              dt.DefaultAuthMaxAge,
+		PerActionOverrides:  map[string]time.Duration{},
+		RequireForPurchases: Setting("auth_require_for_purchases") == "true",
+	}
+	s := Setting("auth_max_age_hours")
+	if len(s) == 0 {
+		s = os.Getenv("ABOT_REQUIRE_AUTH_IN_HOURS")
+	}
+	if len(s) > 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			log.Info("invalid auth max age", s)
+		} else {
+			p.MaxAge = time.Duration(n) * time.Hour
+		}
+	}
+	if s = Setting("auth_action_max_age_hours"); len(s) > 0 {
+		var hours map[string]float64
+		if err := json.Unmarshal([]byte(s), &hours); err != nil {
+			log.Info("invalid auth action max ages", err)
+		}
+		for action, h := range hours {
+			if h < 0 {
+				log.Info("invalid auth max age for", action, h)
+				continue
+			}
+			p.PerActionOverrides[action] = time.Duration(h *
+				float64(time.Hour))
+		}
+	}
+	pluginAuthMaxAgesMu.RLock()
+	defer pluginAuthMaxAgesMu.RUnlock()
+	for action, d := range pluginAuthMaxAges {
+		if d < p.MaxAgeFor(action) {
+			p.PerActionOverrides[action] = d
+		}
+	}
+	return p
+}
@@ -12,31 +12,59 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/itsabot/abot/core/log"
 	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/helpers/holidays"
+	"github.com/itsabot/abot/shared/interface/annotation"
+	"github.com/itsabot/abot/shared/interface/directions"
+	"github.com/itsabot/abot/shared/interface/emailsender"
+	"github.com/itsabot/abot/shared/interface/embedding"
+	"github.com/itsabot/abot/shared/interface/ocr"
+	"github.com/itsabot/abot/shared/interface/payment"
+	"github.com/itsabot/abot/shared/interface/places"
+	"github.com/itsabot/abot/shared/interface/ride"
 	"github.com/itsabot/abot/shared/interface/sms"
+	"github.com/itsabot/abot/shared/interface/translate"
 	"github.com/jmoiron/sqlx"
 	"github.com/julienschmidt/httprouter"
 	_ "github.com/lib/pq" // Postgres driver
 )
 
 var db *sqlx.DB
-var ner Classifier
+var ner Dictionary
+var nerMu sync.RWMutex
 var offensive map[string]struct{}
 var smsConn *sms.Conn
+var emailConn *emailsender.Conn
+var rideConn *ride.Conn
 
 // DB returns a connection to the database.
 func DB() *sqlx.DB {
 	return db
 }
 
-// NER returns the classifiers used for named entity recognition.
-func NER() Classifier {
+// NER returns the dictionaries used for named entity recognition.
+func NER() Dictionary {
+	nerMu.RLock()
+	defer nerMu.RUnlock()
 	return ner
 }
 
+// Ride returns a connection to the ride service, or nil if no ride driver has
+// been imported.
+func Ride() *ride.Conn {
+	return rideConn
+}
+
+// Email returns a connection to the email service, or nil if no email driver
+// has been imported.
+func Email() *emailsender.Conn {
+	return emailConn
+}
+
 // Offensive returns a map of offensive words that Abot should ignore.
 func Offensive() map[string]struct{} {
 	return offensive
@@ -80,10 +108,46 @@ func NewServer() (r *httprouter.Router, err error) {
 	if err != nil {
 		log.Debug("could not build classifier", err)
 	}
+	if err = openClassifier(); err != nil {
+		log.Info("failed to open classifier, using dictionaries", err)
+	}
+	reloadOnHangup()
+	if err = loadSettings(); err != nil {
+		log.Info("failed to load settings", err)
+	}
+	if err = loadRedactionFields(); err != nil {
+		log.Info("failed to load redaction fields", err)
+	}
+	if err = registerWebChat(); err != nil {
+		log.Info("failed to register web chat", err)
+	}
+	if err = loadModels(); err != nil {
+		log.Info("failed to load models", err)
+	}
 	offensive, err = buildOffensiveMap()
 	if err != nil {
 		log.Debug("could not build offensive map", err)
 	}
+	if err = loadStopwords(); err != nil {
+		log.Debug("could not load stopwords", err)
+	}
+	if err = loadVocabulary(); err != nil {
+		log.Info("failed to load vocabulary packs", err)
+	}
+	if err = loadGazetteer(); err != nil {
+		log.Info("failed to load gazetteer", err)
+	}
+	if err = loadBusinessHours(); err != nil {
+		log.Info("failed to load business hours", err)
+	}
+	if err = loadFlows(); err != nil {
+		log.Info("failed to load flows", err)
+	}
+	// Holidays are those of ABOT_COUNTRY, an ISO 3166 country or region
+	// code like "US" or "GB-SCT".
+	if c := os.Getenv("ABOT_COUNTRY"); len(c) > 0 {
+		holidays.DefaultCountry = c
+	}
 	if len(p) > 0 {
 		p = filepath.Join(p, "assets", "html", "layout.html")
 		if err = loadHTMLTemplate(p); err != nil {
@@ -106,49 +170,171 @@ func NewServer() (r *httprouter.Router, err error) {
 		log.Debug("no sms drivers imported")
 	}
 
+	// Open a connection to an email service. ABOT_EMAIL_AUTH is passed
+	// through to the driver, usually as an API key.
+	if len(emailsender.Drivers()) > 0 {
+		drv := emailsender.Drivers()[0]
+		emailConn, err = emailsender.Open(drv,
+			os.Getenv("ABOT_EMAIL_AUTH"))
+		if err != nil {
+			log.Info("failed to open email driver connection", drv,
+				err)
+		}
+	} else {
+		log.Debug("no email drivers imported")
+	}
+
+	// Open a connection to a payment service. ABOT_PAYMENT_AUTH is passed
+	// through to the driver, usually as an API key.
+	if len(payment.Drivers()) > 0 {
+		drv := payment.Drivers()[0]
+		paymentConn, err = payment.Open(drv, db, r,
+			os.Getenv("ABOT_PAYMENT_AUTH"))
+		if err != nil {
+			log.Info("failed to open payment driver connection",
+				drv, err)
+		}
+	} else {
+		log.Debug("no payment drivers imported")
+	}
+
+	// Open a connection to a ride service. ABOT_RIDE_AUTH is passed
+	// through to the driver, usually as the app's OAuth credentials.
+	if len(ride.Drivers()) > 0 {
+		drv := ride.Drivers()[0]
+		rideConn, err = ride.Open(drv, db, r,
+			os.Getenv("ABOT_RIDE_AUTH"))
+		if err != nil {
+			log.Info("failed to open ride driver connection", drv,
+				err)
+		}
+	} else {
+		log.Debug("no ride drivers imported")
+	}
+
+	// Open a connection to a places service. ABOT_PLACES_AUTH is passed
+	// through to the driver, usually as an API key.
+	if len(places.Drivers()) > 0 {
+		drv := places.Drivers()[0]
+		placesConn, err = places.Open(drv,
+			os.Getenv("ABOT_PLACES_AUTH"))
+		if err != nil {
+			log.Info("failed to open places driver connection", drv,
+				err)
+		}
+	} else {
+		log.Debug("no places drivers imported")
+	}
+
+	// Open a connection to a directions service. ABOT_DIRECTIONS_AUTH is
+	// passed through to the driver, usually as an API key.
+	if len(directions.Drivers()) > 0 {
+		drv := directions.Drivers()[0]
+		directionsConn, err = directions.Open(drv,
+			os.Getenv("ABOT_DIRECTIONS_AUTH"))
+		if err != nil {
+			log.Info("failed to open directions driver connection",
+				drv, err)
+		}
+	} else {
+		log.Debug("no directions drivers imported")
+	}
+
+	// Open a connection to an embedding service for searching facts by
+	// meaning. ABOT_EMBEDDING_AUTH is passed through to the driver, usually
+	// as an API key.
+	if len(embedding.Drivers()) > 0 {
+		drv := embedding.Drivers()[0]
+		embeddingConn, err = embedding.Open(drv,
+			os.Getenv("ABOT_EMBEDDING_AUTH"))
+		if err != nil {
+			log.Info("failed to open embedding driver connection",
+				drv, err)
+		}
+		q := `SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname='vector')`
+		if err = db.Get(&pgvector, q); err != nil {
+			log.Info("failed to check for pgvector", err)
+		}
+	} else {
+		log.Debug("no embedding drivers imported")
+	}
+
+	// Open a connection to an OCR service for reading photos users send.
+	// ABOT_OCR_AUTH is passed through to the driver, usually as an API key.
+	if len(ocr.Drivers()) > 0 {
+		drv := ocr.Drivers()[0]
+		ocrConn, err = ocr.Open(drv, os.Getenv("ABOT_OCR_AUTH"))
+		if err != nil {
+			log.Info("failed to open ocr driver connection", drv, err)
+		}
+	} else {
+		log.Debug("no ocr drivers imported")
+	}
+
+	// Open a connection to a translation service. ABOT_TRANSLATE_AUTH is
+	// passed through to the driver, usually as an API key.
+	if len(translate.Drivers()) > 0 {
+		drv := translate.Drivers()[0]
+		translateConn, err = translate.Open(drv,
+			os.Getenv("ABOT_TRANSLATE_AUTH"))
+		if err != nil {
+			log.Info("failed to open translate driver connection",
+				drv, err)
+		}
+	} else {
+		log.Debug("no translate drivers imported")
+	}
+
+	// Open a connection to an annotation tool, where people label the
+	// messages Abot didn't understand. ABOT_ANNOTATION_AUTH is passed
+	// through to the driver in its own format.
+	if len(annotation.Drivers()) > 0 {
+		drv := annotation.Drivers()[0]
+		annotationConn, err = annotation.Open(drv,
+			os.Getenv("ABOT_ANNOTATION_AUTH"))
+		if err != nil {
+			log.Info("failed to open annotation driver connection",
+				drv, err)
+		}
+	} else {
+		log.Debug("no annotation drivers imported")
+	}
+
 	// Listen for events that need to be sent.
-	evtChan := make(chan *dt.ScheduledEvent)
-	go func(chan *dt.ScheduledEvent) {
-		q := `UPDATE scheduledevents SET sent=TRUE WHERE id=$1`
-		select {
-		case evt := <-evtChan:
-			log.Debug("received event")
-			// Send event. On error, event will be retried next
-			// minute.
-			if err := evt.Send(smsConn); err != nil {
-				log.Info("failed to send scheduled event", err)
-				return
-			}
-			// Update event as sent
-			if _, err := db.Exec(q); err != nil {
-				log.Info("failed to update scheduled event as sent",
-					err)
-				return
-			}
+	evtChan := make(chan []*dt.ScheduledEvent)
+	go func(chan []*dt.ScheduledEvent) {
+		for evts := range evtChan {
+			log.Debug("received events")
+			sendScheduled(evts)
 		}
 	}(evtChan)
 
 	// Check every minute if there are any scheduled events that need to be
 	// sent.
-	go func(evtChan chan *dt.ScheduledEvent) {
-		q := `SELECT id, content, flexid, flexidtype
+	go func(evtChan chan []*dt.ScheduledEvent) {
+		q := `SELECT id, content, flexid, flexidtype, priority, category
 		      FROM scheduledevents
-		      WHERE sent=false AND sendat<=$1`
+		      WHERE sent=false AND deadat IS NULL AND sendat<=$1
+		          AND attempts<$2
+		      ORDER BY sendat, id`
 		t := time.NewTicker(time.Minute)
-		select {
-		case now := <-t.C:
+		for now := range t.C {
 			evts := []*dt.ScheduledEvent{}
-			if err := db.Select(&evts, q, now); err != nil {
+			err := db.Select(&evts, q, now, maxSendAttempts)
+			if err != nil {
 				log.Info("failed to queue scheduled event", err)
-				return
+				continue
 			}
-			for _, evt := range evts {
-				// Queue the event for sending
-				evtChan <- evt
+			for _, batch := range batchEvents(evts) {
+				// Queue the recipient's events for sending
+				evtChan <- batch
 			}
 		}
 	}(evtChan)
 
+	// Run any jobs registered by plugins.
+	go runJobs(time.NewTicker(time.Minute))
+
 	return r, nil
 }
 
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// responseCache holds plugins' replies to messages, keyed by plugin name and
+// the key of the message, for plugins that opt in with CacheResponses.
+type responseCache struct {
+	mu      sync.Mutex
+	opts    map[string]cacheOpts
+	entries map[string]cacheEntry
+	hits    int
+	misses  int
+}
+
+type cacheOpts struct {
+	ttl time.Duration
+	key func(in *dt.Msg) string
+}
+
+type cacheEntry struct {
+	reply     string
+	expiresAt time.Time
+}
+
+var respCache = &responseCache{
+	opts:    map[string]cacheOpts{},
+	entries: map[string]cacheEntry{},
+}
+
+func init() {
+	RegisterJob("response_cache_purge", time.Minute, purgeResponseCache)
+	RegisterMetric("response_cache_hit_rate", responseCacheHitRate)
+}
+
+// CacheResponses caches a plugin's replies to identical messages for ttl,
+// e.g. 10 minutes for the weather, so repeated queries don't call the plugin
+// or the external APIs behind it. It's meant for plugins answering idempotent
+// queries: cached replies are sent without running the plugin, so nothing it
+// does besides replying is repeated, and followups in a conversation are
+// never cached.
+//
+// Messages are identical when key returns the same non-empty string for them.
+// A nil key uses the user and the message's words, ignoring case and filler
+// words, so only a user's own replies are reused, and skips unregistered
+// users, who can't be told apart. Plugins whose replies don't depend on the
+// user, like the weather in a named city, can share replies among users by
+// leaving the user out of the key. An empty key skips the cache for that
+// message.
+//
+// Operators can change the TTL, or set it to 0 to turn caching off, through
+// the plugin_{name}_cache_ttl_minutes setting.
+func CacheResponses(p *dt.Plugin, ttl time.Duration,
+	key func(in *dt.Msg) string) {
+
+	if key == nil {
+		key = userMessageKey
+	}
+	respCache.mu.Lock()
+	defer respCache.mu.Unlock()
+	respCache.opts[p.Config.Name] = cacheOpts{ttl: ttl, key: key}
+}
+
+// userMessageKey identifies a message by its user and words. Messages from
+// unregistered users, who all have ID 0, aren't cached.
+func userMessageKey(in *dt.Msg) string {
+	if in.User == nil || !in.User.Registered() {
+		return ""
+	}
+	return fmt.Sprintf("%d:%s", in.User.ID,
+		strings.ToLower(strings.Join(in.Tokens, " ")))
+}
+
+// cacheTTL returns how long a plugin's replies are cached, or 0 if they
+// aren't.
+func cacheTTL(pluginName string, opts cacheOpts) time.Duration {
+	s := Setting("plugin_" + pluginName + "_cache_ttl_minutes")
+	if len(s) == 0 {
+		return opts.ttl
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		log.Info("invalid cache ttl", pluginName, s)
+		return opts.ttl
+	}
+	return time.Duration(n) * time.Minute
+}
+
+// cacheKey returns the key under which a plugin's reply to a message is
+// cached, or an empty string if it isn't cached.
+func cacheKey(p *dt.Plugin, in *dt.Msg) (string, time.Duration) {
+	respCache.mu.Lock()
+	opts, ok := respCache.opts[p.Config.Name]
+	respCache.mu.Unlock()
+	if !ok {
+		return "", 0
+	}
+	ttl := cacheTTL(p.Config.Name, opts)
+	if ttl <= 0 {
+		return "", 0
+	}
+	k := opts.key(in)
+	if len(k) == 0 {
+		return "", 0
+	}
+	return p.Config.Name + "\x00" + k, ttl
+}
+
+// cachedResponse returns a plugin's cached reply to a message.
+func cachedResponse(key string) (string, bool) {
+	respCache.mu.Lock()
+	defer respCache.mu.Unlock()
+	e, ok := respCache.entries[key]
+	if !ok || !time.Now().Before(e.expiresAt) {
+		respCache.misses++
+		return "", false
+	}
+	respCache.hits++
+	return e.reply, true
+}
+
+// cacheResponse caches a plugin's reply to a message. Empty replies aren't
+// cached, since they usually mean the plugin failed.
+func cacheResponse(key, reply string, ttl time.Duration) {
+	if len(reply) == 0 {
+		return
+	}
+	respCache.mu.Lock()
+	defer respCache.mu.Unlock()
+	respCache.entries[key] = cacheEntry{
+		reply:     reply,
+		expiresAt: time.Now().Add(ttl),
+	}
+}
+
+// purgeResponseCache frees the memory of expired replies.
+func purgeResponseCache() error {
+	now := time.Now()
+	respCache.mu.Lock()
+	defer respCache.mu.Unlock()
+	for k, e := range respCache.entries {
+		if !now.Before(e.expiresAt) {
+			delete(respCache.entries, k)
+		}
+	}
+	return nil
+}
+
+// responseCacheHitRate is the share of messages to caching plugins answered
+// from the cache since Abot started.
+func responseCacheHitRate() (interface{}, error) {
+	respCache.mu.Lock()
+	defer respCache.mu.Unlock()
+	total := respCache.hits + respCache.misses
+	if total == 0 {
+		return 0.0, nil
+	}
+	return float64(respCache.hits) / float64(total), nil
+}
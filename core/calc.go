@@ -0,0 +1,149 @@
+package core
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/helpers/calc"
+	"github.com/itsabot/abot/shared/helpers/timeparse"
+)
+
+// regexDaysUntil matches questions like "how many days until June 3" or "how
+// many weeks since Dec 25."
+var regexDaysUntil = regexp.MustCompile(`(?i)^how (?:many (days|weeks)|long) (?:is it |are there |has it been )?(until|till|til|before|since) (.+?)[?.!\s]*$`)
+
+// regexDateOffset matches questions like "what's the date 30 days from now"
+// or "what day was it 2 weeks ago."
+var regexDateOffset = regexp.MustCompile(`(?i)^what(?:'s| is)? (?:the )?(?:day|date) (?:is it |will it be |was it |is )?(?:in )?(\d+) (days?|weeks?|months?|years?)( from now| from today| ago)?[?.!\s]*$`)
+
+// calculate answers arithmetic and date arithmetic questions, like "what's
+// 18% of 92.50" or "how many days until June 3," with numbers and dates
+// formatted for the language of the user's message. An empty string is
+// returned if the message isn't such a question.
+func calculate(msg *dt.Msg) string {
+	lang := msg.Lang
+	if len(lang) == 0 {
+		lang = parserLang
+	}
+	if m := regexDaysUntil.FindStringSubmatch(msg.Sentence); m != nil {
+		return daysUntil(m[1], m[2], m[3], lang)
+	}
+	if m := regexDateOffset.FindStringSubmatch(msg.Sentence); m != nil {
+		return dateOffset(m[1], m[2], m[3], lang)
+	}
+	expr, ok := calc.Extract(msg.Sentence)
+	if !ok {
+		return ""
+	}
+	n, err := calc.Eval(expr)
+	if err == calc.ErrUndefined {
+		return "That's undefined."
+	}
+	if err != nil {
+		log.Debug("failed to evaluate expression", expr, err)
+		return ""
+	}
+	return "That's " + calc.FormatNumber(n, lang) + "."
+}
+
+// daysUntil counts the days or weeks between today and a date.
+func daysUntil(unit, dir, date, lang string) string {
+	ts, err := timeparse.Parse(date)
+	if err != nil {
+		log.Debug("failed to parse date", date, err)
+		return ""
+	}
+	// Times without a date, like "Friday", are parsed as year 0.
+	var t time.Time
+	for _, pt := range ts {
+		if pt.Year() > 0 {
+			t = pt
+			break
+		}
+	}
+	if t.IsZero() {
+		return ""
+	}
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0,
+		time.UTC)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	since := strings.ToLower(dir) == "since"
+	if !since && day.Before(today) && !strings.Contains(date, strconv.Itoa(t.Year())) {
+		// "Until June 3" after June 3 means next year's.
+		day = day.AddDate(1, 0, 0)
+	}
+	days := int(day.Sub(today).Hours() / 24)
+	if since {
+		days = -days
+	}
+	when := calc.FormatDate(day, lang)
+	switch {
+	case days == 0:
+		return "That's today, " + when + "."
+	case days < 0 && since:
+		return "That's " + count(-days, "day", lang) + " from now, on " +
+			when + "."
+	case days < 0:
+		return "That was " + count(-days, "day", lang) + " ago, on " +
+			when + "."
+	}
+	d := count(days, "day", lang)
+	if strings.ToLower(unit) == "weeks" && days >= 7 {
+		d = count(days/7, "week", lang)
+		if days%7 > 0 {
+			d += " and " + count(days%7, "day", lang)
+		}
+	}
+	if since {
+		return "It's been " + d + " since " + when + "."
+	}
+	return "There " + isAre(days) + " " + d + " until " + when + "."
+}
+
+// dateOffset finds the date some number of days, weeks, months or years from
+// today, or ago.
+func dateOffset(num, unit, dir, lang string) string {
+	n, err := strconv.Atoi(num)
+	if err != nil {
+		return ""
+	}
+	if strings.TrimSpace(strings.ToLower(dir)) == "ago" {
+		n = -n
+	}
+	t := time.Now()
+	switch strings.TrimSuffix(strings.ToLower(unit), "s") {
+	case "day":
+		t = t.AddDate(0, 0, n)
+	case "week":
+		t = t.AddDate(0, 0, 7*n)
+	case "month":
+		t = t.AddDate(0, n, 0)
+	case "year":
+		t = t.AddDate(n, 0, 0)
+	}
+	if n < 0 {
+		return "It was " + calc.FormatDate(t, lang) + "."
+	}
+	return "It'll be " + calc.FormatDate(t, lang) + "."
+}
+
+// count writes a number of units, e.g. "1 day" or "1,000 days."
+func count(n int, unit, lang string) string {
+	s := calc.FormatNumber(float64(n), lang) + " " + unit
+	if n != 1 {
+		s += "s"
+	}
+	return s
+}
+
+func isAre(n int) string {
+	if n == 1 {
+		return "is"
+	}
+	return "are"
+}
@@ -0,0 +1,431 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// callbackContextMsgs is how many of the user's messages leading up to a
+// callback are shown to operators with it.
+const callbackContextMsgs = 10
+
+// ErrUnknownCallback is returned when claiming or completing a callback that
+// doesn't exist or was already completed.
+var ErrUnknownCallback = errors.New("unknown callback")
+
+// ErrCallbackClaimed is returned when claiming or completing a callback
+// another operator has claimed.
+var ErrCallbackClaimed = errors.New("callback claimed by another operator")
+
+// Callback is a user's request for a person made outside business hours,
+// queued for operators to follow up on once they open, at the time the user
+// prefers if they gave one. An operator claims a callback before following
+// up, so two don't call the same user, and completes it with the outcome,
+// which is logged in the user's conversation.
+type Callback struct {
+	ID        uint64
+	UserID    uint64
+	Name      string
+	FlexID    string
+	MessageID uint64
+
+	// Reason is the escalation rule that requested the person.
+	Reason string
+
+	// DueAt is when business opens after the request. PreferredAt is
+	// when the user asked to be contacted instead, if they did.
+	DueAt       time.Time
+	PreferredAt *time.Time
+
+	ClaimedBy   string
+	ClaimedAt   *time.Time
+	CompletedBy string
+	CompletedAt *time.Time
+	Outcome     string
+
+	// Context is the user's conversation leading up to the request,
+	// oldest first. It's only set by GetCallback.
+	Context []TranscriptMsg
+
+	CreatedAt time.Time
+}
+
+// callbackColumns are selected into Callbacks.
+const callbackColumns = `c.id, c.userid, u.name,
+	COALESCE((SELECT flexid FROM userflexids
+	    WHERE userid=c.userid
+	    ORDER BY createdat DESC LIMIT 1), '') AS flexid,
+	c.messageid, c.reason, c.dueat, c.preferredat, c.claimedby,
+	c.claimedat, c.completedby, c.completedat, c.outcome, c.createdat`
+
+// deferHandoff queues a user's request for a person, made outside business
+// hours, as a callback due when business opens, and schedules a message
+// telling the user a person will follow up then. The user may reply with a
+// time they'd prefer. It returns Abot's response to the user.
+func deferHandoff(in *dt.Msg, reason string, h *BusinessHours) (string,
+	error) {
+
+	now := time.Now()
+	opens := h.NextOpen(now)
+	fid, fidT := in.User.FlexID, in.User.FlexIDType
+	if len(fid) == 0 {
+		var err error
+		if fid, fidT, err = in.User.LastFlexID(db); err != nil {
+			return "", err
+		}
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	q := `INSERT INTO scheduledevents
+	      (content, flexid, flexidtype, sendat, category, priority)
+	      VALUES ($1, $2, $3, $4, $5, $6)
+	      RETURNING id`
+	var followupID uint64
+	err = tx.QueryRowx(q,
+		"We're open now, and a person will follow up with you shortly.",
+		fid, fidT, opens, handoffLabel, dt.PriorityNormal).Scan(&followupID)
+	if err != nil {
+		_ = tx.Rollback()
+		return "", err
+	}
+	q = `INSERT INTO callbacks
+	     (tenant, userid, messageid, reason, dueat, followupid)
+	     VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = tx.Exec(q, tenant(), in.User.ID, in.ID, reason, opens,
+		followupID)
+	if err != nil {
+		_ = tx.Rollback()
+		return "", err
+	}
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+	// The response says when business opens, so the notice would
+	// repeat it.
+	markNoticed(in.User, opens)
+	return fmt.Sprintf(`Our team is away right now, so I've asked a person to follow up with you when we open %s. If another time suits you better, just tell me when, like "tomorrow at 2pm."`,
+		opensPhrase(now, opens)), nil
+}
+
+// manageCallbackTime answers a message with only a time, like "tomorrow at
+// 2pm," from a user awaiting a callback no operator has claimed, saving it as
+// the time they'd prefer to be contacted. An empty string is returned if the
+// message isn't such a reply.
+func manageCallbackTime(msg *dt.Msg) string {
+	si := msg.StructuredInput
+	if !msg.User.Registered() || si == nil || !si.OnlyTime ||
+		len(si.Times) == 0 {
+		return ""
+	}
+	var cb struct {
+		ID         uint64
+		FollowupID sql.NullInt64
+	}
+	q := `SELECT id, followupid FROM callbacks
+	      WHERE userid=$1 AND tenant=$2 AND claimedby=''
+	          AND completedat IS NULL
+	      ORDER BY id DESC
+	      LIMIT 1`
+	err := db.Get(&cb, q, msg.User.ID, tenant())
+	if err == sql.ErrNoRows {
+		return ""
+	}
+	if err != nil {
+		log.Info("failed to get callback", err)
+		return ""
+	}
+	now := time.Now()
+	t := si.Times[0]
+	if t.Before(now) {
+		return "That time has already passed. When would you like us to reach you?"
+	}
+	h := currentHours()
+	if !h.OpenAt(t) {
+		return fmt.Sprintf("We're closed then, but we open %s. When would you like us to reach you?",
+			opensPhrase(now, h.NextOpen(t)))
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Info("failed to save preferred callback time", err)
+		return "I'm sorry, I couldn't save that time right now."
+	}
+	q = `UPDATE callbacks SET preferredat=$1 WHERE id=$2`
+	if _, err = tx.Exec(q, t, cb.ID); err != nil {
+		_ = tx.Rollback()
+		log.Info("failed to save preferred callback time", err)
+		return "I'm sorry, I couldn't save that time right now."
+	}
+	if cb.FollowupID.Valid {
+		q = `UPDATE scheduledevents SET sendat=$1, content=$2
+		     WHERE id=$3 AND sent IS FALSE`
+		_, err = tx.Exec(q, t,
+			"A person from our team will reach out to you shortly, as you asked.",
+			cb.FollowupID.Int64)
+		if err != nil {
+			_ = tx.Rollback()
+			log.Info("failed to reschedule callback followup", err)
+			return "I'm sorry, I couldn't save that time right now."
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		log.Info("failed to save preferred callback time", err)
+		return "I'm sorry, I couldn't save that time right now."
+	}
+	return fmt.Sprintf("Got it. A person will reach out to you %s.",
+		opensPhrase(now, t.In(h.location())))
+}
+
+// Callbacks returns the callbacks operators haven't completed, soonest first
+// by the time the user preferred or else when they're due.
+func Callbacks() ([]Callback, error) {
+	q := `SELECT ` + callbackColumns + `
+	      FROM callbacks AS c
+	      JOIN users AS u ON u.id=c.userid
+	      WHERE c.tenant=$1 AND c.completedat IS NULL
+	      ORDER BY COALESCE(c.preferredat, c.dueat), c.id
+	      LIMIT $2`
+	var cbs []Callback
+	if err := db.Select(&cbs, q, tenant(), maxAdminResults); err != nil {
+		return nil, err
+	}
+	return cbs, nil
+}
+
+// GetCallback returns a callback with the user's conversation leading up to
+// it.
+func GetCallback(id uint64) (*Callback, error) {
+	cb := &Callback{}
+	q := `SELECT ` + callbackColumns + `
+	      FROM callbacks AS c
+	      JOIN users AS u ON u.id=c.userid
+	      WHERE c.id=$1 AND c.tenant=$2`
+	err := db.Get(cb, q, id, tenant())
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownCallback
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(cb.Outcome) > 0 {
+		cb.Outcome = openSentence(cb.Outcome)
+	}
+	q = `SELECT id, COALESCE(sentence, '') AS sentence, abotsent,
+	         COALESCE(commands, '{}') AS commands,
+	         COALESCE(objects, '{}') AS objects,
+	         COALESCE(plugin, '') AS plugin, COALESCE(route, '') AS route,
+	         COALESCE(needstraining, FALSE) AS needstraining, createdat
+	     FROM messages
+	     WHERE userid=$1 AND id<=$2
+	     ORDER BY id DESC
+	     LIMIT $3`
+	var msgs []TranscriptMsg
+	err = db.Select(&msgs, q, cb.UserID, cb.MessageID, callbackContextMsgs)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(msgs) - 1; i >= 0; i-- {
+		msgs[i].Sentence = openSentence(msgs[i].Sentence)
+		cb.Context = append(cb.Context, msgs[i])
+	}
+	return cb, nil
+}
+
+// ClaimCallback assigns a callback to an operator, so others know it's being
+// handled. Claiming a callback the operator already claimed does nothing.
+func ClaimCallback(id uint64, operator string) error {
+	q := `UPDATE callbacks SET claimedby=$1, claimedat=CURRENT_TIMESTAMP
+	      WHERE id=$2 AND tenant=$3 AND completedat IS NULL
+	          AND (claimedby='' OR claimedby=$1)`
+	res, err := db.Exec(q, operator, id, tenant())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return callbackUnavailable(id)
+	}
+	return nil
+}
+
+// CompleteCallback removes a callback from the queue once an operator has
+// followed up, logging the outcome, e.g. "Called, refund issued," in the
+// user's conversation. A follow-up message not yet sent to the user is
+// canceled. Once the user has no callbacks or tickets left open, their
+// conversation's human_handoff label is resolved.
+func CompleteCallback(id uint64, operator, outcome string) error {
+	sealed, err := dt.SealSentence(outcome)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	var cb struct {
+		UserID     uint64
+		FollowupID sql.NullInt64
+	}
+	q := `UPDATE callbacks
+	      SET completedby=$1, completedat=CURRENT_TIMESTAMP, outcome=$2
+	      WHERE id=$3 AND tenant=$4 AND completedat IS NULL
+	          AND (claimedby='' OR claimedby=$1)
+	      RETURNING userid, followupid`
+	err = tx.Get(&cb, q, operator, sealed, id, tenant())
+	if err == sql.ErrNoRows {
+		_ = tx.Rollback()
+		return callbackUnavailable(id)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if cb.FollowupID.Valid {
+		q = `DELETE FROM scheduledevents WHERE id=$1 AND sent IS FALSE`
+		if _, err = tx.Exec(q, cb.FollowupID.Int64); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	var open bool
+	q = `SELECT EXISTS(SELECT 1 FROM callbacks
+	         WHERE userid=$1 AND completedat IS NULL)
+	     OR EXISTS(SELECT 1 FROM tickets
+	         WHERE userid=$1 AND releasedat IS NULL)`
+	if err = tx.Get(&open, q, cb.UserID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	// The outcome is kept with the conversation, like a message Abot
+	// sent, so it's read in context. Operators see it in the transcript,
+	// but it's never sent to the user.
+	m := &dt.Msg{
+		User:     &dt.User{ID: cb.UserID},
+		Sentence: fmt.Sprintf("Callback by %s: %s", operator, outcome),
+		AbotSent: true,
+		Plugin:   "callback",
+	}
+	if err = m.Save(db); err != nil {
+		return err
+	}
+	if !open {
+		return ResolveLabel(cb.UserID, handoffLabel)
+	}
+	return nil
+}
+
+// callbackUnavailable returns why a callback couldn't be claimed or completed.
+func callbackUnavailable(id uint64) error {
+	var claimed bool
+	q := `SELECT claimedby<>'' FROM callbacks
+	      WHERE id=$1 AND tenant=$2 AND completedat IS NULL`
+	err := db.Get(&claimed, q, id, tenant())
+	if err == sql.ErrNoRows {
+		return ErrUnknownCallback
+	}
+	if err != nil {
+		return err
+	}
+	if claimed {
+		return ErrCallbackClaimed
+	}
+	return ErrUnknownCallback
+}
+
+// HAPICallbacks responds with the callbacks operators haven't completed.
+func HAPICallbacks(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	cbs, err := Callbacks()
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Callbacks []Callback }{Callbacks: cbs})
+}
+
+// HAPICallback responds with a callback, given by the id query parameter, and
+// the user's conversation leading up to it.
+func HAPICallback(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	cb, err := GetCallback(id)
+	if err == ErrUnknownCallback {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, cb)
+}
+
+// HAPICallbackClaim assigns a callback to the operator.
+func HAPICallbackClaim(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct{ ID uint64 }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := ClaimCallback(req.ID, operatorName(r))
+	if err == ErrUnknownCallback || err == ErrCallbackClaimed {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HAPICallbackComplete marks a callback completed with its outcome.
+func HAPICallbackComplete(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct {
+		ID      uint64
+		Outcome string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := CompleteCallback(req.ID, operatorName(r), req.Outcome)
+	if err == ErrUnknownCallback || err == ErrCallbackClaimed {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/interface/classifier"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// dictionaryClassifier is the ABOT_CLASSIFIER choosing the dictionaries in
+// data/ner, which are used when it's unset, too.
+const dictionaryClassifier = "dictionary"
+
+var classifierConn *classifier.Conn
+var classifierMu sync.RWMutex
+var hangupOnce sync.Once
+
+// ActiveClassifier returns the classifier finding Commands and Objects in
+// messages: the driver named by ABOT_CLASSIFIER if one was opened, otherwise
+// the dictionaries.
+func ActiveClassifier() Classifier {
+	classifierMu.RLock()
+	defer classifierMu.RUnlock()
+	if classifierConn != nil {
+		return classifierConn
+	}
+	return NER()
+}
+
+// classifyTokens builds a StructuredInput from a tokenized sentence with the
+// active classifier. Drivers are given the tokens joined by spaces. If a
+// driver fails, the dictionaries are used instead, so messages are still
+// routed while an external service is down.
+func classifyTokens(tokens []string) *nlp.StructuredInput {
+	c := ActiveClassifier()
+	if d, ok := c.(Dictionary); ok {
+		return d.ClassifyTokens(tokens)
+	}
+	wc, err := c.Classify(strings.Join(tokens, " "))
+	if err != nil {
+		log.Info("failed to classify, using dictionaries", err)
+		return NER().ClassifyTokens(tokens)
+	}
+	si := &nlp.StructuredInput{}
+	if err = si.Add(wc); err != nil {
+		log.Info("failed to classify, using dictionaries", err)
+		return NER().ClassifyTokens(tokens)
+	}
+	return si
+}
+
+// openClassifier opens the classifier driver named by ABOT_CLASSIFIER, passing
+// it ABOT_CLASSIFIER_AUTH, to classify messages in place of the dictionaries.
+// Nothing is opened if ABOT_CLASSIFIER is unset or "dictionary."
+func openClassifier() error {
+	name := os.Getenv("ABOT_CLASSIFIER")
+	if len(name) == 0 || name == dictionaryClassifier {
+		return nil
+	}
+	c, err := classifier.Open(name, os.Getenv("ABOT_CLASSIFIER_AUTH"))
+	if err != nil {
+		return err
+	}
+	classifierMu.Lock()
+	old := classifierConn
+	classifierConn = c
+	classifierMu.Unlock()
+	if old != nil {
+		if err = old.Close(); err != nil {
+			log.Info("failed to close classifier", old.Name(), err)
+		}
+	}
+	log.Info("classifying with", name)
+	return nil
+}
+
+// reloadClassifierDriver reloads the opened classifier driver's model, if a
+// driver was opened.
+func reloadClassifierDriver() error {
+	classifierMu.RLock()
+	c := classifierConn
+	classifierMu.RUnlock()
+	if c == nil {
+		return nil
+	}
+	return c.Reload()
+}
+
+// reloadOnHangup retrains the classifier whenever Abot receives SIGHUP, so
+// new dictionaries or model files are picked up without restarting the
+// server, e.g. with `kill -HUP`.
+func reloadOnHangup() {
+	hangupOnce.Do(func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGHUP)
+		go func() {
+			for range sig {
+				if err := Retrain(); err != nil {
+					log.Info("failed to reload classifier", err)
+				}
+			}
+		}()
+	})
+}
@@ -0,0 +1,243 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/sms"
+)
+
+// Kinds of costs. Quantities are in the unit each is named for.
+const (
+	// CostSMSSegment is an SMS segment sent or received. Long messages
+	// are billed as several segments.
+	CostSMSSegment = "sms_segment"
+
+	// CostSTTSecond is a second of speech transcribed to text.
+	CostSTTSecond = "stt_second"
+
+	// CostLLMToken is a token sent to or generated by a language model.
+	CostLLMToken = "llm_token"
+
+	// CostAPICall is a call to an external API, like a translation or
+	// OCR service.
+	CostAPICall = "api_call"
+)
+
+// Groupings of costs. See CostSummaries.
+const (
+	CostsByUser   = "user"
+	CostsByPlugin = "plugin"
+	CostsByKind   = "kind"
+)
+
+// ErrInvalidCostGrouping is returned when summarizing costs by anything but a
+// user, plugin or kind.
+var ErrInvalidCostGrouping = errors.New("invalid cost grouping")
+
+// Cost is something Abot paid for in handling a conversation turn, like the
+// SMS segments of its response or the tokens a plugin sent to a language
+// model. Micros is the cost in millionths of a dollar. When it's not known,
+// it's the quantity times the cost_{kind}_micros setting, e.g.
+// cost_sms_segment_micros=7500 for $0.0075 a segment.
+type Cost struct {
+	Kind     string
+	Quantity int64
+	Micros   int64
+
+	// MessageID is the user's message the cost was incurred in
+	// responding to, or 0 if none, like a reminder sent later.
+	MessageID uint64
+	UserID    uint64
+	Plugin    string
+	Note      string
+}
+
+// CostSummary totals the costs of a user, plugin or kind of cost.
+type CostSummary struct {
+	Key      string
+	Quantity int64
+	Micros   int64
+	Messages int64
+}
+
+func init() {
+	RegisterMetric("costs_this_month", costsThisMonth)
+}
+
+// RecordCost records a cost Abot incurred, pricing it by its kind's
+// cost_{kind}_micros setting if Micros is 0.
+func RecordCost(c *Cost) error {
+	if c.Micros == 0 && c.Quantity > 0 {
+		c.Micros = c.Quantity * quotaSetting("cost_"+c.Kind+"_micros")
+	}
+	q := `INSERT INTO costs
+	      (tenant, messageid, userid, pluginname, kind, quantity, micros,
+	       note)
+	      VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := db.Exec(q, tenant(), c.MessageID, c.UserID, c.Plugin, c.Kind,
+		c.Quantity, c.Micros, c.Note)
+	return err
+}
+
+// RecordMsgCost records a cost incurred in responding to a user's message,
+// attributed to the plugin that handled it.
+func RecordMsgCost(in *dt.Msg, kind string, quantity int64, note string) error {
+	c := &Cost{
+		Kind:      kind,
+		Quantity:  quantity,
+		MessageID: in.ID,
+		Plugin:    in.Plugin,
+		Note:      note,
+	}
+	if in.User != nil {
+		c.UserID = in.User.ID
+	}
+	return RecordCost(c)
+}
+
+// recordTurnCosts records the costs Abot incurs itself in a conversation
+// turn: the SMS segments of the message and response, if the user texted, and
+// the calls to translate and read images. Failures are logged, since they
+// shouldn't affect the response.
+func recordTurnCosts(in *dt.Msg, resp string) {
+	record := func(kind string, n int64, note string) {
+		if err := RecordMsgCost(in, kind, n, note); err != nil {
+			log.Info("failed to record cost", kind, err)
+		}
+	}
+	if in.User != nil && in.User.FlexIDType == dt.FlexIDType(2) {
+		record(CostSMSSegment, int64(sms.Segments(in.Sentence)), "received")
+		if len(resp) > 0 {
+			record(CostSMSSegment, int64(sms.Segments(resp)), "sent")
+		}
+	}
+	if len(in.Lang) > 0 && in.Lang != parserLang {
+		// Detecting the language and translating the message and
+		// response.
+		record(CostAPICall, 3, "translate")
+	}
+	if in.StructuredInput != nil && in.StructuredInput.FromImage {
+		record(CostAPICall, 1, "ocr")
+	}
+}
+
+// recordSendCost records the SMS segments of an event sent by text outside of
+// a conversation turn, like a reminder.
+func recordSendCost(evt dt.ScheduledEvent) {
+	if evt.FlexIDType != dt.FlexIDType(2) {
+		return
+	}
+	c := &Cost{
+		Kind:     CostSMSSegment,
+		Quantity: int64(sms.Segments(evt.Content)),
+		Note:     "sent",
+	}
+	u, err := evt.Recipient(db)
+	if err != nil {
+		log.Info("failed to get recipient for cost", err)
+	} else if u != nil {
+		c.UserID = u.ID
+	}
+	if err = RecordCost(c); err != nil {
+		log.Info("failed to record cost", c.Kind, err)
+	}
+}
+
+// CostSummaries totals the costs incurred since a time by user, plugin or
+// kind, most expensive first.
+func CostSummaries(by string, since time.Time, limit int) ([]CostSummary,
+	error) {
+
+	var col string
+	switch by {
+	case CostsByUser:
+		col = "userid::text"
+	case CostsByPlugin:
+		col = "pluginname"
+	case CostsByKind:
+		col = "kind"
+	default:
+		return nil, fmt.Errorf("%s: %q", ErrInvalidCostGrouping, by)
+	}
+	q := `SELECT ` + col + ` AS key, SUM(quantity) AS quantity,
+	          SUM(micros) AS micros,
+	          COUNT(DISTINCT NULLIF(messageid, 0)) AS messages
+	      FROM costs
+	      WHERE tenant=$1 AND createdat>=$2
+	      GROUP BY key
+	      ORDER BY micros DESC, key
+	      LIMIT $3`
+	var ss []CostSummary
+	if err := db.Select(&ss, q, tenant(), since, limit); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// costsThisMonth summarizes this month's costs for Abot's analytics: the
+// tenant's total and the totals of each kind of cost and plugin.
+func costsThisMonth() (interface{}, error) {
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	kinds, err := CostSummaries(CostsByKind, since, maxAdminResults)
+	if err != nil {
+		return nil, err
+	}
+	plugins, err := CostSummaries(CostsByPlugin, since, maxAdminResults)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, k := range kinds {
+		total += k.Micros
+	}
+	return struct {
+		TotalMicros int64
+		Kinds       []CostSummary
+		Plugins     []CostSummary
+	}{TotalMicros: total, Kinds: kinds, Plugins: plugins}, nil
+}
+
+// HAPICosts responds with the costs incurred over the past number of days
+// given by the days query parameter, 30 by default, totaled by the by query
+// parameter: user, plugin or kind.
+func HAPICosts(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	_, limit, err := pageParams(r)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	v := r.URL.Query()
+	by := v.Get("by")
+	switch by {
+	case "":
+		by = CostsByPlugin
+	case CostsByUser, CostsByPlugin, CostsByKind:
+	default:
+		writeErrorBadRequest(w, fmt.Errorf("%s: %q",
+			ErrInvalidCostGrouping, by))
+		return
+	}
+	days := 30
+	if s := v.Get("days"); len(s) > 0 {
+		if days, err = strconv.Atoi(s); err != nil || days <= 0 {
+			writeErrorBadRequest(w, fmt.Errorf("invalid days %q", s))
+			return
+		}
+	}
+	ss, err := CostSummaries(by, time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Costs []CostSummary }{Costs: ss})
+}
@@ -0,0 +1,237 @@
+package core
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// ErrNoCredential is returned when a plugin asks for a credential that hasn't
+// been stored for this tenant.
+var ErrNoCredential = errors.New("credential not found")
+
+// ErrInvalidCredential is returned when storing a credential without a plugin,
+// name or value.
+var ErrInvalidCredential = errors.New("invalid credential")
+
+// CredentialInfo describes a stored credential without revealing it.
+type CredentialInfo struct {
+	Plugin    string
+	Name      string
+	CreatedAt time.Time
+	RotatedAt *time.Time
+}
+
+// credentialKey is the key credentials are encrypted with. It's derived from
+// ABOT_SECRET alone rather than authSecret, so rotating auth keys doesn't
+// make stored credentials unreadable.
+func credentialKey() []byte {
+	k := sha256.Sum256([]byte(os.Getenv("ABOT_SECRET") + ":credentials"))
+	return k[:]
+}
+
+// sealCredential encrypts a credential with AES-GCM.
+func sealCredential(value string) ([]byte, error) {
+	return sealGCM(credentialKey(), []byte(value))
+}
+
+// openCredential decrypts a credential sealed by sealCredential.
+func openCredential(sealed []byte) (string, error) {
+	b, err := openGCM(credentialKey(), sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Credential returns a plugin's credential for an external API, like an API
+// key, as stored for this tenant by an operator or SetCredential. Plugins can
+// only read their own credentials. ErrNoCredential is returned if it hasn't
+// been stored.
+func Credential(p *dt.Plugin, name string) (string, error) {
+	return credential(p.Config.Name, name, "value")
+}
+
+// PreviousCredential returns the value a plugin's credential had before it was
+// last rotated, for external APIs that accept both keys while the new one
+// takes effect. ErrNoCredential is returned if it was never rotated.
+func PreviousCredential(p *dt.Plugin, name string) (string, error) {
+	return credential(p.Config.Name, name, "previousvalue")
+}
+
+func credential(plugin, name, column string) (string, error) {
+	q := `SELECT ` + column + ` FROM plugincredentials
+	      WHERE tenant=$1 AND pluginname=$2 AND name=$3`
+	var sealed []byte
+	err := db.Get(&sealed, q, tenant(), plugin, name)
+	if err == sql.ErrNoRows || (err == nil && sealed == nil) {
+		return "", ErrNoCredential
+	}
+	if err != nil {
+		return "", err
+	}
+	return openCredential(sealed)
+}
+
+// SetCredential stores a plugin's credential for this tenant, replacing any of
+// the same name. Credentials are encrypted at rest with a key derived from
+// ABOT_SECRET, so changing ABOT_SECRET requires storing them again.
+func SetCredential(p *dt.Plugin, name, value string) error {
+	return setCredential(p.Config.Name, name, value)
+}
+
+func setCredential(plugin, name, value string) error {
+	if len(plugin) == 0 || len(name) == 0 || len(value) == 0 {
+		return ErrInvalidCredential
+	}
+	sealed, err := sealCredential(value)
+	if err != nil {
+		return err
+	}
+	q := `INSERT INTO plugincredentials (tenant, pluginname, name, value)
+	      VALUES ($1, $2, $3, $4)
+	      ON CONFLICT (tenant, pluginname, name) DO UPDATE
+	      SET value=$4, previousvalue=NULL, rotatedat=NULL,
+	          createdat=CURRENT_TIMESTAMP`
+	_, err = db.Exec(q, tenant(), plugin, name, sealed)
+	return err
+}
+
+// RotateCredential replaces a plugin's credential with the one returned by fn,
+// which is passed the current value, e.g. to request a new API key from the
+// provider with the old one. The old value remains available through
+// PreviousCredential until the next rotation. Schedule rotations with
+// RegisterJob to rotate keys regularly.
+func RotateCredential(p *dt.Plugin, name string,
+	fn func(current string) (string, error)) error {
+
+	cur, err := Credential(p, name)
+	if err != nil {
+		return err
+	}
+	next, err := fn(cur)
+	if err != nil {
+		return err
+	}
+	if len(next) == 0 {
+		return ErrInvalidCredential
+	}
+	sealed, err := sealCredential(next)
+	if err != nil {
+		return err
+	}
+	q := `UPDATE plugincredentials
+	      SET previousvalue=value, value=$4, rotatedat=CURRENT_TIMESTAMP
+	      WHERE tenant=$1 AND pluginname=$2 AND name=$3`
+	_, err = db.Exec(q, tenant(), p.Config.Name, name, sealed)
+	if err != nil {
+		return err
+	}
+	log.Info("rotated credential", p.Config.Name, name)
+	return nil
+}
+
+// DeleteCredential removes a plugin's credential for this tenant.
+func DeleteCredential(plugin, name string) error {
+	q := `DELETE FROM plugincredentials
+	      WHERE tenant=$1 AND pluginname=$2 AND name=$3`
+	res, err := db.Exec(q, tenant(), plugin, name)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoCredential
+	}
+	return nil
+}
+
+// Credentials describes the credentials stored for this tenant, without their
+// values.
+func Credentials() ([]CredentialInfo, error) {
+	q := `SELECT pluginname AS plugin, name, createdat, rotatedat
+	      FROM plugincredentials
+	      WHERE tenant=$1
+	      ORDER BY pluginname, name`
+	var cs []CredentialInfo
+	if err := db.Select(&cs, q, tenant()); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// HAPICredentials responds with the credentials stored for this tenant. Their
+// values are never sent.
+func HAPICredentials(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	cs, err := Credentials()
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Credentials []CredentialInfo }{Credentials: cs})
+}
+
+// HAPICredentialsSubmit stores a plugin's credential, replacing any of the
+// same name.
+func HAPICredentialsSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct {
+		Plugin string
+		Name   string
+		Value  string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := setCredential(req.Plugin, req.Name, req.Value)
+	if err == ErrInvalidCredential {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HAPICredentialsDelete removes a plugin's credential.
+func HAPICredentialsDelete(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct {
+		Plugin string
+		Name   string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := DeleteCredential(req.Plugin, req.Name)
+	if err == ErrNoCredential {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/helpers/holidays"
+)
+
+// dayPartMargin is how long "after work" or "before lunch" lasts.
+const dayPartMargin = 2 * time.Hour
+
+// regexFuzzyTime matches fuzzy times like "this evening," "tomorrow morning,"
+// "Friday night," "after work" and "lunchtime tomorrow."
+var regexFuzzyTime = regexp.MustCompile(`(?i)\b(?:(this|today|tomorrow|tonight|(?:on |next )?(?:sun|mon|tues|wednes|thurs|fri|satur)day)\s+)?(?:(after|before|during|at|for|around)\s+)?(?:the\s+)?(morning|breakfast|lunch|afternoon|dinner|evening|night|work)(?:\s*time)?\b(?:\s+(today|tomorrow))?|\b(tonight)\b`)
+
+// regexDayPartDefine matches users defining a day part, like "my evening is
+// from 6pm to 10pm" or "I work 8:30 to 5."
+var regexDayPartDefine = regexp.MustCompile(`(?i)^(?:my\s+(morning|breakfast|lunch|afternoon|dinner|evening|night|work)(?:\s*time)?\s+(?:is|runs|starts)|i\s+(work|have lunch|have dinner|have breakfast))\s+(?:from\s+|at\s+)?(\S+(?:\s*[ap]\.?m\.?)?)\s*(?:to|until|till|-)\s*(\S+(?:\s*[ap]\.?m\.?)?)[.!]*$`)
+
+// regexDayPartReset matches users restoring a day part's default, like
+// "reset my evening."
+var regexDayPartReset = regexp.MustCompile(`(?i)^(?:reset|forget|clear)\s+my\s+(morning|breakfast|lunch|afternoon|dinner|evening|night|work)(?:\s*time|\s+hours)?[.!]*$`)
+
+var regexClockTime = regexp.MustCompile(`(?i)^(\d{1,2})(?::(\d{2}))?\s*([ap])?\.?(?:m\.?)?$`)
+
+// ResolveFuzzyTime finds a fuzzy time in a sentence, like "this evening,"
+// "tomorrow morning" or "after work," and returns the range of time it spans
+// on the day meant, in now's location. Day parts are those the user has
+// defined, and the defaults for holidays.DefaultCountry otherwise. Without a
+// day, the day part's next occurrence is meant. A nil range is returned if
+// the sentence holds no fuzzy time.
+func ResolveFuzzyTime(u *dt.User, s string, now time.Time) (*dt.TimeRange,
+	error) {
+
+	m := regexFuzzyTime.FindStringSubmatch(s)
+	if m == nil {
+		return nil, nil
+	}
+	ps, err := dayParts(u)
+	if err != nil {
+		return nil, err
+	}
+	day, modifier, name := strings.ToLower(m[1]), strings.ToLower(m[2]),
+		strings.ToLower(m[3])
+	if len(m[4]) > 0 {
+		day = strings.ToLower(m[4])
+	}
+	if day == "tonight" {
+		day = "today"
+	}
+	if len(m[5]) > 0 {
+		// "Tonight" alone spans the evening and night.
+		day, name = "today", "tonight"
+	}
+	var p dt.DayPart
+	if name == "tonight" {
+		ev, _ := findDayPart(ps, "evening")
+		nt, _ := findDayPart(ps, "night")
+		p = dt.DayPart{Name: name, Start: ev.Start, End: nt.End}
+	} else {
+		var ok bool
+		if p, ok = findDayPart(ps, name); !ok {
+			return nil, nil
+		}
+	}
+	start, end := dayPartOn(p, now, 0)
+	switch {
+	case day == "today" || day == "this":
+	case day == "tomorrow":
+		start, end = dayPartOn(p, now, 1)
+	case len(day) > 0:
+		wd := strings.TrimPrefix(strings.TrimPrefix(day, "next "), "on ")
+		days := (int(weekdays[wd]) - int(now.Weekday()) + 7) % 7
+		if days == 0 && (strings.HasPrefix(day, "next ") ||
+			!end.After(now)) {
+			days = 7
+		}
+		start, end = dayPartOn(p, now, days)
+	default:
+		if !end.After(now) {
+			start, end = dayPartOn(p, now, 1)
+		}
+	}
+	switch modifier {
+	case "after":
+		start, end = end, end.Add(dayPartMargin)
+	case "before":
+		start, end = start.Add(-dayPartMargin), start
+	}
+	return &dt.TimeRange{Start: &start, End: &end}, nil
+}
+
+// dayParts returns the user's day parts, or the defaults for
+// holidays.DefaultCountry for unregistered users.
+func dayParts(u *dt.User) ([]dt.DayPart, error) {
+	if u == nil || !u.Registered() {
+		return dt.DefaultDayParts(holidays.DefaultCountry), nil
+	}
+	return u.DayParts(db, holidays.DefaultCountry)
+}
+
+func findDayPart(ps []dt.DayPart, name string) (dt.DayPart, bool) {
+	for _, p := range ps {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return dt.DayPart{}, false
+}
+
+// dayPartOn returns when a day part starts and ends a number of days after
+// now's date.
+func dayPartOn(p dt.DayPart, now time.Time, days int) (time.Time, time.Time) {
+	y, m, d := now.Date()
+	midnight := time.Date(y, m, d+days, 0, 0, 0, 0, now.Location())
+	start := midnight.Add(time.Duration(p.Start) * time.Minute)
+	end := midnight.Add(time.Duration(p.End) * time.Minute)
+	if p.End <= p.Start {
+		end = end.AddDate(0, 0, 1)
+	}
+	return start, end
+}
+
+// manageDayParts answers requests to define when the user's day parts start
+// and end, e.g. "my evening is from 6pm to 10pm" or "I work 8:30 to 5," and to
+// restore their defaults, e.g. "reset my evening." An empty string is
+// returned if the message isn't such a request.
+func manageDayParts(msg *dt.Msg) string {
+	if !msg.User.Registered() {
+		return ""
+	}
+	u := msg.User
+	if m := regexDayPartReset.FindStringSubmatch(msg.Sentence); m != nil {
+		name := strings.ToLower(m[1])
+		err := u.DeleteDayPart(db, name)
+		if err == dt.ErrNoDayPart {
+			return fmt.Sprintf("Your %s is already the default.", name)
+		}
+		if err != nil {
+			log.Info("failed to delete day part", err)
+			return "I'm sorry, I couldn't reset that right now."
+		}
+		auditMsg(msg, AuditPreference, "reset day part "+name)
+		return fmt.Sprintf("OK, your %s is back to the default.", name)
+	}
+	m := regexDayPartDefine.FindStringSubmatch(msg.Sentence)
+	if m == nil {
+		return ""
+	}
+	name := strings.ToLower(m[1])
+	if len(name) == 0 {
+		name = strings.TrimPrefix(strings.ToLower(m[2]), "have ")
+	}
+	start, startMer, ok := parseClockMinutes(m[3])
+	if !ok {
+		return ""
+	}
+	end, endMer, ok := parseClockMinutes(m[4])
+	if !ok {
+		return ""
+	}
+	// "9 to 5" means 5pm, and "8 to 11pm" means 8pm.
+	if !endMer && end <= start && end+12*60 > start {
+		end += 12 * 60
+	}
+	if !startMer && endMer && start+12*60 < end {
+		start += 12 * 60
+	}
+	p := dt.DayPart{Name: name, Start: start, End: end % (24 * 60)}
+	if err := u.SetDayPart(db, p); err != nil {
+		log.Info("failed to set day part", err)
+		return "I'm sorry, I couldn't save that right now."
+	}
+	auditMsg(msg, AuditPreference, "set day part "+name)
+	return fmt.Sprintf("Got it. Your %s is from %s to %s.", name,
+		formatMinutes(p.Start), formatMinutes(p.End))
+}
+
+// parseClockMinutes parses a time of day, like "6pm," "18:00" or "8:30," into
+// minutes after midnight, reporting whether it had an "am" or "pm."
+func parseClockMinutes(s string) (mins int, meridiem bool, ok bool) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "noon":
+		return 12 * 60, true, true
+	case "midnight":
+		return 0, true, true
+	}
+	m := regexClockTime.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false, false
+	}
+	h, _ := strconv.Atoi(m[1])
+	var min int
+	if len(m[2]) > 0 {
+		min, _ = strconv.Atoi(m[2])
+	}
+	if h > 23 || min > 59 {
+		return 0, false, false
+	}
+	switch strings.ToLower(m[3]) {
+	case "a":
+		if h == 12 {
+			h = 0
+		}
+		meridiem = true
+	case "p":
+		if h < 12 {
+			h += 12
+		}
+		meridiem = true
+	}
+	return h*60 + min, meridiem, true
+}
+
+func formatMinutes(mins int) string {
+	t := time.Date(0, 1, 1, mins/60, mins%60, 0, 0, time.UTC)
+	if mins%60 == 0 {
+		return t.Format("3pm")
+	}
+	return t.Format("3:04pm")
+}
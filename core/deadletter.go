@@ -0,0 +1,280 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// inboundAttempts is how many times a user's message is processed before it's
+// saved as a dead letter, so a brief database outage doesn't drop it.
+const inboundAttempts = 3
+
+// maxSendAttempts is how many times a scheduled event is sent before it's
+// swept into the dead letters.
+const maxSendAttempts = 10
+
+// deadLettersShown is the most dead letters of each kind HAPIDeadLetters
+// responds with.
+const deadLettersShown = 100
+
+// Kinds of dead letters.
+const (
+	DeadLetterInbound  = "inbound"
+	DeadLetterOutbound = "outbound"
+)
+
+// ErrUnknownDeadLetter is returned when reprocessing a dead letter that
+// doesn't exist or was already reprocessed.
+var ErrUnknownDeadLetter = errors.New("unknown dead letter")
+
+// DeadLetter is a user's message Abot failed to process or a scheduled event
+// it failed to send, kept for operators to reprocess once the underlying
+// issue is fixed. IDs are unique within each kind.
+type DeadLetter struct {
+	ID         uint64
+	Kind       string
+	Content    string
+	FlexID     string
+	FlexIDType dt.FlexIDType
+	Error      string
+	Attempts   int
+	DeadAt     time.Time
+}
+
+// processWithRetries processes a user's message like ProcessText, retrying
+// failures. Messages still failing are saved as dead letters. A message that
+// failed after it was saved may be saved twice.
+func processWithRetries(body []byte) (string, uint64, error) {
+	var ret string
+	var uid uint64
+	var err error
+	for i := 0; i < inboundAttempts; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * 500 * time.Millisecond)
+		}
+		r, rerr := http.NewRequest("POST", "/", bytes.NewReader(body))
+		if rerr != nil {
+			return "", 0, rerr
+		}
+		if ret, uid, err = ProcessText(r); err == nil {
+			return ret, uid, nil
+		}
+		log.Info("failed to process text", err)
+	}
+	if derr := saveInboundDeadLetter(body, err); derr != nil {
+		log.Info("failed to save dead letter", derr)
+	}
+	return ret, uid, err
+}
+
+// saveInboundDeadLetter saves a message that couldn't be processed. Its
+// payload is sealed like the sentences of messages, since it holds what the
+// user wrote.
+func saveInboundDeadLetter(body []byte, procErr error) error {
+	payload, err := dt.SealSentence(string(body))
+	if err != nil {
+		return err
+	}
+	q := `INSERT INTO inbounddeadletters (payload, error, attempts)
+	      VALUES ($1, $2, $3)`
+	_, err = db.Exec(q, payload, procErr.Error(), inboundAttempts)
+	return err
+}
+
+// markFailed records a failed attempt to send events. Events that fail
+// maxSendAttempts times are no longer retried.
+func markFailed(evts []*dt.ScheduledEvent, sendErr error) {
+	var ids []int64
+	for _, evt := range evts {
+		ids = append(ids, int64(evt.ID))
+	}
+	q := `UPDATE scheduledevents SET attempts=attempts+1, lasterror=$1
+	      WHERE id=ANY($2::integer[])`
+	_, err := db.Exec(q, sendErr.Error(), intArray(ids))
+	if err != nil {
+		log.Info("failed to record failed scheduled event", err)
+	}
+}
+
+// DeadLetters returns the dead letters awaiting reprocessing, most recent
+// first.
+func DeadLetters() ([]DeadLetter, error) {
+	var in []struct {
+		ID        uint64
+		Payload   string
+		Error     string
+		Attempts  int
+		CreatedAt time.Time
+	}
+	q := `SELECT id, payload, error, attempts, createdat
+	      FROM inbounddeadletters
+	      WHERE reprocessedat IS NULL
+	      ORDER BY createdat DESC
+	      LIMIT $1`
+	if err := db.Select(&in, q, deadLettersShown); err != nil {
+		return nil, err
+	}
+	var dls []DeadLetter
+	for _, d := range in {
+		dl := DeadLetter{
+			ID:       d.ID,
+			Kind:     DeadLetterInbound,
+			Error:    d.Error,
+			Attempts: d.Attempts,
+			DeadAt:   d.CreatedAt,
+		}
+		req := &dt.Request{}
+		err := json.Unmarshal([]byte(openSentence(d.Payload)), req)
+		if err == nil {
+			dl.Content = req.CMD
+			dl.FlexID, dl.FlexIDType = req.FlexID, req.FlexIDType
+		}
+		dls = append(dls, dl)
+	}
+	var out []DeadLetter
+	q = `SELECT id, content, COALESCE(flexid, '') AS flexid,
+	          COALESCE(flexidtype, 0) AS flexidtype, lasterror AS error,
+	          attempts, deadat
+	      FROM scheduledevents
+	      WHERE deadat IS NOT NULL
+	      ORDER BY deadat DESC
+	      LIMIT $1`
+	if err := db.Select(&out, q, deadLettersShown); err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i].Kind = DeadLetterOutbound
+	}
+	dls = append(dls, out...)
+	sort.Sort(deadLettersByDeadAt(dls))
+	return dls, nil
+}
+
+// Reprocess retries a dead letter once its underlying issue is fixed. An
+// inbound message is processed again and the reply sent to the user over the
+// channel they wrote on. An outbound event is queued to send again with its
+// attempts reset.
+func Reprocess(kind string, id uint64) error {
+	switch kind {
+	case DeadLetterInbound:
+		return reprocessInbound(id)
+	case DeadLetterOutbound:
+		q := `UPDATE scheduledevents
+		      SET deadat=NULL, attempts=0, lasterror='', sendat=$1
+		      WHERE id=$2 AND deadat IS NOT NULL`
+		res, err := db.Exec(q, time.Now(), id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err == nil && n == 0 {
+			err = ErrUnknownDeadLetter
+		}
+		return err
+	}
+	return ErrUnknownDeadLetter
+}
+
+func reprocessInbound(id uint64) error {
+	var payload string
+	q := `SELECT payload FROM inbounddeadletters
+	      WHERE id=$1 AND reprocessedat IS NULL`
+	err := db.Get(&payload, q, id)
+	if err == sql.ErrNoRows {
+		return ErrUnknownDeadLetter
+	}
+	if err != nil {
+		return err
+	}
+	body := []byte(openSentence(payload))
+	r, err := http.NewRequest("POST", "/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ret, uid, err := ProcessText(r)
+	if err != nil {
+		q = `UPDATE inbounddeadletters
+		     SET error=$1, attempts=attempts+1 WHERE id=$2`
+		if _, uerr := db.Exec(q, err.Error(), id); uerr != nil {
+			log.Info("failed to update dead letter", uerr)
+		}
+		return err
+	}
+	q = `UPDATE inbounddeadletters SET reprocessedat=CURRENT_TIMESTAMP
+	     WHERE id=$1`
+	if _, err = db.Exec(q, id); err != nil {
+		return err
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	// The user's request is long gone, so reply over their channel.
+	req := &dt.Request{}
+	if err = json.Unmarshal(body, req); err != nil {
+		return err
+	}
+	fid, fidT := req.FlexID, req.FlexIDType
+	if len(fid) == 0 {
+		u := &dt.User{ID: uid}
+		if fid, fidT, err = u.LastFlexID(db); err != nil {
+			return err
+		}
+	}
+	return sendEvent(dt.ScheduledEvent{
+		Content:    ret,
+		FlexID:     fid,
+		FlexIDType: fidT,
+	})
+}
+
+// HAPIDeadLetters responds with the dead letters awaiting reprocessing.
+func HAPIDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	dls, err := DeadLetters()
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ DeadLetters []DeadLetter }{DeadLetters: dls})
+}
+
+// HAPIReprocess retries a dead letter. See Reprocess.
+func HAPIReprocess(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct {
+		Kind string
+		ID   uint64
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := Reprocess(req.Kind, req.ID)
+	if err == ErrUnknownDeadLetter || err == ErrRecipientPaused {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type deadLettersByDeadAt []DeadLetter
+
+func (d deadLettersByDeadAt) Len() int           { return len(d) }
+func (d deadLettersByDeadAt) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d deadLettersByDeadAt) Less(i, j int) bool { return d[i].DeadAt.After(d[j].DeadAt) }
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/directions"
+	"github.com/itsabot/abot/shared/interface/directions/driver"
+)
+
+// leaveBuffer is added to travel times when deciding when a user should
+// leave, leaving time to park, find the room, etc.
+const leaveBuffer = 5 * time.Minute
+
+var directionsConn *directions.Conn
+
+// ErrMissingDirectionsDriver is returned when estimating travel times, but no
+// directions driver has been imported.
+var ErrMissingDirectionsDriver = errors.New("missing directions driver")
+
+// Waypoint returns the start or end of a trip described by the user. Names of
+// saved addresses, like "home" or "work," become those addresses, and "here"
+// becomes the location from ResolveHere. Anything else is treated as an
+// address. If the user refers to their location but it isn't known or fresh,
+// dt.ErrNoLocation is returned.
+func Waypoint(u *dt.User, s string) (*driver.Waypoint, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "", "here", "me", "my location", "where i am":
+		l, err := hereLocation(u)
+		if err != nil {
+			return nil, err
+		}
+		return &driver.Waypoint{Lat: l.Lat, Lon: l.Lon}, nil
+	}
+	if u.Registered() {
+		addr, err := u.GetAddress(db, s)
+		if err == nil {
+			return &driver.Waypoint{Address: formatAddress(addr)}, nil
+		}
+		if err != dt.ErrNoAddress {
+			return nil, err
+		}
+	}
+	return &driver.Waypoint{Address: s}, nil
+}
+
+// ETA estimates how long it takes to travel between two waypoints leaving
+// now.
+func ETA(from, to *driver.Waypoint, mode driver.Mode) (time.Duration,
+	error) {
+
+	r, err := route(from, to, mode, time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	return r.Duration, nil
+}
+
+// LeaveBy returns when a user should leave one waypoint to arrive at another
+// by a given time, allowing a few minutes to spare.
+func LeaveBy(from, to *driver.Waypoint, mode driver.Mode,
+	arrive time.Time) (time.Time, error) {
+
+	r, err := route(from, to, mode, time.Time{})
+	if err != nil {
+		return time.Time{}, err
+	}
+	// Estimate again for the time the user would leave, since traffic and
+	// transit schedules then may differ from now.
+	if depart := arrive.Add(-r.Duration); depart.After(time.Now()) {
+		r, err = route(from, to, mode, depart)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	return arrive.Add(-r.Duration - leaveBuffer).Truncate(time.Minute), nil
+}
+
+// route finds the fastest route between two waypoints. If the directions
+// driver requires coordinates, addresses are geocoded through the places
+// driver.
+func route(from, to *driver.Waypoint, mode driver.Mode,
+	departAt time.Time) (*driver.Route, error) {
+
+	if directionsConn == nil {
+		return nil, ErrMissingDirectionsDriver
+	}
+	q := &driver.Query{From: from, To: to, Mode: mode, DepartAt: departAt}
+	r, err := directionsConn.Route(q)
+	if err != driver.ErrCoordinatesRequired {
+		return r, err
+	}
+	if q.From, err = geocodeWaypoint(from); err != nil {
+		return nil, err
+	}
+	if q.To, err = geocodeWaypoint(to); err != nil {
+		return nil, err
+	}
+	return directionsConn.Route(q)
+}
+
+func geocodeWaypoint(w *driver.Waypoint) (*driver.Waypoint, error) {
+	if w.HasCoordinates() {
+		return w, nil
+	}
+	g := geocoder()
+	if g == nil {
+		return nil, driver.ErrCoordinatesRequired
+	}
+	ps, err := g.Geocode(w.Address, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(ps) == 0 {
+		return nil, driver.ErrNoRoute
+	}
+	return &driver.Waypoint{Address: w.Address, Lat: ps[0].Lat,
+		Lon: ps[0].Lon}, nil
+}
+
+// formatAddress writes an address on one line for directions services, e.g.
+// "1 Main St, Apt 2, Boston, MA 02110."
+func formatAddress(a *dt.Address) string {
+	parts := []string{a.Line1}
+	if len(a.Line2) > 0 {
+		parts = append(parts, a.Line2)
+	}
+	parts = append(parts, a.City, strings.TrimSpace(fmt.Sprintf("%s %s",
+		a.State, a.Zip)))
+	return strings.Join(parts, ", ")
+}
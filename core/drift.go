@@ -0,0 +1,355 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// defaultDriftSampleRate is the share of messages sampled for trainers to
+// spot-check how they were classified. Operators can change it with the
+// drift_sample_rate setting, e.g. "0.05."
+const defaultDriftSampleRate = 0.02
+
+// defaultDriftAlertDrop is how far a class's agreement must fall below its
+// baseline before ADMIN_EMAIL is alerted. Operators can change it with the
+// drift_alert_drop setting, e.g. "0.15."
+const defaultDriftAlertDrop = 0.1
+
+// driftRecentWindow is the period whose agreement is compared against the
+// driftBaselineWindow before it.
+const (
+	driftRecentWindow   = 7 * 24 * time.Hour
+	driftBaselineWindow = 28 * 24 * time.Hour
+)
+
+// minDriftChecks is the fewest checks of a class in each window needed to
+// compare them, so a couple of mistakes in a rarely used plugin don't raise
+// an alert.
+const minDriftChecks = 20
+
+// Sources of the expected classification in checks.
+const (
+	// CheckHuman checks are sampled from production and spot-checked by a
+	// trainer.
+	CheckHuman = "human"
+
+	// CheckShadow checks compare against a candidate model run alongside
+	// the live one.
+	CheckShadow = "shadow"
+
+	// CheckFallback checks label messages Abot didn't understand with the
+	// plugin that should have handled them.
+	CheckFallback = "fallback"
+
+	// CheckCorrection checks are users' corrections of how their own
+	// messages were routed, made with "/correct."
+	CheckCorrection = "correction"
+)
+
+// ErrCheckNotFound is returned when spot-checking a sample that doesn't exist
+// or has already been checked.
+var ErrCheckNotFound = errors.New("classification check not found")
+
+// ClassificationCheck compares the plugin a message was routed to with the
+// plugin it should have been routed to, according to a trainer or a shadow
+// model. An empty Plugin means the message wasn't routed to any plugin.
+// Expected is empty until the check is done.
+type ClassificationCheck struct {
+	ID        uint64
+	MessageID uint64
+	Sentence  string
+	Plugin    string
+	Expected  string
+	Source    string
+	CheckedBy string
+	CheckedAt *time.Time
+	CreatedAt time.Time
+}
+
+// ClassAgreement is how often checks of messages routed to a plugin agreed
+// with the live classification.
+type ClassAgreement struct {
+	Plugin    string
+	Source    string
+	Checks    int
+	Agreed    int
+	Agreement float64
+}
+
+func init() {
+	RegisterJob("classification_drift", 24*time.Hour, checkDrift)
+	RegisterMetric("classification_agreement", func() (interface{},
+		error) {
+		now := time.Now()
+		return Agreement(now.Add(-driftRecentWindow), now)
+	})
+}
+
+func settingFloat(name string, def float64) float64 {
+	s := Setting(name)
+	if len(s) == 0 {
+		return def
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || f > 1 {
+		log.Info("invalid setting", name, s)
+		return def
+	}
+	return f
+}
+
+// sampleClassification randomly samples a message for trainers to spot-check
+// how it was classified. Failures are logged, since sampling mustn't affect
+// the response.
+func sampleClassification(in *dt.Msg) {
+	if rand.Float64() >= settingFloat("drift_sample_rate",
+		defaultDriftSampleRate) {
+		return
+	}
+	q := `INSERT INTO classificationchecks (messageid, plugin, source)
+	      VALUES ($1, $2, $3)`
+	if _, err := db.Exec(q, in.ID, in.Plugin, CheckHuman); err != nil {
+		log.Info("failed to sample classification", err)
+	}
+}
+
+// RecordClassificationCheck saves a completed check of a message's
+// classification, e.g. from a shadow model.
+func RecordClassificationCheck(msgID uint64, plugin, expected, source,
+	checkedBy string) error {
+
+	q := `INSERT INTO classificationchecks
+	      (messageid, plugin, expected, source, checkedby, checkedat)
+	      VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)`
+	_, err := db.Exec(q, msgID, plugin, expected, source, checkedBy)
+	return err
+}
+
+// PendingClassificationChecks returns sampled messages awaiting a trainer's
+// spot-check, oldest first.
+func PendingClassificationChecks(limit int) ([]ClassificationCheck, error) {
+	q := `SELECT cc.id, cc.messageid, COALESCE(m.sentence, '') AS sentence,
+	          cc.plugin, cc.source, cc.createdat
+	      FROM classificationchecks AS cc
+	      JOIN messages AS m ON m.id=cc.messageid
+	      WHERE cc.checkedat IS NULL
+	      ORDER BY cc.id
+	      LIMIT $1`
+	var checks []ClassificationCheck
+	if err := db.Select(&checks, q, limit); err != nil {
+		return nil, err
+	}
+	for i := range checks {
+		checks[i].Sentence = openSentence(checks[i].Sentence)
+	}
+	return checks, nil
+}
+
+// CheckClassification records the plugin a trainer says a sampled message
+// should have been routed to, or "" if none.
+func CheckClassification(id uint64, expected, checkedBy string) error {
+	q := `UPDATE classificationchecks
+	      SET expected=$1, checkedby=$2, checkedat=CURRENT_TIMESTAMP
+	      WHERE id=$3 AND checkedat IS NULL`
+	res, err := db.Exec(q, expected, checkedBy, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrCheckNotFound
+	}
+	return nil
+}
+
+// Agreement returns the agreement of each class checked between from and to,
+// by source.
+func Agreement(from, to time.Time) ([]ClassAgreement, error) {
+	q := `SELECT plugin, source, COUNT(*) AS checks,
+	          COUNT(*) FILTER (WHERE expected=plugin) AS agreed
+	      FROM classificationchecks
+	      WHERE checkedat>=$1 AND checkedat<$2
+	      GROUP BY plugin, source
+	      ORDER BY plugin, source`
+	var as []ClassAgreement
+	if err := db.Select(&as, q, from, to); err != nil {
+		return nil, err
+	}
+	for i := range as {
+		as[i].Agreement = float64(as[i].Agreed) / float64(as[i].Checks)
+	}
+	return as, nil
+}
+
+// checkDrift alerts ADMIN_EMAIL of classes whose agreement this week fell
+// well below their agreement over the weeks before, catching a model's decay
+// before users complain.
+func checkDrift() error {
+	now := time.Now()
+	split := now.Add(-driftRecentWindow)
+	recent, err := Agreement(split, now)
+	if err != nil {
+		return err
+	}
+	baseline, err := Agreement(split.Add(-driftBaselineWindow), split)
+	if err != nil {
+		return err
+	}
+	base := map[string]ClassAgreement{}
+	for _, a := range baseline {
+		base[a.Source+"/"+a.Plugin] = a
+	}
+	drop := settingFloat("drift_alert_drop", defaultDriftAlertDrop)
+	var content string
+	for _, a := range recent {
+		b, ok := base[a.Source+"/"+a.Plugin]
+		if !ok || a.Checks < minDriftChecks || b.Checks < minDriftChecks {
+			continue
+		}
+		if b.Agreement-a.Agreement < drop {
+			continue
+		}
+		name := a.Plugin
+		if len(name) == 0 {
+			name = "(no plugin)"
+		}
+		content += fmt.Sprintf("%s (%s checks): %.0f%% agreement this week, down from %.0f%%\n",
+			name, a.Source, a.Agreement*100, b.Agreement*100)
+	}
+	if len(content) == 0 {
+		return nil
+	}
+	return notifyAdmin("Classification drift detected", content)
+}
+
+// HAPIClassificationChecks responds with sampled messages awaiting a
+// spot-check along with this week's agreement of each class.
+func HAPIClassificationChecks(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	_, limit, err := pageParams(r)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	checks, err := PendingClassificationChecks(limit)
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	now := time.Now()
+	as, err := Agreement(now.Add(-driftRecentWindow), now)
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct {
+		Checks    []ClassificationCheck
+		Agreement []ClassAgreement
+	}{Checks: checks, Agreement: as})
+}
+
+// HAPIClassificationChecksSubmit records a trainer's spot-check of a sampled
+// message.
+func HAPIClassificationChecksSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct {
+		ID       uint64
+		Expected string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := CheckClassification(req.ID, req.Expected, operatorName(r))
+	if err == ErrCheckNotFound {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Fallbacks returns messages Abot didn't understand that haven't been labeled
+// with the plugin that should have handled them, newest first.
+func Fallbacks(limit int) ([]TranscriptMsg, error) {
+	q := `SELECT m.id, COALESCE(m.sentence, '') AS sentence, m.abotsent,
+	          COALESCE(m.commands, '{}') AS commands,
+	          COALESCE(m.objects, '{}') AS objects,
+	          COALESCE(m.plugin, '') AS plugin,
+	          COALESCE(m.route, '') AS route, m.needstraining, m.createdat
+	      FROM messages AS m
+	      WHERE m.needstraining AND NOT EXISTS (
+	          SELECT 1 FROM classificationchecks AS cc
+	          WHERE cc.messageid=m.id AND cc.source IN ($1, $2))
+	      ORDER BY m.id DESC
+	      LIMIT $3`
+	var msgs []TranscriptMsg
+	err := db.Select(&msgs, q, CheckFallback, CheckCorrection, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range msgs {
+		msgs[i].Sentence = openSentence(msgs[i].Sentence)
+	}
+	return msgs, nil
+}
+
+// HAPIFallbacks responds with messages Abot didn't understand awaiting a
+// label.
+func HAPIFallbacks(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	_, limit, err := pageParams(r)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	msgs, err := Fallbacks(limit)
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Messages []TranscriptMsg }{Messages: msgs})
+}
+
+// HAPIFallbacksSubmit labels a message Abot didn't understand with the plugin
+// that should have handled it, or "" if none should have, for retraining.
+func HAPIFallbacksSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct {
+		MessageID uint64
+		Expected  string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := RecordClassificationCheck(req.MessageID, "", req.Expected,
+		CheckFallback, operatorName(r))
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
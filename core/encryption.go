@@ -0,0 +1,115 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// sealedPrefix marks sentences encrypted by sentenceCipher, so those saved
+// before encryption was enabled can still be read.
+const sealedPrefix = "enc1:"
+
+// errSealedTooShort is returned when opening a value too short to have been
+// sealed.
+var errSealedTooShort = errors.New("sealed value too short")
+
+func init() {
+	dt.SetMessageCipher(sentenceCipher{})
+}
+
+// sentenceCipher encrypts the sentences of messages with AES-GCM when
+// ABOT_ENCRYPT_MESSAGES is "true," with a key for each tenant derived from
+// ABOT_SECRET, so a leaked database doesn't leak conversations and one
+// tenant's key can't read another's. Sentences are decrypted only as Abot
+// reads them, so text search in the admin console doesn't match encrypted
+// messages. Changing ABOT_SECRET makes encrypted messages unreadable.
+type sentenceCipher struct{}
+
+// Seal encrypts a sentence if encryption is enabled.
+func (sentenceCipher) Seal(sentence string) (string, error) {
+	if os.Getenv("ABOT_ENCRYPT_MESSAGES") != "true" || len(sentence) == 0 {
+		return sentence, nil
+	}
+	b, err := sealGCM(sentenceKey(), []byte(sentence))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// Open decrypts a sentence if it was encrypted, whether or not encryption is
+// still enabled.
+func (sentenceCipher) Open(saved string) (string, error) {
+	if !strings.HasPrefix(saved, sealedPrefix) {
+		return saved, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(saved[len(sealedPrefix):])
+	if err != nil {
+		return "", err
+	}
+	if b, err = openGCM(sentenceKey(), b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// sentenceKey is the key the tenant's sentences are encrypted with.
+func sentenceKey() []byte {
+	k := sha256.Sum256([]byte(os.Getenv("ABOT_SECRET") + ":messages:" +
+		tenant()))
+	return k[:]
+}
+
+// openSentence decrypts a sentence read from the database, logging failures
+// and returning an empty sentence rather than ciphertext.
+func openSentence(s string) string {
+	opened, err := dt.OpenSentence(s)
+	if err != nil {
+		log.Info("failed to decrypt sentence", err)
+		return ""
+	}
+	return opened
+}
+
+// sealGCM encrypts with AES-GCM, prefixing the nonce.
+func sealGCM(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openGCM decrypts a value sealed by sealGCM.
+func openGCM(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	n := gcm.NonceSize()
+	if len(sealed) < n {
+		return nil, errSealedTooShort
+	}
+	return gcm.Open(nil, sealed[:n], sealed[n:], nil)
+}
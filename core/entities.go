@@ -0,0 +1,252 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// entityConfidence is the confidence of words classified as entities, which
+// were named deliberately by operators or packages.
+const entityConfidence = 1
+
+// ErrInvalidEntity is returned when training an entity without a name or
+// type.
+var ErrInvalidEntity = errors.New("invalid entity")
+
+// ErrEntityNotFound is returned when forgetting an entity that isn't in the
+// gazetteer.
+var ErrEntityNotFound = errors.New("entity not found")
+
+// GazetteerEntity is an entity operators have taught Abot for this tenant,
+// like a product name, so it's recognized as one however many words it has.
+type GazetteerEntity struct {
+	ID   uint64
+	Name string
+
+	// Type is what the entity is, e.g. nlp.EntityProduct or "wine."
+	// People and businesses are classified as Actors, and everything else
+	// as Objects.
+	Type string
+
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// registeredEntities are the entity lists registered by packages, by type.
+var registeredEntities = map[string][]string{}
+var registeredEntitiesMu sync.Mutex
+
+var gazetteer = nlp.NewGazetteer()
+var gazetteerMu sync.RWMutex
+
+// RegisterEntities adds a package's list of domain entities, like restaurant
+// names or wine labels, to the gazetteer, so each is recognized as a single
+// Actor or Object. People and businesses, nlp.EntityPerson and
+// nlp.EntityBusiness, are Actors, and other types Objects. Lists are kept in
+// memory, so packages should register them when they're loaded.
+func RegisterEntities(typ string, names []string) {
+	typ = dt.NormalizeTag(typ)
+	registeredEntitiesMu.Lock()
+	registeredEntities[typ] = append(registeredEntities[typ], names...)
+	registeredEntitiesMu.Unlock()
+	gazetteerMu.Lock()
+	for _, name := range names {
+		gazetteer.Add(name, typ)
+	}
+	gazetteerMu.Unlock()
+}
+
+// GazetteerEntities returns the entities operators have taught Abot for this
+// tenant, by name.
+func GazetteerEntities() ([]GazetteerEntity, error) {
+	q := `SELECT id, name, type, createdby, createdat
+	      FROM entities
+	      WHERE tenant=$1
+	      ORDER BY name`
+	var es []GazetteerEntity
+	if err := db.Select(&es, q, tenant()); err != nil {
+		return nil, err
+	}
+	return es, nil
+}
+
+// TrainEntity teaches Abot an entity for this tenant, replacing the type of
+// any already taught by the same name. It's recognized immediately.
+func TrainEntity(e *GazetteerEntity, trainedBy string) error {
+	e.Name = strings.TrimSpace(e.Name)
+	e.Type = dt.NormalizeTag(e.Type)
+	if len(e.Name) == 0 || len(e.Type) == 0 {
+		return ErrInvalidEntity
+	}
+	e.CreatedBy = trainedBy
+	q := `INSERT INTO entities (tenant, name, type, createdby)
+	      VALUES ($1, $2, $3, $4)
+	      ON CONFLICT (tenant, name) DO UPDATE
+	      SET type=$3, createdby=$4, createdat=CURRENT_TIMESTAMP
+	      RETURNING id, createdat`
+	err := db.QueryRowx(q, tenant(), e.Name, e.Type, trainedBy).Scan(&e.ID,
+		&e.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return loadGazetteer()
+}
+
+// ForgetEntity removes an entity operators taught Abot by name.
+func ForgetEntity(name string) error {
+	q := `DELETE FROM entities WHERE tenant=$1 AND name=$2`
+	res, err := db.Exec(q, tenant(), strings.TrimSpace(name))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrEntityNotFound
+	}
+	return loadGazetteer()
+}
+
+// loadGazetteer compiles the entities packages registered and operators
+// taught Abot for this tenant. Those taught by operators take precedence.
+func loadGazetteer() error {
+	es, err := GazetteerEntities()
+	if err != nil {
+		return err
+	}
+	g := nlp.NewGazetteer()
+	registeredEntitiesMu.Lock()
+	for typ, names := range registeredEntities {
+		for _, name := range names {
+			g.Add(name, typ)
+		}
+	}
+	registeredEntitiesMu.Unlock()
+	for _, e := range es {
+		g.Add(e.Name, e.Type)
+	}
+	gazetteerMu.Lock()
+	gazetteer = g
+	gazetteerMu.Unlock()
+	return nil
+}
+
+// findEntities returns the entities named in a tokenized sentence.
+func findEntities(tokens []string) []nlp.Entity {
+	gazetteerMu.RLock()
+	defer gazetteerMu.RUnlock()
+	return nlp.FindEntities(tokens, gazetteer)
+}
+
+// entitiesStage finds the people, businesses and things named in the
+// message's tokens, so they're classified as single words.
+func entitiesStage(in *dt.Msg) error {
+	in.StructuredInput.Entities = findEntities(in.Tokens)
+	return nil
+}
+
+// classifyWithEntities classifies a tokenized sentence, adding each entity as
+// a single Actor or Object in place of the words naming it. Entities go
+// first, since they were named deliberately.
+func classifyWithEntities(tokens []string,
+	entities []nlp.Entity) *nlp.StructuredInput {
+
+	if len(entities) == 0 {
+		return withVocabulary(LiveModel().ClassifyTokens(tokens), tokens)
+	}
+	named := make([]bool, len(tokens))
+	var wc []nlp.WordClass
+	for _, e := range entities {
+		if e.Start < 0 || e.Start+e.Len > len(tokens) {
+			continue
+		}
+		for i := e.Start; i < e.Start+e.Len; i++ {
+			named[i] = true
+		}
+		wc = append(wc, nlp.WordClass{Word: strings.ToLower(e.Name),
+			Class: e.Class, Confidence: entityConfidence})
+	}
+	var rest []string
+	for i, t := range tokens {
+		if !named[i] {
+			rest = append(rest, t)
+		}
+	}
+	si := withVocabulary(LiveModel().ClassifyTokens(rest), rest)
+	out := &nlp.StructuredInput{}
+	if err := out.Add(wc); err != nil {
+		return si
+	}
+	_ = out.Add(si.Classes)
+	return out
+}
+
+// HAPIEntities responds with the entities operators have taught Abot for this
+// tenant.
+func HAPIEntities(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	es, err := GazetteerEntities()
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Entities []GazetteerEntity }{Entities: es})
+}
+
+// HAPIEntitiesSubmit teaches Abot an entity, e.g. {"Name": "Widget Pro",
+// "Type": "product"}.
+func HAPIEntitiesSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var e GazetteerEntity
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := TrainEntity(&e, operatorName(r))
+	if err == ErrInvalidEntity {
+		writeErrorBadRequest(w, fmt.Errorf("%s: name and type are required",
+			err))
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, e)
+}
+
+// HAPIEntitiesDelete forgets an entity by name.
+func HAPIEntitiesDelete(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct{ Name string }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := ForgetEntity(req.Name)
+	if err == ErrEntityNotFound {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// defaultEscalationWindow is how long an urgent message may go unanswered
+// before it's resent on the user's next channel. Operators can change it with
+// the escalation_window_minutes setting.
+const defaultEscalationWindow = 15 * time.Minute
+
+// maxEscalationAge is how long after an urgent message was sent Abot keeps
+// trying to reach the user. After that it's likely too late to matter.
+const maxEscalationAge = 24 * time.Hour
+
+// Reasons urgent messages are escalated to another channel.
+const (
+	EscalationUndelivered = "undelivered"
+	EscalationUnread      = "unread"
+)
+
+// Escalation records an urgent message being resent on another of the user's
+// channels, e.g. email after SMS. Together an event's escalations form a chain
+// of every channel tried, in order.
+type Escalation struct {
+	ID               uint64
+	ScheduledEventID uint64
+	Content          string
+	FlexID           string
+	FlexIDType       dt.FlexIDType
+	Reason           string
+	CreatedAt        time.Time
+}
+
+func init() {
+	RegisterJob("escalations", time.Minute, checkEscalations)
+}
+
+func escalationWindow() time.Duration {
+	s := Setting("escalation_window_minutes")
+	if len(s) == 0 {
+		return defaultEscalationWindow
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		log.Info("invalid escalation window", s)
+		return defaultEscalationWindow
+	}
+	return time.Duration(n) * time.Minute
+}
+
+// escalate resends an event on the most recently used of the recipient's
+// channels that hasn't been tried yet, recording the attempt whether or not it
+// succeeds. It returns false if no channels remain or the send failed.
+func escalate(evt *dt.ScheduledEvent, reason string) (bool, error) {
+	u, err := evt.Recipient(db)
+	if err != nil || u == nil {
+		return false, err
+	}
+	paused, err := u.Paused(db)
+	if err != nil || paused {
+		return false, err
+	}
+	q := `SELECT flexid, flexidtype FROM userflexids
+	      WHERE userid=$1 AND NOT (flexid=$2 AND flexidtype=$3)
+	        AND (flexid, flexidtype) NOT IN (
+	            SELECT flexid, flexidtype FROM escalations
+	            WHERE scheduledeventid=$4)
+	      ORDER BY createdat DESC
+	      LIMIT 1`
+	next := *evt
+	err = db.QueryRowx(q, u.ID, evt.FlexID, evt.FlexIDType,
+		evt.ID).Scan(&next.FlexID, &next.FlexIDType)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	q = `INSERT INTO escalations
+	     (scheduledeventid, flexid, flexidtype, reason)
+	     VALUES ($1, $2, $3, $4)`
+	_, err = db.Exec(q, evt.ID, next.FlexID, next.FlexIDType, reason)
+	if err != nil {
+		return false, err
+	}
+	if err = sendRendered(next); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// escalateUndelivered tries another channel when an urgent event couldn't be
+// sent. If that succeeds, the event is marked sent, so the original channel
+// isn't retried. Otherwise it's retried next minute like any other event.
+func escalateUndelivered(evt *dt.ScheduledEvent) {
+	ok, err := escalate(evt, EscalationUndelivered)
+	if err != nil {
+		log.Info("failed to escalate scheduled event", evt.ID, err)
+	}
+	if ok {
+		markSent([]*dt.ScheduledEvent{evt})
+	}
+}
+
+// checkEscalations resends urgent events that the user hasn't replied to
+// within the escalation window on their next channel, since a reply is the
+// only sign that a message was read.
+func checkEscalations() error {
+	now := time.Now()
+	var evts []struct {
+		dt.ScheduledEvent
+		UserID uint64
+	}
+	q := `SELECT se.id, se.content, se.flexid, se.flexidtype, se.priority,
+	          se.category, uf.userid
+	      FROM scheduledevents AS se
+	      JOIN userflexids AS uf
+	        ON uf.flexid=se.flexid AND uf.flexidtype=se.flexidtype
+	      WHERE se.sent AND se.sentat>$1
+	        AND COALESCE((SELECT MAX(createdat) FROM escalations
+	            WHERE scheduledeventid=se.id), se.sentat)<=$2
+	        AND (se.priority=$3 OR se.category IN (
+	            SELECT category FROM notificationpolicies
+	            WHERE userid=uf.userid AND priority=$3))
+	        AND NOT EXISTS (
+	            SELECT 1 FROM messages AS m
+	            WHERE m.userid=uf.userid AND m.abotsent IS FALSE
+	              AND m.createdat>se.sentat)`
+	err := db.Select(&evts, q, now.Add(-maxEscalationAge),
+		now.Add(-escalationWindow()), dt.PriorityUrgent)
+	if err != nil {
+		return err
+	}
+	for i := range evts {
+		evt := &evts[i].ScheduledEvent
+		// The user may have made the event's category less urgent
+		// since it was sent.
+		pri, err := evt.EffectivePriority(db)
+		if err != nil {
+			return err
+		}
+		if pri != dt.PriorityUrgent {
+			continue
+		}
+		if _, err = escalate(evt, EscalationUnread); err != nil {
+			log.Info("failed to escalate scheduled event", evt.ID,
+				err)
+		}
+	}
+	return nil
+}
+
+// Escalations returns the escalations of urgent messages sent to a user in
+// the past day, oldest first, so each message's chain reads in order.
+func Escalations(uid uint64) ([]Escalation, error) {
+	q := `SELECT e.id, e.scheduledeventid, se.content, e.flexid,
+	          e.flexidtype, e.reason, e.createdat
+	      FROM escalations AS e
+	      JOIN scheduledevents AS se ON se.id=e.scheduledeventid
+	      JOIN userflexids AS uf
+	        ON uf.flexid=se.flexid AND uf.flexidtype=se.flexidtype
+	      WHERE uf.userid=$1 AND e.createdat>$2
+	      ORDER BY e.scheduledeventid, e.id`
+	var escs []Escalation
+	err := db.Select(&escs, q, uid, time.Now().Add(-maxEscalationAge))
+	if err != nil {
+		return nil, err
+	}
+	return escs, nil
+}
+
+// HAPIEscalations responds with the escalations of urgent messages sent to a
+// user in the past day.
+func HAPIEscalations(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	uid, err := strconv.ParseUint(r.URL.Query().Get("userid"), 10, 64)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	escs, err := Escalations(uid)
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Escalations []Escalation }{Escalations: escs})
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// Classes of words in an Explanation.
+const (
+	ClassCommand     = "command"
+	ClassObject      = "object"
+	ClassParticipant = "participant"
+	ClassTime        = "time"
+	ClassStopword    = "stopword"
+)
+
+// Explanation shows why each word of a message was classified as it was and
+// how those classes led to its route, so operators can debug surprises like
+// "May" being taken for a person. The message is classified again by the
+// current dictionaries, model and settings, which may differ from those when
+// it was received. Plugin and Route are how it was routed then.
+type Explanation struct {
+	MessageID uint64
+	Sentence  string
+	Plugin    string
+	Route     string
+
+	Words    []WordExplanation
+	Commands []string
+	Objects  []string
+	Actors   []string
+
+	// ExplainedRoute is the route the message would take now, and
+	// RouteReason says why. Candidates are every intent it matches, best
+	// first. Messages continuing a conversation may have been routed by its
+	// state instead.
+	ExplainedRoute string
+	RouteReason    string
+	Candidates     []RouteCandidate
+}
+
+// WordExplanation lists the classes of a word in a message. Corrected is the
+// word the spellchecker replaced it with, if any.
+type WordExplanation struct {
+	Word      string
+	Corrected string
+	Stem      string
+	Classes   []WordClass
+}
+
+// WordClass is a class given to a word, the reason it was given and the rule,
+// dictionary or model responsible.
+type WordClass struct {
+	Class  string
+	Reason string
+	Source string
+}
+
+// Explain classifies a saved message word by word, explaining each class.
+func Explain(msgID uint64) (*Explanation, error) {
+	e := &Explanation{MessageID: msgID}
+	q := `SELECT COALESCE(sentence, ''), COALESCE(plugin, ''),
+	          COALESCE(route, '')
+	      FROM messages WHERE id=$1`
+	row := db.QueryRowx(q, msgID)
+	if err := row.Scan(&e.Sentence, &e.Plugin, &e.Route); err != nil {
+		return nil, err
+	}
+	e.Sentence = openSentence(e.Sentence)
+	tokens := nlp.TokenTexts(nlp.NormalizeTokens(
+		nlp.SentenceTokens(e.Sentence), nil))
+	stop := stopwordMask(tokens, parserLang)
+	var kept []string
+	for i, t := range tokens {
+		if !stop[i] {
+			kept = append(kept, t)
+		}
+	}
+	corrected := Spellcheck(kept)
+	ws := make([]WordExplanation, len(tokens))
+	var k int
+	for i, t := range tokens {
+		ws[i].Word = t
+		if stop[i] {
+			ws[i].Classes = append(ws[i].Classes, WordClass{
+				Class:  ClassStopword,
+				Reason: "filler word, ignored when classifying",
+				Source: "stopwords_" + parserLang,
+			})
+			continue
+		}
+		c := corrected[k]
+		k++
+		if c != t {
+			ws[i].Corrected = c
+		}
+		ws[i].Stem = nlp.StemTokens([]string{c})[0]
+		ws[i].Classes = append(ws[i].Classes, explainWord(c, ws[i].Stem)...)
+	}
+	explainParticipants(tokens, ws)
+	if _, ok := extractOnlyTime(e.Sentence, time.Now()); ok {
+		explainTimes(ws)
+	}
+	e.Words = ws
+
+	si := classifyWithEntities(corrected, findEntities(kept))
+	e.Commands, e.Objects, e.Actors = si.Commands, si.Objects, si.Actors
+	d := routeMsg(si, corrected, "")
+	e.ExplainedRoute, e.RouteReason = d.Route, d.Reason
+	e.Candidates = d.Candidates
+	return e, nil
+}
+
+// explainWord explains the commands and objects a word was classified as by
+// the dictionaries and the live model.
+func explainWord(word, stem string) []WordClass {
+	var cs []WordClass
+	lower := strings.ToLower(word)
+	ner := NER()
+	if _, ok := ner["C"+lower]; ok {
+		cs = append(cs, WordClass{
+			Class:  ClassCommand,
+			Reason: "listed as a verb",
+			Source: "data/ner/verbs.txt",
+		})
+	}
+	if _, ok := ner["O"+lower]; ok {
+		cs = append(cs, WordClass{
+			Class:  ClassObject,
+			Reason: "listed as a noun, adjective or adverb",
+			Source: "data/ner",
+		})
+	}
+	m := LiveModel()
+	if m == nil {
+		return cs
+	}
+	route, ok := m.Learned[stem]
+	if !ok {
+		return cs
+	}
+	parts := strings.SplitN(route, "_", 2)
+	if len(parts) != 2 {
+		return cs
+	}
+	src := "model v" + strconv.Itoa(m.Version)
+	reason := fmt.Sprintf("learned from labeled messages, which mostly routed %q to %s",
+		stem, route)
+	return append(cs,
+		WordClass{Class: ClassCommand, Reason: reason + " (as " + parts[0] + ")",
+			Source: src},
+		WordClass{Class: ClassObject, Reason: reason + " (as " + parts[1] + ")",
+			Source: src})
+}
+
+// explainParticipants explains the words taken for people other than the
+// user, following nlp.ExtractParticipants.
+func explainParticipants(tokens []string, ws []WordExplanation) {
+	for _, m := range mentions(tokens) {
+		c := WordClass{
+			Class:  ClassParticipant,
+			Reason: "capitalized and not the first word, so taken for a name",
+			Source: "nlp.ExtractParticipants",
+		}
+		if len(m.Relationship) > 0 {
+			c.Reason = fmt.Sprintf("%q names a relationship to the user",
+				m.Mention)
+		}
+		for j := m.Start; j < m.Start+m.Len; j++ {
+			ws[j].Classes = append(ws[j].Classes, c)
+		}
+	}
+}
+
+// explainTimes explains the words making up the time in a message holding
+// nothing but one, which amends the time of a recent event.
+func explainTimes(ws []WordExplanation) {
+	for i := range ws {
+		w := strings.ToLower(ws[i].Word)
+		_, clock := clockWords[w]
+		_, day := dayWords[w]
+		_, weekday := weekdays[w]
+		if !clock && !day && !weekday && !regexClock.MatchString(w) {
+			continue
+		}
+		ws[i].Classes = append(ws[i].Classes, WordClass{
+			Class:  ClassTime,
+			Reason: "part of a time in a message holding nothing else, so taken to amend a recent event's time",
+			Source: "extractOnlyTime",
+		})
+	}
+}
+
+// HAPIExplain responds with an explanation of how the message given by the id
+// query parameter was classified.
+func HAPIExplain(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	e, err := Explain(id)
+	if err == sql.ErrNoRows {
+		writeErrorBadRequest(w, fmt.Errorf("message %d not found", id))
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, e)
+}
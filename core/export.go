@@ -0,0 +1,252 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/helpers/redact"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// Formats of exported corpora.
+const (
+	// FormatCoNLL writes a token and its IOB tag per line, tab separated,
+	// with a blank line after each sentence. Comment lines starting with
+	// "#" give each sentence's message ID, plugin and route.
+	FormatCoNLL = "conll"
+
+	// FormatSpans writes a JSON object per line with each sentence's text
+	// and the character offsets of its tagged spans, as imported by
+	// annotation tools like Prodigy and Label Studio.
+	FormatSpans = "jsonl"
+)
+
+// IOB tags of classified words, prefixed by "B-" for the first token of a
+// span and "I-" for the rest. Untagged tokens are "O."
+const (
+	TagCommand = "CMD"
+	TagObject  = "OBJ"
+	TagPerson  = "PER"
+)
+
+// ErrUnknownFormat is returned when exporting to a format Abot doesn't
+// support.
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// TaggedSentence is a user's message with each of its tokens tagged by how it
+// was classified.
+type TaggedSentence struct {
+	MessageID uint64
+	Text      string
+	Plugin    string
+	Route     string
+	Tokens    []string
+	Tags      []string
+}
+
+// Span is a tagged run of characters in a sentence.
+type Span struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Label string `json:"label"`
+}
+
+// spansLine is a sentence in FormatSpans.
+type spansLine struct {
+	Text  string `json:"text"`
+	Spans []Span `json:"spans"`
+	Meta  struct {
+		ID     uint64 `json:"id"`
+		Plugin string `json:"plugin"`
+		Route  string `json:"route"`
+	} `json:"meta"`
+}
+
+// TagSentence tags a message's tokens with the commands and objects it was
+// classified with when received and the people it mentions.
+func TagSentence(m *TranscriptMsg) *TaggedSentence {
+	ts := &TaggedSentence{
+		MessageID: m.ID,
+		Text:      m.Sentence,
+		Plugin:    m.Plugin,
+		Route:     m.Route,
+		Tokens:    nlp.TokenizeSentence(m.Sentence),
+	}
+	ts.Tags = make([]string, len(ts.Tokens))
+	cmds, objs := map[string]struct{}{}, map[string]struct{}{}
+	for _, c := range m.Commands {
+		cmds[strings.ToLower(c)] = struct{}{}
+	}
+	for _, o := range m.Objects {
+		objs[strings.ToLower(o)] = struct{}{}
+	}
+	for i, t := range ts.Tokens {
+		ts.Tags[i] = "O"
+		t = strings.ToLower(t)
+		if _, ok := cmds[t]; ok {
+			ts.Tags[i] = "B-" + TagCommand
+		} else if _, ok = objs[t]; ok {
+			ts.Tags[i] = "B-" + TagObject
+		}
+	}
+	for _, m := range mentions(ts.Tokens) {
+		ts.Tags[m.Start] = "B-" + TagPerson
+		for j := m.Start + 1; j < m.Start+m.Len; j++ {
+			ts.Tags[j] = "I-" + TagPerson
+		}
+	}
+	return ts
+}
+
+// Spans returns the character offsets of the sentence's tagged spans.
+func (ts *TaggedSentence) Spans() []Span {
+	var spans []Span
+	var pos int
+	for i, t := range ts.Tokens {
+		start := strings.Index(ts.Text[pos:], t)
+		if start < 0 {
+			// The tokenizer changed the token, so later offsets
+			// can't be trusted.
+			break
+		}
+		start += pos
+		pos = start + len(t)
+		tag := ts.Tags[i]
+		switch {
+		case strings.HasPrefix(tag, "B-"):
+			spans = append(spans, Span{Start: start, End: pos,
+				Label: tag[2:]})
+		case strings.HasPrefix(tag, "I-") && len(spans) > 0:
+			spans[len(spans)-1].End = pos
+		}
+	}
+	return spans
+}
+
+// WriteCoNLL writes the sentence in FormatCoNLL.
+func (ts *TaggedSentence) WriteCoNLL(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# id = %d\n# plugin = %s\n# route = %s\n",
+		ts.MessageID, ts.Plugin, ts.Route)
+	if err != nil {
+		return err
+	}
+	for i, t := range ts.Tokens {
+		if _, err = fmt.Fprintf(w, "%s\t%s\n", t, ts.Tags[i]); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// WriteSpans writes the sentence in FormatSpans.
+func (ts *TaggedSentence) WriteSpans(w io.Writer) error {
+	spans := ts.Spans()
+	if spans == nil {
+		spans = []Span{}
+	}
+	line := spansLine{Text: ts.Text, Spans: spans}
+	line.Meta.ID, line.Meta.Plugin = ts.MessageID, ts.Plugin
+	line.Meta.Route = ts.Route
+	byt, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", byt)
+	return err
+}
+
+// ExportCorpus writes the messages users sent since a time, tagged by how
+// they were classified, in a format for training external sequence models
+// or annotation tools. Personal information is masked as configured for
+// redact.FieldMessage.
+func ExportCorpus(w io.Writer, format string, since time.Time) error {
+	var write func(*TaggedSentence, io.Writer) error
+	switch format {
+	case FormatCoNLL:
+		write = (*TaggedSentence).WriteCoNLL
+	case FormatSpans:
+		write = (*TaggedSentence).WriteSpans
+	default:
+		return fmt.Errorf("%s: %q", ErrUnknownFormat, format)
+	}
+	q := `SELECT id, COALESCE(sentence, '') AS sentence, abotsent,
+	          COALESCE(commands, '{}') AS commands,
+	          COALESCE(objects, '{}') AS objects,
+	          COALESCE(plugin, '') AS plugin, COALESCE(route, '') AS route,
+	          COALESCE(needstraining, FALSE) AS needstraining, createdat
+	      FROM messages
+	      WHERE abotsent IS FALSE AND createdat>=$1
+	      ORDER BY id`
+	rows, err := db.Queryx(q, since)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err = rows.Close(); err != nil {
+			log.Info("failed to close rows", err)
+		}
+	}()
+	bw := bufio.NewWriter(w)
+	for rows.Next() {
+		var m TranscriptMsg
+		if err = rows.StructScan(&m); err != nil {
+			return err
+		}
+		m.Sentence = openSentence(m.Sentence)
+		if len(strings.TrimSpace(m.Sentence)) == 0 {
+			continue
+		}
+		m.Sentence = redact.String(redact.FieldMessage, m.Sentence)
+		if err = write(TagSentence(&m), bw); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// HAPIExportCorpus downloads the messages users sent over the past number of
+// days given by the days query parameter, 30 by default, tagged by how they
+// were classified. Pass format=conll or format=jsonl.
+func HAPIExportCorpus(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	v := r.URL.Query()
+	format := v.Get("format")
+	if len(format) == 0 {
+		format = FormatCoNLL
+	}
+	if format != FormatCoNLL && format != FormatSpans {
+		writeErrorBadRequest(w, fmt.Errorf("%s: %q", ErrUnknownFormat,
+			format))
+		return
+	}
+	days := 30
+	if s := v.Get("days"); len(s) > 0 {
+		var err error
+		if days, err = strconv.Atoi(s); err != nil || days <= 0 {
+			writeErrorBadRequest(w, fmt.Errorf("invalid days %q", s))
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition",
+		`attachment; filename="abot_corpus.`+format+`"`)
+	err := ExportCorpus(w, format, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		// The response has begun, so the error can only be logged.
+		log.Info("failed to export corpus", err)
+	}
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"sort"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/embedding"
+)
+
+// maxScannedFacts limits how many of a user's most recent facts are compared
+// to a search when Postgres can't compare vectors itself.
+const maxScannedFacts = 1000
+
+var embeddingConn *embedding.Conn
+
+// pgvector is true if the pgvector extension is installed, in which case
+// facts are ranked by similarity in Postgres rather than in Go.
+var pgvector bool
+
+// SaveFact saves something a plugin learned about a user to recall later. If
+// an embedding driver is imported, the fact is embedded so it can be found by
+// meaning.
+func SaveFact(u *dt.User, pluginName, content string) error {
+	var vec dt.Vector
+	if embeddingConn != nil {
+		vecs, err := embeddingConn.Embed([]string{content})
+		if err != nil {
+			// The fact can still be found by keyword.
+			log.Info("failed to embed fact", err)
+		} else if len(vecs) > 0 {
+			vec = dt.Vector(vecs[0])
+		}
+	}
+	q := `INSERT INTO facts (userid, pluginname, content, embedding)
+	      VALUES ($1, $2, $3, $4)`
+	_, err := db.Exec(q, u.ID, pluginName, content, vec)
+	return err
+}
+
+// SearchFacts finds a user's facts related to a query, like "travel plans,"
+// most related first. Facts are found by meaning if an embedding driver is
+// imported and by keyword otherwise.
+func SearchFacts(u *dt.User, query string, limit int) ([]dt.Fact, error) {
+	if embeddingConn == nil {
+		return searchFactsByKeyword(u, query, limit)
+	}
+	vecs, err := embeddingConn.Embed([]string{query})
+	if err != nil {
+		log.Info("failed to embed query, searching by keyword", err)
+		return searchFactsByKeyword(u, query, limit)
+	}
+	if len(vecs) == 0 {
+		return searchFactsByKeyword(u, query, limit)
+	}
+	vec := dt.Vector(vecs[0])
+	var facts []dt.Fact
+	if pgvector {
+		q := `SELECT id, userid, pluginname, content, createdat,
+		          1 - (embedding::vector <=> $2::real[]::vector) AS similarity
+		      FROM facts
+		      WHERE userid=$1 AND embedding IS NOT NULL
+		      ORDER BY embedding::vector <=> $2::real[]::vector
+		      LIMIT $3`
+		if err = db.Select(&facts, q, u.ID, vec, limit); err != nil {
+			return nil, err
+		}
+		return facts, nil
+	}
+	var rows []struct {
+		dt.Fact
+		Embedding dt.Vector
+	}
+	q := `SELECT id, userid, pluginname, content, createdat, embedding
+	      FROM facts
+	      WHERE userid=$1 AND embedding IS NOT NULL
+	      ORDER BY createdat DESC
+	      LIMIT $2`
+	if err = db.Select(&rows, q, u.ID, maxScannedFacts); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		row.Fact.Similarity = vec.Cosine(row.Embedding)
+		facts = append(facts, row.Fact)
+	}
+	sort.Sort(bySimilarity(facts))
+	if len(facts) > limit {
+		facts = facts[:limit]
+	}
+	return facts, nil
+}
+
+func searchFactsByKeyword(u *dt.User, query string, limit int) ([]dt.Fact,
+	error) {
+
+	var facts []dt.Fact
+	q := `SELECT id, userid, pluginname, content, createdat
+	      FROM facts
+	      WHERE userid=$1 AND to_tsvector('english', content) @@
+	          plainto_tsquery('english', $2)
+	      ORDER BY createdat DESC
+	      LIMIT $3`
+	if err := db.Select(&facts, q, u.ID, query, limit); err != nil {
+		return nil, err
+	}
+	return facts, nil
+}
+
+type bySimilarity []dt.Fact
+
+func (f bySimilarity) Len() int {
+	return len(f)
+}
+
+func (f bySimilarity) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+func (f bySimilarity) Less(i, j int) bool {
+	return f[i].Similarity > f[j].Similarity
+}
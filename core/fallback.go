@@ -0,0 +1,302 @@
+package core
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// defaultFallbackSuggestions is how many intents are suggested when Abot
+// doesn't understand a message. Operators can change it with the
+// fallback_suggestions setting, or set it to 0 to suggest none.
+const defaultFallbackSuggestions = 3
+
+// minWordSimilarity is how alike a word must be to a trigger to count toward
+// suggesting it, so "restaraunt" suggests "find restaurant" but "rest" doesn't.
+const minWordSimilarity = 0.6
+
+// suggestion is a plugin's intent suggested to a user when Abot didn't
+// understand them.
+type suggestion struct {
+	Plugin string
+	Route  string
+	Score  float64
+}
+
+// fallbackSuggestions are the intents suggested after a message Abot didn't
+// understand, and the message's sentence.
+type fallbackSuggestions struct {
+	ID        uint64
+	MessageID uint64
+	Sentence  string
+	Plugins   nlp.StringSlice
+	Routes    nlp.StringSlice
+}
+
+func init() {
+	RegisterMetric("fallback_suggestions", func() (interface{}, error) {
+		var s struct {
+			Offered int
+			Picked  int
+		}
+		q := `SELECT COUNT(*) AS offered, COUNT(chosen) AS picked
+		      FROM fallbacksuggestions
+		      WHERE createdat>$1`
+		err := db.Get(&s, q, time.Now().AddDate(0, 0, -30))
+		return s, err
+	})
+}
+
+func fallbackSuggestionCount() int {
+	s := Setting("fallback_suggestions")
+	if len(s) == 0 {
+		return defaultFallbackSuggestions
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		log.Info("invalid fallback suggestions", s)
+		return defaultFallbackSuggestions
+	}
+	return n
+}
+
+// fallbackResponse builds the response to a message Abot didn't understand:
+// the fallback_response setting, or a confused reply if that's empty, along
+// with the intents the message most resembles for the user to pick from.
+func fallbackResponse(in *dt.Msg) *dt.Response {
+	resp := &dt.Response{Text: Setting("fallback_response")}
+	if len(resp.Text) == 0 {
+		resp.Text = ConfusedLang()
+	}
+	sugs := suggestIntents(in.Tokens, fallbackSuggestionCount())
+	if len(sugs) == 0 {
+		return resp
+	}
+	resp.Text += " Did you mean one of these?"
+	var plugins, routes []string
+	for _, s := range sugs {
+		resp.Buttons = append(resp.Buttons, routeLabel(s.Route))
+		plugins = append(plugins, s.Plugin)
+		routes = append(routes, s.Route)
+	}
+	if in.User != nil && in.User.ID > 0 {
+		q := `INSERT INTO fallbacksuggestions
+		      (userid, messageid, plugins, routes)
+		      VALUES ($1, $2, $3, $4)`
+		_, err := db.Exec(q, in.User.ID, in.ID, nlp.StringSlice(plugins),
+			nlp.StringSlice(routes))
+		if err != nil {
+			log.Info("failed to save fallback suggestions", err)
+		}
+	}
+	return resp
+}
+
+// suggestIntents ranks enabled plugins by how closely a message's tokens
+// resemble their trigger commands and objects, returning the top n with any
+// resemblance. Each is suggested by the route it most resembles.
+func suggestIntents(tokens []string, n int) []suggestion {
+	var sugs []suggestion
+	for _, p := range AllPlugins {
+		t := p.Trigger
+		if t == nil || len(t.Commands) == 0 || len(t.Objects) == 0 ||
+			!PluginEnabled(p.Config.Name) {
+			continue
+		}
+		c, cScore := closestWord(tokens, t.Commands)
+		o, oScore := closestWord(tokens, t.Objects)
+		if cScore+oScore == 0 {
+			continue
+		}
+		sugs = append(sugs, suggestion{
+			Plugin: p.Config.Name,
+			Route:  strings.ToLower(c + "_" + o),
+			Score:  cScore + oScore,
+		})
+	}
+	sort.Sort(byScore(sugs))
+	if len(sugs) > n {
+		sugs = sugs[:n]
+	}
+	return sugs
+}
+
+// closestWord returns the word most similar to any of the tokens and its
+// similarity, or the first word and 0 if none are alike.
+func closestWord(tokens, words []string) (string, float64) {
+	best, score := words[0], 0.0
+	for _, w := range words {
+		for _, t := range tokens {
+			if s := wordSimilarity(t, w); s > score {
+				best, score = w, s
+			}
+		}
+	}
+	return best, score
+}
+
+// wordSimilarity scores how alike two words are from 0 to 1, counting words
+// with the same stem as nearly identical and otherwise scoring by their edit
+// distance.
+func wordSimilarity(a, b string) float64 {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if a == b {
+		return 1
+	}
+	if nlp.StemTokens([]string{a})[0] == nlp.StemTokens([]string{b})[0] {
+		return 0.9
+	}
+	n := len([]rune(a))
+	if m := len([]rune(b)); m > n {
+		n = m
+	}
+	s := 1 - float64(editDistance(a, b))/float64(n)
+	if s < minWordSimilarity {
+		return 0
+	}
+	return s
+}
+
+// editDistance returns the Levenshtein distance between two words.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// routeLabel presents a route to users, e.g. "find restaurant" for
+// "find_restaurant."
+func routeLabel(route string) string {
+	return strings.Replace(route, "_", " ", 1)
+}
+
+// pickSuggestion returns the index of the route a reply picks by number or
+// position, e.g. "2" or "the second one," or by name, e.g. "find
+// restaurant," or -1 if it doesn't pick exactly one.
+func pickSuggestion(reply string, routes []string) int {
+	reply = strings.ToLower(reply)
+	words := strings.FieldsFunc(reply, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		if w == "last" && len(routes) > 0 {
+			return len(routes) - 1
+		}
+		if i, ok := suggestionNumbers[w]; ok && i < len(routes) {
+			return i
+		}
+	}
+	picked := -1
+	for i, route := range routes {
+		if !strings.Contains(reply, routeLabel(route)) {
+			continue
+		}
+		if picked >= 0 {
+			return -1
+		}
+		picked = i
+	}
+	return picked
+}
+
+// suggestionNumbers are the ways users pick a suggestion by its position.
+var suggestionNumbers = map[string]int{
+	"1": 0, "one": 0, "first": 0, "1st": 0,
+	"2": 1, "two": 1, "second": 1, "2nd": 1,
+	"3": 2, "three": 2, "third": 2, "3rd": 2,
+	"4": 3, "four": 3, "fourth": 3, "4th": 3,
+	"5": 4, "five": 4, "fifth": 4, "5th": 4,
+}
+
+type byScore []suggestion
+
+func (s byScore) Len() int           { return len(s) }
+func (s byScore) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byScore) Less(i, j int) bool { return s[i].Score > s[j].Score }
+
+// applySuggestion handles a reply picking one of the intents suggested after
+// the user's last message, e.g. "the second one," by routing the last
+// message to the chosen plugin as if the user had repeated it. The pick is
+// recorded, and the last message labeled with the chosen plugin for
+// retraining. Other replies are left alone.
+func applySuggestion(in *dt.Msg) error {
+	if in.User == nil || in.User.ID == 0 {
+		return nil
+	}
+	var fs fallbackSuggestions
+	q := `SELECT fs.id, fs.messageid, COALESCE(m.sentence, '') AS sentence,
+	          fs.plugins, fs.routes
+	      FROM fallbacksuggestions AS fs
+	      JOIN messages AS m ON m.id=fs.messageid
+	      WHERE fs.userid=$1 AND fs.chosen IS NULL AND fs.messageid=(
+	          SELECT MAX(id) FROM messages
+	          WHERE userid=$1 AND abotsent IS FALSE)`
+	err := db.Get(&fs, q, in.User.ID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fs.Sentence = openSentence(fs.Sentence)
+	i := pickSuggestion(in.Sentence, fs.Routes)
+	if i < 0 || i >= len(fs.Plugins) {
+		return nil
+	}
+	p := enabledPlugin(fs.Routes[i])
+	if p == nil {
+		return nil
+	}
+	q = `UPDATE fallbacksuggestions
+	     SET chosen=$1, chosenat=CURRENT_TIMESTAMP
+	     WHERE id=$2`
+	if _, err = db.Exec(q, i, fs.ID); err != nil {
+		return err
+	}
+	err = RecordClassificationCheck(fs.MessageID, "", fs.Plugins[i],
+		CheckFallback, "user")
+	if err != nil {
+		return err
+	}
+	in.Sentence = fs.Sentence
+	in.StructuredInput = &nlp.StructuredInput{
+		FromImage: in.StructuredInput.FromImage,
+	}
+	if err = runPipeline(in); err != nil {
+		return err
+	}
+	in.Route, in.Plugin, in.Followup = fs.Routes[i], p.Config.Name, false
+	return nil
+}
@@ -0,0 +1,335 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// Names of the built-in fallback handlers.
+const (
+	// FallbackSmallTalk answers greetings and pleasantries, like "how
+	// are you?"
+	FallbackSmallTalk = "small_talk"
+
+	// FallbackCapabilities tells users what Abot can do when they ask,
+	// e.g. "I can order pizza or book a table."
+	FallbackCapabilities = "capabilities"
+
+	// FallbackOperator hands the conversation to a person, who follows
+	// up once business opens if it's closed.
+	FallbackOperator = "operator"
+
+	// FallbackSuggest says Abot didn't understand and suggests the
+	// intents the message most resembles. It always answers, so handlers
+	// after it never run.
+	FallbackSuggest = "suggest"
+)
+
+// maxCapabilities is the most intents listed when telling users what Abot
+// can do.
+const maxCapabilities = 5
+
+// ErrUnknownFallback is returned when configuring the fallback chain with a
+// handler that hasn't been registered.
+var ErrUnknownFallback = errors.New("unknown fallback handler")
+
+// FallbackHandler answers messages no plugin claimed, like small talk or a
+// request Abot doesn't support. Handlers run in the order of the fallback
+// chain until one answers. See FallbackChain.
+type FallbackHandler interface {
+	// Fallback returns the response to a message, or nil to pass it to
+	// the next handler in the chain. Errors are logged and the message
+	// passed on.
+	Fallback(in *dt.Msg) (*dt.Response, error)
+}
+
+// FallbackFunc adapts a function to a FallbackHandler.
+type FallbackFunc func(in *dt.Msg) (*dt.Response, error)
+
+// Fallback calls f(in).
+func (f FallbackFunc) Fallback(in *dt.Msg) (*dt.Response, error) {
+	return f(in)
+}
+
+var fallbackHandlers = map[string]FallbackHandler{
+	FallbackSmallTalk:    FallbackFunc(smallTalkFallback),
+	FallbackCapabilities: FallbackFunc(capabilitiesFallback),
+	FallbackOperator:     FallbackFunc(operatorFallback),
+	FallbackSuggest:      FallbackFunc(suggestFallback),
+}
+
+// defaultFallbackChain is the order of fallback handlers when operators
+// haven't configured one. Handing off to a person is left out, since not
+// every tenant has operators.
+var defaultFallbackChain = []string{FallbackSmallTalk, FallbackCapabilities,
+	FallbackSuggest}
+
+var fallbacksMu sync.RWMutex
+
+// smallTalk are the pleasantries FallbackSmallTalk answers, matched against
+// the normalized sentence.
+var smallTalk = []struct {
+	re   *regexp.Regexp
+	resp string
+}{
+	{regexp.MustCompile(`^(?:hey )?how(?: are|'re| r) (?:you|u)(?: doing| today)?\W*$|^how is it going\W*$|^what is up\W*$|^sup\W*$`),
+		"I'm doing well, thanks for asking! What can I help you with?"},
+	{regexp.MustCompile(`^good (?:morning|afternoon|evening)\W*$`),
+		"Hi there! What can I help you with?"},
+	{regexp.MustCompile(`^(?:good ?night|bye|goodbye|see (?:you|ya)(?: later)?|talk (?:to you )?later|ttyl)\W*$`),
+		"Talk to you later!"},
+	{regexp.MustCompile(`^(?:who|what) are you\W*$|^are you (?:a )?(?:bot|robot|human|real|person)\W*$`),
+		"I'm an assistant. I can help with a few things, and a person can step in when I can't."},
+	{regexp.MustCompile(`^(?:lol|haha+|hehe+|lmao)\W*$`), ":)"},
+	{regexp.MustCompile(`^(?:i love you|you are (?:great|awesome|the best|amazing))\W*$`),
+		"That's kind of you to say!"},
+}
+
+// regexCapabilities matches users asking what Abot can do, against the
+// normalized sentence.
+var regexCapabilities = regexp.MustCompile(`^(?:what (?:can|do) you do|what are you (?:able to do|for)|how can you help(?: me)?|what can i (?:ask|say)(?: you)?|help)\W*$`)
+
+// RegisterFallback adds a fallback handler to the chain, running after the
+// handler named by after, or just before FallbackSuggest if that's empty.
+// Registering a name twice replaces the earlier handler. Operators who have
+// configured the chain must add the handler to it themselves.
+func RegisterFallback(name, after string, h FallbackHandler) {
+	fallbacksMu.Lock()
+	defer fallbacksMu.Unlock()
+	if _, ok := fallbackHandlers[name]; ok {
+		fallbackHandlers[name] = h
+		return
+	}
+	fallbackHandlers[name] = h
+	at := len(defaultFallbackChain)
+	for i, s := range defaultFallbackChain {
+		if (len(after) == 0 && s == FallbackSuggest) ||
+			(len(after) > 0 && s == after) {
+
+			at = i
+			if len(after) > 0 {
+				at++
+			}
+			break
+		}
+	}
+	defaultFallbackChain = append(defaultFallbackChain[:at],
+		append([]string{name}, defaultFallbackChain[at:]...)...)
+}
+
+// FallbackChain returns the names of the fallback handlers that answer
+// messages no plugin claimed, in order. Operators can reorder or disable
+// handlers with the fallback_chain setting, a comma-separated list of
+// handler names.
+func FallbackChain() []string {
+	s := Setting("fallback_chain")
+	fallbacksMu.RLock()
+	defer fallbacksMu.RUnlock()
+	if len(s) == 0 {
+		return append([]string{}, defaultFallbackChain...)
+	}
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		names = append(names, strings.TrimSpace(name))
+	}
+	return names
+}
+
+// SetFallbackChain validates and saves the order of the fallback handlers.
+// Handlers left out are disabled.
+func SetFallbackChain(names []string) error {
+	fallbacksMu.RLock()
+	seen := map[string]struct{}{}
+	for _, name := range names {
+		_, ok := fallbackHandlers[name]
+		_, dup := seen[name]
+		if !ok || dup {
+			fallbacksMu.RUnlock()
+			return fmt.Errorf("%s: %q", ErrUnknownFallback, name)
+		}
+		seen[name] = struct{}{}
+	}
+	fallbacksMu.RUnlock()
+	return SetSetting("fallback_chain", strings.Join(names, ","))
+}
+
+// runFallbacks passes a message no plugin claimed through the fallback chain
+// until a handler answers, returning its response and name. If none does,
+// Abot says it's confused.
+func runFallbacks(in *dt.Msg) (*dt.Response, string) {
+	for _, name := range FallbackChain() {
+		fallbacksMu.RLock()
+		h, ok := fallbackHandlers[name]
+		fallbacksMu.RUnlock()
+		if !ok {
+			// The handler's plugin may have been uninstalled.
+			continue
+		}
+		resp, err := h.Fallback(in)
+		if err != nil {
+			log.Info("fallback", name, "failed", err)
+			continue
+		}
+		if resp != nil && len(resp.Text) > 0 {
+			return resp, name
+		}
+	}
+	return &dt.Response{Text: ConfusedLang()}, ""
+}
+
+func smallTalkFallback(in *dt.Msg) (*dt.Response, error) {
+	s := in.StructuredInput.NormalizedSentence
+	for _, st := range smallTalk {
+		if st.re.MatchString(s) {
+			return &dt.Response{Text: st.resp}, nil
+		}
+	}
+	return nil, nil
+}
+
+// capabilitiesFallback answers users asking what Abot can do with the
+// capabilities setting or, if that's empty, the intents of enabled plugins.
+func capabilitiesFallback(in *dt.Msg) (*dt.Response, error) {
+	if !regexCapabilities.MatchString(in.StructuredInput.NormalizedSentence) {
+		return nil, nil
+	}
+	if s := Setting("capabilities"); len(s) > 0 {
+		return &dt.Response{Text: s}, nil
+	}
+	labels := capabilityLabels(in.User, maxCapabilities)
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return &dt.Response{
+		Text:    "I can " + joinOr(labels) + ". What would you like to do?",
+		Buttons: labels,
+	}, nil
+}
+
+// capabilityLabels returns up to n intents the user may use, one for each
+// enabled plugin, e.g. "order pizza." Each plugin is represented by its
+// highest priority intent.
+func capabilityLabels(u *dt.User, n int) []string {
+	best := map[string]dt.Intent{}
+	for name, in := range Intents() {
+		p := RegPlugins.Get(name)
+		if p == nil || !PluginEnabled(p.Config.Name) ||
+			(u != nil && !u.Authorize(p.Config.Name)) {
+			continue
+		}
+		prev, ok := best[p.Config.Name]
+		if !ok || in.Priority > prev.Priority ||
+			(in.Priority == prev.Priority && name < prev.Name) {
+			in.Name = name
+			best[p.Config.Name] = in
+		}
+	}
+	var labels []string
+	for _, in := range best {
+		labels = append(labels, routeLabel(in.Name))
+	}
+	sort.Strings(labels)
+	if len(labels) > n {
+		labels = labels[:n]
+	}
+	return labels
+}
+
+// joinOr joins words in a list ending with "or," e.g. "a, b or c."
+func joinOr(ws []string) string {
+	if len(ws) < 2 {
+		return strings.Join(ws, "")
+	}
+	return strings.Join(ws[:len(ws)-1], ", ") + " or " + ws[len(ws)-1]
+}
+
+// operatorFallback hands the conversation to a person, queueing a callback if
+// business is closed. Users already handed off are told a person will follow
+// up rather than being queued again.
+func operatorFallback(in *dt.Msg) (*dt.Response, error) {
+	if in.User == nil || !in.User.Registered() {
+		return nil, nil
+	}
+	var open bool
+	q := `SELECT EXISTS(SELECT 1 FROM conversationlabels
+	      WHERE userid=$1 AND label=$2 AND resolvedat IS NULL)`
+	if err := db.Get(&open, q, in.User.ID, handoffLabel); err != nil {
+		return nil, err
+	}
+	if open {
+		return &dt.Response{Text: "I've passed that along. A person will follow up with you."}, nil
+	}
+	resp, err := handOff(in, FallbackOperator, "fallback")
+	if err != nil {
+		return nil, err
+	}
+	return &dt.Response{Text: "I'm not sure how to help with that. " + resp}, nil
+}
+
+// handOff escalates the user's conversation to a person, deferring it as a
+// callback if business is closed, and returns Abot's response. See Escalate.
+func handOff(in *dt.Msg, reason, createdBy string) (string, error) {
+	h := currentHours()
+	if h.OpenAt(time.Now()) {
+		if _, err := Escalate(in.User.ID, "", reason); err != nil {
+			return "", err
+		}
+		return "I'm getting a person to help you. They'll follow up shortly.",
+			nil
+	}
+	err := LabelConversation(in.User.ID, in.ID, handoffLabel, createdBy)
+	if err != nil {
+		return "", err
+	}
+	return deferHandoff(in, reason, h)
+}
+
+func suggestFallback(in *dt.Msg) (*dt.Response, error) {
+	return fallbackResponse(in), nil
+}
+
+// HAPIFallbackChain responds with the fallback handlers that answer messages
+// no plugin claimed, in order, along with every handler available.
+func HAPIFallbackChain(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	fallbacksMu.RLock()
+	var available []string
+	for name := range fallbackHandlers {
+		available = append(available, name)
+	}
+	fallbacksMu.RUnlock()
+	sort.Strings(available)
+	writeBytes(w, struct {
+		Handlers  []string
+		Available []string
+	}{Handlers: FallbackChain(), Available: available})
+}
+
+// HAPIFallbackChainSubmit reorders or disables fallback handlers.
+func HAPIFallbackChainSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct{ Handlers []string }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err := SetFallbackChain(req.Handlers); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
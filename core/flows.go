@@ -0,0 +1,440 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// ErrInvalidFlow is returned when loading a flow that's missing a name,
+// triggers or states, or whose states are malformed.
+var ErrInvalidFlow = errors.New("invalid flow")
+
+// Types of the slots a FlowState fills from the user's reply.
+const (
+	// SlotText is the user's whole reply. It's the default.
+	SlotText = "text"
+
+	// SlotNumber is the first number in the reply, like "3" for "three
+	// please," in digits.
+	SlotNumber = "number"
+
+	// SlotYesNo is "yes" or "no."
+	SlotYesNo = "yesno"
+
+	// SlotChoice is one of the state's Choices, named or picked by
+	// number, like "2."
+	SlotChoice = "choice"
+)
+
+// Conversation variables holding a user's progress through a flow.
+const (
+	flowStateVar = "__flow_state"
+	flowSlotsVar = "__flow_slots"
+)
+
+// flowYes and flowNo are the replies to yes or no questions. They mirror
+// language.Yes and language.No, which core can't import.
+var flowYes = map[string]struct{}{
+	"yes": {}, "yea": {}, "yah": {}, "yeah": {}, "yup": {}, "sure": {},
+	"ok": {}, "okay": {}, "k": {}, "correct": {}, "right": {},
+}
+
+var flowNo = map[string]struct{}{
+	"no": {}, "nope": {}, "nah": {}, "not": {}, "wrong": {},
+}
+
+var flowClient = &http.Client{Timeout: 10 * time.Second}
+
+// Flow is a simple dialog defined in a JSON file rather than in Go, so people
+// who don't program can build basic plugins. Flows are loaded from the flows
+// directory of the Abot project when Abot boots. For example:
+//
+//	{
+//		"Name": "pizza",
+//		"Commands": ["order"],
+//		"Objects": ["pizza"],
+//		"States": [
+//			{"Name": "size", "Prompt": "What size?", "Slot": "size",
+//			 "Type": "choice", "Choices": ["small", "large"]},
+//			{"Name": "confirm", "Slot": "ok", "Type": "yesno",
+//			 "Prompt": "A {{size}} pizza, right?",
+//			 "Transitions": {"no": "size"}},
+//			{"Name": "order", "Webhook": "https://example.com/pizza",
+//			 "Prompt": "Ordered! It'll arrive in {{minutes|30}} minutes."}
+//		]
+//	}
+//
+// Messages routed to any of the flow's Commands and Objects start the flow at
+// its first state.
+type Flow struct {
+	Name     string
+	Commands []string
+	Objects  []string
+	States   []FlowState
+}
+
+// FlowState is a step of a Flow. States that fill a Slot send their Prompt and
+// wait for the user's reply. The rest send their Prompt, if any, and move on.
+type FlowState struct {
+	Name string
+
+	// Prompt is sent on entering the state. It may use slots filled so
+	// far, like "{{size}}," with an optional default, like
+	// "{{size|medium}}."
+	Prompt string
+
+	// Slot names the value read from the user's reply to Prompt, whose
+	// Type is one of SlotText, SlotNumber, SlotYesNo or SlotChoice.
+	// Replies that can't be read are asked for again.
+	Slot    string
+	Type    string
+	Choices []string
+
+	// Transitions move to another state depending on the slot's value,
+	// e.g. "no": "size." Otherwise the flow moves to Next, or to the
+	// following state if Next is empty.
+	Transitions map[string]string
+	Next        string
+
+	// Webhook is a URL the state's slots are POSTed to as JSON on
+	// entering the state, e.g. to place an order. The fields of the JSON
+	// object it responds with are added to the slots, so Prompt can use
+	// them.
+	Webhook string
+
+	// End finishes the flow after the state.
+	End bool
+}
+
+// LoadFlows loads the flows defined in a directory's .json files, routing
+// their messages to them. Invalid flows are skipped and logged.
+func LoadFlows(dir string) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if filepath.Ext(fi.Name()) != ".json" {
+			continue
+		}
+		byt, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return err
+		}
+		f := &Flow{}
+		if err = json.Unmarshal(byt, f); err != nil {
+			log.Info("failed to parse flow", fi.Name(), err)
+			continue
+		}
+		if err = f.validate(); err != nil {
+			log.Info("failed to load flow", fi.Name(), err)
+			continue
+		}
+		registerFlow(f)
+	}
+	return nil
+}
+
+// loadFlows loads the flows in the flows directory of the Abot project, if
+// any.
+func loadFlows() error {
+	if len(os.Getenv("ABOT_PATH")) == 0 {
+		return nil
+	}
+	err := LoadFlows(filepath.Join(os.Getenv("ABOT_PATH"), "flows"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// validate checks that a flow's states and the transitions between them are
+// well formed.
+func (f *Flow) validate() error {
+	if len(f.Name) == 0 || len(f.Commands) == 0 || len(f.Objects) == 0 ||
+		len(f.States) == 0 {
+		return ErrInvalidFlow
+	}
+	names := map[string]struct{}{}
+	for _, st := range f.States {
+		if len(st.Name) == 0 {
+			return fmt.Errorf("%s: unnamed state", ErrInvalidFlow)
+		}
+		if _, ok := names[st.Name]; ok {
+			return fmt.Errorf("%s: duplicate state %q", ErrInvalidFlow,
+				st.Name)
+		}
+		names[st.Name] = struct{}{}
+	}
+	for _, st := range f.States {
+		switch st.Type {
+		case "", SlotText, SlotNumber, SlotYesNo:
+		case SlotChoice:
+			if len(st.Choices) == 0 {
+				return fmt.Errorf("%s: state %q has no choices",
+					ErrInvalidFlow, st.Name)
+			}
+		default:
+			return fmt.Errorf("%s: state %q has unknown type %q",
+				ErrInvalidFlow, st.Name, st.Type)
+		}
+		targets := []string{st.Next}
+		for _, t := range st.Transitions {
+			targets = append(targets, t)
+		}
+		for _, t := range targets {
+			if _, ok := names[t]; len(t) > 0 && !ok {
+				return fmt.Errorf("%s: state %q moves to %q",
+					ErrInvalidFlow, st.Name, t)
+			}
+		}
+	}
+	// Slots' values are matched to transitions ignoring case.
+	for i := range f.States {
+		ts := map[string]string{}
+		for val, t := range f.States[i].Transitions {
+			ts[strings.ToLower(val)] = t
+		}
+		f.States[i].Transitions = ts
+	}
+	return nil
+}
+
+// registerFlow routes a flow's messages to it as a plugin.
+func registerFlow(f *Flow) {
+	trigger := &nlp.StructuredInput{Commands: f.Commands, Objects: f.Objects}
+	p := &dt.Plugin{
+		Config:  dt.PluginConfig{Name: f.Name, Type: "action"},
+		Trigger: trigger,
+		DB:      db,
+		Log:     log.New(f.Name),
+		Events: &dt.PluginEvents{
+			PostReceive:    func(cmd *string) {},
+			PreProcessing:  func(cmd *string, u *dt.User) {},
+			PostProcessing: func(in *dt.Msg) {},
+			PostResponse:   func(in *dt.Msg, resp *string) {},
+		},
+	}
+	p.PluginFns = &dt.PluginFns{
+		Run: func(in *dt.Msg) (string, error) {
+			return f.start(dt.NewStateMachine(p), in)
+		},
+		FollowUp: func(in *dt.Msg) (string, error) {
+			return f.followUp(dt.NewStateMachine(p), in)
+		},
+	}
+	var intents []dt.Intent
+	for _, c := range f.Commands {
+		for _, o := range f.Objects {
+			intents = append(intents, dt.Intent{
+				Name:     strings.ToLower(c + "_" + o),
+				Commands: []string{c},
+				Objects:  []string{o},
+			})
+		}
+	}
+	if err := RegisterIntents(p, intents...); err != nil {
+		log.Info("failed to register flow", f.Name, err)
+		return
+	}
+	AllPlugins = append(AllPlugins, p)
+	log.Debug("loaded flow", f.Name)
+}
+
+// start begins the flow at its first state.
+func (f *Flow) start(sm *dt.StateMachine, in *dt.Msg) (string, error) {
+	sm.Reset(in)
+	return f.enter(sm, in, f.States[0].Name, map[string]string{})
+}
+
+// followUp fills the slot of the state the user is in from their reply and
+// moves on. Users whose conversation expired start over.
+func (f *Flow) followUp(sm *dt.StateMachine, in *dt.Msg) (string, error) {
+	var name string
+	err := json.Unmarshal(sm.GetVar(in, flowStateVar).Val, &name)
+	if err != nil {
+		return f.start(sm, in)
+	}
+	st := f.state(name)
+	if st == nil {
+		return f.start(sm, in)
+	}
+	slots := map[string]string{}
+	err = json.Unmarshal(sm.GetVar(in, flowSlotsVar).Val, &slots)
+	if err != nil {
+		return f.start(sm, in)
+	}
+	val, ok := st.read(in.Sentence)
+	if !ok {
+		return "Sorry, I didn't catch that. " + fillSlots(st.Prompt,
+			slots), nil
+	}
+	slots[st.Slot] = val
+	return f.enter(sm, in, f.next(st, val), slots)
+}
+
+// enter moves the user to a state, passing through states that don't wait for
+// a reply, and returns the prompts sent along the way.
+func (f *Flow) enter(sm *dt.StateMachine, in *dt.Msg, name string,
+	slots map[string]string) (string, error) {
+
+	var prompts []string
+	for steps := 0; len(name) > 0; steps++ {
+		if steps > len(f.States) {
+			sm.Reset(in)
+			return "", fmt.Errorf("flow %s loops at %s", f.Name, name)
+		}
+		st := f.state(name)
+		if len(st.Webhook) > 0 {
+			err := f.callWebhook(st.Webhook, in, slots)
+			if err != nil {
+				sm.Reset(in)
+				return "I'm sorry, something went wrong. Please try again later.", err
+			}
+		}
+		if p := fillSlots(st.Prompt, slots); len(p) > 0 {
+			prompts = append(prompts, p)
+		}
+		if len(st.Slot) > 0 {
+			sm.SetVar(in, flowStateVar, st.Name)
+			sm.SetVar(in, flowSlotsVar, slots)
+			return strings.Join(prompts, " "), nil
+		}
+		name = f.next(st, "")
+	}
+	sm.Reset(in)
+	return strings.Join(prompts, " "), nil
+}
+
+// state returns a flow's state by name, or nil if there's none.
+func (f *Flow) state(name string) *FlowState {
+	for i := range f.States {
+		if f.States[i].Name == name {
+			return &f.States[i]
+		}
+	}
+	return nil
+}
+
+// next returns the name of the state after st given its slot's value, or an
+// empty string if the flow is finished.
+func (f *Flow) next(st *FlowState, val string) string {
+	if t, ok := st.Transitions[strings.ToLower(val)]; ok {
+		return t
+	}
+	if st.End {
+		return ""
+	}
+	if len(st.Next) > 0 {
+		return st.Next
+	}
+	for i := range f.States {
+		if f.States[i].Name == st.Name && i+1 < len(f.States) {
+			return f.States[i+1].Name
+		}
+	}
+	return ""
+}
+
+// read returns the value of a state's slot in the user's reply, or false if
+// the reply holds none.
+func (st *FlowState) read(sent string) (string, bool) {
+	sent = strings.TrimSpace(sent)
+	switch st.Type {
+	case SlotNumber:
+		for _, n := range nlp.ExtractNumbers(sent) {
+			if !n.Ordinal {
+				return n.Normalized, true
+			}
+		}
+		return "", false
+	case SlotYesNo:
+		for _, w := range strings.Fields(strings.ToLower(sent)) {
+			w = strings.Trim(w, ".,;:!?'\"")
+			if _, ok := flowYes[w]; ok {
+				return "yes", true
+			}
+			if _, ok := flowNo[w]; ok {
+				return "no", true
+			}
+		}
+		return "", false
+	case SlotChoice:
+		lsent := " " + strings.ToLower(sent) + " "
+		for _, c := range st.Choices {
+			if strings.Contains(lsent, " "+strings.ToLower(c)+" ") {
+				return c, true
+			}
+		}
+		n, ok := nlp.ParseNumber(sent)
+		i := int(n.Value)
+		if ok && !n.Ordinal && float64(i) == n.Value && i >= 1 &&
+			i <= len(st.Choices) {
+			return st.Choices[i-1], true
+		}
+		return "", false
+	}
+	return sent, len(sent) > 0
+}
+
+// callWebhook POSTs a flow's slots to a webhook, adding the fields of the
+// JSON object it responds with to the slots.
+func (f *Flow) callWebhook(url string, in *dt.Msg,
+	slots map[string]string) error {
+
+	byt, err := json.Marshal(struct {
+		Flow   string
+		UserID uint64
+		Slots  map[string]string
+	}{Flow: f.Name, UserID: in.User.ID, Slots: slots})
+	if err != nil {
+		return err
+	}
+	resp, err := flowClient.Post(url, "application/json",
+		bytes.NewReader(byt))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Info("failed to close webhook response", cerr)
+		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded %d", url,
+			resp.StatusCode)
+	}
+	var fields map[string]interface{}
+	if err = json.NewDecoder(resp.Body).Decode(&fields); err != nil {
+		// Webhooks needn't respond with anything.
+		return nil
+	}
+	for k, v := range fields {
+		slots[k] = fmt.Sprint(v)
+	}
+	return nil
+}
+
+// fillSlots replaces the slots used in a prompt, like "{{size}}," with their
+// values, or their defaults when unfilled.
+func fillSlots(prompt string, slots map[string]string) string {
+	return regexMacroVar.ReplaceAllStringFunc(prompt, func(s string) string {
+		v := regexMacroVar.FindStringSubmatch(s)
+		if val := slots[v[1]]; len(val) > 0 {
+			return val
+		}
+		return v[2]
+	})
+}
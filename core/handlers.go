@@ -50,6 +50,7 @@ func newRouter() *httprouter.Router {
 	// Web routes
 	router.HandlerFunc("GET", "/", HIndex)
 	router.HandlerFunc("POST", "/", HMain)
+	router.GET("/l/:code", HLink)
 
 	// Route any unknown request to our single page app front-end
 	router.NotFound = http.HandlerFunc(HIndex)
@@ -60,13 +61,95 @@ func newRouter() *httprouter.Router {
 	router.HandlerFunc("POST", "/api/signup.json", HAPISignupSubmit)
 	router.HandlerFunc("POST", "/api/forgot_password.json", HAPIForgotPasswordSubmit)
 	router.HandlerFunc("POST", "/api/reset_password.json", HAPIResetPasswordSubmit)
+	router.HandlerFunc("POST", "/api/payment/webhook.json", HAPIPaymentWebhook)
+	router.HandlerFunc("POST", "/api/annotation/webhook.json", HAPIAnnotationWebhook)
+	router.HandlerFunc("GET", "/api/webchat", HAPIWebChat)
 
 	// API routes (restricted by login)
 	router.HandlerFunc("GET", "/api/user/profile.json", HAPIProfile)
 	router.HandlerFunc("PUT", "/api/user/profile.json", HAPIProfileView)
+	router.HandlerFunc("GET", "/api/user/progress.json", HAPIProgress)
+	router.HandlerFunc("POST", "/api/user/totp.json", HAPITOTPEnroll)
+	router.HandlerFunc("PUT", "/api/user/totp.json", HAPITOTPConfirm)
+	router.HandlerFunc("POST", "/api/user/totp/delete.json", HAPITOTPDisable)
 
 	// API routes (restricted to admins)
 	router.HandlerFunc("GET", "/api/admin/plugins.json", HAPIPlugins)
+	router.HandlerFunc("PUT", "/api/admin/plugins.json", HAPIPluginsSubmit)
+	router.HandlerFunc("GET", "/api/admin/users.json", HAPIUsers)
+	router.HandlerFunc("GET", "/api/admin/user.json", HAPIUser)
+	router.HandlerFunc("PUT", "/api/admin/user/flexids.json", HAPIUserFlexIDsSubmit)
+	router.HandlerFunc("PUT", "/api/admin/user/roles.json", HAPIUserRolesSubmit)
+	router.HandlerFunc("PUT", "/api/admin/users/merge.json", HAPIUsersMerge)
+	router.HandlerFunc("GET", "/api/admin/transcript.json", HAPITranscript)
+	router.HandlerFunc("GET", "/api/admin/shadow_reports.json", HAPIShadowReports)
+	router.HandlerFunc("GET", "/api/admin/explain.json", HAPIExplain)
+	router.HandlerFunc("GET", "/api/admin/intents.json", HAPIIntents)
+	router.HandlerFunc("GET", "/api/admin/export/corpus", HAPIExportCorpus)
+	router.HandlerFunc("GET", "/api/admin/export/training", HAPIExportTrainingData)
+	router.HandlerFunc("GET", "/api/admin/fallbacks.json", HAPIFallbacks)
+	router.HandlerFunc("GET", "/api/admin/fallback_chain.json", HAPIFallbackChain)
+	router.HandlerFunc("PUT", "/api/admin/fallback_chain.json", HAPIFallbackChainSubmit)
+	router.HandlerFunc("PUT", "/api/admin/fallbacks.json", HAPIFallbacksSubmit)
+	router.HandlerFunc("GET", "/api/admin/observe", HAPIObserve)
+	router.HandlerFunc("GET", "/api/admin/redaction.json", HAPIRedaction)
+	router.HandlerFunc("PUT", "/api/admin/redaction.json", HAPIRedactionSubmit)
+	router.HandlerFunc("GET", "/api/admin/macros.json", HAPIMacros)
+	router.HandlerFunc("PUT", "/api/admin/macros.json", HAPIMacrosSubmit)
+	router.HandlerFunc("POST", "/api/admin/macros/delete.json", HAPIMacrosDelete)
+	router.HandlerFunc("POST", "/api/admin/macros/send.json", HAPIMacrosSend)
+	router.HandlerFunc("GET", "/api/admin/models.json", HAPIModels)
+	router.HandlerFunc("POST", "/api/admin/models/train.json", HAPIModelsTrain)
+	router.HandlerFunc("POST", "/api/admin/models/promote.json", HAPIModelsPromote)
+	router.HandlerFunc("GET", "/api/admin/pipeline.json", HAPIPipeline)
+	router.HandlerFunc("PUT", "/api/admin/pipeline.json", HAPIPipelineSubmit)
+	router.HandlerFunc("GET", "/api/admin/settings.json", HAPISettings)
+	router.HandlerFunc("PUT", "/api/admin/settings.json", HAPISettingsSubmit)
+	router.HandlerFunc("POST", "/api/admin/retrain.json", HAPIRetrain)
+	router.HandlerFunc("POST", "/api/admin/migrate.json", HAPIMigrate)
+	router.HandlerFunc("GET", "/api/admin/jobs.json", HAPIJobs)
+	router.HandlerFunc("POST", "/api/admin/resend.json", HAPIResend)
+	router.HandlerFunc("GET", "/api/admin/dead_letters.json", HAPIDeadLetters)
+	router.HandlerFunc("POST", "/api/admin/dead_letters/reprocess.json", HAPIReprocess)
+	router.HandlerFunc("GET", "/api/admin/audit.json", HAPIAuditEntries)
+	router.HandlerFunc("POST", "/api/admin/rotate_keys.json", HAPIRotateKeys)
+	router.HandlerFunc("GET", "/api/admin/analytics.json", HAPIAnalytics)
+	router.HandlerFunc("GET", "/api/admin/costs.json", HAPICosts)
+	router.HandlerFunc("GET", "/api/admin/channels.json", HAPIChannels)
+	router.HandlerFunc("PUT", "/api/admin/channels.json", HAPIChannelsSubmit)
+	router.HandlerFunc("GET", "/api/admin/classification_checks.json", HAPIClassificationChecks)
+	router.HandlerFunc("PUT", "/api/admin/classification_checks.json", HAPIClassificationChecksSubmit)
+	router.HandlerFunc("GET", "/api/admin/qa.json", HAPIQAReviews)
+	router.HandlerFunc("GET", "/api/admin/qa_review.json", HAPIQAReview)
+	router.HandlerFunc("PUT", "/api/admin/qa.json", HAPIQAReviewsSubmit)
+	router.HandlerFunc("GET", "/api/admin/business_hours.json", HAPIBusinessHours)
+	router.HandlerFunc("PUT", "/api/admin/business_hours.json", HAPIBusinessHoursSubmit)
+	router.HandlerFunc("GET", "/api/admin/callbacks.json", HAPICallbacks)
+	router.HandlerFunc("GET", "/api/admin/callback.json", HAPICallback)
+	router.HandlerFunc("POST", "/api/admin/callbacks/claim.json", HAPICallbackClaim)
+	router.HandlerFunc("POST", "/api/admin/callbacks/complete.json", HAPICallbackComplete)
+	router.HandlerFunc("GET", "/api/admin/tickets.json", HAPITickets)
+	router.HandlerFunc("GET", "/api/admin/ticket.json", HAPITicket)
+	router.HandlerFunc("POST", "/api/admin/tickets/reply.json", HAPITicketReply)
+	router.HandlerFunc("POST", "/api/admin/tickets/release.json", HAPITicketRelease)
+	router.HandlerFunc("GET", "/api/admin/credentials.json", HAPICredentials)
+	router.HandlerFunc("PUT", "/api/admin/credentials.json", HAPICredentialsSubmit)
+	router.HandlerFunc("POST", "/api/admin/credentials/delete.json", HAPICredentialsDelete)
+	router.HandlerFunc("GET", "/api/admin/entities.json", HAPIEntities)
+	router.HandlerFunc("PUT", "/api/admin/entities.json", HAPIEntitiesSubmit)
+	router.HandlerFunc("POST", "/api/admin/entities/delete.json", HAPIEntitiesDelete)
+	router.HandlerFunc("GET", "/api/admin/escalations.json", HAPIEscalations)
+	router.HandlerFunc("GET", "/api/admin/escalation_rules.json", HAPIEscalationRules)
+	router.HandlerFunc("PUT", "/api/admin/escalation_rules.json", HAPIEscalationRulesSubmit)
+	router.HandlerFunc("GET", "/api/admin/labels.json", HAPIConversationLabels)
+	router.HandlerFunc("PUT", "/api/admin/labels.json", HAPIConversationLabelsSubmit)
+	router.HandlerFunc("GET", "/api/admin/links.json", HAPILinks)
+	router.HandlerFunc("GET", "/api/admin/search.json", HAPISearch)
+	router.HandlerFunc("GET", "/api/admin/tags.json", HAPITags)
+	router.HandlerFunc("PUT", "/api/admin/tags.json", HAPITagsSubmit)
+	router.HandlerFunc("GET", "/api/admin/vocab_packs.json", HAPIVocabPacks)
+	router.HandlerFunc("PUT", "/api/admin/vocab_packs.json", HAPIVocabPacksSubmit)
+	router.HandlerFunc("POST", "/api/admin/vocab_packs/uninstall.json", HAPIVocabPacksUninstall)
 	return router
 }
 
@@ -95,16 +178,23 @@ func HIndex(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// errMsg is sent in place of a reply when a message couldn't be processed.
+const errMsg = "Something went wrong with my wiring... I'll get that fixed up soon."
+
 // HMain is the endpoint to hit when you want a direct response via JSON.
 // The Abot console uses this endpoint.
 func HMain(w http.ResponseWriter, r *http.Request) {
-	errMsg := "Something went wrong with my wiring... I'll get that fixed up soon."
-	ret, _, err := ProcessText(r)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	ret, uid, err := processWithRetries(body)
 	if err != nil {
 		ret = errMsg
-		log.Info("failed to process text", err)
 		// TODO notify plugins listening for errors
 	}
+	progress.done(uid)
 	_, err = fmt.Fprint(w, ret)
 	if err != nil {
 		writeErrorInternal(w, err)
@@ -164,12 +254,14 @@ func HAPILoginSubmit(w http.ResponseWriter, r *http.Request) {
 	}
 	err = bcrypt.CompareHashAndPassword(u.Password, []byte(req.Password))
 	if err == bcrypt.ErrMismatchedHashAndPassword || err == bcrypt.ErrHashTooShort {
+		Audit(AuditEntry{UserID: u.ID, Action: AuditLoginFailed})
 		writeErrorAuth(w, ErrInvalidUserPass)
 		return
 	} else if err != nil {
 		writeErrorInternal(w, err)
 		return
 	}
+	Audit(AuditEntry{UserID: u.ID, Action: AuditLogin})
 	user := &dt.User{
 		ID:      u.ID,
 		Email:   req.Email,
@@ -412,6 +504,28 @@ Response:
 	w.WriteHeader(http.StatusOK)
 }
 
+// HAPIPaymentWebhook receives events from the payment service, such as a card
+// being added or a charge being disputed. The payment driver is responsible for
+// verifying that the request came from the payment service.
+func HAPIPaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if paymentConn == nil {
+		writeErrorBadRequest(w, ErrMissingPaymentDriver)
+		return
+	}
+	evt, err := paymentConn.ParseEvent(r)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if evt != nil {
+		if err = handlePaymentEvent(evt); err != nil {
+			writeErrorInternal(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 // HAPIForgotPasswordSubmit asks the server to send the user a "Forgot
 // Password" email with instructions for resetting their password.
 func HAPIForgotPasswordSubmit(w http.ResponseWriter, r *http.Request) {
@@ -577,7 +691,7 @@ func getAuthToken(u *dt.User) (header *Header, authToken string, err error) {
 	if err != nil {
 		return nil, "", err
 	}
-	hash := hmac.New(sha512.New, []byte(os.Getenv("ABOT_SECRET")))
+	hash := hmac.New(sha512.New, authSecret())
 	_, err = hash.Write(byt)
 	if err != nil {
 		return nil, "", err
@@ -748,7 +862,7 @@ func LoggedIn(w http.ResponseWriter, r *http.Request) bool {
 		writeErrorInternal(w, err)
 		return false
 	}
-	known := hmac.New(sha512.New, []byte(os.Getenv("ABOT_SECRET")))
+	known := hmac.New(sha512.New, authSecret())
 	_, err = known.Write(byt)
 	if err != nil {
 		writeErrorInternal(w, err)
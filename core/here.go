@@ -0,0 +1,120 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// Default freshness of locations. A shared location, like a phone's GPS
+// position, is precise but goes stale as the user moves around, while a city
+// the user says they're in stays true for longer. Operators can change them
+// with the location_shared_ttl_minutes and location_inferred_ttl_minutes
+// settings.
+const (
+	defaultSharedLocationTTL   = 4 * time.Hour
+	defaultInferredLocationTTL = 24 * time.Hour
+)
+
+// locationConfirmWindow is how long after going stale a named location is
+// offered back to the user to confirm, e.g. "Are you still in Chicago?"
+const locationConfirmWindow = 7 * 24 * time.Hour
+
+// regexInLocation matches users telling Abot where they are, like "I'm in
+// Chicago" or "we are at Union Square." Place names must be capitalized.
+var regexInLocation = regexp.MustCompile(`(?:^|\b)(?:[Ii]'?m|[Ii] am|[Ww]e'?re|[Ww]e are|[Ii]'?m currently|[Ii]'?m staying) (?:in|at|visiting) ((?:[A-Z][\w.'-]*)(?: (?:[A-Z][\w.'-]*|of|de)){0,4})`)
+
+// ResolveHere returns the location a user means by "near me" or "around here,"
+// found by nlp.ExtractHere: the location they most recently shared or
+// inferred from them saying where they are, if it's still fresh. Otherwise
+// the location is nil, and the prompt asks the user to share their location
+// or confirm a stale one, e.g. "Are you still in Chicago?"
+func ResolveHere(u *dt.User) (*dt.Location, string, error) {
+	promptNew := "Where are you? Share your location or tell me the city you're in, and I'll look near you."
+	if !u.Registered() {
+		return nil, promptNew, nil
+	}
+	l, err := u.LastLocation(db)
+	if err == dt.ErrNoLocation {
+		return nil, promptNew, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	age := time.Since(l.CreatedAt)
+	ttl := locationTTL(l.Source)
+	if age <= ttl {
+		return l, "", nil
+	}
+	if len(l.Name) > 0 && age <= ttl+locationConfirmWindow {
+		return nil, fmt.Sprintf("Are you still in %s? If not, share your location or tell me where you are.",
+			l.Name), nil
+	}
+	return nil, promptNew, nil
+}
+
+// hereLocation returns the user's location if it's fresh, or
+// dt.ErrNoLocation.
+func hereLocation(u *dt.User) (*dt.Location, error) {
+	l, _, err := ResolveHere(u)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil {
+		return nil, dt.ErrNoLocation
+	}
+	return l, nil
+}
+
+func locationTTL(source string) time.Duration {
+	name, def := "location_shared_ttl_minutes", defaultSharedLocationTTL
+	if source == dt.LocationInferred {
+		name, def = "location_inferred_ttl_minutes",
+			defaultInferredLocationTTL
+	}
+	s := Setting(name)
+	if len(s) == 0 {
+		return def
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		log.Info("invalid location ttl", name, s)
+		return def
+	}
+	return time.Duration(n) * time.Minute
+}
+
+// inferLocation saves where a user says they are, like "I'm in Chicago," as
+// their location if the places service finds exactly one place by that name.
+// Failing to infer it isn't fatal, since the user can still share their
+// location when asked.
+func inferLocation(in *dt.Msg) {
+	if geocoder() == nil || in.User == nil || !in.User.Registered() {
+		return
+	}
+	m := regexInLocation.FindStringSubmatch(in.Sentence)
+	if m == nil {
+		return
+	}
+	ps, err := Geocode(in.User, m[1])
+	if err != nil {
+		log.Info("failed to geocode inferred location", err)
+		return
+	}
+	if len(ps) != 1 {
+		return
+	}
+	l := &dt.Location{
+		Name:   m[1],
+		Lat:    ps[0].Lat,
+		Lon:    ps[0].Lon,
+		Source: dt.LocationInferred,
+	}
+	if err = in.User.SaveLocation(db, l); err != nil {
+		log.Info("failed to save inferred location", err)
+	}
+}
@@ -0,0 +1,322 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// hoursNoticeKey is the shared preference holding when the user was last told
+// Abot's operators are away, as the time they open again, so the notice is
+// sent once each time they're closed.
+const hoursNoticeKey = "hours_notice_until"
+
+// ErrInvalidBusinessHours is returned when saving business hours with an
+// unknown time zone or day, or hours outside a day.
+var ErrInvalidBusinessHours = errors.New("invalid business hours")
+
+// OpenHours are the minutes after midnight a business opens and closes on a
+// day. Hours closing before they open, like 18:00 to 2:00, close the
+// following day.
+type OpenHours struct {
+	Open  int
+	Close int
+}
+
+// BusinessHours are when a tenant's staff are available to take over
+// conversations from Abot. Outside them, Abot begins its responses with a
+// notice saying when they open, queues requests for a person as callbacks and
+// tells users a person will follow up once they open. Tenants without any
+// hours are always open.
+type BusinessHours struct {
+	// TimeZone is the IANA time zone the hours are in, e.g.
+	// "America/New_York." It defaults to UTC.
+	TimeZone string
+
+	// Days are the hours open by the day of the week in lowercase
+	// English, e.g. "monday." Days without hours are closed.
+	Days map[string]OpenHours
+
+	// Greeting begins Abot's response to a user's first message, e.g.
+	// "Hi, I'm Acme's assistant."
+	Greeting string
+
+	// Notice begins Abot's responses outside business hours, replacing
+	// "{opens}" with when they open, e.g. "tomorrow at 9:00am EST." It
+	// defaults to "We're closed right now and open again {opens}."
+	Notice string
+
+	UpdatedAt time.Time
+}
+
+var hours = &BusinessHours{}
+var hoursMu sync.RWMutex
+
+// validate normalizes business hours, checking that they can be evaluated.
+func (h *BusinessHours) validate() error {
+	if _, err := time.LoadLocation(h.TimeZone); err != nil {
+		return fmt.Errorf("%s: time zone %q", ErrInvalidBusinessHours,
+			h.TimeZone)
+	}
+	days := map[string]OpenHours{}
+	for day, oh := range h.Days {
+		day = strings.ToLower(strings.TrimSpace(day))
+		if _, ok := weekdays[day]; !ok {
+			return fmt.Errorf("%s: day %q", ErrInvalidBusinessHours, day)
+		}
+		if oh.Open < 0 || oh.Open >= 24*60 || oh.Close < 0 ||
+			oh.Close > 24*60 || oh.Open == oh.Close {
+
+			return fmt.Errorf("%s: hours on %s", ErrInvalidBusinessHours,
+				day)
+		}
+		days[day] = oh
+	}
+	h.Days = days
+	return nil
+}
+
+// location returns the business hours' time zone, or UTC if it's invalid.
+func (h *BusinessHours) location() *time.Location {
+	loc, err := time.LoadLocation(h.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// hoursOn returns the hours open on a day of the week.
+func (h *BusinessHours) hoursOn(d time.Weekday) (OpenHours, bool) {
+	oh, ok := h.Days[strings.ToLower(d.String())]
+	return oh, ok
+}
+
+// OpenAt reports whether a time falls within business hours.
+func (h *BusinessHours) OpenAt(t time.Time) bool {
+	if h == nil || len(h.Days) == 0 {
+		return true
+	}
+	t = t.In(h.location())
+	mins := t.Hour()*60 + t.Minute()
+	if oh, ok := h.hoursOn(t.Weekday()); ok {
+		if oh.Open <= oh.Close && mins >= oh.Open && mins < oh.Close {
+			return true
+		}
+		if oh.Open > oh.Close && mins >= oh.Open {
+			return true
+		}
+	}
+	// Hours from the day before may run past midnight.
+	oh, ok := h.hoursOn(t.AddDate(0, 0, -1).Weekday())
+	return ok && oh.Open > oh.Close && mins < oh.Close
+}
+
+// NextOpen returns when business next opens after a time, or the time itself
+// if business is open then.
+func (h *BusinessHours) NextOpen(t time.Time) time.Time {
+	if h.OpenAt(t) {
+		return t
+	}
+	lt := t.In(h.location())
+	for i := 0; i <= 7; i++ {
+		day := lt.AddDate(0, 0, i)
+		oh, ok := h.hoursOn(day.Weekday())
+		if !ok {
+			continue
+		}
+		open := time.Date(day.Year(), day.Month(), day.Day(), oh.Open/60,
+			oh.Open%60, 0, 0, lt.Location())
+		if open.After(t) {
+			return open
+		}
+	}
+	return t
+}
+
+// notice tells users when business opens again.
+func (h *BusinessHours) notice(now, opens time.Time) string {
+	s := h.Notice
+	if len(s) == 0 {
+		s = "We're closed right now and open again {opens}."
+	}
+	return strings.Replace(s, "{opens}", opensPhrase(now, opens), -1)
+}
+
+// opensPhrase describes when business opens relative to now, e.g. "tomorrow
+// at 9:00am EST."
+func opensPhrase(now, opens time.Time) string {
+	now = now.In(opens.Location())
+	clock := opens.Format("3:04pm MST")
+	y, m, d := now.Date()
+	oy, om, od := opens.Date()
+	switch {
+	case y == oy && m == om && d == od:
+		return "today at " + clock
+	case opens.Sub(time.Date(y, m, d, 0, 0, 0, 0,
+		now.Location())) < 48*time.Hour:
+		return "tomorrow at " + clock
+	}
+	return opens.Format("Monday") + " at " + clock
+}
+
+// currentHours returns this tenant's business hours.
+func currentHours() *BusinessHours {
+	hoursMu.RLock()
+	defer hoursMu.RUnlock()
+	return hours
+}
+
+// GetBusinessHours returns this tenant's business hours.
+func GetBusinessHours() (*BusinessHours, error) {
+	var row struct {
+		TimeZone  string
+		Days      []byte
+		Greeting  string
+		Notice    string
+		UpdatedAt time.Time
+	}
+	q := `SELECT timezone, days, greeting, notice, updatedat
+	      FROM businesshours
+	      WHERE tenant=$1`
+	err := db.Get(&row, q, tenant())
+	if err == sql.ErrNoRows {
+		return &BusinessHours{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	h := &BusinessHours{
+		TimeZone:  row.TimeZone,
+		Greeting:  row.Greeting,
+		Notice:    row.Notice,
+		UpdatedAt: row.UpdatedAt,
+	}
+	if err = json.Unmarshal(row.Days, &h.Days); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// SetBusinessHours saves this tenant's business hours, replacing any already
+// set. They take effect immediately.
+func SetBusinessHours(h *BusinessHours) error {
+	if err := h.validate(); err != nil {
+		return err
+	}
+	days, err := json.Marshal(h.Days)
+	if err != nil {
+		return err
+	}
+	q := `INSERT INTO businesshours (tenant, timezone, days, greeting, notice)
+	      VALUES ($1, $2, $3, $4, $5)
+	      ON CONFLICT (tenant) DO UPDATE
+	      SET timezone=$2, days=$3, greeting=$4, notice=$5,
+	          updatedat=CURRENT_TIMESTAMP`
+	_, err = db.Exec(q, tenant(), h.TimeZone, days, h.Greeting, h.Notice)
+	if err != nil {
+		return err
+	}
+	return loadBusinessHours()
+}
+
+// loadBusinessHours caches this tenant's business hours in memory.
+func loadBusinessHours() error {
+	h, err := GetBusinessHours()
+	if err != nil {
+		return err
+	}
+	hoursMu.Lock()
+	hours = h
+	hoursMu.Unlock()
+	return nil
+}
+
+// applyBusinessHours begins Abot's response with the tenant's greeting if
+// it's the user's first message, and with a notice saying when business
+// opens if it's closed and the user hasn't been told since it last closed.
+func applyBusinessHours(in *dt.Msg, resp string) string {
+	if in.User == nil || !in.User.Registered() {
+		return resp
+	}
+	h := currentHours()
+	if len(h.Greeting) > 0 {
+		var first bool
+		q := `SELECT COUNT(*)<2 FROM (
+		          SELECT 1 FROM messages
+		          WHERE userid=$1 AND abotsent IS FALSE
+		          LIMIT 2) AS m`
+		if err := db.Get(&first, q, in.User.ID); err != nil {
+			log.Info("failed to check for first message", err)
+		} else if first {
+			resp = h.Greeting + " " + resp
+		}
+	}
+	now := time.Now()
+	if h.OpenAt(now) || !noticeDue(in.User, now) {
+		return resp
+	}
+	opens := h.NextOpen(now)
+	markNoticed(in.User, opens)
+	return h.notice(now, opens) + " " + resp
+}
+
+// noticeDue reports whether the user hasn't been told when business opens
+// since it last closed.
+func noticeDue(u *dt.User, now time.Time) bool {
+	s, err := u.GetPref(db, "", hoursNoticeKey)
+	if err == dt.ErrNoPref {
+		return true
+	}
+	if err != nil {
+		log.Info("failed to get hours notice", err)
+		return false
+	}
+	until, err := time.Parse(time.RFC3339, s)
+	return err != nil || !now.Before(until)
+}
+
+// markNoticed records that the user was told business opens at a time.
+func markNoticed(u *dt.User, opens time.Time) {
+	err := u.SetPref(db, "", hoursNoticeKey, opens.Format(time.RFC3339))
+	if err != nil {
+		log.Info("failed to save hours notice", err)
+	}
+}
+
+// HAPIBusinessHours responds with this tenant's business hours.
+func HAPIBusinessHours(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	h, err := GetBusinessHours()
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, h)
+}
+
+// HAPIBusinessHoursSubmit replaces this tenant's business hours.
+func HAPIBusinessHoursSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	h := &BusinessHours{}
+	if err := json.NewDecoder(r.Body).Decode(h); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err := SetBusinessHours(h); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
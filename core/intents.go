@@ -0,0 +1,43 @@
+package core
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// normalizeIntent makes intent keys that differ only in case or spacing
+// match, e.g. "Book flight UA100" and "book  flight ua100".
+func normalizeIntent(key string) string {
+	return strings.ToLower(strings.Join(strings.Fields(key), " "))
+}
+
+// RecordIntent records that a plugin carried out a transactional intent for a
+// user, like "book flight UA100 on 2016-05-01," so the same intent arriving
+// again can be caught before it's carried out twice.
+func RecordIntent(uid uint64, pluginName, key string) error {
+	q := `INSERT INTO intents (userid, pluginname, key) VALUES ($1, $2, $3)`
+	_, err := db.Exec(q, uid, pluginName, normalizeIntent(key))
+	return err
+}
+
+// LastIntent returns when a plugin last carried out an intent for a user
+// within a window of time, or nil if it hasn't.
+func LastIntent(uid uint64, pluginName, key string,
+	window time.Duration) (*time.Time, error) {
+
+	var t time.Time
+	q := `SELECT createdat FROM intents
+	      WHERE userid=$1 AND pluginname=$2 AND key=$3 AND createdat>$4
+	      ORDER BY createdat DESC
+	      LIMIT 1`
+	err := db.Get(&t, q, uid, pluginName, normalizeIntent(key),
+		time.Now().Add(-window))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+)
+
+// job is a function that Abot's scheduler runs at a regular interval on behalf
+// of a plugin, such as polling an external API for updates.
+type job struct {
+	name     string
+	interval time.Duration
+	fn       func() error
+	nextRun  time.Time
+}
+
+var jobs = []*job{}
+var jobsMu sync.Mutex
+
+// RegisterJob schedules fn to run every interval, starting one interval from
+// now. Jobs are checked once per minute, so intervals shorter than a minute
+// are run each minute. The name is used in logs if the job returns an error.
+func RegisterJob(name string, interval time.Duration, fn func() error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	jobs = append(jobs, &job{
+		name:     name,
+		interval: interval,
+		fn:       fn,
+		nextRun:  time.Now().Add(interval),
+	})
+}
+
+// runJobs runs each job that's due with every tick. Each job is run in its own
+// goroutine, so a slow external API can't hold up other plugins.
+func runJobs(t *time.Ticker) {
+	for now := range t.C {
+		jobsMu.Lock()
+		for _, j := range jobs {
+			if now.Before(j.nextRun) {
+				continue
+			}
+			j.nextRun = now.Add(j.interval)
+			go runJob(j)
+		}
+		jobsMu.Unlock()
+	}
+}
+
+// runJob runs a job, recording the run in its history. See HAPIJobs.
+func runJob(j *job) {
+	start := time.Now()
+	err := j.fn()
+	var errStr string
+	if err != nil {
+		log.Info("job failed", j.name, err)
+		errStr = err.Error()
+	}
+	q := `INSERT INTO jobruns (name, startedat, finishedat, error)
+	      VALUES ($1, $2, $3, $4)`
+	if _, err = db.Exec(q, j.name, start, time.Now(), errStr); err != nil {
+		log.Info("failed to record job run", j.name, err)
+	}
+}
+
+// jobRunsShown is how many of each job's most recent runs HAPIJobs responds
+// with.
+const jobRunsShown = 10
+
+// JobRun is a run of a job, with the error it returned, if any.
+type JobRun struct {
+	Name       string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Error      string
+}
+
+// JobStatus describes a job on the scheduler and its most recent runs.
+type JobStatus struct {
+	Name     string
+	Interval string
+	NextRun  time.Time
+	Runs     []JobRun
+}
+
+// HAPIJobs responds with the jobs on the scheduler, including built-in
+// maintenance, with their recent runs and any tables that need vacuuming.
+func HAPIJobs(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	var runs []JobRun
+	q := `SELECT name, startedat, finishedat, error FROM (
+	          SELECT name, startedat, finishedat, error,
+	              ROW_NUMBER() OVER (PARTITION BY name
+	                  ORDER BY startedat DESC) AS n
+	          FROM jobruns) AS r
+	      WHERE n<=$1
+	      ORDER BY startedat DESC`
+	if err := db.Select(&runs, q, jobRunsShown); err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	byName := map[string][]JobRun{}
+	for _, run := range runs {
+		byName[run.Name] = append(byName[run.Name], run)
+	}
+	var resp struct {
+		Jobs       []JobStatus
+		TableHints []TableHint
+	}
+	jobsMu.Lock()
+	for _, j := range jobs {
+		resp.Jobs = append(resp.Jobs, JobStatus{
+			Name:     j.name,
+			Interval: j.interval.String(),
+			NextRun:  j.nextRun,
+			Runs:     byName[j.name],
+		})
+	}
+	jobsMu.Unlock()
+	sort.Sort(jobsByName(resp.Jobs))
+	tableHints.RLock()
+	resp.TableHints = tableHints.hints
+	tableHints.RUnlock()
+	writeBytes(w, resp)
+}
+
+type jobsByName []JobStatus
+
+func (j jobsByName) Len() int           { return len(j) }
+func (j jobsByName) Swap(a, b int)      { j[a], j[b] = j[b], j[a] }
+func (j jobsByName) Less(a, b int) bool { return j[a].Name < j[b].Name }
@@ -0,0 +1,181 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// ConversationLabel marks a user's conversation with Abot for operators, such
+// as "billing_issue" or "bug." Labels stay open until resolved.
+type ConversationLabel struct {
+	ID         uint64
+	UserID     uint64
+	MessageID  uint64
+	Label      string
+	CreatedBy  string
+	ResolvedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// LabelConversation attaches a label to a user's conversation, optionally at
+// a specific message. createdBy records who added it, such as a plugin's name.
+// Adding a label that's already open on the conversation does nothing.
+func LabelConversation(uid, msgID uint64, label, createdBy string) error {
+	label = dt.NormalizeTag(label)
+	if len(label) == 0 {
+		return dt.ErrInvalidTag
+	}
+	var mid interface{}
+	if msgID > 0 {
+		mid = msgID
+	}
+	q := `INSERT INTO conversationlabels
+	      (userid, messageid, label, createdby) VALUES ($1, $2, $3, $4)
+	      ON CONFLICT (userid, label) WHERE resolvedat IS NULL DO NOTHING`
+	_, err := db.Exec(q, uid, mid, label, createdBy)
+	return err
+}
+
+// ResolveLabel closes an open label on a user's conversation, e.g. once a
+// billing issue is fixed. It is not an error to resolve a label that isn't
+// open.
+func ResolveLabel(uid uint64, label string) error {
+	q := `UPDATE conversationlabels SET resolvedat=CURRENT_TIMESTAMP
+	      WHERE userid=$1 AND label=$2 AND resolvedat IS NULL`
+	_, err := db.Exec(q, uid, dt.NormalizeTag(label))
+	return err
+}
+
+// ConversationLabels returns labels on conversations, newest first. An empty
+// label returns every label, and open limits results to unresolved labels.
+func ConversationLabels(label string, open bool) ([]ConversationLabel,
+	error) {
+
+	q := `SELECT id, userid, COALESCE(messageid, 0) AS messageid, label,
+	          createdby, resolvedat, createdat
+	      FROM conversationlabels
+	      WHERE ($1='' OR label=$1) AND ($2 IS FALSE OR resolvedat IS NULL)
+	      ORDER BY createdat DESC`
+	var ls []ConversationLabel
+	if err := db.Select(&ls, q, dt.NormalizeTag(label), open); err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
+
+// LabelReport counts each label added between two times along with how many
+// of those remain open, for reporting on support workload.
+type LabelReport struct {
+	Label string
+	Total int
+	Open  int
+}
+
+// LabelReports counts the labels added between two times. Zero times are
+// ignored.
+func LabelReports(after, before time.Time) ([]LabelReport, error) {
+	if before.IsZero() {
+		before = time.Now()
+	}
+	q := `SELECT label, COUNT(*) AS total,
+	          COUNT(*) FILTER (WHERE resolvedat IS NULL) AS open
+	      FROM conversationlabels
+	      WHERE createdat>=$1 AND createdat<$2
+	      GROUP BY label
+	      ORDER BY total DESC, label`
+	var rs []LabelReport
+	if err := db.Select(&rs, q, after, before); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// HAPIConversationLabels responds with labeled conversations filtered by the
+// label and open query parameters, or with a report of label counts between
+// the after and before RFC 3339 times if report=true.
+func HAPIConversationLabels(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !Admin(w, r) {
+			return
+		}
+		if !LoggedIn(w, r) {
+			return
+		}
+	}
+	v := r.URL.Query()
+	if v.Get("report") == "true" {
+		var after, before time.Time
+		var err error
+		if s := v.Get("after"); len(s) > 0 {
+			if after, err = time.Parse(time.RFC3339, s); err != nil {
+				writeErrorBadRequest(w, err)
+				return
+			}
+		}
+		if s := v.Get("before"); len(s) > 0 {
+			if before, err = time.Parse(time.RFC3339, s); err != nil {
+				writeErrorBadRequest(w, err)
+				return
+			}
+		}
+		rs, err := LabelReports(after, before)
+		if err != nil {
+			writeErrorInternal(w, err)
+			return
+		}
+		writeBytes(w, struct{ Labels []LabelReport }{Labels: rs})
+		return
+	}
+	ls, err := ConversationLabels(v.Get("label"), v.Get("open") == "true")
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Labels []ConversationLabel }{Labels: ls})
+}
+
+// HAPIConversationLabelsSubmit labels a user's conversation, or resolves the
+// label if Resolve is true. The label is recorded as created by the operator.
+func HAPIConversationLabelsSubmit(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !Admin(w, r) {
+			return
+		}
+		if !LoggedIn(w, r) {
+			return
+		}
+		if !CSRF(w, r) {
+			return
+		}
+	}
+	var req struct {
+		UserID    uint64
+		MessageID uint64
+		Label     string
+		Resolve   bool
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	var err error
+	if req.Resolve {
+		err = ResolveLabel(req.UserID, req.Label)
+	} else {
+		err = LabelConversation(req.UserID, req.MessageID, req.Label,
+			operatorName(r))
+	}
+	if err == dt.ErrInvalidTag {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
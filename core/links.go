@@ -0,0 +1,174 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Short links are a random code followed by a signature over it, so links
+// can't be guessed by walking through codes.
+const (
+	linkCodeLen = 8
+	linkSigLen  = 6
+)
+
+// ErrInvalidLink is returned when a short link's code or signature is invalid.
+var ErrInvalidLink = errors.New("invalid link")
+
+var linkChars = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Link is a short link sent to a user and how often it's been followed.
+type Link struct {
+	ID          uint64
+	Code        string
+	URL         string
+	UserID      uint64
+	MessageID   uint64
+	PluginName  string
+	Clicks      int
+	LastClicked *time.Time
+	CreatedAt   time.Time
+}
+
+// ShortLink returns a short, signed link to target, e.g. a checkout page, map
+// or calendar invite, for sending to a user over channels like SMS that can't
+// render rich cards. Following the link is recorded against the message it
+// was sent in response to, which may be 0 if there isn't one.
+func ShortLink(u *dt.User, msgID uint64, pluginName, target string) (string,
+	error) {
+
+	if _, err := url.ParseRequestURI(target); err != nil {
+		return "", err
+	}
+	code, err := linkCode()
+	if err != nil {
+		return "", err
+	}
+	q := `INSERT INTO links (code, url, userid, messageid, pluginname)
+	      VALUES ($1, $2, $3, $4, $5)`
+	var mid interface{}
+	if msgID > 0 {
+		mid = msgID
+	}
+	if _, err = db.Exec(q, code, target, u.ID, mid, pluginName); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(os.Getenv("ABOT_URL"), "/") + "/l/" + code +
+		linkSig(code), nil
+}
+
+// linkCode generates a random code for a short link, leaving out characters
+// that are easily confused when retyped, like 0 and O.
+func linkCode() (string, error) {
+	max := big.NewInt(int64(len(linkChars)))
+	b := make([]byte, linkCodeLen)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = linkChars[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// linkSig signs a short link's code. Links are signed with ABOT_SECRET alone
+// rather than authSecret, so rotating auth keys doesn't break links already
+// sent to users.
+func linkSig(code string) string {
+	h := hmac.New(sha256.New, []byte(os.Getenv("ABOT_SECRET")))
+	_, _ = h.Write([]byte(code))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))[:linkSigLen]
+}
+
+// verifyLink checks the signature of a short link's path, returning its code.
+func verifyLink(s string) (string, error) {
+	if len(s) != linkCodeLen+linkSigLen {
+		return "", ErrInvalidLink
+	}
+	code, sig := s[:linkCodeLen], s[linkCodeLen:]
+	if !hmac.Equal([]byte(sig), []byte(linkSig(code))) {
+		return "", ErrInvalidLink
+	}
+	return code, nil
+}
+
+// HLink records a click on a short link and redirects to its target.
+func HLink(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	code, err := verifyLink(ps.ByName("code"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var l struct {
+		ID  uint64
+		URL string
+	}
+	q := `SELECT id, url FROM links WHERE code=$1`
+	if err = db.Get(&l, q, code); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	ua := r.UserAgent()
+	if len(ua) > 255 {
+		ua = ua[:255]
+	}
+	q = `INSERT INTO linkclicks (linkid, useragent) VALUES ($1, $2)`
+	if _, err = db.Exec(q, l.ID, ua); err != nil {
+		// Never strand a user because tracking failed.
+		log.Info("failed to record link click", err)
+	}
+	http.Redirect(w, r, l.URL, http.StatusFound)
+}
+
+// LinksFor returns the short links sent to a user newest first, with how
+// often each was followed.
+func LinksFor(uid uint64) ([]Link, error) {
+	q := `SELECT l.id, l.code, l.url, l.userid,
+	          COALESCE(l.messageid, 0) AS messageid, l.pluginname,
+	          COUNT(c.id) AS clicks, MAX(c.createdat) AS lastclicked,
+	          l.createdat
+	      FROM links AS l
+	      LEFT JOIN linkclicks AS c ON c.linkid=l.id
+	      WHERE l.userid=$1
+	      GROUP BY l.id
+	      ORDER BY l.createdat DESC
+	      LIMIT $2`
+	var links []Link
+	if err := db.Select(&links, q, uid, maxAdminResults); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// HAPILinks responds with the short links sent to a user and their clicks.
+func HAPILinks(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	uid, err := strconv.ParseUint(r.URL.Query().Get("userid"), 10, 64)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	links, err := LinksFor(uid)
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Links []Link }{Links: links})
+}
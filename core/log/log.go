@@ -1,13 +1,16 @@
 // Package log is a logger used by Abot core and plugins. It standardizes
 // logging formats consistent with Abot's needs, allowing for a debug mode, and
 // enabling plugins to specify their own name so each plugin's logs are easy to
-// isolate.
+// isolate. Personal information, like card numbers, is masked in every log.
+// See redact.FieldLog.
 package log
 
 import (
 	"fmt"
 	"log"
 	"os"
+
+	"github.com/itsabot/abot/shared/helpers/redact"
 )
 
 var debugprefix = "DEBUG: "
@@ -44,7 +47,7 @@ func Info(v ...interface{}) {
 
 // Infof logs a statement, allowing for custom formatting.
 func Infof(format string, v ...interface{}) {
-	msg := fmt.Sprintf(format, v...)
+	msg := redact.String(redact.FieldLog, fmt.Sprintf(format, v...))
 	log.Print(msg)
 }
 
@@ -84,7 +87,7 @@ func Fatal(v ...interface{}) {
 // Fatalf logs a statement and kills the running process, allowing for custom
 // formatting.
 func Fatalf(format string, v ...interface{}) {
-	log.Fatalf(format, v...)
+	log.Fatal(redact.String(redact.FieldLog, fmt.Sprintf(format, v...)))
 }
 
 // Logger is defined for plugins to use in place of the stdlib log. It sets a
@@ -134,19 +137,20 @@ func (l *Logger) Info(v ...interface{}) {
 
 // Infof logs a statement, allowing for custom formatting.
 func (l *Logger) Infof(format string, v ...interface{}) {
-	msg := fmt.Sprintf(format, v...)
+	msg := redact.String(redact.FieldLog, fmt.Sprintf(format, v...))
 	l.logger.Printf(msg)
 }
 
 // Fatal logs a statement and kills the running process.
 func (l *Logger) Fatal(v ...interface{}) {
-	l.logger.Fatalf("%s", l.prefix+fmt.Sprintln(v...))
+	l.logger.Fatal(redact.String(redact.FieldLog,
+		l.prefix+fmt.Sprintln(v...)))
 }
 
 // Fatalf logs a statement and kills the running process, allowing for custom
 // formatting.
 func (l *Logger) Fatalf(format string, v ...interface{}) {
-	l.logger.Fatalf(format, v...)
+	l.logger.Fatal(redact.String(redact.FieldLog, fmt.Sprintf(format, v...)))
 }
 
 // SetFlags enables customizing flags just like the standard library's
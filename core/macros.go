@@ -0,0 +1,299 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// ErrInvalidMacro is returned when saving a macro that's missing a name or
+// body, uses an unknown variable or maps to a route that isn't in the form
+// command_object.
+var ErrInvalidMacro = errors.New("invalid macro")
+
+// ErrMacroNotFound is returned when using a macro that doesn't exist.
+var ErrMacroNotFound = errors.New("macro not found")
+
+// Macro is a canned response operators send to users from the console, or
+// that answers messages routed to an intent no plugin handles, so common
+// replies are fast and consistent. Macros are saved for the tenant named by
+// ABOT_TENANT.
+//
+// Bodies may use the variables in macroVars, like "Hi {{first_name}}," with an
+// optional default for users missing the value, like "{{first_name|there}}."
+type Macro struct {
+	ID   uint64
+	Name string
+	Body string
+
+	// Route optionally maps the macro to an intent in the form
+	// command_object, e.g. "return_policy."
+	Route string
+
+	CreatedAt time.Time
+}
+
+// regexMacroVar matches a macro's variables, like "{{first_name}}" or
+// "{{first_name|there}}."
+var regexMacroVar = regexp.MustCompile(`\{\{\s*(\w+)\s*(?:\|([^}]*))?\}\}`)
+
+// macroVars look up the values of a macro's variables from what's known
+// about the user. Empty values use the variable's default.
+var macroVars = map[string]func(u *dt.User) string{
+	"name": func(u *dt.User) string { return u.Name },
+	"first_name": func(u *dt.User) string {
+		if fs := strings.Fields(u.Name); len(fs) > 0 {
+			return fs[0]
+		}
+		return ""
+	},
+	"email": func(u *dt.User) string { return u.Email },
+	"location": func(u *dt.User) string {
+		l, err := u.LastLocation(db)
+		if err != nil {
+			return ""
+		}
+		return l.Name
+	},
+}
+
+// validate normalizes a macro, checking its variables and route.
+func (m *Macro) validate() error {
+	m.Name = dt.NormalizeTag(m.Name)
+	m.Route = strings.ToLower(strings.TrimSpace(m.Route))
+	if len(m.Name) == 0 || len(strings.TrimSpace(m.Body)) == 0 {
+		return ErrInvalidMacro
+	}
+	for _, v := range regexMacroVar.FindAllStringSubmatch(m.Body, -1) {
+		if _, ok := macroVars[v[1]]; !ok {
+			return fmt.Errorf("%s: unknown variable %q", ErrInvalidMacro,
+				v[1])
+		}
+	}
+	if len(m.Route) > 0 {
+		parts := strings.SplitN(m.Route, "_", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return fmt.Errorf("%s: route %q", ErrInvalidMacro, m.Route)
+		}
+	}
+	return nil
+}
+
+// Render fills in a macro's variables for a user.
+func (m *Macro) Render(u *dt.User) string {
+	return regexMacroVar.ReplaceAllStringFunc(m.Body, func(s string) string {
+		v := regexMacroVar.FindStringSubmatch(s)
+		fn, ok := macroVars[v[1]]
+		if !ok {
+			return v[2]
+		}
+		if val := fn(u); len(val) > 0 {
+			return val
+		}
+		return v[2]
+	})
+}
+
+// Macros returns this tenant's macros by name.
+func Macros() ([]Macro, error) {
+	q := `SELECT id, name, body, route, createdat FROM macros
+	      WHERE tenant=$1
+	      ORDER BY name`
+	var ms []Macro
+	if err := db.Select(&ms, q, tenant()); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// SaveMacro saves a macro for this tenant, replacing any of the same name.
+func SaveMacro(m *Macro) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	q := `INSERT INTO macros (tenant, name, body, route)
+	      VALUES ($1, $2, $3, $4)
+	      ON CONFLICT (tenant, name) DO UPDATE SET body=$3, route=$4
+	      RETURNING id, createdat`
+	return db.QueryRowx(q, tenant(), m.Name, m.Body, m.Route).Scan(&m.ID,
+		&m.CreatedAt)
+}
+
+// DeleteMacro deletes one of this tenant's macros by name.
+func DeleteMacro(name string) error {
+	q := `DELETE FROM macros WHERE tenant=$1 AND name=$2`
+	res, err := db.Exec(q, tenant(), dt.NormalizeTag(name))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrMacroNotFound
+	}
+	return nil
+}
+
+// SendMacro sends a macro to a user on the channel they last messaged Abot
+// through, adding it to their transcript. It returns the message sent.
+func SendMacro(name string, uid uint64) (string, error) {
+	var m Macro
+	q := `SELECT id, name, body, route, createdat FROM macros
+	      WHERE tenant=$1 AND name=$2`
+	if err := db.Get(&m, q, tenant(), dt.NormalizeTag(name)); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrMacroNotFound
+		}
+		return "", err
+	}
+	u := &dt.User{}
+	q = `SELECT id, name, email FROM users WHERE id=$1`
+	if err := db.Get(u, q, uid); err != nil {
+		return "", err
+	}
+	fid, fidT, err := u.LastFlexID(db)
+	if err != nil {
+		return "", err
+	}
+	u.FlexID, u.FlexIDType = fid, fidT
+	content := m.Render(u)
+	err = sendRendered(dt.ScheduledEvent{
+		Content:    content,
+		FlexID:     fid,
+		FlexIDType: fidT,
+	})
+	if err != nil {
+		return "", err
+	}
+	msg := &dt.Msg{User: u, Sentence: content, AbotSent: true}
+	if err = msg.Save(db); err != nil {
+		return "", err
+	}
+	return content, nil
+}
+
+// answerWithMacro answers a message with the macro mapped to its intent, if
+// any. It's only used for messages no plugin handles.
+func answerWithMacro(in *dt.Msg) string {
+	si := in.StructuredInput
+	var routes []string
+	for _, c := range si.Commands {
+		if si.Negated(c) {
+			continue
+		}
+		for _, o := range si.Objects {
+			routes = append(routes, strings.ToLower(c+"_"+o))
+		}
+	}
+	if len(routes) == 0 {
+		return ""
+	}
+	var m Macro
+	q := `SELECT id, name, body, route, createdat FROM macros
+	      WHERE tenant=$1 AND route=ANY($2::varchar[])
+	      ORDER BY array_position($2::varchar[], route)
+	      LIMIT 1`
+	err := db.Get(&m, q, tenant(), nlp.StringSlice(routes))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Info("failed to find macro", err)
+		}
+		return ""
+	}
+	return m.Render(in.User)
+}
+
+// HAPIMacros responds with this tenant's macros.
+func HAPIMacros(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	ms, err := Macros()
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Macros []Macro }{Macros: ms})
+}
+
+// HAPIMacrosSubmit saves a macro, replacing any of the same name.
+func HAPIMacrosSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct{ Macro Macro }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err := req.Macro.validate(); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err := SaveMacro(&req.Macro); err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, req.Macro)
+}
+
+// HAPIMacrosDelete deletes a macro by name.
+func HAPIMacrosDelete(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct{ Name string }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := DeleteMacro(req.Name)
+	if err == ErrMacroNotFound {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HAPIMacrosSend sends a macro to a user, e.g. from a conversation handed off
+// to a person, responding with the message sent.
+func HAPIMacrosSend(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct {
+		Name   string
+		UserID uint64
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	content, err := SendMacro(req.Name, req.UserID)
+	if err == ErrMacroNotFound || err == sql.ErrNoRows ||
+		err == ErrRecipientPaused {
+
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Sentence string }{Sentence: content})
+}
@@ -0,0 +1,371 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/lib/pq"
+)
+
+// ErrNotAbotSent is returned when resending a message that the user sent
+// rather than Abot.
+var ErrNotAbotSent = errors.New("message was not sent by abot")
+
+// alreadyApplied are the Postgres error codes returned when a migration's
+// tables, columns or indexes already exist, meaning it was applied before
+// migrations were tracked, e.g. by cmd/dbsetup.sh.
+var alreadyApplied = map[pq.ErrorCode]struct{}{
+	"42P07": {}, // duplicate_table
+	"42701": {}, // duplicate_column
+	"42710": {}, // duplicate_object
+	"42P06": {}, // duplicate_schema
+}
+
+// deadLetterAge is how long past its send time an event may go unsent before
+// it's swept into the dead letters, so a message that can't be delivered isn't
+// retried forever.
+const deadLetterAge = 24 * time.Hour
+
+// jobHistoryAge is how long the history of job runs is kept.
+const jobHistoryAge = 14 * 24 * time.Hour
+
+// TableHint is a table Postgres' autovacuum is falling behind on, which an
+// operator should VACUUM or tune autovacuum for.
+type TableHint struct {
+	Table      string
+	LiveRows   int64
+	DeadRows   int64
+	AnalyzedAt *time.Time
+}
+
+var tableHints = struct {
+	sync.RWMutex
+	hints []TableHint
+}{}
+
+func init() {
+	RegisterJob("conversation_vars", time.Hour, func() error {
+		return dt.DeleteExpiredVars(db)
+	})
+	RegisterJob("analyze_tables", 24*time.Hour, analyzeTables)
+	RegisterJob("expired_context", time.Hour, deleteExpiredContext)
+	RegisterJob("orphaned_identities", 24*time.Hour, pruneOrphanedIdentities)
+	RegisterJob("dead_letters", time.Hour, sweepDeadLetters)
+	RegisterJob("user_deletion", time.Hour, deleteRequestedUsers)
+	RegisterJob("job_history", 24*time.Hour, func() error {
+		q := `DELETE FROM jobruns WHERE startedat<$1`
+		_, err := db.Exec(q, time.Now().Add(-jobHistoryAge))
+		return err
+	})
+}
+
+// analyzeTables refreshes the planner's statistics for tables that changed a
+// lot since they were last analyzed and records hints for tables with many
+// dead rows. VACUUM can't run in the background without holding up the
+// tables, so it's left to operators.
+func analyzeTables() error {
+	var stats []struct {
+		Name       string
+		Live       int64
+		Dead       int64
+		Modified   int64
+		AnalyzedAt *time.Time
+	}
+	q := `SELECT relname AS name, n_live_tup AS live, n_dead_tup AS dead,
+	          n_mod_since_analyze AS modified,
+	          GREATEST(last_analyze, last_autoanalyze) AS analyzedat
+	      FROM pg_stat_user_tables
+	      ORDER BY relname`
+	if err := db.Select(&stats, q); err != nil {
+		return err
+	}
+	var hints []TableHint
+	for _, st := range stats {
+		// These match the thresholds autovacuum uses by default, with
+		// room to spare, so only tables it's missing are touched.
+		if st.Modified > 1000+st.Live/5 {
+			q = `ANALYZE "` +
+				strings.Replace(st.Name, `"`, `""`, -1) + `"`
+			if _, err := db.Exec(q); err != nil {
+				return err
+			}
+			log.Debug("analyzed", st.Name)
+		}
+		if st.Dead > 1000+st.Live/2 {
+			hints = append(hints, TableHint{
+				Table:      st.Name,
+				LiveRows:   st.Live,
+				DeadRows:   st.Dead,
+				AnalyzedAt: st.AnalyzedAt,
+			})
+		}
+	}
+	if len(hints) > 0 {
+		log.Info("tables need vacuuming", len(hints))
+	}
+	tableHints.Lock()
+	tableHints.hints = hints
+	tableHints.Unlock()
+	return nil
+}
+
+// deleteExpiredContext deletes what users referred to, the times they're
+// discussing, verification codes and challenges once they can no longer be
+// used.
+func deleteExpiredContext() error {
+	now := time.Now()
+	ttl := crossChannelContextTTL()
+	if ttl < dt.ConversationTTL {
+		ttl = dt.ConversationTTL
+	}
+	q := `DELETE FROM contextentities WHERE updatedat<$1`
+	if _, err := db.Exec(q, now.Add(-ttl)); err != nil {
+		return err
+	}
+	q = `DELETE FROM timecontexts WHERE updatedat<$1`
+	if _, err := db.Exec(q, now.Add(-timeContextTTL)); err != nil {
+		return err
+	}
+	q = `DELETE FROM verifications WHERE expiresat<$1`
+	if _, err := db.Exec(q, now); err != nil {
+		return err
+	}
+	q = `DELETE FROM challenges
+	     WHERE expiresat<$1 AND (passedat IS NULL OR passedat<$2)`
+	if _, err := db.Exec(q, now, now.Add(-challengeTTL)); err != nil {
+		return err
+	}
+	q = `DELETE FROM challengeattempts WHERE createdat<$1`
+	_, err := db.Exec(q, now.Add(-time.Hour))
+	return err
+}
+
+// pruneOrphanedIdentities deletes the FlexIDs and sessions of users who no
+// longer exist, so a deleted user's phone number or email isn't kept, nor
+// attached to a new user.
+func pruneOrphanedIdentities() error {
+	for _, t := range []string{"userflexids", "sessions"} {
+		q := `DELETE FROM ` + t + ` AS t WHERE NOT EXISTS (
+		          SELECT 1 FROM users WHERE id=t.userid)`
+		res, err := db.Exec(q)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			log.Info("pruned orphaned", t, n)
+		}
+	}
+	return nil
+}
+
+// sweepDeadLetters stops retrying scheduled events that failed
+// maxSendAttempts times or couldn't be sent within deadLetterAge of their send
+// time, notifying the admin. Reprocess queues them again once their channel is
+// fixed.
+func sweepDeadLetters() error {
+	now := time.Now()
+	q := `UPDATE scheduledevents SET deadat=$1
+	      WHERE sent=false AND deadat IS NULL
+	          AND (sendat<$2 OR attempts>=$3)`
+	res, err := db.Exec(q, now, now.Add(-deadLetterAge), maxSendAttempts)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return err
+	}
+	log.Info("swept dead letters", n)
+	return notifyAdmin("Messages couldn't be sent", fmt.Sprintf(
+		"%d scheduled messages couldn't be sent and won't be "+
+			"retried. See abot admin dead-letters.", n))
+}
+
+// Migrate applies any migrations in db/migrations/up that haven't been
+// applied yet, in order, returning the names of those applied.
+func Migrate() ([]string, error) {
+	q := `CREATE TABLE IF NOT EXISTS migrations (
+		name VARCHAR(255) NOT NULL,
+		appliedat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		PRIMARY KEY (name)
+	)`
+	if _, err := db.Exec(q); err != nil {
+		return nil, err
+	}
+	var done []string
+	if err := db.Select(&done, `SELECT name FROM migrations`); err != nil {
+		return nil, err
+	}
+	applied := map[string]struct{}{}
+	for _, name := range done {
+		applied[name] = struct{}{}
+	}
+	p := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "itsabot",
+		"abot", "db", "migrations", "up")
+	fis, err := ioutil.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range fis {
+		if strings.HasSuffix(fi.Name(), ".sql") {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+	var ran []string
+	for _, name := range names {
+		if _, ok := applied[name]; ok {
+			continue
+		}
+		byt, err := ioutil.ReadFile(filepath.Join(p, name))
+		if err != nil {
+			return ran, err
+		}
+		if err = applyMigration(name, string(byt)); err != nil {
+			return ran, err
+		}
+		ran = append(ran, name)
+	}
+	return ran, nil
+}
+
+// applyMigration runs a migration and records it in a single transaction.
+func applyMigration(name, sql string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(sql); err != nil {
+		_ = tx.Rollback()
+		pqErr, ok := err.(*pq.Error)
+		if !ok {
+			return err
+		}
+		if _, ok = alreadyApplied[pqErr.Code]; !ok {
+			return err
+		}
+		log.Info("migration already applied", name)
+		tx, err = db.Begin()
+		if err != nil {
+			return err
+		}
+	}
+	q := `INSERT INTO migrations (name) VALUES ($1)`
+	if _, err = tx.Exec(q, name); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// authSecret is the key used to sign auth tokens: ABOT_SECRET plus a salt
+// operators can rotate without redeploying.
+func authSecret() []byte {
+	return []byte(os.Getenv("ABOT_SECRET") + Setting("auth_secret_salt"))
+}
+
+// RotateAuthSecret changes the key used to sign auth tokens and deletes all
+// sessions, logging everyone out. Use it if a token may have leaked.
+func RotateAuthSecret() error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	salt := base64.StdEncoding.EncodeToString(b)
+	if err := SetSetting("auth_secret_salt", salt); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM sessions`)
+	return err
+}
+
+// Resend delivers a message Abot sent to a user again over the channel it was
+// first sent on, e.g. after an SMS failed to arrive.
+func Resend(msgID uint64) error {
+	var m struct {
+		Sentence   string
+		AbotSent   bool
+		FlexID     string
+		FlexIDType dt.FlexIDType
+	}
+	q := `SELECT COALESCE(sentence, '') AS sentence, abotsent,
+	          COALESCE(flexid, '') AS flexid,
+	          COALESCE(flexidtype, 0) AS flexidtype
+	      FROM messages WHERE id=$1`
+	if err := db.Get(&m, q, msgID); err != nil {
+		return err
+	}
+	if !m.AbotSent {
+		return ErrNotAbotSent
+	}
+	return sendRendered(dt.ScheduledEvent{
+		Content:    openSentence(m.Sentence),
+		FlexID:     m.FlexID,
+		FlexIDType: m.FlexIDType,
+	})
+}
+
+// HAPIMigrate applies any pending database migrations.
+func HAPIMigrate(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	ran, err := Migrate()
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Applied []string }{Applied: ran})
+}
+
+// HAPIRotateKeys rotates the key used to sign auth tokens, logging everyone
+// out, including the operator making the request.
+func HAPIRotateKeys(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	if err := RotateAuthSecret(); err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	log.Info("rotated auth secret")
+	w.WriteHeader(http.StatusOK)
+}
+
+// HAPIResend delivers a message Abot sent to a user again.
+func HAPIResend(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct{ MessageID uint64 }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := Resend(req.MessageID)
+	if err == ErrNotAbotSent || err == dt.ErrMissingConn ||
+		err == ErrRecipientPaused {
+
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// ErrSameUser is returned when merging a user into themselves.
+var ErrSameUser = errors.New("can't merge a user into themselves")
+
+// ErrAlreadyLinked is returned when linking a FlexID that already belongs to
+// the user.
+var ErrAlreadyLinked = errors.New("flexid already linked")
+
+// mergeTable is a table of users' data that's moved to the primary user when
+// merging users. Unique lists the columns other than userid that identify a
+// row per user, e.g. a state's plugin and key. Where both users have such a
+// row, the primary user's is kept.
+type mergeTable struct {
+	Name   string
+	Unique []string
+}
+
+// mergeTables are the tables moved by MergeUsers. Sessions aren't moved, so
+// the duplicate user is logged out.
+var mergeTables = []mergeTable{
+	{Name: "userflexids"},
+	{Name: "messages"},
+	{Name: "preferences", Unique: []string{"pkgname", "key"}},
+	{Name: "states", Unique: []string{"pluginname", "key"}},
+	{Name: "conversationvars", Unique: []string{"pluginname", "key"}},
+	{Name: "contextentities", Unique: []string{"class"}},
+	{Name: "timecontexts", Unique: []string{}},
+	{Name: "briefings", Unique: []string{}},
+	{Name: "notificationpolicies", Unique: []string{"category"}},
+	{Name: "usertags", Unique: []string{"tag"}},
+	{Name: "contacts", Unique: []string{"name", "email", "phone"}},
+	{Name: "placechoices", Unique: []string{"name"}},
+	{Name: "shipments", Unique: []string{"trackingnumber"}},
+	{Name: "pluginusage", Unique: []string{"tenant", "pluginname", "day"}},
+	{Name: "conversationlabels"},
+	{Name: "addresses"},
+	{Name: "cards"},
+	{Name: "charges"},
+	{Name: "purchases"},
+	{Name: "locations"},
+	{Name: "facts"},
+	{Name: "intents"},
+	{Name: "links"},
+	{Name: "surveys"},
+	{Name: "meetingrequests"},
+	{Name: "fallbacksuggestions"},
+	{Name: "verifications"},
+	{Name: "passwordresets"},
+	{Name: "totpsecrets", Unique: []string{}},
+	{Name: "challenges"},
+	{Name: "challengeattempts"},
+	{Name: "auditentries"},
+	{Name: "callbacks"},
+	{Name: "costs"},
+	{Name: "tickets"},
+	{Name: "qareviews", Unique: []string{"lastmessageid"}},
+}
+
+// MergeUsers merges a duplicate user into a primary one, such as when someone
+// who first texted Abot later emails it, creating two users. The duplicate's
+// FlexIDs, conversation history, preferences, plugin state and other data
+// are moved to the primary user, whose own data wins where both have the
+// same preference or memory. The primary user takes the duplicate's name and
+// email if they have none. The duplicate user is then deleted.
+func MergeUsers(primary, duplicate uint64) error {
+	if primary == duplicate {
+		return ErrSameUser
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	dup := &dt.User{}
+	q := `SELECT name, email FROM users WHERE id=$1`
+	if err = tx.Get(dup, q, duplicate); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	// Usage is summed rather than kept from the primary user, so merging
+	// doesn't lose any spend.
+	q = `UPDATE pluginusage AS p
+	     SET invocations=p.invocations+d.invocations,
+	         spendmicros=p.spendmicros+d.spendmicros
+	     FROM pluginusage AS d
+	     WHERE p.userid=$1 AND d.userid=$2 AND p.tenant=d.tenant
+	         AND p.pluginname=d.pluginname AND p.day=d.day`
+	if _, err = tx.Exec(q, primary, duplicate); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	// Only one of a user's conversations may be open under a label.
+	q = `UPDATE conversationlabels AS d SET resolvedat=CURRENT_TIMESTAMP
+	     WHERE d.userid=$2 AND d.resolvedat IS NULL AND EXISTS (
+	         SELECT 1 FROM conversationlabels AS p
+	         WHERE p.userid=$1 AND p.label=d.label AND p.resolvedat IS NULL)`
+	if _, err = tx.Exec(q, primary, duplicate); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	for _, t := range mergeTables {
+		if t.Unique != nil {
+			if _, err = tx.Exec(t.deleteConflicts(), primary,
+				duplicate); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+		}
+		q = fmt.Sprintf(`UPDATE %s SET userid=$1 WHERE userid=$2`, t.Name)
+		if _, err = tx.Exec(q, primary, duplicate); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	q = `DELETE FROM sessions WHERE userid=$1`
+	if _, err = tx.Exec(q, duplicate); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	q = `DELETE FROM users WHERE id=$1`
+	if _, err = tx.Exec(q, duplicate); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	q = `UPDATE users
+	     SET name=CASE WHEN name='' THEN $2 ELSE name END,
+	         email=CASE WHEN email='' THEN $3 ELSE email END
+	     WHERE id=$1`
+	if _, err = tx.Exec(q, primary, dup.Name, dup.Email); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteConflicts returns a query deleting the duplicate user's rows that the
+// primary user has too.
+func (t mergeTable) deleteConflicts() string {
+	var conds []string
+	for _, c := range t.Unique {
+		conds = append(conds, fmt.Sprintf("p.%s IS NOT DISTINCT FROM d.%s",
+			c, c))
+	}
+	conds = append(conds, "p.userid=$1")
+	return fmt.Sprintf(`DELETE FROM %s AS d
+	                    WHERE d.userid=$2 AND EXISTS (
+	                        SELECT 1 FROM %s AS p WHERE %s)`,
+		t.Name, t.Name, strings.Join(conds, " AND "))
+}
+
+// StartLink begins linking another of a user's channels to them, like the
+// email address of someone texting Abot, by sending a one-time code there.
+// When the user sends the code back on their current channel, their message
+// is routed to the plugin on the given route, with the code in
+// StructuredInput.Code. Link the channel with ConfirmLink. Codes expire after
+// 10 minutes.
+func StartLink(uid uint64, pluginName, route, fid string,
+	fidT dt.FlexIDType) error {
+
+	if len(fid) == 0 {
+		return dt.ErrMissingFlexID
+	}
+	if !fidT.Valid() {
+		return dt.ErrInvalidFlexIDType
+	}
+	owner, err := dt.UserByFlexID(db, fid, fidT)
+	if err != nil && err != dt.ErrUnknownFlexID {
+		return err
+	}
+	if owner != nil && owner.ID == uid {
+		return ErrAlreadyLinked
+	}
+	code, err := startVerification(uid, pluginName, route, fid, fidT)
+	if err != nil {
+		return err
+	}
+	evt := dt.ScheduledEvent{
+		Content: fmt.Sprintf("Your code to link your accounts is %s.",
+			code),
+		FlexID:     fid,
+		FlexIDType: fidT,
+	}
+	return evt.Send(smsConn, emailConn)
+}
+
+// ConfirmLink checks a code sent by StartLink and, if it's right, links the
+// channel it was sent to to the user. If the channel belonged to another
+// user, that user is merged into this one with MergeUsers. It returns false
+// for wrong codes and ErrNoVerification if the plugin awaits no code.
+func ConfirmLink(uid uint64, pluginName, code string) (bool, error) {
+	v, ok, err := checkCode(uid, pluginName, code)
+	if err != nil || !ok {
+		return false, err
+	}
+	if len(v.FlexID) == 0 {
+		return false, ErrNoVerification
+	}
+	owner, err := dt.UserByFlexID(db, v.FlexID, v.FlexIDType)
+	if err == dt.ErrUnknownFlexID {
+		u := &dt.User{ID: uid}
+		return true, u.LinkFlexID(db, v.FlexID, v.FlexIDType)
+	}
+	if err != nil {
+		return false, err
+	}
+	if owner.ID == uid {
+		return true, nil
+	}
+	if err = MergeUsers(uid, owner.ID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -5,18 +5,23 @@ import (
 	"github.com/itsabot/abot/shared/nlp"
 )
 
-// NewMsg builds a message struct with Tokens, Stems, and a Structured Input.
-func NewMsg(u *dt.User, cmd string) *dt.Msg {
+// NewMsg builds a message from a user's sentence, passing it through each
+// stage of the pipeline to fill in its Tokens, Stems, Structured Input and
+// Route. See Pipeline.
+func NewMsg(u *dt.User, cmd string) (*dt.Msg, error) {
+	m := newMsg(u, cmd)
+	if err := runPipeline(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
 
-	tokens := nlp.TokenizeSentence(cmd)
-	stems := nlp.StemTokens(tokens)
-	si := NER().ClassifyTokens(tokens)
+// newMsg builds a message that hasn't yet passed through the pipeline.
+func newMsg(u *dt.User, cmd string) *dt.Msg {
 	m := &dt.Msg{
 		User:            u,
 		Sentence:        cmd,
-		Tokens:          tokens,
-		Stems:           stems,
-		StructuredInput: si,
+		StructuredInput: &nlp.StructuredInput{},
 	}
 	/*
 		m, err = addContext(db, m)
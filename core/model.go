@@ -0,0 +1,512 @@
+package core
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// Statuses of model versions.
+const (
+	// ModelStaged models have been trained and evaluated, awaiting an
+	// operator's promotion. They run in shadow meanwhile.
+	ModelStaged = "staged"
+
+	// ModelLive is the model classifying messages.
+	ModelLive = "live"
+
+	// ModelRetired models have been replaced.
+	ModelRetired = "retired"
+)
+
+// stagedModelParser is the name the staged model runs under in shadow. See
+// ShadowReports.
+const stagedModelParser = "staged_model"
+
+// minLearnExamples is the fewest labeled messages with a word before a model
+// learns it, and minLearnAgreement is the share of those that must agree on
+// the word's route. Together they keep common words like "my" from being
+// learned.
+const (
+	minLearnExamples  = 3
+	minLearnAgreement = 0.8
+)
+
+// ErrModelNotFound is returned when promoting a model that doesn't exist or
+// isn't staged.
+var ErrModelNotFound = errors.New("model not found")
+
+// Model is a version of the classifier retrained from the messages operators
+// have labeled. It learns words the dictionaries in data/ner don't route on
+// their own, like "lift" in "I need a lift," and the route they signal, e.g.
+// "need_ride."
+type Model struct {
+	Version int
+	Learned map[string]string
+
+	// Agreement is the share of labeled messages with each learned word
+	// that agreed on its route.
+	Agreement map[string]float64
+
+	// Examples is how many labeled messages the model was trained on.
+	Examples int
+
+	// Accuracy is the share of the golden corpus the model routes
+	// correctly, and LiveAccuracy is the share routed correctly by the
+	// model live when it was trained.
+	Accuracy     float64
+	LiveAccuracy float64
+
+	Status     string
+	CreatedAt  time.Time
+	PromotedAt *time.Time
+}
+
+// trainingExample is a message labeled with the plugin that should handle it,
+// or "" if none.
+type trainingExample struct {
+	Sentence string
+	Plugin   string
+}
+
+var liveModel *Model
+var modelMu sync.RWMutex
+
+func init() {
+	RegisterJob("retrain_model", 24*time.Hour, func() error {
+		_, err := TrainModel()
+		return err
+	})
+}
+
+// LiveModel returns the model classifying messages, or nil if none has been
+// promoted, in which case the active classifier is used alone.
+func LiveModel() *Model {
+	modelMu.RLock()
+	defer modelMu.RUnlock()
+	return liveModel
+}
+
+// ClassifyTokens builds a StructuredInput from a tokenized sentence, adding
+// the commands and objects of learned words to those found by the active
+// classifier. A learned word's confidence is the share of labeled messages
+// with it that agreed on its route. A nil model uses the classifier alone.
+func (m *Model) ClassifyTokens(tokens []string) *nlp.StructuredInput {
+	si := classifyTokens(tokens)
+	if m == nil {
+		return si
+	}
+	var learned []nlp.WordClass
+	for _, s := range nlp.StemTokens(tokens) {
+		route, ok := m.Learned[s]
+		if !ok {
+			continue
+		}
+		parts := strings.SplitN(route, "_", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		conf, ok := m.Agreement[s]
+		if !ok {
+			// Models trained before agreement was kept learned
+			// words with at least minLearnAgreement.
+			conf = minLearnAgreement
+		}
+		// Learned routes go first, since the classifier missed them.
+		learned = append([]nlp.WordClass{
+			{Word: parts[0], Class: nlp.CommandI, Confidence: conf},
+			{Word: parts[1], Class: nlp.ObjectI, Confidence: conf},
+		}, learned...)
+	}
+	if len(learned) == 0 {
+		return si
+	}
+	out := &nlp.StructuredInput{}
+	_ = out.Add(learned)
+	_ = out.Add(si.Classes)
+	return out
+}
+
+// TrainModel trains a new model from messages operators have labeled, both
+// spot-checked samples and messages Abot didn't understand, evaluating it and
+// the live model against the golden corpus in data/golden.tsv. The model is
+// staged for operators to promote and run in shadow meanwhile. If nothing's
+// been labeled since the last model was trained, no model is trained, and
+// nil is returned.
+func TrainModel() (*Model, error) {
+	exs, err := trainingExamples()
+	if err != nil {
+		return nil, err
+	}
+	var last int
+	q := `SELECT COALESCE(MAX(examples), 0) FROM models`
+	if err = db.Get(&last, q); err != nil {
+		return nil, err
+	}
+	if len(exs) == last {
+		return nil, nil
+	}
+	golden, err := goldenCorpus()
+	if err != nil {
+		return nil, err
+	}
+	m := train(exs)
+	m.Accuracy = evaluate(m, golden)
+	m.LiveAccuracy = evaluate(LiveModel(), golden)
+	learned, err := json.Marshal(m.Learned)
+	if err != nil {
+		return nil, err
+	}
+	agreement, err := json.Marshal(m.Agreement)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	q = `UPDATE models SET status=$1 WHERE status=$2`
+	if _, err = tx.Exec(q, ModelRetired, ModelStaged); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	q = `INSERT INTO models
+	     (learned, agreement, examples, accuracy, liveaccuracy, status)
+	     VALUES ($1, $2, $3, $4, $5, $6)
+	     RETURNING id, createdat`
+	err = tx.QueryRowx(q, learned, agreement, m.Examples, m.Accuracy,
+		m.LiveAccuracy, ModelStaged).Scan(&m.Version, &m.CreatedAt)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	RegisterShadowParser(stagedModelParser, m.ClassifyTokens)
+	log.Info("staged model", m.Version)
+	err = notifyAdmin(fmt.Sprintf("Model %d staged", m.Version), fmt.Sprintf(
+		"Model %d was trained on %d labeled messages and routes %.0f%% of the golden corpus correctly, compared to %.0f%% by the live model. Promote it in the admin console.",
+		m.Version, m.Examples, m.Accuracy*100, m.LiveAccuracy*100))
+	if err != nil {
+		log.Info("failed to notify admin of staged model", err)
+	}
+	return m, nil
+}
+
+// trainingExamples returns the messages operators have labeled, or users have
+// corrected, with the plugin that should handle them.
+func trainingExamples() ([]trainingExample, error) {
+	q := `SELECT COALESCE(m.sentence, '') AS sentence,
+	          cc.expected AS plugin
+	      FROM classificationchecks AS cc
+	      JOIN messages AS m ON m.id=cc.messageid
+	      WHERE cc.checkedat IS NOT NULL AND cc.source IN ($1, $2, $3)
+	      ORDER BY cc.id`
+	var exs []trainingExample
+	err := db.Select(&exs, q, CheckHuman, CheckFallback, CheckCorrection)
+	if err != nil {
+		return nil, err
+	}
+	for i := range exs {
+		exs[i].Sentence = openSentence(exs[i].Sentence)
+	}
+	return exs, nil
+}
+
+// goldenCorpus loads the sentences models are evaluated against.
+func goldenCorpus() ([]trainingExample, error) {
+	p := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "itsabot",
+		"abot", "data", "golden.tsv")
+	fi, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err = fi.Close(); err != nil {
+			log.Info("failed to close golden corpus", err)
+		}
+	}()
+	var exs []trainingExample
+	scanner := bufio.NewScanner(fi)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid golden corpus line %q", line)
+		}
+		exs = append(exs, trainingExample{
+			Sentence: parts[0],
+			Plugin:   strings.TrimSpace(parts[1]),
+		})
+	}
+	return exs, scanner.Err()
+}
+
+// train learns the route signaled by each word that consistently appears in
+// messages labeled with the same plugin. A plugin's route is its first
+// command and object, which the plugin is registered under.
+func train(exs []trainingExample) *Model {
+	routes := map[string]string{}
+	for _, p := range AllPlugins {
+		t := p.Trigger
+		if t == nil || len(t.Commands) == 0 || len(t.Objects) == 0 {
+			continue
+		}
+		routes[p.Config.Name] = strings.ToLower(t.Commands[0] + "_" +
+			t.Objects[0])
+	}
+	counts := map[string]map[string]int{}
+	totals := map[string]int{}
+	for _, ex := range exs {
+		route := routes[ex.Plugin]
+		tokens := RemoveStopwords(nlp.TokenizeSentence(ex.Sentence),
+			parserLang)
+		seen := map[string]struct{}{}
+		for _, s := range nlp.StemTokens(tokens) {
+			if _, ok := seen[s]; ok {
+				continue
+			}
+			seen[s] = struct{}{}
+			totals[s]++
+			if len(route) == 0 {
+				continue
+			}
+			if counts[s] == nil {
+				counts[s] = map[string]int{}
+			}
+			counts[s][route]++
+		}
+	}
+	m := &Model{
+		Learned:   map[string]string{},
+		Agreement: map[string]float64{},
+		Examples:  len(exs),
+	}
+	for s, rs := range counts {
+		for route, n := range rs {
+			if n < minLearnExamples {
+				continue
+			}
+			agreement := float64(n) / float64(totals[s])
+			if agreement < minLearnAgreement {
+				continue
+			}
+			m.Learned[s] = route
+			m.Agreement[s] = agreement
+		}
+	}
+	return m
+}
+
+// evaluate returns the share of examples that a model routes to the right
+// plugin. A nil model uses the active classifier alone.
+func evaluate(m *Model, exs []trainingExample) float64 {
+	if len(exs) == 0 {
+		return 0
+	}
+	var correct int
+	for _, ex := range exs {
+		tokens := RemoveStopwords(nlp.TokenizeSentence(ex.Sentence),
+			parserLang)
+		p, _ := matchRoute(m.ClassifyTokens(tokens), tokens)
+		var name string
+		if p != nil {
+			name = p.Config.Name
+		}
+		if name == ex.Plugin {
+			correct++
+		}
+	}
+	return float64(correct) / float64(len(exs))
+}
+
+// modelRow is a model as it's stored.
+type modelRow struct {
+	ID           int
+	Learned      []byte
+	Agreement    []byte
+	Examples     int
+	Accuracy     float64
+	LiveAccuracy float64
+	Status       string
+	CreatedAt    time.Time
+	PromotedAt   *time.Time
+}
+
+func (r *modelRow) model() (*Model, error) {
+	m := &Model{
+		Version:      r.ID,
+		Examples:     r.Examples,
+		Accuracy:     r.Accuracy,
+		LiveAccuracy: r.LiveAccuracy,
+		Status:       r.Status,
+		CreatedAt:    r.CreatedAt,
+		PromotedAt:   r.PromotedAt,
+	}
+	if err := json.Unmarshal(r.Learned, &m.Learned); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(r.Agreement, &m.Agreement); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Models returns the most recently trained models, newest first.
+func Models(limit int) ([]*Model, error) {
+	q := `SELECT id, learned, agreement, examples, accuracy, liveaccuracy,
+	          status, createdat, promotedat
+	      FROM models
+	      ORDER BY id DESC
+	      LIMIT $1`
+	var rows []modelRow
+	if err := db.Select(&rows, q, limit); err != nil {
+		return nil, err
+	}
+	var ms []*Model
+	for i := range rows {
+		m, err := rows[i].model()
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// loadModels loads the live model and runs the staged model in shadow, if
+// there are any.
+func loadModels() error {
+	q := `SELECT id, learned, agreement, examples, accuracy, liveaccuracy,
+	          status, createdat, promotedat
+	      FROM models
+	      WHERE status IN ($1, $2)`
+	var rows []modelRow
+	if err := db.Select(&rows, q, ModelLive, ModelStaged); err != nil {
+		return err
+	}
+	for i := range rows {
+		m, err := rows[i].model()
+		if err != nil {
+			return err
+		}
+		if m.Status == ModelStaged {
+			RegisterShadowParser(stagedModelParser, m.ClassifyTokens)
+			continue
+		}
+		modelMu.Lock()
+		liveModel = m
+		modelMu.Unlock()
+	}
+	return nil
+}
+
+// PromoteModel makes a staged model live, retiring the model it replaces.
+func PromoteModel(version int) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	q := `UPDATE models SET status=$1 WHERE status=$2`
+	if _, err = tx.Exec(q, ModelRetired, ModelLive); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	var row modelRow
+	q = `UPDATE models SET status=$1, promotedat=CURRENT_TIMESTAMP
+	     WHERE id=$2 AND status=$3
+	     RETURNING id, learned, agreement, examples, accuracy, liveaccuracy,
+	         status, createdat, promotedat`
+	err = tx.Get(&row, q, ModelLive, version, ModelStaged)
+	if err == sql.ErrNoRows {
+		_ = tx.Rollback()
+		return ErrModelNotFound
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	m, err := row.model()
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	modelMu.Lock()
+	liveModel = m
+	modelMu.Unlock()
+	unregisterShadowParser(stagedModelParser)
+	log.Info("promoted model", version)
+	return nil
+}
+
+// HAPIModels responds with the most recently trained models.
+func HAPIModels(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	_, limit, err := pageParams(r)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	ms, err := Models(limit)
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Models []*Model }{Models: ms})
+}
+
+// HAPIModelsTrain trains and stages a new model now rather than waiting for
+// the daily job.
+func HAPIModelsTrain(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	m, err := TrainModel()
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Model *Model }{Model: m})
+}
+
+// HAPIModelsPromote makes a staged model live.
+func HAPIModelsPromote(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct{ Version int }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := PromoteModel(req.Version)
+	if err == ErrModelNotFound {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// splitIntents splits a message holding several requests, like "cancel my 2pm
+// and book dinner at 7," into a message for each, routed to its plugin, in
+// the order the user wrote them. A clause is only split off when it routes to
+// a plugin on its own, so "book dinner and drinks" stays whole, and clauses
+// that don't are kept with the request before them. Requests share the
+// context mentioned only once, as by nlp.ShareContext. Messages holding fewer
+// than two requests return nil.
+func splitIntents(in *dt.Msg) []*dt.Msg {
+	var plugins []*dt.Plugin
+	var routes []string
+	segs := in.StructuredInput.SegmentFunc(func(clause string) bool {
+		p, route := clauseRoute(clause)
+		if p == nil {
+			return false
+		}
+		plugins, routes = append(plugins, p), append(routes, route)
+		return true
+	})
+	if segs == nil {
+		return nil
+	}
+	parts := make([]*dt.Msg, len(segs))
+	sis := make([]*nlp.StructuredInput, len(segs))
+	for i, seg := range segs {
+		part := newMsg(in.User, seg.RawSentence)
+		part.Lang = in.Lang
+		if err := runPipeline(part); err != nil {
+			log.Info("failed to process request", part.Sentence, err)
+			return nil
+		}
+		part.Route, part.Plugin = routes[i], plugins[i].Config.Name
+		part.Followup = false
+		parts[i], sis[i] = part, part.StructuredInput
+	}
+	nlp.ShareContext(sis)
+	return parts
+}
+
+// clauseRoute classifies a clause as the pipeline would, returning the plugin
+// and route it triggers on its own, independent of the conversation.
+func clauseRoute(clause string) (*dt.Plugin, string) {
+	tokens := nlp.TokenTexts(nlp.NormalizeTokens(nlp.SentenceTokens(clause),
+		nil))
+	tokens = RemoveStopwords(tokens, parserLang)
+	tokens = Spellcheck(tokens)
+	return matchRoute(LiveModel().ClassifyTokens(tokens), tokens)
+}
+
+// callIntents sends each request in a message to its plugin in order,
+// combining their responses.
+func callIntents(in *dt.Msg, parts []*dt.Msg) string {
+	var resps []string
+	for _, part := range parts {
+		part.ID = in.ID
+		p := RegPlugins.Get(part.Route)
+		if resp := CallPlugin(p, part, part.Followup); len(resp) > 0 {
+			resps = append(resps, resp)
+		}
+	}
+	return strings.Join(resps, "\n")
+}
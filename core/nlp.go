@@ -12,25 +12,53 @@ import (
 	"github.com/itsabot/abot/shared/nlp"
 )
 
-// Classifier is a set of common english word stems unique among their
+// Classifier classifies the words of a sentence as Commands and Objects. The
+// dictionaries in data/ner are used unless ABOT_CLASSIFIER names a driver
+// registered with package classifier, like a perceptron or an external ML
+// service. See ActiveClassifier.
+type Classifier interface {
+	Classify(sentence string) ([]nlp.WordClass, error)
+}
+
+// Dictionary is a set of common english word stems unique among their
 // Structured Input Types. This enables extremely fast constant-time O(1)
 // lookups of stems to their SITs with high accuracy and no training
 // requirements. It consumes just a few MB in memory.
-type Classifier map[string]struct{}
+type Dictionary map[string]struct{}
+
+// Confidences of words classified by the dictionaries. Words listed as both
+// a command and an object, like "book," are as likely to be either.
+const (
+	dictConfidence          = 0.9
+	ambiguousDictConfidence = 0.5
+)
+
+// Classify the words of a sentence by looking each up in the dictionaries.
+func (c Dictionary) Classify(sentence string) ([]nlp.WordClass, error) {
+	return c.ClassifyTokens(nlp.TokenizeSentence(sentence)).Classes, nil
+}
 
 // ClassifyTokens builds a StructuredInput from a tokenized sentence.
-func (c Classifier) ClassifyTokens(tokens []string) *nlp.StructuredInput {
+func (c Dictionary) ClassifyTokens(tokens []string) *nlp.StructuredInput {
 	var s nlp.StructuredInput
 	for _, t := range tokens {
 		t = strings.ToLower(t)
-		_, exists := c["C"+t]
-		if exists {
-			s.Commands = append(s.Commands, t)
+		_, cmd := c["C"+t]
+		_, obj := c["O"+t]
+		conf := dictConfidence
+		if cmd && obj {
+			conf = ambiguousDictConfidence
+		}
+		var wc []nlp.WordClass
+		if cmd {
+			wc = append(wc, nlp.WordClass{Word: t, Class: nlp.CommandI,
+				Confidence: conf})
 		}
-		_, exists = c["O"+t]
-		if exists {
-			s.Objects = append(s.Objects, t)
+		if obj {
+			wc = append(wc, nlp.WordClass{Word: t, Class: nlp.ObjectI,
+				Confidence: conf})
 		}
+		_ = s.Add(wc)
 	}
 	return &s
 }
@@ -43,8 +71,8 @@ func (c Classifier) ClassifyTokens(tokens []string) *nlp.StructuredInput {
 // pass, and any double-marked words should be passed through something like an
 // n-gram Bayesian filter to determine the correct part of speech within its
 // context in the sentence.
-func buildClassifier() (Classifier, error) {
-	ner := Classifier{}
+func buildClassifier() (Dictionary, error) {
+	ner := Dictionary{}
 	p := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "itsabot",
 		"abot", "data", "ner")
 	fi, err := os.Open(filepath.Join(p, "nouns.txt"))
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// batchEvents groups due scheduled events by recipient, keeping them in the
+// order they're due.
+func batchEvents(evts []*dt.ScheduledEvent) [][]*dt.ScheduledEvent {
+	type recipient struct {
+		flexID     string
+		flexIDType dt.FlexIDType
+	}
+	var batches [][]*dt.ScheduledEvent
+	idx := map[recipient]int{}
+	for _, evt := range evts {
+		r := recipient{evt.FlexID, evt.FlexIDType}
+		i, ok := idx[r]
+		if !ok {
+			i = len(batches)
+			idx[r] = i
+			batches = append(batches, nil)
+		}
+		batches[i] = append(batches[i], evt)
+	}
+	return batches
+}
+
+// sendScheduled sends a recipient's due events. Urgent events are sent right
+// away on their own. The rest are held during the recipient's quiet hours and
+// otherwise sent together as a single message, so a user isn't buzzed once for
+// each. Like errors, held events are retried next minute, until they're swept
+// into the dead letters. Events for users who
+// have paused Abot are dropped, since they've asked not to receive them.
+func sendScheduled(evts []*dt.ScheduledEvent) {
+	paused, err := evts[0].RecipientPaused(db)
+	if err != nil {
+		log.Info("failed to check if recipient paused", err)
+		return
+	}
+	if paused {
+		markSent(evts)
+		return
+	}
+	var normal []*dt.ScheduledEvent
+	for _, evt := range evts {
+		pri, err := evt.EffectivePriority(db)
+		if err != nil {
+			log.Info("failed to get event priority", err)
+			continue
+		}
+		if pri == dt.PriorityUrgent {
+			err = sendEvents([]*dt.ScheduledEvent{evt}, evt.Content)
+			if err != nil {
+				log.Info("failed to send scheduled event", err)
+				markFailed([]*dt.ScheduledEvent{evt}, err)
+				escalateUndelivered(evt)
+			}
+			continue
+		}
+		normal = append(normal, evt)
+	}
+	if len(normal) == 0 {
+		return
+	}
+	quiet, err := normal[0].InQuietHours(db, time.Now())
+	if err != nil {
+		log.Info("failed to check quiet hours", err)
+		return
+	}
+	if quiet {
+		return
+	}
+	var contents []string
+	for _, evt := range normal {
+		contents = append(contents, evt.Content)
+	}
+	err = sendEvents(normal, strings.Join(contents, "\n\n"))
+	if err != nil {
+		log.Info("failed to send scheduled event", err)
+		markFailed(normal, err)
+	}
+}
+
+// sendEvents delivers content in place of events to their shared recipient,
+// marking the events as sent.
+func sendEvents(evts []*dt.ScheduledEvent, content string) error {
+	msg := *evts[0]
+	msg.Content = content
+	if err := sendRendered(msg); err != nil {
+		return err
+	}
+	markSent(evts)
+	return nil
+}
+
+// markSent records that events were sent, so they aren't sent again.
+func markSent(evts []*dt.ScheduledEvent) {
+	var ids []int64
+	for _, evt := range evts {
+		ids = append(ids, int64(evt.ID))
+	}
+	q := `UPDATE scheduledevents SET sent=TRUE, sentat=$1
+	      WHERE id=ANY($2::integer[])`
+	if _, err := db.Exec(q, time.Now(), intArray(ids)); err != nil {
+		log.Info("failed to update scheduled event as sent", err)
+	}
+}
@@ -0,0 +1,257 @@
+package core
+
+import (
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/helpers/redact"
+	"golang.org/x/net/websocket"
+)
+
+// Roles allowing users to observe live conversations. Admins may observe as
+// well, but only see redacted text unless they also hold RoleObserverPII.
+const (
+	// RoleObserver may watch conversations as they happen, with
+	// personal information redacted, e.g. for supervision dashboards.
+	RoleObserver = "observer"
+
+	// RoleObserverPII may watch conversations unredacted.
+	RoleObserverPII = "observer_pii"
+)
+
+// Types of observer events.
+const (
+	ObserverEventMessage  = "message"
+	ObserverEventResponse = "response"
+
+	// ObserverEventTyping is sent while a user types, on channels that
+	// say so, like web chat. It carries no message.
+	ObserverEventTyping = "typing"
+)
+
+// observerBuffer is the most events held for an observer that's slow to
+// receive them. Further events are dropped for that observer, so a slow
+// dashboard never holds up conversations.
+const observerBuffer = 100
+
+// ErrNotObserver is returned when a user without an observer role asks to
+// observe conversations.
+var ErrNotObserver = errors.New("user may not observe conversations")
+
+// ObserverEvent is a message sent or received by Abot, streamed to observers
+// as it happens.
+type ObserverEvent struct {
+	Type      string
+	UserID    uint64
+	MessageID uint64
+	Sentence  string
+	Plugin    string
+	Route     string
+	Redacted  bool
+	CreatedAt time.Time
+}
+
+// observer is a subscriber to conversation events. Events are sampled by
+// user, so observers see whole conversations, and may be limited to a plugin
+// or a user.
+type observer struct {
+	user   *dt.User
+	events chan ObserverEvent
+	redact bool
+	sample float64
+	plugin string
+	userID uint64
+}
+
+var observers = map[*observer]struct{}{}
+var observersMu sync.RWMutex
+
+// observeMsg streams a saved message to every observer following its user.
+// Observers never hold up the conversation: events are dropped for those too
+// slow to receive them.
+func observeMsg(typ string, m *dt.Msg, route string) {
+	observersMu.RLock()
+	defer observersMu.RUnlock()
+	if len(observers) == 0 {
+		return
+	}
+	ev := ObserverEvent{
+		Type:      typ,
+		UserID:    m.User.ID,
+		MessageID: m.ID,
+		Sentence:  m.Sentence,
+		Plugin:    m.Plugin,
+		Route:     route,
+		CreatedAt: time.Now(),
+	}
+	redacted := ev
+	redacted.Sentence = redact.String(redact.FieldMessage, ev.Sentence)
+	redacted.Redacted = true
+	for o := range observers {
+		if !o.follows(&ev) {
+			continue
+		}
+		e := ev
+		if o.redact {
+			e = redacted
+		}
+		select {
+		case o.events <- e:
+		default:
+			log.Debug("dropped event for slow observer", o.user.ID)
+		}
+	}
+}
+
+// observeTyping tells observers following a user that they're typing.
+func observeTyping(uid uint64) {
+	observersMu.RLock()
+	defer observersMu.RUnlock()
+	ev := ObserverEvent{
+		Type:      ObserverEventTyping,
+		UserID:    uid,
+		CreatedAt: time.Now(),
+	}
+	for o := range observers {
+		if !o.follows(&ev) {
+			continue
+		}
+		select {
+		case o.events <- ev:
+		default:
+			log.Debug("dropped event for slow observer", o.user.ID)
+		}
+	}
+}
+
+// observing reports whether anyone is observing conversations.
+func observing() bool {
+	observersMu.RLock()
+	defer observersMu.RUnlock()
+	return len(observers) > 0
+}
+
+// follows reports whether an observer receives an event: its user is sampled,
+// it matches the observer's filters, and the observer may use its plugin.
+func (o *observer) follows(ev *ObserverEvent) bool {
+	if o.userID > 0 && ev.UserID != o.userID {
+		return false
+	}
+	if len(o.plugin) > 0 && ev.Plugin != o.plugin {
+		return false
+	}
+	if len(ev.Plugin) > 0 && !o.user.Authorize(ev.Plugin) {
+		return false
+	}
+	return sampled(ev.UserID, o.sample)
+}
+
+// sampled reports whether a user falls in a sample of a share of all users,
+// from 0 to 1. The same users are always sampled for a given share, so QA
+// reviewers follow conversations from start to finish.
+func sampled(uid uint64, share float64) bool {
+	if share >= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strconv.FormatUint(uid, 10)))
+	return float64(h.Sum32()%10000) < share*10000
+}
+
+// HAPIObserve streams conversations as they happen over a websocket, one JSON
+// ObserverEvent per message, to users holding RoleObserver or
+// RoleObserverPII, or admins. The stream is read-only. Personal information
+// is redacted for everyone but RoleObserverPII, as configured for
+// redact.FieldMessage. Optional query parameters
+// limit the stream: sample, the share of users to follow from 0 to 1; plugin;
+// and uid.
+func HAPIObserve(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !LoggedIn(w, r) {
+			return
+		}
+	}
+	cookie, err := r.Cookie("id")
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	uid, err := strconv.ParseUint(cookie.Value, 10, 64)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	u, err := dt.GetUser(db, &dt.Request{UserID: uid})
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	if !u.HasRole(RoleObserver) && !u.HasRole(RoleObserverPII) &&
+		!u.HasRole(dt.RoleAdmin) {
+
+		writeErrorAuth(w, ErrNotObserver)
+		return
+	}
+	o := &observer{
+		user:   u,
+		events: make(chan ObserverEvent, observerBuffer),
+		redact: !u.HasRole(RoleObserverPII),
+		sample: 1,
+		plugin: r.URL.Query().Get("plugin"),
+	}
+	if s := r.URL.Query().Get("sample"); len(s) > 0 {
+		o.sample, err = strconv.ParseFloat(s, 64)
+		if err != nil || o.sample <= 0 {
+			writeErrorBadRequest(w, errors.New("invalid sample"))
+			return
+		}
+	}
+	if s := r.URL.Query().Get("uid"); len(s) > 0 {
+		if o.userID, err = strconv.ParseUint(s, 10, 64); err != nil {
+			writeErrorBadRequest(w, err)
+			return
+		}
+	}
+	log.Info("operator", u.ID, "observing conversations, redacted:",
+		o.redact)
+	websocket.Handler(o.stream).ServeHTTP(w, r)
+}
+
+// stream sends events to an observer's websocket until it disconnects.
+func (o *observer) stream(conn *websocket.Conn) {
+	observersMu.Lock()
+	observers[o] = struct{}{}
+	observersMu.Unlock()
+	defer func() {
+		observersMu.Lock()
+		delete(observers, o)
+		observersMu.Unlock()
+	}()
+
+	// Observers don't send anything, so reading only notices when they
+	// disconnect.
+	done := make(chan struct{})
+	go func() {
+		var discard string
+		for websocket.Message.Receive(conn, &discard) == nil {
+		}
+		close(done)
+	}()
+	for {
+		select {
+		case ev := <-o.events:
+			if err := websocket.JSON.Send(conn, ev); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
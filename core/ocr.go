@@ -0,0 +1,50 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/interface/ocr"
+)
+
+// maxOCRImages limits how many photos in a single message are read, since
+// each one is a slow call to the OCR service.
+const maxOCRImages = 3
+
+var ocrConn *ocr.Conn
+
+// readImages appends the text in any photos sent with a message to its
+// command, returning true if any text was found. Images that can't be read
+// are skipped, so the rest of the message is still processed.
+func readImages(cmd *string, images []string) bool {
+	if ocrConn == nil || len(images) == 0 {
+		return false
+	}
+	if len(images) > maxOCRImages {
+		images = images[:maxOCRImages]
+	}
+	texts := []string{}
+	if s := strings.TrimSpace(*cmd); len(s) > 0 {
+		texts = append(texts, s)
+	}
+	var found bool
+	for _, img := range images {
+		s, err := ocrConn.Read(img)
+		if err != nil {
+			log.Info("failed to read image", img, err)
+			continue
+		}
+		// The parser reads a message as one sentence, so flatten the
+		// lines of a receipt or flyer.
+		s = strings.Join(strings.Fields(s), " ")
+		if len(s) == 0 {
+			continue
+		}
+		texts = append(texts, s)
+		found = true
+	}
+	if found {
+		*cmd = strings.Join(texts, "\n")
+	}
+	return found
+}
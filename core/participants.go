@@ -0,0 +1,84 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// resolveParticipants resolves the speaker to the user and third parties
+// against the user's contacts, by relationship when mentioned as "my sister"
+// and otherwise by name. Participants who can't be found are left unresolved
+// for plugins to ask about.
+func resolveParticipants(u *dt.User, ps []nlp.Participant) {
+	for i := range ps {
+		p := &ps[i]
+		if p.Role == nlp.RoleSpeaker {
+			p.UserID = u.ID
+			continue
+		}
+		if u.ID == 0 {
+			continue
+		}
+		var c *dt.Contact
+		var err error
+		if len(p.Relationship) > 0 {
+			c, err = u.FindContactByRelationship(db, p.Relationship)
+		} else {
+			c, err = u.FindContact(db, p.Mention)
+		}
+		if err == dt.ErrNoContact {
+			continue
+		}
+		if err != nil {
+			log.Info("failed to resolve participant", p.Mention, err)
+			continue
+		}
+		p.ContactID = c.ID
+	}
+}
+
+// isContact reports whether a name is how the user mentioned one of their
+// contacts among resolved participants, e.g. "Paris" for a friend rather than
+// the city.
+func isContact(ps []nlp.Participant, name string) bool {
+	for _, p := range ps {
+		if p.ContactID > 0 && p.Mention == name {
+			return true
+		}
+	}
+	return false
+}
+
+// mention is where a third party is mentioned in a tokenized sentence.
+type mention struct {
+	nlp.Participant
+	Start int
+	Len   int
+}
+
+// mentions finds where each third party in a tokenized sentence is
+// mentioned, following nlp.ExtractParticipants.
+func mentions(tokens []string) []mention {
+	var ms []mention
+	var i int
+	for _, p := range nlp.ExtractParticipants(tokens) {
+		if p.Role != nlp.RoleThirdParty {
+			continue
+		}
+		words := strings.Fields(p.Mention)
+		for ; i+len(words) <= len(tokens); i++ {
+			if matchesPhrase(tokens[i:], words) {
+				break
+			}
+		}
+		if i+len(words) > len(tokens) {
+			break
+		}
+		ms = append(ms, mention{Participant: p, Start: i, Len: len(words)})
+		i += len(words)
+	}
+	return ms
+}
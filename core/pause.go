@@ -0,0 +1,96 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// defaultHelpMessage is the reply to "help." Operators can change it with the
+// help_message setting, e.g. to the compliance text their SMS carrier
+// requires, with their program's name and a support number.
+const defaultHelpMessage = "I'm Abot, your assistant. Just tell me what you need. Reply STOP to pause messages from me, and START to resume them."
+
+// pauseWords and resumeWords are the replies that pause and resume Abot. They
+// include the keywords carriers require SMS services to honor.
+var pauseWords = map[string]struct{}{
+	"stop": {}, "stopall": {}, "unsubscribe": {}, "end": {}, "quit": {},
+	"pause": {},
+}
+
+var resumeWords = map[string]struct{}{
+	"start": {}, "unstop": {}, "resume": {}, "unpause": {},
+}
+
+var helpWords = map[string]struct{}{
+	"help": {}, "info": {},
+}
+
+// smsPauseWords and smsResumeWords are carriers' other keywords, which are
+// only honored over SMS, since elsewhere they're more likely replies to
+// plugins, like "cancel" or "yes."
+var smsPauseWords = map[string]struct{}{"cancel": {}}
+
+var smsResumeWords = map[string]struct{}{"yes": {}}
+
+// managePause answers messages that are nothing but a keyword to pause Abot,
+// like "stop," to resume it, like "start," or for help. Pausing mutes the
+// messages Abot sends on its own, like reminders and plugins' notifications,
+// and the nudges to finish conversations in progress, until the user resumes.
+// Replies to the user's own messages are still sent. People who haven't
+// signed up, like the contacts plugins text on a user's behalf, pause the
+// FlexID they messaged from. An empty string is returned if the message isn't
+// a keyword.
+func managePause(msg *dt.Msg) string {
+	if msg.User == nil {
+		return ""
+	}
+	w := strings.ToLower(strings.Trim(msg.Sentence, " \t\n.!"))
+	sms := dt.ChannelName(msg.User.FlexIDType) == dt.ChannelSMS
+	if _, ok := helpWords[w]; ok {
+		if s := Setting("help_message"); len(s) > 0 {
+			return s
+		}
+		return defaultHelpMessage
+	}
+	if isKeyword(w, pauseWords, smsPauseWords, sms) {
+		if err := msg.User.Pause(db); err != nil {
+			log.Info("failed to pause user", err)
+			return "I'm sorry, I couldn't pause messages right now. Please try again."
+		}
+		return "OK, I've paused messages. I'll only reply when you message me. Reply START to resume."
+	}
+	if isKeyword(w, resumeWords, smsResumeWords, sms) {
+		paused, err := msg.User.Paused(db)
+		if err != nil {
+			log.Info("failed to check if user paused", err)
+			return ""
+		}
+		if !paused {
+			// "start" may be meant for a plugin.
+			return ""
+		}
+		if err = msg.User.Resume(db); err != nil {
+			log.Info("failed to resume user", err)
+			return "I'm sorry, I couldn't resume messages right now. Please try again."
+		}
+		return "Welcome back! I've resumed messages."
+	}
+	return ""
+}
+
+// isKeyword reports whether a word is one of keywords, or of smsKeywords for
+// messages sent over SMS.
+func isKeyword(w string, keywords, smsKeywords map[string]struct{},
+	sms bool) bool {
+
+	if _, ok := keywords[w]; ok {
+		return true
+	}
+	if !sms {
+		return false
+	}
+	_, ok := smsKeywords[w]
+	return ok
+}
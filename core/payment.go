@@ -0,0 +1,211 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/payment"
+	"github.com/itsabot/abot/shared/interface/payment/driver"
+)
+
+// currency is the ISO code of the currency in which all charges are made.
+const currency = "usd"
+
+var paymentConn *payment.Conn
+
+// ErrMissingPaymentDriver is returned when a payment is attempted, but no
+// payment driver has been imported.
+var ErrMissingPaymentDriver = errors.New("missing payment driver")
+
+// ErrUnregisteredUser is returned when an action requires a user to have
+// signed up, such as adding a card.
+var ErrUnregisteredUser = errors.New("user is not registered")
+
+// Charge a user's primary card and send them a receipt. If the user has no
+// card on file, dt.ErrNoCard is returned, and the user should be sent a link
+// to add one from CardURL. If the AuthPolicy requires it and the user hasn't
+// authenticated recently enough, dt.ErrAuthRequired is returned, and the user
+// should be challenged with dt.ChallengeUser first.
+func Charge(u *dt.User, amountInCents uint64, desc string) (*dt.Charge,
+	error) {
+
+	if paymentConn == nil {
+		return nil, ErrMissingPaymentDriver
+	}
+	if !u.Registered() {
+		return nil, dt.ErrNoCard
+	}
+	if p := AuthPolicy(); p.RequireForPurchases {
+		// Read when the user last authenticated again, since they may
+		// have just passed a challenge to make this purchase.
+		var t time.Time
+		q := `SELECT lastauthenticated FROM users WHERE id=$1`
+		if err := db.Get(&t, q, u.ID); err != nil {
+			return nil, err
+		}
+		u.LastAuthenticated = &t
+		if !u.IsAuthenticated(p, dt.ActionPurchase, 0) {
+			return nil, dt.ErrAuthRequired
+		}
+	}
+	card, err := u.GetPrimaryCard(db)
+	if err != nil {
+		return nil, err
+	}
+	sid, err := paymentConn.ChargeCard(uint64(card.ID), amountInCents,
+		currency, desc)
+	if err != nil {
+		return nil, err
+	}
+	auditUser(u, AuditPurchase, fmt.Sprintf("charged $%.2f for %s",
+		float64(amountInCents)/100, desc))
+	c := &dt.Charge{
+		UserID:          u.ID,
+		CardID:          uint64(card.ID),
+		Amount:          amountInCents,
+		Currency:        currency,
+		Description:     desc,
+		ServiceChargeID: sid,
+	}
+	q := `INSERT INTO charges
+	      (userid, cardid, amount, currency, description, servicechargeid)
+	      VALUES ($1, $2, $3, $4, $5, $6)
+	      RETURNING id, createdat`
+	err = db.QueryRow(q, c.UserID, c.CardID, c.Amount, c.Currency,
+		c.Description, c.ServiceChargeID).Scan(&c.ID, &c.CreatedAt)
+	if err != nil {
+		// The card was charged, so don't return an error that might
+		// lead the plugin to retry and charge the user twice.
+		log.Info("failed to save charge", sid, err)
+		return c, nil
+	}
+	if err = notifyUser(u, c.Receipt(card)); err != nil {
+		log.Info("failed to send receipt for charge", c.ID, err)
+	}
+	return c, nil
+}
+
+// Refund some or all of a charge. Pass an amount of 0 to refund whatever
+// remains of the charge. The user is notified of the refund.
+func Refund(chargeID, amountInCents uint64) error {
+	if paymentConn == nil {
+		return ErrMissingPaymentDriver
+	}
+	c := &dt.Charge{}
+	q := `SELECT id, userid, amount, refundedamount, description,
+	          servicechargeid
+	      FROM charges
+	      WHERE id=$1`
+	if err := db.Get(c, q, chargeID); err != nil {
+		return err
+	}
+	remaining := c.Amount - c.RefundedAmount
+	if amountInCents == 0 || amountInCents > remaining {
+		amountInCents = remaining
+	}
+	if amountInCents == 0 {
+		return nil
+	}
+	err := paymentConn.Refund(c.ServiceChargeID, amountInCents)
+	if err != nil {
+		return err
+	}
+	auditUser(&dt.User{ID: c.UserID}, AuditRefund, fmt.Sprintf(
+		"refunded $%.2f of charge %d", float64(amountInCents)/100, c.ID))
+	q = `UPDATE charges SET refundedamount=refundedamount+$1 WHERE id=$2`
+	if _, err = db.Exec(q, amountInCents, c.ID); err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("I've refunded $%.2f for %s.",
+		float64(amountInCents)/100, c.Description)
+	return notifyUser(&dt.User{ID: c.UserID}, msg)
+}
+
+// CardURL returns a link to a secure page where the user can add a card,
+// registering the user with the payment service first if needed.
+func CardURL(u *dt.User) (string, error) {
+	if paymentConn == nil {
+		return "", ErrMissingPaymentDriver
+	}
+	if !u.Registered() {
+		return "", ErrUnregisteredUser
+	}
+	if len(u.PaymentServiceID) == 0 {
+		if err := paymentConn.RegisterUser(u); err != nil {
+			return "", err
+		}
+	}
+	return paymentConn.CardURL(u)
+}
+
+// handlePaymentEvent processes a webhook event from the payment service.
+func handlePaymentEvent(evt *driver.Event) error {
+	switch evt.Type {
+	case driver.EventCardAdded:
+		u := &dt.User{}
+		q := `SELECT id, name, email, paymentserviceid FROM users
+		      WHERE paymentserviceid=$1`
+		if err := db.Get(u, q, evt.PaymentServiceID); err != nil {
+			return err
+		}
+		if _, err := paymentConn.SaveCard(evt.Card, u); err != nil {
+			return err
+		}
+		msg := fmt.Sprintf("Your %s ending in %s is all set.",
+			evt.Card.Brand, evt.Card.Last4)
+		return notifyUser(u, msg)
+	case driver.EventChargeRefunded:
+		// Refunds made through Abot have already been recorded, so
+		// only refunds made elsewhere change anything here.
+		q := `UPDATE charges SET refundedamount=$1
+		      WHERE servicechargeid=$2 AND refundedamount<$1`
+		_, err := db.Exec(q, evt.AmountInCents, evt.ServiceChargeID)
+		return err
+	case driver.EventChargeDisputed:
+		var id uint64
+		q := `UPDATE charges SET disputedat=$1, disputereason=$2
+		      WHERE servicechargeid=$3
+		      RETURNING id`
+		err := db.QueryRow(q, time.Now(), evt.Reason,
+			evt.ServiceChargeID).Scan(&id)
+		if err != nil {
+			return err
+		}
+		return notifyAdmin("Charge disputed", fmt.Sprintf(
+			"Charge #%d (%s) was disputed for $%.2f. Reason: %q",
+			id, evt.ServiceChargeID, float64(evt.AmountInCents)/100,
+			evt.Reason))
+	}
+	return nil
+}
+
+// notifyUser sends a message to a user right away through their most recently
+// used FlexID. Users can choose how urgently payment messages are sent through
+// their "payment" notification policy.
+func notifyUser(u *dt.User, content string) error {
+	fid, fidT, err := u.LastFlexID(db)
+	if err != nil {
+		return err
+	}
+	q := `INSERT INTO scheduledevents
+	      (content, flexid, flexidtype, sendat, category, priority)
+	      VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = db.Exec(q, content, fid, fidT, time.Now(), "payment",
+		dt.PriorityNormal)
+	return err
+}
+
+// notifyAdmin emails ADMIN_EMAIL. If no email driver has been imported, the
+// message is logged instead.
+func notifyAdmin(subj, content string) error {
+	if emailConn == nil {
+		log.Info(subj, content)
+		return nil
+	}
+	admin := os.Getenv("ADMIN_EMAIL")
+	return emailConn.SendPlainText([]string{admin}, admin, subj, content)
+}
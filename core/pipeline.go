@@ -0,0 +1,290 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// Names of the built-in pipeline stages.
+const (
+	// StageAliases expands the user's aliases, like "the usual."
+	StageAliases = "aliases"
+
+	// StageCode sets aside one-time codes sent while a plugin awaits one.
+	StageCode = "code"
+
+	// StageNormalize tokenizes the sentence, fixing common typos and
+	// expanding contractions and dropping filler words.
+	StageNormalize = "normalize"
+
+	// StageVocabulary rewrites the synonyms of the tenant's vocabulary
+	// packs.
+	StageVocabulary = "vocabulary"
+
+	// StageEntities finds the people, businesses and things named, like
+	// "John Smith," so each is classified as a single word.
+	StageEntities = "entities"
+
+	// StageSpellcheck corrects misspelled tokens.
+	StageSpellcheck = "spellcheck"
+
+	// StageClassify stems the tokens and finds their commands and
+	// objects with the live model.
+	StageClassify = "classify"
+
+	// StageResolve resolves the people and times mentioned, and the
+	// pronouns referring to those mentioned before.
+	StageResolve = "resolve"
+
+	// StageRoute finds the plugin to handle the message.
+	StageRoute = "route"
+)
+
+// ErrUnknownStage is returned when configuring a pipeline with a stage that
+// hasn't been registered.
+var ErrUnknownStage = errors.New("unknown pipeline stage")
+
+// Stage is a step in building a message from a user's sentence, e.g.
+// classifying it. Each stage reads and updates the fields of the message left
+// by the stages before it. Errors stop the message from being processed.
+type Stage func(in *dt.Msg) error
+
+var stages = map[string]Stage{
+	StageAliases:    aliasStage,
+	StageCode:       codeStage,
+	StageNormalize:  normalizeStage,
+	StageVocabulary: vocabStage,
+	StageEntities:   entitiesStage,
+	StageSpellcheck: spellcheckStage,
+	StageClassify:   classifyStage,
+	StageResolve:    resolveStage,
+	StageRoute:      routeStage,
+}
+
+// defaultPipeline is the order of stages when operators haven't configured
+// one. Custom stages are added after the stage they name.
+var defaultPipeline = []string{StageAliases, StageCode, StageNormalize,
+	StageVocabulary, StageEntities, StageSpellcheck, StageClassify,
+	StageResolve, StageRoute}
+
+var stagesMu sync.RWMutex
+
+// RegisterStage adds a custom stage to the pipeline, running after the stage
+// named by after, or last if that's empty. Registering a name twice replaces
+// the earlier stage. Operators who have configured the pipeline must add the
+// stage to it themselves.
+func RegisterStage(name, after string, fn Stage) {
+	stagesMu.Lock()
+	defer stagesMu.Unlock()
+	if _, ok := stages[name]; ok {
+		stages[name] = fn
+		return
+	}
+	stages[name] = fn
+	for i, s := range defaultPipeline {
+		if s == after {
+			defaultPipeline = append(defaultPipeline[:i+1],
+				append([]string{name}, defaultPipeline[i+1:]...)...)
+			return
+		}
+	}
+	defaultPipeline = append(defaultPipeline, name)
+}
+
+// Pipeline returns the names of the stages each message passes through, in
+// order. Operators can reorder or disable stages with the pipeline setting, a
+// comma-separated list of stage names.
+func Pipeline() []string {
+	s := Setting("pipeline")
+	stagesMu.RLock()
+	defer stagesMu.RUnlock()
+	if len(s) == 0 {
+		return append([]string{}, defaultPipeline...)
+	}
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		names = append(names, strings.TrimSpace(name))
+	}
+	return names
+}
+
+// SetPipeline validates and saves the order of the stages each message passes
+// through. Stages left out are disabled.
+func SetPipeline(names []string) error {
+	stagesMu.RLock()
+	seen := map[string]struct{}{}
+	for _, name := range names {
+		_, ok := stages[name]
+		_, dup := seen[name]
+		if !ok || dup {
+			stagesMu.RUnlock()
+			return fmt.Errorf("%s: %q", ErrUnknownStage, name)
+		}
+		seen[name] = struct{}{}
+	}
+	stagesMu.RUnlock()
+	return SetSetting("pipeline", strings.Join(names, ","))
+}
+
+// runPipeline passes a message through each stage of the pipeline in order.
+func runPipeline(in *dt.Msg) error {
+	for _, name := range Pipeline() {
+		stagesMu.RLock()
+		fn, ok := stages[name]
+		stagesMu.RUnlock()
+		if !ok {
+			// The stage's plugin may have been uninstalled.
+			continue
+		}
+		if err := fn(in); err != nil {
+			return fmt.Errorf("stage %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// normalizeStage tokenizes and normalizes the sentence, fixing common typos
+// and expanding contractions except in the words the user asked Abot not to
+// correct. Filler words like "um" and "please" are removed from the tokens,
+// but the Sentence is left as the user wrote it.
+func normalizeStage(in *dt.Msg) error {
+	in.StructuredInput.RawSentence = in.Sentence
+	in.SentenceTokens = nlp.NormalizeTokens(nlp.SentenceTokens(in.Sentence),
+		uncorrectedWords(in.User))
+	in.StructuredInput.NormalizedSentence = nlp.NormalizeSentence(
+		in.SentenceTokens)
+	in.UnfilteredTokens = nlp.TokenTexts(in.SentenceTokens)
+	in.Tokens = RemoveStopwords(in.UnfilteredTokens, parserLang)
+	return nil
+}
+
+func spellcheckStage(in *dt.Msg) error {
+	in.Tokens = spellcheckExcept(in.Tokens, uncorrectedWords(in.User))
+	return nil
+}
+
+func classifyStage(in *dt.Msg) error {
+	in.Stems = nlp.StemTokens(in.Tokens)
+	si := classifyWithEntities(in.Tokens, in.StructuredInput.Entities)
+	nlp.MarkNegations(si.Classes, in.UnfilteredTokens)
+	in.StructuredInput.Commands = si.Commands
+	in.StructuredInput.Objects = si.Objects
+	in.StructuredInput.Actors = si.Actors
+	in.StructuredInput.Classes = si.Classes
+	return nil
+}
+
+// defaultCrossChannelContextTTL is how long what users referred to on one
+// channel is remembered on their others, like an order emailed about and then
+// asked after by text.
+const defaultCrossChannelContextTTL = 24 * time.Hour
+
+// crossChannelContextTTL returns the context_cross_channel_hours setting, or
+// defaultCrossChannelContextTTL. Zero keeps each channel's context separate.
+func crossChannelContextTTL() time.Duration {
+	s := Setting("context_cross_channel_hours")
+	if len(s) == 0 {
+		return defaultCrossChannelContextTTL
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		log.Info("invalid cross channel context ttl", s)
+		return defaultCrossChannelContextTTL
+	}
+	return time.Duration(n) * time.Hour
+}
+
+func resolveStage(in *dt.Msg) error {
+	si := in.StructuredInput
+	si.Participants = nlp.ExtractParticipants(in.UnfilteredTokens)
+	si.Times, si.OnlyTime = extractOnlyTime(in.Sentence, time.Now())
+	si.DateTimes = nlp.ParseTimesFrom(in.Sentence, time.Now())
+	si.ParseTimes(time.Local)
+	si.References = nlp.ExtractReferences(in.UnfilteredTokens)
+	var cs *dt.ContextStore
+	if in.User != nil && in.User.ID > 0 {
+		cs = dt.NewContextStore(db, in.User.ID, in.User.FlexIDType)
+		cs.CrossChannelTTL = crossChannelContextTTL()
+		if err := cs.ResolvePronouns(si); err != nil {
+			// Context is a convenience, so don't stop the message.
+			log.Info("failed to resolve pronouns", err)
+		}
+	}
+	resolveParticipants(in.User, si.Participants)
+	si.Places = nil
+	for _, name := range nlp.ExtractPlaces(in.Sentence) {
+		if !isContact(si.Participants, name) {
+			si.Places = append(si.Places, name)
+		}
+	}
+	si.Numbers = nlp.ExtractNumbers(in.Sentence)
+	si.Quantities = nlp.ExtractQuantities(in.Sentence)
+	si.Sentiment = Sentiment(in.Sentence)
+	inferLocation(in)
+	resolvePlaces(in)
+	if cs != nil {
+		if err := cs.RememberInput(si); err != nil {
+			log.Info("failed to remember context", err)
+		}
+	}
+	return nil
+}
+
+func routeStage(in *dt.Msg) error {
+	p, route, followup, err := GetPlugin(db, in)
+	if err != nil && err != ErrMissingPlugin {
+		return err
+	}
+	in.Route, in.Followup, in.Plugin = route, followup, ""
+	if p != nil {
+		in.Plugin = p.Config.Name
+	}
+	return nil
+}
+
+// HAPIPipeline responds with the stages each message passes through, in
+// order, along with every stage available.
+func HAPIPipeline(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	stagesMu.RLock()
+	var available []string
+	for name := range stages {
+		available = append(available, name)
+	}
+	stagesMu.RUnlock()
+	sort.Strings(available)
+	writeBytes(w, struct {
+		Stages    []string
+		Available []string
+	}{Stages: Pipeline(), Available: available})
+}
+
+// HAPIPipelineSubmit reorders or disables pipeline stages.
+func HAPIPipelineSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct{ Stages []string }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err := SetPipeline(req.Stages); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
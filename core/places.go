@@ -0,0 +1,276 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/places"
+	"github.com/itsabot/abot/shared/interface/places/driver"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// nearbyRadius is how far from a user to search for places, in meters.
+const nearbyRadius = 5000
+
+// geocodeLimit is the most candidates returned when geocoding a place name.
+const geocodeLimit = 3
+
+// defaultGeocodeCacheTTL is how long geocoding results are cached. Places
+// rarely move, so they're kept for a while.
+const defaultGeocodeCacheTTL = 30 * 24 * time.Hour
+
+var placesConn *places.Conn
+
+var geocoderMu sync.RWMutex
+var customGeocoder driver.Geocoder
+
+func init() {
+	RegisterJob("geocode_cache_purge", time.Hour, purgeGeocodes)
+}
+
+// ErrMissingPlacesDriver is returned when searching for places, but no places
+// driver has been imported.
+var ErrMissingPlacesDriver = errors.New("missing places driver")
+
+// NearbySearch finds places matching a keyword, like "coffee" or "pharmacy,"
+// near the location the user most recently shared, nearest first. If the user
+// hasn't shared a location recently, dt.ErrNoLocation is returned, and the
+// user should be asked where they are with the prompt from ResolveHere.
+func NearbySearch(u *dt.User, keyword string, limit int) ([]driver.Place,
+	error) {
+
+	if placesConn == nil {
+		return nil, ErrMissingPlacesDriver
+	}
+	l, err := hereLocation(u)
+	if err != nil {
+		return nil, err
+	}
+	return NearbySearchAt(l, keyword, limit)
+}
+
+// NearbySearchAt finds places matching a keyword near a location, nearest
+// first.
+func NearbySearchAt(l *dt.Location, keyword string, limit int) (
+	[]driver.Place, error) {
+
+	if placesConn == nil {
+		return nil, ErrMissingPlacesDriver
+	}
+	ps, err := placesConn.NearbySearch(&driver.Query{
+		Keyword:        keyword,
+		Lat:            l.Lat,
+		Lon:            l.Lon,
+		RadiusInMeters: nearbyRadius,
+		Limit:          limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range ps {
+		if ps[i].DistanceInMeters == 0 {
+			ps[i].DistanceInMeters = distance(l.Lat, l.Lon, ps[i].Lat,
+				ps[i].Lon)
+		}
+	}
+	sort.Sort(byDistance(ps))
+	return ps, nil
+}
+
+// SetGeocoder replaces the places driver for geocoding, e.g. with Nominatim
+// where the places service covers businesses but not addresses. It should be
+// called before Abot begins processing messages, e.g. in a plugin's init.
+func SetGeocoder(g driver.Geocoder) {
+	geocoderMu.Lock()
+	defer geocoderMu.Unlock()
+	customGeocoder = g
+}
+
+// geocoder returns the Geocoder set with SetGeocoder, or else the places
+// driver's connection, or nil if there's neither.
+func geocoder() driver.Geocoder {
+	geocoderMu.RLock()
+	g := customGeocoder
+	geocoderMu.RUnlock()
+	if g != nil {
+		return g
+	}
+	if placesConn != nil {
+		return placesConn
+	}
+	return nil
+}
+
+// Geocode finds places matching a name or address, like "Springfield," most
+// relevant first. If the user previously chose among several places with the
+// same name, only that place is returned, so a single result can be used
+// without asking the user again. Otherwise multiple results mean the name is
+// ambiguous, and the user should be asked which they meant.
+func Geocode(u *dt.User, name string) ([]driver.Place, error) {
+	g := geocoder()
+	if g == nil {
+		return nil, ErrMissingPlacesDriver
+	}
+	name = normalizePlaceName(name)
+	if u.Registered() {
+		var b []byte
+		q := `SELECT place FROM placechoices WHERE userid=$1 AND name=$2`
+		err := db.Get(&b, q, u.ID, name)
+		if err == nil {
+			p := driver.Place{}
+			if err = json.Unmarshal(b, &p); err != nil {
+				return nil, err
+			}
+			return []driver.Place{p}, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return geocodeCached(g, name)
+}
+
+// geocodeCached geocodes a normalized place name, caching the results in
+// Postgres for the geocode_cache_days setting, or defaultGeocodeCacheTTL, to
+// limit calls to the geocoding service. Names found nowhere are cached too.
+func geocodeCached(g driver.Geocoder, name string) ([]driver.Place, error) {
+	ttl := geocodeCacheTTL()
+	var b []byte
+	q := `SELECT places FROM geocodes WHERE query=$1 AND createdat>$2`
+	err := db.Get(&b, q, name, time.Now().Add(-ttl))
+	if err == nil {
+		var ps []driver.Place
+		if err = json.Unmarshal(b, &ps); err != nil {
+			return nil, err
+		}
+		return ps, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+	ps, err := g.Geocode(name, geocodeLimit)
+	if err != nil {
+		return nil, err
+	}
+	if ttl == 0 {
+		return ps, nil
+	}
+	if b, err = json.Marshal(ps); err != nil {
+		return nil, err
+	}
+	q = `INSERT INTO geocodes (query, places) VALUES ($1, $2)
+	     ON CONFLICT (query) DO UPDATE
+	     SET places=$2, createdat=CURRENT_TIMESTAMP`
+	if _, err = db.Exec(q, name, b); err != nil {
+		log.Info("failed to cache geocode", err)
+	}
+	return ps, nil
+}
+
+func geocodeCacheTTL() time.Duration {
+	s := Setting("geocode_cache_days")
+	if len(s) == 0 {
+		return defaultGeocodeCacheTTL
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		log.Info("invalid geocode cache ttl", s)
+		return defaultGeocodeCacheTTL
+	}
+	return time.Duration(n) * 24 * time.Hour
+}
+
+// purgeGeocodes deletes expired geocoding results.
+func purgeGeocodes() error {
+	q := `DELETE FROM geocodes WHERE createdat<=$1`
+	_, err := db.Exec(q, time.Now().Add(-geocodeCacheTTL()))
+	return err
+}
+
+// resolvePlaces geocodes the places named in a message, adding those that
+// match exactly one place to its ParsedPlaces. Names matching several places
+// are ambiguous, so plugins should ask the user which they meant with
+// Geocode's results.
+func resolvePlaces(in *dt.Msg) {
+	si := in.StructuredInput
+	si.ParsedPlaces = nil
+	if geocoder() == nil || len(si.Places) == 0 {
+		return
+	}
+	u := in.User
+	if u == nil {
+		u = &dt.User{}
+	}
+	for _, name := range si.Places {
+		ps, err := Geocode(u, name)
+		if err != nil {
+			log.Info("failed to geocode place", name, err)
+			continue
+		}
+		if len(ps) != 1 {
+			continue
+		}
+		si.ParsedPlaces = append(si.ParsedPlaces, nlp.Place{
+			Name:     name,
+			Address:  ps[0].Address,
+			Lat:      ps[0].Lat,
+			Lng:      ps[0].Lon,
+			Accuracy: ps[0].Accuracy,
+		})
+	}
+}
+
+// RememberPlace saves the place a user chose for an ambiguous name, so future
+// references to the same name by that user resolve to it.
+func RememberPlace(u *dt.User, name string, p *driver.Place) error {
+	if !u.Registered() {
+		return nil
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	q := `INSERT INTO placechoices (userid, name, place) VALUES ($1, $2, $3)
+	      ON CONFLICT (userid, name) DO UPDATE SET place=$3`
+	_, err = db.Exec(q, u.ID, normalizePlaceName(name), b)
+	return err
+}
+
+func normalizePlaceName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// distance returns the great-circle distance between two points in meters
+// using the haversine formula.
+func distance(lat1, lon1, lat2, lon2 float64) int {
+	const earthRadius = 6371000
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*
+		math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return int(2 * earthRadius * math.Asin(math.Sqrt(a)))
+}
+
+type byDistance []driver.Place
+
+func (p byDistance) Len() int {
+	return len(p)
+}
+
+func (p byDistance) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
+
+func (p byDistance) Less(i, j int) bool {
+	return p[i].DistanceInMeters < p[j].DistanceInMeters
+}
@@ -3,11 +3,12 @@ package core
 import (
 	"database/sql"
 	"runtime"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/itsabot/abot/core/log"
 	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -27,12 +28,17 @@ var RegPlugins = pkgMap{
 	mutex: &sync.Mutex{},
 }
 
+// RouteQuestion is the route for questions that don't trigger any plugin, like
+// "Who wrote Moby Dick?" A plugin such as search can handle these questions by
+// registering itself under this route.
+const RouteQuestion = "question"
+
 // AllPlugins contains a set of all registered plugins.
 var AllPlugins = []*dt.Plugin{}
 
 // pkgMap is a thread-safe atomic map that's used to route user messages to the
-// appropriate plugins. The map's key is the route in the form of
-// command_object, e.g. "find_restaurant".
+// appropriate plugins. The map's key is the route: the name of an intent, e.g.
+// "find_restaurant". See RegisterIntents.
 type pkgMap struct {
 	pkgs  map[string]*dt.Plugin
 	mutex *sync.Mutex
@@ -60,12 +66,35 @@ func (pm pkgMap) Set(k string, v *dt.Plugin) {
 // dictates whether this is the first consecutive time the user has sent that
 // plugin a message, or if the user is engaged in a conversation with the
 // plugin. This difference enables plugins to respond differently--like reset
-// state--when messaged for the first time in each new conversation.
+// state--when messaged for the first time in each new conversation. Replies
+// cached by CacheResponses are returned without calling the plugin or counting
+// against its quota.
 func CallPlugin(p *dt.Plugin, in *dt.Msg, followup bool) string {
 	var reply string
 	if p == nil {
 		return reply
 	}
+	// Check roles before the cache, so a cached reply isn't sent to a user
+	// who may not use the plugin.
+	if !in.User.Authorize(p.Config.Name) {
+		return "I'm sorry, you're not allowed to use " + p.Config.Name +
+			". Please ask an admin for access."
+	}
+	var key string
+	var ttl time.Duration
+	if !followup {
+		key, ttl = cacheKey(p, in)
+		if len(key) > 0 {
+			if cached, ok := cachedResponse(key); ok {
+				return cached
+			}
+		}
+	}
+	if in.User != nil && !checkQuota(p, in.User) {
+		return "I'm sorry, you've reached your limit for " +
+			p.Config.Name + " for now. Please try again later."
+	}
+	auditMsg(in, AuditPlugin, p.Config.Name+" "+in.Route)
 	var err error
 	if followup {
 		reply, err = p.FollowUp(in)
@@ -74,6 +103,10 @@ func CallPlugin(p *dt.Plugin, in *dt.Msg, followup bool) string {
 	}
 	if err != nil {
 		log.Debug(err)
+		return reply
+	}
+	if len(key) > 0 {
+		cacheResponse(key, reply, ttl)
 	}
 	return reply
 }
@@ -103,24 +136,49 @@ func GetPlugin(db *sqlx.DB, m *dt.Msg) (*dt.Plugin, string, bool, error) {
 	}
 	log.Debugf("found user's last route: %q\n", prevRoute)
 
-	// Iterate over all command/object pairs and see if any plugin has been
-	// registered for the resulting route
-	for _, c := range m.StructuredInput.Commands {
-		for _, o := range m.StructuredInput.Objects {
-			route := strings.ToLower(c + "_" + o)
-			log.Debug("searching for route", route)
-			if p := RegPlugins.Get(route); p != nil {
-				// Found route. Return it
-				return p, route, false, nil
+	// One-time codes go to the plugin awaiting them.
+	if len(m.StructuredInput.Code) > 0 {
+		v, err := pendingVerification(m.User.ID, "")
+		if err != nil {
+			return nil, "", false, err
+		}
+		if v != nil {
+			if p := enabledPlugin(v.Route); p != nil {
+				return p, v.Route, true, nil
+			}
+		}
+	}
+
+	// Messages with only a time, like "actually make it 7pm," amend the
+	// conversation in progress or, failing that, the event the user most
+	// recently set a time for, rather than starting anything new.
+	if m.StructuredInput.OnlyTime {
+		if p := enabledPlugin(prevRoute); p != nil {
+			return p, prevRoute, true, nil
+		}
+		tc, err := GetTimeContext(m.User.ID)
+		if err != nil {
+			return nil, "", false, err
+		}
+		if tc != nil {
+			if p := enabledPlugin(tc.Route); p != nil {
+				return p, tc.Route, true, nil
 			}
 		}
 	}
 
+	d := routeMsg(m.StructuredInput, m.Tokens, prevRoute)
+	log.Debug("routing:", d.Reason)
+	if p := RegPlugins.Get(d.Route); len(d.Route) > 0 && p != nil {
+		return p, d.Route, d.Route == RouteQuestion &&
+			prevRoute == RouteQuestion, nil
+	}
+
 	// The user input didn't match any plugins. Lets see if the prevRoute
 	// does
 	if prevRoute != "" {
 		log.Debug("checking prevRoute for pkg")
-		if p := RegPlugins.Get(prevRoute); p != nil {
+		if p := enabledPlugin(prevRoute); p != nil {
 			// Prev route matches a pkg! Return it
 			return p, prevRoute, true, nil
 		}
@@ -130,3 +188,25 @@ func GetPlugin(db *sqlx.DB, m *dt.Msg) (*dt.Plugin, string, bool, error) {
 	log.Debug("could not match user input to any plugin")
 	return nil, "", false, ErrMissingPlugin
 }
+
+// matchRoute finds the plugin triggered by a message's commands and objects,
+// independent of the user's conversation. See routeMsg.
+func matchRoute(si *nlp.StructuredInput, tokens []string) (*dt.Plugin,
+	string) {
+
+	d := routeMsg(si, tokens, "")
+	if len(d.Route) == 0 {
+		return nil, ""
+	}
+	return RegPlugins.Get(d.Route), d.Route
+}
+
+// enabledPlugin returns the plugin registered for a route, or nil if there is
+// none or operators have disabled it.
+func enabledPlugin(route string) *dt.Plugin {
+	p := RegPlugins.Get(route)
+	if p == nil || !PluginEnabled(p.Config.Name) {
+		return nil
+	}
+	return p
+}
@@ -0,0 +1,269 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// deletionGrace is how long after a user asks to delete their data that it's
+// deleted, so they can change their mind.
+const deletionGrace = 24 * time.Hour
+
+// challengeDeleteData is the reason users are challenged for before their
+// data is deleted.
+const challengeDeleteData = "delete_data"
+
+var (
+	regexPrivacyShow   = regexp.MustCompile(`(?i)^\s*(?:what do you know about me|what (?:data|info|information) do you (?:have|keep|store) (?:about|on) me)\s*[.!?]*\s*$`)
+	regexPrivacyForget = regexp.MustCompile(`(?i)^\s*(?:please\s+)?forget\s+(?:all\s+)?(?:of\s+)?my\s+(?:(home|work|office)\s+)?(address(?:es)?|locations?|preferences|settings|memories|facts|contacts|history|messages)\s*[.!]*\s*$`)
+	regexPrivacyDelete = regexp.MustCompile(`(?i)^\s*(?:please\s+)?(permanently\s+)?(?:delete|erase)\s+(?:all\s+)?(?:of\s+)?my\s+(?:data|account|info|information)(\s+permanently)?\s*[.!]*\s*$`)
+	regexPrivacyCancel = regexp.MustCompile(`(?i)^\s*(?:cancel (?:the\s+|my\s+)?deletion|(?:don'?t|do not) delete my (?:data|account|info|information))\s*[.!]*\s*$`)
+)
+
+// privacyTable is a table of users' data they may ask Abot to forget.
+type privacyTable struct {
+	Name  string
+	Label string
+}
+
+// privacyTables are the tables of users' data shown when they ask what Abot
+// knows about them, in order.
+var privacyTables = []privacyTable{
+	{Name: "addresses", Label: "saved addresses"},
+	{Name: "cards", Label: "cards"},
+	{Name: "contacts", Label: "contacts"},
+	{Name: "preferences", Label: "preferences"},
+	{Name: "facts", Label: "things I remember about you"},
+	{Name: "locations", Label: "locations"},
+	{Name: "messages", Label: "messages"},
+}
+
+// forgettable maps the words users call their data, as in "forget my
+// locations," to its table.
+var forgettable = map[string]privacyTable{
+	"address":     privacyTables[0],
+	"addresses":   privacyTables[0],
+	"contacts":    privacyTables[2],
+	"preferences": privacyTables[3],
+	"settings":    privacyTables[3],
+	"memories":    privacyTables[4],
+	"facts":       privacyTables[4],
+	"location":    privacyTables[5],
+	"locations":   privacyTables[5],
+	"history":     privacyTables[6],
+	"messages":    privacyTables[6],
+}
+
+// managePrivacy answers requests to see, forget and delete the user's data,
+// e.g. "what do you know about me," "forget my home address" and "delete my
+// data," so users needn't contact support to exercise their privacy rights.
+// Deleting a user's data requires them to confirm and, if they have a second
+// factor, pass a challenge, and happens after deletionGrace. An empty string
+// is returned if the message isn't such a request.
+func managePrivacy(msg *dt.Msg) string {
+	if !msg.User.Registered() {
+		return ""
+	}
+	u := msg.User
+	if regexPrivacyShow.MatchString(msg.Sentence) {
+		s, err := describeUserData(u)
+		if err != nil {
+			log.Info("failed to describe user data", err)
+			return "I'm sorry, I couldn't look up your data right now."
+		}
+		return s
+	}
+	if m := regexPrivacyForget.FindStringSubmatch(msg.Sentence); m != nil {
+		t := forgettable[strings.ToLower(m[2])]
+		n, err := forgetUserData(u, t, strings.ToLower(m[1]))
+		if err != nil {
+			log.Info("failed to forget user data", t.Name, err)
+			return "I'm sorry, I couldn't forget that right now."
+		}
+		if n == 0 {
+			return fmt.Sprintf("I don't have any %s for you.", t.Label)
+		}
+		detail := "forgot " + t.Name
+		if len(m[1]) > 0 {
+			detail += " " + strings.ToLower(m[1])
+		}
+		auditMsg(msg, AuditPreference, detail)
+		return fmt.Sprintf("OK, I've forgotten your %s.", t.Label)
+	}
+	if m := regexPrivacyDelete.FindStringSubmatch(msg.Sentence); m != nil {
+		return requestDeletion(msg, len(m[1]) > 0 || len(m[2]) > 0)
+	}
+	if regexPrivacyCancel.MatchString(msg.Sentence) {
+		q := `UPDATE users SET deleterequestedat=NULL
+		      WHERE id=$1 AND deleterequestedat IS NOT NULL`
+		res, err := db.Exec(q, u.ID)
+		if err != nil {
+			log.Info("failed to cancel deletion", err)
+			return "I'm sorry, I couldn't cancel the deletion right now. Please try again."
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return "Your data isn't scheduled to be deleted."
+		}
+		auditMsg(msg, AuditDeletion, "canceled")
+		return "OK, I won't delete your data."
+	}
+	return ""
+}
+
+// describeUserData summarizes what Abot knows about a user.
+func describeUserData(u *dt.User) (string, error) {
+	var user struct {
+		Name              string
+		Email             string
+		DeleteRequestedAt *time.Time
+	}
+	q := `SELECT name, email, deleterequestedat FROM users WHERE id=$1`
+	if err := db.Get(&user, q, u.ID); err != nil {
+		return "", err
+	}
+	lines := []string{"Here's what I know about you:"}
+	if len(user.Name) > 0 {
+		lines = append(lines, "Name: "+user.Name)
+	}
+	if len(user.Email) > 0 {
+		lines = append(lines, "Email: "+user.Email)
+	}
+	var fids []string
+	q = `SELECT flexid FROM userflexids WHERE userid=$1 ORDER BY flexid`
+	if err := db.Select(&fids, q, u.ID); err != nil {
+		return "", err
+	}
+	if len(fids) > 0 {
+		lines = append(lines, "Contact info: "+strings.Join(fids, ", "))
+	}
+	for _, t := range privacyTables {
+		var n int
+		q = fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE userid=$1`, t.Name)
+		if err := db.Get(&n, q, u.ID); err != nil {
+			return "", err
+		}
+		if n > 0 {
+			lines = append(lines, fmt.Sprintf("%d %s", n, t.Label))
+		}
+	}
+	lines = append(lines, `To remove something, say something like "forget my addresses." To remove everything, say "delete my data."`)
+	if user.DeleteRequestedAt != nil {
+		lines = append(lines, `Your data is scheduled to be deleted. Say "cancel deletion" to keep it.`)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// forgetUserData deletes a user's data in a table, returning how many rows
+// were deleted. Addresses may be limited to a name, like "home" or "work."
+func forgetUserData(u *dt.User, t privacyTable, name string) (int64,
+	error) {
+
+	q := fmt.Sprintf(`DELETE FROM %s WHERE userid=$1`, t.Name)
+	args := []interface{}{u.ID}
+	if t.Name == "addresses" && len(name) > 0 {
+		if name == "work" {
+			name = "office"
+		}
+		q += ` AND name=$2`
+		args = append(args, name)
+	}
+	res, err := db.Exec(q, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// requestDeletion schedules the user's data to be deleted after
+// deletionGrace once they've confirmed and passed any challenge.
+func requestDeletion(msg *dt.Msg, confirmed bool) string {
+	if !confirmed {
+		return `This permanently deletes everything I know about you, including your messages, addresses, cards and preferences, and can't be undone. To go ahead, say "permanently delete my data."`
+	}
+	passed, prompt, err := dt.ChallengeUser(msg.User, challengeDeleteData)
+	switch err {
+	case nil:
+		if !passed {
+			return prompt + ` Send it along with "permanently delete my data."`
+		}
+	case dt.ErrNoSecondFactor:
+		// The user has no other channel to prove it's them, so their
+		// confirmation has to do.
+	case dt.ErrTooManyAttempts:
+		return "I'm sorry, there have been too many attempts to confirm it's you. Please try again later."
+	default:
+		log.Info("failed to challenge user", err)
+		return "I'm sorry, I couldn't delete your data right now. Please try again."
+	}
+	q := `UPDATE users SET deleterequestedat=CURRENT_TIMESTAMP
+	      WHERE id=$1 AND deleterequestedat IS NULL`
+	if _, err = db.Exec(q, msg.User.ID); err != nil {
+		log.Info("failed to request deletion", err)
+		return "I'm sorry, I couldn't delete your data right now. Please try again."
+	}
+	auditMsg(msg, AuditDeletion, "requested")
+	return `OK. I'll delete your data within a day. If you change your mind before then, say "cancel deletion."`
+}
+
+// deleteRequestedUsers deletes the users who asked for their data to be
+// deleted more than deletionGrace ago.
+func deleteRequestedUsers() error {
+	var uids []uint64
+	q := `SELECT id FROM users WHERE deleterequestedat<$1`
+	err := db.Select(&uids, q, time.Now().Add(-deletionGrace))
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	for _, uid := range uids {
+		if err = DeleteUser(uid); err != nil {
+			return err
+		}
+		log.Info("deleted user", uid)
+	}
+	return nil
+}
+
+// DeleteUser permanently deletes a user and all of their data, including the
+// messages scheduled to be sent to them and their audit entries, e.g. to
+// honor a request to erase it.
+func DeleteUser(uid uint64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	// Scheduled events are kept by FlexID rather than user, so they're
+	// deleted before the user's FlexIDs.
+	for _, t := range []string{"escalations", "scheduledevents"} {
+		q := fmt.Sprintf(`DELETE FROM %s AS t USING userflexids AS f
+		                  WHERE f.userid=$1 AND t.flexid=f.flexid
+		                      AND t.flexidtype=f.flexidtype`, t)
+		if _, err = tx.Exec(q, uid); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	for _, t := range mergeTables {
+		q := fmt.Sprintf(`DELETE FROM %s WHERE userid=$1`, t.Name)
+		if _, err = tx.Exec(q, uid); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	q := `DELETE FROM sessions WHERE userid=$1`
+	if _, err = tx.Exec(q, uid); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	q = `DELETE FROM users WHERE id=$1`
+	if _, err = tx.Exec(q, uid); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -7,6 +7,7 @@ import (
 
 	log "github.com/itsabot/abot/core/log"
 	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/helpers/emailreply"
 )
 
 // ErrInvalidCommand denotes that a user-inputted command could not be
@@ -28,17 +29,44 @@ func Preprocess(r *http.Request) (*dt.Msg, error) {
 		log.Info("could not parse empty body", err)
 		return nil, err
 	}
+	extractEmailReply(req)
+	fromImage := readImages(&req.CMD, req.Images)
+	lang := translateIn(&req.CMD)
 	sendPostReceiveEvent(&req.CMD)
 	u, err := dt.GetUser(DB(), req)
 	if err != nil {
 		return nil, err
 	}
+	if req.Location != nil && u.Registered() {
+		if err = u.SaveLocation(DB(), req.Location); err != nil {
+			log.Info("failed to save shared location", err)
+		}
+	}
 	sendPreProcessingEvent(&req.CMD, u)
-	msg := NewMsg(u, req.CMD)
+	msg := newMsg(u, req.CMD)
+	msg.Lang = lang
+	msg.StructuredInput.FromImage = fromImage
+	if err = runPipeline(msg); err != nil {
+		return nil, err
+	}
 	// TODO trigger training if needed (see buildInput)
 	return msg, nil
 }
 
+// extractEmailReply keeps only the text the user newly wrote in an email,
+// dropping the quoted history, signature and other boilerplate their client
+// added. Emails without any text of the user's own, like a forward without
+// comment, are left whole, so nothing they sent is lost.
+func extractEmailReply(req *dt.Request) {
+	if req.FlexIDType != dt.FlexIDType(1) {
+		return
+	}
+	client := emailreply.DetectClient(req.Mailer, req.CMD)
+	if s := emailreply.Extract(req.CMD, client); len(s) > 0 {
+		req.CMD = s
+	}
+}
+
 // ProcessText is Abot's core logic. This function processes a user's message,
 // routes it to the correct plugin, and handles edge cases like offensive
 // language before returning a response to the user. Any user-presentable error
@@ -50,12 +78,72 @@ func ProcessText(r *http.Request) (ret string, uid uint64, err error) {
 	if err != nil {
 		return "", 0, err
 	}
+	if err = applySuggestion(msg); err != nil {
+		return "", msg.User.ID, err
+	}
 	log.Debug("processed input into message...")
 	log.Debug("commands:", msg.StructuredInput.Commands)
 	log.Debug(" objects:", msg.StructuredInput.Objects)
-	plugin, route, followup, pluginErr := GetPlugin(DB(), msg)
-	if pluginErr != nil && pluginErr != ErrMissingPlugin {
-		return "", msg.User.ID, pluginErr
+	route, followup := msg.Route, msg.Followup
+	var plugin *dt.Plugin
+	var pluginErr error
+	if len(msg.Plugin) > 0 {
+		plugin = RegPlugins.Get(route)
+	}
+	if plugin == nil {
+		pluginErr = ErrMissingPlugin
+	}
+	// Messages holding several requests are saved under the first.
+	intents := splitIntents(msg)
+	if len(intents) > 0 {
+		route, followup = intents[0].Route, false
+		plugin, pluginErr = RegPlugins.Get(route), nil
+	}
+	shadows := parseInShadow(msg)
+	liveRoute, livePlugin := route, msg.Plugin
+	// Keywords to pause Abot, times to be called back, arithmetic and
+	// date questions, searches of the user's history and requests about
+	// their data are answered by Abot itself rather than by any plugin.
+	builtinResp := managePause(msg)
+	if len(builtinResp) == 0 {
+		// Users handed off to a person are answered by operators
+		// until the conversation is released back to Abot.
+		relayed, err := relayToOperator(msg)
+		if err != nil {
+			return "", msg.User.ID, err
+		}
+		if relayed {
+			return "", msg.User.ID, nil
+		}
+		builtinResp = manageCallbackTime(msg)
+	}
+	if len(builtinResp) == 0 {
+		builtinResp = calculate(msg)
+	}
+	if len(builtinResp) == 0 {
+		builtinResp = findInHistory(msg)
+	}
+	if len(builtinResp) == 0 {
+		builtinResp = manageAliases(msg)
+	}
+	if len(builtinResp) == 0 {
+		builtinResp = manageUncorrected(msg)
+	}
+	if len(builtinResp) == 0 {
+		builtinResp = manageDayParts(msg)
+	}
+	if len(builtinResp) == 0 {
+		builtinResp = managePrivacy(msg)
+	}
+	if len(builtinResp) == 0 {
+		builtinResp = manageCorrection(msg)
+	}
+	if len(builtinResp) == 0 && plugin == nil && len(intents) == 0 {
+		builtinResp = answerWithMacro(msg)
+	}
+	if len(builtinResp) > 0 {
+		plugin, route, pluginErr = nil, "", ErrMissingPlugin
+		intents = nil
 	}
 	msg.Route = route
 	if plugin == nil {
@@ -66,12 +154,24 @@ func ProcessText(r *http.Request) (ret string, uid uint64, err error) {
 	if err = msg.Save(DB()); err != nil {
 		return "", msg.User.ID, err
 	}
+	observeMsg(ObserverEventMessage, msg, msg.Route)
+	sampleClassification(msg)
+	if len(shadows) > 0 {
+		go recordShadowParses(msg, liveRoute, livePlugin, shadows)
+	}
 	sendPostProcessingEvent(msg)
 	ret = RespondWithOffense(Offensive(), msg)
 	if len(ret) > 0 {
-		return ret, msg.User.ID, nil
-	}
-	if pluginErr != ErrMissingPlugin {
+		ret = applyEscalationRules(msg, ret)
+		ret = applyBusinessHours(msg, ret)
+		recordTurnCosts(msg, ret)
+		return render(msg, ret), msg.User.ID, nil
+	}
+	if len(builtinResp) > 0 {
+		ret = builtinResp
+	} else if len(intents) > 0 {
+		ret = callIntents(msg, intents)
+	} else if pluginErr != ErrMissingPlugin {
 		if followup {
 			log.Debug("message is a followup")
 		}
@@ -87,23 +187,43 @@ func ProcessText(r *http.Request) (ret string, uid uint64, err error) {
 	m := &dt.Msg{}
 	m.AbotSent = true
 	m.User = msg.User
+	var buttons []string
 	if len(ret) == 0 {
-		m.Sentence = ConfusedLang()
-		msg.NeedsTraining = true
-		if err = msg.Update(DB()); err != nil {
-			return "", m.User.ID, err
+		resp, handler := runFallbacks(msg)
+		m.Sentence, buttons = resp.Text, resp.Buttons
+		// Small talk isn't meant for any plugin, so it needn't be
+		// labeled for training.
+		if handler != FallbackSmallTalk {
+			msg.NeedsTraining = true
+			if err = msg.Update(DB()); err != nil {
+				return "", m.User.ID, err
+			}
 		}
 	} else {
 		m.Sentence = ret
 	}
+	m.Sentence = applyEscalationRules(msg, m.Sentence)
+	m.Sentence = applyBusinessHours(msg, m.Sentence)
 	if plugin != nil {
 		m.Plugin = plugin.Config.Name
 	}
 	if err = m.Save(db); err != nil {
 		return "", m.User.ID, err
 	}
+	observeMsg(ObserverEventResponse, m, msg.Route)
+	recordTurnCosts(msg, m.Sentence)
 	sendPostResponseEvent(msg, &ret)
-	return m.Sentence, m.User.ID, nil
+	return render(msg, m.Sentence, buttons...), m.User.ID, nil
+}
+
+// render translates a response and its buttons into the user's language and
+// formats them for their channel.
+func render(in *dt.Msg, resp string, buttons ...string) string {
+	r := &dt.Response{Text: translateOut(resp, in.Lang)}
+	for _, b := range buttons {
+		r.Buttons = append(r.Buttons, translateOut(b, in.Lang))
+	}
+	return RenderFor(in.User, r)
 }
 
 func sendPostReceiveEvent(cmd *string) {
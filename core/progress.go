@@ -0,0 +1,127 @@
+package core
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// progressInterval is the least time between a user's progress updates, so
+// chatty plugins don't flood users or run up SMS bills. Updates sent sooner are
+// dropped.
+const progressInterval = 3 * time.Second
+
+// maxPendingProgress is the most progress updates kept for a web user until
+// the console fetches them. Older updates are dropped.
+const maxPendingProgress = 5
+
+// progressUpdates tracks the progress updates sent to users, holding those
+// for web users until the console fetches them, since web replies are only
+// sent once the plugin's done.
+type progressUpdates struct {
+	mu      sync.Mutex
+	sentAt  map[uint64]time.Time
+	pending map[uint64][]string
+}
+
+var progress = &progressUpdates{
+	sentAt:  map[uint64]time.Time{},
+	pending: map[uint64][]string{},
+}
+
+// SendProgress sends a user an interim update while a plugin works on their
+// message, like "Searching flights..." or "Found 3 options, comparing
+// prices...," so slow backends don't leave them wondering whether Abot heard
+// them. Updates are sent as messages of their own on channels that allow it,
+// and fetched by the console on the web. They aren't saved to the transcript,
+// since the plugin's reply supersedes them. Updates sent within
+// progressInterval of the last are dropped.
+func SendProgress(in *dt.Msg, text string) error {
+	if in.User == nil || in.User.ID == 0 || len(text) == 0 {
+		return nil
+	}
+	name := dt.ChannelName(in.User.FlexIDType)
+	if !ChannelFor(name).Progress {
+		return nil
+	}
+	if !progress.allow(in.User.ID, time.Now()) {
+		return nil
+	}
+	text = render(in, text)
+	if name == dt.ChannelWeb {
+		progress.hold(in.User.ID, text)
+		return nil
+	}
+	evt := dt.ScheduledEvent{
+		Content:    text,
+		FlexID:     in.User.FlexID,
+		FlexIDType: in.User.FlexIDType,
+	}
+	return evt.Send(smsConn, emailConn)
+}
+
+// allow reports whether a user may be sent a progress update now, recording
+// the update if so.
+func (p *progressUpdates) allow(uid uint64, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Sub(p.sentAt[uid]) < progressInterval {
+		return false
+	}
+	p.sentAt[uid] = now
+	return true
+}
+
+// hold keeps a web user's progress update until the console fetches it.
+func (p *progressUpdates) hold(uid uint64, text string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ups := append(p.pending[uid], text)
+	if len(ups) > maxPendingProgress {
+		ups = ups[len(ups)-maxPendingProgress:]
+	}
+	p.pending[uid] = ups
+}
+
+// take returns a user's pending progress updates, forgetting them.
+func (p *progressUpdates) take(uid uint64) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ups := p.pending[uid]
+	delete(p.pending, uid)
+	return ups
+}
+
+// done forgets a user's progress once Abot has replied to their message, so
+// the next message's updates aren't throttled by this one's.
+func (p *progressUpdates) done(uid uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.sentAt, uid)
+	delete(p.pending, uid)
+}
+
+// HAPIProgress responds with the progress updates sent to the logged in user
+// since the last request, for the console to show while awaiting a reply.
+func HAPIProgress(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !LoggedIn(w, r) {
+			return
+		}
+	}
+	cookie, err := r.Cookie("id")
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	uid, err := strconv.ParseUint(cookie.Value, 10, 64)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	writeBytes(w, struct{ Updates []string }{Updates: progress.take(uid)})
+}
@@ -0,0 +1,422 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+)
+
+// defaultQASampleRate is the share of completed conversations sampled for
+// reviewers to score. Operators can change it with the qa_sample_rate
+// setting, e.g. "0.1."
+const defaultQASampleRate = 0.05
+
+// qaIdleGap is how long a user must be quiet for their conversation to be
+// complete. A message after a longer gap starts a new conversation.
+const qaIdleGap = 30 * time.Minute
+
+// qaInterval is how often completed conversations are sampled.
+const qaInterval = 15 * time.Minute
+
+// maxQAMsgs is the most messages of a conversation scored, bounding how much
+// history each sample loads. Longer conversations are scored by their end.
+const maxQAMsgs = 100
+
+// qaWindow is the period QA analytics summarize.
+const qaWindow = 30 * 24 * time.Hour
+
+// Scores reviewers give conversations, from poor to excellent.
+const (
+	MinQAScore = 1
+	MaxQAScore = 5
+)
+
+// ErrReviewNotFound is returned when scoring a sampled conversation that
+// doesn't exist or has already been scored.
+var ErrReviewNotFound = errors.New("qa review not found")
+
+// ErrInvalidScore is returned when scoring a conversation outside of
+// MinQAScore and MaxQAScore.
+var ErrInvalidScore = errors.New("invalid qa score")
+
+// QAReview is a completed conversation sampled for reviewers to score, with
+// quality signals computed automatically to guide them. Score is nil until a
+// reviewer has scored it.
+type QAReview struct {
+	ID             uint64
+	UserID         uint64
+	FirstMessageID uint64
+	LastMessageID  uint64
+
+	// Turns is how many messages the user sent, i.e. the turns to
+	// completion when the conversation was resolved.
+	Turns int
+
+	// Fallbacks is how many of the user's messages Abot didn't
+	// understand.
+	Fallbacks int
+
+	// Resolved is true when Abot understood the user's last message and
+	// answered it without handing off to a person.
+	Resolved  bool
+	HandedOff bool
+
+	// SentimentStart and SentimentEnd are the sentiment of the user's
+	// first and last messages, between -1 and 1, so their difference is
+	// whether the conversation left the user happier or more frustrated.
+	SentimentStart float64
+	SentimentEnd   float64
+
+	StartedAt  time.Time
+	EndedAt    time.Time
+	Score      *int
+	Notes      string
+	ReviewedBy string
+	ReviewedAt *time.Time
+
+	// Messages are the conversation, oldest first. They're only set by
+	// GetQAReview.
+	Messages []TranscriptMsg
+
+	CreatedAt time.Time
+}
+
+// QASummary summarizes the conversations sampled over a period, comparing
+// the automatic signals with reviewers' scores.
+type QASummary struct {
+	Sampled  int
+	Reviewed int
+
+	// AvgScore is the average of reviewers' scores, or 0 if none have
+	// been scored.
+	AvgScore float64
+
+	ResolutionRate float64
+	HandoffRate    float64
+	AvgTurns       float64
+
+	// AvgSentimentChange is the average of each conversation's
+	// SentimentEnd less its SentimentStart.
+	AvgSentimentChange float64
+
+	// AvgScoreResolved and AvgScoreUnresolved are reviewers' average
+	// scores of resolved and unresolved conversations, which should
+	// differ if Resolved is a useful signal.
+	AvgScoreResolved   float64
+	AvgScoreUnresolved float64
+}
+
+const qaReviewColumns = `id, userid, firstmessageid, lastmessageid, turns,
+	fallbacks, resolved, handedoff, sentimentstart, sentimentend, startedat,
+	endedat, score, notes, reviewedby, reviewedat, createdat`
+
+func init() {
+	RegisterJob("qa_sampling", qaInterval, sampleConversations)
+	RegisterMetric("qa_quality", func() (interface{}, error) {
+		return QAQuality(time.Now().Add(-qaWindow))
+	})
+}
+
+// sampleConversations samples the conversations completed since the last run
+// for reviewers to score. Conversations whose users have been quiet for
+// qaIdleGap are complete. The window overlaps the last run's, so one delayed
+// by a slow job isn't missed, and conversations are never sampled twice.
+func sampleConversations() error {
+	to := time.Now().Add(-qaIdleGap)
+	from := to.Add(-2 * qaInterval)
+	var ends []struct {
+		UserID        uint64
+		LastMessageID uint64
+	}
+	q := `SELECT m.userid, MAX(m.id) AS lastmessageid
+	      FROM messages AS m
+	      WHERE m.userid>0 AND m.createdat>=$1 AND m.createdat<$2
+	          AND NOT EXISTS (
+	              SELECT 1 FROM messages
+	              WHERE userid=m.userid AND createdat>=$2)
+	      GROUP BY m.userid`
+	if err := db.Select(&ends, q, from, to); err != nil {
+		return err
+	}
+	rate := settingFloat("qa_sample_rate", defaultQASampleRate)
+	for _, e := range ends {
+		if rand.Float64() >= rate {
+			continue
+		}
+		if err := sampleConversation(e.UserID, e.LastMessageID); err != nil {
+			log.Info("failed to sample conversation of user", e.UserID,
+				err)
+		}
+	}
+	return nil
+}
+
+// sampleConversation scores the conversation ending with a message and saves
+// it for review.
+func sampleConversation(uid, lastID uint64) error {
+	msgs, err := conversationEndingAt(uid, lastID)
+	if err != nil || len(msgs) == 0 {
+		return err
+	}
+	r := scoreConversation(msgs)
+	r.UserID = uid
+	q := `SELECT EXISTS(SELECT 1 FROM conversationlabels
+	      WHERE userid=$1 AND label=$2 AND createdat>=$3 AND createdat<=$4)`
+	var labeled bool
+	err = db.Get(&labeled, q, uid, handoffLabel, r.StartedAt, r.EndedAt)
+	if err != nil {
+		return err
+	}
+	if labeled {
+		r.HandedOff, r.Resolved = true, false
+	}
+	q = `INSERT INTO qareviews
+	     (tenant, userid, firstmessageid, lastmessageid, turns, fallbacks,
+	      resolved, handedoff, sentimentstart, sentimentend, startedat,
+	      endedat)
+	     VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	     ON CONFLICT (userid, lastmessageid) DO NOTHING`
+	_, err = db.Exec(q, tenant(), uid, r.FirstMessageID, r.LastMessageID,
+		r.Turns, r.Fallbacks, r.Resolved, r.HandedOff, r.SentimentStart,
+		r.SentimentEnd, r.StartedAt, r.EndedAt)
+	return err
+}
+
+// conversationEndingAt returns the messages of a user's conversation ending
+// with a message, oldest first. The conversation begins after the last gap of
+// qaIdleGap between messages.
+func conversationEndingAt(uid, lastID uint64) ([]TranscriptMsg, error) {
+	q := `SELECT id, COALESCE(sentence, '') AS sentence, abotsent,
+	          COALESCE(commands, '{}') AS commands,
+	          COALESCE(objects, '{}') AS objects,
+	          COALESCE(plugin, '') AS plugin, COALESCE(route, '') AS route,
+	          COALESCE(needstraining, FALSE) AS needstraining, createdat
+	      FROM messages
+	      WHERE userid=$1 AND id<=$2
+	      ORDER BY id DESC
+	      LIMIT $3`
+	var msgs []TranscriptMsg
+	if err := db.Select(&msgs, q, uid, lastID, maxQAMsgs); err != nil {
+		return nil, err
+	}
+	var conv []TranscriptMsg
+	for i, m := range msgs {
+		if i > 0 && msgs[i-1].CreatedAt.Sub(m.CreatedAt) > qaIdleGap {
+			break
+		}
+		m.Sentence = openSentence(m.Sentence)
+		conv = append([]TranscriptMsg{m}, conv...)
+	}
+	return conv, nil
+}
+
+// scoreConversation computes the quality signals of a conversation's
+// messages, oldest first, leaving out conversation labels, which are checked
+// separately.
+func scoreConversation(msgs []TranscriptMsg) *QAReview {
+	first, last := msgs[0], msgs[len(msgs)-1]
+	r := &QAReview{
+		FirstMessageID: first.ID,
+		LastMessageID:  last.ID,
+		StartedAt:      first.CreatedAt,
+		EndedAt:        last.CreatedAt,
+	}
+	var lastUser *TranscriptMsg
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Plugin == humanPlugin {
+			r.HandedOff = true
+		}
+		if m.AbotSent {
+			continue
+		}
+		s := Sentiment(m.Sentence)
+		if r.Turns == 0 {
+			r.SentimentStart = s
+		}
+		r.SentimentEnd = s
+		r.Turns++
+		if m.NeedsTraining {
+			r.Fallbacks++
+		}
+		lastUser = m
+	}
+	r.Resolved = !r.HandedOff && lastUser != nil &&
+		!lastUser.NeedsTraining && last.AbotSent
+	return r
+}
+
+// PendingQAReviews returns sampled conversations awaiting a reviewer's score,
+// oldest first.
+func PendingQAReviews(limit int) ([]QAReview, error) {
+	q := `SELECT ` + qaReviewColumns + `
+	      FROM qareviews
+	      WHERE tenant=$1 AND reviewedat IS NULL
+	      ORDER BY id
+	      LIMIT $2`
+	var rs []QAReview
+	if err := db.Select(&rs, q, tenant(), limit); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// GetQAReview returns a sampled conversation with its messages.
+func GetQAReview(id uint64) (*QAReview, error) {
+	r := &QAReview{}
+	q := `SELECT ` + qaReviewColumns + `
+	      FROM qareviews
+	      WHERE id=$1 AND tenant=$2`
+	err := db.Get(r, q, id, tenant())
+	if err == sql.ErrNoRows {
+		return nil, ErrReviewNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	q = `SELECT id, COALESCE(sentence, '') AS sentence, abotsent,
+	         COALESCE(commands, '{}') AS commands,
+	         COALESCE(objects, '{}') AS objects,
+	         COALESCE(plugin, '') AS plugin, COALESCE(route, '') AS route,
+	         COALESCE(needstraining, FALSE) AS needstraining, createdat
+	     FROM messages
+	     WHERE userid=$1 AND id>=$2 AND id<=$3
+	     ORDER BY id`
+	err = db.Select(&r.Messages, q, r.UserID, r.FirstMessageID,
+		r.LastMessageID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range r.Messages {
+		r.Messages[i].Sentence = openSentence(r.Messages[i].Sentence)
+	}
+	return r, nil
+}
+
+// ScoreQAReview records a reviewer's score of a sampled conversation, from
+// MinQAScore to MaxQAScore, with any notes on what went well or wrong.
+func ScoreQAReview(id uint64, score int, notes, reviewedBy string) error {
+	if score < MinQAScore || score > MaxQAScore {
+		return ErrInvalidScore
+	}
+	q := `UPDATE qareviews
+	      SET score=$1, notes=$2, reviewedby=$3, reviewedat=CURRENT_TIMESTAMP
+	      WHERE id=$4 AND tenant=$5 AND reviewedat IS NULL`
+	res, err := db.Exec(q, score, notes, reviewedBy, id, tenant())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrReviewNotFound
+	}
+	return nil
+}
+
+// QAQuality summarizes the conversations sampled since a time.
+func QAQuality(since time.Time) (*QASummary, error) {
+	q := `SELECT COUNT(*) AS sampled,
+	          COUNT(score) AS reviewed,
+	          COALESCE(AVG(score), 0) AS avgscore,
+	          COALESCE(AVG(resolved::int), 0) AS resolutionrate,
+	          COALESCE(AVG(handedoff::int), 0) AS handoffrate,
+	          COALESCE(AVG(turns), 0) AS avgturns,
+	          COALESCE(AVG(sentimentend-sentimentstart), 0)
+	              AS avgsentimentchange,
+	          COALESCE(AVG(score) FILTER (WHERE resolved), 0)
+	              AS avgscoreresolved,
+	          COALESCE(AVG(score) FILTER (WHERE NOT resolved), 0)
+	              AS avgscoreunresolved
+	      FROM qareviews
+	      WHERE tenant=$1 AND createdat>=$2`
+	s := &QASummary{}
+	if err := db.Get(s, q, tenant(), since); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// HAPIQAReviews responds with sampled conversations awaiting a score along
+// with a summary of the past 30 days.
+func HAPIQAReviews(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	_, limit, err := pageParams(r)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	rs, err := PendingQAReviews(limit)
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	s, err := QAQuality(time.Now().Add(-qaWindow))
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct {
+		Reviews []QAReview
+		Summary *QASummary
+	}{Reviews: rs, Summary: s})
+}
+
+// HAPIQAReview responds with a sampled conversation, given by the id query
+// parameter, and its messages.
+func HAPIQAReview(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	rev, err := GetQAReview(id)
+	if err == ErrReviewNotFound {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, rev)
+}
+
+// HAPIQAReviewsSubmit records a reviewer's score of a sampled conversation.
+func HAPIQAReviewsSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct {
+		ID    uint64
+		Score int
+		Notes string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := ScoreQAReview(req.ID, req.Score, req.Notes, operatorName(r))
+	if err == ErrReviewNotFound || err == ErrInvalidScore {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/itsabot/abot/shared/helpers/redact"
+)
+
+// RedactionFields returns the kinds of personal information masked in each
+// type of field written to logs, analytics events and exports, e.g. card
+// numbers in log lines. See redact.String.
+func RedactionFields() map[string][]string {
+	return redact.Fields()
+}
+
+// SetRedactionFields validates and saves the kinds of personal information
+// masked in types of fields, taking effect immediately. Field types left out
+// use their defaults.
+func SetRedactionFields(fs map[string][]string) error {
+	byt, err := json.Marshal(fs)
+	if err != nil {
+		return err
+	}
+	if err = redact.SetFields(fs); err != nil {
+		return err
+	}
+	return SetSetting("redaction_fields", string(byt))
+}
+
+// loadRedactionFields applies the redaction_fields setting saved by
+// SetRedactionFields.
+func loadRedactionFields() error {
+	s := Setting("redaction_fields")
+	if len(s) == 0 {
+		return nil
+	}
+	var fs map[string][]string
+	if err := json.Unmarshal([]byte(s), &fs); err != nil {
+		return err
+	}
+	return redact.SetFields(fs)
+}
+
+// HAPIRedaction responds with the kinds of personal information masked in
+// each type of field, along with every kind that can be masked.
+func HAPIRedaction(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	writeBytes(w, struct {
+		Fields map[string][]string
+		Kinds  []string
+	}{Fields: RedactionFields(), Kinds: redact.Kinds()})
+}
+
+// HAPIRedactionSubmit changes the kinds of personal information masked in
+// types of fields.
+func HAPIRedactionSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct{ Fields map[string][]string }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err := SetRedactionFields(req.Fields); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
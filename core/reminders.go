@@ -0,0 +1,127 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// defaultExpiryReminder is how long before a conversation expires that the
+// user is reminded of it. Operators can change it for each plugin with the
+// plugin_{name}_expiry_reminder_minutes setting, or set it to 0 to send no
+// reminders.
+const defaultExpiryReminder = 10 * time.Minute
+
+// expiringConversation is a plugin's conversation with a user whose
+// variables will soon expire.
+type expiringConversation struct {
+	UserID     uint64
+	PluginName string
+	ExpiresAt  time.Time
+}
+
+func init() {
+	RegisterJob("expiry_reminders", time.Minute, remindExpiring)
+}
+
+func expiryReminder(pluginName string) time.Duration {
+	s := Setting("plugin_" + pluginName + "_expiry_reminder_minutes")
+	if len(s) == 0 {
+		return defaultExpiryReminder
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		log.Info("invalid expiry reminder", pluginName, s)
+		return defaultExpiryReminder
+	}
+	return time.Duration(n) * time.Minute
+}
+
+// remindExpiring reminds users of conversations about to expire, so they
+// don't return to find Abot has forgotten everything. Each conversation is
+// reminded of once until the user picks it back up. Users in their quiet
+// hours aren't reminded, since the conversation would expire before the
+// reminder could be sent. Users who have paused Abot aren't reminded either,
+// nor are users who haven't signed up, whose conversations can't be told
+// apart.
+func remindExpiring() error {
+	now := time.Now()
+	q := `SELECT userid, pluginname, MIN(expiresat) AS expiresat
+	      FROM conversationvars
+	      WHERE remindedat IS NULL AND expiresat>$1 AND userid>0
+	        AND userid NOT IN (
+	            SELECT id FROM users WHERE pausedat IS NOT NULL)
+	      GROUP BY userid, pluginname`
+	var cs []expiringConversation
+	if err := db.Select(&cs, q, now); err != nil {
+		return err
+	}
+	for _, c := range cs {
+		lead := expiryReminder(c.PluginName)
+		if lead == 0 || c.ExpiresAt.Sub(now) > lead {
+			continue
+		}
+		if err := remind(c, now); err != nil {
+			log.Info("failed to send expiry reminder", c.PluginName, err)
+		}
+	}
+	return nil
+}
+
+func remind(c expiringConversation, now time.Time) error {
+	u := &dt.User{ID: c.UserID}
+	fid, fidT, err := u.LastFlexID(db)
+	if err != nil {
+		return err
+	}
+	evt := &dt.ScheduledEvent{
+		Content:    reminderContent(c, now),
+		FlexID:     fid,
+		FlexIDType: fidT,
+		Priority:   dt.PriorityNormal,
+		Category:   "conversation_expiry",
+	}
+	quiet, err := evt.InQuietHours(db, now)
+	if err != nil {
+		return err
+	}
+	if !quiet {
+		q := `INSERT INTO scheduledevents
+		      (content, flexid, flexidtype, sendat, category, priority)
+		      VALUES ($1, $2, $3, $4, $5, $6)`
+		_, err = db.Exec(q, evt.Content, evt.FlexID, evt.FlexIDType, now,
+			evt.Category, evt.Priority)
+		if err != nil {
+			return err
+		}
+	}
+	q := `UPDATE conversationvars SET remindedat=$1
+	      WHERE userid=$2 AND pluginname=$3`
+	_, err = db.Exec(q, now, c.UserID, c.PluginName)
+	return err
+}
+
+// reminderContent returns the reminder the plugin set with
+// StateMachine.SetReminder, or a generic one if it hasn't.
+func reminderContent(c expiringConversation, now time.Time) string {
+	q := `SELECT value FROM conversationvars
+	      WHERE userid=$1 AND pluginname=$2 AND key=$3`
+	var buf []byte
+	err := db.Get(&buf, q, c.UserID, c.PluginName, dt.ReminderVar)
+	if err == nil {
+		var s string
+		if err = json.Unmarshal(buf, &s); err == nil && len(s) > 0 {
+			return s
+		}
+	}
+	mins := int(c.ExpiresAt.Sub(now).Minutes())
+	if mins < 1 {
+		mins = 1
+	}
+	return fmt.Sprintf("Just checking in, since we were in the middle of something with %s. Reply in the next %d minutes to pick up where we left off.",
+		c.PluginName, mins)
+}
@@ -0,0 +1,233 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/sms"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// ErrUnknownChannel is returned when configuring a channel Abot doesn't
+// support.
+var ErrUnknownChannel = errors.New("unknown channel")
+
+// ErrRecipientPaused is returned when sending a message to a user who has
+// paused Abot, e.g. by replying "stop."
+var ErrRecipientPaused = errors.New("recipient paused messages")
+
+var (
+	regexMdLink    = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	regexMdBold    = regexp.MustCompile(`(\*\*|__)(.+?)(\*\*|__)`)
+	regexMdItalic  = regexp.MustCompile(`(^|[\s(])[*_]([^*_\s][^*_]*?)[*_]([\s.,!?:;)]|$)`)
+	regexMdCode    = regexp.MustCompile("`([^`]+)`")
+	regexMdHeading = regexp.MustCompile(`(?m)^#{1,6}\s+`)
+	regexSpaces    = regexp.MustCompile(`[ \t]{2,}`)
+	regexLineEnds  = regexp.MustCompile(`[ \t]+\n`)
+)
+
+// ChannelFor returns the capabilities of a channel, including any overrides
+// operators have saved. Unknown channels are treated as the web.
+func ChannelFor(name string) dt.Channel {
+	ch, ok := dt.Channels[name]
+	if !ok {
+		ch = dt.Channels[dt.ChannelWeb]
+	}
+	if s := Setting("channel_" + ch.Name); len(s) > 0 {
+		override := ch
+		if err := json.Unmarshal([]byte(s), &override); err != nil {
+			log.Info("invalid channel setting", ch.Name, err)
+		} else {
+			ch = override
+		}
+	}
+	return ch
+}
+
+// SetChannel saves operators' overrides of a channel's capabilities.
+func SetChannel(ch dt.Channel) error {
+	if _, ok := dt.Channels[ch.Name]; !ok {
+		return fmt.Errorf("%s: %q", ErrUnknownChannel, ch.Name)
+	}
+	if ch.MaxLength < 0 || ch.MaxSegments < 0 || ch.MaxButtons < 0 {
+		return errors.New("limits must not be negative")
+	}
+	byt, err := json.Marshal(ch)
+	if err != nil {
+		return err
+	}
+	return SetSetting("channel_"+ch.Name, string(byt))
+}
+
+// RenderFor renders a response for the channel a user messages Abot through.
+func RenderFor(u *dt.User, resp *dt.Response) string {
+	name := dt.ChannelWeb
+	if u != nil {
+		name = dt.ChannelName(u.FlexIDType)
+	}
+	return Render(resp, ChannelFor(name))
+}
+
+// Render formats a response for a channel, stripping Markdown and emoji the
+// channel can't display, listing its buttons by number and cutting it to the
+// channel's length and number of SMS segments.
+func Render(resp *dt.Response, ch dt.Channel) string {
+	s := resp.Text
+	if !ch.Markdown {
+		s = stripMarkdown(s)
+	}
+	if !ch.Emoji {
+		s = stripEmoji(s)
+	}
+	btns := resp.Buttons
+	if ch.MaxButtons > 0 && len(btns) > ch.MaxButtons {
+		btns = btns[:ch.MaxButtons]
+	}
+	var suffix string
+	for i, b := range btns {
+		suffix += fmt.Sprintf(" (%d) %s", i+1, b)
+	}
+	if ch.MaxLength > 0 {
+		s = truncate(s, ch.MaxLength-utf8.RuneCountInString(suffix))
+	}
+	if ch.MaxSegments > 0 && sms.Segments(s+suffix) > ch.MaxSegments {
+		s = truncateSegments(s, suffix, ch.MaxSegments)
+	}
+	return s + suffix
+}
+
+func stripMarkdown(s string) string {
+	s = regexMdLink.ReplaceAllString(s, "$1 ($2)")
+	s = regexMdBold.ReplaceAllString(s, "$2")
+	s = regexMdItalic.ReplaceAllString(s, "$1$2$3")
+	s = regexMdCode.ReplaceAllString(s, "$1")
+	return regexMdHeading.ReplaceAllString(s, "")
+}
+
+func stripEmoji(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if nlp.IsEmoji(r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = regexLineEnds.ReplaceAllString(s, "\n")
+	return strings.TrimSpace(regexSpaces.ReplaceAllString(s, " "))
+}
+
+// truncate cuts s to at most n characters at the last word that fits, marking
+// the cut with an ellipsis.
+func truncate(s string, n int) string {
+	rs := []rune(s)
+	if len(rs) <= n {
+		return s
+	}
+	const ellipsis = "..."
+	if n <= len(ellipsis) {
+		// The buttons alone fill the message.
+		if n < 0 {
+			n = 0
+		}
+		return string(rs[:n])
+	}
+	cut := string(rs[:n-len(ellipsis)])
+	if i := strings.LastIndexAny(cut, " \n"); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " \n.,;:") + ellipsis
+}
+
+// truncateSegments cuts s at the last word that fits in n SMS segments
+// followed by suffix.
+func truncateSegments(s, suffix string, n int) string {
+	// Removing characters never lengthens a message, so the most that
+	// fit can be found by a binary search.
+	lo, hi := 0, utf8.RuneCountInString(s)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if sms.Segments(truncate(s, mid)+suffix) <= n {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return truncate(s, lo)
+}
+
+// sendRendered sends a scheduled event rendered for its recipient's channel.
+// Nothing is sent to users who have paused Abot or to FlexIDs that opted out,
+// as carriers require of SMS services once someone replies "stop."
+func sendRendered(evt dt.ScheduledEvent) error {
+	ch := ChannelFor(dt.ChannelName(evt.FlexIDType))
+	evt.Content = Render(&dt.Response{Text: evt.Content}, ch)
+	return sendEvent(evt)
+}
+
+// sendEvent sends a scheduled event whose content is already rendered for its
+// recipient's channel, unless they've paused Abot or its FlexID opted out.
+func sendEvent(evt dt.ScheduledEvent) error {
+	paused, err := evt.RecipientPaused(db)
+	if err != nil {
+		return err
+	}
+	if paused {
+		return ErrRecipientPaused
+	}
+	ch := ChannelFor(dt.ChannelName(evt.FlexIDType))
+	parts := []string{evt.Content}
+	if ch.SplitSegments && evt.FlexIDType == dt.FlexIDType(2) {
+		parts = sms.Split(evt.Content, ch.MaxSegments)
+	}
+	for _, part := range parts {
+		evt.Content = part
+		if err = evt.Send(smsConn, emailConn); err != nil {
+			outboundFailures.add(ch.Name)
+			return err
+		}
+		recordSendCost(evt)
+	}
+	return nil
+}
+
+// HAPIChannels responds with the capabilities of each channel, including any
+// overrides operators have saved.
+func HAPIChannels(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	var names []string
+	for name := range dt.Channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var chs []dt.Channel
+	for _, name := range names {
+		chs = append(chs, ChannelFor(name))
+	}
+	writeBytes(w, struct{ Channels []dt.Channel }{Channels: chs})
+}
+
+// HAPIChannelsSubmit overrides a channel's capabilities.
+func HAPIChannelsSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var ch dt.Channel
+	if err := json.NewDecoder(r.Body).Decode(&ch); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err := SetChannel(ch); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,326 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// Weights of the ways a message can match an intent, which sum to its score.
+// A message sharing every word of an example scores exampleWeight for it.
+const (
+	commandWeight = 1
+	objectWeight  = 1
+	exampleWeight = 2
+	contextWeight = 0.5
+)
+
+// minExampleSimilarity is how alike a message and one of an intent's examples
+// must be, by the share of their words in common, to trigger the intent
+// without its commands and objects.
+const minExampleSimilarity = 0.5
+
+// ErrInvalidIntent is returned when registering an intent without a name, or
+// with neither both commands and objects nor examples to match messages by.
+var ErrInvalidIntent = errors.New("invalid intent")
+
+// RouteCandidate is an intent considered for a message, with its score and
+// the reasons for it, e.g. `command "book"` and `55% like "get us a table."`
+type RouteCandidate struct {
+	Route    string
+	Plugin   string
+	Score    float64
+	Priority int
+
+	// Recent is true when the intent's plugin handled the conversation in
+	// progress, which breaks ties between equal scores and priorities.
+	Recent  bool
+	Reasons []string
+
+	// position orders intents matched by their commands and objects as
+	// they were written, e.g. "find" before "book" in "find a restaurant
+	// and book a table," which breaks any remaining ties.
+	position int
+}
+
+// RouteDecision is how a message was routed: the route chosen, if any, why,
+// and every candidate considered, best first.
+type RouteDecision struct {
+	Route      string
+	Plugin     string
+	Reason     string
+	Candidates []RouteCandidate
+}
+
+// intentEntry is a registered intent with its plugin and the stems of its
+// examples.
+type intentEntry struct {
+	dt.Intent
+	plugin   *dt.Plugin
+	examples [][]string
+}
+
+var intentRegistry = map[string]*intentEntry{}
+var intentsMu sync.RWMutex
+
+// RegisterIntents declares the intents a plugin handles, so messages are
+// routed to it when one of them scores best. An intent registered under a
+// name already taken replaces the earlier one. Triggers passed to plugin.New
+// are registered as an intent for each command and object pair, named like
+// "find_restaurant."
+func RegisterIntents(p *dt.Plugin, intents ...dt.Intent) error {
+	for _, in := range intents {
+		in.Name = strings.ToLower(strings.TrimSpace(in.Name))
+		hasPair := len(in.Commands) > 0 && len(in.Objects) > 0
+		if len(in.Name) == 0 || (!hasPair && len(in.Examples) == 0) {
+			return fmt.Errorf("%s: %q", ErrInvalidIntent, in.Name)
+		}
+		e := &intentEntry{Intent: in, plugin: p}
+		e.Commands = lowerAll(in.Commands)
+		e.Objects = lowerAll(in.Objects)
+		for _, ex := range in.Examples {
+			tokens := RemoveStopwords(nlp.TokenizeSentence(ex), parserLang)
+			e.examples = append(e.examples, nlp.StemTokens(tokens))
+		}
+		intentsMu.Lock()
+		if prev, ok := intentRegistry[in.Name]; ok && prev.plugin != p {
+			log.Info("found duplicate plugin or trigger", p.Config.Name,
+				"on", in.Name)
+		}
+		intentRegistry[in.Name] = e
+		intentsMu.Unlock()
+		RegPlugins.Set(in.Name, p)
+	}
+	return nil
+}
+
+// Intents returns the intents plugins have registered, by name.
+func Intents() map[string]dt.Intent {
+	intentsMu.RLock()
+	defer intentsMu.RUnlock()
+	is := make(map[string]dt.Intent, len(intentRegistry))
+	for name, e := range intentRegistry {
+		is[name] = e.Intent
+	}
+	return is
+}
+
+// routeMsg scores every enabled plugin's intents against a message's commands,
+// objects and tokens, choosing the best. prevRoute is the route of the
+// conversation in progress, if any, whose plugin wins ties. Questions no
+// intent matches go to the question plugin, if one has been registered.
+func routeMsg(si *nlp.StructuredInput, tokens []string,
+	prevRoute string) *RouteDecision {
+
+	var prevPlugin string
+	if len(prevRoute) > 0 {
+		if p := RegPlugins.Get(prevRoute); p != nil {
+			prevPlugin = p.Config.Name
+		}
+	}
+	stems := nlp.StemTokens(tokens)
+	var cs []RouteCandidate
+	intentsMu.RLock()
+	for _, e := range intentRegistry {
+		if !PluginEnabled(e.plugin.Config.Name) {
+			continue
+		}
+		if c, ok := e.score(si, stems, prevRoute, prevPlugin); ok {
+			cs = append(cs, c)
+		}
+	}
+	intentsMu.RUnlock()
+	sort.Sort(candidatesByRank(cs))
+	d := &RouteDecision{Candidates: cs}
+	if len(cs) > 0 {
+		d.Route, d.Plugin = cs[0].Route, cs[0].Plugin
+		d.Reason = decisionReason(cs)
+		return d
+	}
+	if nlp.IsQuestion(tokens) {
+		if p := enabledPlugin(RouteQuestion); p != nil {
+			d.Route, d.Plugin = RouteQuestion, p.Config.Name
+			d.Reason = fmt.Sprintf("no enabled plugin's intents match, but the message is a question, which %s answers",
+				p.Config.Name)
+			return d
+		}
+	}
+	d.Reason = "no enabled plugin's intents match"
+	return d
+}
+
+// score scores an intent against a message, reporting whether the message
+// triggers it: with both a command and an object of the intent, or by being
+// enough like one of its examples. Negated commands, as in "don't order the
+// pizza," never count, since the plugin would do the opposite of what the
+// user asked.
+func (e *intentEntry) score(si *nlp.StructuredInput, stems []string,
+	prevRoute, prevPlugin string) (RouteCandidate, bool) {
+
+	c := RouteCandidate{
+		Route:    e.Name,
+		Plugin:   e.plugin.Config.Name,
+		Priority: e.Priority,
+		Recent:   len(prevPlugin) > 0 && e.plugin.Config.Name == prevPlugin,
+		position: math.MaxInt32,
+	}
+	cmdAt, objAt := -1, -1
+	for i, w := range si.Commands {
+		if !si.Negated(w) && containsString(e.Commands, strings.ToLower(w)) {
+			cmdAt = i
+			c.Score += commandWeight
+			c.Reasons = append(c.Reasons, fmt.Sprintf("command %q", w))
+			break
+		}
+	}
+	for i, w := range si.Objects {
+		if matchObject(e.Objects, strings.ToLower(w)) {
+			objAt = i
+			c.Score += objectWeight
+			c.Reasons = append(c.Reasons, fmt.Sprintf("object %q", w))
+			break
+		}
+	}
+	var best float64
+	var example string
+	for i, ex := range e.examples {
+		if s := stemSimilarity(stems, ex); s > best {
+			best, example = s, e.Examples[i]
+		}
+	}
+	if best > 0 {
+		c.Score += exampleWeight * best
+		c.Reasons = append(c.Reasons, fmt.Sprintf("%.0f%% like %q",
+			best*100, example))
+	}
+	for _, ctx := range e.Contexts {
+		if len(ctx) > 0 && (ctx == prevRoute || ctx == prevPlugin) {
+			c.Score += contextWeight
+			c.Reasons = append(c.Reasons, fmt.Sprintf("continues %q",
+				ctx))
+			break
+		}
+	}
+	paired := cmdAt >= 0 && objAt >= 0
+	if paired {
+		c.position = cmdAt*(len(si.Objects)+1) + objAt
+	}
+	return c, paired || best >= minExampleSimilarity
+}
+
+// decisionReason says why the first of ranked candidates was chosen, and what
+// broke any tie with the second.
+func decisionReason(cs []RouteCandidate) string {
+	first := cs[0]
+	s := fmt.Sprintf("intent %s of %s scored %.2f: %s", first.Route,
+		first.Plugin, first.Score, strings.Join(first.Reasons, ", "))
+	if len(cs) == 1 || cs[1].Score != first.Score {
+		return s
+	}
+	second := cs[1]
+	switch {
+	case first.Priority != second.Priority:
+		return s + fmt.Sprintf(", tied with %s and chosen by its higher priority",
+			second.Route)
+	case first.Recent && !second.Recent:
+		return s + fmt.Sprintf(", tied with %s and chosen since %s handled the conversation in progress",
+			second.Route, first.Plugin)
+	}
+	return s + fmt.Sprintf(", tied with %s and chosen by the order of the message's words",
+		second.Route)
+}
+
+// matchObject reports whether an object matches any of an intent's object
+// patterns.
+func matchObject(patterns []string, obj string) bool {
+	for _, p := range patterns {
+		switch {
+		case p == "*", p == obj:
+			return true
+		case strings.HasSuffix(p, "*") &&
+			strings.HasPrefix(obj, strings.TrimSuffix(p, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+// stemSimilarity returns the share of the stems in either of two sentences
+// that are in both.
+func stemSimilarity(a, b []string) float64 {
+	inA, inB := map[string]bool{}, map[string]bool{}
+	for _, s := range a {
+		inA[s] = true
+	}
+	for _, s := range b {
+		inB[s] = true
+	}
+	var both int
+	for s := range inB {
+		if inA[s] {
+			both++
+		}
+	}
+	union := len(inA) + len(inB) - both
+	if union == 0 {
+		return 0
+	}
+	return float64(both) / float64(union)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func lowerAll(ss []string) []string {
+	lower := make([]string, len(ss))
+	for i, s := range ss {
+		lower[i] = strings.ToLower(strings.TrimSpace(s))
+	}
+	return lower
+}
+
+// candidatesByRank orders candidates by score, then priority, then whether
+// their plugin handled the conversation in progress, then the order of the
+// message's words.
+type candidatesByRank []RouteCandidate
+
+func (c candidatesByRank) Len() int      { return len(c) }
+func (c candidatesByRank) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c candidatesByRank) Less(i, j int) bool {
+	a, b := c[i], c[j]
+	switch {
+	case a.Score != b.Score:
+		return a.Score > b.Score
+	case a.Priority != b.Priority:
+		return a.Priority > b.Priority
+	case a.Recent != b.Recent:
+		return a.Recent
+	case a.position != b.position:
+		return a.position < b.position
+	}
+	return a.Route < b.Route
+}
+
+// HAPIIntents responds with the intents plugins have registered, by name.
+func HAPIIntents(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	writeBytes(w, struct{ Intents map[string]dt.Intent }{
+		Intents: Intents(),
+	})
+}
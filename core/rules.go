@@ -0,0 +1,307 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// Conditions that trigger escalation rules.
+const (
+	// ConditionSentiment holds when the user's sentiment is below the
+	// rule's threshold. See Sentiment.
+	ConditionSentiment = "sentiment"
+
+	// ConditionFallback holds when Abot didn't understand the user.
+	ConditionFallback = "fallback"
+
+	// ConditionProfanity holds when the user used offensive language.
+	ConditionProfanity = "profanity"
+)
+
+// Actions taken when escalation rules are triggered.
+const (
+	// ActionHandoff escalates the conversation to operators, who take
+	// over until they release it, and tells the user a person will
+	// follow up. Outside business hours, the request is queued as a
+	// callback. See Escalate and BusinessHours.
+	ActionHandoff = "handoff"
+
+	// ActionApologize begins Abot's response with the rule's template.
+	ActionApologize = "apologize"
+
+	// ActionAlert emails ADMIN_EMAIL, usually a supervisor.
+	ActionAlert = "alert"
+)
+
+// handoffLabel marks conversations handed off to a person.
+const handoffLabel = "human_handoff"
+
+// maxRuleTurns limits how many turns a condition may need to hold, bounding
+// how much history each message loads.
+const maxRuleTurns = 10
+
+// ErrInvalidRule is returned when saving an escalation rule that's missing a
+// name, condition or action, or that uses an unknown one.
+var ErrInvalidRule = errors.New("invalid escalation rule")
+
+// EscalationRule takes actions when a condition holds for a number of the
+// user's turns in a row, e.g. alerting a supervisor when the user's sentiment
+// is below -0.5 for 2 turns. A triggered rule labels the conversation with
+// its name and doesn't trigger again for that user until an operator resolves
+// the label.
+type EscalationRule struct {
+	Name      string
+	Condition string
+
+	// Threshold is the sentiment below which ConditionSentiment holds,
+	// between -1 and 1.
+	Threshold float64
+
+	// Turns is how many of the user's messages in a row the condition
+	// must hold for. It defaults to 1.
+	Turns int
+
+	Actions []string
+
+	// Template is the apology used by ActionApologize, e.g. "I'm sorry
+	// this has been frustrating."
+	Template string
+}
+
+// validate normalizes a rule, checking that it can be evaluated.
+func (rule *EscalationRule) validate() error {
+	rule.Name = dt.NormalizeTag(rule.Name)
+	if len(rule.Name) == 0 || len(rule.Actions) == 0 {
+		return ErrInvalidRule
+	}
+	switch rule.Condition {
+	case ConditionSentiment, ConditionFallback, ConditionProfanity:
+	default:
+		return ErrInvalidRule
+	}
+	if rule.Turns <= 0 {
+		rule.Turns = 1
+	}
+	if rule.Turns > maxRuleTurns {
+		return ErrInvalidRule
+	}
+	for _, a := range rule.Actions {
+		switch a {
+		case ActionHandoff, ActionAlert:
+		case ActionApologize:
+			if len(rule.Template) == 0 {
+				return ErrInvalidRule
+			}
+		default:
+			return ErrInvalidRule
+		}
+	}
+	return nil
+}
+
+// EscalationRules returns the escalation rules operators have defined, saved
+// as JSON in the escalation_rules setting. Invalid rules, e.g. those edited
+// directly through the settings API, are logged and skipped.
+func EscalationRules() ([]EscalationRule, error) {
+	s := Setting("escalation_rules")
+	if len(s) == 0 {
+		return nil, nil
+	}
+	var saved []EscalationRule
+	if err := json.Unmarshal([]byte(s), &saved); err != nil {
+		return nil, err
+	}
+	var rules []EscalationRule
+	for _, rule := range saved {
+		if err := rule.validate(); err != nil {
+			log.Info("skipping invalid escalation rule", rule.Name)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// SetEscalationRules validates and saves escalation rules, replacing any
+// already defined.
+func SetEscalationRules(rules []EscalationRule) error {
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return fmt.Errorf("%s: %q", err, rules[i].Name)
+		}
+	}
+	byt, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	return SetSetting("escalation_rules", string(byt))
+}
+
+// ruleTurn is what rules know about one of the user's messages.
+type ruleTurn struct {
+	Sentence      string
+	NeedsTraining bool
+}
+
+// holds reports whether a rule's condition holds for a turn.
+func (rule *EscalationRule) holds(t ruleTurn) bool {
+	switch rule.Condition {
+	case ConditionSentiment:
+		return Sentiment(t.Sentence) < rule.Threshold
+	case ConditionFallback:
+		return t.NeedsTraining
+	case ConditionProfanity:
+		stems := nlp.StemTokens(nlp.TokenizeSentence(t.Sentence))
+		return len(RespondWithOffense(Offensive(), &dt.Msg{
+			Stems: stems,
+		})) > 0
+	}
+	return false
+}
+
+// applyEscalationRules triggers any rules whose conditions hold for the
+// user's latest turns, returning Abot's response to the message as changed by
+// the rules' actions. Rules that fail are logged, leaving the response as is.
+// Unregistered users' turns can't be told apart, so rules don't apply to them.
+func applyEscalationRules(in *dt.Msg, resp string) string {
+	if in.User == nil || !in.User.Registered() {
+		return resp
+	}
+	rules, err := EscalationRules()
+	if err != nil {
+		log.Info("failed to load escalation rules", err)
+		return resp
+	}
+	if len(rules) == 0 {
+		return resp
+	}
+	var turns []ruleTurn
+	for _, rule := range rules {
+		if rule.Turns > len(turns) {
+			if turns, err = recentTurns(in, rule.Turns); err != nil {
+				log.Info("failed to load turns for rules", err)
+				return resp
+			}
+		}
+		if len(turns) < rule.Turns {
+			continue
+		}
+		held := true
+		for _, t := range turns[:rule.Turns] {
+			held = held && rule.holds(t)
+		}
+		if !held {
+			continue
+		}
+		resp, err = triggerRule(&rule, in, resp)
+		if err != nil {
+			log.Info("failed to trigger escalation rule", rule.Name,
+				err)
+		}
+	}
+	return resp
+}
+
+// recentTurns returns the user's last n messages newest first, starting with
+// the message being processed.
+func recentTurns(in *dt.Msg, n int) ([]ruleTurn, error) {
+	turns := []ruleTurn{{
+		Sentence:      in.Sentence,
+		NeedsTraining: in.NeedsTraining,
+	}}
+	if n == 1 {
+		return turns, nil
+	}
+	var prev []ruleTurn
+	q := `SELECT COALESCE(sentence, '') AS sentence,
+	          COALESCE(needstraining, FALSE) AS needstraining
+	      FROM messages
+	      WHERE userid=$1 AND abotsent IS FALSE AND id<$2
+	      ORDER BY id DESC
+	      LIMIT $3`
+	if err := db.Select(&prev, q, in.User.ID, in.ID, n-1); err != nil {
+		return nil, err
+	}
+	for i := range prev {
+		prev[i].Sentence = openSentence(prev[i].Sentence)
+	}
+	return append(turns, prev...), nil
+}
+
+// triggerRule takes a rule's actions unless it's already been triggered for
+// the user and not yet resolved.
+func triggerRule(rule *EscalationRule, in *dt.Msg, resp string) (string,
+	error) {
+
+	var open bool
+	q := `SELECT EXISTS(SELECT 1 FROM conversationlabels
+	      WHERE userid=$1 AND label=$2 AND resolvedat IS NULL)`
+	if err := db.Get(&open, q, in.User.ID, rule.Name); err != nil {
+		return resp, err
+	}
+	if open {
+		return resp, nil
+	}
+	err := LabelConversation(in.User.ID, in.ID, rule.Name, "escalation_rules")
+	if err != nil {
+		return resp, err
+	}
+	log.Info("triggered escalation rule", rule.Name, "for user", in.User.ID)
+	for _, a := range rule.Actions {
+		switch a {
+		case ActionHandoff:
+			r, err := handOff(in, rule.Name, "escalation_rules")
+			if err != nil {
+				return resp, err
+			}
+			resp = r
+		case ActionApologize:
+			resp = rule.Template + " " + resp
+		case ActionAlert:
+			err = notifyAdmin("Escalation: "+rule.Name, fmt.Sprintf(
+				"User %d triggered escalation rule %q on message %d: %q",
+				in.User.ID, rule.Name, in.ID, in.Sentence))
+			if err != nil {
+				return resp, err
+			}
+		}
+	}
+	return resp, nil
+}
+
+// HAPIEscalationRules responds with the escalation rules operators have
+// defined.
+func HAPIEscalationRules(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	rules, err := EscalationRules()
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Rules []EscalationRule }{Rules: rules})
+}
+
+// HAPIEscalationRulesSubmit replaces the escalation rules.
+func HAPIEscalationRulesSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct{ Rules []EscalationRule }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err := SetEscalationRules(req.Rules); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
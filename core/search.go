@@ -0,0 +1,210 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// maxSearchResults limits how many messages a search returns.
+const maxSearchResults = 200
+
+// MsgQuery describes a search over message history. Text is matched using
+// Postgres full-text search, so "addresses" finds "address," and doesn't match
+// messages saved encrypted. Zero-valued filters are ignored, and results are
+// most recent first.
+type MsgQuery struct {
+	Text       string
+	UserID     uint64
+	FlexIDType dt.FlexIDType
+	Plugin     string
+	After      time.Time
+	Before     time.Time
+
+	// FromUser limits results to messages sent by users rather than by
+	// Abot.
+	FromUser bool
+	Limit    int
+}
+
+// MsgResult is a message found by SearchMsgs.
+type MsgResult struct {
+	ID         uint64
+	UserID     uint64
+	FlexIDType dt.FlexIDType
+	Sentence   string
+	Plugin     string
+	AbotSent   bool
+	CreatedAt  time.Time
+}
+
+// SearchMsgs searches message history.
+func SearchMsgs(mq *MsgQuery) ([]MsgResult, error) {
+	var where []string
+	var args []interface{}
+	arg := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if len(strings.TrimSpace(mq.Text)) > 0 {
+		arg(`to_tsvector('english', COALESCE(sentence, '')) @@
+		     plainto_tsquery('english', $%d)`, mq.Text)
+	}
+	if mq.UserID > 0 {
+		arg("userid=$%d", mq.UserID)
+	}
+	if mq.FlexIDType > 0 {
+		arg("flexidtype=$%d", mq.FlexIDType)
+	}
+	if len(mq.Plugin) > 0 {
+		arg("plugin=$%d", mq.Plugin)
+	}
+	if !mq.After.IsZero() {
+		arg("createdat>=$%d", mq.After)
+	}
+	if !mq.Before.IsZero() {
+		arg("createdat<$%d", mq.Before)
+	}
+	if mq.FromUser {
+		where = append(where, "abotsent IS FALSE")
+	}
+	limit := mq.Limit
+	if limit <= 0 || limit > maxSearchResults {
+		limit = maxSearchResults
+	}
+	q := `SELECT id, COALESCE(userid, 0) AS userid,
+	          COALESCE(flexidtype, 0) AS flexidtype,
+	          COALESCE(sentence, '') AS sentence,
+	          COALESCE(plugin, '') AS plugin, abotsent, createdat
+	      FROM messages`
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY createdat DESC LIMIT " + strconv.Itoa(limit)
+	var res []MsgResult
+	if err := db.Select(&res, q, args...); err != nil {
+		return nil, err
+	}
+	for i := range res {
+		res[i].Sentence = openSentence(res[i].Sentence)
+	}
+	return res, nil
+}
+
+// HAPISearch searches message history for the admin console. Filters are
+// taken from the query parameters q, userid, flexidtype, plugin, after and
+// before, where after and before are RFC 3339 times.
+func HAPISearch(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !Admin(w, r) {
+			return
+		}
+		if !LoggedIn(w, r) {
+			return
+		}
+	}
+	v := r.URL.Query()
+	mq := &MsgQuery{Text: v.Get("q"), Plugin: v.Get("plugin")}
+	var err error
+	if s := v.Get("userid"); len(s) > 0 {
+		if mq.UserID, err = strconv.ParseUint(s, 10, 64); err != nil {
+			writeErrorBadRequest(w, err)
+			return
+		}
+	}
+	if s := v.Get("flexidtype"); len(s) > 0 {
+		var n int
+		if n, err = strconv.Atoi(s); err != nil {
+			writeErrorBadRequest(w, err)
+			return
+		}
+		mq.FlexIDType = dt.FlexIDType(n)
+	}
+	if s := v.Get("limit"); len(s) > 0 {
+		if mq.Limit, err = strconv.Atoi(s); err != nil {
+			writeErrorBadRequest(w, err)
+			return
+		}
+	}
+	if s := v.Get("after"); len(s) > 0 {
+		if mq.After, err = time.Parse(time.RFC3339, s); err != nil {
+			writeErrorBadRequest(w, err)
+			return
+		}
+	}
+	if s := v.Get("before"); len(s) > 0 {
+		if mq.Before, err = time.Parse(time.RFC3339, s); err != nil {
+			writeErrorBadRequest(w, err)
+			return
+		}
+	}
+	res, err := SearchMsgs(mq)
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Messages []MsgResult }{Messages: res})
+}
+
+// regexFindInHistory matches requests to find something in the user's past
+// messages, like "find that address Bob sent me" or "look up the link I
+// sent."
+var regexFindInHistory = regexp.MustCompile(`(?i)^(?:can you |please )?(?:find|search for|look up|pull up|show me) (?:that|the) (.+?) (?:that )?(\w+) (?:sent|gave|texted|emailed|told|mentioned|shared)(?: me)?[?.!\s]*$`)
+
+// historyKinds are the kinds of information users ask to find, which are
+// pulled out of matching messages rather than searched for by name.
+var historyKinds = map[string]*regexp.Regexp{
+	"address":       regexp.MustCompile(`(?i)\d+\s+[\w .]+?\s(?:st|street|ave|avenue|rd|road|blvd|boulevard|dr|drive|ln|lane|way|ct|court|pl|place)\b(?:[\w ,#]*?\b\d{5}(?:-\d{4})?\b)?`),
+	"phone number":  regexp.MustCompile(`\+?\(?\d{3}\)?[\s.-]?\d{3}[\s.-]?\d{4}`),
+	"number":        regexp.MustCompile(`\+?\(?\d{3}\)?[\s.-]?\d{3}[\s.-]?\d{4}`),
+	"email":         regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.-]+`),
+	"email address": regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.-]+`),
+	"link":          regexp.MustCompile(`https?://\S+`),
+	"url":           regexp.MustCompile(`https?://\S+`),
+}
+
+// findInHistory answers requests to find something in the user's past
+// messages. If the user names who sent it, as in "the address Bob sent me,"
+// only messages mentioning them are searched. An empty string is returned if
+// the message isn't such a request.
+func findInHistory(msg *dt.Msg) string {
+	m := regexFindInHistory.FindStringSubmatch(msg.Sentence)
+	if m == nil || !msg.User.Registered() {
+		return ""
+	}
+	what, who := strings.ToLower(m[1]), m[2]
+	mq := &MsgQuery{UserID: msg.User.ID, FromUser: true, Limit: 50}
+	kind, ok := historyKinds[what]
+	if !ok {
+		mq.Text = what
+	}
+	if w := strings.ToLower(who); w != "i" && w != "you" && w != "we" {
+		mq.Text = strings.TrimSpace(mq.Text + " " + who)
+	}
+	res, err := SearchMsgs(mq)
+	if err != nil {
+		log.Info("failed to search messages", err)
+		return "I'm sorry, I couldn't search your messages right now."
+	}
+	for _, r := range res {
+		if regexFindInHistory.MatchString(r.Sentence) {
+			continue
+		}
+		found := r.Sentence
+		if kind != nil {
+			if found = kind.FindString(r.Sentence); len(found) == 0 {
+				continue
+			}
+		}
+		return fmt.Sprintf("I found this from %s: %s",
+			r.CreatedAt.Format("Jan 2"), strings.TrimSpace(found))
+	}
+	return "I couldn't find that in your messages."
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// segment returns the IDs of users matching a rule, such as users who
+// ordered in the past 30 days.
+type segment func() ([]uint64, error)
+
+var segments = map[string]segment{}
+var segmentsMu sync.RWMutex
+
+func init() {
+	RegisterSegment("active_last_30_days", querySegment(
+		`SELECT DISTINCT userid FROM messages
+		 WHERE userid>0 AND abotsent IS FALSE
+		   AND createdat > NOW() - INTERVAL '30 days'`))
+	RegisterSegment("ordered_last_30_days", querySegment(
+		`SELECT DISTINCT userid FROM charges
+		 WHERE refundedamount < amount
+		   AND createdat > NOW() - INTERVAL '30 days'`))
+	RegisterSegment("new_last_7_days", querySegment(
+		`SELECT id FROM users
+		 WHERE createdat > NOW() - INTERVAL '7 days'`))
+	RegisterMetric("tags", tagCounts)
+}
+
+// RegisterSegment makes a rule-based segment of users queryable as a tag,
+// like "ordered_last_30_days." Segments are computed whenever they're
+// queried, so they never go stale, but they can't be applied to users
+// manually. Registering a name twice replaces the earlier segment.
+func RegisterSegment(name string, fn func() ([]uint64, error)) {
+	segmentsMu.Lock()
+	defer segmentsMu.Unlock()
+	segments[dt.NormalizeTag(name)] = fn
+}
+
+// querySegment returns a segment selecting user IDs with a SQL query.
+func querySegment(q string) segment {
+	return func() ([]uint64, error) {
+		var uids []uint64
+		if err := db.Select(&uids, q); err != nil {
+			return nil, err
+		}
+		return uids, nil
+	}
+}
+
+// UsersTagged returns the IDs of users with a tag, whether it was applied
+// manually or is a rule-based segment.
+func UsersTagged(tag string) ([]uint64, error) {
+	tag = dt.NormalizeTag(tag)
+	segmentsMu.RLock()
+	fn, ok := segments[tag]
+	segmentsMu.RUnlock()
+	if ok {
+		return fn()
+	}
+	var uids []uint64
+	q := `SELECT userid FROM usertags WHERE tag=$1 ORDER BY userid`
+	if err := db.Select(&uids, q, tag); err != nil {
+		return nil, err
+	}
+	return uids, nil
+}
+
+// tagCounts counts the users with each tag and in each segment for Abot's
+// analytics. Segments that fail are logged and left out.
+func tagCounts() (interface{}, error) {
+	counts := map[string]int{}
+	var rows []struct {
+		Tag   string
+		Count int
+	}
+	q := `SELECT tag, COUNT(*) AS count FROM usertags GROUP BY tag`
+	if err := db.Select(&rows, q); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.Tag] = row.Count
+	}
+	segmentsMu.RLock()
+	defer segmentsMu.RUnlock()
+	var names []string
+	for name := range segments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		uids, err := segments[name]()
+		if err != nil {
+			log.Info("failed to compute segment", name, err)
+			continue
+		}
+		counts[name] = len(uids)
+	}
+	return counts, nil
+}
+
+// HAPITags responds with the IDs of users with the tag in the "tag" query
+// parameter, or the number of users with each tag if none is given.
+func HAPITags(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !Admin(w, r) {
+			return
+		}
+		if !LoggedIn(w, r) {
+			return
+		}
+	}
+	tag := r.URL.Query().Get("tag")
+	if len(tag) == 0 {
+		counts, err := tagCounts()
+		if err != nil {
+			writeErrorInternal(w, err)
+			return
+		}
+		writeBytes(w, counts)
+		return
+	}
+	uids, err := UsersTagged(tag)
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ UserIDs []uint64 }{UserIDs: uids})
+}
+
+// HAPITagsSubmit applies a tag to a user, or removes it if Remove is true.
+func HAPITagsSubmit(w http.ResponseWriter, r *http.Request) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !Admin(w, r) {
+			return
+		}
+		if !LoggedIn(w, r) {
+			return
+		}
+		if !CSRF(w, r) {
+			return
+		}
+	}
+	var req struct {
+		UserID uint64
+		Tag    string
+		Remove bool
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	tag := dt.NormalizeTag(req.Tag)
+	segmentsMu.RLock()
+	_, isSegment := segments[tag]
+	segmentsMu.RUnlock()
+	if isSegment {
+		writeErrorBadRequest(w, errors.New("segments can't be applied manually"))
+		return
+	}
+	u := &dt.User{ID: req.UserID}
+	var err error
+	if req.Remove {
+		err = u.RemoveTag(db, tag)
+	} else {
+		err = u.AddTag(db, tag)
+	}
+	if err == dt.ErrInvalidTag {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
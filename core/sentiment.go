@@ -0,0 +1,119 @@
+package core
+
+import (
+	"sync"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// SentimentAnalyzer scores how positive a sentence is from -1, very negative,
+// to 1, very positive, e.g. with a machine learning model. Abot scores each
+// message with it, so plugins can apologize to frustrated users or hand them
+// off to a person. See nlp.StructuredInput.Sentiment.
+type SentimentAnalyzer interface {
+	Sentiment(sentence string) (float64, error)
+}
+
+// LexiconAnalyzer is the default SentimentAnalyzer. It averages the sentiment
+// of the emotional words in a sentence, like "great" or "useless," flipping
+// those soon after a negation, as in "not very helpful."
+type LexiconAnalyzer struct{}
+
+var sentimentMu sync.RWMutex
+var sentimentAnalyzer SentimentAnalyzer = LexiconAnalyzer{}
+
+// SetSentimentAnalyzer replaces the analyzer used by Sentiment. It should be
+// called before Abot begins processing messages, e.g. in a plugin's init.
+func SetSentimentAnalyzer(a SentimentAnalyzer) {
+	sentimentMu.Lock()
+	defer sentimentMu.Unlock()
+	sentimentAnalyzer = a
+}
+
+// negationWindow is how many words after "not" or "don't" have their
+// sentiment flipped, as in "not very helpful."
+const negationWindow = 3
+
+var positiveWords = []string{"good", "great", "awesome", "amazing",
+	"excellent", "perfect", "love", "like", "thanks", "thank", "helpful",
+	"happy", "glad", "nice", "wonderful", "fantastic", "appreciate", "cool",
+	"best", "pleased", "works", "fixed", "easy"}
+
+var negativeWords = []string{"bad", "terrible", "awful", "horrible", "worst",
+	"hate", "angry", "annoyed", "annoying", "frustrated", "frustrating",
+	"upset", "useless", "stupid", "ridiculous", "broken", "wrong", "fail",
+	"failed", "never", "disappointed", "disappointing", "unacceptable",
+	"slow", "late", "refund", "cancel", "complaint", "problem", "issue",
+	"sucks", "waste", "confusing", "unhelpful", "scam", "ugh"}
+
+// negators are stems that flip the sentiment of the words following them.
+// "Don't" is tokenized as "don", "'", "t", so "t" catches every contraction.
+var negators = map[string]struct{}{
+	"not": {}, "no": {}, "t": {}, "nor": {},
+}
+
+var sentimentLexicon = map[string]float64{}
+
+func init() {
+	for _, s := range nlp.StemTokens(positiveWords) {
+		sentimentLexicon[s] = 1
+	}
+	for _, s := range nlp.StemTokens(negativeWords) {
+		sentimentLexicon[s] = -1
+	}
+}
+
+// Sentiment scores how positive a sentence is from -1, very negative, to 1,
+// very positive, with the analyzer set by SetSentimentAnalyzer. Sentences
+// without any emotional words score 0. If the analyzer fails, e.g. when its
+// model is unreachable, the sentence is scored by a LexiconAnalyzer instead.
+func Sentiment(sentence string) float64 {
+	sentimentMu.RLock()
+	a := sentimentAnalyzer
+	sentimentMu.RUnlock()
+	score, err := a.Sentiment(sentence)
+	if err != nil {
+		log.Info("failed to analyze sentiment", err)
+		score, _ = LexiconAnalyzer{}.Sentiment(sentence)
+	}
+	switch {
+	case score < -1:
+		return -1
+	case score > 1:
+		return 1
+	}
+	return score
+}
+
+// Sentiment scores a sentence by its emotional words. It never fails.
+func (LexiconAnalyzer) Sentiment(sentence string) (float64, error) {
+	return stemsSentiment(nlp.StemTokens(nlp.TokenizeSentence(sentence))), nil
+}
+
+// stemsSentiment averages the sentiment of every emotional word in a sentence,
+// flipping words that follow a negation.
+func stemsSentiment(stems []string) float64 {
+	var sum float64
+	var n, negated int
+	for _, s := range stems {
+		if _, ok := negators[s]; ok {
+			negated = negationWindow
+			continue
+		}
+		score, ok := sentimentLexicon[s]
+		if negated > 0 {
+			negated--
+			score = -score
+		}
+		if !ok {
+			continue
+		}
+		sum += score
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
@@ -0,0 +1,197 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// maxShadowDifferences limits how many kinds of routing differences a shadow
+// report lists, most frequent first.
+const maxShadowDifferences = 50
+
+// Parser builds a StructuredInput from a message's tokens, like
+// Dictionary.ClassifyTokens.
+type Parser func(tokens []string) *nlp.StructuredInput
+
+var shadowParsers = map[string]Parser{}
+var shadowParsersMu sync.RWMutex
+
+// shadowParse is a candidate parser's output for a message and the route it
+// would have led to.
+type shadowParse struct {
+	Parser string
+	SI     *nlp.StructuredInput
+	Route  string
+	Plugin string
+}
+
+// ShadowReport compares the routes a candidate parser would have taken with
+// the routes the live parser took.
+type ShadowReport struct {
+	Parser      string
+	Messages    int
+	Agreed      int
+	Differences []RouteDifference
+}
+
+// RouteDifference counts messages that a candidate parser would have routed
+// differently, with an example sentence. An empty route means the message
+// wasn't routed to any plugin.
+type RouteDifference struct {
+	LiveRoute   string
+	ShadowRoute string
+	Count       int
+	Example     string
+}
+
+// RegisterShadowParser runs a candidate parser in shadow alongside the live
+// one. For every message, its output and the route it would have led to are
+// logged without affecting the response, so the two can be compared with
+// ShadowReports before the candidate goes live. Registering a name twice
+// replaces the earlier parser.
+func RegisterShadowParser(name string, p Parser) {
+	shadowParsersMu.Lock()
+	defer shadowParsersMu.Unlock()
+	shadowParsers[name] = p
+}
+
+func unregisterShadowParser(name string) {
+	shadowParsersMu.Lock()
+	defer shadowParsersMu.Unlock()
+	delete(shadowParsers, name)
+}
+
+// parseInShadow runs every shadow parser on a message, routing each output as
+// the live parser's would be. Only commands and objects are taken from a
+// candidate's output. Parsers that panic or fail to route are logged and
+// skipped.
+func parseInShadow(in *dt.Msg) []shadowParse {
+	shadowParsersMu.RLock()
+	defer shadowParsersMu.RUnlock()
+	var sps []shadowParse
+	for name, p := range shadowParsers {
+		sp, err := runShadowParser(name, p, in)
+		if err != nil {
+			log.Info("shadow parser failed", name, err)
+			continue
+		}
+		sps = append(sps, sp)
+	}
+	return sps
+}
+
+func runShadowParser(name string, p Parser, in *dt.Msg) (sp shadowParse,
+	err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	si := *in.StructuredInput
+	if out := p(in.Tokens); out != nil {
+		si.Commands, si.Objects = out.Commands, out.Objects
+	} else {
+		si.Commands, si.Objects = nil, nil
+	}
+	m := *in
+	m.StructuredInput = &si
+	plugin, route, _, err := GetPlugin(db, &m)
+	if err != nil && err != ErrMissingPlugin {
+		return sp, err
+	}
+	sp = shadowParse{Parser: name, SI: &si, Route: route}
+	if plugin != nil {
+		sp.Plugin = plugin.Config.Name
+	}
+	return sp, nil
+}
+
+// recordShadowParses logs the shadow parsers' output for a saved message
+// alongside the live route, also recording each as a check of the live
+// classification. See Agreement.
+func recordShadowParses(in *dt.Msg, liveRoute, livePlugin string,
+	sps []shadowParse) {
+
+	q := `INSERT INTO shadowparses
+	      (messageid, parser, commands, objects, route, liveroute)
+	      VALUES ($1, $2, $3, $4, $5, $6)`
+	for _, sp := range sps {
+		_, err := db.Exec(q, in.ID, sp.Parser, sp.SI.Commands,
+			sp.SI.Objects, sp.Route, liveRoute)
+		if err != nil {
+			log.Info("failed to record shadow parse", sp.Parser, err)
+			continue
+		}
+		err = RecordClassificationCheck(in.ID, livePlugin, sp.Plugin,
+			CheckShadow, sp.Parser)
+		if err != nil {
+			log.Info("failed to record shadow check", sp.Parser, err)
+		}
+	}
+}
+
+// ShadowReports compares each candidate parser with the live one over
+// messages received since a time.
+func ShadowReports(since time.Time) ([]ShadowReport, error) {
+	var rs []ShadowReport
+	q := `SELECT parser, COUNT(*) AS messages,
+	          COUNT(*) FILTER (WHERE route=liveroute) AS agreed
+	      FROM shadowparses
+	      WHERE createdat>=$1
+	      GROUP BY parser
+	      ORDER BY parser`
+	if err := db.Select(&rs, q, since); err != nil {
+		return nil, err
+	}
+	q = `SELECT sp.liveroute, sp.route AS shadowroute, COUNT(*) AS count,
+	         MIN(COALESCE(m.sentence, '')) AS example
+	     FROM shadowparses AS sp
+	     JOIN messages AS m ON m.id=sp.messageid
+	     WHERE sp.parser=$1 AND sp.createdat>=$2 AND sp.route<>sp.liveroute
+	     GROUP BY sp.liveroute, sp.route
+	     ORDER BY count DESC
+	     LIMIT $3`
+	for i := range rs {
+		err := db.Select(&rs[i].Differences, q, rs[i].Parser, since,
+			maxShadowDifferences)
+		if err != nil {
+			return nil, err
+		}
+		for j := range rs[i].Differences {
+			d := &rs[i].Differences[j]
+			d.Example = openSentence(d.Example)
+		}
+	}
+	return rs, nil
+}
+
+// HAPIShadowReports responds with a comparison of each candidate parser with
+// the live one over the past number of days given by the days query
+// parameter, 7 by default.
+func HAPIShadowReports(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	days := 7
+	if s := r.URL.Query().Get("days"); len(s) > 0 {
+		var err error
+		if days, err = strconv.Atoi(s); err != nil || days <= 0 {
+			writeErrorBadRequest(w, fmt.Errorf("invalid days %q", s))
+			return
+		}
+	}
+	rs, err := ShadowReports(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Reports []ShadowReport }{Reports: rs})
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// minSpellcheckLen is the shortest word Spellcheck corrects. Shorter words
+// are one edit away from too many others to guess which was meant.
+const minSpellcheckLen = 5
+
+const alphabet = "abcdefghijklmnopqrstuvwxyz"
+
+var (
+	regexUncorrectedAdd    = regexp.MustCompile(`(?i)^\s*(?:please\s+)?(?:don['’]?t|do not|stop)\s+(?:auto)?correct(?:ing)?\s+["“‘']?([^\s"“”]{1,40}?)["”’']?\s*[.!]*\s*$`)
+	regexUncorrectedDelete = regexp.MustCompile(`(?i)^\s*(?:you can\s+|please\s+)?(?:start\s+)?(?:auto)?correct(?:ing)?\s+["“‘']?([^\s"“”]{1,40}?)["”’']?\s+again\s*[.!]*\s*$`)
+	regexUncorrectedList   = regexp.MustCompile(`(?i)^\s*(?:(?:list|show)(?: me)?(?: all)?(?: the)?|what are(?: the)?)\s+words you (?:don['’]?t|do not|won['’]?t) correct\s*[.!?]*\s*$`)
+)
+
+// Spellcheck corrects misspelled tokens, like "resturant," to words the
+// classifier knows. A token is only corrected when it's unknown and exactly
+// one known word is a single edit away, so names and words the classifier
+// hasn't learned are usually left alone, as are words in the tenant's
+// vocabulary packs. Capitalized tokens, likely names, and tokens with anything
+// other than letters are never corrected.
+func Spellcheck(tokens []string) []string {
+	c := NER()
+	checked := make([]string, len(tokens))
+	for i, t := range tokens {
+		checked[i] = t
+		if len(t) < minSpellcheckLen || !isLowerWord(t) || c.knows(t) ||
+			knownVocabulary(t) {
+			continue
+		}
+		var match string
+		for _, e := range edits(t) {
+			if e == match || !c.knows(e) {
+				continue
+			}
+			if len(match) > 0 {
+				match = ""
+				break
+			}
+			match = e
+		}
+		if len(match) > 0 {
+			checked[i] = match
+		}
+	}
+	return checked
+}
+
+// spellcheckExcept corrects misspelled tokens like Spellcheck, leaving alone
+// the words in keep, which are lowercase.
+func spellcheckExcept(tokens []string, keep map[string]struct{}) []string {
+	checked := Spellcheck(tokens)
+	for i, t := range tokens {
+		if _, ok := keep[strings.ToLower(t)]; ok {
+			checked[i] = t
+		}
+	}
+	return checked
+}
+
+// uncorrectedWords returns the words a user has asked Abot not to correct, in
+// lowercase. Failures are logged, since corrections are a convenience.
+func uncorrectedWords(u *dt.User) map[string]struct{} {
+	if u == nil || !u.Registered() {
+		return nil
+	}
+	ws, err := u.UncorrectedWords(db)
+	if err != nil {
+		log.Info("failed to get uncorrected words", err)
+		return nil
+	}
+	keep := make(map[string]struct{}, len(ws))
+	for _, w := range ws {
+		keep[w] = struct{}{}
+	}
+	return keep
+}
+
+// manageUncorrected answers requests to stop or resume correcting a word,
+// e.g. "don't correct gonna" and "you can correct gonna again," and to list
+// the words Abot doesn't correct. An empty string is returned if the message
+// isn't such a request.
+func manageUncorrected(msg *dt.Msg) string {
+	if !msg.User.Registered() {
+		return ""
+	}
+	u := msg.User
+	if m := regexUncorrectedAdd.FindStringSubmatch(msg.Sentence); m != nil {
+		word := strings.ToLower(strings.Trim(m[1], "'‘’"))
+		if err := u.AddUncorrectedWord(db, word); err != nil {
+			log.Info("failed to add uncorrected word", err)
+			return "I'm sorry, I couldn't save that right now."
+		}
+		auditMsg(msg, AuditPreference, "stopped correcting "+word)
+		return fmt.Sprintf("Got it. I won't correct %q anymore.", word)
+	}
+	if m := regexUncorrectedDelete.FindStringSubmatch(msg.Sentence); m != nil {
+		word := strings.ToLower(strings.Trim(m[1], "'‘’"))
+		err := u.DeleteUncorrectedWord(db, word)
+		if err == dt.ErrNoUncorrectedWord {
+			return fmt.Sprintf("I'm already correcting %q.", word)
+		}
+		if err != nil {
+			log.Info("failed to delete uncorrected word", err)
+			return "I'm sorry, I couldn't save that right now."
+		}
+		auditMsg(msg, AuditPreference, "resumed correcting "+word)
+		return fmt.Sprintf("OK, I'll correct %q again.", word)
+	}
+	if regexUncorrectedList.MatchString(msg.Sentence) {
+		ws, err := u.UncorrectedWords(db)
+		if err != nil {
+			log.Info("failed to get uncorrected words", err)
+			return "I'm sorry, I couldn't find those words right now."
+		}
+		if len(ws) == 0 {
+			return `I correct every word right now. To stop correcting one, say something like "don't correct gonna."`
+		}
+		return "I don't correct: " + strings.Join(ws, ", ")
+	}
+	return ""
+}
+
+// knows reports whether a word is in the classifier's dictionaries.
+func (c Dictionary) knows(w string) bool {
+	if _, ok := c["C"+w]; ok {
+		return true
+	}
+	_, ok := c["O"+w]
+	return ok
+}
+
+func isLowerWord(s string) bool {
+	for _, r := range s {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// edits returns every string one deletion, transposition, substitution or
+// insertion away from a word.
+func edits(w string) []string {
+	var es []string
+	for i := 0; i <= len(w); i++ {
+		a, b := w[:i], w[i:]
+		if len(b) > 0 {
+			es = append(es, a+b[1:])
+		}
+		if len(b) > 1 {
+			es = append(es, a+string(b[1])+string(b[0])+b[2:])
+		}
+		for _, r := range alphabet {
+			if len(b) > 0 && rune(b[0]) != r {
+				es = append(es, a+string(r)+b[1:])
+			}
+			es = append(es, a+string(r)+b)
+		}
+	}
+	return es
+}
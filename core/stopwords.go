@@ -0,0 +1,127 @@
+package core
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stopwords are filler words and phrases like "um," "please" and "hey ava"
+// that are dropped before classifying a message, keyed by ISO 639-1 language.
+// Each is split into lowercase words so phrases can be matched token by token.
+var stopwords = map[string][][]string{}
+
+// loadStopwords reads the stopword lists in data/stopwords, one file per
+// language named for its ISO 639-1 code, e.g. en.txt. Lines starting with #
+// are comments.
+func loadStopwords() error {
+	dir := filepath.Join(os.Getenv("GOPATH"), "src", "github.com", "itsabot",
+		"abot", "data", "stopwords")
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	sw := map[string][][]string{}
+	for _, fi := range fis {
+		if filepath.Ext(fi.Name()) != ".txt" {
+			continue
+		}
+		lang := strings.TrimSuffix(fi.Name(), ".txt")
+		f, err := os.Open(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			if words := strings.Fields(strings.ToLower(line)); len(words) > 0 {
+				sw[lang] = append(sw[lang], words)
+			}
+		}
+		err = scanner.Err()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	stopwords = sw
+	return nil
+}
+
+// Stopwords returns the filler words and phrases dropped from messages in a
+// language, including any operators added to the comma-separated
+// stopwords_{lang} setting.
+func Stopwords(lang string) [][]string {
+	sw := stopwords[lang]
+	extra := Setting("stopwords_" + lang)
+	if len(extra) == 0 {
+		return sw
+	}
+	sw = append([][]string{}, sw...)
+	for _, s := range strings.Split(extra, ",") {
+		if words := strings.Fields(strings.ToLower(s)); len(words) > 0 {
+			sw = append(sw, words)
+		}
+	}
+	return sw
+}
+
+// RemoveStopwords drops filler words and phrases in a language from a
+// tokenized sentence, preferring the longest phrase that matches.
+func RemoveStopwords(tokens []string, lang string) []string {
+	stop := stopwordMask(tokens, lang)
+	filtered := make([]string, 0, len(tokens))
+	for i, t := range tokens {
+		if !stop[i] {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
+
+// stopwordMask reports which tokens RemoveStopwords drops.
+func stopwordMask(tokens []string, lang string) []bool {
+	stop := make([]bool, len(tokens))
+	sw := Stopwords(lang)
+	if len(sw) == 0 {
+		return stop
+	}
+	for i := 0; i < len(tokens); {
+		n := 0
+		for _, words := range sw {
+			if len(words) > n && matchesPhrase(tokens[i:], words) {
+				n = len(words)
+			}
+		}
+		if n == 0 {
+			n = 1
+		} else {
+			for j := i; j < i+n; j++ {
+				stop[j] = true
+			}
+		}
+		i += n
+	}
+	return stop
+}
+
+// matchesPhrase reports whether tokens begin with the words of a phrase,
+// ignoring case.
+func matchesPhrase(tokens, words []string) bool {
+	if len(tokens) < len(words) {
+		return false
+	}
+	for i, w := range words {
+		if strings.ToLower(tokens[i]) != w {
+			return false
+		}
+	}
+	return true
+}
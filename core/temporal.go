@@ -0,0 +1,158 @@
+package core
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/helpers/timeparse"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// timeContextTTL is how long after a user sets a time for an event that a
+// message with only a time, like "make it 7pm," is taken to amend it.
+const timeContextTTL = 2 * time.Hour
+
+var regexClock = regexp.MustCompile(`^\d{1,2}(:\d{2})?(am|pm)?$`)
+var regexHour = regexp.MustCompile(`^\d{1,2}$`)
+
+// clockWords make up a time of day, rewritten for timeparse.
+var clockWords = map[string]string{
+	"am": "am", "pm": "pm", "noon": "12pm", "midnight": "12am",
+	"morning": "am", "afternoon": "pm", "evening": "pm", "night": "pm",
+	"tonight": "pm",
+}
+
+// dayWords are days relative to today.
+var dayWords = map[string]int{
+	"today": 0, "tonight": 0, "tomorrow": 1,
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday,
+	"wednesday": time.Wednesday, "thursday": time.Thursday,
+	"friday": time.Friday, "saturday": time.Saturday,
+}
+
+// carryOverWords are words people use when correcting a time, as in "actually,
+// how about 7pm instead?" They're ignored when deciding whether a message is
+// only a time.
+var carryOverWords = map[string]struct{}{
+	"actually": {}, "make": {}, "it": {}, "change": {}, "move": {},
+	"switch": {}, "push": {}, "back": {}, "to": {}, "instead": {}, "no": {},
+	"nope": {}, "how": {}, "about": {}, "what": {}, "let": {}, "lets": {},
+	"s": {}, "do": {}, "try": {}, "for": {}, "rather": {}, "sorry": {},
+	"i": {}, "meant": {}, "mean": {}, "oh": {}, "wait": {}, "then": {},
+	"ok": {}, "okay": {}, "can": {}, "you": {}, "we": {}, "at": {}, "on": {},
+	"the": {}, "by": {}, "around": {}, "set": {}, "o": {}, "clock": {},
+	"oclock": {}, "please": {}, "um": {}, "uh": {}, "in": {}, "this": {},
+	"next": {},
+}
+
+// extractOnlyTime returns the times in a sentence if the sentence holds
+// nothing but a time and words used to correct one, e.g. "actually make it
+// 7pm" or "how about noon tomorrow?" A day without a time of day, as in "make
+// it tomorrow," returns midnight that day.
+func extractOnlyTime(sentence string, now time.Time) ([]time.Time, bool) {
+	// Spelled-out numbers, as in "make it seven thirty," become digits.
+	sentence = nlp.NormalizeNumbers(sentence)
+	words := strings.FieldsFunc(strings.ToLower(sentence), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ':'
+	})
+	var clock string
+	days := -1
+	for _, w := range words {
+		if regexClock.MatchString(w) {
+			// Join "7 30" into "7:30."
+			if regexHour.MatchString(clock) && len(w) == 2 {
+				clock += ":"
+			}
+			clock += w
+			continue
+		}
+		if s, ok := clockWords[w]; ok {
+			// Join "7 pm" into "7pm" for timeparse, preferring the
+			// first of "7pm tonight."
+			if !strings.HasSuffix(clock, "am") &&
+				!strings.HasSuffix(clock, "pm") {
+				clock += s
+			}
+		}
+		if d, ok := dayWords[w]; ok {
+			days = d
+			continue
+		}
+		if wd, ok := weekdays[w]; ok {
+			days = (int(wd) - int(now.Weekday()) + 7) % 7
+			continue
+		}
+		if _, ok := clockWords[w]; ok {
+			continue
+		}
+		if _, ok := carryOverWords[w]; ok {
+			continue
+		}
+		return nil, false
+	}
+	// A bare number, like "make it 7," is only a time when it has a day or
+	// minutes.
+	if !strings.ContainsAny(clock, ":apm") && days < 0 {
+		return nil, false
+	}
+	if days < 0 {
+		days = 0
+	}
+	y, m, d := now.Date()
+	day := time.Date(y, m, d+days, 0, 0, 0, 0, now.Location())
+	if len(clock) == 0 {
+		return []time.Time{day}, true
+	}
+	parsed, err := timeparse.ParseFromTime(now, clock)
+	if err != nil || len(parsed) == 0 {
+		return nil, false
+	}
+	// timeparse treats 12pm as ambiguous, though the user said which.
+	if strings.HasSuffix(clock, "m") {
+		parsed = parsed[:1]
+	}
+	var times []time.Time
+	for _, t := range parsed {
+		times = append(times, day.Add(time.Duration(t.Hour())*time.Hour+
+			time.Duration(t.Minute())*time.Minute))
+	}
+	return times, true
+}
+
+// SetTimeContext records the event a user most recently set a time for, so a
+// follow-up with only a time is routed back to the plugin on route.
+func SetTimeContext(uid uint64, pluginName, route, label string,
+	t time.Time) error {
+
+	q := `INSERT INTO timecontexts (userid, pluginname, route, label, time)
+	      VALUES ($1, $2, $3, $4, $5)
+	      ON CONFLICT (userid) DO UPDATE
+	      SET pluginname=$2, route=$3, label=$4, time=$5,
+	          updatedat=CURRENT_TIMESTAMP`
+	_, err := db.Exec(q, uid, pluginName, route, label, t)
+	return err
+}
+
+// GetTimeContext returns the event a user most recently set a time for, or
+// nil if they haven't done so recently.
+func GetTimeContext(uid uint64) (*dt.TimeContext, error) {
+	tc := &dt.TimeContext{}
+	q := `SELECT pluginname, route, label, time, updatedat
+	      FROM timecontexts
+	      WHERE userid=$1 AND updatedat>$2`
+	err := db.Get(tc, q, uid, time.Now().Add(-timeContextTTL))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
@@ -0,0 +1,530 @@
+package core
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/helpers/redact"
+)
+
+// Channels operators are notified of new tickets on, set with the
+// ticket_notify setting as a comma-separated list. Email is used if it's
+// empty.
+const (
+	// TicketNotifyEmail emails ADMIN_EMAIL.
+	TicketNotifyEmail = "email"
+
+	// TicketNotifyWebhook posts the ticket as JSON to the
+	// ticket_webhook setting, or ABOT_ALERT_WEBHOOK if that's empty. Its
+	// text field is a summary, so a Slack incoming webhook works as is.
+	TicketNotifyWebhook = "webhook"
+)
+
+// humanPlugin is the plugin of messages exchanged with an operator in human
+// mode, so they're told apart from Abot's in transcripts.
+const humanPlugin = "human"
+
+// ticketContextMsgs is how many of the user's messages leading up to a
+// ticket are kept as its transcript when none is given.
+const ticketContextMsgs = 10
+
+// releasedLang is sent to users when an operator hands their conversation
+// back to Abot.
+const releasedLang = "Thanks for your patience. I'm back, so just let me know if there's anything else I can help with."
+
+// ErrUnknownTicket is returned when replying to or releasing a ticket that
+// doesn't exist or was already released.
+var ErrUnknownTicket = errors.New("unknown ticket")
+
+// ErrTicketClaimed is returned when replying to or releasing a ticket another
+// operator has claimed.
+var ErrTicketClaimed = errors.New("ticket claimed by another operator")
+
+// ticketClient posts tickets to webhooks.
+var ticketClient = &http.Client{Timeout: 10 * time.Second}
+
+// Ticket is a conversation escalated to a person. While it's open, the user
+// is in human mode: Abot stops answering them, their messages are kept for
+// operators, and operators' replies are relayed to them, until an operator
+// releases the ticket. The first operator to reply claims it, so two don't
+// answer the same user.
+type Ticket struct {
+	ID        uint64
+	UserID    uint64
+	Name      string
+	FlexID    string
+	MessageID uint64
+
+	// Reason is why the conversation was escalated, e.g. the escalation
+	// rule or plugin that asked for a person.
+	Reason string
+
+	// Transcript is the conversation leading up to the ticket, as given
+	// to Escalate or else the user's recent messages.
+	Transcript string
+
+	ClaimedBy  string
+	ClaimedAt  *time.Time
+	ReleasedBy string
+	ReleasedAt *time.Time
+
+	// Messages are those exchanged in human mode, oldest first. They're
+	// only set by GetTicket.
+	Messages []TranscriptMsg
+
+	CreatedAt time.Time
+}
+
+const ticketColumns = `t.id, t.userid, u.name,
+	COALESCE((SELECT flexid FROM userflexids
+	    WHERE userid=t.userid
+	    ORDER BY createdat DESC LIMIT 1), '') AS flexid,
+	t.messageid, t.reason, t.transcript, t.claimedby, t.claimedat,
+	t.releasedby, t.releasedat, t.createdat`
+
+// Escalate hands a user's conversation to a person, e.g. when a plugin can't
+// resolve a billing dispute. It opens a ticket, labels the conversation
+// human_handoff and notifies operators on the channels in the ticket_notify
+// setting. An empty transcript is filled with the user's recent messages.
+// Users already in human mode keep their open ticket, which is returned.
+// Unregistered users can't be told apart, so escalating them returns
+// ErrUnregisteredUser.
+func Escalate(uid uint64, transcript, reason string) (*Ticket, error) {
+	if uid == 0 {
+		return nil, ErrUnregisteredUser
+	}
+	t, err := openTicket(uid)
+	if err != nil || t != nil {
+		return t, err
+	}
+	var msgID uint64
+	q := `SELECT COALESCE(MAX(id), 0) FROM messages
+	      WHERE userid=$1 AND abotsent IS FALSE`
+	if err = db.Get(&msgID, q, uid); err != nil {
+		return nil, err
+	}
+	if len(transcript) == 0 {
+		if transcript, err = recentTranscript(uid); err != nil {
+			return nil, err
+		}
+	}
+	sealed, err := dt.SealSentence(transcript)
+	if err != nil {
+		return nil, err
+	}
+	var id uint64
+	q = `INSERT INTO tickets (tenant, userid, messageid, reason, transcript)
+	     VALUES ($1, $2, $3, $4, $5)
+	     RETURNING id`
+	err = db.QueryRowx(q, tenant(), uid, msgID, reason, sealed).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	err = LabelConversation(uid, msgID, handoffLabel, "escalation")
+	if err != nil {
+		return nil, err
+	}
+	t, err = GetTicket(id)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("escalated user", uid, "to a person:", reason)
+	// The ticket is open either way, and operators will see it in the
+	// queue, so failing to notify them isn't an error.
+	if err = notifyTicket(t); err != nil {
+		log.Info("failed to notify operators of ticket", t.ID, err)
+	}
+	return t, nil
+}
+
+// recentTranscript returns a user's recent messages, oldest first, one per
+// line prefixed by who sent it.
+func recentTranscript(uid uint64) (string, error) {
+	var msgs []struct {
+		Sentence string
+		AbotSent bool
+	}
+	q := `SELECT COALESCE(sentence, '') AS sentence, abotsent
+	      FROM messages
+	      WHERE userid=$1
+	      ORDER BY id DESC
+	      LIMIT $2`
+	if err := db.Select(&msgs, q, uid, ticketContextMsgs); err != nil {
+		return "", err
+	}
+	var lines []string
+	for i := len(msgs) - 1; i >= 0; i-- {
+		from := "User"
+		if msgs[i].AbotSent {
+			from = "Abot"
+		}
+		lines = append(lines, from+": "+openSentence(msgs[i].Sentence))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// ticketNotifiers returns the channels operators are notified of tickets on.
+func ticketNotifiers() []string {
+	s := Setting("ticket_notify")
+	if len(s) == 0 {
+		return []string{TicketNotifyEmail}
+	}
+	var chs []string
+	for _, ch := range strings.Split(s, ",") {
+		chs = append(chs, strings.TrimSpace(ch))
+	}
+	return chs
+}
+
+// notifyTicket tells operators of a new ticket on each channel in the
+// ticket_notify setting, returning the last error.
+func notifyTicket(t *Ticket) error {
+	subj := fmt.Sprintf("User %d needs a person: %s", t.UserID, t.Reason)
+	content := fmt.Sprintf("Ticket %d for %s (user %d)\n\n%s", t.ID, t.Name,
+		t.UserID, t.Transcript)
+	var last error
+	for _, ch := range ticketNotifiers() {
+		var err error
+		switch ch {
+		case TicketNotifyEmail:
+			err = notifyAdmin(subj, content)
+		case TicketNotifyWebhook:
+			err = postTicket(t, subj)
+		default:
+			err = fmt.Errorf("unknown ticket notifier %q", ch)
+		}
+		if err != nil {
+			log.Info("failed to notify operators by", ch, err)
+			last = err
+		}
+	}
+	return last
+}
+
+// postTicket posts a ticket to the ticket_webhook setting, or
+// ABOT_ALERT_WEBHOOK if that's empty. Webhooks are usually chat services
+// outside Abot, so the user's personal information is redacted.
+func postTicket(t *Ticket, text string) error {
+	webhook := Setting("ticket_webhook")
+	if len(webhook) == 0 {
+		webhook = os.Getenv("ABOT_ALERT_WEBHOOK")
+	}
+	if len(webhook) == 0 {
+		return errors.New("no ticket webhook")
+	}
+	redacted := *t
+	redacted.Name = redact.String(redact.FieldContact, t.Name)
+	redacted.FlexID = redact.String(redact.FieldContact, t.FlexID)
+	redacted.Transcript = redact.String(redact.FieldMessage, t.Transcript)
+	byt, err := json.Marshal(struct {
+		Text   string `json:"text"`
+		Ticket *Ticket
+	}{Text: text, Ticket: &redacted})
+	if err != nil {
+		return err
+	}
+	resp, err := ticketClient.Post(webhook, "application/json",
+		bytes.NewBuffer(byt))
+	if err != nil {
+		return err
+	}
+	if err = resp.Body.Close(); err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("ticket webhook responded %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// openTicket returns a user's open ticket, or nil if they're not in human
+// mode.
+func openTicket(uid uint64) (*Ticket, error) {
+	t := &Ticket{}
+	q := `SELECT ` + ticketColumns + `
+	      FROM tickets AS t
+	      JOIN users AS u ON u.id=t.userid
+	      WHERE t.userid=$1 AND t.tenant=$2 AND t.releasedat IS NULL
+	      ORDER BY t.id DESC
+	      LIMIT 1`
+	err := db.Get(t, q, uid, tenant())
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.Transcript = openSentence(t.Transcript)
+	return t, nil
+}
+
+// relayToOperator keeps a message from a user in human mode for operators
+// rather than letting Abot answer it, reporting whether it did.
+func relayToOperator(msg *dt.Msg) (bool, error) {
+	if !msg.User.Registered() {
+		return false, nil
+	}
+	t, err := openTicket(msg.User.ID)
+	if err != nil || t == nil {
+		return false, err
+	}
+	msg.Plugin, msg.Route = humanPlugin, ""
+	if err = msg.Save(db); err != nil {
+		return false, err
+	}
+	observeMsg(ObserverEventMessage, msg, "")
+	return true, nil
+}
+
+// Tickets returns tickets newest first. open limits them to those not yet
+// released.
+func Tickets(open bool) ([]Ticket, error) {
+	q := `SELECT ` + ticketColumns + `
+	      FROM tickets AS t
+	      JOIN users AS u ON u.id=t.userid
+	      WHERE t.tenant=$1 AND ($2 IS FALSE OR t.releasedat IS NULL)
+	      ORDER BY t.id DESC
+	      LIMIT $3`
+	var ts []Ticket
+	if err := db.Select(&ts, q, tenant(), open, maxAdminResults); err != nil {
+		return nil, err
+	}
+	for i := range ts {
+		ts[i].Transcript = openSentence(ts[i].Transcript)
+	}
+	return ts, nil
+}
+
+// GetTicket returns a ticket with the messages exchanged since it was opened.
+func GetTicket(id uint64) (*Ticket, error) {
+	t := &Ticket{}
+	q := `SELECT ` + ticketColumns + `
+	      FROM tickets AS t
+	      JOIN users AS u ON u.id=t.userid
+	      WHERE t.id=$1 AND t.tenant=$2`
+	err := db.Get(t, q, id, tenant())
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownTicket
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.Transcript = openSentence(t.Transcript)
+	q = `SELECT id, COALESCE(sentence, '') AS sentence, abotsent,
+	         COALESCE(commands, '{}') AS commands,
+	         COALESCE(objects, '{}') AS objects,
+	         COALESCE(plugin, '') AS plugin, COALESCE(route, '') AS route,
+	         COALESCE(needstraining, FALSE) AS needstraining, createdat
+	     FROM messages
+	     WHERE userid=$1 AND id>$2
+	         AND createdat<=COALESCE($3, CURRENT_TIMESTAMP)
+	     ORDER BY id
+	     LIMIT $4`
+	err = db.Select(&t.Messages, q, t.UserID, t.MessageID, t.ReleasedAt,
+		maxAdminResults)
+	if err != nil {
+		return nil, err
+	}
+	for i := range t.Messages {
+		t.Messages[i].Sentence = openSentence(t.Messages[i].Sentence)
+	}
+	return t, nil
+}
+
+// ReplyToTicket relays an operator's reply to the user, claiming the ticket
+// for the operator if no one has.
+func ReplyToTicket(id uint64, operator, text string) error {
+	var uid uint64
+	q := `UPDATE tickets
+	      SET claimedby=$1, claimedat=COALESCE(claimedat, CURRENT_TIMESTAMP)
+	      WHERE id=$2 AND tenant=$3 AND releasedat IS NULL
+	          AND (claimedby='' OR claimedby=$1)
+	      RETURNING userid`
+	err := db.Get(&uid, q, operator, id, tenant())
+	if err == sql.ErrNoRows {
+		return ticketUnavailable(id)
+	}
+	if err != nil {
+		return err
+	}
+	return sendAsOperator(uid, text)
+}
+
+// ReleaseTicket hands a conversation back to Abot, telling the user. Once
+// the user has no callbacks left either, their conversation's human_handoff
+// label is resolved.
+func ReleaseTicket(id uint64, operator string) error {
+	var uid uint64
+	q := `UPDATE tickets
+	      SET releasedby=$1, releasedat=CURRENT_TIMESTAMP
+	      WHERE id=$2 AND tenant=$3 AND releasedat IS NULL
+	          AND (claimedby='' OR claimedby=$1)
+	      RETURNING userid`
+	err := db.Get(&uid, q, operator, id, tenant())
+	if err == sql.ErrNoRows {
+		return ticketUnavailable(id)
+	}
+	if err != nil {
+		return err
+	}
+	log.Info(operator, "released ticket", id)
+	var open bool
+	q = `SELECT EXISTS(SELECT 1 FROM callbacks
+	     WHERE userid=$1 AND completedat IS NULL)`
+	if err = db.Get(&open, q, uid); err != nil {
+		return err
+	}
+	if !open {
+		if err = ResolveLabel(uid, handoffLabel); err != nil {
+			return err
+		}
+	}
+	return sendAsOperator(uid, releasedLang)
+}
+
+// sendAsOperator saves a message from an operator to the user's conversation
+// and sends it on the channel they last used. Web users receive it the next
+// time the console fetches their progress updates.
+func sendAsOperator(uid uint64, text string) error {
+	u := &dt.User{ID: uid}
+	m := &dt.Msg{
+		User:     u,
+		Sentence: text,
+		AbotSent: true,
+		Plugin:   humanPlugin,
+	}
+	if err := m.Save(db); err != nil {
+		return err
+	}
+	observeMsg(ObserverEventResponse, m, "")
+	fid, fidT, err := u.LastFlexID(db)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == sql.ErrNoRows || dt.ChannelName(fidT) == dt.ChannelWeb {
+		progress.hold(uid, Render(&dt.Response{Text: text},
+			ChannelFor(dt.ChannelWeb)))
+		return nil
+	}
+	return sendRendered(dt.ScheduledEvent{
+		Content:    text,
+		FlexID:     fid,
+		FlexIDType: fidT,
+	})
+}
+
+// ticketUnavailable returns why a ticket couldn't be replied to or released.
+func ticketUnavailable(id uint64) error {
+	var claimed bool
+	q := `SELECT claimedby<>'' FROM tickets
+	      WHERE id=$1 AND tenant=$2 AND releasedat IS NULL`
+	err := db.Get(&claimed, q, id, tenant())
+	if err == sql.ErrNoRows {
+		return ErrUnknownTicket
+	}
+	if err != nil {
+		return err
+	}
+	if claimed {
+		return ErrTicketClaimed
+	}
+	return ErrUnknownTicket
+}
+
+// HAPITickets responds with tickets, newest first, limited to those not yet
+// released unless the all query parameter is "true."
+func HAPITickets(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	ts, err := Tickets(r.URL.Query().Get("all") != "true")
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Tickets []Ticket }{Tickets: ts})
+}
+
+// HAPITicket responds with a ticket, given by the id query parameter, and the
+// messages exchanged since it was opened.
+func HAPITicket(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	t, err := GetTicket(id)
+	if err == ErrUnknownTicket {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, t)
+}
+
+// HAPITicketReply relays the operator's reply to the user.
+func HAPITicketReply(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct {
+		ID   uint64
+		Text string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if len(strings.TrimSpace(req.Text)) == 0 {
+		writeErrorBadRequest(w, errors.New("missing text"))
+		return
+	}
+	err := ReplyToTicket(req.ID, operatorName(r), req.Text)
+	if err == ErrUnknownTicket || err == ErrTicketClaimed {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HAPITicketRelease hands the conversation back to Abot.
+func HAPITicketRelease(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct{ ID uint64 }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := ReleaseTicket(req.ID, operatorName(r))
+	if err == ErrUnknownTicket || err == ErrTicketClaimed {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,311 @@
+package core
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/helpers/redact"
+	"github.com/itsabot/abot/shared/interface/annotation/driver"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// Formats of exported training data.
+const (
+	// FormatCSV writes a header and a row per message.
+	FormatCSV = "csv"
+
+	// FormatJSON writes an array of TrainingRecords.
+	FormatJSON = "json"
+)
+
+// correctableMsgs is how many of the user's latest messages are searched for
+// one to correct, skipping their corrections.
+const correctableMsgs = 5
+
+var regexCorrect = regexp.MustCompile(`(?i)^\s*/correct(?:\s+(.+?))?\s*[.!]*\s*$`)
+
+// TrainingRecord is a message a user sent with how Abot classified and routed
+// it and, if it's been labeled, the plugin it should have been routed to, for
+// retraining the classifier outside of Abot.
+type TrainingRecord struct {
+	MessageID     uint64
+	Sentence      string
+	Commands      nlp.StringSlice
+	Objects       nlp.StringSlice
+	Plugin        string
+	Route         string
+	NeedsTraining bool
+
+	// Expected is the plugin the message's latest label says should have
+	// handled it, or "" if none should have. LabelSource is the label's
+	// source, e.g. CheckCorrection, or "" if the message is unlabeled.
+	Expected    string
+	LabelSource string
+
+	CreatedAt time.Time
+}
+
+// manageCorrection answers "/correct {plugin}," labeling the user's previous
+// message with the plugin that should have handled it, or "none," so
+// misclassifications are kept for retraining. "/correct" alone lists the
+// plugins. An empty string is returned if the message isn't a correction.
+func manageCorrection(msg *dt.Msg) string {
+	if !msg.User.Registered() {
+		return ""
+	}
+	m := regexCorrect.FindStringSubmatch(msg.Sentence)
+	if m == nil {
+		return ""
+	}
+	choices := annotationChoices()
+	name := strings.ToLower(strings.TrimSpace(m[1]))
+	if len(name) == 0 {
+		return fmt.Sprintf(`Which plugin should have handled your last message? Say "/correct" followed by one of: %s.`,
+			strings.Join(choices, ", "))
+	}
+	var expected string
+	var found bool
+	for _, c := range choices {
+		if strings.ToLower(c) == name {
+			expected, found = c, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Sprintf("I don't know a plugin called %q. Choose one of: %s.",
+			name, strings.Join(choices, ", "))
+	}
+	if expected == driver.NoPlugin {
+		expected = ""
+	}
+	prev, err := lastCorrectable(msg.User)
+	if err == sql.ErrNoRows {
+		return "You haven't sent me anything to correct yet."
+	}
+	if err != nil {
+		log.Info("failed to find message to correct", err)
+		return "I'm sorry, I couldn't save that correction right now."
+	}
+	err = CorrectClassification(prev.MessageID, prev.Plugin, expected,
+		fmt.Sprintf("user %d", msg.User.ID))
+	if err != nil {
+		log.Info("failed to save correction", err)
+		return "I'm sorry, I couldn't save that correction right now."
+	}
+	if len(expected) == 0 {
+		return fmt.Sprintf("Thanks! I'll learn that %q wasn't meant for any plugin.",
+			prev.Sentence)
+	}
+	return fmt.Sprintf("Thanks! I'll learn that %q was meant for %s.",
+		prev.Sentence, expected)
+}
+
+// lastCorrectable returns the user's latest message other than a correction,
+// or sql.ErrNoRows if there's none.
+func lastCorrectable(u *dt.User) (*TrainingRecord, error) {
+	q := `SELECT id AS messageid, COALESCE(sentence, '') AS sentence,
+	          COALESCE(plugin, '') AS plugin
+	      FROM messages
+	      WHERE userid=$1 AND abotsent IS FALSE
+	      ORDER BY id DESC
+	      LIMIT $2`
+	var msgs []TrainingRecord
+	if err := db.Select(&msgs, q, u.ID, correctableMsgs); err != nil {
+		return nil, err
+	}
+	for _, m := range msgs {
+		m.Sentence = openSentence(m.Sentence)
+		if !regexCorrect.MatchString(m.Sentence) {
+			return &m, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// CorrectClassification records a user's correction of the plugin a message
+// was routed to, replacing any earlier correction of it. Corrections are
+// training examples like labels from trainers.
+func CorrectClassification(msgID uint64, plugin, expected,
+	correctedBy string) error {
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	q := `DELETE FROM classificationchecks WHERE messageid=$1 AND source=$2`
+	if _, err = tx.Exec(q, msgID, CheckCorrection); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	q = `INSERT INTO classificationchecks
+	     (messageid, plugin, expected, source, checkedby, checkedat)
+	     VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)`
+	_, err = tx.Exec(q, msgID, plugin, expected, CheckCorrection,
+		correctedBy)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ExportTrainingData writes the messages users sent since a time with how
+// they were classified and routed and their latest label, if any, from a
+// trainer, the annotation tool or the user's correction. If labeled is true,
+// unlabeled messages are left out. Personal information is masked as
+// configured for redact.FieldMessage.
+func ExportTrainingData(w io.Writer, format string, since time.Time,
+	labeled bool) error {
+
+	if format != FormatCSV && format != FormatJSON {
+		return fmt.Errorf("%s: %q", ErrUnknownFormat, format)
+	}
+	q := `SELECT m.id AS messageid, COALESCE(m.sentence, '') AS sentence,
+	          COALESCE(m.commands, '{}') AS commands,
+	          COALESCE(m.objects, '{}') AS objects,
+	          COALESCE(m.plugin, '') AS plugin,
+	          COALESCE(m.route, '') AS route,
+	          COALESCE(m.needstraining, FALSE) AS needstraining,
+	          COALESCE(cc.expected, '') AS expected,
+	          COALESCE(cc.source, '') AS labelsource, m.createdat
+	      FROM messages AS m
+	      LEFT JOIN LATERAL (
+	          SELECT expected, source FROM classificationchecks
+	          WHERE messageid=m.id AND checkedat IS NOT NULL
+	              AND source<>$2
+	          ORDER BY checkedat DESC
+	          LIMIT 1) AS cc ON TRUE
+	      WHERE m.abotsent IS FALSE AND m.createdat>=$1
+	          AND (cc.source IS NOT NULL OR NOT $3)
+	      ORDER BY m.id`
+	rows, err := db.Queryx(q, since, CheckShadow, labeled)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err = rows.Close(); err != nil {
+			log.Info("failed to close rows", err)
+		}
+	}()
+	bw := bufio.NewWriter(w)
+	var cw *csv.Writer
+	if format == FormatCSV {
+		cw = csv.NewWriter(bw)
+		err = cw.Write([]string{"message_id", "sentence", "commands",
+			"objects", "plugin", "route", "needs_training", "expected",
+			"label_source", "created_at"})
+		if err != nil {
+			return err
+		}
+	} else if _, err = io.WriteString(bw, "["); err != nil {
+		return err
+	}
+	var n int
+	for rows.Next() {
+		var rec TrainingRecord
+		if err = rows.StructScan(&rec); err != nil {
+			return err
+		}
+		rec.Sentence = openSentence(rec.Sentence)
+		if len(strings.TrimSpace(rec.Sentence)) == 0 ||
+			regexCorrect.MatchString(rec.Sentence) {
+			continue
+		}
+		rec.Sentence = redact.String(redact.FieldMessage, rec.Sentence)
+		if cw != nil {
+			err = cw.Write([]string{
+				strconv.FormatUint(rec.MessageID, 10),
+				rec.Sentence,
+				strings.Join(rec.Commands, " "),
+				strings.Join(rec.Objects, " "),
+				rec.Plugin,
+				rec.Route,
+				strconv.FormatBool(rec.NeedsTraining),
+				rec.Expected,
+				rec.LabelSource,
+				rec.CreatedAt.Format(time.RFC3339),
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		var byt []byte
+		if byt, err = json.Marshal(rec); err != nil {
+			return err
+		}
+		if n > 0 {
+			if _, err = io.WriteString(bw, ","); err != nil {
+				return err
+			}
+		}
+		if _, err = bw.Write(byt); err != nil {
+			return err
+		}
+		n++
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if cw != nil {
+		cw.Flush()
+		if err = cw.Error(); err != nil {
+			return err
+		}
+	} else if _, err = io.WriteString(bw, "]\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// HAPIExportTrainingData downloads the messages users sent over the past
+// number of days given by the days query parameter, 30 by default, with how
+// they were classified and labeled. Pass format=csv or format=json, and
+// labeled=true to leave out unlabeled messages.
+func HAPIExportTrainingData(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	v := r.URL.Query()
+	format := v.Get("format")
+	if len(format) == 0 {
+		format = FormatCSV
+	}
+	if format != FormatCSV && format != FormatJSON {
+		writeErrorBadRequest(w, fmt.Errorf("%s: %q", ErrUnknownFormat,
+			format))
+		return
+	}
+	days := 30
+	if s := v.Get("days"); len(s) > 0 {
+		var err error
+		if days, err = strconv.Atoi(s); err != nil || days <= 0 {
+			writeErrorBadRequest(w, fmt.Errorf("invalid days %q", s))
+			return
+		}
+	}
+	contentType := "text/csv; charset=utf-8"
+	if format == FormatJSON {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition",
+		`attachment; filename="abot_training.`+format+`"`)
+	err := ExportTrainingData(w, format, time.Now().AddDate(0, 0, -days),
+		v.Get("labeled") == "true")
+	if err != nil {
+		// The response has begun, so the error can only be logged.
+		log.Info("failed to export training data", err)
+	}
+}
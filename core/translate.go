@@ -0,0 +1,60 @@
+package core
+
+import (
+	"os"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/interface/translate"
+)
+
+// parserLang is the ISO 639-1 code of the language Abot's NLP understands.
+const parserLang = "en"
+
+var translateConn *translate.Conn
+
+// autoTranslate reports whether messages in other languages should be
+// translated for the parser and responses translated back. It's enabled by
+// setting ABOT_AUTO_TRANSLATE=true and importing a translation driver.
+func autoTranslate() bool {
+	return translateConn != nil && os.Getenv("ABOT_AUTO_TRANSLATE") == "true"
+}
+
+// translateIn translates a user's message into the parser's language in place,
+// returning the language the message was written in. An empty language means
+// no translation was done. Failing to translate isn't fatal, since the parser
+// may still make sense of the original.
+func translateIn(cmd *string) string {
+	if !autoTranslate() || len(*cmd) == 0 {
+		return ""
+	}
+	lang, err := translateConn.Detect(*cmd)
+	if err != nil {
+		log.Info("failed to detect language", err)
+		return ""
+	}
+	if len(lang) == 0 || lang == parserLang {
+		return ""
+	}
+	s, err := translateConn.Translate(*cmd, lang, parserLang)
+	if err != nil {
+		log.Info("failed to translate message from", lang, err)
+		return ""
+	}
+	log.Debug("translated message from", lang)
+	*cmd = s
+	return lang
+}
+
+// translateOut translates a response back into the language of the user's
+// message. If translation fails, the original response is returned.
+func translateOut(resp, lang string) string {
+	if !autoTranslate() || len(lang) == 0 || len(resp) == 0 {
+		return resp
+	}
+	s, err := translateConn.Translate(resp, parserLang, lang)
+	if err != nil {
+		log.Info("failed to translate response to", lang, err)
+		return resp
+	}
+	return s
+}
@@ -0,0 +1,544 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// challengeTTL is how long a passed challenge lasts, so a user confirming a
+// purchase isn't asked again for the next one moments later.
+const challengeTTL = 10 * time.Minute
+
+// maxChallengeFailures is how many wrong codes a user may send across
+// challenges within an hour before they're refused, so codes can't be guessed
+// by starting challenge after challenge.
+const maxChallengeFailures = 10
+
+// maxChallengeTexts is how many codes may be texted to a user within an hour.
+const maxChallengeTexts = 5
+
+// totpStep is how long each code from an authenticator app lasts.
+const totpStep = 30
+
+// totpIssuer names Abot in authenticator apps.
+const totpIssuer = "Abot"
+
+// Methods of challenging a user.
+const (
+	challengeTOTP = "totp"
+	challengeSMS  = "sms"
+)
+
+// ErrTOTPEnrolled is returned when enrolling a user in TOTP who already uses
+// an authenticator app. They must disable it first.
+var ErrTOTPEnrolled = errors.New("authenticator app already enrolled")
+
+// ErrNotEnrolled is returned when confirming or disabling TOTP for a user who
+// hasn't enrolled.
+var ErrNotEnrolled = errors.New("authenticator app not enrolled")
+
+// ErrWrongCode is returned when a code from an authenticator app is wrong.
+var ErrWrongCode = errors.New("wrong code")
+
+// challenge is a second factor a user has been asked for.
+type challenge struct {
+	ID       uint64
+	Reason   string
+	Method   string
+	CodeHash string
+	Attempts int
+}
+
+func init() {
+	dt.SetChallenger(twoFactor{})
+}
+
+// twoFactor challenges users with a code from their authenticator app if
+// they've enrolled one with EnrollTOTP, or one texted to their phone
+// otherwise.
+type twoFactor struct{}
+
+// Challenge reports whether the user passed a challenge for the reason within
+// challengeTTL, or the AuthPolicy's max age for it if shorter, starting one if
+// they haven't. A challenge the user is already answering for the reason is
+// reused, so calling Challenge again as they reply doesn't text them another
+// code.
+func (twoFactor) Challenge(u *dt.User, reason string) (bool, string, error) {
+	if u == nil || !u.Registered() {
+		return false, "", dt.ErrNoSecondFactor
+	}
+	// A policy demanding users authenticate more often than challengeTTL
+	// for the reason applies to challenges too.
+	window := challengeTTL
+	if d := AuthPolicy().MaxAgeFor(reason); d < window {
+		window = d
+	}
+	var n int
+	q := `SELECT COUNT(*) FROM challenges
+	      WHERE userid=$1 AND reason=$2 AND passedat>$3`
+	err := db.Get(&n, q, u.ID, reason, time.Now().Add(-window))
+	if err != nil {
+		return false, "", err
+	}
+	if n > 0 {
+		return true, "", nil
+	}
+	if err = checkChallengeFailures(u.ID); err != nil {
+		return false, "", err
+	}
+	c, err := pendingChallenge(u.ID)
+	if err != nil {
+		return false, "", err
+	}
+	if c != nil && c.Reason == reason {
+		return false, c.prompt(), nil
+	}
+	if c, err = startChallenge(u, reason); err != nil {
+		return false, "", err
+	}
+	return false, c.prompt(), nil
+}
+
+// prompt asks the user for the code a challenge awaits.
+func (c *challenge) prompt() string {
+	var s string
+	if c.Attempts > 0 {
+		s = "That code wasn't right. "
+	}
+	if c.Method == challengeTOTP {
+		return s + "To confirm it's you, please send me the code from " +
+			"your authenticator app."
+	}
+	return s + "To confirm it's you, please send me the code I texted " +
+		"to your phone."
+}
+
+// startChallenge asks the user for a code from their authenticator app, or
+// texts them one, replacing any challenge they were answering.
+func startChallenge(u *dt.User, reason string) (*challenge, error) {
+	enrolled, err := totpEnrolled(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	c := &challenge{Reason: reason, Method: challengeTOTP}
+	var phone, code string
+	if !enrolled {
+		c.Method = challengeSMS
+		if phone, err = challengePhone(u); err != nil {
+			return nil, err
+		}
+		var n int
+		q := `SELECT COUNT(*) FROM challenges
+		      WHERE userid=$1 AND method=$2 AND createdat>$3`
+		err = db.Get(&n, q, u.ID, challengeSMS,
+			time.Now().Add(-time.Hour))
+		if err != nil {
+			return nil, err
+		}
+		if n >= maxChallengeTexts {
+			return nil, dt.ErrTooManyAttempts
+		}
+		num, err := rand.Int(rand.Reader, big.NewInt(1000000))
+		if err != nil {
+			return nil, err
+		}
+		code = fmt.Sprintf("%06d", num.Int64())
+		c.CodeHash = hashCode(code)
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	q := `DELETE FROM challenges WHERE userid=$1 AND passedat IS NULL`
+	if _, err = tx.Exec(q, u.ID); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	q = `INSERT INTO challenges
+	     (userid, reason, method, codehash, expiresat)
+	     VALUES ($1, $2, $3, $4, $5)
+	     RETURNING id`
+	err = tx.QueryRowx(q, u.ID, reason, c.Method, c.CodeHash,
+		time.Now().Add(codeTTL)).Scan(&c.ID)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	if c.Method == challengeSMS {
+		evt := dt.ScheduledEvent{
+			Content: fmt.Sprintf("Your code is %s. It expires in "+
+				"10 minutes.", code),
+			FlexID:     phone,
+			FlexIDType: dt.FlexIDType(2),
+		}
+		if err = evt.Send(smsConn, emailConn); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// challengePhone returns the phone number a user's codes are texted to.
+func challengePhone(u *dt.User) (string, error) {
+	fids, err := u.FlexIDs(db)
+	if err != nil {
+		return "", err
+	}
+	for _, fid := range fids {
+		if fid.FlexIDType == dt.FlexIDType(2) {
+			return fid.FlexID, nil
+		}
+	}
+	return "", dt.ErrNoSecondFactor
+}
+
+// pendingChallenge returns the challenge a user is answering, or nil if
+// there's none.
+func pendingChallenge(uid uint64) (*challenge, error) {
+	q := `SELECT id, reason, method, codehash, attempts FROM challenges
+	      WHERE userid=$1 AND passedat IS NULL AND expiresat>$2
+	          AND attempts<$3
+	      ORDER BY id DESC
+	      LIMIT 1`
+	c := &challenge{}
+	err := db.Get(c, q, uid, time.Now(), maxCodeAttempts)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// checkChallengeFailures returns dt.ErrTooManyAttempts if the user sent
+// maxChallengeFailures wrong codes within the past hour.
+func checkChallengeFailures(uid uint64) error {
+	var n int
+	q := `SELECT COUNT(*) FROM challengeattempts
+	      WHERE userid=$1 AND passed IS FALSE AND createdat>$2`
+	if err := db.Get(&n, q, uid, time.Now().Add(-time.Hour)); err != nil {
+		return err
+	}
+	if n >= maxChallengeFailures {
+		return dt.ErrTooManyAttempts
+	}
+	return nil
+}
+
+// answerChallenge checks a code the user sent against their pending
+// challenge, counting wrong codes toward maxCodeAttempts and
+// maxChallengeFailures. Passing a challenge counts as authenticating, so it
+// updates the user's LastAuthenticated.
+func answerChallenge(uid uint64, code string) (bool, error) {
+	if err := checkChallengeFailures(uid); err != nil {
+		return false, err
+	}
+	c, err := pendingChallenge(uid)
+	if err != nil {
+		return false, err
+	}
+	if c == nil {
+		return false, ErrNoVerification
+	}
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+	var passed bool
+	if c.Method == challengeTOTP {
+		passed, err = checkTOTP(uid, code, true)
+		if err != nil {
+			return false, err
+		}
+	} else {
+		passed = hmac.Equal([]byte(hashCode(code)), []byte(c.CodeHash))
+	}
+	q := `INSERT INTO challengeattempts (userid, passed) VALUES ($1, $2)`
+	if _, err = db.Exec(q, uid, passed); err != nil {
+		return false, err
+	}
+	action := AuditChallengePassed
+	if !passed {
+		action = AuditChallengeFailed
+	}
+	Audit(AuditEntry{UserID: uid, Action: action, Detail: c.Reason})
+	if !passed {
+		q = `UPDATE challenges SET attempts=attempts+1 WHERE id=$1`
+		_, err = db.Exec(q, c.ID)
+		return false, err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	q = `UPDATE challenges SET passedat=CURRENT_TIMESTAMP WHERE id=$1`
+	if _, err = tx.Exec(q, c.ID); err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+	q = `UPDATE users SET lastauthenticated=CURRENT_TIMESTAMP WHERE id=$1`
+	if _, err = tx.Exec(q, uid); err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// EnrollTOTP creates a secret for the user's authenticator app, returning an
+// otpauth URI to show them as a QR code. The user must send back a code from
+// their app with ConfirmTOTP before they're challenged with it. Enrolling
+// again before confirming replaces the secret.
+func EnrollTOTP(uid uint64) (string, error) {
+	enrolled, err := totpEnrolled(uid)
+	if err != nil {
+		return "", err
+	}
+	if enrolled {
+		return "", ErrTOTPEnrolled
+	}
+	var email string
+	q := `SELECT email FROM users WHERE id=$1`
+	if err = db.Get(&email, q, uid); err != nil {
+		return "", err
+	}
+	secret := make([]byte, 20)
+	if _, err = rand.Read(secret); err != nil {
+		return "", err
+	}
+	sealed, err := sealGCM(totpKey(), secret)
+	if err != nil {
+		return "", err
+	}
+	q = `INSERT INTO totpsecrets (userid, secret) VALUES ($1, $2)
+	     ON CONFLICT (userid) DO UPDATE
+	     SET secret=$2, laststep=0, createdat=CURRENT_TIMESTAMP`
+	_, err = db.Exec(q, uid, base64.StdEncoding.EncodeToString(sealed))
+	if err != nil {
+		return "", err
+	}
+	v := url.Values{}
+	v.Set("secret", strings.TrimRight(
+		base32.StdEncoding.EncodeToString(secret), "="))
+	v.Set("issuer", totpIssuer)
+	return "otpauth://totp/" + url.QueryEscape(totpIssuer+":"+email) +
+		"?" + v.Encode(), nil
+}
+
+// ConfirmTOTP checks a code from the user's newly enrolled authenticator app,
+// enabling it for challenges if it's right.
+func ConfirmTOTP(uid uint64, code string) error {
+	ok, err := checkTOTP(uid, code, false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrWrongCode
+	}
+	q := `UPDATE totpsecrets SET confirmedat=CURRENT_TIMESTAMP
+	      WHERE userid=$1`
+	if _, err = db.Exec(q, uid); err != nil {
+		return err
+	}
+	Audit(AuditEntry{UserID: uid, Action: AuditTOTPEnrolled})
+	return nil
+}
+
+// DisableTOTP stops challenging the user with their authenticator app, so
+// codes are texted to them instead. It requires a current code from the app,
+// so a stolen session can't turn it off.
+func DisableTOTP(uid uint64, code string) error {
+	ok, err := checkTOTP(uid, code, true)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrWrongCode
+	}
+	q := `DELETE FROM totpsecrets WHERE userid=$1`
+	if _, err = db.Exec(q, uid); err != nil {
+		return err
+	}
+	Audit(AuditEntry{UserID: uid, Action: AuditTOTPDisabled})
+	return nil
+}
+
+// totpEnrolled reports whether the user confirmed an authenticator app.
+func totpEnrolled(uid uint64) (bool, error) {
+	var n int
+	q := `SELECT COUNT(*) FROM totpsecrets
+	      WHERE userid=$1 AND confirmedat IS NOT NULL`
+	if err := db.Get(&n, q, uid); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// checkTOTP checks a code from the user's authenticator app, allowing for a
+// step of clock drift either way. Each code may be used only once.
+func checkTOTP(uid uint64, code string, confirmed bool) (bool, error) {
+	var row struct {
+		Secret    string
+		LastStep  int64
+		Confirmed bool
+	}
+	q := `SELECT secret, laststep, confirmedat IS NOT NULL AS confirmed
+	      FROM totpsecrets WHERE userid=$1`
+	err := db.Get(&row, q, uid)
+	if err == sql.ErrNoRows || (err == nil && row.Confirmed != confirmed) {
+		return false, ErrNotEnrolled
+	}
+	if err != nil {
+		return false, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(row.Secret)
+	if err != nil {
+		return false, err
+	}
+	secret, err := openGCM(totpKey(), sealed)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().Unix() / totpStep
+	for step := now - 1; step <= now+1; step++ {
+		if step <= row.LastStep {
+			continue
+		}
+		if !hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			continue
+		}
+		q = `UPDATE totpsecrets SET laststep=$1
+		     WHERE userid=$2 AND laststep<$1`
+		res, err := db.Exec(q, step, uid)
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		return n > 0, err
+	}
+	return false, nil
+}
+
+// totpCode is the six-digit code for a step, per RFC 6238.
+func totpCode(secret []byte, step int64) string {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(step))
+	h := hmac.New(sha1.New, secret)
+	_, _ = h.Write(b[:])
+	sum := h.Sum(nil)
+	off := sum[len(sum)-1] & 0xf
+	n := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", n%1000000)
+}
+
+// totpKey is the key the tenant's TOTP secrets are encrypted with.
+func totpKey() []byte {
+	k := sha256.Sum256([]byte(os.Getenv("ABOT_SECRET") + ":totp:" +
+		tenant()))
+	return k[:]
+}
+
+// HAPITOTPEnroll responds with an otpauth URI for the logged in user to add
+// to their authenticator app. See EnrollTOTP.
+func HAPITOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	uid, ok := totpUserID(w, r)
+	if !ok {
+		return
+	}
+	uri, err := EnrollTOTP(uid)
+	if err == ErrTOTPEnrolled {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ URI string }{URI: uri})
+}
+
+// HAPITOTPConfirm enables the logged in user's authenticator app with a code
+// from it.
+func HAPITOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	uid, ok := totpUserID(w, r)
+	if !ok {
+		return
+	}
+	var req struct{ Code string }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	switch err := ConfirmTOTP(uid, req.Code); err {
+	case nil:
+		w.WriteHeader(http.StatusOK)
+	case ErrWrongCode, ErrNotEnrolled:
+		writeErrorBadRequest(w, err)
+	default:
+		writeErrorInternal(w, err)
+	}
+}
+
+// HAPITOTPDisable disables the logged in user's authenticator app with a
+// current code from it.
+func HAPITOTPDisable(w http.ResponseWriter, r *http.Request) {
+	uid, ok := totpUserID(w, r)
+	if !ok {
+		return
+	}
+	var req struct{ Code string }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	switch err := DisableTOTP(uid, req.Code); err {
+	case nil:
+		w.WriteHeader(http.StatusOK)
+	case ErrWrongCode, ErrNotEnrolled:
+		writeErrorBadRequest(w, err)
+	default:
+		writeErrorInternal(w, err)
+	}
+}
+
+// totpUserID checks that a TOTP request is from a logged in user, returning
+// their ID.
+func totpUserID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	if os.Getenv("ABOT_ENV") != "test" {
+		if !LoggedIn(w, r) {
+			return 0, false
+		}
+		if !CSRF(w, r) {
+			return 0, false
+		}
+	}
+	cookie, err := r.Cookie("id")
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return 0, false
+	}
+	uid, err := strconv.ParseUint(cookie.Value, 10, 64)
+	if err != nil {
+		writeErrorBadRequest(w, err)
+		return 0, false
+	}
+	return uid, true
+}
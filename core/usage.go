@@ -0,0 +1,232 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/helpers/redact"
+)
+
+// Types of billing events.
+const (
+	BillingInvocation    = "invocation"
+	BillingSpend         = "spend"
+	BillingQuotaExceeded = "quota_exceeded"
+)
+
+// BillingEvent records metered usage for operators billing their customers.
+// Tenant identifies the Abot server through ABOT_TENANT when one operator
+// hosts many. Amounts are invocations for invocation events and millionths of
+// a dollar for spend events.
+type BillingEvent struct {
+	Type      string
+	Tenant    string
+	Plugin    string
+	UserID    uint64
+	Amount    int64
+	Note      string
+	CreatedAt time.Time
+}
+
+var billingHooks []func(*BillingEvent)
+var billingHooksMu sync.RWMutex
+
+var billingClient = &http.Client{Timeout: 10 * time.Second}
+
+func init() {
+	RegisterMetric("plugin_usage_this_month", usageThisMonth)
+}
+
+// RegisterBillingHook calls fn with every billing event, e.g. to forward usage
+// to a billing service. Hooks are called in their own goroutine, so they may
+// block.
+func RegisterBillingHook(fn func(*BillingEvent)) {
+	billingHooksMu.Lock()
+	defer billingHooksMu.Unlock()
+	billingHooks = append(billingHooks, fn)
+}
+
+// emitBillingEvent sends an event to every billing hook and, if set, POSTs it
+// as JSON to ABOT_BILLING_WEBHOOK.
+func emitBillingEvent(evt *BillingEvent) {
+	billingHooksMu.RLock()
+	hooks := billingHooks
+	billingHooksMu.RUnlock()
+	webhook := os.Getenv("ABOT_BILLING_WEBHOOK")
+	if len(hooks) == 0 && len(webhook) == 0 {
+		return
+	}
+	evt.Note = redact.String(redact.FieldEvent, evt.Note)
+	go func() {
+		for _, fn := range hooks {
+			fn(evt)
+		}
+		if len(webhook) == 0 {
+			return
+		}
+		byt, err := json.Marshal(evt)
+		if err != nil {
+			log.Info("failed to marshal billing event", err)
+			return
+		}
+		resp, err := billingClient.Post(webhook, "application/json",
+			bytes.NewBuffer(byt))
+		if err != nil {
+			log.Info("failed to send billing event", err)
+			return
+		}
+		if err = resp.Body.Close(); err != nil {
+			log.Info("failed to close billing response", err)
+		}
+	}()
+}
+
+func tenant() string {
+	return os.Getenv("ABOT_TENANT")
+}
+
+// recordUsage adds invocations and spend to a plugin's usage for a user today.
+func recordUsage(plugin string, uid uint64, invocations int, spend int64) error {
+	q := `INSERT INTO pluginusage
+	      (tenant, pluginname, userid, invocations, spendmicros)
+	      VALUES ($1, $2, $3, $4, $5)
+	      ON CONFLICT (tenant, pluginname, userid, day) DO UPDATE
+	      SET invocations=pluginusage.invocations+$4,
+	          spendmicros=pluginusage.spendmicros+$5`
+	_, err := db.Exec(q, tenant(), plugin, uid, invocations, spend)
+	return err
+}
+
+// RecordInvocation meters a plugin being called for a user.
+func RecordInvocation(plugin string, uid uint64) error {
+	if err := recordUsage(plugin, uid, 1, 0); err != nil {
+		return err
+	}
+	emitBillingEvent(&BillingEvent{
+		Type:      BillingInvocation,
+		Tenant:    tenant(),
+		Plugin:    plugin,
+		UserID:    uid,
+		Amount:    1,
+		CreatedAt: time.Now(),
+	})
+	return nil
+}
+
+// RecordSpend meters money a plugin spent on an external API on behalf of a
+// user, in millionths of a dollar, e.g. 5000 for a $0.005 API call.
+func RecordSpend(plugin string, uid uint64, micros int64, note string) error {
+	if err := recordUsage(plugin, uid, 0, micros); err != nil {
+		return err
+	}
+	emitBillingEvent(&BillingEvent{
+		Type:      BillingSpend,
+		Tenant:    tenant(),
+		Plugin:    plugin,
+		UserID:    uid,
+		Amount:    micros,
+		Note:      note,
+		CreatedAt: time.Now(),
+	})
+	return nil
+}
+
+// quotaSetting returns a quota configured in settings, or 0 if the quota is
+// unset or invalid, meaning unlimited.
+func quotaSetting(name string) int64 {
+	s := Setting(name)
+	if len(s) == 0 {
+		return 0
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		log.Info("invalid quota", name, s)
+		return 0
+	}
+	return n
+}
+
+// OverQuota reports whether a plugin has hit a quota for a user. Operators set
+// quotas as settings: plugin_{name}_daily_quota limits each user's
+// invocations per day, and plugin_{name}_monthly_spend_quota limits the
+// plugin's spend across all users this month in millionths of a dollar.
+func OverQuota(plugin string, uid uint64) (bool, error) {
+	if daily := quotaSetting("plugin_" + plugin + "_daily_quota"); daily > 0 {
+		var n int64
+		q := `SELECT COALESCE(SUM(invocations), 0) FROM pluginusage
+		      WHERE tenant=$1 AND pluginname=$2 AND userid=$3
+		        AND day=CURRENT_DATE`
+		if err := db.Get(&n, q, tenant(), plugin, uid); err != nil {
+			return false, err
+		}
+		if n >= daily {
+			return true, nil
+		}
+	}
+	spend := quotaSetting("plugin_" + plugin + "_monthly_spend_quota")
+	if spend > 0 {
+		var n int64
+		q := `SELECT COALESCE(SUM(spendmicros), 0) FROM pluginusage
+		      WHERE tenant=$1 AND pluginname=$2
+		        AND day>=date_trunc('month', CURRENT_DATE)`
+		if err := db.Get(&n, q, tenant(), plugin); err != nil {
+			return false, err
+		}
+		if n >= spend {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkQuota meters a plugin call for a user, returning false if the call
+// would exceed a quota. Metering errors are logged and the call allowed, so
+// an outage of usage tracking doesn't take down every plugin.
+func checkQuota(p *dt.Plugin, u *dt.User) bool {
+	over, err := OverQuota(p.Config.Name, u.ID)
+	if err != nil {
+		log.Info("failed to check quota", p.Config.Name, err)
+		return true
+	}
+	if over {
+		emitBillingEvent(&BillingEvent{
+			Type:      BillingQuotaExceeded,
+			Tenant:    tenant(),
+			Plugin:    p.Config.Name,
+			UserID:    u.ID,
+			CreatedAt: time.Now(),
+		})
+		return false
+	}
+	if err = RecordInvocation(p.Config.Name, u.ID); err != nil {
+		log.Info("failed to record invocation", p.Config.Name, err)
+	}
+	return true
+}
+
+// usageThisMonth summarizes each plugin's usage this month for Abot's
+// analytics.
+func usageThisMonth() (interface{}, error) {
+	var rows []struct {
+		PluginName  string
+		Invocations int64
+		SpendMicros int64
+	}
+	q := `SELECT pluginname, SUM(invocations) AS invocations,
+	          SUM(spendmicros) AS spendmicros
+	      FROM pluginusage
+	      WHERE tenant=$1 AND day>=date_trunc('month', CURRENT_DATE)
+	      GROUP BY pluginname
+	      ORDER BY pluginname`
+	if err := db.Select(&rows, q, tenant()); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,192 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// codeTTL is how long a user has to send back a one-time code.
+const codeTTL = 10 * time.Minute
+
+// maxCodeAttempts is how many wrong codes a user may send before a
+// verification is cancelled, so codes can't be guessed.
+const maxCodeAttempts = 5
+
+// ErrNoVerification is returned when checking a code with no verification
+// awaiting one, including after it's expired or had too many wrong codes.
+var ErrNoVerification = errors.New("no pending verification")
+
+// verification is a one-time code a plugin awaits from a user.
+type verification struct {
+	ID         uint64
+	PluginName string
+	Route      string
+	CodeHash   string
+	Attempts   int
+
+	// FlexID and FlexIDType are the channel the code was sent to when
+	// linking users' accounts. See StartLink.
+	FlexID     string
+	FlexIDType dt.FlexIDType
+}
+
+// StartVerification creates a six-digit one-time code for a plugin to send the user,
+// e.g. by SMS to confirm their phone number, replacing any code the plugin
+// was already awaiting. When the user sends the code back, in any format,
+// like "my code is 489 221," their message is routed to the plugin on the
+// given route, with the code in StructuredInput.Code. Check it with
+// VerifyCode. Codes expire after 10 minutes.
+func StartVerification(uid uint64, pluginName, route string) (string,
+	error) {
+
+	return startVerification(uid, pluginName, route, "", 0)
+}
+
+// startVerification creates a one-time code, recording the FlexID it's sent
+// to, if any.
+func startVerification(uid uint64, pluginName, route, fid string,
+	fidT dt.FlexIDType) (string, error) {
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	q := `DELETE FROM verifications
+	      WHERE userid=$1 AND pluginname=$2 AND verifiedat IS NULL`
+	if _, err = tx.Exec(q, uid, pluginName); err != nil {
+		_ = tx.Rollback()
+		return "", err
+	}
+	q = `INSERT INTO verifications
+	     (userid, pluginname, route, codehash, expiresat, flexid, flexidtype)
+	     VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.Exec(q, uid, pluginName, route, hashCode(code),
+		time.Now().Add(codeTTL), fid, fidT)
+	if err != nil {
+		_ = tx.Rollback()
+		return "", err
+	}
+	return code, tx.Commit()
+}
+
+// VerifyCode checks a code the user sent against the one a plugin awaits,
+// counting wrong codes toward maxCodeAttempts. ErrNoVerification is returned
+// if the plugin awaits no code from the user.
+func VerifyCode(uid uint64, pluginName, code string) (bool, error) {
+	_, ok, err := checkCode(uid, pluginName, code)
+	return ok, err
+}
+
+// checkCode checks a code like VerifyCode, also returning the verification
+// it was checked against.
+func checkCode(uid uint64, pluginName, code string) (*verification, bool,
+	error) {
+
+	v, err := pendingVerification(uid, pluginName)
+	if err != nil {
+		return nil, false, err
+	}
+	if v == nil {
+		return nil, false, ErrNoVerification
+	}
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+	if hmac.Equal([]byte(hashCode(code)), []byte(v.CodeHash)) {
+		q := `UPDATE verifications SET verifiedat=CURRENT_TIMESTAMP
+		      WHERE id=$1`
+		_, err = db.Exec(q, v.ID)
+		return v, err == nil, err
+	}
+	q := `UPDATE verifications SET attempts=attempts+1 WHERE id=$1`
+	_, err = db.Exec(q, v.ID)
+	return v, false, err
+}
+
+// pendingVerification returns the verification a plugin awaits from a user,
+// or nil if there's none. An empty pluginName returns the user's most recent
+// verification from any plugin.
+func pendingVerification(uid uint64, pluginName string) (*verification,
+	error) {
+
+	q := `SELECT id, pluginname, route, codehash, attempts, flexid,
+	          flexidtype
+	      FROM verifications
+	      WHERE userid=$1 AND ($2='' OR pluginname=$2)
+	          AND verifiedat IS NULL AND expiresat>$3 AND attempts<$4
+	      ORDER BY id DESC
+	      LIMIT 1`
+	v := &verification{}
+	err := db.Get(v, q, uid, pluginName, time.Now(), maxCodeAttempts)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// hashCode hashes a one-time code, so codes aren't stored in the clear.
+func hashCode(code string) string {
+	h := hmac.New(sha256.New, authSecret())
+	_, _ = h.Write([]byte(code))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// codeStage sets aside a one-time code sent while a plugin awaits one, or
+// checks one sent to answer a challenge, removing it from the sentence so it
+// isn't classified or saved.
+func codeStage(in *dt.Msg) error {
+	if in.User == nil || !in.User.Registered() {
+		return nil
+	}
+	code, found := nlp.ExtractCode(in.Sentence)
+	if len(code) == 0 {
+		return nil
+	}
+	// Codes answering a challenge are checked here, so plugins never see
+	// them. The plugin learns the result by calling dt.ChallengeUser
+	// again.
+	c, err := pendingChallenge(in.User.ID)
+	if err != nil {
+		log.Info("failed to get pending challenge", err)
+		return nil
+	}
+	if c != nil {
+		if _, err = answerChallenge(in.User.ID, code); err != nil {
+			log.Info("failed to answer challenge", err)
+		}
+		s := strings.Replace(in.Sentence, found, "", 1)
+		in.Sentence = strings.TrimSpace(regexSpaces.ReplaceAllString(s,
+			" "))
+		return nil
+	}
+	v, err := pendingVerification(in.User.ID, "")
+	if err != nil {
+		log.Info("failed to get pending verification", err)
+		return nil
+	}
+	if v == nil {
+		return nil
+	}
+	in.StructuredInput.Code = code
+	s := strings.Replace(in.Sentence, found, "", 1)
+	in.Sentence = strings.TrimSpace(regexSpaces.ReplaceAllString(s, " "))
+	return nil
+}
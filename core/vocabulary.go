@@ -0,0 +1,382 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// vocabConfidence is the confidence of words classified by vocabulary packs,
+// which operators install deliberately for their users' jargon.
+const vocabConfidence = 1
+
+// ErrInvalidVocabPack is returned when installing a vocabulary pack that's
+// missing a name or terms, or has a trigger that isn't a route.
+var ErrInvalidVocabPack = errors.New("invalid vocabulary pack")
+
+// ErrVocabPackNotFound is returned when uninstalling a vocabulary pack that
+// isn't installed.
+var ErrVocabPackNotFound = errors.New("vocabulary pack not found")
+
+// VocabPack extends the words Abot understands with a tenant's industry
+// jargon, product names and branded commands. Packs are installed for the
+// tenant named by ABOT_TENANT, so servers hosting other tenants on the same
+// database are unaffected. Words and phrases are matched ignoring case.
+type VocabPack struct {
+	ID   uint64
+	Name string
+
+	// Gazetteers list the names of things by the object they're
+	// classified as, e.g. "product": ["widget pro", "widget mini"], so
+	// "reorder the widget pro" has the object "product."
+	Gazetteers map[string][]string
+
+	// Synonyms rewrite words and phrases before they're classified, e.g.
+	// "po": "purchase order."
+	Synonyms map[string]string
+
+	// Triggers route words and phrases, like branded commands, to a
+	// plugin's route in the form command_object, e.g. "restock":
+	// "order_inventory."
+	Triggers map[string]string
+
+	CreatedAt time.Time
+}
+
+// vocabPhrase is a word or phrase from a vocabulary pack, tokenized to match
+// a message's tokens.
+type vocabPhrase struct {
+	words []string
+
+	// replacement is a synonym's tokens.
+	replacement []string
+
+	// classes are those of a gazetteer entry or trigger.
+	classes []nlp.WordClass
+}
+
+// vocabulary is the installed packs compiled for matching.
+type vocabulary struct {
+	synonyms []vocabPhrase
+	phrases  []vocabPhrase
+
+	// known are the words of every phrase, which Spellcheck leaves alone.
+	known map[string]struct{}
+}
+
+var vocab = &vocabulary{}
+var vocabMu sync.RWMutex
+
+// validate normalizes a pack, checking that it can be compiled.
+func (p *VocabPack) validate() error {
+	p.Name = dt.NormalizeTag(p.Name)
+	if len(p.Name) == 0 {
+		return ErrInvalidVocabPack
+	}
+	if len(p.Gazetteers)+len(p.Synonyms)+len(p.Triggers) == 0 {
+		return fmt.Errorf("%s: %q has no terms", ErrInvalidVocabPack, p.Name)
+	}
+	for phrase, route := range p.Triggers {
+		parts := strings.SplitN(route, "_", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return fmt.Errorf("%s: trigger %q has route %q",
+				ErrInvalidVocabPack, phrase, route)
+		}
+	}
+	return nil
+}
+
+// VocabPacks returns the vocabulary packs installed for this tenant, in the
+// order they were installed.
+func VocabPacks() ([]VocabPack, error) {
+	q := `SELECT id, name, gazetteers, synonyms, triggers, createdat
+	      FROM vocabpacks
+	      WHERE tenant=$1
+	      ORDER BY id`
+	var rows []struct {
+		ID         uint64
+		Name       string
+		Gazetteers []byte
+		Synonyms   []byte
+		Triggers   []byte
+		CreatedAt  time.Time
+	}
+	if err := db.Select(&rows, q, tenant()); err != nil {
+		return nil, err
+	}
+	ps := make([]VocabPack, len(rows))
+	for i, r := range rows {
+		ps[i] = VocabPack{ID: r.ID, Name: r.Name, CreatedAt: r.CreatedAt}
+		if err := json.Unmarshal(r.Gazetteers, &ps[i].Gazetteers); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(r.Synonyms, &ps[i].Synonyms); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(r.Triggers, &ps[i].Triggers); err != nil {
+			return nil, err
+		}
+	}
+	return ps, nil
+}
+
+// InstallVocabPack installs a vocabulary pack for this tenant, replacing any
+// installed pack of the same name. It takes effect immediately.
+func InstallVocabPack(p *VocabPack) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	gazetteers, err := json.Marshal(p.Gazetteers)
+	if err != nil {
+		return err
+	}
+	synonyms, err := json.Marshal(p.Synonyms)
+	if err != nil {
+		return err
+	}
+	triggers, err := json.Marshal(p.Triggers)
+	if err != nil {
+		return err
+	}
+	q := `INSERT INTO vocabpacks
+	      (tenant, name, gazetteers, synonyms, triggers)
+	      VALUES ($1, $2, $3, $4, $5)
+	      ON CONFLICT (tenant, name) DO UPDATE
+	      SET gazetteers=$3, synonyms=$4, triggers=$5,
+	          createdat=CURRENT_TIMESTAMP
+	      RETURNING id, createdat`
+	err = db.QueryRowx(q, tenant(), p.Name, gazetteers, synonyms,
+		triggers).Scan(&p.ID, &p.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return loadVocabulary()
+}
+
+// UninstallVocabPack removes one of this tenant's vocabulary packs by name.
+func UninstallVocabPack(name string) error {
+	q := `DELETE FROM vocabpacks WHERE tenant=$1 AND name=$2`
+	res, err := db.Exec(q, tenant(), dt.NormalizeTag(name))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVocabPackNotFound
+	}
+	return loadVocabulary()
+}
+
+// loadVocabulary compiles the vocabulary packs installed for this tenant.
+func loadVocabulary() error {
+	ps, err := VocabPacks()
+	if err != nil {
+		return err
+	}
+	v := compileVocabulary(ps)
+	vocabMu.Lock()
+	vocab = v
+	vocabMu.Unlock()
+	return nil
+}
+
+// compileVocabulary tokenizes the terms of vocabulary packs. Packs installed
+// later take precedence where their phrases are the same length.
+func compileVocabulary(ps []VocabPack) *vocabulary {
+	v := &vocabulary{known: map[string]struct{}{}}
+	for i := len(ps) - 1; i >= 0; i-- {
+		p := ps[i]
+		for name, entries := range p.Gazetteers {
+			obj := dt.NormalizeTag(name)
+			for _, e := range entries {
+				v.addPhrase(e, nil, []nlp.WordClass{{Word: obj,
+					Class: nlp.ObjectI, Confidence: vocabConfidence}})
+			}
+		}
+		for phrase, route := range p.Triggers {
+			parts := strings.SplitN(strings.ToLower(route), "_", 2)
+			v.addPhrase(phrase, nil, []nlp.WordClass{
+				{Word: parts[0], Class: nlp.CommandI,
+					Confidence: vocabConfidence},
+				{Word: parts[1], Class: nlp.ObjectI,
+					Confidence: vocabConfidence},
+			})
+		}
+		for phrase, replacement := range p.Synonyms {
+			v.addPhrase(phrase, nlp.TokenizeSentence(replacement), nil)
+		}
+	}
+	return v
+}
+
+func (v *vocabulary) addPhrase(phrase string, replacement []string,
+	classes []nlp.WordClass) {
+
+	var words []string
+	for _, w := range nlp.TokenizeSentence(phrase) {
+		words = append(words, strings.ToLower(w))
+	}
+	if len(words) == 0 {
+		return
+	}
+	vp := vocabPhrase{words: words, replacement: replacement,
+		classes: classes}
+	if classes == nil {
+		v.synonyms = append(v.synonyms, vp)
+	} else {
+		v.phrases = append(v.phrases, vp)
+	}
+	for _, w := range words {
+		v.known[w] = struct{}{}
+	}
+}
+
+// longestPhrase returns the longest of ps that tokens begin with, or nil if
+// none match.
+func longestPhrase(tokens []string, ps []vocabPhrase) *vocabPhrase {
+	var longest *vocabPhrase
+	for i := range ps {
+		if longest != nil && len(ps[i].words) <= len(longest.words) {
+			continue
+		}
+		if matchesPhrase(tokens, ps[i].words) {
+			longest = &ps[i]
+		}
+	}
+	return longest
+}
+
+// vocabStage rewrites the synonyms of this tenant's vocabulary packs in the
+// message's tokens, preferring the longest phrase that matches, so "submit a
+// po" becomes "submit a purchase order."
+func vocabStage(in *dt.Msg) error {
+	vocabMu.RLock()
+	v := vocab
+	vocabMu.RUnlock()
+	if len(v.synonyms) == 0 {
+		return nil
+	}
+	tokens := make([]string, 0, len(in.Tokens))
+	for i := 0; i < len(in.Tokens); {
+		p := longestPhrase(in.Tokens[i:], v.synonyms)
+		if p == nil {
+			tokens = append(tokens, in.Tokens[i])
+			i++
+			continue
+		}
+		tokens = append(tokens, p.replacement...)
+		i += len(p.words)
+	}
+	in.Tokens = tokens
+	return nil
+}
+
+// classifyVocabulary returns the classes of the gazetteer entries and triggers
+// of this tenant's vocabulary packs found in a tokenized sentence, preferring
+// the longest phrase that matches.
+func classifyVocabulary(tokens []string) []nlp.WordClass {
+	vocabMu.RLock()
+	v := vocab
+	vocabMu.RUnlock()
+	var wc []nlp.WordClass
+	for i := 0; i < len(tokens); {
+		p := longestPhrase(tokens[i:], v.phrases)
+		if p == nil {
+			i++
+			continue
+		}
+		wc = append(wc, p.classes...)
+		i += len(p.words)
+	}
+	return wc
+}
+
+// withVocabulary adds the classes found by this tenant's vocabulary packs to
+// a classified sentence. They go first, since operators installed them to
+// route their users' jargon.
+func withVocabulary(si *nlp.StructuredInput, tokens []string) *nlp.StructuredInput {
+	wc := classifyVocabulary(tokens)
+	if len(wc) == 0 {
+		return si
+	}
+	out := &nlp.StructuredInput{}
+	_ = out.Add(wc)
+	_ = out.Add(si.Classes)
+	return out
+}
+
+// knownVocabulary reports whether a word is in this tenant's vocabulary
+// packs.
+func knownVocabulary(w string) bool {
+	vocabMu.RLock()
+	defer vocabMu.RUnlock()
+	_, ok := vocab.known[w]
+	return ok
+}
+
+// HAPIVocabPacks responds with the vocabulary packs installed for this tenant.
+func HAPIVocabPacks(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, false) {
+		return
+	}
+	ps, err := VocabPacks()
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, struct{ Packs []VocabPack }{Packs: ps})
+}
+
+// HAPIVocabPacksSubmit installs a vocabulary pack, replacing any of the same
+// name.
+func HAPIVocabPacksSubmit(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct{ Pack VocabPack }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err := req.Pack.validate(); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err := InstallVocabPack(&req.Pack); err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	writeBytes(w, req.Pack)
+}
+
+// HAPIVocabPacksUninstall uninstalls a vocabulary pack by name.
+func HAPIVocabPacksUninstall(w http.ResponseWriter, r *http.Request) {
+	if !adminGuard(w, r, true) {
+		return
+	}
+	var req struct{ Name string }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	err := UninstallVocabPack(req.Name)
+	if err == ErrVocabPackNotFound {
+		writeErrorBadRequest(w, err)
+		return
+	}
+	if err != nil {
+		writeErrorInternal(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
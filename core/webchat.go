@@ -0,0 +1,368 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"golang.org/x/net/websocket"
+)
+
+// ChannelWebChat is the channel of browsers chatting with Abot through the
+// websocket at /api/webchat, e.g. from a widget embedded in a website. It
+// displays what the web console does.
+const ChannelWebChat = "webchat"
+
+// Types of frames sent over a web chat websocket, each a JSON WebChatFrame.
+const (
+	// WebChatSession is sent by Abot when a browser connects, carrying
+	// the session it should reconnect with.
+	WebChatSession = "session"
+
+	// WebChatMessage is a message from the browser or from Abot.
+	WebChatMessage = "message"
+
+	// WebChatReceipt acknowledges a message by its ID. Abot sends one
+	// for each message it receives, and browsers should send one for
+	// each message they display. Abot's messages are sent again when
+	// the browser reconnects until they're acknowledged.
+	WebChatReceipt = "receipt"
+
+	// WebChatTyping is sent by Abot while it works on a reply, and by
+	// browsers while their user types.
+	WebChatTyping = "typing"
+)
+
+// maxPendingWebChat is the most unacknowledged messages kept for a web chat
+// session. Older messages are dropped.
+const maxPendingWebChat = 20
+
+// webChatWriteTimeout is how long a frame may take to reach a browser, so
+// one that stalls doesn't hold up the session's other tabs.
+const webChatWriteTimeout = 10 * time.Second
+
+// webChatIdle is how long a disconnected web chat session's unacknowledged
+// messages are kept for it to reconnect.
+const webChatIdle = 24 * time.Hour
+
+// ErrWebChatOffline is returned when sending a message to a web chat session
+// with no browser connected, so scheduled events are retried.
+var ErrWebChatOffline = errors.New("web chat session not connected")
+
+// WebChatFrame is a frame sent over a web chat websocket. Which fields are
+// set depends on its Type.
+type WebChatFrame struct {
+	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
+	Session string `json:"session,omitempty"`
+	Text    string `json:"text,omitempty"`
+	Typing  bool   `json:"typing"`
+}
+
+// webChatSession is a browser's conversation with Abot, which may be open in
+// several tabs at once. Abot's messages are kept until acknowledged.
+type webChatSession struct {
+	mu       sync.Mutex
+	conns    map[*websocket.Conn]struct{}
+	pending  []WebChatFrame
+	nextID   uint64
+	lastSeen time.Time
+}
+
+var webChatSessions = map[string]*webChatSession{}
+var webChatMu sync.Mutex
+
+// webChatFlexIDType identifies web chat sessions, registered at boot.
+var webChatFlexIDType dt.FlexIDType
+
+func init() {
+	RegisterJob("webchat_sessions", time.Hour, pruneWebChatSessions)
+}
+
+// registerWebChat adds the FlexIDType of web chat sessions, so messages Abot
+// sends on its own, like reminders and operators' replies, reach browsers
+// connected to the websocket.
+func registerWebChat() error {
+	ch := dt.Channels[dt.ChannelWeb]
+	ch.Name = ChannelWebChat
+	var err error
+	webChatFlexIDType, err = dt.RegisterFlexIDType(db, dt.FlexIDTypeDef{
+		Name:    ChannelWebChat,
+		Channel: ch,
+		Send:    sendWebChat,
+	})
+	return err
+}
+
+// sendWebChat delivers a message to every browser connected to a web chat
+// session.
+func sendWebChat(session, content string) error {
+	webChatMu.Lock()
+	s, ok := webChatSessions[session]
+	webChatMu.Unlock()
+	if !ok {
+		return ErrWebChatOffline
+	}
+	return s.send(content)
+}
+
+// HAPIWebChat connects a browser to Abot over a websocket, exchanging JSON
+// WebChatFrames. Browsers resume a conversation by passing the session they
+// were sent when first connecting as the session query parameter. Sessions
+// are anonymous until linked to a user like any other FlexID. If the
+// webchat_origins setting lists origins, separated by commas, only websites
+// at those origins may connect.
+func HAPIWebChat(w http.ResponseWriter, r *http.Request) {
+	session := r.URL.Query().Get("session")
+	if !validWebChatSession(session) {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			writeErrorInternal(w, err)
+			return
+		}
+		session = hex.EncodeToString(b)
+	}
+	srv := websocket.Server{
+		Handshake: checkWebChatOrigin,
+		Handler: func(conn *websocket.Conn) {
+			chatOverWebSocket(conn, session)
+		},
+	}
+	srv.ServeHTTP(w, r)
+}
+
+// checkWebChatOrigin rejects websockets opened from websites missing from the
+// webchat_origins setting, if set.
+func checkWebChatOrigin(config *websocket.Config, r *http.Request) error {
+	var err error
+	config.Origin, err = websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	s := Setting("webchat_origins")
+	if len(s) == 0 {
+		return nil
+	}
+	if config.Origin == nil {
+		return errors.New("missing origin")
+	}
+	for _, o := range strings.Split(s, ",") {
+		u, err := url.Parse(strings.TrimSpace(o))
+		if err != nil {
+			continue
+		}
+		if u.Scheme == config.Origin.Scheme && u.Host == config.Origin.Host {
+			return nil
+		}
+	}
+	return errors.New("origin not allowed: " + config.Origin.String())
+}
+
+// chatOverWebSocket relays a browser's messages to Abot until it disconnects.
+// Messages are processed one at a time in the order they're sent.
+func chatOverWebSocket(conn *websocket.Conn, session string) {
+	s := attachWebChat(session, conn)
+	defer detachWebChat(session, s, conn)
+	for {
+		var f WebChatFrame
+		if err := websocket.JSON.Receive(conn, &f); err != nil {
+			return
+		}
+		switch f.Type {
+		case WebChatMessage:
+			if len(strings.TrimSpace(f.Text)) == 0 {
+				continue
+			}
+			s.write(WebChatFrame{Type: WebChatReceipt, ID: f.ID})
+			s.write(WebChatFrame{Type: WebChatTyping, Typing: true})
+			ret := processWebChat(session, f.Text)
+			s.write(WebChatFrame{Type: WebChatTyping, Typing: false})
+			if len(ret) > 0 {
+				if err := s.send(ret); err != nil {
+					log.Debug("failed to send web chat reply", err)
+				}
+			}
+		case WebChatReceipt:
+			s.ack(f.ID)
+		case WebChatTyping:
+			if f.Typing {
+				observeWebChatTyping(session)
+			}
+		}
+	}
+}
+
+// processWebChat processes a message from a web chat session as it would one
+// posted to HMain, returning Abot's reply.
+func processWebChat(session, text string) string {
+	body, err := json.Marshal(&dt.Request{
+		CMD:        text,
+		FlexID:     session,
+		FlexIDType: webChatFlexIDType,
+	})
+	if err != nil {
+		log.Info("failed to encode web chat message", err)
+		return errMsg
+	}
+	ret, uid, err := processWithRetries(body)
+	if err != nil {
+		ret = errMsg
+	}
+	progress.done(uid)
+	return ret
+}
+
+// observeWebChatTyping tells observers following a web chat session's user
+// that they're typing, e.g. so operators relaying for Abot wait to reply.
+func observeWebChatTyping(session string) {
+	if !observing() {
+		return
+	}
+	u, err := dt.GetUser(db, &dt.Request{
+		FlexID:     session,
+		FlexIDType: webChatFlexIDType,
+	})
+	if err != nil {
+		log.Debug("failed to get web chat user", err)
+		return
+	}
+	observeTyping(u.ID)
+}
+
+// attachWebChat adds a browser's websocket to its session, sending it the
+// session and any messages the session hasn't acknowledged.
+func attachWebChat(session string, conn *websocket.Conn) *webChatSession {
+	// The websocket is added before webChatMu is released, so the session
+	// isn't forgotten by its last other tab disconnecting meanwhile.
+	webChatMu.Lock()
+	s, ok := webChatSessions[session]
+	if !ok {
+		s = &webChatSession{conns: map[*websocket.Conn]struct{}{}}
+		webChatSessions[session] = s
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = struct{}{}
+	s.lastSeen = time.Now()
+	webChatMu.Unlock()
+
+	err := sendWebChatFrame(conn, WebChatFrame{
+		Type:    WebChatSession,
+		Session: session,
+	})
+	if err != nil {
+		log.Debug("failed to send web chat session", err)
+	}
+	for _, f := range s.pending {
+		if err = sendWebChatFrame(conn, f); err != nil {
+			log.Debug("failed to resend web chat message", err)
+			break
+		}
+	}
+	return s
+}
+
+// detachWebChat removes a browser's websocket from its session, forgetting
+// the session once no browser is connected and nothing is left to deliver.
+func detachWebChat(session string, s *webChatSession, conn *websocket.Conn) {
+	webChatMu.Lock()
+	defer webChatMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+	s.lastSeen = time.Now()
+	if len(s.conns) == 0 && len(s.pending) == 0 {
+		delete(webChatSessions, session)
+	}
+}
+
+// send delivers a message from Abot to every browser connected to the
+// session, keeping it until a browser acknowledges it.
+func (s *webChatSession) send(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.conns) == 0 {
+		return ErrWebChatOffline
+	}
+	s.nextID++
+	f := WebChatFrame{
+		Type: WebChatMessage,
+		ID:   strconv.FormatUint(s.nextID, 10),
+		Text: text,
+	}
+	s.pending = append(s.pending, f)
+	if len(s.pending) > maxPendingWebChat {
+		s.pending = s.pending[len(s.pending)-maxPendingWebChat:]
+	}
+	s.writeLocked(f)
+	return nil
+}
+
+// write sends a frame to every browser connected to the session without
+// keeping it, as for receipts and typing indicators.
+func (s *webChatSession) write(f WebChatFrame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeLocked(f)
+}
+
+func (s *webChatSession) writeLocked(f WebChatFrame) {
+	for conn := range s.conns {
+		if err := sendWebChatFrame(conn, f); err != nil {
+			log.Debug("failed to write to web chat", err)
+		}
+	}
+}
+
+// sendWebChatFrame sends a frame to a browser, giving up after
+// webChatWriteTimeout.
+func sendWebChatFrame(conn *websocket.Conn, f WebChatFrame) error {
+	err := conn.SetWriteDeadline(time.Now().Add(webChatWriteTimeout))
+	if err != nil {
+		return err
+	}
+	return websocket.JSON.Send(conn, f)
+}
+
+// ack forgets a message a browser has displayed.
+func (s *webChatSession) ack(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.pending {
+		if f.ID == id {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// pruneWebChatSessions forgets the unacknowledged messages of sessions
+// disconnected for longer than webChatIdle.
+func pruneWebChatSessions() error {
+	webChatMu.Lock()
+	defer webChatMu.Unlock()
+	for session, s := range webChatSessions {
+		s.mu.Lock()
+		if len(s.conns) == 0 && time.Since(s.lastSeen) > webChatIdle {
+			delete(webChatSessions, session)
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// validWebChatSession reports whether a session could have been issued by
+// HAPIWebChat.
+func validWebChatSession(session string) bool {
+	b, err := hex.DecodeString(session)
+	return err == nil && len(b) == 24
+}
@@ -0,0 +1,3 @@
+DROP TABLE briefings;
+ALTER TABLE users DROP COLUMN quiethoursend;
+ALTER TABLE users DROP COLUMN quiethoursstart;
@@ -0,0 +1,2 @@
+DROP TABLE surveyresponses;
+DROP TABLE surveys;
@@ -0,0 +1,2 @@
+DROP INDEX locations_userid_idx;
+ALTER TABLE locations DROP COLUMN userid;
@@ -0,0 +1,2 @@
+DROP INDEX usertags_tag_idx;
+DROP TABLE usertags;
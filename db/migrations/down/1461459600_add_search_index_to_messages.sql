@@ -0,0 +1,2 @@
+DROP INDEX messages_userid_createdat_idx;
+DROP INDEX messages_sentence_search_idx;
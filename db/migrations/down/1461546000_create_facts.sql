@@ -0,0 +1,3 @@
+DROP INDEX facts_content_search_idx;
+DROP INDEX facts_userid_idx;
+DROP TABLE facts;
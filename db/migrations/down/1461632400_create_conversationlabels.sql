@@ -0,0 +1,3 @@
+DROP INDEX conversationlabels_open_idx;
+DROP INDEX conversationlabels_label_idx;
+DROP TABLE conversationlabels;
@@ -0,0 +1,3 @@
+DROP INDEX linkclicks_linkid_idx;
+DROP TABLE linkclicks;
+DROP TABLE links;
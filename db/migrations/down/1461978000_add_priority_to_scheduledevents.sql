@@ -0,0 +1,3 @@
+DROP TABLE notificationpolicies;
+ALTER TABLE scheduledevents DROP COLUMN category;
+ALTER TABLE scheduledevents DROP COLUMN priority;
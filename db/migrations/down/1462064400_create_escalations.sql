@@ -0,0 +1,3 @@
+DROP INDEX escalations_scheduledeventid_idx;
+DROP TABLE escalations;
+ALTER TABLE scheduledevents DROP COLUMN sentat;
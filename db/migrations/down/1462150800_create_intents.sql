@@ -0,0 +1,2 @@
+DROP INDEX intents_userid_key_idx;
+DROP TABLE intents;
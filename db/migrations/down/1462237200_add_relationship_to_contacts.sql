@@ -0,0 +1,2 @@
+DROP INDEX contacts_relationship_idx;
+ALTER TABLE contacts DROP COLUMN relationship;
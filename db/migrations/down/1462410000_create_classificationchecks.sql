@@ -0,0 +1,2 @@
+DROP INDEX classificationchecks_checkedat_idx;
+DROP TABLE classificationchecks;
@@ -0,0 +1,2 @@
+DROP INDEX shadowparses_parser_createdat_idx;
+DROP TABLE shadowparses;
@@ -0,0 +1,2 @@
+DROP INDEX conversationvars_expiresat_idx;
+DROP TABLE conversationvars;
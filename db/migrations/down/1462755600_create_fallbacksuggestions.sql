@@ -0,0 +1,2 @@
+DROP INDEX fallbacksuggestions_userid_createdat_idx;
+DROP TABLE fallbacksuggestions;
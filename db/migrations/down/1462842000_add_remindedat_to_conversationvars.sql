@@ -0,0 +1 @@
+ALTER TABLE conversationvars DROP COLUMN remindedat;
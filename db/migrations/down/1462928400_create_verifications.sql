@@ -0,0 +1,2 @@
+DROP INDEX verifications_userid_idx;
+DROP TABLE verifications;
@@ -0,0 +1 @@
+ALTER TABLE contextentities DROP COLUMN flexidtype;
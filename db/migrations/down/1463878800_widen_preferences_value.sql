@@ -0,0 +1,2 @@
+DROP INDEX preferences_userid_pkgname_key_idx;
+ALTER TABLE preferences ALTER COLUMN value TYPE VARCHAR(255) USING SUBSTRING(value FOR 255);
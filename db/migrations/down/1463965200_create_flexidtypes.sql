@@ -0,0 +1,2 @@
+DROP INDEX userflexids_flexid_flexidtype_key;
+DROP TABLE flexidtypes;
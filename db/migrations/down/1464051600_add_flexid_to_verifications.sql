@@ -0,0 +1,2 @@
+ALTER TABLE verifications DROP COLUMN flexidtype;
+ALTER TABLE verifications DROP COLUMN flexid;
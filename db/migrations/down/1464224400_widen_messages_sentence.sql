@@ -0,0 +1 @@
+ALTER TABLE messages ALTER COLUMN sentence TYPE VARCHAR(255) USING SUBSTRING(sentence FOR 255);
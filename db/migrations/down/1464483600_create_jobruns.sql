@@ -0,0 +1,2 @@
+ALTER TABLE scheduledevents DROP COLUMN deadat;
+DROP TABLE jobruns;
@@ -0,0 +1,3 @@
+DROP TABLE challengeattempts;
+DROP TABLE challenges;
+DROP TABLE totpsecrets;
@@ -0,0 +1,3 @@
+ALTER TABLE scheduledevents DROP COLUMN lasterror;
+ALTER TABLE scheduledevents DROP COLUMN attempts;
+DROP TABLE inbounddeadletters;
@@ -0,0 +1,6 @@
+ALTER TABLE callbacks DROP COLUMN outcome;
+ALTER TABLE callbacks DROP COLUMN completedby;
+ALTER TABLE callbacks DROP COLUMN claimedat;
+ALTER TABLE callbacks DROP COLUMN claimedby;
+ALTER TABLE callbacks DROP COLUMN preferredat;
+ALTER TABLE callbacks DROP COLUMN followupid;
@@ -0,0 +1,14 @@
+CREATE TABLE meetingrequests (
+	id SERIAL,
+	userid INTEGER NOT NULL,
+	contactid INTEGER NOT NULL,
+	flexid VARCHAR(255) NOT NULL,
+	flexidtype INTEGER NOT NULL,
+	title VARCHAR(255) NOT NULL,
+	durationinmins INTEGER NOT NULL,
+	proposedtimes JSONB NOT NULL,
+	scheduledat TIMESTAMP,
+	declined BOOLEAN NOT NULL DEFAULT FALSE,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE shipments (
+	id SERIAL,
+	userid INTEGER NOT NULL,
+	carrier VARCHAR(255) NOT NULL,
+	trackingnumber VARCHAR(255) NOT NULL,
+	status VARCHAR(255) NOT NULL DEFAULT '',
+	delivered BOOLEAN NOT NULL DEFAULT FALSE,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	updatedat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	UNIQUE (userid, trackingnumber),
+	PRIMARY KEY (id)
+);
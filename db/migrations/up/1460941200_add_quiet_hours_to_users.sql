@@ -0,0 +1,9 @@
+ALTER TABLE users ADD COLUMN quiethoursstart INTEGER;
+ALTER TABLE users ADD COLUMN quiethoursend INTEGER;
+CREATE TABLE briefings (
+	userid INTEGER NOT NULL,
+	sendat INTEGER NOT NULL,
+	lastsentat TIMESTAMP,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (userid)
+);
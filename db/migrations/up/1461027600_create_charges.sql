@@ -0,0 +1,14 @@
+CREATE TABLE charges (
+	id SERIAL,
+	userid INTEGER NOT NULL,
+	cardid INTEGER NOT NULL,
+	amount INTEGER NOT NULL,
+	refundedamount INTEGER NOT NULL DEFAULT 0,
+	currency VARCHAR(3) NOT NULL,
+	description VARCHAR(255) NOT NULL,
+	servicechargeid VARCHAR(255) NOT NULL UNIQUE,
+	disputedat TIMESTAMP,
+	disputereason VARCHAR(255) NOT NULL DEFAULT '',
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
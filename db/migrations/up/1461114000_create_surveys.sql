@@ -0,0 +1,20 @@
+CREATE TABLE surveys (
+	id SERIAL,
+	userid INTEGER NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	question INTEGER NOT NULL DEFAULT 0,
+	sendat TIMESTAMP NOT NULL,
+	sentat TIMESTAMP,
+	completedat TIMESTAMP,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE TABLE surveyresponses (
+	id SERIAL,
+	surveyid INTEGER NOT NULL,
+	question INTEGER NOT NULL,
+	rating INTEGER,
+	answer TEXT NOT NULL DEFAULT '',
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE locations ADD COLUMN userid INTEGER;
+CREATE INDEX locations_userid_idx ON locations (userid, createdat);
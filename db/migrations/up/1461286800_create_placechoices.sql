@@ -0,0 +1,9 @@
+CREATE TABLE placechoices (
+	id SERIAL,
+	userid INTEGER NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	place JSONB NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE (userid, name)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE usertags (
+	id SERIAL,
+	userid INTEGER NOT NULL,
+	tag VARCHAR(255) NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE (userid, tag)
+);
+CREATE INDEX usertags_tag_idx ON usertags (tag);
@@ -0,0 +1,3 @@
+CREATE INDEX messages_sentence_search_idx ON messages
+	USING GIN (to_tsvector('english', COALESCE(sentence, '')));
+CREATE INDEX messages_userid_createdat_idx ON messages (userid, createdat);
@@ -0,0 +1,12 @@
+CREATE TABLE facts (
+	id SERIAL,
+	userid INTEGER NOT NULL,
+	pluginname VARCHAR(255) NOT NULL,
+	content TEXT NOT NULL,
+	embedding REAL[],
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX facts_userid_idx ON facts (userid, createdat);
+CREATE INDEX facts_content_search_idx ON facts
+	USING GIN (to_tsvector('english', content));
@@ -0,0 +1,13 @@
+CREATE TABLE conversationlabels (
+	id SERIAL,
+	userid INTEGER NOT NULL,
+	messageid INTEGER,
+	label VARCHAR(255) NOT NULL,
+	createdby VARCHAR(255) NOT NULL,
+	resolvedat TIMESTAMP,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX conversationlabels_label_idx ON conversationlabels (label, createdat);
+CREATE UNIQUE INDEX conversationlabels_open_idx ON conversationlabels (userid, label)
+	WHERE resolvedat IS NULL;
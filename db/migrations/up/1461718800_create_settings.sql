@@ -0,0 +1,6 @@
+CREATE TABLE settings (
+	name VARCHAR(255) NOT NULL,
+	value TEXT NOT NULL,
+	updatedat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (name)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE pluginusage (
+	id SERIAL,
+	tenant VARCHAR(255) NOT NULL DEFAULT '',
+	pluginname VARCHAR(255) NOT NULL,
+	userid INTEGER NOT NULL,
+	day DATE NOT NULL DEFAULT CURRENT_DATE,
+	invocations INTEGER NOT NULL DEFAULT 0,
+	spendmicros BIGINT NOT NULL DEFAULT 0, -- millionths of a dollar
+	PRIMARY KEY (id),
+	UNIQUE (tenant, pluginname, userid, day)
+);
@@ -0,0 +1,19 @@
+CREATE TABLE links (
+	id SERIAL,
+	code VARCHAR(16) UNIQUE NOT NULL,
+	url TEXT NOT NULL,
+	userid INTEGER NOT NULL,
+	messageid INTEGER,
+	pluginname VARCHAR(255) NOT NULL DEFAULT '',
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE linkclicks (
+	id SERIAL,
+	linkid INTEGER NOT NULL,
+	useragent VARCHAR(255) NOT NULL DEFAULT '',
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX linkclicks_linkid_idx ON linkclicks (linkid);
@@ -0,0 +1,10 @@
+ALTER TABLE scheduledevents ADD COLUMN priority INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE scheduledevents ADD COLUMN category VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE TABLE notificationpolicies (
+	userid INTEGER NOT NULL,
+	category VARCHAR(255) NOT NULL,
+	priority INTEGER NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (userid, category)
+);
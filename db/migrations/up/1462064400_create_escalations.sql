@@ -0,0 +1,12 @@
+ALTER TABLE scheduledevents ADD COLUMN sentat TIMESTAMP;
+
+CREATE TABLE escalations (
+	id SERIAL,
+	scheduledeventid INTEGER NOT NULL,
+	flexid VARCHAR(255) NOT NULL,
+	flexidtype INTEGER NOT NULL,
+	reason VARCHAR(255) NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX escalations_scheduledeventid_idx ON escalations (scheduledeventid);
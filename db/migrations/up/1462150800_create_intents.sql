@@ -0,0 +1,9 @@
+CREATE TABLE intents (
+	id SERIAL,
+	userid INTEGER NOT NULL,
+	pluginname VARCHAR(255) NOT NULL,
+	key TEXT NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX intents_userid_key_idx ON intents (userid, pluginname, key, createdat);
@@ -0,0 +1,2 @@
+ALTER TABLE contacts ADD COLUMN relationship VARCHAR(255);
+CREATE INDEX contacts_relationship_idx ON contacts (userid, relationship);
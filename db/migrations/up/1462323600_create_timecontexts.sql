@@ -0,0 +1,9 @@
+CREATE TABLE timecontexts (
+	userid INTEGER NOT NULL,
+	pluginname VARCHAR(255) NOT NULL,
+	route VARCHAR(255) NOT NULL,
+	label VARCHAR(255) NOT NULL,
+	time TIMESTAMP NOT NULL,
+	updatedat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (userid)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE classificationchecks (
+	id SERIAL,
+	messageid INTEGER NOT NULL,
+	plugin VARCHAR(255) NOT NULL,
+	expected VARCHAR(255),
+	source VARCHAR(255) NOT NULL,
+	checkedby VARCHAR(255),
+	checkedat TIMESTAMP,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX classificationchecks_checkedat_idx ON classificationchecks (checkedat);
@@ -0,0 +1,12 @@
+CREATE TABLE shadowparses (
+	id SERIAL,
+	messageid INTEGER NOT NULL,
+	parser VARCHAR(255) NOT NULL,
+	commands VARCHAR(255) ARRAY,
+	objects VARCHAR(255) ARRAY,
+	route VARCHAR(255) NOT NULL,
+	liveroute VARCHAR(255) NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX shadowparses_parser_createdat_idx ON shadowparses (parser, createdat);
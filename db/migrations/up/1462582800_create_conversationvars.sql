@@ -0,0 +1,9 @@
+CREATE TABLE conversationvars (
+	userid INTEGER NOT NULL,
+	pluginname VARCHAR(255) NOT NULL,
+	key VARCHAR(255) NOT NULL,
+	value BYTEA,
+	expiresat TIMESTAMP NOT NULL,
+	PRIMARY KEY (userid, pluginname, key)
+);
+CREATE INDEX conversationvars_expiresat_idx ON conversationvars (expiresat);
@@ -0,0 +1,11 @@
+CREATE TABLE models (
+	id SERIAL,
+	learned JSONB NOT NULL,
+	examples INTEGER NOT NULL,
+	accuracy DOUBLE PRECISION NOT NULL,
+	liveaccuracy DOUBLE PRECISION NOT NULL,
+	status VARCHAR(255) NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	promotedat TIMESTAMP,
+	PRIMARY KEY (id)
+);
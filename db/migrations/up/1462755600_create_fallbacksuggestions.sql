@@ -0,0 +1,12 @@
+CREATE TABLE fallbacksuggestions (
+	id SERIAL,
+	userid INTEGER NOT NULL,
+	messageid INTEGER NOT NULL,
+	plugins VARCHAR(255) ARRAY NOT NULL,
+	routes VARCHAR(255) ARRAY NOT NULL,
+	chosen INTEGER,
+	chosenat TIMESTAMP,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX fallbacksuggestions_userid_createdat_idx ON fallbacksuggestions (userid, createdat);
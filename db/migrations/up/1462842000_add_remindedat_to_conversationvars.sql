@@ -0,0 +1 @@
+ALTER TABLE conversationvars ADD COLUMN remindedat TIMESTAMP;
@@ -0,0 +1,13 @@
+CREATE TABLE verifications (
+	id SERIAL,
+	userid INTEGER NOT NULL,
+	pluginname VARCHAR(255) NOT NULL,
+	route VARCHAR(255) NOT NULL,
+	codehash VARCHAR(255) NOT NULL,
+	attempts INTEGER DEFAULT 0 NOT NULL,
+	expiresat TIMESTAMP NOT NULL,
+	verifiedat TIMESTAMP,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX verifications_userid_idx ON verifications (userid);
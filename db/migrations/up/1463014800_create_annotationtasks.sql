@@ -0,0 +1,7 @@
+CREATE TABLE annotationtasks (
+	messageid INTEGER NOT NULL,
+	drivername VARCHAR(255) NOT NULL,
+	pushedat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	annotatedat TIMESTAMP,
+	PRIMARY KEY (messageid)
+);
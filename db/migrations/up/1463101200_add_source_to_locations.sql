@@ -0,0 +1 @@
+ALTER TABLE locations ADD COLUMN source VARCHAR(255) DEFAULT 'shared' NOT NULL;
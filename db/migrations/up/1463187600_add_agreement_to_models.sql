@@ -0,0 +1 @@
+ALTER TABLE models ADD COLUMN agreement JSONB DEFAULT '{}' NOT NULL;
@@ -0,0 +1,11 @@
+CREATE TABLE vocabpacks (
+	id SERIAL,
+	tenant VARCHAR(255) NOT NULL DEFAULT '',
+	name VARCHAR(255) NOT NULL,
+	gazetteers JSONB DEFAULT '{}' NOT NULL,
+	synonyms JSONB DEFAULT '{}' NOT NULL,
+	triggers JSONB DEFAULT '{}' NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE (tenant, name)
+);
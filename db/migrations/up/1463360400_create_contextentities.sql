@@ -0,0 +1,7 @@
+CREATE TABLE contextentities (
+	userid INTEGER NOT NULL,
+	class INTEGER NOT NULL,
+	entity TEXT NOT NULL,
+	updatedat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (userid, class)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE plugincredentials (
+	id SERIAL,
+	tenant VARCHAR(255) NOT NULL DEFAULT '',
+	pluginname VARCHAR(255) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	value BYTEA NOT NULL,
+	previousvalue BYTEA,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	rotatedat TIMESTAMP,
+	PRIMARY KEY (id),
+	UNIQUE (tenant, pluginname, name)
+);
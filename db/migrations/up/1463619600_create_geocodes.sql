@@ -0,0 +1,6 @@
+CREATE TABLE geocodes (
+	query VARCHAR(255) NOT NULL,
+	places JSONB DEFAULT '[]' NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (query)
+);
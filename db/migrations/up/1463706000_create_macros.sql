@@ -0,0 +1,10 @@
+CREATE TABLE macros (
+	id SERIAL,
+	tenant VARCHAR(255) NOT NULL DEFAULT '',
+	name VARCHAR(255) NOT NULL,
+	body TEXT NOT NULL,
+	route VARCHAR(255) NOT NULL DEFAULT '',
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE (tenant, name)
+);
@@ -0,0 +1 @@
+ALTER TABLE contextentities ADD COLUMN flexidtype INTEGER NOT NULL DEFAULT 0;
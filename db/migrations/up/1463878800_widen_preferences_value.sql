@@ -0,0 +1,2 @@
+ALTER TABLE preferences ALTER COLUMN value TYPE TEXT;
+CREATE INDEX preferences_userid_pkgname_key_idx ON preferences (userid, pkgname, key);
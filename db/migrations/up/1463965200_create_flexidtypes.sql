@@ -0,0 +1,12 @@
+CREATE TABLE flexidtypes (
+	id SERIAL,
+	name VARCHAR(255) UNIQUE NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+INSERT INTO flexidtypes (id, name) VALUES (1, 'email'), (2, 'phone');
+SELECT setval('flexidtypes_id_seq', 100);
+DELETE FROM userflexids AS a USING userflexids AS b
+WHERE a.flexid=b.flexid AND a.flexidtype=b.flexidtype
+	AND (a.createdat<b.createdat OR (a.createdat=b.createdat AND a.ctid<b.ctid));
+CREATE UNIQUE INDEX userflexids_flexid_flexidtype_key ON userflexids (flexid, flexidtype);
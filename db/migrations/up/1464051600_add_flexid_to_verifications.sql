@@ -0,0 +1,2 @@
+ALTER TABLE verifications ADD COLUMN flexid VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE verifications ADD COLUMN flexidtype INTEGER NOT NULL DEFAULT 0;
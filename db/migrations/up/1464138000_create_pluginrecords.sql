@@ -0,0 +1,12 @@
+CREATE TABLE pluginrecords (
+	id SERIAL,
+	pluginname VARCHAR(255) NOT NULL,
+	collection VARCHAR(255) NOT NULL,
+	key VARCHAR(255) NOT NULL,
+	data JSONB NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	updatedat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE (pluginname, collection, key)
+);
+CREATE INDEX pluginrecords_data_idx ON pluginrecords USING GIN (data jsonb_path_ops);
@@ -0,0 +1 @@
+ALTER TABLE messages ALTER COLUMN sentence TYPE TEXT;
@@ -0,0 +1,8 @@
+CREATE TABLE metricsamples (
+	id SERIAL,
+	name VARCHAR(255) NOT NULL,
+	value DOUBLE PRECISION NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX metricsamples_name_createdat_idx ON metricsamples (name, createdat);
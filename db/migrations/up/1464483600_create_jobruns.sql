@@ -0,0 +1,11 @@
+CREATE TABLE jobruns (
+	id SERIAL,
+	name VARCHAR(255) NOT NULL,
+	startedat TIMESTAMP NOT NULL,
+	finishedat TIMESTAMP NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (id)
+);
+CREATE INDEX jobruns_name_startedat_idx ON jobruns (name, startedat);
+
+ALTER TABLE scheduledevents ADD COLUMN deadat TIMESTAMP;
@@ -0,0 +1,32 @@
+CREATE TABLE totpsecrets (
+	userid INTEGER NOT NULL,
+	secret TEXT NOT NULL,
+	laststep BIGINT DEFAULT 0 NOT NULL,
+	confirmedat TIMESTAMP,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (userid)
+);
+
+CREATE TABLE challenges (
+	id SERIAL,
+	userid INTEGER NOT NULL,
+	reason VARCHAR(255) NOT NULL,
+	method VARCHAR(255) NOT NULL,
+	codehash VARCHAR(255) DEFAULT '' NOT NULL,
+	attempts INTEGER DEFAULT 0 NOT NULL,
+	expiresat TIMESTAMP NOT NULL,
+	passedat TIMESTAMP,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX challenges_userid_idx ON challenges (userid);
+
+CREATE TABLE challengeattempts (
+	id SERIAL,
+	userid INTEGER NOT NULL,
+	passed BOOLEAN NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX challengeattempts_userid_createdat_idx
+	ON challengeattempts (userid, createdat);
@@ -0,0 +1,12 @@
+CREATE TABLE inbounddeadletters (
+	id SERIAL,
+	payload TEXT NOT NULL,
+	error TEXT NOT NULL,
+	attempts INTEGER NOT NULL,
+	reprocessedat TIMESTAMP,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+
+ALTER TABLE scheduledevents ADD COLUMN attempts INTEGER DEFAULT 0 NOT NULL;
+ALTER TABLE scheduledevents ADD COLUMN lasterror TEXT DEFAULT '' NOT NULL;
@@ -0,0 +1,13 @@
+CREATE TABLE auditentries (
+	id SERIAL,
+	userid INTEGER NOT NULL,
+	flexid VARCHAR(255) DEFAULT '' NOT NULL,
+	flexidtype INTEGER DEFAULT 0 NOT NULL,
+	action VARCHAR(255) NOT NULL,
+	detail TEXT DEFAULT '' NOT NULL,
+	sentence TEXT DEFAULT '' NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+
+CREATE INDEX auditentries_userid_createdat_idx ON auditentries (userid, createdat);
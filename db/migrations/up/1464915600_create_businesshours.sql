@@ -0,0 +1,22 @@
+CREATE TABLE businesshours (
+	tenant VARCHAR(255) NOT NULL DEFAULT '',
+	timezone VARCHAR(255) DEFAULT '' NOT NULL,
+	days JSONB DEFAULT '{}' NOT NULL,
+	greeting TEXT DEFAULT '' NOT NULL,
+	notice TEXT DEFAULT '' NOT NULL,
+	updatedat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (tenant)
+);
+
+CREATE TABLE callbacks (
+	id SERIAL,
+	tenant VARCHAR(255) NOT NULL DEFAULT '',
+	userid INTEGER NOT NULL,
+	messageid INTEGER NOT NULL,
+	reason VARCHAR(255) NOT NULL,
+	dueat TIMESTAMP NOT NULL,
+	completedat TIMESTAMP,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX callbacks_tenant_dueat_idx ON callbacks (tenant, dueat);
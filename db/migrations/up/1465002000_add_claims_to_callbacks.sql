@@ -0,0 +1,6 @@
+ALTER TABLE callbacks ADD COLUMN followupid INTEGER;
+ALTER TABLE callbacks ADD COLUMN preferredat TIMESTAMP;
+ALTER TABLE callbacks ADD COLUMN claimedby VARCHAR(255) DEFAULT '' NOT NULL;
+ALTER TABLE callbacks ADD COLUMN claimedat TIMESTAMP;
+ALTER TABLE callbacks ADD COLUMN completedby VARCHAR(255) DEFAULT '' NOT NULL;
+ALTER TABLE callbacks ADD COLUMN outcome TEXT DEFAULT '' NOT NULL;
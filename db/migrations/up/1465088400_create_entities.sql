@@ -0,0 +1,10 @@
+CREATE TABLE entities (
+	id SERIAL,
+	tenant VARCHAR(255) NOT NULL DEFAULT '',
+	name VARCHAR(255) NOT NULL,
+	type VARCHAR(255) NOT NULL,
+	createdby VARCHAR(255) DEFAULT '' NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE (tenant, name)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE costs (
+	id SERIAL,
+	tenant VARCHAR(255) NOT NULL DEFAULT '',
+	messageid INTEGER DEFAULT 0 NOT NULL,
+	userid INTEGER DEFAULT 0 NOT NULL,
+	pluginname VARCHAR(255) DEFAULT '' NOT NULL,
+	kind VARCHAR(255) NOT NULL,
+	quantity BIGINT DEFAULT 0 NOT NULL,
+	micros BIGINT DEFAULT 0 NOT NULL,
+	note VARCHAR(255) DEFAULT '' NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX costs_tenant_createdat_idx ON costs (tenant, createdat);
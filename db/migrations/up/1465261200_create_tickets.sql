@@ -0,0 +1,15 @@
+CREATE TABLE tickets (
+	id SERIAL,
+	tenant VARCHAR(255) NOT NULL DEFAULT '',
+	userid INTEGER NOT NULL,
+	messageid INTEGER DEFAULT 0 NOT NULL,
+	reason VARCHAR(255) NOT NULL,
+	transcript TEXT DEFAULT '' NOT NULL,
+	claimedby VARCHAR(255) DEFAULT '' NOT NULL,
+	claimedat TIMESTAMP,
+	releasedby VARCHAR(255) DEFAULT '' NOT NULL,
+	releasedat TIMESTAMP,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id)
+);
+CREATE INDEX tickets_tenant_userid_idx ON tickets (tenant, userid);
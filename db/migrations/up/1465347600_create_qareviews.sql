@@ -0,0 +1,23 @@
+CREATE TABLE qareviews (
+	id SERIAL,
+	tenant VARCHAR(255) NOT NULL DEFAULT '',
+	userid INTEGER NOT NULL,
+	firstmessageid INTEGER NOT NULL,
+	lastmessageid INTEGER NOT NULL,
+	turns INTEGER DEFAULT 0 NOT NULL,
+	fallbacks INTEGER DEFAULT 0 NOT NULL,
+	resolved BOOLEAN DEFAULT FALSE NOT NULL,
+	handedoff BOOLEAN DEFAULT FALSE NOT NULL,
+	sentimentstart DOUBLE PRECISION DEFAULT 0 NOT NULL,
+	sentimentend DOUBLE PRECISION DEFAULT 0 NOT NULL,
+	startedat TIMESTAMP NOT NULL,
+	endedat TIMESTAMP NOT NULL,
+	score INTEGER,
+	notes TEXT DEFAULT '' NOT NULL,
+	reviewedby VARCHAR(255) DEFAULT '' NOT NULL,
+	reviewedat TIMESTAMP,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE (userid, lastmessageid)
+);
+CREATE INDEX qareviews_tenant_reviewedat_idx ON qareviews (tenant, reviewedat);
@@ -0,0 +1,6 @@
+CREATE TABLE optouts (
+	flexid VARCHAR(255) NOT NULL,
+	flexidtype INTEGER NOT NULL,
+	createdat TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (flexid, flexidtype)
+);
@@ -0,0 +1,345 @@
+// Package briefing is a plugin that sends users a daily briefing each morning,
+// e.g. "Send me a briefing every day at 7am." The briefing covers the user's
+// calendar and reminders for the day along with digests published by other
+// plugins, like the weather or packages arriving. Users can also set quiet
+// hours, e.g. "quiet hours from 10pm to 7am," during which Abot holds any
+// briefings, reminders, and other messages until the quiet hours end, and
+// choose which kinds of messages may interrupt them, e.g. "always tell me
+// about flight changes right away" or "package updates can wait."
+package briefing
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core"
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/cal"
+	"github.com/itsabot/abot/shared/nlp"
+	"github.com/itsabot/abot/shared/plugin"
+)
+
+var p *dt.Plugin
+
+// checkInterval is how often Abot checks for briefings that are due.
+const checkInterval = 5 * time.Minute
+
+var regexTime = regexp.MustCompile(`\b(\d{1,2})(:(\d{2}))?\s*(am|pm)?\b`)
+
+func init() {
+	trigger := &nlp.StructuredInput{
+		Commands: []string{"send", "give", "stop", "cancel", "set", "what",
+			"show", "tell", "notify", "interrupt"},
+		Objects: []string{"briefing", "digest", "summary", "hours",
+			"notification", "alert"},
+	}
+	fns := &dt.PluginFns{Run: Run, FollowUp: FollowUp}
+	var err error
+	p, err = plugin.New("github.com/itsabot/abot/plugins/briefing",
+		trigger, fns)
+	if err != nil {
+		log.Fatal("failed to build plugin briefing", err)
+	}
+	plugin.Every(p, checkInterval, sendDue)
+}
+
+// Run handles requests to schedule, cancel, or show a briefing as well as to
+// set quiet hours and notification policies.
+func Run(in *dt.Msg) (string, error) {
+	return FollowUp(in)
+}
+
+// FollowUp handles requests to schedule, cancel, or show a briefing as well as
+// to set quiet hours and notification policies.
+func FollowUp(in *dt.Msg) (string, error) {
+	if !in.User.Registered() {
+		return "I'm sorry, I can only send briefings to people who've signed up.", nil
+	}
+	s := strings.ToLower(in.Sentence)
+	category, pri, err := extractPolicy(s)
+	if err != nil {
+		return "", err
+	}
+	if len(category) > 0 {
+		err = in.User.SetNotificationPolicy(p.DB, category, pri)
+		if err != nil {
+			return "", err
+		}
+		name := strings.Replace(category, "_", " ", -1)
+		if pri == dt.PriorityUrgent {
+			plugin.Audit(p, in, core.AuditPreference,
+				"urgent notifications for "+name)
+			return fmt.Sprintf("Got it. I'll message you about %s right away, even during quiet hours.",
+				name), nil
+		}
+		plugin.Audit(p, in, core.AuditPreference,
+			"normal notifications for "+name)
+		return fmt.Sprintf("Got it. Messages about %s will wait until your quiet hours end.",
+			name), nil
+	}
+	times := extractTimes(s)
+	switch {
+	case strings.Contains(s, "quiet"):
+		if len(times) < 2 {
+			return "When should your quiet hours start and end? For example, 10pm to 7am.", nil
+		}
+		if err := in.User.SetQuietHours(p.DB, times[0], times[1]); err != nil {
+			return "", err
+		}
+		plugin.Audit(p, in, core.AuditPreference, fmt.Sprintf(
+			"quiet hours %s-%s", formatMins(times[0]), formatMins(times[1])))
+		return fmt.Sprintf("Got it. I won't message you between %s and %s unless you message me first.",
+			formatMins(times[0]), formatMins(times[1])), nil
+	case strings.Contains(s, "stop") || strings.Contains(s, "cancel"):
+		q := `DELETE FROM briefings WHERE userid=$1`
+		if _, err := p.DB.Exec(q, in.User.ID); err != nil {
+			return "", err
+		}
+		return "OK. I'll stop sending you briefings.", nil
+	case len(times) > 0:
+		q := `INSERT INTO briefings (userid, sendat) VALUES ($1, $2)
+		      ON CONFLICT (userid) DO UPDATE SET sendat=$2`
+		if _, err := p.DB.Exec(q, in.User.ID, times[0]); err != nil {
+			return "", err
+		}
+		// Let "actually make it 8am" change the briefing later on.
+		y, m, d := time.Now().Date()
+		t := time.Date(y, m, d, 0, times[0], 0, 0, time.Local)
+		if err := plugin.SetTimeContext(p, in, "briefing", t); err != nil {
+			p.Log.Info("failed to set time context", err)
+		}
+		return fmt.Sprintf("Sure. I'll send you a briefing every day at %s.",
+			formatMins(times[0])), nil
+	}
+	return compose(in.User, time.Now())
+}
+
+// sendDue sends each briefing whose time has passed today that hasn't yet been
+// sent, holding any that fall in the user's quiet hours until they end. A
+// briefing that fails is logged and retried next time, so it doesn't hold up
+// everyone else's.
+func sendDue() error {
+	var briefings []struct {
+		UserID     uint64
+		SendAt     int
+		LastSentAt *time.Time
+	}
+	q := `SELECT userid, sendat, lastsentat FROM briefings WHERE userid>0`
+	if err := p.DB.Select(&briefings, q); err != nil {
+		return err
+	}
+	now := time.Now()
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	mins := now.Hour()*60 + now.Minute()
+	for _, b := range briefings {
+		if mins < b.SendAt {
+			continue
+		}
+		if b.LastSentAt != nil && !b.LastSentAt.Before(today) {
+			continue
+		}
+		if err := sendBriefing(b.UserID, now); err != nil {
+			p.Log.Info("failed to send briefing to user", b.UserID, err)
+		}
+	}
+	return nil
+}
+
+// sendBriefing sends a user their briefing unless they're in their quiet
+// hours.
+func sendBriefing(uid uint64, now time.Time) error {
+	u := &dt.User{ID: uid}
+	quiet, err := u.InQuietHours(p.DB, now)
+	if err != nil || quiet {
+		return err
+	}
+	q := `SELECT name FROM users WHERE id=$1`
+	if err = p.DB.Get(&u.Name, q, u.ID); err != nil {
+		return err
+	}
+	content, err := compose(u, now)
+	if err != nil {
+		return err
+	}
+	if _, err = p.Schedule(u, content, now); err != nil {
+		return err
+	}
+	q = `UPDATE briefings SET lastsentat=$1 WHERE userid=$2`
+	_, err = p.DB.Exec(q, now, u.ID)
+	return err
+}
+
+// compose builds a briefing for the user from their calendar, reminders, and
+// the digests of all plugins.
+func compose(u *dt.User, now time.Time) (string, error) {
+	greeting := "Good morning"
+	if now.Hour() >= 12 {
+		greeting = "Good afternoon"
+	}
+	if len(u.Name) > 0 {
+		greeting += ", " + strings.Fields(u.Name)[0]
+	}
+	parts := []string{greeting + "!"}
+	evts, err := events(u, now)
+	if err != nil {
+		// A broken calendar shouldn't prevent the rest of the
+		// briefing from being sent.
+		p.Log.Info("failed to get events for briefing", err)
+	} else {
+		parts = append(parts, evts)
+	}
+	rems, err := reminders(u, now)
+	if err != nil {
+		return "", err
+	}
+	if len(rems) > 0 {
+		parts = append(parts, rems)
+	}
+	parts = append(parts, plugin.Digests(u)...)
+	return strings.Join(parts, " "), nil
+}
+
+// events summarizes the rest of the day in the user's calendar.
+func events(u *dt.User, now time.Time) (string, error) {
+	if len(cal.Drivers()) == 0 {
+		return "", nil
+	}
+	conn, err := cal.Open(cal.Drivers()[0], p.DB,
+		strconv.FormatUint(u.ID, 10))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err = conn.Close(); err != nil {
+			p.Log.Info("failed to close calendar connection", err)
+		}
+	}()
+	y, m, d := now.Date()
+	end := time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+	evts, err := conn.GetEvents(dt.TimeRange{Start: &now, End: &end})
+	if err != nil {
+		return "", err
+	}
+	switch len(evts) {
+	case 0:
+		return "Your calendar is clear for the rest of the day.", nil
+	case 1:
+		return fmt.Sprintf("You have one event today: %s.",
+			describeEvent(evts[0].Title(), evts[0].StartTime(),
+				evts[0].AllDay())), nil
+	}
+	var ss []string
+	for _, evt := range evts {
+		ss = append(ss, describeEvent(evt.Title(), evt.StartTime(),
+			evt.AllDay()))
+	}
+	return fmt.Sprintf("You have %d events today: %s.", len(evts),
+		strings.Join(ss, "; ")), nil
+}
+
+func describeEvent(title string, start *time.Time, allDay bool) string {
+	if allDay || start == nil {
+		return title
+	}
+	return fmt.Sprintf("%s at %s", title, start.Format("3:04PM"))
+}
+
+// reminders summarizes any messages scheduled to be sent to the user later
+// today.
+func reminders(u *dt.User, now time.Time) (string, error) {
+	var rems []string
+	q := `SELECT se.content FROM scheduledevents se
+	      JOIN userflexids uf
+	        ON se.flexid=uf.flexid AND se.flexidtype=uf.flexidtype
+	      WHERE uf.userid=$1 AND se.sent IS FALSE AND se.sendat>$2
+	        AND se.sendat<$3
+	      ORDER BY se.sendat`
+	y, m, d := now.Date()
+	end := time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+	err := p.DB.Select(&rems, q, u.ID, now, end)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	if len(rems) == 0 {
+		return "", nil
+	}
+	return "Later today I'll remind you: " + strings.Join(rems, "; ") + ".",
+		nil
+}
+
+// extractPolicy returns the category and priority of a notification policy
+// found in a sentence, e.g. "flight changes are urgent" or "packages can
+// wait." Categories are those plugins have sent messages with. If no policy
+// is found, the category is empty.
+func extractPolicy(s string) (string, dt.Priority, error) {
+	var pri dt.Priority
+	switch {
+	case strings.Contains(s, "can wait"), strings.Contains(s, "not urgent"),
+		strings.Contains(s, "don't interrupt"),
+		strings.Contains(s, "do not interrupt"):
+		pri = dt.PriorityNormal
+	case strings.Contains(s, "urgent"), strings.Contains(s, "always"),
+		strings.Contains(s, "right away"),
+		strings.Contains(s, "immediately"):
+		pri = dt.PriorityUrgent
+	default:
+		return "", 0, nil
+	}
+	var categories []string
+	q := `SELECT DISTINCT category FROM scheduledevents WHERE category<>''`
+	if err := p.DB.Select(&categories, q); err != nil {
+		return "", 0, err
+	}
+	for _, c := range categories {
+		match := true
+		for _, w := range strings.Split(c, "_") {
+			match = match && strings.Contains(s, w)
+		}
+		if match {
+			return c, pri, nil
+		}
+	}
+	return "", 0, nil
+}
+
+// extractTimes returns times of day found in a sentence in minutes after
+// midnight, e.g. "7am" or "19:30". Bare numbers without am/pm or minutes are
+// ignored, since they're rarely times.
+func extractTimes(s string) []int {
+	var times []int
+	for _, m := range regexTime.FindAllStringSubmatch(s, -1) {
+		if len(m[3]) == 0 && len(m[4]) == 0 {
+			continue
+		}
+		h, err := strconv.Atoi(m[1])
+		if err != nil || h > 23 {
+			continue
+		}
+		var mins int
+		if len(m[3]) > 0 {
+			mins, err = strconv.Atoi(m[3])
+			if err != nil || mins > 59 {
+				continue
+			}
+		}
+		switch {
+		case m[4] == "pm" && h < 12:
+			h += 12
+		case m[4] == "am" && h == 12:
+			h = 0
+		}
+		times = append(times, h*60+mins)
+	}
+	return times
+}
+
+func formatMins(mins int) string {
+	t := time.Date(0, 1, 1, mins/60, mins%60, 0, 0, time.UTC)
+	return t.Format("3:04PM")
+}
@@ -0,0 +1,188 @@
+// Package convert is a plugin that converts between units and currencies,
+// e.g. "How many km is 26 miles?" or "Convert 100 EUR to USD." Units are
+// converted offline using the tables in units.go. Currencies are converted at
+// the rates of the imported exchange rate driver, configured with
+// ABOT_EXCHANGE_AUTH.
+package convert
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/exchange"
+	"github.com/itsabot/abot/shared/language"
+	"github.com/itsabot/abot/shared/nlp"
+	"github.com/itsabot/abot/shared/plugin"
+)
+
+var p *dt.Plugin
+
+// regexConvert matches requests like "convert 26 miles to km" or "$20 in
+// euros."
+var regexConvert = regexp.MustCompile(`(?i)([$€£¥])?\s*(-?[\d,]*\.?\d+)\s*([a-z°€$£¥/]+(?:\s+[a-z]+)?)?\s+(?:to|in|into)\s+([a-z°€$£¥/]+(?:\s+[a-z]+)?)`)
+
+// regexHowMany matches requests like "how many km is 26 miles" or "how much
+// is 20 dollars in euros."
+var regexHowMany = regexp.MustCompile(`(?i)how (?:many|much)\s+([a-z°€$£¥/]+(?:\s+[a-z]+)?)\s+(?:is|are|in|makes?|equals?)\s+([$€£¥])?\s*(-?[\d,]*\.?\d+)\s*([a-z°€$£¥/]+(?:\s+[a-z]+)?)?`)
+
+func init() {
+	objs := []string{}
+	for name := range units {
+		objs = append(objs, name)
+	}
+	for name := range currencies {
+		objs = append(objs, name)
+	}
+	trigger := &nlp.StructuredInput{
+		Commands: []string{"convert", "change", "exchange", "how"},
+		Objects:  objs,
+	}
+	fns := &dt.PluginFns{Run: Run, FollowUp: FollowUp}
+	var err error
+	p, err = plugin.New("github.com/itsabot/abot/plugins/convert", trigger,
+		fns)
+	if err != nil {
+		log.Fatal("failed to build plugin convert", err)
+	}
+}
+
+// Run converts the quantity in a user's message.
+func Run(in *dt.Msg) (string, error) {
+	return FollowUp(in)
+}
+
+// FollowUp converts the quantity in a user's message.
+func FollowUp(in *dt.Msg) (string, error) {
+	amount, from, to, ok := parse(in.Sentence)
+	if !ok {
+		return `What should I convert? For example, "26 miles to km."`,
+			nil
+	}
+	val, err := strconv.ParseFloat(strings.Replace(amount, ",", "", -1),
+		64)
+	if err != nil {
+		return "I'm sorry, I didn't understand that number.", nil
+	}
+	fu, fok := lookupUnit(from)
+	tu, tok := lookupUnit(to)
+	if fok && tok {
+		if fu.Dim != tu.Dim {
+			return fmt.Sprintf("I can't convert %s to %s.", fu.Names[0],
+				tu.Names[0]), nil
+		}
+		res := tu.fromBase(fu.toBase(val))
+		return fmt.Sprintf("%s %s is %s %s.", formatNum(val),
+			fu.name(val), formatNum(res), tu.name(res)), nil
+	}
+	fc, fok := lookupCurrency(from)
+	tc, tok := lookupCurrency(to)
+	if !fok || !tok {
+		return "I'm sorry, I don't know how to convert those.", nil
+	}
+	return convertCurrency(amount, fc, tc)
+}
+
+// convertCurrency at the current exchange rate.
+func convertCurrency(amount, from, to string) (string, error) {
+	cents := language.ExtractCurrency(strings.Replace(amount, ",", "", -1))
+	if !cents.Valid {
+		return "I'm sorry, I didn't understand that amount.", nil
+	}
+	if len(exchange.Drivers()) == 0 {
+		return "I'm sorry, I can't convert currencies yet.", nil
+	}
+	conn, err := exchange.Open(exchange.Drivers()[0],
+		os.Getenv("ABOT_EXCHANGE_AUTH"))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err = conn.Close(); err != nil {
+			p.Log.Info("failed to close exchange connection", err)
+		}
+	}()
+	val := float64(cents.Int64) / 100
+	res, err := conn.Convert(val, from, to)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%.2f %s is %.2f %s.", val, from, res, to), nil
+}
+
+// parse returns the amount to be converted along with the normalized names
+// of the units or currencies to convert from and to.
+func parse(s string) (amount, from, to string, ok bool) {
+	if m := regexHowMany.FindStringSubmatch(s); m != nil {
+		from = m[4]
+		if len(from) == 0 {
+			from = m[2]
+		}
+		return m[3], normalize(from), normalize(m[1]), true
+	}
+	if m := regexConvert.FindStringSubmatch(s); m != nil {
+		from = m[3]
+		if len(from) == 0 {
+			from = m[1]
+		}
+		return m[2], normalize(from), normalize(m[4]), true
+	}
+	return "", "", "", false
+}
+
+// normalize the name of a unit, e.g. "Degrees Fahrenheit" to "fahrenheit."
+func normalize(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.TrimRight(s, ".!?")
+	s = strings.TrimPrefix(s, "degrees ")
+	return strings.TrimPrefix(s, "degree ")
+}
+
+// lookupUnit finds a unit by name, trying singular forms of plural names not
+// in the table. Names matched by regex may include a trailing word, like
+// "miles please," so the first word is tried on its own as well.
+func lookupUnit(name string) (*unit, bool) {
+	for _, n := range []string{name, firstWord(name)} {
+		if u, ok := units[n]; ok {
+			return u, true
+		}
+		for _, suffix := range []string{"s", "es"} {
+			if u, ok := units[strings.TrimSuffix(n, suffix)]; ok {
+				return u, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// lookupCurrency returns the ISO 4217 code of a currency by name.
+func lookupCurrency(name string) (string, bool) {
+	if c, ok := currencies[name]; ok {
+		return c, true
+	}
+	c, ok := currencies[firstWord(name)]
+	return c, ok
+}
+
+func firstWord(s string) string {
+	if i := strings.Index(s, " "); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// formatNum rounds a number for display, trimming trailing zeros, e.g. 41.84
+// or 3.
+func formatNum(n float64) string {
+	if n != 0 && math.Abs(n) < 0.01 {
+		return strconv.FormatFloat(n, 'g', 2, 64)
+	}
+	s := strconv.FormatFloat(n, 'f', 2, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
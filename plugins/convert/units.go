@@ -0,0 +1,175 @@
+package convert
+
+import "strings"
+
+// unit of measure. A value in a unit is converted to its dimension's base unit
+// by multiplying by Factor and adding Offset. Names holds the unit's plural
+// and singular display names followed by any other names and abbreviations
+// users may use.
+type unit struct {
+	Names  []string
+	Dim    string
+	Factor float64
+	Offset float64
+}
+
+func (u *unit) toBase(v float64) float64 {
+	return v*u.Factor + u.Offset
+}
+
+func (u *unit) fromBase(v float64) float64 {
+	return (v - u.Offset) / u.Factor
+}
+
+// name returns the display name of the unit for a value, e.g. "1 mile" but
+// "2 miles."
+func (u *unit) name(v float64) string {
+	if v == 1 {
+		return u.Names[1]
+	}
+	return u.Names[0]
+}
+
+// unitDefs are the units Abot can convert offline. Base units are meters,
+// grams, liters, kelvin, meters per second, seconds and bytes.
+var unitDefs = []*unit{
+	// Length
+	{[]string{"meters", "meter", "metre", "metres", "m"}, "length", 1, 0},
+	{[]string{"kilometers", "kilometer", "kilometre", "kilometres", "km",
+		"kms"}, "length", 1000, 0},
+	{[]string{"centimeters", "centimeter", "centimetre", "centimetres",
+		"cm"}, "length", 0.01, 0},
+	{[]string{"millimeters", "millimeter", "millimetre", "millimetres",
+		"mm"}, "length", 0.001, 0},
+	{[]string{"miles", "mile", "mi"}, "length", 1609.344, 0},
+	{[]string{"yards", "yard", "yd", "yds"}, "length", 0.9144, 0},
+	{[]string{"feet", "foot", "ft"}, "length", 0.3048, 0},
+	{[]string{"inches", "inch"}, "length", 0.0254, 0},
+
+	// Mass
+	{[]string{"grams", "gram", "g"}, "mass", 1, 0},
+	{[]string{"kilograms", "kilogram", "kg", "kgs", "kilo", "kilos"},
+		"mass", 1000, 0},
+	{[]string{"milligrams", "milligram", "mg"}, "mass", 0.001, 0},
+	{[]string{"tonnes", "tonne"}, "mass", 1000000, 0},
+	{[]string{"pounds", "pound", "lb", "lbs"}, "mass", 453.59237, 0},
+	{[]string{"ounces", "ounce", "oz"}, "mass", 28.349523125, 0},
+	{[]string{"stone", "stone", "st"}, "mass", 6350.29318, 0},
+
+	// Volume
+	{[]string{"liters", "liter", "litre", "litres", "l"}, "volume", 1, 0},
+	{[]string{"milliliters", "milliliter", "millilitre", "millilitres",
+		"ml"}, "volume", 0.001, 0},
+	{[]string{"gallons", "gallon", "gal"}, "volume", 3.785411784, 0},
+	{[]string{"quarts", "quart", "qt"}, "volume", 0.946352946, 0},
+	{[]string{"pints", "pint", "pt"}, "volume", 0.473176473, 0},
+	{[]string{"cups", "cup"}, "volume", 0.2365882365, 0},
+	{[]string{"fluid ounces", "fluid ounce", "fl oz"}, "volume",
+		0.0295735295625, 0},
+	{[]string{"tablespoons", "tablespoon", "tbsp"}, "volume",
+		0.01478676478125, 0},
+	{[]string{"teaspoons", "teaspoon", "tsp"}, "volume",
+		0.00492892159375, 0},
+
+	// Temperature
+	{[]string{"°C", "°C", "celsius", "centigrade", "c"}, "temperature",
+		1, 273.15},
+	{[]string{"°F", "°F", "fahrenheit", "f"}, "temperature", 5.0 / 9,
+		459.67 * 5 / 9},
+	{[]string{"kelvin", "kelvin", "k"}, "temperature", 1, 0},
+
+	// Speed
+	{[]string{"meters per second", "meter per second", "m/s"}, "speed", 1,
+		0},
+	{[]string{"km/h", "km/h", "kph", "kmh"}, "speed", 1 / 3.6, 0},
+	{[]string{"mph", "mph"}, "speed", 0.44704, 0},
+	{[]string{"knots", "knot", "kn"}, "speed", 1852.0 / 3600, 0},
+
+	// Time
+	{[]string{"seconds", "second", "sec", "secs", "s"}, "time", 1, 0},
+	{[]string{"minutes", "minute", "min", "mins"}, "time", 60, 0},
+	{[]string{"hours", "hour", "hr", "hrs", "h"}, "time", 3600, 0},
+	{[]string{"days", "day"}, "time", 86400, 0},
+	{[]string{"weeks", "week", "wk", "wks"}, "time", 604800, 0},
+	{[]string{"years", "year", "yr", "yrs"}, "time", 31557600, 0},
+
+	// Data
+	{[]string{"bytes", "byte"}, "data", 1, 0},
+	{[]string{"kilobytes", "kilobyte", "kb"}, "data", 1e3, 0},
+	{[]string{"megabytes", "megabyte", "mb"}, "data", 1e6, 0},
+	{[]string{"gigabytes", "gigabyte", "gb"}, "data", 1e9, 0},
+	{[]string{"terabytes", "terabyte", "tb"}, "data", 1e12, 0},
+}
+
+// units maps the lowercase names of units to their definitions.
+var units = buildUnits()
+
+// currencies maps the names and symbols of currencies to their ISO 4217
+// codes. Names shared with units, like "pounds," are treated as units unless
+// the other side of the conversion is a currency.
+var currencies = map[string]string{
+	"usd":        "USD",
+	"$":          "USD",
+	"dollar":     "USD",
+	"dollars":    "USD",
+	"us dollars": "USD",
+	"bucks":      "USD",
+
+	"eur":   "EUR",
+	"€":     "EUR",
+	"euro":  "EUR",
+	"euros": "EUR",
+
+	"gbp":    "GBP",
+	"£":      "GBP",
+	"pound":  "GBP",
+	"pounds": "GBP",
+	"quid":   "GBP",
+
+	"jpy": "JPY",
+	"¥":   "JPY",
+	"yen": "JPY",
+
+	"cny":  "CNY",
+	"rmb":  "CNY",
+	"yuan": "CNY",
+
+	"cad":              "CAD",
+	"canadian dollars": "CAD",
+
+	"aud":                "AUD",
+	"australian dollars": "AUD",
+
+	"chf":    "CHF",
+	"franc":  "CHF",
+	"francs": "CHF",
+
+	"inr":    "INR",
+	"rupee":  "INR",
+	"rupees": "INR",
+
+	"mxn":   "MXN",
+	"peso":  "MXN",
+	"pesos": "MXN",
+
+	"krw": "KRW",
+	"won": "KRW",
+
+	"sek":    "SEK",
+	"krona":  "SEK",
+	"kronor": "SEK",
+
+	"brl":   "BRL",
+	"real":  "BRL",
+	"reais": "BRL",
+}
+
+func buildUnits() map[string]*unit {
+	m := map[string]*unit{}
+	for _, u := range unitDefs {
+		for _, name := range u.Names {
+			m[strings.ToLower(name)] = u
+		}
+	}
+	return m
+}
@@ -0,0 +1,271 @@
+// Package email is a plugin that sends emails to a user's contacts on their
+// behalf, e.g. "Email Bob saying I'm running late" or "Email Sarah Smith about
+// the notes." Before sending, Abot shows the user a preview of the email to
+// confirm. Emails are sent through the imported email driver from
+// ADMIN_EMAIL, and they ask contacts to reply to the user directly.
+package email
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/language"
+	"github.com/itsabot/abot/shared/nlp"
+	"github.com/itsabot/abot/shared/plugin"
+)
+
+var p *dt.Plugin
+var sm *dt.StateMachine
+
+const (
+	keyContact   = "email_contact"
+	keySubject   = "email_subject"
+	keyBody      = "email_body"
+	keyAskedBody = "email_asked_body"
+	keyPreviewed = "email_previewed"
+)
+
+// stateConfirm is the index of the state that previews the email.
+const stateConfirm = 2
+
+// regexBody matches the content of an email within a request, e.g. "saying
+// I'm running late."
+var regexBody = regexp.MustCompile(`(?i)\b(?:saying|that says|to say|telling (?:him|her|them))\s*:?\s+(.+)$`)
+
+// regexSubject matches the topic of an email within the part of a request
+// preceding its body, e.g. "about lunch" or "the notes."
+var regexSubject = regexp.MustCompile(`(?i)\b(?:about\s+(.+)|((?:the|my|our)\s+.+))$`)
+
+func init() {
+	trigger := &nlp.StructuredInput{
+		Commands: []string{"email", "send", "write", "message"},
+		Objects:  []string{"email", "note", "notes", "message"},
+	}
+	fns := &dt.PluginFns{Run: Run, FollowUp: FollowUp}
+	var err error
+	p, err = plugin.New("github.com/itsabot/abot/plugins/email", trigger,
+		fns)
+	if err != nil {
+		log.Fatal("failed to build plugin email", err)
+	}
+	sm = dt.NewStateMachine(p)
+	sm.SetStates([]dt.State{
+		{
+			SkipIfComplete: true,
+			OnEntry: func(in *dt.Msg) string {
+				return "Who should I email?"
+			},
+			OnInput: func(in *dt.Msg) {
+				name := extractContactName(in.Tokens)
+				if len(name) == 0 {
+					return
+				}
+				c, err := in.User.FindContact(p.DB, name)
+				if err != nil {
+					p.Log.Debug("could not find contact", name,
+						err)
+					return
+				}
+				sm.SetMemory(in, keyContact, c)
+			},
+			Complete: func(in *dt.Msg) (bool, string) {
+				if !sm.HasMemory(in, keyContact) {
+					return false, "I couldn't find that person in your contacts. Who should I email?"
+				}
+				c, err := contact(in)
+				if err != nil || !c.Email.Valid || len(c.Email.String) == 0 {
+					sm.DeleteMemory(in, keyContact)
+					return false, "I don't have an email address for them. Who else should I email?"
+				}
+				return true, ""
+			},
+		},
+		{
+			SkipIfComplete: true,
+			OnEntry: func(in *dt.Msg) string {
+				sm.SetMemory(in, keyAskedBody, true)
+				return "What should the email say?"
+			},
+			OnInput: func(in *dt.Msg) {
+				if m := regexBody.FindStringSubmatch(in.Sentence); m != nil {
+					sm.SetMemory(in, keyBody, m[1])
+					return
+				}
+				// Once asked, the user's whole reply is the body.
+				if sm.GetMemory(in, keyAskedBody).Bool() {
+					sm.SetMemory(in, keyBody, in.Sentence)
+				}
+			},
+			Complete: func(in *dt.Msg) (bool, string) {
+				return sm.HasMemory(in, keyBody), ""
+			},
+		},
+		{
+			OnEntry: func(in *dt.Msg) string {
+				sm.SetMemory(in, keyPreviewed, true)
+				resp, err := preview(in)
+				if err != nil {
+					p.Log.Info("failed to preview email", err)
+					return "I'm sorry, something went wrong with that email."
+				}
+				return resp
+			},
+			OnInput: func(in *dt.Msg) {},
+			Complete: func(in *dt.Msg) (bool, string) {
+				return false, ""
+			},
+		},
+	})
+	sm.SetOnReset(func(in *dt.Msg) {
+		sm.DeleteMemory(in, keyContact)
+		sm.DeleteMemory(in, keySubject)
+		sm.DeleteMemory(in, keyBody)
+		sm.DeleteMemory(in, keyAskedBody)
+		sm.DeleteMemory(in, keyPreviewed)
+	})
+}
+
+// Run resets the plugin's state, remembering the subject and body of the email
+// if they were included in the user's request.
+func Run(in *dt.Msg) (string, error) {
+	if !in.User.Registered() {
+		return "You'll need to sign up before I can send emails for you.", nil
+	}
+	sm.Reset(in)
+	head := in.Sentence
+	if loc := regexBody.FindStringSubmatchIndex(head); loc != nil {
+		sm.SetMemory(in, keyBody, head[loc[2]:loc[3]])
+		head = head[:loc[0]]
+	}
+	head = strings.TrimSpace(head)
+	if m := regexSubject.FindStringSubmatch(head); m != nil {
+		subj := m[1] + m[2]
+		sm.SetMemory(in, keySubject, strings.TrimRight(subj, ".!"))
+	}
+	return FollowUp(in)
+}
+
+// FollowUp continues the conversation with the user. Once the email has been
+// previewed, the user's reply confirms or cancels sending it.
+func FollowUp(in *dt.Msg) (string, error) {
+	if !in.User.Registered() {
+		return "", nil
+	}
+	sm.LoadState(in)
+	if sm.State() == stateConfirm && sm.GetMemory(in, keyPreviewed).Bool() {
+		return confirm(in)
+	}
+	return sm.Next(in), nil
+}
+
+// confirm sends the email if the user approves the preview.
+func confirm(in *dt.Msg) (string, error) {
+	yes := language.ExtractYesNo(in.Sentence)
+	if !yes.Valid {
+		return "Should I send it?", nil
+	}
+	defer sm.Reset(in)
+	if !yes.Bool {
+		return "OK, I won't send it.", nil
+	}
+	conn := plugin.Email()
+	if conn == nil {
+		return "I'm sorry, I can't send emails yet.", nil
+	}
+	c, err := contact(in)
+	if err != nil {
+		return "", err
+	}
+	subj, body := compose(in)
+	err = conn.SendPlainText([]string{c.Email.String},
+		os.Getenv("ADMIN_EMAIL"), subj, body)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Sent! I emailed %s.", c.Name), nil
+}
+
+// preview describes the email for the user to confirm.
+func preview(in *dt.Msg) (string, error) {
+	c, err := contact(in)
+	if err != nil {
+		return "", err
+	}
+	subj, body := compose(in)
+	return fmt.Sprintf("Here's your email to %s (%s):\n\nSubject: %s\n\n%s\n\nShould I send it?",
+		c.Name, c.Email.String, subj, body), nil
+}
+
+// compose builds the subject and body of the email. Since the email comes
+// from Abot, the body ends by asking the contact to reply to the user.
+func compose(in *dt.Msg) (subj, body string) {
+	subj = sm.GetMemory(in, keySubject).String()
+	if len(subj) == 0 {
+		subj = "Message from " + in.User.Name
+	} else {
+		subj = strings.ToUpper(subj[:1]) + subj[1:]
+	}
+	body = sm.GetMemory(in, keyBody).String()
+	body += fmt.Sprintf("\n\n--\nSent by Abot on behalf of %s. To reply, email %s directly at %s.",
+		in.User.Name, in.User.Name, in.User.Email)
+	return subj, body
+}
+
+func contact(in *dt.Msg) (*dt.Contact, error) {
+	c := &dt.Contact{}
+	err := json.Unmarshal(sm.GetMemory(in, keyContact).Val, c)
+	return c, err
+}
+
+// extractContactName returns the name following "email" or "to" in a
+// request, e.g. "Bob" in "Email Bob the notes." The first word is always
+// taken, and following words are taken only if they're capitalized, like a
+// last name. If the message has neither word, like a reply to "Who should I
+// email?", the whole message is the name.
+func extractContactName(tokens []string) string {
+	var name []string
+	var found bool
+	for _, t := range tokens {
+		switch strings.ToLower(t) {
+		case "email", "to", "message":
+			found = true
+		}
+	}
+	if !found {
+		for _, t := range tokens {
+			if t != "." && t != "!" {
+				name = append(name, t)
+			}
+		}
+		return strings.Join(name, " ")
+	}
+	found = false
+	for _, t := range tokens {
+		lower := strings.ToLower(t)
+		if !found {
+			found = lower == "email" || lower == "to" ||
+				lower == "message"
+			continue
+		}
+		if len(name) == 0 {
+			switch lower {
+			case "a", "an", "the", "my":
+				// "Send an email to Bob"
+				found = false
+				continue
+			}
+			name = append(name, t)
+			continue
+		}
+		if t == strings.ToLower(t) {
+			break
+		}
+		name = append(name, t)
+	}
+	return strings.Join(name, " ")
+}
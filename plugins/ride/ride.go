@@ -0,0 +1,289 @@
+// Package ride is a reference plugin for taking actions on a third-party
+// service on a user's behalf. It books rides through a ride driver, e.g. "Get
+// me a ride from work to home." Users first grant Abot access to their account
+// through the driver's OAuth page. Pickup and dropoff points can be a saved
+// address like "home," a place's name, or the location the user most recently
+// shared. Abot confirms the price with the user before booking.
+package ride
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/itsabot/abot/core"
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/ride/driver"
+	"github.com/itsabot/abot/shared/nlp"
+	"github.com/itsabot/abot/shared/plugin"
+	"github.com/itsabot/abot/shared/task"
+)
+
+var p *dt.Plugin
+var sm *dt.StateMachine
+
+const (
+	keyPickup        = "ride_pickup"
+	keyDropoff       = "ride_dropoff"
+	keyAskedPickup   = "ride_asked_pickup"
+	keyAskedDropoff  = "ride_asked_dropoff"
+	keyProduct       = "ride_product"
+	keyRideID        = "ride_id"
+	currentLocation  = "your current location"
+	msgNotConfigured = "I'm sorry, I can't book rides yet."
+)
+
+var regexFrom = regexp.MustCompile(`(?i)\bfrom\s+(.+?)(?:\s+to\s+|[.!?]?$)`)
+var regexTo = regexp.MustCompile(`(?i)\bto\s+(.+?)(?:\s+from\s+|[.!?]?$)`)
+
+func init() {
+	trigger := &nlp.StructuredInput{
+		Commands: []string{"get", "book", "call", "order", "need",
+			"cancel"},
+		Objects: []string{"ride", "car", "taxi", "cab", "uber", "lyft"},
+	}
+	fns := &dt.PluginFns{Run: Run, FollowUp: FollowUp}
+	var err error
+	p, err = plugin.New("github.com/itsabot/abot/plugins/ride", trigger,
+		fns)
+	if err != nil {
+		log.Fatal("failed to build plugin ride", err)
+	}
+	sm = dt.NewStateMachine(p)
+	sm.SetStates(
+		task.New(sm, task.RequestSignup, ""),
+		[]dt.State{
+			{
+				SkipIfComplete: true,
+				OnEntry: func(in *dt.Msg) string {
+					url, err := core.Ride().AuthURL(in.User)
+					if err != nil {
+						p.Log.Info("failed to get auth url", err)
+						return msgNotConfigured
+					}
+					return "First, I'll need access to your account. You can connect it here: " +
+						url + ". Let me know when you're done!"
+				},
+				OnInput: func(in *dt.Msg) {},
+				Complete: func(in *dt.Msg) (bool, string) {
+					ok, err := core.Ride().Authorized(in.User)
+					if err != nil {
+						p.Log.Info("failed to check authorization",
+							err)
+						return false, msgNotConfigured
+					}
+					if !ok {
+						return false, "It looks like your account isn't connected yet."
+					}
+					return true, ""
+				},
+			},
+			{
+				SkipIfComplete: true,
+				OnEntry: func(in *dt.Msg) string {
+					sm.SetMemory(in, keyAskedPickup, true)
+					return "Where should I pick you up?"
+				},
+				OnInput: func(in *dt.Msg) {
+					setPlace(in, keyPickup, keyAskedPickup,
+						regexFrom)
+					if sm.HasMemory(in, keyPickup) {
+						return
+					}
+					// Use the user's shared location if
+					// they haven't said otherwise.
+					l, err := in.User.LastLocation(p.DB)
+					if err != nil || !l.IsRecent() {
+						return
+					}
+					sm.SetMemory(in, keyPickup, &driver.Place{
+						Name: currentLocation,
+						Lat:  l.Lat,
+						Lon:  l.Lon,
+					})
+				},
+				Complete: func(in *dt.Msg) (bool, string) {
+					return sm.HasMemory(in, keyPickup), ""
+				},
+			},
+			{
+				SkipIfComplete: true,
+				OnEntry: func(in *dt.Msg) string {
+					sm.SetMemory(in, keyAskedDropoff, true)
+					return "Where are you going?"
+				},
+				OnInput: func(in *dt.Msg) {
+					setPlace(in, keyDropoff, keyAskedDropoff,
+						regexTo)
+				},
+				Complete: func(in *dt.Msg) (bool, string) {
+					return sm.HasMemory(in, keyDropoff), ""
+				},
+			},
+			{
+				SkipIfComplete: true,
+				OnEntry: func(in *dt.Msg) string {
+					return "I'm sorry, there aren't any rides available right now. Want me to try again?"
+				},
+				OnInput: func(in *dt.Msg) {
+					if err := estimate(in); err != nil {
+						p.Log.Info("failed to estimate ride", err)
+					}
+				},
+				Complete: func(in *dt.Msg) (bool, string) {
+					return sm.HasMemory(in, keyProduct), ""
+				},
+			},
+		},
+		task.New(sm, task.RequestConfirmation, "confirm"),
+		[]dt.State{
+			{
+				OnEntry: func(in *dt.Msg) string {
+					resp, err := book(in)
+					if err != nil {
+						p.Log.Info("failed to book ride", err)
+						return "I'm sorry, I couldn't book that ride."
+					}
+					return resp
+				},
+				OnInput: func(in *dt.Msg) {},
+				Complete: func(in *dt.Msg) (bool, string) {
+					return true, ""
+				},
+			},
+		},
+	)
+	sm.SetOnReset(func(in *dt.Msg) {
+		sm.DeleteMemory(in, keyPickup)
+		sm.DeleteMemory(in, keyDropoff)
+		sm.DeleteMemory(in, keyAskedPickup)
+		sm.DeleteMemory(in, keyAskedDropoff)
+		sm.DeleteMemory(in, keyProduct)
+		sm.DeleteMemory(in, task.KeyConfirmSummary)
+	})
+}
+
+// Run starts booking a new ride or cancels the last one.
+func Run(in *dt.Msg) (string, error) {
+	if core.Ride() == nil {
+		return msgNotConfigured, nil
+	}
+	if strings.Contains(strings.ToLower(in.Sentence), "cancel") {
+		return cancel(in)
+	}
+	sm.Reset(in)
+	return FollowUp(in)
+}
+
+// FollowUp continues booking a ride.
+func FollowUp(in *dt.Msg) (string, error) {
+	if core.Ride() == nil {
+		return msgNotConfigured, nil
+	}
+	sm.LoadState(in)
+	return sm.Next(in), nil
+}
+
+// setPlace remembers a place found in the user's message using regex. Once
+// the user has been asked for the place, their whole reply is the place.
+func setPlace(in *dt.Msg, key, askedKey string, regex *regexp.Regexp) {
+	var s string
+	if m := regex.FindStringSubmatch(in.Sentence); m != nil {
+		s = m[1]
+	} else if sm.GetMemory(in, askedKey).Bool() {
+		s = strings.TrimRight(in.Sentence, ".!?")
+	} else if key == keyDropoff && strings.Contains(
+		strings.ToLower(in.Sentence), " home") {
+		// "Take me home"
+		s = "home"
+	}
+	if len(s) == 0 {
+		return
+	}
+	place := &driver.Place{Name: s}
+	addr, err := in.User.GetAddress(p.DB, s)
+	if err == nil {
+		place.Address = addr
+	}
+	sm.SetMemory(in, key, place)
+}
+
+// estimate finds the cheapest ride available, describing it for the user to
+// confirm.
+func estimate(in *dt.Msg) error {
+	pickup, dropoff, err := places(in)
+	if err != nil {
+		return err
+	}
+	ests, err := core.Ride().Estimate(in.User, pickup, dropoff)
+	if err != nil {
+		return err
+	}
+	if len(ests) == 0 {
+		return nil
+	}
+	best := ests[0]
+	for _, e := range ests[1:] {
+		if e.LowInCents < best.LowInCents {
+			best = e
+		}
+	}
+	sm.SetMemory(in, keyProduct, best.ProductID)
+	sm.SetMemory(in, task.KeyConfirmSummary, fmt.Sprintf(
+		"A %s can pick you up at %s in %d minutes and take you to %s for $%d-%d.",
+		best.ProductName, pickup.Name, int(best.PickupETA.Minutes()),
+		dropoff.Name, best.LowInCents/100, (best.HighInCents+99)/100))
+	return nil
+}
+
+// book requests the ride the user confirmed.
+func book(in *dt.Msg) (string, error) {
+	pickup, dropoff, err := places(in)
+	if err != nil {
+		return "", err
+	}
+	product := sm.GetMemory(in, keyProduct).String()
+	r, err := core.Ride().Request(in.User, product, pickup, dropoff)
+	if err != nil {
+		return "", err
+	}
+	sm.SetMemory(in, keyRideID, r.ID)
+	resp := "You're all set!"
+	if len(r.DriverName) > 0 {
+		resp += fmt.Sprintf(" %s is on the way in a %s (%s).",
+			r.DriverName, r.Vehicle, r.LicensePlate)
+	}
+	if r.PickupETA > 0 {
+		resp += fmt.Sprintf(" Your ride will arrive in about %d minutes.",
+			int(r.PickupETA.Minutes()))
+	}
+	return resp, nil
+}
+
+// cancel the ride the user most recently booked.
+func cancel(in *dt.Msg) (string, error) {
+	if !sm.HasMemory(in, keyRideID) {
+		return "You don't have any rides booked.", nil
+	}
+	id := sm.GetMemory(in, keyRideID).String()
+	if err := core.Ride().Cancel(in.User, id); err != nil {
+		return "", err
+	}
+	sm.DeleteMemory(in, keyRideID)
+	return "OK, I've canceled your ride.", nil
+}
+
+func places(in *dt.Msg) (pickup, dropoff *driver.Place, err error) {
+	pickup, dropoff = &driver.Place{}, &driver.Place{}
+	err = json.Unmarshal(sm.GetMemory(in, keyPickup).Val, pickup)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = json.Unmarshal(sm.GetMemory(in, keyDropoff).Val, dropoff)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pickup, dropoff, nil
+}
@@ -0,0 +1,461 @@
+// Package schedule is a plugin that finds meeting times between a user and one
+// of their contacts, e.g. "Set up 30 minutes with Sarah next week." Abot
+// checks the user's calendar for free time, offers the contact a few options
+// over SMS or email, and creates the event once the contact picks one.
+package schedule
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/helpers/holidays"
+	"github.com/itsabot/abot/shared/interface/cal"
+	"github.com/itsabot/abot/shared/interface/cal/driver"
+	"github.com/itsabot/abot/shared/language"
+	"github.com/itsabot/abot/shared/nlp"
+	"github.com/itsabot/abot/shared/plugin"
+)
+
+var p *dt.Plugin
+var sm *dt.StateMachine
+
+// route is saved with messages sent to contacts, so their replies are routed
+// back to this plugin. See dt.Msg.GetLastRoute.
+const route = "schedule_meeting"
+
+const (
+	keyContact  = "schedule_contact"
+	keyDuration = "schedule_duration"
+	keyWindow   = "schedule_window"
+)
+
+// maxProposals is the number of meeting times offered to a contact.
+const maxProposals = 3
+
+var regexDuration = regexp.MustCompile(`(\d+|an?|one|half an?)\s*(hours?|hrs?|minutes?|mins?)`)
+
+func init() {
+	trigger := &nlp.StructuredInput{
+		Commands: []string{"schedule", "set", "setup", "book", "arrange",
+			"find"},
+		Objects: []string{"meeting", "meet", "call", "time", "minutes",
+			"hour"},
+	}
+	fns := &dt.PluginFns{Run: Run, FollowUp: FollowUp}
+	var err error
+	p, err = plugin.New("github.com/itsabot/abot/plugins/schedule",
+		trigger, fns)
+	if err != nil {
+		log.Fatal("failed to build plugin schedule", err)
+	}
+	sm = dt.NewStateMachine(p)
+	sm.SetStates([]dt.State{
+		{
+			SkipIfComplete: true,
+			OnEntry: func(in *dt.Msg) string {
+				return "Who would you like to meet with?"
+			},
+			OnInput: func(in *dt.Msg) {
+				name := extractContactName(in.Tokens)
+				if len(name) == 0 {
+					return
+				}
+				c, err := in.User.FindContact(p.DB, name)
+				if err != nil {
+					p.Log.Debug("could not find contact", name,
+						err)
+					return
+				}
+				sm.SetMemory(in, keyContact, c)
+			},
+			Complete: func(in *dt.Msg) (bool, string) {
+				if sm.HasMemory(in, keyContact) {
+					return true, ""
+				}
+				return false, "I couldn't find that person in your contacts. Who would you like to meet with?"
+			},
+		},
+		{
+			SkipIfComplete: true,
+			OnEntry: func(in *dt.Msg) string {
+				return "How long should the meeting be?"
+			},
+			OnInput: func(in *dt.Msg) {
+				mins := extractDuration(in.Sentence)
+				if mins > 0 {
+					sm.SetMemory(in, keyDuration, mins)
+				}
+			},
+			Complete: func(in *dt.Msg) (bool, string) {
+				return sm.HasMemory(in, keyDuration), ""
+			},
+		},
+		{
+			OnEntry: func(in *dt.Msg) string {
+				resp, err := propose(in)
+				if err != nil {
+					p.Log.Info("failed to propose meeting times",
+						err)
+					return "I'm sorry, I couldn't check your calendar right now."
+				}
+				return resp
+			},
+			OnInput: func(in *dt.Msg) {},
+			Complete: func(in *dt.Msg) (bool, string) {
+				return false, "I'm still waiting to hear back. I'll let you know once they reply."
+			},
+		},
+	})
+	sm.SetOnReset(func(in *dt.Msg) {
+		sm.DeleteMemory(in, keyContact)
+		sm.DeleteMemory(in, keyDuration)
+		sm.DeleteMemory(in, keyWindow)
+	})
+}
+
+// Run resets the plugin's state, remembering the time window requested in the
+// user's first message, e.g. "next week."
+func Run(in *dt.Msg) (string, error) {
+	if !in.User.Registered() {
+		return handleReply(in)
+	}
+	sm.Reset(in)
+	sm.SetMemory(in, keyWindow, extractWindow(in.Sentence, time.Now()))
+	return FollowUp(in)
+}
+
+// FollowUp continues the conversation with the user. Contacts replying to a
+// meeting request are never registered users, so their replies are handled
+// separately.
+func FollowUp(in *dt.Msg) (string, error) {
+	if !in.User.Registered() {
+		return handleReply(in)
+	}
+	sm.LoadState(in)
+	return sm.Next(in), nil
+}
+
+// propose finds free times in the user's calendar and sends them to the
+// contact, saving the request so the contact's reply can be matched to it.
+func propose(in *dt.Msg) (string, error) {
+	if len(cal.Drivers()) == 0 {
+		return "You'll need to connect a calendar before I can schedule meetings.", nil
+	}
+	var c dt.Contact
+	if err := json.Unmarshal(sm.GetMemory(in, keyContact).Val, &c); err != nil {
+		return "", err
+	}
+	var tr dt.TimeRange
+	if err := json.Unmarshal(sm.GetMemory(in, keyWindow).Val, &tr); err != nil {
+		return "", err
+	}
+	mins := int(sm.GetMemory(in, keyDuration).Int64())
+	conn, err := cal.Open(cal.Drivers()[0], p.DB,
+		strconv.FormatUint(in.User.ID, 10))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err = conn.Close(); err != nil {
+			p.Log.Info("failed to close calendar connection", err)
+		}
+	}()
+	evts, err := conn.GetEvents(tr)
+	if err != nil {
+		return "", err
+	}
+	times, skipped := freeTimes(evts, tr, mins, time.Now())
+	note := holidayNote(skipped)
+	if len(times) == 0 {
+		return note + "You don't have any free time then. Would you like to try another week?", nil
+	}
+	contact := &dt.User{}
+	switch {
+	case c.Phone.Valid && len(c.Phone.String) > 0:
+		contact.FlexID = c.Phone.String
+		contact.FlexIDType = dt.FlexIDType(2)
+	case c.Email.Valid && len(c.Email.String) > 0:
+		contact.FlexID = c.Email.String
+		contact.FlexIDType = dt.FlexIDType(1)
+	default:
+		return fmt.Sprintf("I don't have a phone number or email for %s.",
+			c.Name), nil
+	}
+	byt, err := json.Marshal(times)
+	if err != nil {
+		return "", err
+	}
+	q := `INSERT INTO meetingrequests
+	      (userid, contactid, flexid, flexidtype, title, durationinmins,
+		proposedtimes)
+	      VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	title := fmt.Sprintf("%s / %s", in.User.Name, c.Name)
+	_, err = p.DB.Exec(q, in.User.ID, c.ID, contact.FlexID,
+		contact.FlexIDType, title, mins, byt)
+	if err != nil {
+		return "", err
+	}
+
+	// Ask the contact, and save the request as a routed message so that
+	// their reply comes back to this plugin.
+	content := fmt.Sprintf("Hi %s, %s would like to meet for %d minutes. Which time works best for you? ",
+		c.Name, in.User.Name, mins)
+	for i, t := range times {
+		content += fmt.Sprintf("(%d) %s ", i+1, formatTime(t))
+	}
+	content += `Just reply with the number, or "none" if none work.`
+	if _, err = p.Schedule(contact, content, time.Now()); err != nil {
+		return "", err
+	}
+	m := &dt.Msg{
+		User:     contact,
+		Sentence: content,
+		AbotSent: true,
+		Plugin:   p.Config.Name,
+		Route:    route,
+	}
+	if err = m.Save(p.DB); err != nil {
+		return "", err
+	}
+	return note + fmt.Sprintf("I've sent %s a few times that work for you. I'll let you know once they reply.",
+		c.Name), nil
+}
+
+// holidayNote warns the user of holidays skipped when looking for free time.
+func holidayNote(skipped []holidays.Holiday) string {
+	if len(skipped) == 0 {
+		return ""
+	}
+	var ss []string
+	for _, h := range skipped {
+		ss = append(ss, fmt.Sprintf("%s on %s", h.Name,
+			h.Date.Format("Mon Jan 2")))
+	}
+	reason := "it's a holiday"
+	if len(ss) > 1 {
+		reason = "they're holidays"
+	}
+	return fmt.Sprintf("I skipped %s since %s. ",
+		language.SliceToString(ss, "and"), reason)
+}
+
+// handleReply processes a contact's choice of meeting time, creating the
+// event on the user's calendar and letting the user know.
+func handleReply(in *dt.Msg) (string, error) {
+	var req struct {
+		ID             uint64
+		UserID         uint64
+		ContactID      uint64
+		Title          string
+		DurationInMins int
+		ProposedTimes  []byte
+	}
+	q := `SELECT id, userid, contactid, title, durationinmins, proposedtimes
+	      FROM meetingrequests
+	      WHERE flexid=$1 AND flexidtype=$2 AND scheduledat IS NULL
+	        AND declined IS FALSE
+	      ORDER BY createdat DESC`
+	err := p.DB.Get(&req, q, in.User.FlexID, in.User.FlexIDType)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var times []time.Time
+	if err = json.Unmarshal(req.ProposedTimes, &times); err != nil {
+		return "", err
+	}
+	organizer := &dt.User{ID: req.UserID}
+	yes := language.ExtractYesNo(in.Sentence)
+	if (yes.Valid && !yes.Bool) || strings.Contains(
+		strings.ToLower(in.Sentence), "none") {
+
+		q = `UPDATE meetingrequests SET declined=TRUE WHERE id=$1`
+		if _, err = p.DB.Exec(q, req.ID); err != nil {
+			return "", err
+		}
+		msg := fmt.Sprintf("None of the times worked for %s (%s).",
+			in.User.FlexID, req.Title)
+		if _, err = p.Schedule(organizer, msg, time.Now()); err != nil {
+			return "", err
+		}
+		return "No problem. I'll let them know.", nil
+	}
+	n := language.ExtractCount(in.Sentence)
+	if !n.Valid || n.Int64 < 1 || int(n.Int64) > len(times) {
+		return `Which time works best? Just reply with its number, or "none" if none work.`, nil
+	}
+	t := times[n.Int64-1]
+	if len(cal.Drivers()) == 0 {
+		return "", fmt.Errorf("no calendar driver to create meeting %d",
+			req.ID)
+	}
+	contact := &dt.Contact{}
+	q = `SELECT id, name, email, phone, userid FROM contacts WHERE id=$1`
+	if err = p.DB.Get(contact, q, req.ContactID); err != nil {
+		return "", err
+	}
+	conn, err := cal.Open(cal.Drivers()[0], p.DB,
+		strconv.FormatUint(req.UserID, 10))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err = conn.Close(); err != nil {
+			p.Log.Info("failed to close calendar connection", err)
+		}
+	}()
+	err = conn.CreateEvent(&driver.EventParams{
+		Title:          req.Title,
+		StartTime:      &t,
+		DurationInMins: req.DurationInMins,
+		Attendees:      []*dt.Contact{contact},
+	})
+	if err != nil {
+		return "", err
+	}
+	q = `UPDATE meetingrequests SET scheduledat=$1 WHERE id=$2`
+	if _, err = p.DB.Exec(q, t, req.ID); err != nil {
+		return "", err
+	}
+	msg := fmt.Sprintf("%s is meeting with you %s. I've added it to your calendar.",
+		contact.Name, formatTime(t))
+	if _, err = p.Schedule(organizer, msg, time.Now()); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Great! You're all set for %s.", formatTime(t)), nil
+}
+
+// extractContactName returns the words following "with" up to the next word
+// describing time, e.g. "Sarah Smith" in "meet with Sarah Smith next week".
+func extractContactName(tokens []string) string {
+	var name []string
+	var found bool
+	for _, t := range tokens {
+		if !found {
+			found = strings.ToLower(t) == "with"
+			continue
+		}
+		switch strings.ToLower(t) {
+		case "next", "this", "today", "tomorrow", "on", "at", "for",
+			"about", "in", "sometime", ".", ",", "!", "?":
+			return strings.Join(name, " ")
+		}
+		name = append(name, t)
+	}
+	return strings.Join(name, " ")
+}
+
+// extractDuration returns the length of a meeting in minutes, or 0 if none is
+// found.
+func extractDuration(s string) int {
+	m := regexDuration.FindStringSubmatch(strings.ToLower(s))
+	if m == nil {
+		return 0
+	}
+	var n float64
+	switch {
+	case strings.HasPrefix(m[1], "half"):
+		n = 0.5
+	case m[1] == "a", m[1] == "an", m[1] == "one":
+		n = 1
+	default:
+		i, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0
+		}
+		n = float64(i)
+	}
+	if strings.HasPrefix(m[2], "h") {
+		n *= 60
+	}
+	return int(n)
+}
+
+// extractWindow returns the range of days in which to look for a meeting time,
+// defaulting to the coming week.
+func extractWindow(s string, now time.Time) dt.TimeRange {
+	y, mo, d := now.Date()
+	today := time.Date(y, mo, d, 0, 0, 0, 0, now.Location())
+	start := today.AddDate(0, 0, 1)
+	end := start.AddDate(0, 0, 7)
+	s = strings.ToLower(s)
+	switch {
+	case strings.Contains(s, "today"):
+		start, end = today, today.AddDate(0, 0, 1)
+	case strings.Contains(s, "tomorrow"):
+		end = start.AddDate(0, 0, 1)
+	case strings.Contains(s, "next week"):
+		offset := (8 - int(today.Weekday())) % 7
+		if offset == 0 {
+			offset = 7
+		}
+		start = today.AddDate(0, 0, offset)
+		end = start.AddDate(0, 0, 7)
+	case strings.Contains(s, "this week"):
+		end = today.AddDate(0, 0, 7-int(today.Weekday()))
+	}
+	return dt.TimeRange{Start: &start, End: &end}
+}
+
+// freeTimes returns up to maxProposals start times during business hours on
+// weekdays that don't conflict with existing events, preferring one time per
+// day to give the contact a variety of options. Public holidays are skipped
+// and returned so the user can be told about them.
+func freeTimes(evts []driver.Event, tr dt.TimeRange, mins int,
+	now time.Time) (times []time.Time, skipped []holidays.Holiday) {
+
+	dur := time.Duration(mins) * time.Minute
+	for day := *tr.Start; day.Before(*tr.End); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+		h, ok := holidays.On(holidays.DefaultCountry, day)
+		if ok && h.Public {
+			skipped = append(skipped, *h)
+			continue
+		}
+		y, mo, d := day.Date()
+		closeAt := time.Date(y, mo, d, 17, 0, 0, 0, day.Location())
+		t := time.Date(y, mo, d, 9, 0, 0, 0, day.Location())
+		for ; !t.Add(dur).After(closeAt); t = t.Add(30 * time.Minute) {
+			if t.Before(now) || conflicts(evts, t, dur) {
+				continue
+			}
+			times = append(times, t)
+			break
+		}
+		if len(times) == maxProposals {
+			break
+		}
+	}
+	return times, skipped
+}
+
+// conflicts reports whether a meeting at t would overlap any existing event.
+// All day events, like birthdays, don't block time.
+func conflicts(evts []driver.Event, t time.Time, dur time.Duration) bool {
+	for _, evt := range evts {
+		if evt.AllDay() || evt.StartTime() == nil {
+			continue
+		}
+		start := *evt.StartTime()
+		end := start.Add(time.Duration(evt.DurationInMins()) * time.Minute)
+		if t.Before(end) && start.Before(t.Add(dur)) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatTime(t time.Time) string {
+	return t.Format("Mon Jan 2 at 3:04PM")
+}
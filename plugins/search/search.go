@@ -0,0 +1,130 @@
+// Package search is a plugin that answers general questions by searching the
+// web, e.g. "Who wrote Moby Dick?" or "Search for the tallest building." It
+// handles any question that no other plugin claims, answering with a snippet
+// from the top result. Users can ask for "more" to hear the next result. It
+// requires a search driver, like the Bing, Brave or SearxNG drivers in
+// shared/interface/search, configured with ABOT_SEARCH_AUTH.
+package search
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/search"
+	"github.com/itsabot/abot/shared/nlp"
+	"github.com/itsabot/abot/shared/plugin"
+)
+
+var p *dt.Plugin
+var sm *dt.StateMachine
+
+// maxResults is the number of results a user can page through with "more."
+const maxResults = 5
+
+const (
+	memQuery  = "search_query"
+	memOffset = "search_offset"
+)
+
+// regexCommand matches requests to search that precede the query itself.
+var regexCommand = regexp.MustCompile(
+	`(?i)^\s*(please\s+)?(search( the web| online)?( for)?|google|look up)\s+`)
+
+// regexSentence matches the end of a sentence, used to trim snippets.
+var regexSentence = regexp.MustCompile(`[.!?](\s|$)`)
+
+func init() {
+	trigger := &nlp.StructuredInput{
+		Commands: []string{"search", "google", "look"},
+		Objects:  []string{"web", "internet", "online", "up"},
+	}
+	fns := &dt.PluginFns{Run: Run, FollowUp: FollowUp}
+	var err error
+	p, err = plugin.New("github.com/itsabot/abot/plugins/search", trigger,
+		fns)
+	if err != nil {
+		log.Fatal("failed to build plugin search", err)
+	}
+	plugin.HandleQuestions(p)
+	sm = dt.NewStateMachine(p)
+}
+
+// Run searches for the user's query and answers with the top result.
+func Run(in *dt.Msg) (string, error) {
+	q := regexCommand.ReplaceAllString(in.Sentence, "")
+	q = strings.TrimSpace(q)
+	if len(q) == 0 {
+		return "What should I search for?", nil
+	}
+	sm.SetMemory(in, memQuery, q)
+	sm.SetMemory(in, memOffset, 0)
+	return answer(q, 0)
+}
+
+// FollowUp answers with the next result when the user asks for more.
+// Otherwise it searches for the user's new query.
+func FollowUp(in *dt.Msg) (string, error) {
+	s := strings.ToLower(in.Sentence)
+	if !strings.Contains(s, "more") && !strings.Contains(s, "next") {
+		return Run(in)
+	}
+	if !sm.HasMemory(in, memQuery) {
+		return "What should I search for?", nil
+	}
+	q := sm.GetMemory(in, memQuery).String()
+	offset := int(sm.GetMemory(in, memOffset).Int64()) + 1
+	if offset >= maxResults {
+		return "That's all I found.", nil
+	}
+	sm.SetMemory(in, memOffset, offset)
+	return answer(q, offset)
+}
+
+// answer searches for a query and describes the result at the given offset.
+func answer(q string, offset int) (string, error) {
+	if len(search.Drivers()) == 0 {
+		return "I'm sorry, I can't search the web yet.", nil
+	}
+	conn, err := search.Open(search.Drivers()[0],
+		os.Getenv("ABOT_SEARCH_AUTH"))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err = conn.Close(); err != nil {
+			p.Log.Info("failed to close search connection", err)
+		}
+	}()
+	results, err := conn.Search(q, maxResults)
+	if err != nil {
+		return "", err
+	}
+	if len(results) == 0 {
+		return "I couldn't find anything about that.", nil
+	}
+	if offset >= len(results) {
+		return "That's all I found.", nil
+	}
+	r := results[offset]
+	snippet := trimSnippet(r.Snippet, 2)
+	if len(snippet) == 0 {
+		return fmt.Sprintf("Here's what I found: %s %s", r.Title, r.URL),
+			nil
+	}
+	return fmt.Sprintf("%s (from %s)", snippet, r.URL), nil
+}
+
+// trimSnippet shortens a snippet to its first n sentences, dropping any
+// trailing partial sentence left by the search service.
+func trimSnippet(s string, n int) string {
+	s = strings.TrimSpace(s)
+	locs := regexSentence.FindAllStringIndex(s, n)
+	if len(locs) == 0 {
+		return s
+	}
+	return strings.TrimSpace(s[:locs[len(locs)-1][0]+1])
+}
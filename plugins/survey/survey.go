@@ -0,0 +1,259 @@
+// Package survey is a plugin that asks users short surveys over chat, like a
+// Net Promoter Score (NPS) survey. A survey is scheduled automatically a while
+// after a user's conversation with another plugin, at most once per
+// surveyInterval, and users can also ask to "give feedback" at any time.
+// Questions are either ratings from 0 to 10 or free text. Results are
+// available in Abot's analytics.
+package survey
+
+import (
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+	"github.com/itsabot/abot/shared/plugin"
+)
+
+var p *dt.Plugin
+
+// route is saved with survey questions sent to users, so their replies are
+// routed back to this plugin. See dt.Msg.GetLastRoute.
+const route = "take_survey"
+
+const (
+	// surveyDelay is how long after a conversation a survey is sent.
+	surveyDelay = 2 * time.Hour
+
+	// surveyInterval is the minimum time between surveys for a user.
+	surveyInterval = 90 * 24 * time.Hour
+
+	// checkInterval is how often Abot checks for surveys that are due.
+	checkInterval = 5 * time.Minute
+)
+
+// question in a survey. Rating questions take a number from 0 to 10, and all
+// others take free text.
+type question struct {
+	Text   string
+	Rating bool
+}
+
+// surveys maps the names of surveys to their questions.
+var surveys = map[string][]question{
+	"nps": {
+		{"On a scale of 0 to 10, how likely are you to recommend me to a friend?", true},
+		{"Thanks! What's the main reason for your score?", false},
+	},
+}
+
+// defaultSurvey is sent after conversations and when users give feedback.
+const defaultSurvey = "nps"
+
+var regexRating = regexp.MustCompile(`\b(10|[0-9])\b`)
+
+func init() {
+	trigger := &nlp.StructuredInput{
+		Commands: []string{"take", "give", "leave", "send"},
+		Objects:  []string{"survey", "feedback"},
+	}
+	fns := &dt.PluginFns{Run: Run, FollowUp: FollowUp}
+	var err error
+	p, err = plugin.New("github.com/itsabot/abot/plugins/survey", trigger,
+		fns)
+	if err != nil {
+		log.Fatal("failed to build plugin survey", err)
+	}
+	p.Events.PostResponse = scheduleAfter
+	plugin.Every(p, checkInterval, sendDue)
+	plugin.Metric(p, "nps", nps)
+	plugin.Metric(p, "comments", comments)
+}
+
+// Run starts the default survey right away.
+func Run(in *dt.Msg) (string, error) {
+	if !in.User.Registered() {
+		return "Thanks, but I can only take feedback from people who've signed up.", nil
+	}
+	now := time.Now()
+	q := `INSERT INTO surveys (userid, name, sendat, sentat)
+	      VALUES ($1, $2, $3, $3)`
+	if _, err := p.DB.Exec(q, in.User.ID, defaultSurvey, now); err != nil {
+		return "", err
+	}
+	return surveys[defaultSurvey][0].Text, nil
+}
+
+// FollowUp records the user's answer to the current question of their open
+// survey and asks the next one.
+func FollowUp(in *dt.Msg) (string, error) {
+	var s struct {
+		ID       uint64
+		Name     string
+		Question int
+	}
+	q := `SELECT id, name, question FROM surveys
+	      WHERE userid=$1 AND sentat IS NOT NULL AND completedat IS NULL
+	      ORDER BY sentat DESC`
+	err := p.DB.Get(&s, q, in.User.ID)
+	if err == sql.ErrNoRows {
+		// The survey is over, so there's nothing to say.
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	qs := surveys[s.Name]
+	if s.Question >= len(qs) {
+		return "", complete(s.ID)
+	}
+	lower := strings.ToLower(in.Sentence)
+	if strings.Contains(lower, "skip") || strings.Contains(lower, "stop") {
+		return "No problem.", complete(s.ID)
+	}
+	var rating sql.NullInt64
+	var answer string
+	if qs[s.Question].Rating {
+		m := regexRating.FindString(in.Sentence)
+		if len(m) == 0 {
+			return `Please reply with a number from 0 to 10, or "skip" to skip the survey.`, nil
+		}
+		n, _ := strconv.Atoi(m)
+		rating = sql.NullInt64{Int64: int64(n), Valid: true}
+	} else {
+		answer = in.Sentence
+	}
+	q = `INSERT INTO surveyresponses (surveyid, question, rating, answer)
+	     VALUES ($1, $2, $3, $4)`
+	_, err = p.DB.Exec(q, s.ID, s.Question, rating, answer)
+	if err != nil {
+		return "", err
+	}
+	s.Question++
+	if s.Question >= len(qs) {
+		return "Thanks for the feedback!", complete(s.ID)
+	}
+	q = `UPDATE surveys SET question=$1 WHERE id=$2`
+	if _, err = p.DB.Exec(q, s.Question, s.ID); err != nil {
+		return "", err
+	}
+	return qs[s.Question].Text, nil
+}
+
+func complete(id uint64) error {
+	q := `UPDATE surveys SET completedat=$1 WHERE id=$2`
+	_, err := p.DB.Exec(q, time.Now(), id)
+	return err
+}
+
+// scheduleAfter schedules the default survey to be sent to a registered user
+// after their conversation with another plugin, unless they've been surveyed
+// recently.
+func scheduleAfter(in *dt.Msg, resp *string) {
+	if in.User == nil || !in.User.Registered() {
+		return
+	}
+	if len(in.Plugin) == 0 || in.Plugin == p.Config.Name {
+		return
+	}
+	now := time.Now()
+	q := `INSERT INTO surveys (userid, name, sendat)
+	      SELECT $1, $2, $3
+	      WHERE NOT EXISTS (
+		SELECT 1 FROM surveys WHERE userid=$1 AND createdat>$4
+	      )`
+	_, err := p.DB.Exec(q, in.User.ID, defaultSurvey,
+		now.Add(surveyDelay), now.Add(-surveyInterval))
+	if err != nil {
+		p.Log.Info("failed to schedule survey", err)
+	}
+}
+
+// sendDue sends the first question of each survey that's due, saving it as a
+// routed message so the user's reply comes back to this plugin.
+func sendDue() error {
+	var due []struct {
+		ID     uint64
+		UserID uint64
+		Name   string
+	}
+	now := time.Now()
+	q := `SELECT id, userid, name FROM surveys
+	      WHERE sentat IS NULL AND sendat<=$1`
+	if err := p.DB.Select(&due, q, now); err != nil {
+		return err
+	}
+	for _, s := range due {
+		u := &dt.User{ID: s.UserID}
+		fid, fidT, err := u.LastFlexID(p.DB)
+		if err != nil {
+			return err
+		}
+		u.FlexID, u.FlexIDType = fid, fidT
+		content := "Quick question: " + surveys[s.Name][0].Text
+		if _, err = p.Schedule(u, content, now); err != nil {
+			return err
+		}
+		m := &dt.Msg{
+			User:     u,
+			Sentence: content,
+			AbotSent: true,
+			Plugin:   p.Config.Name,
+			Route:    route,
+		}
+		if err = m.Save(p.DB); err != nil {
+			return err
+		}
+		q = `UPDATE surveys SET sentat=$1 WHERE id=$2`
+		if _, err = p.DB.Exec(q, now, s.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nps computes the Net Promoter Score over the last surveyInterval: the
+// percentage of promoters rating 9 or 10 less the percentage of detractors
+// rating 0 to 6.
+func nps() (interface{}, error) {
+	var res struct {
+		Score      int
+		Responses  int
+		Promoters  int
+		Detractors int
+	}
+	q := `SELECT COUNT(*) AS responses,
+	          COUNT(*) FILTER (WHERE r.rating>=9) AS promoters,
+	          COUNT(*) FILTER (WHERE r.rating<=6) AS detractors
+	      FROM surveyresponses r
+	      JOIN surveys s ON s.id=r.surveyid
+	      WHERE s.name=$1 AND r.question=0 AND r.rating IS NOT NULL
+	        AND r.createdat>$2`
+	err := p.DB.Get(&res, q, defaultSurvey,
+		time.Now().Add(-surveyInterval))
+	if err != nil {
+		return nil, err
+	}
+	if res.Responses > 0 {
+		res.Score = 100 * (res.Promoters - res.Detractors) /
+			res.Responses
+	}
+	return res, nil
+}
+
+// comments returns the most recent free text answers.
+func comments() (interface{}, error) {
+	var answers []string
+	q := `SELECT answer FROM surveyresponses
+	      WHERE answer<>''
+	      ORDER BY createdat DESC
+	      LIMIT 10`
+	if err := p.DB.Select(&answers, q); err != nil {
+		return nil, err
+	}
+	return answers, nil
+}
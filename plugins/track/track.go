@@ -0,0 +1,258 @@
+// Package track is a plugin that tracks packages for a user. Users send Abot a
+// tracking number, and Abot lets them know whenever the package's status
+// changes until it's delivered.
+package track
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/carrier"
+	"github.com/itsabot/abot/shared/nlp"
+	"github.com/itsabot/abot/shared/plugin"
+)
+
+var p *dt.Plugin
+
+// pollInterval is how often carriers are checked for updates to undelivered
+// shipments.
+const pollInterval = 30 * time.Minute
+
+// notRegisteredLang is sent to users who haven't signed up, since Abot can't
+// tell their shipments apart or reach them with updates.
+const notRegisteredLang = "I'm sorry, I can only track packages for people who've signed up."
+
+// shipment is a package being tracked on behalf of a user.
+type shipment struct {
+	ID             uint64
+	UserID         uint64
+	Carrier        string
+	TrackingNumber string
+	Status         string
+}
+
+// carrierNumbers maps carriers to the formats of their tracking numbers. Order
+// matters, since FedEx's numeric formats would otherwise match the start of a
+// USPS number.
+var carrierNumbers = []struct {
+	carrier string
+	regex   *regexp.Regexp
+}{
+	{"ups", regexp.MustCompile(`\b1Z[0-9A-Z]{16}\b`)},
+	{"usps", regexp.MustCompile(`\b(9[2-5]\d{20}|[A-Z]{2}\d{9}US)\b`)},
+	{"fedex", regexp.MustCompile(`\b(\d{15}|\d{12})\b`)},
+}
+
+func init() {
+	trigger := &nlp.StructuredInput{
+		Commands: []string{"track", "where", "find", "check"},
+		Objects: []string{"package", "shipment", "order", "delivery",
+			"parcel"},
+	}
+	fns := &dt.PluginFns{Run: Run, FollowUp: FollowUp, Digest: summarize}
+	var err error
+	p, err = plugin.New("github.com/itsabot/abot/plugins/track", trigger,
+		fns)
+	if err != nil {
+		log.Fatal("failed to build plugin track", err)
+	}
+	plugin.Every(p, pollInterval, poll)
+}
+
+// Run starts tracking any numbers in the user's message. If there are none,
+// it reports the status of the user's undelivered packages.
+func Run(in *dt.Msg) (string, error) {
+	if !in.User.Registered() {
+		return notRegisteredLang, nil
+	}
+	nums := extractTrackingNumbers(in.Sentence)
+	if len(nums) == 0 {
+		resp, err := summarize(in.User)
+		if err != nil || len(resp) > 0 {
+			return resp, err
+		}
+		return "What's the tracking number?", nil
+	}
+	return FollowUp(in)
+}
+
+// FollowUp starts tracking any numbers in the user's message.
+func FollowUp(in *dt.Msg) (string, error) {
+	if !in.User.Registered() {
+		return "", nil
+	}
+	nums := extractTrackingNumbers(in.Sentence)
+	if len(nums) == 0 {
+		return "", nil
+	}
+	var resps []string
+	for num, c := range nums {
+		resp, err := track(in.User, c, num)
+		if err != nil {
+			return "", err
+		}
+		resps = append(resps, resp)
+	}
+	return strings.Join(resps, " "), nil
+}
+
+// track saves a shipment and returns its current status.
+func track(u *dt.User, carrierName, num string) (string, error) {
+	name := strings.ToUpper(carrierName)
+	if !hasDriver(carrierName) {
+		return fmt.Sprintf("I'm sorry, I can't track %s packages yet.",
+			name), nil
+	}
+	conn, err := carrier.Open(carrierName)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err = conn.Close(); err != nil {
+			p.Log.Info("failed to close carrier connection", err)
+		}
+	}()
+	status, err := conn.Track(num)
+	if err != nil {
+		return "", err
+	}
+	q := `INSERT INTO shipments
+	      (userid, carrier, trackingnumber, status, delivered)
+	      VALUES ($1, $2, $3, $4, $5)
+	      ON CONFLICT (userid, trackingnumber)
+	      DO UPDATE SET status=$4, delivered=$5, updatedat=$6`
+	_, err = p.DB.Exec(q, u.ID, carrierName, num, status.Description,
+		status.Delivered, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if status.Delivered {
+		return fmt.Sprintf("Your %s package was delivered.", name), nil
+	}
+	resp := fmt.Sprintf("Your %s package is %s.", name,
+		strings.ToLower(status.Description))
+	if status.EstimatedDelivery != nil {
+		resp += fmt.Sprintf(" It should arrive %s.",
+			status.EstimatedDelivery.Format("Monday, Jan 2"))
+	}
+	return resp + " I'll let you know when that changes.", nil
+}
+
+// summarize the statuses of a user's undelivered packages. It's also used as
+// the plugin's digest.
+func summarize(u *dt.User) (string, error) {
+	if !u.Registered() {
+		return "", nil
+	}
+	var ships []shipment
+	q := `SELECT id, carrier, trackingnumber, status FROM shipments
+	      WHERE userid=$1 AND delivered IS FALSE
+	      ORDER BY createdat`
+	if err := p.DB.Select(&ships, q, u.ID); err != nil {
+		return "", err
+	}
+	var resps []string
+	for _, s := range ships {
+		resps = append(resps, fmt.Sprintf("Your %s package ending in %s is %s.",
+			strings.ToUpper(s.Carrier), last4(s.TrackingNumber),
+			strings.ToLower(s.Status)))
+	}
+	return strings.Join(resps, " "), nil
+}
+
+// poll checks carriers for updates to undelivered shipments and notifies users
+// of any changes. Shipments saved for users who hadn't signed up can't be
+// told apart, so they're skipped.
+func poll() error {
+	var ships []shipment
+	q := `SELECT id, userid, carrier, trackingnumber, status FROM shipments
+	      WHERE delivered IS FALSE AND userid>0`
+	if err := p.DB.Select(&ships, q); err != nil {
+		return err
+	}
+	conns := map[string]*carrier.Conn{}
+	defer func() {
+		for _, conn := range conns {
+			if err := conn.Close(); err != nil {
+				p.Log.Info("failed to close carrier connection",
+					err)
+			}
+		}
+	}()
+	for _, s := range ships {
+		conn, ok := conns[s.Carrier]
+		if !ok {
+			if !hasDriver(s.Carrier) {
+				continue
+			}
+			var err error
+			conn, err = carrier.Open(s.Carrier)
+			if err != nil {
+				return err
+			}
+			conns[s.Carrier] = conn
+		}
+		status, err := conn.Track(s.TrackingNumber)
+		if err != nil {
+			p.Log.Info("failed to track", s.TrackingNumber, err)
+			continue
+		}
+		if status.Description == s.Status && !status.Delivered {
+			continue
+		}
+		q = `UPDATE shipments SET status=$1, delivered=$2, updatedat=$3
+		     WHERE id=$4`
+		_, err = p.DB.Exec(q, status.Description, status.Delivered,
+			time.Now(), s.ID)
+		if err != nil {
+			return err
+		}
+		msg := fmt.Sprintf("Update on your %s package ending in %s: %s",
+			strings.ToUpper(s.Carrier), last4(s.TrackingNumber),
+			status.Description)
+		if len(status.Location) > 0 {
+			msg += " in " + status.Location
+		}
+		u := &dt.User{ID: s.UserID}
+		if _, err = p.Schedule(u, msg+".", time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTrackingNumbers returns a map of tracking numbers found in a sentence
+// to their carriers.
+func extractTrackingNumbers(s string) map[string]string {
+	nums := map[string]string{}
+	s = strings.ToUpper(s)
+	for _, cn := range carrierNumbers {
+		for _, num := range cn.regex.FindAllString(s, -1) {
+			if _, exists := nums[num]; !exists {
+				nums[num] = cn.carrier
+			}
+		}
+		s = cn.regex.ReplaceAllString(s, "")
+	}
+	return nums
+}
+
+func hasDriver(carrierName string) bool {
+	for _, d := range carrier.Drivers() {
+		if d == carrierName {
+			return true
+		}
+	}
+	return false
+}
+
+func last4(s string) string {
+	if len(s) <= 4 {
+		return s
+	}
+	return s[len(s)-4:]
+}
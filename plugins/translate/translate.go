@@ -0,0 +1,101 @@
+// Package translate is a plugin that translates phrases for a user, e.g.
+// "Translate good morning to Spanish" or "How do you say thank you in French?"
+// It requires a translation driver to be imported.
+package translate
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/translate"
+	"github.com/itsabot/abot/shared/nlp"
+	"github.com/itsabot/abot/shared/plugin"
+)
+
+var p *dt.Plugin
+
+// languages maps the names of supported languages to their ISO 639-1 codes.
+var languages = map[string]string{
+	"arabic":     "ar",
+	"chinese":    "zh",
+	"dutch":      "nl",
+	"english":    "en",
+	"french":     "fr",
+	"german":     "de",
+	"greek":      "el",
+	"hebrew":     "he",
+	"hindi":      "hi",
+	"italian":    "it",
+	"japanese":   "ja",
+	"korean":     "ko",
+	"polish":     "pl",
+	"portuguese": "pt",
+	"russian":    "ru",
+	"spanish":    "es",
+	"swedish":    "sv",
+	"turkish":    "tr",
+	"vietnamese": "vi",
+}
+
+// regexPhrase matches the phrase to be translated and the language to
+// translate it into, e.g. "translate 'good morning' to spanish" or "how do you
+// say thank you in french".
+var regexPhrase = regexp.MustCompile(
+	`(?:translate|say)\s+(.+?)\s+(?:to|into|in)\s+([a-z]+)\W*$`)
+
+func init() {
+	objs := []string{}
+	for name := range languages {
+		objs = append(objs, name)
+	}
+	trigger := &nlp.StructuredInput{
+		Commands: []string{"translate", "say"},
+		Objects:  objs,
+	}
+	fns := &dt.PluginFns{Run: Run, FollowUp: FollowUp}
+	var err error
+	p, err = plugin.New("github.com/itsabot/abot/plugins/translate",
+		trigger, fns)
+	if err != nil {
+		log.Fatal("failed to build plugin translate", err)
+	}
+}
+
+// Run translates the phrase in a user's message.
+func Run(in *dt.Msg) (string, error) {
+	return FollowUp(in)
+}
+
+// FollowUp translates the phrase in a user's message.
+func FollowUp(in *dt.Msg) (string, error) {
+	m := regexPhrase.FindStringSubmatch(strings.ToLower(in.Sentence))
+	if m == nil {
+		return "", nil
+	}
+	lang, ok := languages[m[2]]
+	if !ok {
+		return "I'm sorry, I don't know that language.", nil
+	}
+	if len(translate.Drivers()) == 0 {
+		return "I'm sorry, I can't translate yet.", nil
+	}
+	conn, err := translate.Open(translate.Drivers()[0],
+		os.Getenv("ABOT_TRANSLATE_AUTH"))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err = conn.Close(); err != nil {
+			p.Log.Info("failed to close translate connection", err)
+		}
+	}()
+	phrase := strings.Trim(m[1], `"'`)
+	s, err := conn.Translate(phrase, "en", lang)
+	if err != nil {
+		return "", err
+	}
+	return `In ` + strings.Title(m[2]) + `, that's "` + s + `"`, nil
+}
@@ -0,0 +1,77 @@
+package dt
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Alias is a shortcut a user has defined for something they ask for often,
+// like "usual" for "a large pepperoni to my home address."
+type Alias struct {
+	Name      string
+	Expansion string
+}
+
+// ErrNoAlias signals that a user has no alias by a name.
+var ErrNoAlias = errors.New("no alias")
+
+// aliasPrefix distinguishes aliases from other preferences.
+const aliasPrefix = "alias_"
+
+// Aliases returns the aliases a user has defined, by name.
+func (u *User) Aliases(db *sqlx.DB) ([]Alias, error) {
+	q := `SELECT SUBSTRING(key FROM $1) AS name, value AS expansion
+	      FROM preferences
+	      WHERE userid=$2 AND pkgname IS NULL AND key LIKE $3
+	      ORDER BY key`
+	var as []Alias
+	err := db.Select(&as, q, len(aliasPrefix)+1, u.ID, aliasPrefix+"%")
+	if err != nil {
+		return nil, err
+	}
+	return as, nil
+}
+
+// SetAlias defines or redefines one of the user's aliases. Names are
+// case-insensitive.
+func (u *User) SetAlias(db *sqlx.DB, name, expansion string) error {
+	key := aliasPrefix + strings.ToLower(strings.TrimSpace(name))
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	q := `DELETE FROM preferences
+	      WHERE userid=$1 AND pkgname IS NULL AND key=$2`
+	if _, err = tx.Exec(q, u.ID, key); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	q = `INSERT INTO preferences (userid, key, value) VALUES ($1, $2, $3)`
+	if _, err = tx.Exec(q, u.ID, key, strings.TrimSpace(expansion)); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteAlias deletes one of the user's aliases, returning ErrNoAlias if they
+// have none by that name.
+func (u *User) DeleteAlias(db *sqlx.DB, name string) error {
+	q := `DELETE FROM preferences
+	      WHERE userid=$1 AND pkgname IS NULL AND key=$2`
+	res, err := db.Exec(q, u.ID,
+		aliasPrefix+strings.ToLower(strings.TrimSpace(name)))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoAlias
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package dt
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultAuthMaxAge is how recently users must have authenticated when an
+// AuthPolicy doesn't say.
+const DefaultAuthMaxAge = 7 * 24 * time.Hour
+
+// ActionPurchase is the action checked before charging a user, so an
+// AuthPolicy can demand users authenticated more recently to buy things.
+const ActionPurchase = "purchase"
+
+// ErrAuthRequired is returned when an action requires the user to have
+// authenticated more recently than they did.
+var ErrAuthRequired = errors.New("authentication required")
+
+// AuthPolicy controls how recently users must have authenticated, e.g. by
+// logging in or passing a challenge, before acting, so a plugin that changes
+// a user's address can demand a shorter window than one that reads the
+// weather.
+type AuthPolicy struct {
+	// MaxAge is how recently users must have authenticated for actions
+	// without an override. It defaults to DefaultAuthMaxAge.
+	MaxAge time.Duration
+
+	// PerActionOverrides replaces MaxAge for actions, like a plugin's
+	// name or ActionPurchase.
+	PerActionOverrides map[string]time.Duration
+
+	// RequireForPurchases refuses to charge users who haven't
+	// authenticated within the max age for ActionPurchase.
+	RequireForPurchases bool
+}
+
+// MaxAgeFor returns how recently users must have authenticated for an
+// action.
+func (p *AuthPolicy) MaxAgeFor(action string) time.Duration {
+	if p == nil {
+		return DefaultAuthMaxAge
+	}
+	if d, ok := p.PerActionOverrides[action]; ok {
+		return d
+	}
+	if p.MaxAge > 0 {
+		return p.MaxAge
+	}
+	return DefaultAuthMaxAge
+}
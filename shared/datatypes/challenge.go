@@ -0,0 +1,55 @@
+package dt
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrNoChallenger is returned when challenging a user without a Challenger
+// set.
+var ErrNoChallenger = errors.New("no challenger set")
+
+// ErrNoSecondFactor is returned when challenging a user who has neither
+// enrolled an authenticator app nor linked a phone number to text a code to.
+var ErrNoSecondFactor = errors.New("no second factor")
+
+// ErrTooManyAttempts is returned when a user has sent too many wrong codes or
+// asked for too many codes recently. They may try again later.
+var ErrTooManyAttempts = errors.New("too many attempts")
+
+// Challenger asks users to prove it's them with a second factor, like a code
+// from an authenticator app or one texted to their phone. See SetChallenger.
+type Challenger interface {
+	// Challenge reports whether the user recently passed a challenge for
+	// the reason. If they haven't, it starts one, returning a prompt
+	// asking the user for their code.
+	Challenge(u *User, reason string) (bool, string, error)
+}
+
+var challengerMu sync.RWMutex
+var challenger Challenger
+
+// SetChallenger sets how users are challenged by ChallengeUser.
+func SetChallenger(c Challenger) {
+	challengerMu.Lock()
+	defer challengerMu.Unlock()
+	challenger = c
+}
+
+// ChallengeUser asks a user to prove it's them before a sensitive action, like
+// a purchase or changing their address. It returns true if the user recently
+// passed a challenge for the same reason, e.g. "purchase." Otherwise a
+// challenge is started, and a prompt asking the user for their code is
+// returned for the plugin to reply with. Codes the user sends back are checked
+// by Abot and removed from their message, which is then routed as usual, so
+// plugins should call ChallengeUser again in the same state until it returns
+// true.
+func ChallengeUser(u *User, reason string) (bool, string, error) {
+	challengerMu.RLock()
+	c := challenger
+	challengerMu.RUnlock()
+	if c == nil {
+		return false, "", ErrNoChallenger
+	}
+	return c.Challenge(u, reason)
+}
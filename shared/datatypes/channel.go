@@ -0,0 +1,95 @@
+package dt
+
+// Names of the channels users message Abot through.
+const (
+	ChannelWeb   = "web"
+	ChannelSMS   = "sms"
+	ChannelEmail = "email"
+)
+
+// Channel describes what a channel users message Abot through can display, so
+// responses can be rendered to look right on each. Operators can override a
+// channel's defaults with the channel_<name> setting, e.g. to lower the
+// MaxLength of SMS when their provider doesn't join long messages.
+type Channel struct {
+	Name string
+
+	// MaxLength is the most characters a message may have, or 0 for no
+	// limit. Longer responses are cut off at a word.
+	MaxLength int
+
+	// MaxSegments is the most SMS segments a message may be sent as, or 0
+	// for no limit. Longer responses are cut off at a word. How many
+	// characters fit depends on the message's encoding. See
+	// sms.Segments.
+	MaxSegments int
+
+	// SplitSegments is true when long SMS messages are sent as separate
+	// messages of a segment each, split between words, for providers
+	// that don't join segments or join them out of order. See sms.Split.
+	SplitSegments bool
+
+	// Markdown is true when the channel formats Markdown. Otherwise
+	// Markdown is stripped, leaving plain text.
+	Markdown bool
+
+	// Emoji is true when the channel displays emoji. Otherwise they're
+	// removed, since SMS messages with emoji are sent in a more expensive
+	// encoding and some phones can't show them.
+	Emoji bool
+
+	// MaxButtons is the most buttons shown with a response, or 0 for no
+	// limit. Extra buttons are left out.
+	MaxButtons int
+
+	// Progress is true when plugins' interim updates on slow requests,
+	// like "Searching flights...," are sent on the channel. See
+	// core.SendProgress.
+	Progress bool
+}
+
+// Channels are the default capabilities of each channel.
+var Channels = map[string]Channel{
+	ChannelWeb: {
+		Name:       ChannelWeb,
+		Markdown:   true,
+		Emoji:      true,
+		MaxButtons: 10,
+		Progress:   true,
+	},
+	ChannelSMS: {
+		Name:        ChannelSMS,
+		MaxLength:   1600,
+		MaxSegments: 10,
+		MaxButtons:  3,
+		Progress:    true,
+	},
+	ChannelEmail: {
+		Name:  ChannelEmail,
+		Emoji: true,
+	},
+}
+
+// ChannelName returns the name of the channel a FlexIDType is reached
+// through, including those added by RegisterFlexIDType. Users identified any
+// other way are assumed to be on the web.
+func ChannelName(t FlexIDType) string {
+	switch t {
+	case fidtPhone:
+		return ChannelSMS
+	case fidtEmail:
+		return ChannelEmail
+	}
+	if def, ok := registeredFlexIDType(t); ok {
+		return def.Channel.Name
+	}
+	return ChannelWeb
+}
+
+// Response is Abot's reply to a user, rendered for the channel it's sent
+// through. Buttons are choices the user can reply with, like "Yes" and "No,"
+// listed by number so they work on channels without real buttons.
+type Response struct {
+	Text    string
+	Buttons []string
+}
@@ -0,0 +1,36 @@
+package dt
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Charge is a payment made by a user through a payment driver. Amounts are in
+// cents.
+type Charge struct {
+	ID              uint64
+	UserID          uint64
+	CardID          uint64
+	Amount          uint64
+	RefundedAmount  uint64
+	Currency        string
+	Description     string
+	ServiceChargeID string
+	DisputedAt      *time.Time
+	CreatedAt       *time.Time
+}
+
+// ErrNoCard is returned when a user is charged but has no card on file.
+var ErrNoCard = errors.New("no card on file")
+
+// Receipt describes the charge to the user.
+func (c *Charge) Receipt(card *Card) string {
+	s := fmt.Sprintf("Receipt #%d: $%.2f for %s", c.ID,
+		float64(c.Amount)/100, c.Description)
+	if card != nil {
+		s += fmt.Sprintf(", charged to your %s ending in %s", card.Brand,
+			card.Last4)
+	}
+	return s + "."
+}
@@ -0,0 +1,190 @@
+package dt
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrNoRecord is returned when getting a record that isn't in a collection.
+var ErrNoRecord = errors.New("no record")
+
+// ErrInvalidRecord is returned when a record or query doesn't match a
+// collection's schema.
+var ErrInvalidRecord = errors.New("invalid record")
+
+// FieldType is the JSON type of a field in a collection's records.
+type FieldType string
+
+// FieldTypes of records' fields.
+const (
+	FieldString FieldType = "string"
+	FieldNumber FieldType = "number"
+	FieldBool   FieldType = "bool"
+	FieldObject FieldType = "object"
+	FieldArray  FieldType = "array"
+)
+
+// Field describes a field in a collection's records.
+type Field struct {
+	Type     FieldType
+	Required bool
+}
+
+// Schema maps the fields of a collection's records to their descriptions.
+// Records with fields not in the schema are invalid.
+type Schema map[string]Field
+
+// Collection stores a plugin's records as JSON, like shipments being tracked
+// or survey responses, without the plugin creating tables of its own. Each
+// record has a key unique in its collection, and collections are kept apart
+// by plugin, so two plugins may use the same collection names.
+type Collection struct {
+	db     *sqlx.DB
+	plugin string
+	name   string
+	schema Schema
+}
+
+// Collection returns one of the plugin's collections of records. Records are
+// checked against the schema when set, unless it's nil.
+func (p *Plugin) Collection(name string, schema Schema) *Collection {
+	return &Collection{
+		db:     p.DB,
+		plugin: p.Config.Name,
+		name:   name,
+		schema: schema,
+	}
+}
+
+// Get decodes the record with a key into v, or returns ErrNoRecord if there's
+// none.
+func (c *Collection) Get(key string, v interface{}) error {
+	q := `SELECT data FROM pluginrecords
+	      WHERE pluginname=$1 AND collection=$2 AND key=$3`
+	var byt []byte
+	err := c.db.Get(&byt, q, c.plugin, c.name, key)
+	if err == sql.ErrNoRows {
+		return ErrNoRecord
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(byt, v)
+}
+
+// Set encodes v as JSON and stores it as the record with a key, replacing any
+// record already there. v must encode to a JSON object matching the
+// collection's schema.
+func (c *Collection) Set(key string, v interface{}) error {
+	byt, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err = c.validate(byt); err != nil {
+		return err
+	}
+	q := `INSERT INTO pluginrecords (pluginname, collection, key, data)
+	      VALUES ($1, $2, $3, $4)
+	      ON CONFLICT (pluginname, collection, key) DO UPDATE
+	      SET data=$4, updatedat=CURRENT_TIMESTAMP`
+	_, err = c.db.Exec(q, c.plugin, c.name, key, string(byt))
+	return err
+}
+
+// Delete deletes the record with a key. It is not an error to delete a record
+// that doesn't exist.
+func (c *Collection) Delete(key string) error {
+	q := `DELETE FROM pluginrecords
+	      WHERE pluginname=$1 AND collection=$2 AND key=$3`
+	_, err := c.db.Exec(q, c.plugin, c.name, key)
+	return err
+}
+
+// Query decodes the records whose fields equal those in where into v, a
+// pointer to a slice, most recently set first. For example, {"Status":
+// "shipped", "UserID": 3} finds a user's shipped orders. An empty where
+// returns every record. Queries are indexed on every field, so plugins needn't
+// declare indexes.
+func (c *Collection) Query(where map[string]interface{}, v interface{}) error {
+	if c.schema != nil {
+		for k := range where {
+			if _, ok := c.schema[k]; !ok {
+				return fmt.Errorf("%s: unknown field %q",
+					ErrInvalidRecord, k)
+			}
+		}
+	}
+	if where == nil {
+		where = map[string]interface{}{}
+	}
+	filter, err := json.Marshal(where)
+	if err != nil {
+		return err
+	}
+	q := `SELECT data FROM pluginrecords
+	      WHERE pluginname=$1 AND collection=$2 AND data @> $3
+	      ORDER BY updatedat DESC`
+	var rows []string
+	err = c.db.Select(&rows, q, c.plugin, c.name, string(filter))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte("["+strings.Join(rows, ",")+"]"), v)
+}
+
+// validate checks that a record is a JSON object matching the collection's
+// schema.
+func (c *Collection) validate(byt []byte) error {
+	var rec map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(byt))
+	dec.UseNumber()
+	if err := dec.Decode(&rec); err != nil || rec == nil {
+		return fmt.Errorf("%s: not an object", ErrInvalidRecord)
+	}
+	if c.schema == nil {
+		return nil
+	}
+	for k, val := range rec {
+		f, ok := c.schema[k]
+		if !ok {
+			return fmt.Errorf("%s: unknown field %q", ErrInvalidRecord,
+				k)
+		}
+		if val == nil {
+			continue
+		}
+		if !f.Type.matches(val) {
+			return fmt.Errorf("%s: %q isn't a %s", ErrInvalidRecord, k,
+				f.Type)
+		}
+	}
+	for k, f := range c.schema {
+		if f.Required && rec[k] == nil {
+			return fmt.Errorf("%s: missing %q", ErrInvalidRecord, k)
+		}
+	}
+	return nil
+}
+
+// matches reports whether a value decoded from JSON is of the type.
+func (t FieldType) matches(val interface{}) bool {
+	switch val.(type) {
+	case string:
+		return t == FieldString
+	case json.Number:
+		return t == FieldNumber
+	case bool:
+		return t == FieldBool
+	case map[string]interface{}:
+		return t == FieldObject
+	case []interface{}:
+		return t == FieldArray
+	}
+	return false
+}
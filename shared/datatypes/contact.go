@@ -0,0 +1,80 @@
+package dt
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Contact is a person known to a user, such as a friend or coworker, whom
+// plugins can reach on the user's behalf via email or SMS.
+type Contact struct {
+	ID     uint64
+	Name   string
+	Email  sql.NullString
+	Phone  sql.NullString
+	UserID uint64
+}
+
+// ErrNoContact signals that no contact could be found when one was expected.
+var ErrNoContact = errors.New("no contact")
+
+// GetName satisfies the Contactable interface.
+func (c *Contact) GetName() string {
+	return c.Name
+}
+
+// GetEmail satisfies the Contactable interface.
+func (c *Contact) GetEmail() string {
+	return c.Email.String
+}
+
+// FindContact searches a user's contacts for the closest match to a given
+// name. Matching is fuzzy using Postgres trigrams, so "Sara" will find
+// "Sarah Smith".
+func (u *User) FindContact(db *sqlx.DB, name string) (*Contact, error) {
+	q := `SELECT id, name, email, phone, userid
+	      FROM contacts
+	      WHERE userid=$1 AND similarity(name, $2) > 0.2
+	      ORDER BY similarity(name, $2) DESC`
+	c := &Contact{}
+	err := db.Get(c, q, u.ID, name)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoContact
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// FindContactByRelationship returns the user's contact with a relationship to
+// them, like "sister" or "boss."
+func (u *User) FindContactByRelationship(db *sqlx.DB, rel string) (*Contact,
+	error) {
+
+	q := `SELECT id, name, email, phone, userid
+	      FROM contacts
+	      WHERE userid=$1 AND relationship=$2
+	      ORDER BY updatedat DESC`
+	c := &Contact{}
+	err := db.Get(c, q, u.ID, strings.ToLower(rel))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoContact
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetRelationship records the contact's relationship to the user, like
+// "sister," so they can be found when the user says "my sister."
+func (c *Contact) SetRelationship(db *sqlx.DB, rel string) error {
+	q := `UPDATE contacts SET relationship=$1, updatedat=CURRENT_TIMESTAMP
+	      WHERE id=$2`
+	_, err := db.Exec(q, strings.ToLower(rel), c.ID)
+	return err
+}
@@ -0,0 +1,163 @@
+package dt
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/itsabot/abot/shared/nlp"
+	"github.com/jmoiron/sqlx"
+)
+
+// referenceConfidence is the confidence of objects added to a
+// StructuredInput by resolving pronouns, which usually, but not always, refer
+// to the last object discussed.
+const referenceConfidence = 0.8
+
+// ContextStore remembers the person, object, place and time a user most
+// recently referred to, so pronouns in their next messages, like "it" in
+// "order it again," can be resolved. Entities are forgotten ConversationTTL
+// after they were last referred to.
+//
+// Context is shared by all of a user's linked FlexIDs, so "did it ship yet?"
+// texted after emailing about an order refers to the order. Since users
+// switch channels more slowly than they reply on one, entities referred to on
+// another channel are remembered for CrossChannelTTL instead.
+type ContextStore struct {
+	db     *sqlx.DB
+	userID uint64
+
+	// FlexIDType is the channel the user is messaging through.
+	FlexIDType FlexIDType
+
+	// CrossChannelTTL is how long entities referred to on the user's
+	// other channels are remembered. Zero keeps each channel's context
+	// separate.
+	CrossChannelTTL time.Duration
+}
+
+// NewContextStore returns the ContextStore of a user messaging through a
+// channel. Entities referred to on other channels are remembered for
+// ConversationTTL until CrossChannelTTL is changed.
+func NewContextStore(db *sqlx.DB, userID uint64,
+	fidT FlexIDType) *ContextStore {
+
+	return &ContextStore{
+		db:              db,
+		userID:          userID,
+		FlexIDType:      fidT,
+		CrossChannelTTL: ConversationTTL,
+	}
+}
+
+// Remember records an entity the user referred to, or one a plugin mentioned
+// to them, like the restaurant it recommended, replacing the last entity of
+// its class and the channel it was referred to on. Times are formatted as RFC
+// 3339.
+func (c *ContextStore) Remember(class nlp.SIT, entity string) error {
+	q := `INSERT INTO contextentities (userid, class, entity, flexidtype)
+	      VALUES ($1, $2, $3, $4)
+	      ON CONFLICT (userid, class) DO UPDATE
+	      SET entity=$3, flexidtype=$4, updatedat=CURRENT_TIMESTAMP`
+	_, err := c.db.Exec(q, c.userID, class, entity, c.FlexIDType)
+	return err
+}
+
+// Recall returns the entity of a class the user most recently referred to, or
+// an empty string if they haven't referred to one within ConversationTTL on
+// this channel, or within CrossChannelTTL on another.
+func (c *ContextStore) Recall(class nlp.SIT) (string, error) {
+	q := `SELECT entity FROM contextentities
+	      WHERE userid=$1 AND class=$2 AND updatedat>CASE
+	          WHEN flexidtype=$3 THEN $4::timestamp
+	          ELSE $5::timestamp
+	      END`
+	var entity string
+	now := time.Now()
+	err := c.db.Get(&entity, q, c.userID, class, c.FlexIDType,
+		now.Add(-ConversationTTL), now.Add(-c.CrossChannelTTL))
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return entity, err
+}
+
+// RememberInput records the entities a user referred to in a message: the
+// last object, the last person mentioned other than the user, the last place
+// and the first time. It should be called after ResolvePronouns, so entities
+// referred to by pronouns stay in context.
+func (c *ContextStore) RememberInput(si *nlp.StructuredInput) error {
+	if obj := si.Objects.Last(); len(obj) > 0 {
+		if err := c.Remember(nlp.ObjectI, obj); err != nil {
+			return err
+		}
+	}
+	for i := len(si.Participants) - 1; i >= 0; i-- {
+		p := si.Participants[i]
+		if p.Role != nlp.RoleThirdParty {
+			continue
+		}
+		if err := c.Remember(nlp.PersonI, p.Mention); err != nil {
+			return err
+		}
+		break
+	}
+	if place := si.Places.Last(); len(place) > 0 {
+		if err := c.Remember(nlp.PlaceI, place); err != nil {
+			return err
+		}
+	}
+	var t time.Time
+	switch {
+	case len(si.Times) > 0:
+		t = si.Times[0]
+	case len(si.DateTimes) > 0:
+		t = si.DateTimes[0].Start
+	}
+	if !t.IsZero() {
+		if err := c.Remember(nlp.TimeI, t.Format(time.RFC3339)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolvePronouns sets the entity each of a StructuredInput's References
+// refers to, and adds what they refer to in its place: objects to Objects,
+// people to Participants and times to Times. Places are only set on the
+// References, for plugins to read. References to something the user hasn't
+// referred to recently are left unresolved.
+func (c *ContextStore) ResolvePronouns(si *nlp.StructuredInput) error {
+	for i := range si.References {
+		ref := &si.References[i]
+		entity, err := c.Recall(ref.Class)
+		if err != nil {
+			return err
+		}
+		if len(entity) == 0 {
+			continue
+		}
+		ref.Entity = entity
+		switch ref.Class {
+		case nlp.ObjectI:
+			if _, ok := si.Objects.Map()[entity]; ok {
+				continue
+			}
+			_ = si.Add([]nlp.WordClass{{Word: entity,
+				Class: nlp.ObjectI, Confidence: referenceConfidence}})
+		case nlp.PersonI:
+			si.Participants = append(si.Participants, nlp.Participant{
+				Role:    nlp.RoleThirdParty,
+				Mention: entity,
+			})
+		case nlp.TimeI:
+			t, err := time.Parse(time.RFC3339, entity)
+			if err != nil {
+				return err
+			}
+			if len(si.Times) == 0 {
+				si.Times = append(si.Times, t)
+			}
+		}
+	}
+	return nil
+}
@@ -8,6 +8,10 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
+// SchemaVersion is the version of the JSON schema of the StructuredInput sent
+// to plugins. See nlp.SchemaVersion for how it changes.
+const SchemaVersion = nlp.SchemaVersion
+
 // Msg is a message received by a user. It holds various fields that are useful
 // for plugins which are populated by Abot core in core/process.
 type Msg struct {
@@ -25,9 +29,22 @@ type Msg struct {
 	AbotSent      bool
 	NeedsTraining bool
 	// Tokens breaks the sentence into words. Tokens like ,.' are treated as
-	// individual words.
+	// individual words. Filler words like "um" and "please" are removed.
 	Tokens []string
-	Route  string
+	// UnfilteredTokens are the sentence's tokens including filler words,
+	// for plugins that need every word the user wrote.
+	UnfilteredTokens []string
+	// SentenceTokens are the UnfilteredTokens with their offsets in the
+	// Sentence, for mapping classified words back to what the user wrote.
+	SentenceTokens []nlp.Token
+	Route          string
+	// Followup is true when the message continues a conversation with the
+	// plugin it was routed to rather than starting a new one.
+	Followup bool
+	// Lang is the ISO 639-1 code of the language the user wrote in when
+	// Abot translated the message, e.g. "es". Sentence is always in the
+	// language of the parser. Lang is empty when no translation was needed.
+	Lang string
 }
 
 // GetMsg returns a message for a given message ID.
@@ -39,6 +56,10 @@ func GetMsg(db *sqlx.DB, id uint64) (*Msg, error) {
 	if err := db.Get(m, q, id); err != nil {
 		return nil, err
 	}
+	var err error
+	if m.Sentence, err = OpenSentence(m.Sentence); err != nil {
+		return nil, err
+	}
 	return m, nil
 }
 
@@ -53,28 +74,53 @@ func (m *Msg) Update(db *sqlx.DB) error {
 	return nil
 }
 
-// Save a message to the database, updating the message ID.
+// Save a message to the database, updating the message ID. The sentence is
+// encrypted if a MessageCipher is set.
 func (m *Msg) Save(db *sqlx.DB) error {
+	sentence, err := SealSentence(m.Sentence)
+	if err != nil {
+		return err
+	}
+	// Commands and objects are saved so trainers can review how the
+	// message was classified.
+	si := m.StructuredInput
+	if si == nil {
+		si = &nlp.StructuredInput{}
+	}
 	q := `INSERT INTO messages
 	      (userid, sentence, plugin, route, abotsent, needstraining, flexid,
-		flexidtype)
-	      VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
-	row := db.QueryRowx(q, m.User.ID, m.Sentence, m.Plugin, m.Route,
-		m.AbotSent, m.NeedsTraining, m.User.FlexID, m.User.FlexIDType)
-	if err := row.Scan(&m.ID); err != nil {
+		flexidtype, commands, objects)
+	      VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`
+	row := db.QueryRowx(q, m.User.ID, sentence, m.Plugin, m.Route,
+		m.AbotSent, m.NeedsTraining, m.User.FlexID, m.User.FlexIDType,
+		si.Commands, si.Objects)
+	if err = row.Scan(&m.ID); err != nil {
 		return err
 	}
 	return nil
 }
 
 // GetLastRoute for a given user so the previous plugin can be called again if
-// no new trigger is detected.
+// no new trigger is detected. Unregistered users all share a userid of 0, so
+// they're identified by their FlexID instead. Messages sent by Abot only
+// carry a route when a plugin started the conversation itself, e.g. when
+// messaging a contact on a user's behalf, so that the contact's reply is
+// routed back to the same plugin.
 func (m *Msg) GetLastRoute(db *sqlx.DB) (string, error) {
 	var route string
-	q := `SELECT route FROM messages
-	      WHERE userid=$1 AND abotsent IS FALSE
-	      ORDER BY createdat DESC`
-	err := db.Get(&route, q, m.User.ID)
+	var err error
+	if m.User.Registered() {
+		q := `SELECT route FROM messages
+		      WHERE userid=$1 AND (abotsent IS FALSE OR route<>'')
+		      ORDER BY createdat DESC`
+		err = db.Get(&route, q, m.User.ID)
+	} else {
+		q := `SELECT route FROM messages
+		      WHERE flexid=$1 AND flexidtype=$2
+		        AND (abotsent IS FALSE OR route<>'')
+		      ORDER BY createdat DESC`
+		err = db.Get(&route, q, m.User.FlexID, m.User.FlexIDType)
+	}
 	if err != nil && err != sql.ErrNoRows {
 		return "", err
 	}
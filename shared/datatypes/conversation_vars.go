@@ -0,0 +1,84 @@
+package dt
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ConversationTTL is how long after a plugin last set a conversation variable
+// for a user that the plugin's variables for that user expire.
+const ConversationTTL = time.Hour
+
+// ReminderVar is the conversation variable holding what a user is reminded of
+// shortly before the conversation expires. See SetReminder.
+const ReminderVar = "__reminder"
+
+// SetVar stashes a conversation variable, like the page of search results the
+// user is on. Unlike memories, which last until they're deleted, a plugin's
+// variables for a user expire ConversationTTL after it last sets one, and
+// they're cleared when the state machine is reset, so ephemeral data from one
+// conversation never leaks into the next. Variables are private to the
+// plugin. See also Session.
+func (sm *StateMachine) SetVar(in *Msg, k string, v interface{}) {
+	if err := sm.session(in).Set(k, v); err != nil {
+		sm.logger.Debug("could not set variable at", k, ":", err)
+	}
+}
+
+// GetVar retrieves a conversation variable. Expired variables are empty, like
+// those never set.
+func (sm *StateMachine) GetVar(in *Msg, k string) Memory {
+	buf, err := sm.session(in).raw(k)
+	if err != nil && err != ErrNoSessionValue {
+		sm.logger.Debug("could not get variable for key", k, ":", err)
+	}
+	return Memory{Key: k, Val: buf, logger: sm.logger}
+}
+
+// HasVar reports whether a conversation variable is set and hasn't expired.
+func (sm *StateMachine) HasVar(in *Msg, k string) bool {
+	return len(sm.GetVar(in, k).Val) > 0
+}
+
+// DeleteVar deletes a conversation variable. It is not an error to delete a
+// key that does not exist.
+func (sm *StateMachine) DeleteVar(in *Msg, k string) {
+	if err := sm.session(in).Delete(k); err != nil {
+		sm.logger.Debug("could not delete variable for key", k, ":", err)
+	}
+}
+
+// SetReminder sets the message sent to the user shortly before the plugin's
+// conversation variables expire, like "Still want that ride to the airport?
+// Just say yes." Without one, the user is sent a generic reminder. Operators
+// can change how long before expiry reminders are sent, or turn them off,
+// through the plugin_{name}_expiry_reminder_minutes setting.
+func (sm *StateMachine) SetReminder(in *Msg, text string) {
+	sm.SetVar(in, ReminderVar, text)
+}
+
+// clearVars deletes all of the plugin's conversation variables for a user.
+func (sm *StateMachine) clearVars(in *Msg) {
+	if err := sm.session(in).Clear(); err != nil {
+		sm.logger.Debug("could not clear variables", err)
+	}
+}
+
+// session returns the plugin's session with a user, which holds its
+// conversation variables.
+func (sm *StateMachine) session(in *Msg) *Session {
+	return &Session{
+		db:         sm.db,
+		userID:     in.User.ID,
+		pluginName: sm.pluginName,
+		TTL:        ConversationTTL,
+	}
+}
+
+// DeleteExpiredVars deletes conversation variables that have expired.
+func DeleteExpiredVars(db *sqlx.DB) error {
+	q := `DELETE FROM conversationvars WHERE expiresat<=$1`
+	_, err := db.Exec(q, time.Now())
+	return err
+}
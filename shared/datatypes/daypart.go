@@ -0,0 +1,153 @@
+package dt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DayPart is a fuzzy part of the day, like "evening" or "lunch," spanning
+// minutes after midnight. Parts ending before they start, like a night from
+// 21:00 to 2:00, end the following day.
+type DayPart struct {
+	Name  string
+	Start int
+	End   int
+}
+
+// ErrNoDayPart signals that a day part isn't known by a name.
+var ErrNoDayPart = errors.New("no day part")
+
+// dayPartPrefix distinguishes day parts from other preferences.
+const dayPartPrefix = "daypart_"
+
+// defaultDayParts are used where no country defaults exist.
+var defaultDayParts = []DayPart{
+	{Name: "morning", Start: 6 * 60, End: 12 * 60},
+	{Name: "breakfast", Start: 7 * 60, End: 9 * 60},
+	{Name: "lunch", Start: 11*60 + 30, End: 13*60 + 30},
+	{Name: "afternoon", Start: 12 * 60, End: 17 * 60},
+	{Name: "dinner", Start: 17*60 + 30, End: 20 * 60},
+	{Name: "evening", Start: 17 * 60, End: 21 * 60},
+	{Name: "night", Start: 21 * 60, End: 2 * 60},
+	{Name: "work", Start: 9 * 60, End: 17 * 60},
+}
+
+// countryDayParts override defaultDayParts where a country's days differ,
+// keyed by ISO 3166 country code.
+var countryDayParts = map[string][]DayPart{
+	"ES": {
+		{Name: "lunch", Start: 14 * 60, End: 16 * 60},
+		{Name: "afternoon", Start: 14 * 60, End: 20 * 60},
+		{Name: "dinner", Start: 21 * 60, End: 23 * 60},
+		{Name: "evening", Start: 20 * 60, End: 23 * 60},
+		{Name: "night", Start: 23 * 60, End: 3 * 60},
+		{Name: "work", Start: 9 * 60, End: 18 * 60},
+	},
+	"FR": {
+		{Name: "lunch", Start: 12 * 60, End: 14 * 60},
+		{Name: "dinner", Start: 19*60 + 30, End: 21*60 + 30},
+		{Name: "evening", Start: 18 * 60, End: 22 * 60},
+		{Name: "work", Start: 9 * 60, End: 18 * 60},
+	},
+	"DE": {
+		{Name: "lunch", Start: 12 * 60, End: 13*60 + 30},
+		{Name: "dinner", Start: 18 * 60, End: 20 * 60},
+		{Name: "work", Start: 8 * 60, End: 16*60 + 30},
+	},
+}
+
+// DefaultDayParts returns the day parts for a country, given by its ISO 3166
+// code, e.g. "US."
+func DefaultDayParts(country string) []DayPart {
+	ps := make([]DayPart, len(defaultDayParts))
+	copy(ps, defaultDayParts)
+	for _, o := range countryDayParts[strings.ToUpper(country)] {
+		for i := range ps {
+			if ps[i].Name == o.Name {
+				ps[i] = o
+			}
+		}
+	}
+	return ps
+}
+
+// DayParts returns the user's day parts: those they've defined, and the
+// defaults for their country otherwise.
+func (u *User) DayParts(db *sqlx.DB, country string) ([]DayPart, error) {
+	ps := DefaultDayParts(country)
+	q := `SELECT SUBSTRING(key FROM $1) AS name, value
+	      FROM preferences
+	      WHERE userid=$2 AND pkgname IS NULL AND key LIKE $3`
+	var rows []struct {
+		Name  string
+		Value string
+	}
+	err := db.Select(&rows, q, len(dayPartPrefix)+1, u.ID,
+		dayPartPrefix+"%")
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		p := DayPart{Name: r.Name}
+		_, err = fmt.Sscanf(r.Value, "%d-%d", &p.Start, &p.End)
+		if err != nil {
+			return nil, err
+		}
+		var found bool
+		for i := range ps {
+			if ps[i].Name == p.Name {
+				ps[i], found = p, true
+			}
+		}
+		if !found {
+			ps = append(ps, p)
+		}
+	}
+	return ps, nil
+}
+
+// SetDayPart defines when one of the user's day parts starts and ends,
+// replacing the default. Names are case-insensitive.
+func (u *User) SetDayPart(db *sqlx.DB, p DayPart) error {
+	key := dayPartPrefix + strings.ToLower(strings.TrimSpace(p.Name))
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	q := `DELETE FROM preferences
+	      WHERE userid=$1 AND pkgname IS NULL AND key=$2`
+	if _, err = tx.Exec(q, u.ID, key); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	q = `INSERT INTO preferences (userid, key, value) VALUES ($1, $2, $3)`
+	val := fmt.Sprintf("%d-%d", p.Start, p.End)
+	if _, err = tx.Exec(q, u.ID, key, val); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteDayPart restores the default for one of the user's day parts,
+// returning ErrNoDayPart if they haven't defined it.
+func (u *User) DeleteDayPart(db *sqlx.DB, name string) error {
+	q := `DELETE FROM preferences
+	      WHERE userid=$1 AND pkgname IS NULL AND key=$2`
+	res, err := db.Exec(q, u.ID,
+		dayPartPrefix+strings.ToLower(strings.TrimSpace(name)))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoDayPart
+	}
+	return nil
+}
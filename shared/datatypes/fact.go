@@ -0,0 +1,88 @@
+package dt
+
+import (
+	"database/sql/driver"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fact is something a plugin has learned about a user and saved to recall
+// later, such as "Flying to Denver on June 3 for Sam's wedding." Facts are
+// found by meaning when an embedding driver is imported, so asking about
+// "travel plans" finds the flight above.
+type Fact struct {
+	ID         uint64
+	UserID     uint64
+	PluginName string
+	Content    string
+	CreatedAt  time.Time
+
+	// Similarity of the fact to a search, from -1 to 1, where higher is
+	// more similar. It's only set on facts found through a search by
+	// meaning.
+	Similarity float64
+}
+
+// Vector is an embedding of text, stored as a Postgres REAL[].
+type Vector []float32
+
+// Scan converts a Postgres array, e.g. "{0.1,-0.2}", into a Vector.
+func (v *Vector) Scan(src interface{}) error {
+	if src == nil {
+		*v = nil
+		return nil
+	}
+	asBytes, ok := src.([]byte)
+	if !ok {
+		return errors.New("scan source was not []bytes")
+	}
+	s := strings.Trim(string(asBytes), "{}")
+	if len(s) == 0 {
+		*v = Vector{}
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	vec := make(Vector, len(parts))
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(p, 32)
+		if err != nil {
+			return err
+		}
+		vec[i] = float32(f)
+	}
+	*v = vec
+	return nil
+}
+
+// Value converts a Vector into a Postgres array.
+func (v Vector) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	parts := make([]string, len(v))
+	for i, f := range v {
+		parts[i] = strconv.FormatFloat(float64(f), 'g', -1, 32)
+	}
+	return "{" + strings.Join(parts, ",") + "}", nil
+}
+
+// Cosine returns the cosine similarity of two vectors, from -1 to 1. Vectors
+// of different lengths have a similarity of 0.
+func (v Vector) Cosine(w Vector) float64 {
+	if len(v) != len(w) || len(v) == 0 {
+		return 0
+	}
+	var dot, nv, nw float64
+	for i := range v {
+		dot += float64(v[i]) * float64(w[i])
+		nv += float64(v[i]) * float64(v[i])
+		nw += float64(w[i]) * float64(w[i])
+	}
+	if nv == 0 || nw == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(nv) * math.Sqrt(nw))
+}
@@ -0,0 +1,164 @@
+package dt
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrUnknownFlexID is returned when looking up a user by a FlexID that isn't
+// linked to any.
+var ErrUnknownFlexID = errors.New("unknown flexid")
+
+// FlexIDTypeDef defines a FlexIDType for a channel beyond email and SMS, like
+// a Slack user ID, a Telegram chat ID or a Facebook page-scoped ID. See
+// RegisterFlexIDType.
+type FlexIDTypeDef struct {
+	// Name uniquely identifies the type, e.g. "slack."
+	Name string
+
+	// Channel describes what the channel can display. Its name defaults
+	// to the type's.
+	Channel Channel
+
+	// Send delivers a message to a FlexID of the type, e.g. through
+	// Slack's API. Without it, the messages Abot sends on its own, like
+	// reminders, can't reach users through the channel.
+	Send func(flexID, content string) error
+}
+
+var flexIDTypesMu sync.RWMutex
+var flexIDTypes = map[FlexIDType]FlexIDTypeDef{}
+
+// RegisterFlexIDType adds a FlexIDType for a channel, so its users can message
+// Abot and be recognized as the same person on their other channels. The type
+// is stored by name, so it keeps its value across restarts. It should be
+// called before Abot begins processing messages, e.g. in a plugin's init.
+func RegisterFlexIDType(db *sqlx.DB, def FlexIDTypeDef) (FlexIDType, error) {
+	if len(def.Name) == 0 {
+		return 0, ErrInvalidFlexIDType
+	}
+	q := `INSERT INTO flexidtypes (name) VALUES ($1)
+	      ON CONFLICT (name) DO UPDATE SET name=$1
+	      RETURNING id`
+	var t FlexIDType
+	if err := db.QueryRowx(q, def.Name).Scan(&t); err != nil {
+		return 0, err
+	}
+	if t == fidtEmail || t == fidtPhone {
+		// Email and phone are built in.
+		return 0, ErrInvalidFlexIDType
+	}
+	if len(def.Channel.Name) == 0 {
+		def.Channel.Name = def.Name
+	}
+	flexIDTypesMu.Lock()
+	defer flexIDTypesMu.Unlock()
+	flexIDTypes[t] = def
+	if _, ok := Channels[def.Channel.Name]; !ok {
+		Channels[def.Channel.Name] = def.Channel
+	}
+	return t, nil
+}
+
+// registeredFlexIDType returns the definition of a FlexIDType added by
+// RegisterFlexIDType.
+func registeredFlexIDType(t FlexIDType) (FlexIDTypeDef, bool) {
+	flexIDTypesMu.RLock()
+	defer flexIDTypesMu.RUnlock()
+	def, ok := flexIDTypes[t]
+	return def, ok
+}
+
+// Valid reports whether a FlexIDType is email, phone or one added by
+// RegisterFlexIDType.
+func (t FlexIDType) Valid() bool {
+	if t == fidtEmail || t == fidtPhone {
+		return true
+	}
+	_, ok := registeredFlexIDType(t)
+	return ok
+}
+
+// String returns the name of a FlexIDType, like "email" or "slack."
+func (t FlexIDType) String() string {
+	switch t {
+	case fidtEmail:
+		return "email"
+	case fidtPhone:
+		return "phone"
+	}
+	if def, ok := registeredFlexIDType(t); ok {
+		return def.Name
+	}
+	return "unknown"
+}
+
+// UserByFlexID returns the user a FlexID is linked to, or ErrUnknownFlexID if
+// it isn't linked to any.
+func UserByFlexID(db *sqlx.DB, fid string, fidT FlexIDType) (*User, error) {
+	u := &User{FlexID: fid, FlexIDType: fidT}
+	q := `SELECT u.id, u.name, u.email FROM users AS u
+	      JOIN userflexids AS uf ON uf.userid=u.id
+	      WHERE uf.flexid=$1 AND uf.flexidtype=$2`
+	err := db.Get(u, q, fid, fidT)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownFlexID
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// FlexIDs returns the FlexIDs linked to the user, most recently added first.
+func (u *User) FlexIDs(db *sqlx.DB) ([]Request, error) {
+	q := `SELECT flexid, flexidtype FROM userflexids
+	      WHERE userid=$1
+	      ORDER BY createdat DESC`
+	var fids []Request
+	if err := db.Select(&fids, q, u.ID); err != nil {
+		return nil, err
+	}
+	return fids, nil
+}
+
+// LinkFlexID links a FlexID to the user, so messages from it are theirs. A
+// FlexID belongs to only one user, so one linked to another user, like the
+// account created when the user first texted, is moved to this one.
+func (u *User) LinkFlexID(db *sqlx.DB, fid string, fidT FlexIDType) error {
+	if len(fid) == 0 {
+		return ErrMissingFlexID
+	}
+	if !fidT.Valid() {
+		return ErrInvalidFlexIDType
+	}
+	q := `INSERT INTO userflexids (userid, flexid, flexidtype)
+	      VALUES ($1, $2, $3)
+	      ON CONFLICT (flexid, flexidtype) DO UPDATE
+	      SET userid=$1, createdat=CURRENT_TIMESTAMP`
+	_, err := db.Exec(q, u.ID, fid, fidT)
+	return err
+}
+
+// UnlinkFlexID removes a FlexID from the user, e.g. one linked by mistake.
+// Messages from it are then from an unknown user. ErrUnknownFlexID is
+// returned if it isn't linked to the user.
+func (u *User) UnlinkFlexID(db *sqlx.DB, fid string, fidT FlexIDType) error {
+	q := `DELETE FROM userflexids
+	      WHERE userid=$1 AND flexid=$2 AND flexidtype=$3`
+	res, err := db.Exec(q, u.ID, fid, fidT)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUnknownFlexID
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package dt
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 // Location represents some location saved for a user or plugin. This is used
 // by itsabot.org/abot/shared/knowledge to quickly retrieve either the user's
@@ -11,8 +14,26 @@ type Location struct {
 	Lat       float64
 	Lon       float64
 	CreatedAt time.Time
+
+	// Source is how Abot learned the location, LocationShared or
+	// LocationInferred.
+	Source string
 }
 
+// Sources of locations.
+const (
+	// LocationShared locations were sent by the user's client, like their
+	// phone's GPS position.
+	LocationShared = "shared"
+
+	// LocationInferred locations were inferred from the user's messages,
+	// e.g. "I'm in Chicago."
+	LocationInferred = "inferred"
+)
+
+// ErrNoLocation signals that no location could be found when one was expected.
+var ErrNoLocation = errors.New("no location")
+
 // IsRecent is a helper function to determine if the user's location was last
 // recorded in the past day. Beyond that, itsabot.org/abot/shared/knowledge
 // will request an updated location.
@@ -0,0 +1,48 @@
+package dt
+
+import "sync"
+
+// MessageCipher encrypts the sentences of messages before they're saved and
+// decrypts them when read back, so someone with access to the database alone
+// can't read conversations. See SetMessageCipher.
+type MessageCipher interface {
+	// Seal returns a sentence as it should be saved.
+	Seal(sentence string) (string, error)
+
+	// Open returns a saved sentence as the user wrote it. Sentences saved
+	// before encryption was enabled must be returned as they are.
+	Open(saved string) (string, error)
+}
+
+var msgCipherMu sync.RWMutex
+var msgCipher MessageCipher
+
+// SetMessageCipher sets how the sentences of messages are encrypted. Messages
+// are saved as written if it's nil.
+func SetMessageCipher(c MessageCipher) {
+	msgCipherMu.Lock()
+	defer msgCipherMu.Unlock()
+	msgCipher = c
+}
+
+// SealSentence encrypts a sentence for saving with the MessageCipher, if any.
+func SealSentence(s string) (string, error) {
+	msgCipherMu.RLock()
+	c := msgCipher
+	msgCipherMu.RUnlock()
+	if c == nil {
+		return s, nil
+	}
+	return c.Seal(s)
+}
+
+// OpenSentence decrypts a saved sentence with the MessageCipher, if any.
+func OpenSentence(s string) (string, error) {
+	msgCipherMu.RLock()
+	c := msgCipher
+	msgCipherMu.RUnlock()
+	if c == nil {
+		return s, nil
+	}
+	return c.Open(s)
+}
@@ -30,6 +30,12 @@ type PluginFns struct {
 	// FollowUp runs with 2+ consecutive messages to the same plugin.
 	// FollowUp is a required function.
 	FollowUp func(in *Msg) (string, error)
+
+	// Digest summarizes anything the plugin knows that's relevant to the
+	// user today, such as the weather or packages arriving, for inclusion
+	// in digests like a daily briefing. Return an empty string if there's
+	// nothing to share. Digest is optional.
+	Digest func(u *User) (string, error)
 }
 
 // PluginConfig holds options for a plugin.
@@ -45,6 +51,42 @@ type PluginConfig struct {
 	// Type specifies the type of plugin and can be either "action" or
 	// "driver". It's defined in plugin.json.
 	Type string
+
+	// Roles restricts the plugin to users holding any one of them, e.g.
+	// ["billing"]. Others are politely refused. It's defined in
+	// plugin.json.
+	Roles []string
+}
+
+// Intent is a request a plugin handles, like booking a table, declared so
+// Abot can weigh it against every other plugin's when routing a message. See
+// plugin.Intents.
+type Intent struct {
+	// Name is the intent's route, e.g. "book_table," which the plugin
+	// receives in Msg.Route. It must be unique across plugins.
+	Name string
+
+	// Commands are the verbs that express the intent, e.g. "book" and
+	// "reserve," and Objects the things they act on, e.g. "table." An
+	// object ending in "*" matches any beginning with it, so "reserv*"
+	// matches "reservation," and "*" alone matches any object. A message
+	// with both a command and an object of the intent triggers it.
+	Commands []string
+	Objects  []string
+
+	// Examples are sentences expressing the intent, e.g. "can you get us
+	// a table for four tonight." Messages much like one trigger the
+	// intent even without its commands and objects.
+	Examples []string
+
+	// Priority breaks ties between intents matching a message equally
+	// well. Higher priorities win.
+	Priority int
+
+	// Contexts are the routes or plugin names of conversations in which
+	// the intent is likelier, e.g. "book_table" for "change_party_size."
+	// Intents score higher while the user's conversation is in one.
+	Contexts []string
 }
 
 // PluginEvents allow plugins to listen to events as they happen in Abot core.
@@ -63,13 +105,35 @@ type PluginEvents struct {
 // communication method. This method returns the scheduled event ID in the
 // database for future reference and an error if the event could not be
 // scheduled.
+//
+// Users who have not signed up, like a contact being messaged on a user's
+// behalf, are reached through their FlexID and FlexIDType directly.
 func (p *Plugin) Schedule(u *User, content string, sendat time.Time) (uint64,
 	error) {
 
-	q := `INSERT INTO scheduledevents (content, userid, sendat)
-	      VALUES ($1, $2, $3)
+	return p.SchedulePriority(u, content, sendat, "", PriorityNormal)
+}
+
+// SchedulePriority schedules a message like Schedule with a priority and a
+// category, like "fraud_alert," which users may use to override the priority.
+// Urgent messages are sent even during the user's quiet hours.
+func (p *Plugin) SchedulePriority(u *User, content string, sendat time.Time,
+	category string, pri Priority) (uint64, error) {
+
+	fid, fidT := u.FlexID, u.FlexIDType
+	if len(fid) == 0 {
+		var err error
+		fid, fidT, err = u.LastFlexID(p.DB)
+		if err != nil {
+			return 0, err
+		}
+	}
+	q := `INSERT INTO scheduledevents
+	      (content, flexid, flexidtype, sendat, category, priority)
+	      VALUES ($1, $2, $3, $4, $5, $6)
 	      RETURNING id`
 	var sid uint64
-	err := p.DB.QueryRow(q, content, u.ID, sendat).Scan(&sid)
+	err := p.DB.QueryRow(q, content, fid, fidT, sendat, category,
+		pri).Scan(&sid)
 	return sid, err
 }
@@ -0,0 +1,145 @@
+package dt
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrNoPref signals that a user hasn't set a preference.
+var ErrNoPref = errors.New("no preference")
+
+// prefPkg is the pkgname of a package's preferences. Shared preferences have
+// none.
+func prefPkg(pkg string) interface{} {
+	if len(pkg) == 0 {
+		return nil
+	}
+	return pkg
+}
+
+// GetPref returns one of the user's preferences for a package, or ErrNoPref if
+// they haven't set it. Preferences are settings a user has chosen, like
+// dietary restrictions, that packages remember across conversations without
+// creating their own tables. Each package's preferences are kept apart by its
+// name, e.g. "restaurant," so two packages may use the same key. Preferences of
+// an empty package are shared by all packages, like the user's aliases and day
+// parts, so shared keys shouldn't begin with "alias_" or "daypart_."
+func (u *User) GetPref(db *sqlx.DB, pkg, key string) (string, error) {
+	q := `SELECT value FROM preferences
+	      WHERE userid=$1 AND pkgname IS NOT DISTINCT FROM $2 AND key=$3
+	      ORDER BY createdat DESC
+	      LIMIT 1`
+	var val string
+	err := db.Get(&val, q, u.ID, prefPkg(pkg), key)
+	if err == sql.ErrNoRows {
+		return "", ErrNoPref
+	}
+	if err != nil {
+		return "", err
+	}
+	return val, nil
+}
+
+// SetPref sets one of the user's preferences for a package, replacing any
+// previous value.
+func (u *User) SetPref(db *sqlx.DB, pkg, key, value string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	q := `DELETE FROM preferences
+	      WHERE userid=$1 AND pkgname IS NOT DISTINCT FROM $2 AND key=$3`
+	if _, err = tx.Exec(q, u.ID, prefPkg(pkg), key); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	q = `INSERT INTO preferences (userid, pkgname, key, value)
+	     VALUES ($1, $2, $3, $4)`
+	if _, err = tx.Exec(q, u.ID, prefPkg(pkg), key, value); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeletePref deletes one of the user's preferences for a package. It is not an
+// error to delete a preference that isn't set.
+func (u *User) DeletePref(db *sqlx.DB, pkg, key string) error {
+	q := `DELETE FROM preferences
+	      WHERE userid=$1 AND pkgname IS NOT DISTINCT FROM $2 AND key=$3`
+	_, err := db.Exec(q, u.ID, prefPkg(pkg), key)
+	return err
+}
+
+// Prefs returns all of the user's preferences for a package by key.
+func (u *User) Prefs(db *sqlx.DB, pkg string) (map[string]string, error) {
+	q := `SELECT key, value FROM preferences
+	      WHERE userid=$1 AND pkgname IS NOT DISTINCT FROM $2
+	      ORDER BY createdat`
+	var rows []struct {
+		Key   string
+		Value string
+	}
+	if err := db.Select(&rows, q, u.ID, prefPkg(pkg)); err != nil {
+		return nil, err
+	}
+	prefs := map[string]string{}
+	for _, r := range rows {
+		prefs[r.Key] = r.Value
+	}
+	return prefs, nil
+}
+
+// GetPrefBool returns one of the user's preferences set with SetPrefBool.
+func (u *User) GetPrefBool(db *sqlx.DB, pkg, key string) (bool, error) {
+	val, err := u.GetPref(db, pkg, key)
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(val)
+}
+
+// SetPrefBool sets one of the user's preferences to a bool, like whether they
+// want their receipts emailed.
+func (u *User) SetPrefBool(db *sqlx.DB, pkg, key string, value bool) error {
+	return u.SetPref(db, pkg, key, strconv.FormatBool(value))
+}
+
+// GetPrefInt returns one of the user's preferences set with SetPrefInt.
+func (u *User) GetPrefInt(db *sqlx.DB, pkg, key string) (int64, error) {
+	val, err := u.GetPref(db, pkg, key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+// SetPrefInt sets one of the user's preferences to an integer, like the size
+// of their party.
+func (u *User) SetPrefInt(db *sqlx.DB, pkg, key string, value int64) error {
+	return u.SetPref(db, pkg, key, strconv.FormatInt(value, 10))
+}
+
+// GetPrefJSON decodes one of the user's preferences set with SetPrefJSON into
+// v.
+func (u *User) GetPrefJSON(db *sqlx.DB, pkg, key string, v interface{}) error {
+	val, err := u.GetPref(db, pkg, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(val), v)
+}
+
+// SetPrefJSON sets one of the user's preferences to any value encoded as JSON,
+// like a list of dietary restrictions.
+func (u *User) SetPrefJSON(db *sqlx.DB, pkg, key string, v interface{}) error {
+	byt, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return u.SetPref(db, pkg, key, string(byt))
+}
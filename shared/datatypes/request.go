@@ -6,4 +6,17 @@ type Request struct {
 	UserID     uint64     `json:"uid"`
 	FlexID     string     `json:"flexid"`
 	FlexIDType FlexIDType `json:"flexidtype"`
+
+	// Location is optionally shared by clients that know where the user
+	// is, like a phone sharing its GPS position.
+	Location *Location `json:"location"`
+
+	// Images are URLs of photos the user sent with the message, like MMS
+	// media. Any text in them is read into the message.
+	Images []string `json:"images"`
+
+	// Mailer is the X-Mailer or User-Agent header of an email the user
+	// sent, like "Microsoft Outlook 16.0," so the reply is parsed with
+	// its client's heuristics. See emailreply.DetectClient.
+	Mailer string `json:"mailer"`
 }
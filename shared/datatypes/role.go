@@ -0,0 +1,85 @@
+package dt
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/itsabot/abot/shared/nlp"
+	"github.com/jmoiron/sqlx"
+)
+
+// RoleAdmin is held by every admin, so actions requiring it needn't be granted
+// to admins separately.
+const RoleAdmin = "admin"
+
+var actionRoles = map[string][]string{}
+var actionRolesMu sync.RWMutex
+
+// RequireRoles restricts an action, like a plugin's name, to users holding any
+// one of the roles, e.g. "admin" or "billing." Actions without required roles
+// are open to everyone. Plugins declare theirs with Roles in plugin.json.
+func RequireRoles(action string, roles ...string) {
+	actionRolesMu.Lock()
+	defer actionRolesMu.Unlock()
+	if len(roles) == 0 {
+		delete(actionRoles, action)
+		return
+	}
+	rs := make([]string, len(roles))
+	for i, r := range roles {
+		rs[i] = strings.ToLower(r)
+	}
+	actionRoles[action] = rs
+}
+
+// HasRole reports whether the user holds a role.
+func (u *User) HasRole(role string) bool {
+	if u == nil {
+		return false
+	}
+	role = strings.ToLower(role)
+	if u.Admin && role == RoleAdmin {
+		return true
+	}
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize reports whether the user may perform an action, i.e. it requires
+// no roles or the user holds one of them. Unknown users may only perform
+// actions open to everyone.
+func (u *User) Authorize(action string) bool {
+	actionRolesMu.RLock()
+	roles := actionRoles[action]
+	actionRolesMu.RUnlock()
+	if len(roles) == 0 {
+		return true
+	}
+	for _, r := range roles {
+		if u.HasRole(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRoles replaces the user's roles.
+func (u *User) SetRoles(db *sqlx.DB, roles []string) error {
+	rs := nlp.StringSlice{}
+	for _, r := range roles {
+		r = strings.ToLower(strings.TrimSpace(r))
+		if len(r) > 0 {
+			rs = append(rs, r)
+		}
+	}
+	q := `UPDATE users SET roles=$1 WHERE id=$2`
+	if _, err := db.Exec(q, rs, u.ID); err != nil {
+		return err
+	}
+	u.Roles = rs
+	return nil
+}
@@ -1,29 +1,147 @@
 package dt
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"os"
+	"time"
 
+	"github.com/itsabot/abot/shared/interface/emailsender"
 	"github.com/itsabot/abot/shared/interface/sms"
+	"github.com/jmoiron/sqlx"
 )
 
 // ScheduledEvent for Abot to send a message at some point in the future. No
 // time.Time is necessary in this struct because it's only created when it's
 // known to be time to send. See core/boot:NewServer().
 type ScheduledEvent struct {
+	ID         uint64
 	Content    string
 	FlexID     string
 	FlexIDType FlexIDType
+
+	// Priority determines whether the event may interrupt quiet hours
+	// or be batched with other messages. Users may override it for a
+	// Category, like "flight_change" or "package", through
+	// User.SetNotificationPolicy.
+	Priority Priority
+	Category string
 }
 
-// Send a scheduled event. Currently only phones are supported.
-func (s *ScheduledEvent) Send(c *sms.Conn) error {
+// Priority determines how urgently a message is delivered.
+type Priority int
+
+// Priorities of outbound messages. Normal messages are held during the
+// recipient's quiet hours and batched into a single message when several are
+// due at once. Urgent messages, like fraud alerts or flight changes, are sent
+// right away on their own, even during quiet hours. If an urgent message can't
+// be delivered or the user doesn't reply, Abot resends it on the user's other
+// channels, e.g. email after SMS.
+const (
+	PriorityNormal Priority = iota + 1
+	PriorityUrgent
+)
+
+// ErrMissingConn is returned when a scheduled event can't be sent because no
+// driver has been imported for its FlexIDType.
+var ErrMissingConn = errors.New("missing connection for flexidtype")
+
+// Send a scheduled event via SMS or email depending on its FlexIDType. Emails
+// are sent from ADMIN_EMAIL. Events for FlexIDTypes added by
+// RegisterFlexIDType are sent with the type's Send.
+func (s *ScheduledEvent) Send(sc *sms.Conn, ec *emailsender.Conn) error {
 	switch s.FlexIDType {
 	case fidtPhone:
-		if err := c.Send(s.FlexID, s.Content); err != nil {
+		if sc == nil {
+			return ErrMissingConn
+		}
+		if err := sc.Send(s.FlexID, s.Content); err != nil {
+			return err
+		}
+	case fidtEmail:
+		if ec == nil {
+			return ErrMissingConn
+		}
+		err := ec.SendPlainText([]string{s.FlexID},
+			os.Getenv("ADMIN_EMAIL"), "New message", s.Content)
+		if err != nil {
 			return err
 		}
 	default:
-		return fmt.Errorf("unrecognized flexidtype: %d", s.FlexIDType)
+		def, ok := registeredFlexIDType(s.FlexIDType)
+		if !ok {
+			return fmt.Errorf("unrecognized flexidtype: %d", s.FlexIDType)
+		}
+		if def.Send == nil {
+			return ErrMissingConn
+		}
+		if err := def.Send(s.FlexID, s.Content); err != nil {
+			return err
+		}
 	}
 	return nil
 }
+
+// Recipient returns the user the event will be sent to, or nil if the
+// recipient hasn't signed up, like a contact being messaged on a user's
+// behalf.
+func (s *ScheduledEvent) Recipient(db *sqlx.DB) (*User, error) {
+	u := &User{}
+	q := `SELECT userid FROM userflexids WHERE flexid=$1 AND flexidtype=$2`
+	err := db.Get(&u.ID, q, s.FlexID, s.FlexIDType)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// InQuietHours reports whether the event's recipient is a user currently in
+// their quiet hours. Recipients who haven't signed up, like a user's contacts,
+// never have quiet hours.
+func (s *ScheduledEvent) InQuietHours(db *sqlx.DB, t time.Time) (bool, error) {
+	u, err := s.Recipient(db)
+	if err != nil || u == nil {
+		return false, err
+	}
+	return u.InQuietHours(db, t)
+}
+
+// RecipientPaused reports whether the event's FlexID has opted out, or its
+// recipient is a user who has paused Abot. Recipients who haven't signed up,
+// like a user's contacts, opt out by FlexID.
+func (s *ScheduledEvent) RecipientPaused(db *sqlx.DB) (bool, error) {
+	paused, err := FlexIDPaused(db, s.FlexID, s.FlexIDType)
+	if err != nil || paused {
+		return paused, err
+	}
+	u, err := s.Recipient(db)
+	if err != nil || u == nil {
+		return false, err
+	}
+	return u.Paused(db)
+}
+
+// EffectivePriority returns the event's priority after applying any policy
+// the recipient has set for its category.
+func (s *ScheduledEvent) EffectivePriority(db *sqlx.DB) (Priority, error) {
+	pri := s.Priority
+	if pri == 0 {
+		pri = PriorityNormal
+	}
+	if len(s.Category) == 0 {
+		return pri, nil
+	}
+	u, err := s.Recipient(db)
+	if err != nil || u == nil {
+		return pri, err
+	}
+	policy, ok, err := u.NotificationPolicy(db, s.Category)
+	if err != nil || !ok {
+		return pri, err
+	}
+	return policy, nil
+}
@@ -0,0 +1,105 @@
+package dt
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrNoSessionValue is returned when getting a session value that isn't set
+// or has expired.
+var ErrNoSessionValue = errors.New("no session value")
+
+// Session is a plugin's scratch space for a user's conversation, like the size
+// and toppings chosen so far while ordering a pizza. Values are JSON, private
+// to the plugin, and expire TTL after the plugin last sets one, so an
+// abandoned conversation doesn't leak into the next. Expired values are
+// deleted by a regular job. A Session holds the same values as the
+// StateMachine's conversation variables.
+type Session struct {
+	db         *sqlx.DB
+	userID     uint64
+	pluginName string
+
+	// TTL is how long the session's values last after one was last set.
+	// It defaults to ConversationTTL.
+	TTL time.Duration
+}
+
+// Session returns the plugin's session with a user.
+func (p *Plugin) Session(u *User) *Session {
+	return &Session{
+		db:         p.DB,
+		userID:     u.ID,
+		pluginName: p.Config.Name,
+		TTL:        ConversationTTL,
+	}
+}
+
+// Get decodes a session value into v, or returns ErrNoSessionValue if it isn't
+// set or has expired.
+func (s *Session) Get(k string, v interface{}) error {
+	byt, err := s.raw(k)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(byt, v)
+}
+
+// raw returns a session value as it's stored.
+func (s *Session) raw(k string) ([]byte, error) {
+	q := `SELECT value FROM conversationvars
+	      WHERE userid=$1 AND pluginname=$2 AND key=$3 AND expiresat>$4`
+	var byt []byte
+	err := s.db.Get(&byt, q, s.userID, s.pluginName, k, time.Now())
+	if err == sql.ErrNoRows || (err == nil && len(byt) == 0) {
+		return nil, ErrNoSessionValue
+	}
+	if err != nil {
+		return nil, err
+	}
+	return byt, nil
+}
+
+// Set encodes v as JSON and stores it in the session, extending the session
+// for another TTL.
+func (s *Session) Set(k string, v interface{}) error {
+	byt, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(s.TTL)
+	q := `INSERT INTO conversationvars (userid, pluginname, key, value,
+	          expiresat)
+	      VALUES ($1, $2, $3, $4, $5)
+	      ON CONFLICT (userid, pluginname, key) DO UPDATE SET value=$4`
+	_, err = s.db.Exec(q, s.userID, s.pluginName, k, byt, expiresAt)
+	if err != nil {
+		return err
+	}
+	// The conversation is ongoing, so keep its other values too, and
+	// remind the user again before they expire.
+	q = `UPDATE conversationvars SET expiresat=$1, remindedat=NULL
+	     WHERE userid=$2 AND pluginname=$3`
+	_, err = s.db.Exec(q, expiresAt, s.userID, s.pluginName)
+	return err
+}
+
+// Delete deletes a session value. It is not an error to delete a value that
+// isn't set.
+func (s *Session) Delete(k string) error {
+	q := `DELETE FROM conversationvars
+	      WHERE userid=$1 AND pluginname=$2 AND key=$3`
+	_, err := s.db.Exec(q, s.userID, s.pluginName, k)
+	return err
+}
+
+// Clear deletes all of the session's values, e.g. once an order is placed.
+func (s *Session) Clear() error {
+	q := `DELETE FROM conversationvars WHERE userid=$1 AND pluginname=$2`
+	_, err := s.db.Exec(q, s.userID, s.pluginName)
+	return err
+}
@@ -308,9 +308,10 @@ func (sm *StateMachine) HasMemory(in *Msg, k string) bool {
 	return len(sm.GetMemory(in, k).Val) > 0
 }
 
-// Reset the stateMachine both in memory and in the database. This also runs the
-// programmer-defined reset function (SetOnReset) to reset memories to some
-// starting state for running the same plugin multiple times. This is usually
+// Reset the stateMachine both in memory and in the database, clearing its
+// conversation variables. This also runs the programmer-defined reset
+// function (SetOnReset) to reset memories to some starting state for running
+// the same plugin multiple times. This is usually
 // called from a plugin's Run() function. See
 // plugins/ava_purchase/ava_purchase.go for an example.
 func (sm *StateMachine) Reset(in *Msg) {
@@ -318,6 +319,7 @@ func (sm *StateMachine) Reset(in *Msg) {
 	sm.stateEntered = false
 	sm.SetMemory(in, stateKey, 0)
 	sm.SetMemory(in, stateEnteredKey, false)
+	sm.clearVars(in)
 	sm.resetFn(in)
 }
 
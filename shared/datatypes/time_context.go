@@ -0,0 +1,18 @@
+package dt
+
+import "time"
+
+// TimeContext is the event a user most recently set a time for, like a
+// reservation at 6pm, so a follow-up such as "actually make it 7pm" can be
+// routed back to the plugin that created it.
+type TimeContext struct {
+	PluginName string
+	Route      string
+
+	// Label describes the event to the plugin, e.g. "briefing" or a
+	// reservation ID.
+	Label string
+
+	Time      time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,65 @@
+package dt
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrNoUncorrectedWord signals that a user hasn't asked Abot not to correct a
+// word.
+var ErrNoUncorrectedWord = errors.New("no uncorrected word")
+
+// uncorrectedPrefix distinguishes the words a user doesn't want corrected
+// from other preferences.
+const uncorrectedPrefix = "nocorrect_"
+
+// UncorrectedWords returns the words a user has asked Abot not to correct,
+// like slang or product names it mistakes for typos, in lowercase.
+func (u *User) UncorrectedWords(db *sqlx.DB) ([]string, error) {
+	q := `SELECT SUBSTRING(key FROM $1)
+	      FROM preferences
+	      WHERE userid=$2 AND pkgname IS NULL AND key LIKE $3
+	      ORDER BY key`
+	var ws []string
+	err := db.Select(&ws, q, len(uncorrectedPrefix)+1, u.ID,
+		uncorrectedPrefix+"%")
+	if err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// AddUncorrectedWord asks Abot not to correct a word for the user. Words are
+// case-insensitive.
+func (u *User) AddUncorrectedWord(db *sqlx.DB, word string) error {
+	key := uncorrectedPrefix + strings.ToLower(strings.TrimSpace(word))
+	q := `INSERT INTO preferences (userid, key, value)
+	      SELECT $1, $2, ''
+	      WHERE NOT EXISTS (
+	          SELECT 1 FROM preferences
+	          WHERE userid=$1 AND pkgname IS NULL AND key=$2)`
+	_, err := db.Exec(q, u.ID, key)
+	return err
+}
+
+// DeleteUncorrectedWord lets Abot correct a word for the user again,
+// returning ErrNoUncorrectedWord if they hadn't asked it not to.
+func (u *User) DeleteUncorrectedWord(db *sqlx.DB, word string) error {
+	q := `DELETE FROM preferences
+	      WHERE userid=$1 AND pkgname IS NULL AND key=$2`
+	res, err := db.Exec(q, u.ID,
+		uncorrectedPrefix+strings.ToLower(strings.TrimSpace(word)))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoUncorrectedWord
+	}
+	return nil
+}
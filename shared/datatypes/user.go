@@ -3,14 +3,13 @@ package dt
 import (
 	"database/sql"
 	"errors"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/nlp"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -37,6 +36,10 @@ type User struct {
 	// interface and will be notified via email when new training is
 	// required
 	Trainer bool
+
+	// Roles grant the user actions restricted by RequireRoles, e.g.
+	// "billing."
+	Roles nlp.StringSlice
 }
 
 // FlexIDType is used to identify a user when only an email, phone, or other
@@ -58,9 +61,12 @@ var ErrMissingFlexID = errors.New("missing flexid")
 
 // ErrInvalidFlexIDType is returned when a FlexIDType is invalid not
 // matching one of the pre-defined FlexIDTypes for email (1) or phone
-// (2).
+// (2), nor one added by RegisterFlexIDType.
 var ErrInvalidFlexIDType = errors.New("invalid flexid type")
 
+// ErrInvalidTag is returned when applying an empty tag to a user.
+var ErrInvalidTag = errors.New("invalid tag")
+
 // GetUser from an HTTP request.
 func GetUser(db *sqlx.DB, req *Request) (*User, error) {
 	u := &User{}
@@ -70,10 +76,7 @@ func GetUser(db *sqlx.DB, req *Request) (*User, error) {
 		if req.FlexID == "" {
 			return nil, ErrMissingFlexID
 		}
-		switch req.FlexIDType {
-		case fidtEmail, fidtPhone:
-			// Do nothing
-		default:
+		if !req.FlexIDType.Valid() {
 			return nil, ErrInvalidFlexIDType
 		}
 		log.Debug("searching for user from", req.FlexID, req.FlexIDType)
@@ -90,7 +93,8 @@ func GetUser(db *sqlx.DB, req *Request) (*User, error) {
 			return nil, err
 		}
 	}
-	q := `SELECT id, name, email, lastauthenticated, paymentserviceid
+	q := `SELECT id, name, email, lastauthenticated, paymentserviceid, admin,
+	          roles
 	      FROM users
 	      WHERE id=$1`
 	if err := db.Get(u, q, req.UserID); err != nil {
@@ -118,6 +122,216 @@ func (u *User) Registered() bool {
 	return u.ID > 0
 }
 
+// LastFlexID returns the FlexID through which the user most recently messaged
+// Abot, which is their preferred way of being contacted. If the user has never
+// sent a message with a FlexID, the most recently added FlexID is used.
+func (u *User) LastFlexID(db *sqlx.DB) (string, FlexIDType, error) {
+	var fid string
+	var fidT FlexIDType
+	q := `SELECT flexid, flexidtype FROM messages
+	      WHERE userid=$1 AND abotsent IS FALSE AND flexid<>''
+	      ORDER BY createdat DESC`
+	err := db.QueryRowx(q, u.ID).Scan(&fid, &fidT)
+	if err == nil {
+		return fid, fidT, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", 0, err
+	}
+	q = `SELECT flexid, flexidtype FROM userflexids
+	     WHERE userid=$1
+	     ORDER BY createdat DESC`
+	if err = db.QueryRowx(q, u.ID).Scan(&fid, &fidT); err != nil {
+		return "", 0, err
+	}
+	return fid, fidT, nil
+}
+
+// QuietHours returns the times of day, in minutes after midnight, between
+// which the user doesn't want to receive messages that they didn't ask for,
+// such as reminders and briefings. The bool is false if the user has not set
+// any quiet hours. Quiet hours may wrap past midnight, e.g. 10PM to 7AM.
+func (u *User) QuietHours(db *sqlx.DB) (start, end int, ok bool, err error) {
+	var tmp struct {
+		Start sql.NullInt64 `db:"quiethoursstart"`
+		End   sql.NullInt64 `db:"quiethoursend"`
+	}
+	q := `SELECT quiethoursstart, quiethoursend FROM users WHERE id=$1`
+	if err = db.Get(&tmp, q, u.ID); err != nil {
+		return 0, 0, false, err
+	}
+	if !tmp.Start.Valid || !tmp.End.Valid {
+		return 0, 0, false, nil
+	}
+	return int(tmp.Start.Int64), int(tmp.End.Int64), true, nil
+}
+
+// SetQuietHours saves the user's quiet hours in minutes after midnight.
+func (u *User) SetQuietHours(db *sqlx.DB, start, end int) error {
+	q := `UPDATE users SET quiethoursstart=$1, quiethoursend=$2 WHERE id=$3`
+	_, err := db.Exec(q, start, end, u.ID)
+	return err
+}
+
+// InQuietHours reports whether t falls within the user's quiet hours.
+func (u *User) InQuietHours(db *sqlx.DB, t time.Time) (bool, error) {
+	start, end, ok, err := u.QuietHours(db)
+	if err != nil || !ok {
+		return false, err
+	}
+	mins := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return mins >= start && mins < end, nil
+	}
+	return mins >= start || mins < end, nil
+}
+
+// Paused reports whether the user has paused Abot, e.g. by replying "stop."
+// Paused users receive replies to their messages but nothing they didn't ask
+// for, like reminders, until they resume. Users who haven't signed up pause
+// the FlexID they messaged from. See FlexIDPaused.
+func (u *User) Paused(db *sqlx.DB) (bool, error) {
+	if len(u.FlexID) > 0 {
+		paused, err := FlexIDPaused(db, u.FlexID, u.FlexIDType)
+		if err != nil || paused {
+			return paused, err
+		}
+	}
+	if !u.Registered() {
+		return false, nil
+	}
+	var pausedAt *time.Time
+	q := `SELECT pausedat FROM users WHERE id=$1`
+	if err := db.Get(&pausedAt, q, u.ID); err != nil {
+		return false, err
+	}
+	return pausedAt != nil, nil
+}
+
+// Pause stops messages the user didn't ask for until Resume is called. The
+// FlexID they messaged from is opted out too, so it stays paused even if
+// it's later linked to another user.
+func (u *User) Pause(db *sqlx.DB) error {
+	if len(u.FlexID) > 0 {
+		q := `INSERT INTO optouts (flexid, flexidtype) VALUES ($1, $2)
+		      ON CONFLICT (flexid, flexidtype) DO NOTHING`
+		if _, err := db.Exec(q, u.FlexID, u.FlexIDType); err != nil {
+			return err
+		}
+	}
+	if !u.Registered() {
+		return nil
+	}
+	q := `UPDATE users SET pausedat=CURRENT_TIMESTAMP
+	      WHERE id=$1 AND pausedat IS NULL`
+	_, err := db.Exec(q, u.ID)
+	return err
+}
+
+// Resume undoes Pause.
+func (u *User) Resume(db *sqlx.DB) error {
+	if len(u.FlexID) > 0 {
+		q := `DELETE FROM optouts WHERE flexid=$1 AND flexidtype=$2`
+		if _, err := db.Exec(q, u.FlexID, u.FlexIDType); err != nil {
+			return err
+		}
+	}
+	if !u.Registered() {
+		return nil
+	}
+	q := `UPDATE users SET pausedat=NULL WHERE id=$1`
+	_, err := db.Exec(q, u.ID)
+	return err
+}
+
+// FlexIDPaused reports whether a FlexID has opted out of messages Abot sends
+// on its own, e.g. the phone number of a user's contact who replied "stop,"
+// whether or not it belongs to a user.
+func FlexIDPaused(db *sqlx.DB, fid string, fidT FlexIDType) (bool, error) {
+	var paused bool
+	q := `SELECT EXISTS(SELECT 1 FROM optouts
+	      WHERE flexid=$1 AND flexidtype=$2)`
+	err := db.Get(&paused, q, fid, fidT)
+	return paused, err
+}
+
+// NotificationPolicy returns the priority the user has chosen for messages in
+// a category, overriding the priority plugins send them with. The bool is
+// false if the user hasn't chosen one.
+func (u *User) NotificationPolicy(db *sqlx.DB, category string) (Priority,
+	bool, error) {
+
+	var pri Priority
+	q := `SELECT priority FROM notificationpolicies
+	      WHERE userid=$1 AND category=$2`
+	err := db.Get(&pri, q, u.ID, category)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return pri, true, nil
+}
+
+// SetNotificationPolicy saves the priority of messages in a category for the
+// user, e.g. to always be told of flight changes, even during quiet hours.
+func (u *User) SetNotificationPolicy(db *sqlx.DB, category string,
+	pri Priority) error {
+
+	q := `INSERT INTO notificationpolicies (userid, category, priority)
+	      VALUES ($1, $2, $3)
+	      ON CONFLICT (userid, category) DO UPDATE SET priority=$3`
+	_, err := db.Exec(q, u.ID, category, pri)
+	return err
+}
+
+// Tags returns the tags manually applied to the user by plugins and operators,
+// like "vip" or "beta," sorted alphabetically. Rule-based segments, like users
+// who ordered in the past 30 days, aren't included. See core.UsersTagged.
+func (u *User) Tags(db *sqlx.DB) ([]string, error) {
+	var tags []string
+	q := `SELECT tag FROM usertags WHERE userid=$1 ORDER BY tag`
+	if err := db.Select(&tags, q, u.ID); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// HasTag reports whether a tag has been applied to the user.
+func (u *User) HasTag(db *sqlx.DB, tag string) (bool, error) {
+	var exists bool
+	q := `SELECT EXISTS(SELECT 1 FROM usertags WHERE userid=$1 AND tag=$2)`
+	err := db.Get(&exists, q, u.ID, NormalizeTag(tag))
+	return exists, err
+}
+
+// AddTag applies a tag to the user. It is not an error to add a tag twice.
+func (u *User) AddTag(db *sqlx.DB, tag string) error {
+	tag = NormalizeTag(tag)
+	if len(tag) == 0 {
+		return ErrInvalidTag
+	}
+	q := `INSERT INTO usertags (userid, tag) VALUES ($1, $2)
+	      ON CONFLICT (userid, tag) DO NOTHING`
+	_, err := db.Exec(q, u.ID, tag)
+	return err
+}
+
+// RemoveTag removes a tag from the user. It is not an error to remove a tag
+// the user doesn't have.
+func (u *User) RemoveTag(db *sqlx.DB, tag string) error {
+	q := `DELETE FROM usertags WHERE userid=$1 AND tag=$2`
+	_, err := db.Exec(q, u.ID, NormalizeTag(tag))
+	return err
+}
+
+// NormalizeTag lowercases a tag and joins its words with underscores, so "VIP
+// Customer" and "vip_customer" are the same tag.
+func NormalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), "_")
+}
+
 // Create a new user in the database.
 func (u *User) Create(db *sqlx.DB, fidT FlexIDType, fid string) error {
 	// Create the password hash
@@ -145,7 +359,7 @@ func (u *User) Create(db *sqlx.DB, fidT FlexIDType, fid string) error {
 	}
 	q = `INSERT INTO userflexids (userid, flexid, flexidtype)
 	     VALUES ($1, $2, $3)`
-	_, err = tx.Exec(q, uid, fid, 2)
+	_, err = tx.Exec(q, uid, fid, fidT)
 	if err != nil {
 		_ = tx.Rollback()
 		return err
@@ -157,39 +371,24 @@ func (u *User) Create(db *sqlx.DB, fidT FlexIDType, fid string) error {
 	return nil
 }
 
-// IsAuthenticated confirms that the user is authenticated for a particular
-// AuthMethod.
-func (u *User) IsAuthenticated(m AuthMethod) (bool, error) {
-	var oldTime time.Time
-	tmp := os.Getenv("ABOT_REQUIRE_AUTH_IN_HOURS")
-	var t int
-	if len(tmp) > 0 {
-		var err error
-		t, err = strconv.Atoi(tmp)
-		if err != nil {
-			return false, err
-		}
-		if t < 0 {
-			return false, errors.New("negative ABOT_REQUIRE_AUTH_IN_HOURS")
-		}
-	} else {
-		log.Debug("ABOT_REQUIRE_AUTH_IN_HOURS environment variable is not set.",
-			" Using 168 hours (one week) as the default.")
-		t = 168
-	}
-	oldTime = time.Now().Add(time.Duration(-1*t) * time.Hour)
-	authenticated := false
-	if u.LastAuthenticated.After(oldTime) &&
-		u.LastAuthenticationMethod >= m {
-		authenticated = true
-	}
-	return authenticated, nil
+// IsAuthenticated confirms that the user authenticated with at least an
+// AuthMethod within the policy's max age for an action, e.g. a plugin's name
+// or ActionPurchase.
+func (u *User) IsAuthenticated(p *AuthPolicy, action string,
+	m AuthMethod) bool {
+
+	if u.LastAuthenticated == nil {
+		return false
+	}
+	oldTime := time.Now().Add(-p.MaxAgeFor(action))
+	return u.LastAuthenticated.After(oldTime) &&
+		u.LastAuthenticationMethod >= m
 }
 
 // GetCards retrieves credit cards for a specific user.
 func (u *User) GetCards(db *sqlx.DB) ([]Card, error) {
 	q := `SELECT id, addressid, last4, cardholdername, expmonth, expyear,
-	          brand, stripeid, zip5hash
+	          brand, servicetoken, zip5hash
 	      FROM cards
 	      WHERE userid=$1`
 	var cards []Card
@@ -197,15 +396,22 @@ func (u *User) GetCards(db *sqlx.DB) ([]Card, error) {
 	return cards, err
 }
 
-// GetPrimaryCard retrieves the primary credit card for a specific user.
+// GetPrimaryCard retrieves the primary credit card for a specific user, which
+// is the card most recently added. If the user has no cards, ErrNoCard is
+// returned.
 func (u *User) GetPrimaryCard(db *sqlx.DB) (*Card, error) {
 	q := `SELECT id, addressid, last4, cardholdername, expmonth, expyear,
-	          brand, stripeid
+	          brand, servicetoken
 	      FROM cards
-	      WHERE userid=$1 AND primary=TRUE`
-	var card *Card
-	if err := db.Get(&card, q, u.ID); err != nil {
-		return card, err
+	      WHERE userid=$1
+	      ORDER BY id DESC`
+	card := &Card{}
+	err := db.Get(card, q, u.ID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoCard
+	}
+	if err != nil {
+		return nil, err
 	}
 	return card, nil
 }
@@ -253,7 +459,7 @@ func (u *User) GetAddress(db *sqlx.DB, text string) (*Address, error) {
 	}
 	q := `SELECT name, line1, line2, city, state, country, zip
 	      FROM addresses
-	      WHERE userid=$1 AND name=$2 AND cardid=0`
+	      WHERE userid=$1 AND name=$2`
 	err := db.Get(addr, q, u.ID, name)
 	if err == sql.ErrNoRows {
 		return nil, ErrNoAddress
@@ -265,6 +471,39 @@ func (u *User) GetAddress(db *sqlx.DB, text string) (*Address, error) {
 	return addr, nil
 }
 
+// SaveLocation records a location shared by the user, such as their phone's
+// GPS position, or inferred from their messages. Locations without a Source
+// are saved as LocationShared.
+func (u *User) SaveLocation(db *sqlx.DB, l *Location) error {
+	src := l.Source
+	if len(src) == 0 {
+		src = LocationShared
+	}
+	q := `INSERT INTO locations (userid, name, lat, lon, source)
+	      VALUES ($1, $2, $3, $4, $5)`
+	_, err := db.Exec(q, u.ID, l.Name, l.Lat, l.Lon, src)
+	return err
+}
+
+// LastLocation returns the location most recently shared by the user. If the
+// user has never shared a location, ErrNoLocation is returned. Use
+// Location.IsRecent to check whether it's still useful.
+func (u *User) LastLocation(db *sqlx.DB) (*Location, error) {
+	l := &Location{}
+	q := `SELECT COALESCE(name, '') AS name, lat, lon, createdat, source
+	      FROM locations
+	      WHERE userid=$1
+	      ORDER BY createdat DESC`
+	err := db.Get(l, q, u.ID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoLocation
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
 // UpdateAddressName such as "home" or "office" when learned.
 func (u *User) UpdateAddressName(db *sqlx.DB, id uint64, name string) (*Address,
 	error) {
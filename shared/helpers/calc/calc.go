@@ -0,0 +1,253 @@
+// Package calc safely evaluates arithmetic found in messages, e.g. "what's 18%
+// of 92.50" or "(3 + 4) * 2". Expressions are parsed by hand rather than
+// executed, so only numbers, parentheses and the operators + - * / ^ and % are
+// supported.
+package calc
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidExpression is returned when an expression can't be parsed.
+var ErrInvalidExpression = errors.New("invalid expression")
+
+// ErrUndefined is returned when an expression has no finite result, such as
+// when dividing by zero.
+var ErrUndefined = errors.New("undefined result")
+
+// maxLen limits the length of expressions, which also limits how deeply they
+// can be nested.
+const maxLen = 200
+
+// phrases are replaced with their operators when extracting expressions from
+// sentences. Longer phrases come first so they're replaced before the words
+// within them.
+var phrases = []struct{ from, to string }{
+	{"to the power of", "^"},
+	{"multiplied by", "*"},
+	{"divided by", "/"},
+	{"percent of", "% *"},
+	{"squared", "^2"},
+	{"cubed", "^3"},
+	{"percent", "%"},
+	{"plus", "+"},
+	{"minus", "-"},
+	{"times", "*"},
+	{"over", "/"},
+	{"x", "*"},
+	{"×", "*"},
+	{"÷", "/"},
+}
+
+// prefixes are stripped from the start of sentences before extracting an
+// expression.
+var prefixes = []string{"what's", "whats", "what is", "how much is",
+	"calculate", "compute", "solve"}
+
+var regexThousands = regexp.MustCompile(`(\d),(\d{3})\b`)
+var regexExpression = regexp.MustCompile(`^[\d.+\-*/^%() ]+$`)
+var regexOperator = regexp.MustCompile(`\d\s*[+\-*/^%]|[+\-*/^]\s*[\d(]`)
+
+// Extract returns the arithmetic expression in a sentence, e.g. "18% * 92.50"
+// from "What's 18% of 92.50?" The bool is false if the sentence is anything
+// other than arithmetic, so sentences that merely contain numbers, like
+// "remind me in 5 minutes," aren't mistaken for expressions. Since replies
+// like "555-1234" or "6/3" are more likely phone numbers and dates than
+// arithmetic, bare expressions must be asked about, as in "what's 6/3" or
+// "6/3?", or use words for their operators, as in "6 divided by 3."
+func Extract(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	asked := strings.HasSuffix(s, "?") || strings.HasSuffix(s, "=")
+	s = strings.TrimRight(s, "?!.= ")
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p+" ") {
+			s = s[len(p)+1:]
+			asked = true
+			break
+		}
+	}
+	s = strings.Replace(s, "$", "", -1)
+	s = regexThousands.ReplaceAllString(s, "$1$2")
+	words := strings.Fields(s)
+	s = " " + strings.Join(words, " ") + " "
+	s = strings.Replace(s, "% of ", "% * ", -1)
+	for _, p := range phrases {
+		r := strings.Replace(s, " "+p.from+" ", " "+p.to+" ", -1)
+		asked = asked || r != s
+		s = r
+	}
+	s = strings.TrimSpace(s)
+	if !asked || len(s) == 0 || len(s) > maxLen ||
+		!regexExpression.MatchString(s) {
+		return "", false
+	}
+	if !regexOperator.MatchString(s) {
+		return "", false
+	}
+	return s, true
+}
+
+// Eval evaluates an arithmetic expression. Percentages are fractions, so "18%"
+// is 0.18.
+func Eval(expr string) (float64, error) {
+	if len(expr) > maxLen {
+		return 0, ErrInvalidExpression
+	}
+	p := &parser{s: strings.Replace(expr, " ", "", -1)}
+	n, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+	if p.pos < len(p.s) {
+		return 0, ErrInvalidExpression
+	}
+	if math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, ErrUndefined
+	}
+	return n, nil
+}
+
+// parser is a recursive descent parser for the grammar:
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/") unary }
+//	unary   = "-" unary | power
+//	power   = percent [ "^" unary ]
+//	percent = primary [ "%" ]
+//	primary = number | "(" expr ")"
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) peek() byte {
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) expr() (float64, error) {
+	n, err := p.term()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch p.peek() {
+		case '+':
+			p.pos++
+			m, err := p.term()
+			if err != nil {
+				return 0, err
+			}
+			n += m
+		case '-':
+			p.pos++
+			m, err := p.term()
+			if err != nil {
+				return 0, err
+			}
+			n -= m
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) term() (float64, error) {
+	n, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch p.peek() {
+		case '*':
+			p.pos++
+			m, err := p.unary()
+			if err != nil {
+				return 0, err
+			}
+			n *= m
+		case '/':
+			p.pos++
+			m, err := p.unary()
+			if err != nil {
+				return 0, err
+			}
+			if m == 0 {
+				return 0, ErrUndefined
+			}
+			n /= m
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) unary() (float64, error) {
+	if p.peek() == '-' {
+		p.pos++
+		n, err := p.unary()
+		return -n, err
+	}
+	return p.power()
+}
+
+func (p *parser) power() (float64, error) {
+	n, err := p.percent()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() != '^' {
+		return n, nil
+	}
+	p.pos++
+	m, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(n, m), nil
+}
+
+func (p *parser) percent() (float64, error) {
+	n, err := p.primary()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() == '%' {
+		p.pos++
+		n /= 100
+	}
+	return n, nil
+}
+
+func (p *parser) primary() (float64, error) {
+	if p.peek() == '(' {
+		p.pos++
+		n, err := p.expr()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, ErrInvalidExpression
+		}
+		p.pos++
+		return n, nil
+	}
+	start := p.pos
+	for c := p.peek(); (c >= '0' && c <= '9') || c == '.'; c = p.peek() {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, ErrInvalidExpression
+	}
+	n, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+	if err != nil {
+		return 0, ErrInvalidExpression
+	}
+	return n, nil
+}
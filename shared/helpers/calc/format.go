@@ -0,0 +1,78 @@
+package calc
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// separators maps ISO 639-1 language codes to the thousands and decimal
+// separators used when writing numbers in that language. Languages not listed
+// use English separators.
+var separators = map[string][2]string{
+	"en": {",", "."},
+	"de": {".", ","},
+	"es": {".", ","},
+	"fr": {" ", ","},
+	"it": {".", ","},
+	"nl": {".", ","},
+	"pl": {" ", ","},
+	"pt": {".", ","},
+	"ru": {" ", ","},
+	"sv": {" ", ","},
+	"tr": {".", ","},
+}
+
+// dateFormats maps ISO 639-1 language codes to the layouts of dates in that
+// language. Languages not listed write the day before the month.
+var dateFormats = map[string]string{
+	"en": "Monday, January 2, 2006",
+	"":   "Monday, January 2, 2006",
+}
+
+const defaultDateFormat = "Monday 2 January 2006"
+
+// FormatNumber writes a number for a language, e.g. 1234.5 as "1,234.5" in
+// English and "1.234,5" in German. Results are rounded to 6 decimal places to
+// hide floating point error, so 0.1+0.2 is written "0.3".
+func FormatNumber(n float64, lang string) string {
+	sep, ok := separators[lang]
+	if !ok {
+		sep = separators["en"]
+	}
+	s := strconv.FormatFloat(n, 'f', 6, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	var sign string
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	if s == "0" {
+		sign = ""
+	}
+	intPart, fracPart := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	var groups []string
+	for len(intPart) > 3 {
+		groups = append([]string{intPart[len(intPart)-3:]}, groups...)
+		intPart = intPart[:len(intPart)-3]
+	}
+	groups = append([]string{intPart}, groups...)
+	s = sign + strings.Join(groups, sep[0])
+	if len(fracPart) > 0 {
+		s += sep[1] + fracPart
+	}
+	return s
+}
+
+// FormatDate writes a date for a language, e.g. "Friday, June 3, 2016" in
+// English and "Friday 3 June 2016" in most others. Names of days and months
+// are left in English, since responses are translated as a whole.
+func FormatDate(t time.Time, lang string) string {
+	layout, ok := dateFormats[lang]
+	if !ok {
+		layout = defaultDateFormat
+	}
+	return t.Format(layout)
+}
@@ -0,0 +1,146 @@
+// Package emailreply extracts what a user newly wrote from the body of an
+// email reply, dropping the quoted history, signature, legal footers and
+// calendar boilerplate that email clients add, so only the user's own text
+// reaches Abot's parser. Each client quotes replies differently, so only the
+// heuristics of the client that sent the email are used when it's known. See
+// DetectClient.
+package emailreply
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Email clients with their own heuristics.
+const (
+	ClientGmail   = "gmail"
+	ClientOutlook = "outlook"
+	ClientApple   = "apple"
+
+	// ClientUnknown uses every client's heuristics.
+	ClientUnknown = ""
+)
+
+// quoteHeaders begin the quoted history of a reply, by client. Gmail and
+// Apple Mail introduce it with "On {date}, {name} wrote:", which Gmail may
+// wrap over two lines. Outlook begins it with a rule or "Original Message"
+// line, or a block of headers starting with "From:".
+var quoteHeaders = map[string][]*regexp.Regexp{
+	ClientGmail: {
+		regexp.MustCompile(`(?m)^On\s[^\n]*(?:\n[^\n]*)?\swrote:\s*$`),
+		regexp.MustCompile(`(?m)^-+\s*Forwarded message\s*-+\s*$`),
+	},
+	ClientApple: {
+		regexp.MustCompile(`(?m)^On\s[^\n]*(?:\n[^\n]*)?\swrote:\s*$`),
+		regexp.MustCompile(`(?m)^Begin forwarded message:\s*$`),
+	},
+	ClientOutlook: {
+		regexp.MustCompile(`(?m)^-+\s*Original Message\s*-+\s*$`),
+		regexp.MustCompile(`(?m)^_{20,}\s*\n(?:From|De|Von):`),
+		regexp.MustCompile(`(?m)^(?:From|De|Von):\s[^\n]*\n(?:Sent|Date|Envoyé|Gesendet):\s`),
+	},
+}
+
+// clientMailers identify clients by the X-Mailer or User-Agent header of
+// their emails.
+var clientMailers = map[string]*regexp.Regexp{
+	ClientOutlook: regexp.MustCompile(`(?i)outlook|microsoft`),
+	ClientApple:   regexp.MustCompile(`(?i)apple\s*mail|iphone\s*mail|ipad\s*mail`),
+	ClientGmail:   regexp.MustCompile(`(?i)gmail|google`),
+}
+
+// footers begin the text after the user's own that every client may add:
+// signatures, legal footers and the boilerplate of calendar invitations.
+var footers = []*regexp.Regexp{
+	// Signatures
+	regexp.MustCompile(`(?m)^--\s*$`),
+	regexp.MustCompile(`(?mi)^Sent from (?:my |Mail for |Yahoo Mail|Outlook)[^\n]*$`),
+	regexp.MustCompile(`(?mi)^Get Outlook for [^\n]*$`),
+
+	// Legal footers
+	regexp.MustCompile(`(?m)^\W*(?:CONFIDENTIALITY NOTICE|DISCLAIMER|PRIVILEGED AND CONFIDENTIAL)\b`),
+	regexp.MustCompile(`(?mi)^(?:This|The information (?:contained )?in this) (?:e-?mail|message|communication|transmission)[^\n]*(?:confidential|privileged|intended (?:only |solely )?for)`),
+	regexp.MustCompile(`(?mi)^Please consider the environment before printing`),
+
+	// Calendar boilerplate
+	regexp.MustCompile(`(?mi)^Invitation from Google Calendar`),
+	regexp.MustCompile(`(?mi)^You are receiving this (?:courtesy )?email`),
+	regexp.MustCompile(`(?mi)^(?:Join with Google Meet|Join Zoom Meeting|Microsoft Teams meeting)\b`),
+	regexp.MustCompile(`(?mi)^_{20,}\s*\n\s*(?:Microsoft Teams|Join)`),
+}
+
+var regexBlankLines = regexp.MustCompile(`\n{3,}`)
+
+// DetectClient returns the client that sent an email, one of the Client
+// constants, by its X-Mailer or User-Agent header if known, or otherwise by
+// how its body quotes the message replied to. Replies quote the whole thread,
+// so the quoting that comes first is the client's own.
+func DetectClient(mailer, body string) string {
+	for _, client := range []string{ClientOutlook, ClientApple,
+		ClientGmail} {
+
+		if len(mailer) > 0 && clientMailers[client].MatchString(mailer) {
+			return client
+		}
+	}
+	body = normalizeLines(body)
+	detected, first := ClientUnknown, len(body)
+	// Gmail and Apple Mail quote alike, so Gmail, the more common, is
+	// checked first.
+	for _, client := range []string{ClientGmail, ClientOutlook,
+		ClientApple} {
+
+		for _, re := range quoteHeaders[client] {
+			loc := re.FindStringIndex(body)
+			if loc != nil && loc[0] < first {
+				detected, first = client, loc[0]
+			}
+		}
+	}
+	return detected
+}
+
+// Extract returns the text a user newly wrote in an email, cutting it at the
+// quoted history and at any signature, legal footer or calendar boilerplate
+// that follows. Lines quoted with ">" are dropped, even when the user replied
+// between them. Only the heuristics of a known client are used, so text that
+// merely resembles another client's quoting, like a "From:" line in Gmail,
+// is kept. An empty string is returned if the user wrote nothing of their
+// own, as when forwarding an email without comment.
+func Extract(body, client string) string {
+	body = normalizeLines(body)
+	for c, res := range quoteHeaders {
+		if client == ClientUnknown || c == client {
+			body = cut(body, res)
+		}
+	}
+	body = cut(body, footers)
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), ">") {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, " \t"))
+	}
+	body = strings.Join(lines, "\n")
+	return strings.TrimSpace(regexBlankLines.ReplaceAllString(body, "\n\n"))
+}
+
+// cut returns the text before the earliest match of any of the expressions.
+func cut(s string, res []*regexp.Regexp) string {
+	end := len(s)
+	for _, re := range res {
+		if loc := re.FindStringIndex(s); loc != nil && loc[0] < end {
+			end = loc[0]
+		}
+	}
+	return s[:end]
+}
+
+// normalizeLines converts Windows and old Mac line endings to "\n" and
+// removes non-breaking spaces, which some clients use to indent quotes.
+func normalizeLines(s string) string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = strings.Replace(s, "\r", "\n", -1)
+	return strings.Replace(s, "\u00a0", " ", -1)
+}
@@ -0,0 +1,151 @@
+package holidays
+
+import "time"
+
+// rules maps countries and regions to their holidays. Aliases are normalized
+// as by normalize.
+var rules = map[string][]rule{
+	"US": {
+		{Name: "New Year's Day", Aliases: []string{"new years"},
+			Public: true, Month: time.January, Day: 1},
+		{Name: "Martin Luther King Jr. Day", Aliases: []string{"mlk day",
+			"martin luther king day"}, Public: true,
+			Month: time.January, Weekday: time.Monday, N: 3},
+		{Name: "Valentine's Day", Aliases: []string{"valentines"},
+			Month: time.February, Day: 14},
+		{Name: "Presidents' Day", Aliases: []string{"presidents day",
+			"washingtons birthday"}, Public: true,
+			Month: time.February, Weekday: time.Monday, N: 3},
+		{Name: "St. Patrick's Day", Aliases: []string{"st patricks day",
+			"saint patricks day"}, Month: time.March, Day: 17},
+		{Name: "Easter", Aliases: []string{"easter sunday"},
+			Easter: true},
+		{Name: "Mother's Day", Month: time.May, Weekday: time.Sunday,
+			N: 2},
+		{Name: "Memorial Day", Public: true, Month: time.May,
+			Weekday: time.Monday, N: -1},
+		{Name: "Father's Day", Month: time.June, Weekday: time.Sunday,
+			N: 3},
+		{Name: "Independence Day", Aliases: []string{"fourth of july",
+			"july fourth", "july 4th", "4th of july"}, Public: true,
+			Month: time.July, Day: 4},
+		{Name: "Labor Day", Public: true, Month: time.September,
+			Weekday: time.Monday, N: 1},
+		{Name: "Columbus Day", Public: true, Month: time.October,
+			Weekday: time.Monday, N: 2},
+		{Name: "Halloween", Month: time.October, Day: 31},
+		{Name: "Veterans Day", Public: true, Month: time.November,
+			Day: 11},
+		{Name: "Thanksgiving", Aliases: []string{"thanksgiving day"},
+			Public: true, Month: time.November, Weekday: time.Thursday,
+			N: 4},
+		{Name: "Christmas Eve", Month: time.December, Day: 24},
+		{Name: "Christmas", Aliases: []string{"christmas day", "xmas"},
+			Public: true, Month: time.December, Day: 25},
+		{Name: "New Year's Eve", Aliases: []string{"new years eve",
+			"nye"}, Month: time.December, Day: 31},
+	},
+	"US-MA": {
+		{Name: "Patriots' Day", Aliases: []string{"patriots day"},
+			Public: true, Month: time.April, Weekday: time.Monday,
+			N: 3},
+	},
+	"CA": {
+		{Name: "New Year's Day", Aliases: []string{"new years"},
+			Public: true, Month: time.January, Day: 1},
+		{Name: "Good Friday", Public: true, Easter: true, Offset: -2},
+		{Name: "Easter", Aliases: []string{"easter sunday"},
+			Easter: true},
+		{Name: "Victoria Day", Public: true, Month: time.May, Day: 24,
+			Weekday: time.Monday, Before: true},
+		{Name: "Canada Day", Public: true, Month: time.July, Day: 1},
+		{Name: "Labour Day", Aliases: []string{"labor day"},
+			Public: true, Month: time.September,
+			Weekday: time.Monday, N: 1},
+		{Name: "Thanksgiving", Aliases: []string{"thanksgiving day"},
+			Public: true, Month: time.October, Weekday: time.Monday,
+			N: 2},
+		{Name: "Halloween", Month: time.October, Day: 31},
+		{Name: "Remembrance Day", Public: true, Month: time.November,
+			Day: 11},
+		{Name: "Christmas Eve", Month: time.December, Day: 24},
+		{Name: "Christmas", Aliases: []string{"christmas day", "xmas"},
+			Public: true, Month: time.December, Day: 25},
+		{Name: "Boxing Day", Public: true, Month: time.December,
+			Day: 26},
+	},
+	"GB": {
+		{Name: "New Year's Day", Aliases: []string{"new years"},
+			Public: true, Month: time.January, Day: 1},
+		{Name: "Good Friday", Public: true, Easter: true, Offset: -2},
+		{Name: "Easter", Aliases: []string{"easter sunday"},
+			Easter: true},
+		{Name: "Easter Monday", Public: true, Easter: true, Offset: 1},
+		{Name: "Early May Bank Holiday", Aliases: []string{"may day"},
+			Public: true, Month: time.May, Weekday: time.Monday,
+			N: 1},
+		{Name: "Spring Bank Holiday", Public: true, Month: time.May,
+			Weekday: time.Monday, N: -1},
+		{Name: "Summer Bank Holiday", Public: true, Month: time.August,
+			Weekday: time.Monday, N: -1},
+		{Name: "Christmas Eve", Month: time.December, Day: 24},
+		{Name: "Christmas", Aliases: []string{"christmas day", "xmas"},
+			Public: true, Month: time.December, Day: 25},
+		{Name: "Boxing Day", Public: true, Month: time.December,
+			Day: 26},
+	},
+	"GB-SCT": {
+		{Name: "St. Andrew's Day", Aliases: []string{"st andrews day",
+			"saint andrews day"}, Public: true, Month: time.November,
+			Day: 30},
+	},
+	"DE": {
+		{Name: "New Year's Day", Aliases: []string{"new years",
+			"neujahr"}, Public: true, Month: time.January, Day: 1},
+		{Name: "Good Friday", Aliases: []string{"karfreitag"},
+			Public: true, Easter: true, Offset: -2},
+		{Name: "Easter Monday", Aliases: []string{"ostermontag"},
+			Public: true, Easter: true, Offset: 1},
+		{Name: "Labour Day", Aliases: []string{"labor day",
+			"tag der arbeit"}, Public: true, Month: time.May, Day: 1},
+		{Name: "Ascension Day", Aliases: []string{"christi himmelfahrt"},
+			Public: true, Easter: true, Offset: 39},
+		{Name: "Whit Monday", Aliases: []string{"pfingstmontag"},
+			Public: true, Easter: true, Offset: 50},
+		{Name: "German Unity Day", Aliases: []string{
+			"tag der deutschen einheit"}, Public: true,
+			Month: time.October, Day: 3},
+		{Name: "Christmas", Aliases: []string{"christmas day", "xmas",
+			"weihnachten"}, Public: true, Month: time.December,
+			Day: 25},
+		{Name: "St. Stephen's Day", Aliases: []string{"boxing day"},
+			Public: true, Month: time.December, Day: 26},
+	},
+	"DE-BY": {
+		{Name: "Epiphany", Aliases: []string{"heilige drei konige"},
+			Public: true, Month: time.January, Day: 6},
+	},
+	"FR": {
+		{Name: "New Year's Day", Aliases: []string{"new years",
+			"jour de lan"}, Public: true, Month: time.January, Day: 1},
+		{Name: "Easter Monday", Aliases: []string{"lundi de paques"},
+			Public: true, Easter: true, Offset: 1},
+		{Name: "Labour Day", Aliases: []string{"labor day",
+			"fete du travail"}, Public: true, Month: time.May, Day: 1},
+		{Name: "Victory in Europe Day", Aliases: []string{"ve day"},
+			Public: true, Month: time.May, Day: 8},
+		{Name: "Ascension Day", Public: true, Easter: true,
+			Offset: 39},
+		{Name: "Whit Monday", Public: true, Easter: true, Offset: 50},
+		{Name: "Bastille Day", Aliases: []string{"fete nationale"},
+			Public: true, Month: time.July, Day: 14},
+		{Name: "Assumption Day", Public: true, Month: time.August,
+			Day: 15},
+		{Name: "All Saints' Day", Aliases: []string{"toussaint"},
+			Public: true, Month: time.November, Day: 1},
+		{Name: "Armistice Day", Public: true, Month: time.November,
+			Day: 11},
+		{Name: "Christmas", Aliases: []string{"christmas day", "xmas",
+			"noel"}, Public: true, Month: time.December, Day: 25},
+	},
+}
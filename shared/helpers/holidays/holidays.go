@@ -0,0 +1,182 @@
+// Package holidays provides the dates of holidays by country and region, so
+// that times like "the day after Thanksgiving" can be understood and
+// scheduling plugins can avoid or warn about holidays.
+//
+// Countries are identified by their ISO 3166-1 alpha-2 codes, e.g. "US", and
+// regions by their ISO 3166-2 codes, e.g. "US-MA". A region observes its own
+// holidays as well as those of its country.
+package holidays
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultCountry is used when a user's country is unknown. It may be changed
+// on boot, e.g. from ABOT_COUNTRY.
+var DefaultCountry = "US"
+
+// Holiday is a holiday on a specific date. Public holidays are days off work,
+// like Thanksgiving in the US, while others are merely observed, like
+// Halloween.
+type Holiday struct {
+	Name   string
+	Date   time.Time
+	Public bool
+}
+
+// rule determines the date of a holiday in a given year. A holiday falls on a
+// fixed Month and Day, on the Nth Weekday of Month (N of -1 being the last),
+// on the last Weekday on or before Month and Day if Before is set, or some
+// Offset of days from Easter if Easter is set.
+type rule struct {
+	Name    string
+	Aliases []string
+	Public  bool
+	Month   time.Month
+	Day     int
+	Weekday time.Weekday
+	N       int
+	Before  bool
+	Easter  bool
+	Offset  int
+}
+
+// On returns the holiday in a country or region on the date of t, if any.
+// Public holidays are preferred when more than one falls on the same day.
+func On(country string, t time.Time) (*Holiday, bool) {
+	y, m, d := t.Date()
+	var found *Holiday
+	for _, r := range rulesFor(country) {
+		date := r.date(y, t.Location())
+		if date.Month() != m || date.Day() != d {
+			continue
+		}
+		if found == nil || (r.Public && !found.Public) {
+			found = &Holiday{Name: r.Name, Date: date, Public: r.Public}
+		}
+	}
+	return found, found != nil
+}
+
+// Next returns the next occurrence of a holiday by name on or after the date
+// of t, e.g. the coming "Thanksgiving." Names are matched case-insensitively
+// and without apostrophes, so "new years day" matches "New Year's Day."
+func Next(country, name string, t time.Time) (*Holiday, bool) {
+	name = normalize(name)
+	y, m, d := t.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	for _, r := range rulesFor(country) {
+		if !r.matches(name) {
+			continue
+		}
+		date := r.date(y, t.Location())
+		if date.Before(today) {
+			date = r.date(y+1, t.Location())
+		}
+		return &Holiday{Name: r.Name, Date: date, Public: r.Public}, true
+	}
+	return nil, false
+}
+
+// InYear returns all holidays in a country or region during a year, sorted by
+// date.
+func InYear(country string, year int, loc *time.Location) []Holiday {
+	var hs []Holiday
+	for _, r := range rulesFor(country) {
+		hs = append(hs, Holiday{
+			Name:   r.Name,
+			Date:   r.date(year, loc),
+			Public: r.Public,
+		})
+	}
+	sort.Sort(byDate(hs))
+	return hs
+}
+
+// rulesFor returns the rules of a country along with those of its region, if
+// one is given.
+func rulesFor(country string) []rule {
+	country = strings.ToUpper(country)
+	var rs []rule
+	if i := strings.Index(country, "-"); i > 0 {
+		rs = append(rs, rules[country[:i]]...)
+	}
+	return append(rs, rules[country]...)
+}
+
+func (r rule) matches(name string) bool {
+	if normalize(r.Name) == name {
+		return true
+	}
+	for _, a := range r.Aliases {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r rule) date(year int, loc *time.Location) time.Time {
+	if loc == nil {
+		loc = time.UTC
+	}
+	switch {
+	case r.Easter:
+		return easter(year, loc).AddDate(0, 0, r.Offset)
+	case r.Before:
+		t := time.Date(year, r.Month, r.Day, 0, 0, 0, 0, loc)
+		diff := (int(t.Weekday()) - int(r.Weekday) + 7) % 7
+		return t.AddDate(0, 0, -diff)
+	case r.N > 0:
+		t := time.Date(year, r.Month, 1, 0, 0, 0, 0, loc)
+		diff := (int(r.Weekday) - int(t.Weekday()) + 7) % 7
+		return t.AddDate(0, 0, diff+7*(r.N-1))
+	case r.N < 0:
+		t := time.Date(year, r.Month+1, 0, 0, 0, 0, 0, loc)
+		diff := (int(t.Weekday()) - int(r.Weekday) + 7) % 7
+		return t.AddDate(0, 0, -diff)
+	}
+	return time.Date(year, r.Month, r.Day, 0, 0, 0, 0, loc)
+}
+
+// easter returns the date of Western Easter Sunday in a year using the
+// anonymous Gregorian algorithm.
+func easter(year int, loc *time.Location) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc)
+}
+
+func normalize(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.NewReplacer("'", "", "’", "", ".", "").Replace(s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+type byDate []Holiday
+
+func (h byDate) Len() int {
+	return len(h)
+}
+
+func (h byDate) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h byDate) Less(i, j int) bool {
+	return h[i].Date.Before(h[j].Date)
+}
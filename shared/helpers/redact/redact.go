@@ -0,0 +1,163 @@
+// Package redact masks personal information, like card numbers and street
+// addresses, in text leaving Abot's primary storage: logs, analytics events,
+// exports and live observers. Originals stay encrypted in the database. Which
+// kinds of information are masked is configured by the type of field being
+// written, since a log line needn't lose the order numbers a transcript export
+// should. See SetFields.
+package redact
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// Kinds of personal information.
+const (
+	KindEmail   = "email"
+	KindCard    = "card"
+	KindSSN     = "ssn"
+	KindPhone   = "phone"
+	KindAddress = "address"
+
+	// KindCode is a number of four to eight digits, like a one-time
+	// code or PIN. It errs on the side of masking, so years and order
+	// numbers are masked as well.
+	KindCode = "code"
+
+	// KindAll masks the whole field, e.g. for names and FlexIDs.
+	KindAll = "all"
+)
+
+// Types of fields, each masking its own kinds of information.
+const (
+	// FieldLog is a line written to the server's log.
+	FieldLog = "log"
+
+	// FieldEvent is free text in an analytics or billing event, like a
+	// plugin's note on a charge.
+	FieldEvent = "event"
+
+	// FieldMessage is a message's text in an export or a live stream.
+	FieldMessage = "message"
+
+	// FieldContact identifies a user, like their name or FlexID.
+	FieldContact = "contact"
+)
+
+// ErrUnknownKind is returned when configuring a field to mask a kind of
+// information this package can't detect.
+var ErrUnknownKind = errors.New("unknown kind of personal information")
+
+// rule detects a kind of personal information, masking it with the kind's
+// name, e.g. "[card]," or "[redacted]" for KindAll.
+type rule struct {
+	kind string
+	re   *regexp.Regexp
+}
+
+// rules are checked in order, so card numbers aren't mistaken for phone
+// numbers nor street numbers for codes.
+var rules = []rule{
+	{KindAll, regexp.MustCompile(`(?s)^.+$`)},
+	{KindEmail, regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.-]+`)},
+	{KindCard, regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)},
+	{KindSSN, regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)},
+	{KindPhone, regexp.MustCompile(`\+?\(?\b\d{3}\)?[\s.-]?\d{3}[\s.-]?\d{4}\b`)},
+	{KindAddress, regexp.MustCompile(`(?i)\b\d{1,6}[a-z]?\s+(?:(?:[a-z][a-z.'-]*|\d+(?:st|nd|rd|th))\s+){0,3}(?:street|st|avenue|ave|road|rd|boulevard|blvd|lane|ln|drive|dr|court|ct|way|place|pl|terrace|ter|parkway|pkwy|highway|hwy|circle|cir)\b\.?(?:\s+(?:n|s|e|w|ne|nw|se|sw)\b\.?)?(?:,?\s+(?:apt|apartment|suite|ste|unit|#)\.?\s*#?\w+)?`)},
+	{KindCode, regexp.MustCompile(`\b\d{4,8}\b`)},
+}
+
+var defaultFields = map[string][]string{
+	FieldLog:     {KindEmail, KindCard, KindSSN, KindPhone, KindAddress},
+	FieldEvent:   {KindEmail, KindCard, KindSSN, KindPhone, KindAddress},
+	FieldMessage: {KindEmail, KindCard, KindSSN, KindPhone, KindAddress, KindCode},
+	FieldContact: {KindAll},
+}
+
+var fields = copyFields(defaultFields)
+var fieldsMu sync.RWMutex
+
+// String masks personal information in s by the kinds configured for the
+// field type, e.g. "call me at [phone]." Unknown field types mask every kind
+// but KindAll, so new fields are never written unredacted.
+func String(field, s string) string {
+	if len(s) == 0 {
+		return s
+	}
+	fieldsMu.RLock()
+	kinds, ok := fields[field]
+	fieldsMu.RUnlock()
+	for _, r := range rules {
+		if (!ok && r.kind != KindAll) || (ok && contains(kinds, r.kind)) {
+			s = r.re.ReplaceAllString(s, mask(r.kind))
+		}
+	}
+	return s
+}
+
+// Kinds returns every kind of personal information that can be masked.
+func Kinds() []string {
+	kinds := make([]string, len(rules))
+	for i, r := range rules {
+		kinds[i] = r.kind
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Fields returns the kinds of information masked in each type of field.
+func Fields() map[string][]string {
+	fieldsMu.RLock()
+	defer fieldsMu.RUnlock()
+	return copyFields(fields)
+}
+
+// SetFields replaces the kinds of information masked in types of fields, e.g.
+// to stop masking codes in exports. Field types left out keep their defaults,
+// and an empty list masks nothing.
+func SetFields(fs map[string][]string) error {
+	known := Kinds()
+	for field, kinds := range fs {
+		for _, k := range kinds {
+			if !contains(known, k) {
+				return fmt.Errorf("%s: %q in %s", ErrUnknownKind, k,
+					field)
+			}
+		}
+	}
+	next := copyFields(defaultFields)
+	for field, kinds := range fs {
+		next[field] = append([]string{}, kinds...)
+	}
+	fieldsMu.Lock()
+	fields = next
+	fieldsMu.Unlock()
+	return nil
+}
+
+func mask(kind string) string {
+	if kind == KindAll {
+		return "[redacted]"
+	}
+	return "[" + kind + "]"
+}
+
+func copyFields(fs map[string][]string) map[string][]string {
+	c := make(map[string][]string, len(fs))
+	for field, kinds := range fs {
+		c[field] = append([]string{}, kinds...)
+	}
+	return c
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
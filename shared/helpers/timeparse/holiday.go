@@ -0,0 +1,44 @@
+package timeparse
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/shared/helpers/holidays"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// regexHoliday matches times relative to holidays, e.g. "the day after
+// Thanksgiving" or "two days before Christmas." The holiday itself is looked up
+// by name in the holidays of holidays.DefaultCountry.
+var regexHoliday = regexp.MustCompile(`^(?:the )?(?:(?:([\w-]+) days?|day) (after|before) )?(?:the )?(.+?)$`)
+
+// parseHoliday returns the date of the next occurrence of a holiday named in
+// nlTime, offset by any days before or after it.
+func parseHoliday(nlTime string, t time.Time) (time.Time, bool) {
+	m := regexHoliday.FindStringSubmatch(strings.ToLower(
+		strings.TrimSpace(nlTime)))
+	if m == nil {
+		return time.Time{}, false
+	}
+	h, ok := holidays.Next(holidays.DefaultCountry, m[3], t)
+	if !ok {
+		return time.Time{}, false
+	}
+	if len(m[2]) == 0 {
+		return h.Date, true
+	}
+	n := 1
+	if len(m[1]) > 0 && m[1] != "a" {
+		num, ok := nlp.ParseNumber(m[1])
+		if !ok || num.Ordinal || num.Value != float64(int(num.Value)) {
+			return time.Time{}, false
+		}
+		n = int(num.Value)
+	}
+	if m[2] == "before" {
+		n = -n
+	}
+	return h.Date.AddDate(0, 0, n), true
+}
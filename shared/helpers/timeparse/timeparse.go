@@ -232,7 +232,8 @@ func Parse(nlTimes ...string) ([]time.Time, error) {
 
 // ParseFromTime parses a natural language string to determine most likely times
 // based on a set time "context." The time context changes the meaning of words
-// like "this Tuesday," "next Tuesday," etc.
+// like "this Tuesday," "next Tuesday," etc. Holidays, like "the day after
+// Thanksgiving," refer to their next occurrence.
 func ParseFromTime(t time.Time, nlTimes ...string) ([]time.Time, error) {
 	var times []time.Time
 	tloc := timeLocation{loc: t.Location()}
@@ -241,6 +242,11 @@ func ParseFromTime(t time.Time, nlTimes ...string) ([]time.Time, error) {
 	var rel bool
 	for _, nlTime := range nlTimes {
 		log.Debug("original:", nlTime)
+		if ti, ok := parseHoliday(nlTime, t); ok {
+			ctx = updateContext(ctx, ti, hasDay)
+			times = append(times, ti)
+			continue
+		}
 		var loc *time.Location
 		nlTime, loc, rel = normalizeTime(nlTime)
 		log.Debug("normalized:", nlTime)
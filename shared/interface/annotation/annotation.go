@@ -0,0 +1,94 @@
+// Package annotation enables interaction with arbitrary annotation tools, where
+// people label the messages Abot didn't understand. It implements a
+// standardized interface through which Label Studio, Prodigy and more may be
+// supported. It's up to individual drivers to add support for each of these
+// tools.
+package annotation
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/itsabot/abot/shared/interface/annotation/driver"
+)
+
+var driversMu sync.RWMutex
+var drivers = make(map[string]driver.Driver)
+
+// Register makes a translation driver available by the provided name. If
+// Register is called twice with the same name or if driver is nil, it panics.
+func Register(name string, driver driver.Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("annotation: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("annotation: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns a sorted list of the names of the registered drivers.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	var list []string
+	for name := range drivers {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Conn is a connection to a specific translation driver.
+type Conn struct {
+	driver driver.Driver
+	conn   driver.Conn
+}
+
+// Open a connection to a registered driver.
+func Open(driverName, auth string) (*Conn, error) {
+	driversMu.RLock()
+	driveri, ok := drivers[driverName]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("annotation: unknown driver %q (forgotten import?)",
+			driverName)
+	}
+	conn, err := driveri.Open(auth)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		driver: driveri,
+		conn:   conn,
+	}
+	return c, nil
+}
+
+// Driver returns the driver used by a connection.
+func (c *Conn) Driver() driver.Driver {
+	return c.driver
+}
+
+// Push sends messages to the annotation tool to be labeled through an opened
+// driver connection.
+func (c *Conn) Push(tasks []driver.Task) error {
+	return c.conn.Push(tasks)
+}
+
+// ParseAnnotations verifies and parses a webhook request from the annotation
+// tool through an opened driver connection.
+func (c *Conn) ParseAnnotations(r *http.Request) ([]driver.Annotation,
+	error) {
+
+	return c.conn.ParseAnnotations(r)
+}
+
+// Close the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
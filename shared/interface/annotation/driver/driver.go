@@ -0,0 +1,56 @@
+// Package driver defines interfaces to be implemented by annotation drivers
+// as used by package annotation.
+package driver
+
+import "net/http"
+
+// Driver is the interface that must be implemented by an annotation driver.
+type Driver interface {
+	// Open returns a new connection to the annotation tool. The auth is a
+	// string in a driver-specific format, usually the tool's URL and an
+	// API key.
+	Open(auth string) (Conn, error)
+}
+
+// Conn is a connection to the external annotation tool.
+type Conn interface {
+	// Push sends messages to the annotation tool to be labeled.
+	Push(tasks []Task) error
+
+	// ParseAnnotations verifies and parses a webhook request sent by the
+	// annotation tool when a message is labeled. Requests holding no
+	// completed labels, like those for other events, should return none.
+	ParseAnnotations(r *http.Request) ([]Annotation, error)
+
+	// Close the connection.
+	Close() error
+}
+
+// Task is a message to be labeled with the plugin that should have handled
+// it.
+type Task struct {
+	// MessageID identifies the message, and must be returned in its
+	// Annotation.
+	MessageID uint64
+	Text      string
+
+	// Choices are the names of the plugins the message may be labeled
+	// with. NoPlugin is always among them.
+	Choices []string
+}
+
+// Annotation is a completed label of a Task.
+type Annotation struct {
+	MessageID uint64
+
+	// Plugin is the name of the plugin that should have handled the
+	// message, or "" if none should have.
+	Plugin string
+
+	// Annotator identifies who labeled the message in the annotation
+	// tool, e.g. their email.
+	Annotator string
+}
+
+// NoPlugin is the choice for messages no plugin should have handled.
+const NoPlugin = "none"
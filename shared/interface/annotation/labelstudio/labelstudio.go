@@ -0,0 +1,206 @@
+// Package labelstudio is an annotation driver for Label Studio. Import it for
+// its side effects and pass the project's URL, API token and a webhook secret,
+// separated by spaces, as the auth when opening a connection:
+//
+//	import _ "github.com/itsabot/abot/shared/interface/annotation/labelstudio"
+//
+// The project's labeling config should show each message's text and offer
+// its plugins as choices:
+//
+//	<View>
+//	  <Text name="text" value="$text"/>
+//	  <Choices name="plugin" toName="text" value="$choices"/>
+//	</View>
+//
+// Add a webhook for annotation events pointing to Abot's
+// /api/annotation/webhook.json with the header "Authorization: Token {secret}".
+package labelstudio
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/shared/interface/annotation"
+	"github.com/itsabot/abot/shared/interface/annotation/driver"
+)
+
+// ErrInvalidAuth is returned when opening a connection without a project URL,
+// API token and webhook secret.
+var ErrInvalidAuth = errors.New(
+	"labelstudio auth must be the project url, api token and webhook secret separated by spaces")
+
+// ErrInvalidSecret is returned when a webhook request doesn't carry the
+// webhook secret.
+var ErrInvalidSecret = errors.New("invalid labelstudio webhook secret")
+
+type drv struct{}
+
+type conn struct {
+	base    string
+	project string
+	token   string
+	secret  string
+	client  *http.Client
+}
+
+// task is a Task in Label Studio's import format.
+type task struct {
+	Data struct {
+		Text      string   `json:"text"`
+		MessageID uint64   `json:"message_id"`
+		Choices   []choice `json:"choices"`
+	} `json:"data"`
+}
+
+type choice struct {
+	Value string `json:"value"`
+}
+
+// event is a webhook request sent by Label Studio.
+type event struct {
+	Action string
+	Task   struct {
+		Data struct {
+			MessageID uint64 `json:"message_id"`
+		}
+	}
+	Annotation struct {
+		Result []struct {
+			Type  string
+			Value struct {
+				Choices []string
+			}
+		}
+		CompletedBy json.RawMessage `json:"completed_by"`
+	}
+}
+
+func init() {
+	annotation.Register("labelstudio", &drv{})
+}
+
+// Open a connection to a Label Studio project. The auth is the project's URL,
+// e.g. https://labelstudio.example.com/projects/3, an API token and a webhook
+// secret, separated by spaces.
+func (d *drv) Open(auth string) (driver.Conn, error) {
+	parts := strings.Fields(auth)
+	if len(parts) != 3 {
+		return nil, ErrInvalidAuth
+	}
+	u := strings.TrimRight(parts[0], "/")
+	i := strings.LastIndex(u, "/projects/")
+	if i < 0 {
+		return nil, ErrInvalidAuth
+	}
+	c := &conn{
+		base:    u[:i],
+		project: u[i+len("/projects/"):],
+		token:   parts[1],
+		secret:  parts[2],
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+	return c, nil
+}
+
+// Push imports tasks into the Label Studio project.
+func (c *conn) Push(tasks []driver.Task) error {
+	ts := make([]task, len(tasks))
+	for i, t := range tasks {
+		ts[i].Data.Text = t.Text
+		ts[i].Data.MessageID = t.MessageID
+		for _, ch := range t.Choices {
+			ts[i].Data.Choices = append(ts[i].Data.Choices,
+				choice{Value: ch})
+		}
+	}
+	byt, err := json.Marshal(ts)
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s/api/projects/%s/import", c.base, c.project)
+	req, err := http.NewRequest("POST", u, bytes.NewReader(byt))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated &&
+		resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("labelstudio: unexpected status %d",
+			resp.StatusCode)
+	}
+	return nil
+}
+
+// ParseAnnotations verifies and parses a Label Studio webhook request. Only
+// created and updated annotations with a plugin chosen are returned.
+func (c *conn) ParseAnnotations(r *http.Request) ([]driver.Annotation,
+	error) {
+
+	auth := []byte(r.Header.Get("Authorization"))
+	if subtle.ConstantTimeCompare(auth, []byte("Token "+c.secret)) != 1 {
+		return nil, ErrInvalidSecret
+	}
+	var evt event
+	if err := json.NewDecoder(r.Body).Decode(&evt); err != nil {
+		return nil, err
+	}
+	if evt.Action != "ANNOTATION_CREATED" &&
+		evt.Action != "ANNOTATION_UPDATED" {
+		return nil, nil
+	}
+	if evt.Task.Data.MessageID == 0 {
+		return nil, nil
+	}
+	for _, res := range evt.Annotation.Result {
+		if res.Type != "choices" || len(res.Value.Choices) == 0 {
+			continue
+		}
+		a := driver.Annotation{
+			MessageID: evt.Task.Data.MessageID,
+			Plugin:    res.Value.Choices[0],
+			Annotator: annotator(evt.Annotation.CompletedBy),
+		}
+		if a.Plugin == driver.NoPlugin {
+			a.Plugin = ""
+		}
+		return []driver.Annotation{a}, nil
+	}
+	return nil, nil
+}
+
+// Close the connection.
+func (c *conn) Close() error {
+	return nil
+}
+
+// annotator identifies who completed an annotation. Label Studio sends either
+// the user's ID or an object describing them.
+func annotator(raw json.RawMessage) string {
+	var user struct {
+		ID    uint64
+		Email string
+	}
+	if err := json.Unmarshal(raw, &user); err == nil {
+		if len(user.Email) > 0 {
+			return user.Email
+		}
+		return fmt.Sprintf("labelstudio:%d", user.ID)
+	}
+	var id uint64
+	if err := json.Unmarshal(raw, &id); err == nil {
+		return fmt.Sprintf("labelstudio:%d", id)
+	}
+	return "labelstudio"
+}
@@ -9,9 +9,13 @@
 package cal
 
 import (
-	"database/sql/driver"
+	"fmt"
 	"sort"
 	"sync"
+
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/cal/driver"
+	"github.com/jmoiron/sqlx"
 )
 
 var driversMu sync.RWMutex
@@ -42,3 +46,50 @@ func Drivers() []string {
 	sort.Strings(list)
 	return list
 }
+
+// Conn is a connection to a specific calendar driver.
+type Conn struct {
+	driver driver.Driver
+	conn   driver.Conn
+}
+
+// Open a connection to a registered driver. The name is passed through to the
+// driver and usually identifies the user whose calendar is being opened.
+func Open(driverName string, db *sqlx.DB, name string) (*Conn, error) {
+	driversMu.RLock()
+	driveri, ok := drivers[driverName]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("cal: unknown driver %q (forgotten import?)",
+			driverName)
+	}
+	conn, err := driveri.Open(db, name)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		driver: driveri,
+		conn:   conn,
+	}
+	return c, nil
+}
+
+// Driver returns the driver used by a connection.
+func (c *Conn) Driver() driver.Driver {
+	return c.driver
+}
+
+// GetEvents returns all events within a given time range.
+func (c *Conn) GetEvents(tr dt.TimeRange) ([]driver.Event, error) {
+	return c.conn.GetEvents(tr)
+}
+
+// CreateEvent on the remote calendar through an opened driver connection.
+func (c *Conn) CreateEvent(params *driver.EventParams) error {
+	return c.conn.CreateEvent(params)
+}
+
+// Close the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
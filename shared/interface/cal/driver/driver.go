@@ -23,6 +23,10 @@ type Conn interface {
 	// should be done on the retrieved events.
 	GetEvents(dt.TimeRange) ([]Event, error)
 
+	// CreateEvent adds a new event to the calendar, inviting any
+	// attendees.
+	CreateEvent(*EventParams) error
+
 	// Close the connection.
 	Close() error
 }
@@ -62,6 +66,15 @@ type Event interface {
 	Update() error
 }
 
+// EventParams is used when creating a new event.
+type EventParams struct {
+	Title          string
+	Location       string
+	StartTime      *time.Time
+	DurationInMins int
+	Attendees      []*dt.Contact
+}
+
 // Attendee of an Event
 type Attendee interface {
 	// Name of the attendee
@@ -0,0 +1,85 @@
+// Package carrier enables tracking shipments with arbitrary carriers. It
+// implements a standardized interface through which UPS, FedEx, USPS and more
+// may be supported. It's up to individual drivers to add support for each of
+// these carriers, and drivers should be registered under the carrier's
+// lowercase name, e.g. "ups".
+package carrier
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/itsabot/abot/shared/interface/carrier/driver"
+)
+
+var driversMu sync.RWMutex
+var drivers = make(map[string]driver.Driver)
+
+// Register makes a carrier driver available by the provided name. If Register
+// is called twice with the same name or if driver is nil, it panics.
+func Register(name string, driver driver.Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("carrier: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("carrier: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns a sorted list of the names of the registered drivers.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	var list []string
+	for name := range drivers {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Conn is a connection to a specific carrier driver.
+type Conn struct {
+	driver driver.Driver
+	conn   driver.Conn
+}
+
+// Open a connection to a registered driver.
+func Open(driverName string) (*Conn, error) {
+	driversMu.RLock()
+	driveri, ok := drivers[driverName]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("carrier: unknown driver %q (forgotten import?)",
+			driverName)
+	}
+	conn, err := driveri.Open()
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		driver: driveri,
+		conn:   conn,
+	}
+	return c, nil
+}
+
+// Driver returns the driver used by a connection.
+func (c *Conn) Driver() driver.Driver {
+	return c.driver
+}
+
+// Track returns the latest status of a shipment through an opened driver
+// connection.
+func (c *Conn) Track(trackingNumber string) (*driver.Status, error) {
+	return c.conn.Track(trackingNumber)
+}
+
+// Close the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,40 @@
+// Package driver defines interfaces to be implemented by carrier drivers as
+// used by package carrier.
+package driver
+
+import "time"
+
+// Driver is the interface that must be implemented by a carrier driver.
+type Driver interface {
+	// Open returns a new connection to the carrier's tracking API.
+	// Authentication is handled by the individual drivers.
+	Open() (Conn, error)
+}
+
+// Conn is a connection to the external carrier service.
+type Conn interface {
+	// Track returns the latest status of a shipment.
+	Track(trackingNumber string) (*Status, error)
+
+	// Close the connection.
+	Close() error
+}
+
+// Status is the state of a shipment as reported by a carrier.
+type Status struct {
+	// Description is a user-presentable summary of the shipment's
+	// status, e.g. "Out for delivery".
+	Description string
+
+	// Location is the last known location of the shipment in a free-form
+	// string.
+	Location string
+
+	// Delivered is true once the shipment has arrived. No further
+	// updates are expected after delivery.
+	Delivered bool
+
+	// EstimatedDelivery is nil if the carrier hasn't provided an
+	// estimate.
+	EstimatedDelivery *time.Time
+}
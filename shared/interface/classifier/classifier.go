@@ -0,0 +1,98 @@
+// Package classifier enables interaction with arbitrary word classifiers,
+// which find the Commands and Objects in users' messages. It implements a
+// standardized interface through which perceptrons, external ML services and
+// more may be supported. It's up to individual drivers to add support for each
+// of these.
+package classifier
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/itsabot/abot/shared/interface/classifier/driver"
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+var driversMu sync.RWMutex
+var drivers = make(map[string]driver.Driver)
+
+// Register makes a classifier driver available by the provided name. If
+// Register is called twice with the same name or if driver is nil, it panics.
+func Register(name string, driver driver.Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("classifier: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("classifier: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns a sorted list of the names of the registered drivers.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	var list []string
+	for name := range drivers {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Conn is a connection to a specific classifier driver.
+type Conn struct {
+	name   string
+	driver driver.Driver
+	conn   driver.Conn
+}
+
+// Open a connection to a registered driver.
+func Open(driverName, auth string) (*Conn, error) {
+	driversMu.RLock()
+	driveri, ok := drivers[driverName]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("classifier: unknown driver %q (forgotten import?)",
+			driverName)
+	}
+	conn, err := driveri.Open(auth)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		name:   driverName,
+		driver: driveri,
+		conn:   conn,
+	}
+	return c, nil
+}
+
+// Name returns the name the connection's driver was registered under.
+func (c *Conn) Name() string {
+	return c.name
+}
+
+// Driver returns the driver used by a connection.
+func (c *Conn) Driver() driver.Driver {
+	return c.driver
+}
+
+// Classify the words of a sentence as Commands and Objects through an opened
+// driver connection.
+func (c *Conn) Classify(sentence string) ([]nlp.WordClass, error) {
+	return c.conn.Classify(sentence)
+}
+
+// Reload the classifier's model through an opened driver connection.
+func (c *Conn) Reload() error {
+	return c.conn.Reload()
+}
+
+// Close the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
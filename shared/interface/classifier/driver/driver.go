@@ -0,0 +1,29 @@
+// Package driver defines interfaces to be implemented by classifier drivers
+// as used by package classifier.
+package driver
+
+import "github.com/itsabot/abot/shared/nlp"
+
+// Driver is the interface that must be implemented by a classifier driver.
+type Driver interface {
+	// Open returns a new connection to the classifier. The auth is a
+	// string in a driver-specific format, e.g. the path to a model file
+	// or an ML service's URL and API key.
+	Open(auth string) (Conn, error)
+}
+
+// Conn is a connection to a classifier, which may run in-process, like a
+// perceptron, or as an external service.
+type Conn interface {
+	// Classify the words of a sentence as Commands and Objects. Words
+	// that are neither are left out.
+	Classify(sentence string) ([]nlp.WordClass, error)
+
+	// Reload the classifier's model, e.g. after it's been retrained,
+	// without interrupting messages being classified. Classifiers without
+	// a model to reload should return nil.
+	Reload() error
+
+	// Close the connection.
+	Close() error
+}
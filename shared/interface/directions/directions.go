@@ -0,0 +1,84 @@
+// Package directions enables interaction with arbitrary directions services to
+// estimate travel times. It implements a standardized interface through which
+// Google Maps, OSRM and more may be supported. It's up to individual drivers to
+// add support for each of these services.
+package directions
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/itsabot/abot/shared/interface/directions/driver"
+)
+
+var driversMu sync.RWMutex
+var drivers = make(map[string]driver.Driver)
+
+// Register makes a directions driver available by the provided name. If
+// Register is called twice with the same name or if driver is nil, it panics.
+func Register(name string, driver driver.Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("directions: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("directions: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns a sorted list of the names of the registered drivers.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	var list []string
+	for name := range drivers {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Conn is a connection to a specific directions driver.
+type Conn struct {
+	driver driver.Driver
+	conn   driver.Conn
+}
+
+// Open a connection to a registered driver.
+func Open(driverName, auth string) (*Conn, error) {
+	driversMu.RLock()
+	driveri, ok := drivers[driverName]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("directions: unknown driver %q (forgotten import?)",
+			driverName)
+	}
+	conn, err := driveri.Open(auth)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		driver: driveri,
+		conn:   conn,
+	}
+	return c, nil
+}
+
+// Driver returns the driver used by a connection.
+func (c *Conn) Driver() driver.Driver {
+	return c.driver
+}
+
+// Route returns the fastest route between two waypoints through an opened
+// driver connection.
+func (c *Conn) Route(q *driver.Query) (*driver.Route, error) {
+	return c.conn.Route(q)
+}
+
+// Close the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,90 @@
+// Package driver defines interfaces to be implemented by directions drivers as
+// used by package directions.
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnsupportedMode is returned when a directions service can't route by a
+// mode of travel, such as transit.
+var ErrUnsupportedMode = errors.New("unsupported mode of travel")
+
+// ErrCoordinatesRequired is returned by drivers that can't geocode addresses
+// when a waypoint has only an address.
+var ErrCoordinatesRequired = errors.New("coordinates required")
+
+// ErrNoRoute is returned when no route exists between two waypoints, e.g.
+// when driving between continents.
+var ErrNoRoute = errors.New("no route")
+
+// Driver is the interface that must be implemented by a directions driver.
+type Driver interface {
+	// Open returns a new connection to the directions service. The auth
+	// is a string in a driver-specific format, usually an API key.
+	Open(auth string) (Conn, error)
+}
+
+// Conn is a connection to the external directions service.
+type Conn interface {
+	// Route returns the fastest route between two waypoints.
+	Route(q *Query) (*Route, error)
+
+	// Close the connection.
+	Close() error
+}
+
+// Mode of travel.
+type Mode string
+
+// Modes of travel. Not every driver supports every mode.
+const (
+	ModeDriving   Mode = "driving"
+	ModeWalking   Mode = "walking"
+	ModeBicycling Mode = "bicycling"
+	ModeTransit   Mode = "transit"
+)
+
+// Waypoint is the start or end of a route. Drivers use the coordinates when
+// Lat and Lon are set and the address otherwise.
+type Waypoint struct {
+	Address string
+	Lat     float64
+	Lon     float64
+}
+
+// HasCoordinates reports whether the waypoint's coordinates are known.
+func (w *Waypoint) HasCoordinates() bool {
+	return w.Lat != 0 || w.Lon != 0
+}
+
+// String returns the waypoint's address, or its coordinates if the address
+// is unknown.
+func (w *Waypoint) String() string {
+	if len(w.Address) > 0 {
+		return w.Address
+	}
+	return fmt.Sprintf("%f,%f", w.Lat, w.Lon)
+}
+
+// Query describes a trip between two waypoints. If DepartAt is set, drivers
+// that consider traffic or transit schedules route for that time. Otherwise
+// they route for now.
+type Query struct {
+	From     *Waypoint
+	To       *Waypoint
+	Mode     Mode
+	DepartAt time.Time
+}
+
+// Route is the fastest way between two waypoints.
+type Route struct {
+	Duration         time.Duration
+	DistanceInMeters int
+
+	// Summary is a short description of the route, like "I-280 S," if
+	// the service provides one.
+	Summary string
+}
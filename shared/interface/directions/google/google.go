@@ -0,0 +1,115 @@
+// Package google is a directions driver for the Google Maps Directions API.
+// Import it for its side effects and pass an API key as the auth when opening
+// a connection:
+//
+//	import _ "github.com/itsabot/abot/shared/interface/directions/google"
+package google
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/itsabot/abot/shared/interface/directions"
+	"github.com/itsabot/abot/shared/interface/directions/driver"
+)
+
+const endpoint = "https://maps.googleapis.com/maps/api/directions/json"
+
+// ErrMissingKey is returned when opening a connection without an API key.
+var ErrMissingKey = errors.New("missing google directions api key")
+
+type drv struct{}
+
+type conn struct {
+	key    string
+	client *http.Client
+}
+
+func init() {
+	directions.Register("google", &drv{})
+}
+
+// Open a connection to Google Maps. The auth is an API key.
+func (d *drv) Open(auth string) (driver.Conn, error) {
+	if len(auth) == 0 {
+		return nil, ErrMissingKey
+	}
+	c := &conn{
+		key:    auth,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	return c, nil
+}
+
+// Route returns Google's fastest route between two waypoints. Driving times
+// include traffic expected at the time of departure.
+func (c *conn) Route(q *driver.Query) (*driver.Route, error) {
+	v := url.Values{}
+	v.Set("origin", q.From.String())
+	v.Set("destination", q.To.String())
+	if len(q.Mode) > 0 {
+		v.Set("mode", string(q.Mode))
+	}
+	if q.DepartAt.After(time.Now()) {
+		v.Set("departure_time", strconv.FormatInt(q.DepartAt.Unix(), 10))
+	} else {
+		v.Set("departure_time", "now")
+	}
+	v.Set("key", c.key)
+	resp, err := c.client.Get(endpoint + "?" + v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("google: unexpected status %d",
+			resp.StatusCode)
+	}
+	type value struct {
+		Value int
+	}
+	var data struct {
+		Status string
+		Routes []struct {
+			Summary string
+			Legs    []struct {
+				Distance          value
+				Duration          value
+				DurationInTraffic *value `json:"duration_in_traffic"`
+			}
+		}
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	switch data.Status {
+	case "OK":
+	case "ZERO_RESULTS", "NOT_FOUND":
+		return nil, driver.ErrNoRoute
+	default:
+		return nil, fmt.Errorf("google: unexpected status %s", data.Status)
+	}
+	if len(data.Routes) == 0 {
+		return nil, driver.ErrNoRoute
+	}
+	r := &driver.Route{Summary: data.Routes[0].Summary}
+	for _, leg := range data.Routes[0].Legs {
+		secs := leg.Duration.Value
+		if leg.DurationInTraffic != nil {
+			secs = leg.DurationInTraffic.Value
+		}
+		r.Duration += time.Duration(secs) * time.Second
+		r.DistanceInMeters += leg.Distance.Value
+	}
+	return r, nil
+}
+
+// Close the connection.
+func (c *conn) Close() error {
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package osrm is a directions driver for the Open Source Routing Machine.
+// OSRM routes between coordinates only and doesn't support transit, but it's
+// free and needs no API key. Import it for its side effects:
+//
+//	import _ "github.com/itsabot/abot/shared/interface/directions/osrm"
+//
+// The auth is an optional URL of an OSRM server, defaulting to the public demo
+// server at router.project-osrm.org.
+package osrm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/shared/interface/directions"
+	"github.com/itsabot/abot/shared/interface/directions/driver"
+)
+
+const defaultEndpoint = "https://router.project-osrm.org"
+
+// profiles maps modes of travel to OSRM profiles.
+var profiles = map[driver.Mode]string{
+	"":                   "driving",
+	driver.ModeDriving:   "driving",
+	driver.ModeWalking:   "foot",
+	driver.ModeBicycling: "bike",
+}
+
+type drv struct{}
+
+type conn struct {
+	endpoint string
+	client   *http.Client
+}
+
+func init() {
+	directions.Register("osrm", &drv{})
+}
+
+// Open a connection to OSRM. The auth is an optional URL of an OSRM server.
+func (d *drv) Open(auth string) (driver.Conn, error) {
+	c := &conn{
+		endpoint: defaultEndpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	if len(auth) > 0 {
+		c.endpoint = strings.TrimRight(auth, "/")
+	}
+	return c, nil
+}
+
+// Route returns OSRM's fastest route between two waypoints, which must have
+// coordinates. OSRM doesn't consider traffic, so DepartAt is ignored.
+func (c *conn) Route(q *driver.Query) (*driver.Route, error) {
+	profile, ok := profiles[q.Mode]
+	if !ok {
+		return nil, driver.ErrUnsupportedMode
+	}
+	if !q.From.HasCoordinates() || !q.To.HasCoordinates() {
+		return nil, driver.ErrCoordinatesRequired
+	}
+	u := fmt.Sprintf("%s/route/v1/%s/%f,%f;%f,%f?overview=false",
+		c.endpoint, profile, q.From.Lon, q.From.Lat, q.To.Lon, q.To.Lat)
+	resp, err := c.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var data struct {
+		Code   string
+		Routes []struct {
+			Duration float64
+			Distance float64
+			Legs     []struct {
+				Summary string
+			}
+		}
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	switch data.Code {
+	case "Ok":
+	case "NoRoute", "NoSegment":
+		return nil, driver.ErrNoRoute
+	default:
+		return nil, fmt.Errorf("osrm: unexpected code %s", data.Code)
+	}
+	if len(data.Routes) == 0 {
+		return nil, driver.ErrNoRoute
+	}
+	r := &driver.Route{
+		Duration:         time.Duration(data.Routes[0].Duration) * time.Second,
+		DistanceInMeters: int(data.Routes[0].Distance),
+	}
+	if len(data.Routes[0].Legs) > 0 {
+		r.Summary = data.Routes[0].Legs[0].Summary
+	}
+	return r, nil
+}
+
+// Close the connection.
+func (c *conn) Close() error {
+	return nil
+}
@@ -0,0 +1,21 @@
+// Package driver defines interfaces to be implemented by embedding drivers as
+// used by package embedding.
+package driver
+
+// Driver is the interface that must be implemented by an embedding driver.
+type Driver interface {
+	// Open returns a new connection to the embedding service. The auth is
+	// a string in a driver-specific format, usually an API key.
+	Open(auth string) (Conn, error)
+}
+
+// Conn is a connection to the external embedding service.
+type Conn interface {
+	// Embed returns a vector for each text, in order, such that texts
+	// with similar meanings have vectors with high cosine similarity.
+	// Every vector from a connection has the same number of dimensions.
+	Embed(texts []string) ([][]float32, error)
+
+	// Close the connection.
+	Close() error
+}
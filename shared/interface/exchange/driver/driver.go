@@ -0,0 +1,21 @@
+// Package driver defines interfaces to be implemented by exchange rate drivers
+// as used by package exchange.
+package driver
+
+// Driver is the interface that must be implemented by an exchange rate driver.
+type Driver interface {
+	// Open returns a new connection to the exchange rate service. The auth
+	// is a string in a driver-specific format, usually an API key.
+	Open(auth string) (Conn, error)
+}
+
+// Conn is a connection to the external exchange rate service. Currencies are
+// identified by their ISO 4217 codes, e.g. "USD" or "EUR".
+type Conn interface {
+	// Rate returns the number of units of the to currency that one unit of
+	// the from currency buys.
+	Rate(from, to string) (float64, error)
+
+	// Close the connection.
+	Close() error
+}
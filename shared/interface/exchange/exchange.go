@@ -0,0 +1,94 @@
+// Package exchange enables interaction with arbitrary currency exchange rate
+// services. It implements a standardized interface through which Open
+// Exchange Rates, Fixer and more may be supported. It's up to individual
+// drivers to add support for each of these services.
+package exchange
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/itsabot/abot/shared/interface/exchange/driver"
+)
+
+var driversMu sync.RWMutex
+var drivers = make(map[string]driver.Driver)
+
+// Register makes an exchange rate driver available by the provided name. If
+// Register is called twice with the same name or if driver is nil, it panics.
+func Register(name string, driver driver.Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("exchange: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("exchange: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns a sorted list of the names of the registered drivers.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	var list []string
+	for name := range drivers {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Conn is a connection to a specific exchange rate driver.
+type Conn struct {
+	driver driver.Driver
+	conn   driver.Conn
+}
+
+// Open a connection to a registered driver.
+func Open(driverName, auth string) (*Conn, error) {
+	driversMu.RLock()
+	driveri, ok := drivers[driverName]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("exchange: unknown driver %q (forgotten import?)",
+			driverName)
+	}
+	conn, err := driveri.Open(auth)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		driver: driveri,
+		conn:   conn,
+	}
+	return c, nil
+}
+
+// Driver returns the driver used by a connection.
+func (c *Conn) Driver() driver.Driver {
+	return c.driver
+}
+
+// Rate returns the exchange rate between two currencies through an opened
+// driver connection.
+func (c *Conn) Rate(from, to string) (float64, error) {
+	return c.conn.Rate(from, to)
+}
+
+// Convert an amount from one currency to another through an opened driver
+// connection.
+func (c *Conn) Convert(amount float64, from, to string) (float64, error) {
+	rate, err := c.conn.Rate(from, to)
+	if err != nil {
+		return 0, err
+	}
+	return amount * rate, nil
+}
+
+// Close the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
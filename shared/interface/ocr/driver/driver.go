@@ -0,0 +1,21 @@
+// Package driver defines interfaces to be implemented by OCR drivers as used
+// by package ocr.
+package driver
+
+// Driver is the interface that must be implemented by an OCR driver.
+type Driver interface {
+	// Open returns a new connection to the OCR service. The auth is a
+	// string in a driver-specific format, usually an API key.
+	Open(auth string) (Conn, error)
+}
+
+// Conn is a connection to the external OCR service.
+type Conn interface {
+	// Read returns the text in the image at a URL, with lines separated
+	// by newlines. If the image contains no text, an empty string is
+	// returned.
+	Read(imageURL string) (string, error)
+
+	// Close the connection.
+	Close() error
+}
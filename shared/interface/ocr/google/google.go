@@ -0,0 +1,148 @@
+// Package google is an OCR driver for the Google Cloud Vision API. Import it
+// for its side effects and pass an API key as the auth when opening a
+// connection:
+//
+//	import _ "github.com/itsabot/abot/shared/interface/ocr/google"
+package google
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/itsabot/abot/shared/interface/ocr"
+	"github.com/itsabot/abot/shared/interface/ocr/driver"
+)
+
+const endpoint = "https://vision.googleapis.com/v1/images:annotate"
+
+// maxImageBytes is the largest image Cloud Vision accepts.
+const maxImageBytes = 10 << 20
+
+// ErrMissingKey is returned when opening a connection without an API key.
+var ErrMissingKey = errors.New("missing google vision api key")
+
+// ErrImageTooLarge is returned when an image is too large to be read.
+var ErrImageTooLarge = errors.New("image too large")
+
+type drv struct{}
+
+type conn struct {
+	key    string
+	client *http.Client
+}
+
+func init() {
+	ocr.Register("google", &drv{})
+}
+
+// Open a connection to Cloud Vision. The auth is an API key.
+func (d *drv) Open(auth string) (driver.Conn, error) {
+	if len(auth) == 0 {
+		return nil, ErrMissingKey
+	}
+	c := &conn{
+		key:    auth,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	return c, nil
+}
+
+// Read returns the text Cloud Vision detects in an image. The image is
+// downloaded and sent inline rather than by URL, since media sent over SMS is
+// often at URLs Google can't reach.
+func (c *conn) Read(imageURL string) (string, error) {
+	img, err := c.download(imageURL)
+	if err != nil {
+		return "", err
+	}
+	type feature struct {
+		Type string `json:"type"`
+	}
+	type image struct {
+		Content string `json:"content"`
+	}
+	type request struct {
+		Image    image     `json:"image"`
+		Features []feature `json:"features"`
+	}
+	body := struct {
+		Requests []request `json:"requests"`
+	}{
+		Requests: []request{{
+			Image:    image{Content: base64.StdEncoding.EncodeToString(img)},
+			Features: []feature{{Type: "TEXT_DETECTION"}},
+		}},
+	}
+	byt, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	u := endpoint + "?key=" + url.QueryEscape(c.key)
+	resp, err := c.client.Post(u, "application/json", bytes.NewBuffer(byt))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("google: unexpected status %d",
+			resp.StatusCode)
+	}
+	var data struct {
+		Responses []struct {
+			FullTextAnnotation *struct {
+				Text string
+			}
+			Error *struct {
+				Message string
+			}
+		}
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return "", err
+	}
+	if len(data.Responses) == 0 {
+		return "", nil
+	}
+	r := data.Responses[0]
+	if r.Error != nil {
+		return "", errors.New("google: " + r.Error.Message)
+	}
+	if r.FullTextAnnotation == nil {
+		return "", nil
+	}
+	return r.FullTextAnnotation.Text, nil
+}
+
+// download fetches an image, refusing any too large for Cloud Vision.
+func (c *conn) download(imageURL string) ([]byte, error) {
+	resp, err := c.client.Get(imageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("google: unexpected status %d fetching image",
+			resp.StatusCode)
+	}
+	img, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(img) > maxImageBytes {
+		return nil, ErrImageTooLarge
+	}
+	return img, nil
+}
+
+// Close the connection.
+func (c *conn) Close() error {
+	return nil
+}
@@ -3,6 +3,8 @@
 package driver
 
 import (
+	"net/http"
+
 	"github.com/itsabot/abot/shared/datatypes"
 	"github.com/jmoiron/sqlx"
 	"github.com/julienschmidt/httprouter"
@@ -26,13 +28,69 @@ type Conn interface {
 	SaveCard(params *dt.CardParams, user *dt.User) (cardID uint64, err error)
 
 	// Charge a customer for something. The isoCurrency is the currency in
-	// its 3-letter ISO code.
-	ChargeCard(cardID uint64, amountInCents uint64, isoCurrency string) error
+	// its 3-letter ISO code. The description appears on the customer's
+	// statement where supported. Returns the payment service's ID for the
+	// charge, which is used for refunds and disputes.
+	ChargeCard(cardID uint64, amountInCents uint64, isoCurrency,
+		desc string) (serviceChargeID string, err error)
+
+	// Refund some or all of a charge.
+	Refund(serviceChargeID string, amountInCents uint64) error
 
 	// RegisterUser on the external payment service, saving the customer
 	// identifying token to User.PaymentServiceID in the database.
 	RegisterUser(user *dt.User) error
 
+	// CardURL returns a link to a secure page, usually hosted by the
+	// payment service, where a registered user can add a card. Card
+	// details never touch Abot. Instead the card is reported back through
+	// an EventCardAdded webhook event.
+	CardURL(user *dt.User) (string, error)
+
+	// ParseEvent verifies and parses a webhook request from the payment
+	// service. Events that Abot doesn't handle should be returned as nil.
+	ParseEvent(r *http.Request) (*Event, error)
+
 	// Close the connection.
 	Close() error
 }
+
+// EventType identifies the kind of webhook event sent by a payment service.
+type EventType int
+
+const (
+	// EventCardAdded is sent when a user adds a card through the page at
+	// CardURL.
+	EventCardAdded EventType = iota + 1
+
+	// EventChargeRefunded is sent when a charge is refunded, including
+	// refunds made outside of Abot, e.g. in the payment service's
+	// dashboard.
+	EventChargeRefunded
+
+	// EventChargeDisputed is sent when a customer disputes a charge with
+	// their bank.
+	EventChargeDisputed
+)
+
+// Event is a webhook event sent by the payment service.
+type Event struct {
+	Type EventType
+
+	// PaymentServiceID identifies the customer. It's set for
+	// EventCardAdded.
+	PaymentServiceID string
+
+	// Card describes the added card. It's set for EventCardAdded.
+	Card *dt.CardParams
+
+	// ServiceChargeID identifies the charge. It's set for
+	// EventChargeRefunded and EventChargeDisputed.
+	ServiceChargeID string
+
+	// AmountInCents is the total amount refunded or disputed.
+	AmountInCents uint64
+
+	// Reason is the customer's reason for a dispute, if provided.
+	Reason string
+}
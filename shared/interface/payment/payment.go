@@ -6,9 +6,11 @@ package payment
 
 import (
 	"fmt"
+	"net/http"
 	"sort"
 	"sync"
 
+	"github.com/itsabot/abot/shared/datatypes"
 	"github.com/itsabot/abot/shared/interface/payment/driver"
 	"github.com/jmoiron/sqlx"
 	"github.com/julienschmidt/httprouter"
@@ -23,10 +25,10 @@ func Register(name string, driver driver.Driver) {
 	driversMu.Lock()
 	defer driversMu.Unlock()
 	if driver == nil {
-		panic("cal: Register driver is nil")
+		panic("payment: Register driver is nil")
 	}
 	if _, dup := drivers[name]; dup {
-		panic("cal: Register called twice for driver " + name)
+		panic("payment: Register called twice for driver " + name)
 	}
 	drivers[name] = driver
 }
@@ -57,7 +59,7 @@ func Open(driverName string, db *sqlx.DB, r *httprouter.Router,
 	driveri, ok := drivers[driverName]
 	driversMu.RUnlock()
 	if !ok {
-		return nil, fmt.Errorf("sms: unknown driver %q (forgotten import?)",
+		return nil, fmt.Errorf("payment: unknown driver %q (forgotten import?)",
 			driverName)
 	}
 	conn, err := driveri.Open(db, r, auth)
@@ -75,3 +77,41 @@ func Open(driverName string, db *sqlx.DB, r *httprouter.Router,
 func (c *Conn) Driver() driver.Driver {
 	return c.driver
 }
+
+// SaveCard through an opened driver connection.
+func (c *Conn) SaveCard(params *dt.CardParams, u *dt.User) (uint64, error) {
+	return c.conn.SaveCard(params, u)
+}
+
+// ChargeCard through an opened driver connection, returning the payment
+// service's ID for the charge.
+func (c *Conn) ChargeCard(cardID, amountInCents uint64, isoCurrency,
+	desc string) (string, error) {
+
+	return c.conn.ChargeCard(cardID, amountInCents, isoCurrency, desc)
+}
+
+// Refund some or all of a charge through an opened driver connection.
+func (c *Conn) Refund(serviceChargeID string, amountInCents uint64) error {
+	return c.conn.Refund(serviceChargeID, amountInCents)
+}
+
+// RegisterUser with the payment service through an opened driver connection.
+func (c *Conn) RegisterUser(u *dt.User) error {
+	return c.conn.RegisterUser(u)
+}
+
+// CardURL returns a link to a secure page where the user can add a card.
+func (c *Conn) CardURL(u *dt.User) (string, error) {
+	return c.conn.CardURL(u)
+}
+
+// ParseEvent verifies and parses a webhook request from the payment service.
+func (c *Conn) ParseEvent(r *http.Request) (*driver.Event, error) {
+	return c.conn.ParseEvent(r)
+}
+
+// Close the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
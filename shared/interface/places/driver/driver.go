@@ -0,0 +1,117 @@
+// Package driver defines interfaces to be implemented by places drivers as
+// used by package places.
+package driver
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Driver is the interface that must be implemented by a places driver.
+type Driver interface {
+	// Open returns a new connection to the places service. The auth is a
+	// string in a driver-specific format, usually an API key.
+	Open(auth string) (Conn, error)
+}
+
+// Conn is a connection to the external places service.
+type Conn interface {
+	// NearbySearch returns places matching a query, nearest or most
+	// relevant first.
+	NearbySearch(q *Query) ([]Place, error)
+
+	Geocoder
+
+	// Close the connection.
+	Close() error
+}
+
+// Geocoder resolves the names and addresses of places to coordinates. Every
+// Conn is one, but a separate Geocoder may be used for geocoding alone, e.g.
+// Nominatim alongside a places service without good coverage of addresses.
+type Geocoder interface {
+	// Geocode returns places matching a name or address, like
+	// "Springfield" or "1 Main St," most relevant first.
+	Geocode(query string, limit int) ([]Place, error)
+}
+
+// Query describes a search for places near a point, e.g. "coffee" within
+// 1,000 meters of the user.
+type Query struct {
+	Keyword        string
+	Lat            float64
+	Lon            float64
+	RadiusInMeters int
+	Limit          int
+}
+
+// Place is a business or point of interest returned by a places service.
+// Fields a service doesn't provide are left empty.
+type Place struct {
+	ID      string
+	Name    string
+	Address string
+	Phone   string
+	URL     string
+	Lat     float64
+	Lon     float64
+
+	// Rating is out of 5, or 0 if the place hasn't been rated.
+	Rating      float64
+	RatingCount int
+
+	// Hours are the place's regular opening hours, if known. OpenNow is
+	// set when the service reports it directly.
+	Hours   []Hours
+	OpenNow sql.NullBool
+
+	// DistanceInMeters is the distance from the point searched.
+	DistanceInMeters int
+
+	// Accuracy is how precisely Lat and Lon locate a geocoded place.
+	Accuracy Accuracy
+}
+
+// Accuracy is how precisely a geocoded point locates the place it was found
+// for. A city geocodes to its center, so a point for "Chicago" is only
+// accurate to the locality, while one for "1 Main St" is exact.
+type Accuracy int
+
+// Accuracies of geocoded points, least precise first. AccuracyUnknown is used
+// when the service doesn't say.
+const (
+	AccuracyUnknown Accuracy = iota
+	AccuracyRegion
+	AccuracyLocality
+	AccuracyStreet
+	AccuracyExact
+)
+
+// Hours during which a place is open on a day of the week. Open and Close are
+// in minutes after midnight. Close may exceed 24 hours for places open past
+// midnight, e.g. 1560 for 2am.
+type Hours struct {
+	Weekday time.Weekday
+	Open    int
+	Close   int
+}
+
+// OpenAt reports whether a place is open at a time. The second bool is false
+// if the place's hours are unknown.
+func (p *Place) OpenAt(t time.Time) (open bool, known bool) {
+	if len(p.Hours) == 0 {
+		return false, false
+	}
+	mins := t.Hour()*60 + t.Minute()
+	wd := t.Weekday()
+	for _, h := range p.Hours {
+		if h.Weekday == wd && mins >= h.Open && mins < h.Close {
+			return true, true
+		}
+		// Hours from the night before that run past midnight.
+		if (h.Weekday+1)%7 == wd && mins+24*60 < h.Close {
+			return true, true
+		}
+	}
+	return false, true
+}
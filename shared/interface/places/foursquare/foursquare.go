@@ -0,0 +1,215 @@
+// Package foursquare is a places driver for the Foursquare Places API. Import
+// it for its side effects and pass an API key as the auth when opening a
+// connection:
+//
+//	import _ "github.com/itsabot/abot/shared/interface/places/foursquare"
+package foursquare
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/itsabot/abot/shared/interface/places"
+	"github.com/itsabot/abot/shared/interface/places/driver"
+)
+
+const (
+	endpoint             = "https://api.foursquare.com/v3/places/search"
+	autocompleteEndpoint = "https://api.foursquare.com/v3/autocomplete"
+)
+
+// fields requested for each place.
+const fields = "fsq_id,name,location,geocodes,distance,rating,stats,hours,tel,website"
+
+// ErrMissingKey is returned when opening a connection without an API key.
+var ErrMissingKey = errors.New("missing foursquare api key")
+
+type drv struct{}
+
+type conn struct {
+	key    string
+	client *http.Client
+}
+
+func init() {
+	places.Register("foursquare", &drv{})
+}
+
+// Open a connection to Foursquare. The auth is an API key.
+func (d *drv) Open(auth string) (driver.Conn, error) {
+	if len(auth) == 0 {
+		return nil, ErrMissingKey
+	}
+	c := &conn{
+		key:    auth,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	return c, nil
+}
+
+// NearbySearch finds places near a point.
+func (c *conn) NearbySearch(q *driver.Query) ([]driver.Place, error) {
+	v := url.Values{}
+	v.Set("ll", fmt.Sprintf("%f,%f", q.Lat, q.Lon))
+	v.Set("radius", strconv.Itoa(q.RadiusInMeters))
+	v.Set("query", q.Keyword)
+	v.Set("fields", fields)
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	var data struct {
+		Results []struct {
+			FsqID    string `json:"fsq_id"`
+			Name     string
+			Distance int
+			Location struct {
+				FormattedAddress string `json:"formatted_address"`
+			}
+			Geocodes struct {
+				Main struct {
+					Latitude  float64
+					Longitude float64
+				}
+			}
+			Rating float64
+			Stats  struct {
+				TotalRatings int `json:"total_ratings"`
+			}
+			Hours struct {
+				OpenNow *bool `json:"open_now"`
+				Regular []struct {
+					Day   int
+					Open  string
+					Close string
+				}
+			}
+			Tel     string
+			Website string
+		}
+	}
+	if err := c.get(endpoint, v, &data); err != nil {
+		return nil, err
+	}
+	var ps []driver.Place
+	for _, r := range data.Results {
+		p := driver.Place{
+			ID:      r.FsqID,
+			Name:    r.Name,
+			Address: r.Location.FormattedAddress,
+			Phone:   r.Tel,
+			URL:     r.Website,
+			Lat:     r.Geocodes.Main.Latitude,
+			Lon:     r.Geocodes.Main.Longitude,
+			// Foursquare rates places out of 10.
+			Rating:           r.Rating / 2,
+			RatingCount:      r.Stats.TotalRatings,
+			DistanceInMeters: r.Distance,
+		}
+		if r.Hours.OpenNow != nil {
+			p.OpenNow = sql.NullBool{Bool: *r.Hours.OpenNow,
+				Valid: true}
+		}
+		for _, h := range r.Hours.Regular {
+			// Days run from 1 for Monday to 7 for Sunday.
+			hrs := driver.Hours{
+				Weekday: time.Weekday(h.Day % 7),
+				Open:    mins(h.Open),
+				Close:   mins(h.Close),
+			}
+			if hrs.Close <= hrs.Open {
+				hrs.Close += 24 * 60
+			}
+			p.Hours = append(p.Hours, hrs)
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// Geocode finds cities, neighborhoods and other geographic areas matching a
+// name using Foursquare's autocomplete.
+func (c *conn) Geocode(query string, limit int) ([]driver.Place, error) {
+	v := url.Values{}
+	v.Set("query", query)
+	v.Set("types", "geo")
+	if limit > 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+	var data struct {
+		Results []struct {
+			Text struct {
+				Primary   string
+				Secondary string
+			}
+			Geo struct {
+				Name   string
+				Center struct {
+					Latitude  float64
+					Longitude float64
+				}
+			}
+		}
+	}
+	if err := c.get(autocompleteEndpoint, v, &data); err != nil {
+		return nil, err
+	}
+	var ps []driver.Place
+	for _, r := range data.Results {
+		addr := r.Text.Primary
+		if len(r.Text.Secondary) > 0 {
+			addr += ", " + r.Text.Secondary
+		}
+		ps = append(ps, driver.Place{
+			Name:    r.Geo.Name,
+			Address: addr,
+			Lat:     r.Geo.Center.Latitude,
+			Lon:     r.Geo.Center.Longitude,
+		})
+	}
+	return ps, nil
+}
+
+func (c *conn) get(endpoint string, v url.Values, data interface{}) error {
+	req, err := http.NewRequest("GET", endpoint+"?"+v.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", c.key)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("foursquare: unexpected status %d",
+			resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(data)
+}
+
+// mins converts a time of day in the form "hhmm" to minutes after midnight.
+// Times past midnight are prefixed with "+", e.g. "+0200".
+func mins(s string) int {
+	var next int
+	if len(s) > 0 && s[0] == '+' {
+		next, s = 24*60, s[1:]
+	}
+	if len(s) != 4 {
+		return 0
+	}
+	h, _ := strconv.Atoi(s[:2])
+	m, _ := strconv.Atoi(s[2:])
+	return next + h*60 + m
+}
+
+// Close the connection.
+func (c *conn) Close() error {
+	return nil
+}
@@ -0,0 +1,242 @@
+// Package google is a places driver for the Google Places API. Import it for
+// its side effects and pass an API key as the auth when opening a connection:
+//
+//	import _ "github.com/itsabot/abot/shared/interface/places/google"
+package google
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/shared/interface/places"
+	"github.com/itsabot/abot/shared/interface/places/driver"
+)
+
+const (
+	nearbyEndpoint  = "https://maps.googleapis.com/maps/api/place/nearbysearch/json"
+	detailsEndpoint = "https://maps.googleapis.com/maps/api/place/details/json"
+	geocodeEndpoint = "https://maps.googleapis.com/maps/api/geocode/json"
+)
+
+// ErrMissingKey is returned when opening a connection without an API key.
+var ErrMissingKey = errors.New("missing google places api key")
+
+type drv struct{}
+
+type conn struct {
+	key    string
+	client *http.Client
+}
+
+func init() {
+	places.Register("google", &drv{})
+}
+
+// Open a connection to Google Places. The auth is an API key.
+func (d *drv) Open(auth string) (driver.Conn, error) {
+	if len(auth) == 0 {
+		return nil, ErrMissingKey
+	}
+	c := &conn{
+		key:    auth,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	return c, nil
+}
+
+// NearbySearch finds places near a point. Since nearby search results don't
+// include opening hours, phone numbers or websites, each result's details are
+// fetched as well.
+func (c *conn) NearbySearch(q *driver.Query) ([]driver.Place, error) {
+	v := url.Values{}
+	v.Set("location", fmt.Sprintf("%f,%f", q.Lat, q.Lon))
+	v.Set("radius", strconv.Itoa(q.RadiusInMeters))
+	v.Set("keyword", q.Keyword)
+	var data struct {
+		Status  string
+		Results []struct {
+			PlaceID  string `json:"place_id"`
+			Name     string
+			Vicinity string
+			Geometry struct {
+				Location struct {
+					Lat float64
+					Lng float64
+				}
+			}
+			Rating           float64
+			UserRatingsTotal int `json:"user_ratings_total"`
+			OpeningHours     *struct {
+				OpenNow bool `json:"open_now"`
+			} `json:"opening_hours"`
+		}
+	}
+	if err := c.get(nearbyEndpoint, v, &data); err != nil {
+		return nil, err
+	}
+	if data.Status != "OK" && data.Status != "ZERO_RESULTS" {
+		return nil, fmt.Errorf("google: unexpected status %s", data.Status)
+	}
+	var ps []driver.Place
+	for _, r := range data.Results {
+		if q.Limit > 0 && len(ps) == q.Limit {
+			break
+		}
+		p := driver.Place{
+			ID:          r.PlaceID,
+			Name:        r.Name,
+			Address:     r.Vicinity,
+			Lat:         r.Geometry.Location.Lat,
+			Lon:         r.Geometry.Location.Lng,
+			Rating:      r.Rating,
+			RatingCount: r.UserRatingsTotal,
+		}
+		if r.OpeningHours != nil {
+			p.OpenNow = sql.NullBool{Bool: r.OpeningHours.OpenNow,
+				Valid: true}
+		}
+		if err := c.details(&p); err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// details adds a place's phone number, website and opening hours.
+func (c *conn) details(p *driver.Place) error {
+	v := url.Values{}
+	v.Set("place_id", p.ID)
+	v.Set("fields", "formatted_phone_number,website,opening_hours")
+	var data struct {
+		Status string
+		Result struct {
+			FormattedPhoneNumber string `json:"formatted_phone_number"`
+			Website              string
+			OpeningHours         struct {
+				Periods []struct {
+					Open  period
+					Close *period
+				}
+			} `json:"opening_hours"`
+		}
+	}
+	if err := c.get(detailsEndpoint, v, &data); err != nil {
+		return err
+	}
+	if data.Status != "OK" {
+		return fmt.Errorf("google: unexpected status %s", data.Status)
+	}
+	p.Phone = data.Result.FormattedPhoneNumber
+	p.URL = data.Result.Website
+	for _, pd := range data.Result.OpeningHours.Periods {
+		h := driver.Hours{
+			Weekday: time.Weekday(pd.Open.Day),
+			Open:    pd.Open.mins(),
+		}
+		if pd.Close == nil {
+			// Open 24 hours.
+			h.Close = 24 * 60
+		} else {
+			h.Close = pd.Close.mins()
+			if pd.Close.Day != pd.Open.Day {
+				h.Close += 24 * 60
+			}
+		}
+		p.Hours = append(p.Hours, h)
+	}
+	return nil
+}
+
+// Geocode finds places matching a name or address using the Google Geocoding
+// API.
+func (c *conn) Geocode(query string, limit int) ([]driver.Place, error) {
+	v := url.Values{}
+	v.Set("address", query)
+	var data struct {
+		Status  string
+		Results []struct {
+			PlaceID          string `json:"place_id"`
+			FormattedAddress string `json:"formatted_address"`
+			Geometry         struct {
+				Location struct {
+					Lat float64
+					Lng float64
+				}
+				LocationType string `json:"location_type"`
+			}
+		}
+	}
+	if err := c.get(geocodeEndpoint, v, &data); err != nil {
+		return nil, err
+	}
+	if data.Status != "OK" && data.Status != "ZERO_RESULTS" {
+		return nil, fmt.Errorf("google: unexpected status %s", data.Status)
+	}
+	var ps []driver.Place
+	for _, r := range data.Results {
+		if limit > 0 && len(ps) == limit {
+			break
+		}
+		ps = append(ps, driver.Place{
+			ID:       r.PlaceID,
+			Name:     strings.Split(r.FormattedAddress, ",")[0],
+			Address:  r.FormattedAddress,
+			Lat:      r.Geometry.Location.Lat,
+			Lon:      r.Geometry.Location.Lng,
+			Accuracy: locationAccuracy[r.Geometry.LocationType],
+		})
+	}
+	return ps, nil
+}
+
+// locationAccuracy converts the location types of Google's geocoder to
+// accuracies. Geometric centers are of streets or areas like cities.
+var locationAccuracy = map[string]driver.Accuracy{
+	"ROOFTOP":            driver.AccuracyExact,
+	"RANGE_INTERPOLATED": driver.AccuracyStreet,
+	"GEOMETRIC_CENTER":   driver.AccuracyLocality,
+	"APPROXIMATE":        driver.AccuracyRegion,
+}
+
+// period is a day of the week and time of day in the form "hhmm", as used by
+// Google's opening hours.
+type period struct {
+	Day  int
+	Time string
+}
+
+func (p period) mins() int {
+	if len(p.Time) != 4 {
+		return 0
+	}
+	h, _ := strconv.Atoi(p.Time[:2])
+	m, _ := strconv.Atoi(p.Time[2:])
+	return h*60 + m
+}
+
+func (c *conn) get(endpoint string, v url.Values, data interface{}) error {
+	v.Set("key", c.key)
+	resp, err := c.client.Get(endpoint + "?" + v.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("google: unexpected status %d",
+			resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(data)
+}
+
+// Close the connection.
+func (c *conn) Close() error {
+	return nil
+}
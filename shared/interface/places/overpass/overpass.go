@@ -0,0 +1,271 @@
+// Package overpass is a places driver for OpenStreetMap through the Overpass
+// API, geocoding through Nominatim. OpenStreetMap has no ratings, but it's free
+// and needs no API key. Import it for its side effects:
+//
+//	import _ "github.com/itsabot/abot/shared/interface/places/overpass"
+//
+// The auth is an optional URL of an Overpass API instance, defaulting to the
+// public instance at overpass-api.de.
+package overpass
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/shared/interface/places"
+	"github.com/itsabot/abot/shared/interface/places/driver"
+)
+
+const (
+	defaultEndpoint   = "https://overpass-api.de/api/interpreter"
+	nominatimEndpoint = "https://nominatim.openstreetmap.org/search"
+)
+
+// userAgent identifies Abot to Nominatim, as its usage policy requires.
+const userAgent = "Abot (https://github.com/itsabot/abot)"
+
+// regexSpecial matches characters with special meaning in Overpass regular
+// expressions, which are escaped in keywords.
+var regexSpecial = regexp.MustCompile(`[\\^$.|?*+()\[\]{}"]`)
+
+// regexRule matches one rule of an OpenStreetMap opening_hours tag, e.g.
+// "Mo-Fr 08:00-18:00".
+var regexRule = regexp.MustCompile(`^([A-Za-z,\-]+)\s+(\d{2}):(\d{2})-(\d{2}):(\d{2})$`)
+
+var weekdays = map[string]time.Weekday{
+	"Su": time.Sunday,
+	"Mo": time.Monday,
+	"Tu": time.Tuesday,
+	"We": time.Wednesday,
+	"Th": time.Thursday,
+	"Fr": time.Friday,
+	"Sa": time.Saturday,
+}
+
+type drv struct{}
+
+type conn struct {
+	endpoint string
+	client   *http.Client
+}
+
+func init() {
+	places.Register("overpass", &drv{})
+}
+
+// Open a connection to the Overpass API. The auth is an optional URL of an
+// Overpass instance.
+func (d *drv) Open(auth string) (driver.Conn, error) {
+	c := &conn{
+		endpoint: defaultEndpoint,
+		client:   &http.Client{Timeout: 25 * time.Second},
+	}
+	if len(auth) > 0 {
+		c.endpoint = auth
+	}
+	return c, nil
+}
+
+// NearbySearch finds named places near a point whose name, amenity, cuisine
+// or shop matches the keyword, e.g. "cafe" or "pizza".
+func (c *conn) NearbySearch(q *driver.Query) ([]driver.Place, error) {
+	kw := regexSpecial.ReplaceAllString(q.Keyword, `\\$0`)
+	limit := ""
+	if q.Limit > 0 {
+		limit = " " + strconv.Itoa(q.Limit)
+	}
+	ql := fmt.Sprintf(`[out:json][timeout:20];
+nwr(around:%d,%f,%f)["name"][~"^(name|amenity|cuisine|shop)$"~"%s",i];
+out center%s;`, q.RadiusInMeters, q.Lat, q.Lon, kw, limit)
+	resp, err := c.client.PostForm(c.endpoint, url.Values{"data": {ql}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("overpass: unexpected status %d",
+			resp.StatusCode)
+	}
+	var data struct {
+		Elements []struct {
+			Type   string
+			ID     int64
+			Lat    float64
+			Lon    float64
+			Center *struct {
+				Lat float64
+				Lon float64
+			}
+			Tags map[string]string
+		}
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	var ps []driver.Place
+	for _, e := range data.Elements {
+		p := driver.Place{
+			ID:    fmt.Sprintf("%s/%d", e.Type, e.ID),
+			Name:  e.Tags["name"],
+			Phone: e.Tags["phone"],
+			URL:   e.Tags["website"],
+			Lat:   e.Lat,
+			Lon:   e.Lon,
+			Hours: parseHours(e.Tags["opening_hours"]),
+		}
+		if e.Center != nil {
+			p.Lat, p.Lon = e.Center.Lat, e.Center.Lon
+		}
+		if len(e.Tags["addr:street"]) > 0 {
+			p.Address = strings.TrimSpace(e.Tags["addr:housenumber"] +
+				" " + e.Tags["addr:street"])
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// Geocode finds places matching a name or address through Nominatim.
+func (c *conn) Geocode(query string, limit int) ([]driver.Place, error) {
+	v := url.Values{}
+	v.Set("q", query)
+	v.Set("format", "jsonv2")
+	if limit > 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+	req, err := http.NewRequest("GET", nominatimEndpoint+"?"+v.Encode(),
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("overpass: unexpected nominatim status %d",
+			resp.StatusCode)
+	}
+	var data []struct {
+		OSMType     string `json:"osm_type"`
+		OSMID       int64  `json:"osm_id"`
+		DisplayName string `json:"display_name"`
+		PlaceRank   int    `json:"place_rank"`
+		Lat         string
+		Lon         string
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	var ps []driver.Place
+	for _, r := range data {
+		lat, err := strconv.ParseFloat(r.Lat, 64)
+		if err != nil {
+			return nil, err
+		}
+		lon, err := strconv.ParseFloat(r.Lon, 64)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, driver.Place{
+			ID:       fmt.Sprintf("%s/%d", r.OSMType, r.OSMID),
+			Name:     strings.Split(r.DisplayName, ",")[0],
+			Address:  r.DisplayName,
+			Lat:      lat,
+			Lon:      lon,
+			Accuracy: rankAccuracy(r.PlaceRank),
+		})
+	}
+	return ps, nil
+}
+
+// rankAccuracy converts Nominatim's place rank, which runs from 4 for
+// countries to 30 for buildings, to an accuracy.
+func rankAccuracy(rank int) driver.Accuracy {
+	switch {
+	case rank >= 28:
+		return driver.AccuracyExact
+	case rank >= 26:
+		return driver.AccuracyStreet
+	case rank >= 13:
+		return driver.AccuracyLocality
+	case rank > 0:
+		return driver.AccuracyRegion
+	}
+	return driver.AccuracyUnknown
+}
+
+// parseHours parses common forms of OpenStreetMap opening_hours tags, like
+// "Mo-Fr 08:00-18:00; Sa 09:00-14:00" or "24/7". Rules it doesn't
+// understand, such as those for holidays, are skipped.
+func parseHours(s string) []driver.Hours {
+	s = strings.TrimSpace(s)
+	if s == "24/7" {
+		var hs []driver.Hours
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			hs = append(hs, driver.Hours{Weekday: d, Close: 24 * 60})
+		}
+		return hs
+	}
+	var hs []driver.Hours
+	for _, rule := range strings.Split(s, ";") {
+		m := regexRule.FindStringSubmatch(strings.TrimSpace(rule))
+		if m == nil {
+			continue
+		}
+		oh, _ := strconv.Atoi(m[2])
+		om, _ := strconv.Atoi(m[3])
+		ch, _ := strconv.Atoi(m[4])
+		cm, _ := strconv.Atoi(m[5])
+		open, closeAt := oh*60+om, ch*60+cm
+		if closeAt <= open {
+			closeAt += 24 * 60
+		}
+		for _, d := range parseDays(m[1]) {
+			hs = append(hs, driver.Hours{
+				Weekday: d,
+				Open:    open,
+				Close:   closeAt,
+			})
+		}
+	}
+	return hs
+}
+
+// parseDays parses days like "Mo-Fr" or "Mo,We,Fr".
+func parseDays(s string) []time.Weekday {
+	var days []time.Weekday
+	for _, part := range strings.Split(s, ",") {
+		ends := strings.Split(part, "-")
+		start, ok := weekdays[ends[0]]
+		if !ok {
+			continue
+		}
+		end := start
+		if len(ends) == 2 {
+			if end, ok = weekdays[ends[1]]; !ok {
+				continue
+			}
+		}
+		for d := start; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == end {
+				break
+			}
+		}
+	}
+	return days
+}
+
+// Close the connection.
+func (c *conn) Close() error {
+	return nil
+}
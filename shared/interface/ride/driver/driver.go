@@ -0,0 +1,77 @@
+// Package driver defines interfaces to be implemented by ride drivers as used
+// by package ride.
+package driver
+
+import (
+	"time"
+
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/jmoiron/sqlx"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Driver is the interface that must be implemented by a ride driver.
+type Driver interface {
+	// Open returns a new connection to the ride service. The router is
+	// included so drivers can add routes for OAuth callbacks, and the
+	// database connection allows drivers to store each user's OAuth
+	// tokens. The auth is a string in a driver-specific format, usually
+	// the app's client credentials.
+	Open(db *sqlx.DB, r *httprouter.Router, auth string) (Conn, error)
+}
+
+// Conn is a connection to the external ride service. Every action is taken on
+// behalf of a user who has granted Abot access through AuthURL.
+type Conn interface {
+	// AuthURL returns a link where the user can grant Abot access to their
+	// account, usually an OAuth authorization page. Drivers should request
+	// only the scopes needed to estimate, request and cancel rides.
+	AuthURL(u *dt.User) (string, error)
+
+	// Authorized reports whether the user has granted Abot access.
+	Authorized(u *dt.User) (bool, error)
+
+	// Estimate the price and pickup time of each kind of ride available
+	// between two places.
+	Estimate(u *dt.User, pickup, dropoff *Place) ([]Estimate, error)
+
+	// Request a ride between two places. The productID comes from an
+	// Estimate.
+	Request(u *dt.User, productID string, pickup, dropoff *Place) (*Ride,
+		error)
+
+	// Cancel a ride that's been requested.
+	Cancel(u *dt.User, rideID string) error
+
+	// Close the connection.
+	Close() error
+}
+
+// Place is a pickup or dropoff point. Drivers should prefer the coordinates
+// when set, then the address, and finally the name, e.g. "the airport."
+type Place struct {
+	Name    string
+	Address *dt.Address
+	Lat     float64
+	Lon     float64
+}
+
+// Estimate describes a kind of ride, like a shared or luxury ride, that's
+// available between two places.
+type Estimate struct {
+	ProductID   string
+	ProductName string
+	LowInCents  uint64
+	HighInCents uint64
+	PickupETA   time.Duration
+}
+
+// Ride is a ride that's been requested.
+type Ride struct {
+	ID           string
+	Status       string
+	DriverName   string
+	Vehicle      string
+	LicensePlate string
+	PickupETA    time.Duration
+}
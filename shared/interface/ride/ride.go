@@ -0,0 +1,112 @@
+// Package ride enables interaction with arbitrary ride services. It implements
+// a standardized interface through which Uber, Lyft and more may be supported.
+// It's up to individual drivers to add support for each of these services.
+package ride
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/ride/driver"
+	"github.com/jmoiron/sqlx"
+	"github.com/julienschmidt/httprouter"
+)
+
+var driversMu sync.RWMutex
+var drivers = make(map[string]driver.Driver)
+
+// Register makes a ride driver available by the provided name. If Register is
+// called twice with the same name or if driver is nil, it panics.
+func Register(name string, driver driver.Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("ride: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("ride: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns a sorted list of the names of the registered drivers.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	var list []string
+	for name := range drivers {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Conn is a connection to a specific ride driver.
+type Conn struct {
+	driver driver.Driver
+	conn   driver.Conn
+}
+
+// Open a connection to a registered driver.
+func Open(driverName string, db *sqlx.DB, r *httprouter.Router,
+	auth string) (*Conn, error) {
+
+	driversMu.RLock()
+	driveri, ok := drivers[driverName]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("ride: unknown driver %q (forgotten import?)",
+			driverName)
+	}
+	conn, err := driveri.Open(db, r, auth)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		driver: driveri,
+		conn:   conn,
+	}
+	return c, nil
+}
+
+// Driver returns the driver used by a connection.
+func (c *Conn) Driver() driver.Driver {
+	return c.driver
+}
+
+// AuthURL returns a link where the user can grant Abot access to their
+// account.
+func (c *Conn) AuthURL(u *dt.User) (string, error) {
+	return c.conn.AuthURL(u)
+}
+
+// Authorized reports whether the user has granted Abot access.
+func (c *Conn) Authorized(u *dt.User) (bool, error) {
+	return c.conn.Authorized(u)
+}
+
+// Estimate the rides available between two places.
+func (c *Conn) Estimate(u *dt.User, pickup,
+	dropoff *driver.Place) ([]driver.Estimate, error) {
+
+	return c.conn.Estimate(u, pickup, dropoff)
+}
+
+// Request a ride between two places on the user's behalf.
+func (c *Conn) Request(u *dt.User, productID string, pickup,
+	dropoff *driver.Place) (*driver.Ride, error) {
+
+	return c.conn.Request(u, productID, pickup, dropoff)
+}
+
+// Cancel a ride on the user's behalf.
+func (c *Conn) Cancel(u *dt.User, rideID string) error {
+	return c.conn.Cancel(u, rideID)
+}
+
+// Close the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,95 @@
+// Package bing is a search driver for the Bing Web Search API. Import it for
+// its side effects and pass a subscription key as the auth when opening a
+// connection:
+//
+//	import _ "github.com/itsabot/abot/shared/interface/search/bing"
+package bing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/itsabot/abot/shared/interface/search"
+	"github.com/itsabot/abot/shared/interface/search/driver"
+)
+
+const endpoint = "https://api.bing.microsoft.com/v7.0/search"
+
+// ErrMissingKey is returned when opening a connection without a subscription
+// key.
+var ErrMissingKey = errors.New("missing bing subscription key")
+
+type drv struct{}
+
+type conn struct {
+	key    string
+	client *http.Client
+}
+
+func init() {
+	search.Register("bing", &drv{})
+}
+
+// Open a connection to Bing. The auth is a subscription key.
+func (d *drv) Open(auth string) (driver.Conn, error) {
+	if len(auth) == 0 {
+		return nil, ErrMissingKey
+	}
+	c := &conn{
+		key:    auth,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	return c, nil
+}
+
+// Search Bing for a query.
+func (c *conn) Search(query string, limit int) ([]driver.Result, error) {
+	v := url.Values{}
+	v.Set("q", query)
+	v.Set("count", fmt.Sprint(limit))
+	v.Set("responseFilter", "Webpages")
+	req, err := http.NewRequest("GET", endpoint+"?"+v.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Ocp-Apim-Subscription-Key", c.key)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bing: unexpected status %d",
+			resp.StatusCode)
+	}
+	var data struct {
+		WebPages struct {
+			Value []struct {
+				Name    string
+				URL     string
+				Snippet string
+			}
+		}
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	var results []driver.Result
+	for _, r := range data.WebPages.Value {
+		results = append(results, driver.Result{
+			Title:   r.Name,
+			URL:     r.URL,
+			Snippet: r.Snippet,
+		})
+	}
+	return results, nil
+}
+
+// Close the connection.
+func (c *conn) Close() error {
+	return nil
+}
@@ -0,0 +1,100 @@
+// Package brave is a search driver for the Brave Search API. Import it for its
+// side effects and pass an API key as the auth when opening a connection:
+//
+//	import _ "github.com/itsabot/abot/shared/interface/search/brave"
+package brave
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/itsabot/abot/shared/interface/search"
+	"github.com/itsabot/abot/shared/interface/search/driver"
+)
+
+const endpoint = "https://api.search.brave.com/res/v1/web/search"
+
+// ErrMissingKey is returned when opening a connection without an API key.
+var ErrMissingKey = errors.New("missing brave api key")
+
+// regexTag matches the HTML tags that Brave uses to highlight matching words
+// in descriptions.
+var regexTag = regexp.MustCompile(`<[^>]+>`)
+
+type drv struct{}
+
+type conn struct {
+	key    string
+	client *http.Client
+}
+
+func init() {
+	search.Register("brave", &drv{})
+}
+
+// Open a connection to Brave. The auth is an API key.
+func (d *drv) Open(auth string) (driver.Conn, error) {
+	if len(auth) == 0 {
+		return nil, ErrMissingKey
+	}
+	c := &conn{
+		key:    auth,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	return c, nil
+}
+
+// Search Brave for a query.
+func (c *conn) Search(query string, limit int) ([]driver.Result, error) {
+	v := url.Values{}
+	v.Set("q", query)
+	v.Set("count", fmt.Sprint(limit))
+	req, err := http.NewRequest("GET", endpoint+"?"+v.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Subscription-Token", c.key)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("brave: unexpected status %d",
+			resp.StatusCode)
+	}
+	var data struct {
+		Web struct {
+			Results []struct {
+				Title       string
+				URL         string
+				Description string
+			}
+		}
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	var results []driver.Result
+	for _, r := range data.Web.Results {
+		desc := regexTag.ReplaceAllString(r.Description, "")
+		results = append(results, driver.Result{
+			Title:   html.UnescapeString(r.Title),
+			URL:     r.URL,
+			Snippet: html.UnescapeString(desc),
+		})
+	}
+	return results, nil
+}
+
+// Close the connection.
+func (c *conn) Close() error {
+	return nil
+}
@@ -0,0 +1,28 @@
+// Package driver defines interfaces to be implemented by search drivers as
+// used by package search.
+package driver
+
+// Driver is the interface that must be implemented by a search driver.
+type Driver interface {
+	// Open returns a new connection to the search service. The auth is a
+	// string in a driver-specific format, usually an API key.
+	Open(auth string) (Conn, error)
+}
+
+// Conn is a connection to the external search service.
+type Conn interface {
+	// Search for a query, returning up to limit results ordered from most
+	// to least relevant.
+	Search(query string, limit int) ([]Result, error)
+
+	// Close the connection.
+	Close() error
+}
+
+// Result is a single search result. Snippet is a plain text excerpt of the
+// page relevant to the query.
+type Result struct {
+	Title   string
+	URL     string
+	Snippet string
+}
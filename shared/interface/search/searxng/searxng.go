@@ -0,0 +1,91 @@
+// Package searxng is a search driver for SearxNG, a self-hosted metasearch
+// engine. The instance must have the JSON output format enabled. Import it for
+// its side effects and pass the instance's URL as the auth when opening a
+// connection:
+//
+//	import _ "github.com/itsabot/abot/shared/interface/search/searxng"
+package searxng
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/shared/interface/search"
+	"github.com/itsabot/abot/shared/interface/search/driver"
+)
+
+// ErrMissingURL is returned when opening a connection without an instance URL.
+var ErrMissingURL = errors.New("missing searxng instance url")
+
+type drv struct{}
+
+type conn struct {
+	base   string
+	client *http.Client
+}
+
+func init() {
+	search.Register("searxng", &drv{})
+}
+
+// Open a connection to a SearxNG instance. The auth is the instance's URL,
+// e.g. https://searx.example.com.
+func (d *drv) Open(auth string) (driver.Conn, error) {
+	if len(auth) == 0 {
+		return nil, ErrMissingURL
+	}
+	c := &conn{
+		base:   strings.TrimRight(auth, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	return c, nil
+}
+
+// Search the SearxNG instance for a query. SearxNG doesn't support limiting
+// results, so they're truncated here.
+func (c *conn) Search(query string, limit int) ([]driver.Result, error) {
+	v := url.Values{}
+	v.Set("q", query)
+	v.Set("format", "json")
+	resp, err := c.client.Get(c.base + "/search?" + v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("searxng: unexpected status %d",
+			resp.StatusCode)
+	}
+	var data struct {
+		Results []struct {
+			Title   string
+			URL     string
+			Content string
+		}
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	var results []driver.Result
+	for _, r := range data.Results {
+		if len(results) == limit {
+			break
+		}
+		results = append(results, driver.Result{
+			Title:   r.Title,
+			URL:     r.URL,
+			Snippet: r.Content,
+		})
+	}
+	return results, nil
+}
+
+// Close the connection.
+func (c *conn) Close() error {
+	return nil
+}
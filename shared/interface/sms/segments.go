@@ -0,0 +1,190 @@
+package sms
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Encodings of SMS messages.
+const (
+	// EncodingGSM7 fits 160 characters of the GSM 03.38 alphabet in a
+	// segment. Characters of its extension table, like "€" and "{,"
+	// count twice.
+	EncodingGSM7 = "GSM-7"
+
+	// EncodingUCS2 is used for messages with any other character, like
+	// emoji or Chinese, and fits 70 UTF-16 code units in a segment. Emoji
+	// outside the Basic Multilingual Plane count twice.
+	EncodingUCS2 = "UCS-2"
+)
+
+// Lengths of segments by encoding. Messages longer than a single segment are
+// sent as several, each losing a few characters to the header that joins
+// them.
+const (
+	gsm7Single = 160
+	gsm7Multi  = 153
+	ucs2Single = 70
+	ucs2Multi  = 67
+)
+
+const ellipsis = "..."
+
+// gsm7Basic is the GSM 03.38 alphabet, and gsm7Extension its extension table,
+// whose characters are sent escaped as two.
+var gsm7Basic = map[rune]struct{}{}
+var gsm7Extension = map[rune]struct{}{}
+
+func init() {
+	for _, r := range "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà" {
+		gsm7Basic[r] = struct{}{}
+	}
+	for _, r := range "\f^{}\\[~]|€" {
+		gsm7Extension[r] = struct{}{}
+	}
+}
+
+// Encoding returns the encoding a message is sent in: EncodingGSM7 if every
+// character is in the GSM alphabet, otherwise EncodingUCS2.
+func Encoding(s string) string {
+	for _, r := range s {
+		_, basic := gsm7Basic[r]
+		_, ext := gsm7Extension[r]
+		if !basic && !ext {
+			return EncodingUCS2
+		}
+	}
+	return EncodingGSM7
+}
+
+// Length returns the length of a message in its encoding: GSM-7 septets or
+// UCS-2 code units.
+func Length(s string) int {
+	return lengthIn(s, Encoding(s))
+}
+
+func lengthIn(s, enc string) int {
+	var n int
+	for _, r := range s {
+		n += runeLength(r, enc)
+	}
+	return n
+}
+
+func runeLength(r rune, enc string) int {
+	if enc == EncodingGSM7 {
+		if _, ok := gsm7Extension[r]; ok {
+			return 2
+		}
+		return 1
+	}
+	if r > 0xFFFF {
+		// A surrogate pair.
+		return 2
+	}
+	return 1
+}
+
+// limits returns the lengths of a single segment and of each of several in an
+// encoding.
+func limits(enc string) (single, multi int) {
+	if enc == EncodingGSM7 {
+		return gsm7Single, gsm7Multi
+	}
+	return ucs2Single, ucs2Multi
+}
+
+// Segments returns how many segments a message is sent, and usually billed,
+// as.
+func Segments(s string) int {
+	enc := Encoding(s)
+	n := lengthIn(s, enc)
+	single, multi := limits(enc)
+	if n <= single {
+		return 1
+	}
+	return (n + multi - 1) / multi
+}
+
+// Split splits a message into parts that each fit in a single segment, to be
+// sent as separate messages. Parts are split between words, so words and URLs
+// are only broken when longer than a segment themselves, and never within a
+// character, like an emoji. If maxParts is above 0, the message is cut off at
+// a word to fit that many parts, marking the cut with an ellipsis.
+func Split(s string, maxParts int) []string {
+	var parts []string
+	rest := strings.TrimSpace(s)
+	for len(rest) > 0 {
+		if maxParts > 0 && len(parts) == maxParts-1 {
+			parts = append(parts, Truncate(rest, 1))
+			break
+		}
+		part, n := fitPart(rest)
+		parts = append(parts, part)
+		rest = strings.TrimLeftFunc(rest[n:], unicode.IsSpace)
+	}
+	return parts
+}
+
+// fitPart returns the longest beginning of s that fits in a single segment,
+// ending between words if it can, and how many bytes of s it spans.
+func fitPart(s string) (string, int) {
+	if Segments(s) == 1 {
+		return s, len(s)
+	}
+	// A part may be sent in GSM-7 even if the message isn't, so it's
+	// grown character by character in its own encoding.
+	enc := EncodingGSM7
+	var n, length, lastBreak int
+	for n < len(s) {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if enc == EncodingGSM7 && Encoding(string(r)) == EncodingUCS2 {
+			enc = EncodingUCS2
+			length = lengthIn(s[:n], enc)
+		}
+		single, _ := limits(enc)
+		if length+runeLength(r, enc) > single {
+			break
+		}
+		length += runeLength(r, enc)
+		if unicode.IsSpace(r) && n > 0 {
+			lastBreak = n
+		}
+		n += size
+	}
+	if n < len(s) && lastBreak > 0 {
+		n = lastBreak
+	}
+	if n == 0 {
+		// Nothing fits, which only happens for an invalid limit.
+		_, n = utf8.DecodeRuneInString(s)
+	}
+	return strings.TrimRightFunc(s[:n], unicode.IsSpace), n
+}
+
+// Truncate cuts a message at the last word that fits in maxSegments segments,
+// marking the cut with an ellipsis. Messages that fit are returned unchanged.
+func Truncate(s string, maxSegments int) string {
+	if maxSegments <= 0 || Segments(s) <= maxSegments {
+		return s
+	}
+	rs := []rune(s)
+	// Find the most characters that fit with an ellipsis. Removing
+	// characters never lengthens a message, so the search is binary.
+	lo, hi := 0, len(rs)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if Segments(string(rs[:mid])+ellipsis) <= maxSegments {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	cut := string(rs[:lo])
+	if i := strings.LastIndexAny(cut, " \n"); i > 0 && lo < len(rs) &&
+		!unicode.IsSpace(rs[lo]) {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " \n.,;:") + ellipsis
+}
@@ -0,0 +1,83 @@
+package sms
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSegments(t *testing.T) {
+	tests := []struct {
+		s   string
+		enc string
+		len int
+		n   int
+	}{
+		{"Your table is booked.", EncodingGSM7, 21, 1},
+		{strings.Repeat("a", 160), EncodingGSM7, 160, 1},
+		{strings.Repeat("a", 161), EncodingGSM7, 161, 2},
+		{strings.Repeat("{", 80), EncodingGSM7, 160, 1},
+		{strings.Repeat("€", 81), EncodingGSM7, 162, 2},
+		{"See you soon 👋", EncodingUCS2, 15, 1},
+		{strings.Repeat("a", 69) + "👋", EncodingUCS2, 71, 2},
+		{strings.Repeat("é", 160), EncodingGSM7, 160, 1},
+		{strings.Repeat("ê", 70), EncodingUCS2, 70, 1},
+	}
+	for _, test := range tests {
+		if enc := Encoding(test.s); enc != test.enc {
+			t.Errorf("expected %s for %q, got %s", test.enc, test.s, enc)
+		}
+		if n := Length(test.s); n != test.len {
+			t.Errorf("expected length %d for %q, got %d", test.len,
+				test.s, n)
+		}
+		if n := Segments(test.s); n != test.n {
+			t.Errorf("expected %d segments for %q, got %d", test.n,
+				test.s, n)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	url := "https://example.com/" + strings.Repeat("x", 40)
+	s := strings.Repeat("word ", 25) + url + " " + strings.Repeat("🍕 ", 40)
+	parts := Split(s, 0)
+	if strings.Join(parts, " ") != strings.TrimSpace(s) {
+		t.Fatalf("expected parts to rejoin as the message, got %q", parts)
+	}
+	var found bool
+	for _, p := range parts {
+		if Segments(p) != 1 {
+			t.Errorf("expected %q to fit a segment", p)
+		}
+		if !utf8.ValidString(p) {
+			t.Errorf("expected %q to be valid UTF-8", p)
+		}
+		if strings.HasPrefix(p, "word") && !strings.HasSuffix(p, "word") &&
+			!strings.HasSuffix(p, url) {
+			t.Errorf("expected %q to end between words", p)
+		}
+		if strings.Contains(p, url) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected the url whole in a part, got %q", parts)
+	}
+
+	parts = Split(s, 2)
+	if len(parts) != 2 || !strings.HasSuffix(parts[1], ellipsis) {
+		t.Errorf("expected 2 parts ending in an ellipsis, got %q", parts)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	s := strings.Repeat("hello ", 60)
+	cut := Truncate(s, 2)
+	if Segments(cut) > 2 || !strings.HasSuffix(cut, "hello...") {
+		t.Errorf("expected a cut at a word in 2 segments, got %q", cut)
+	}
+	if Truncate("short", 1) != "short" {
+		t.Error("expected a message that fits to be unchanged")
+	}
+}
@@ -0,0 +1,23 @@
+// Package driver defines interfaces to be implemented by translation drivers
+// as used by package translate.
+package driver
+
+// Driver is the interface that must be implemented by a translation driver.
+type Driver interface {
+	// Open returns a new connection to the translation service. The auth
+	// is a string in a driver-specific format, usually an API key.
+	Open(auth string) (Conn, error)
+}
+
+// Conn is a connection to the external translation service. Languages are
+// identified by their ISO 639-1 codes, e.g. "en" or "es".
+type Conn interface {
+	// Detect the language of some text.
+	Detect(text string) (lang string, err error)
+
+	// Translate text from one language to another.
+	Translate(text, from, to string) (string, error)
+
+	// Close the connection.
+	Close() error
+}
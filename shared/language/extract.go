@@ -13,12 +13,12 @@ import (
 
 	"github.com/itsabot/abot/shared/datatypes"
 	"github.com/itsabot/abot/shared/helpers/address"
+	"github.com/itsabot/abot/shared/nlp"
 	"github.com/itsabot/abot/core/log"
 	"github.com/jmoiron/sqlx"
 )
 
 var regexCurrency = regexp.MustCompile(`\d+\.?\d*`)
-var regexNum = regexp.MustCompile(`\d+`)
 var regexNonWords = regexp.MustCompile(`[^\w\s]`)
 
 // ExtractCurrency returns a pointer to a string to allow a user a simple check
@@ -170,10 +170,12 @@ func ExtractAddress(db *sqlx.DB, u *dt.User, s string) (*dt.Address, bool,
 	return &a, false, nil
 }
 
-// ExtractCount returns a number from a user's message, useful in situations
-// like:
+// ExtractCount returns a number from a user's message, written in digits or
+// spelled out, useful in situations like:
 //	Ava>  How many would you like to buy?
-//	User> Order 5.
+//	User> Order five.
+//
+// Ordinals, like "third," and fractions aren't counts.
 //
 // TODO this should return an *int64 to maintain consistency with the Extract
 // API.
@@ -182,16 +184,15 @@ func ExtractCount(s string) sql.NullInt64 {
 		Int64: 0,
 		Valid: false,
 	}
-	s = regexNum.FindString(s)
-	if len(s) == 0 {
-		return n
-	}
-	val, err := strconv.ParseUint(s, 10, 64)
-	if err != nil {
-		return n
+	for _, num := range nlp.ExtractNumbers(s) {
+		if num.Ordinal || num.Value < 0 ||
+			num.Value != float64(int64(num.Value)) {
+			continue
+		}
+		n.Int64 = int64(num.Value)
+		n.Valid = true
+		break
 	}
-	n.Int64 = int64(val)
-	n.Valid = true
 	return n
 }
 
@@ -0,0 +1,178 @@
+package language
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/itsabot/abot/shared/nlp"
+)
+
+// Attributes of options that users can compare, e.g. "the cheaper one."
+const (
+	AttrPrice    = "price"
+	AttrDistance = "distance"
+	AttrTime     = "time"
+)
+
+// Option is one of a list of choices presented to a user, like a restaurant
+// or a flight. Name and Description are matched against references to the
+// option, e.g. "the Italian place," and Attrs against comparisons, e.g. "the
+// cheaper option." Attrs are keyed by AttrPrice, AttrDistance, AttrTime or
+// any other attribute a plugin compares with its own words.
+type Option struct {
+	Name        string
+	Description string
+	Attrs       map[string]float64
+}
+
+// comparatives map words comparing options to the attribute compared and
+// whether the user wants the option with the lowest value.
+var comparatives = map[string]struct {
+	Attr   string
+	Lowest bool
+}{
+	"cheaper": {AttrPrice, true}, "cheapest": {AttrPrice, true},
+	"cheap": {AttrPrice, true}, "affordable": {AttrPrice, true},
+	"expensive": {AttrPrice, false}, "pricier": {AttrPrice, false},
+	"priciest": {AttrPrice, false}, "closer": {AttrDistance, true},
+	"closest": {AttrDistance, true}, "nearer": {AttrDistance, true},
+	"nearest": {AttrDistance, true}, "near": {AttrDistance, true},
+	"farther": {AttrDistance, false}, "further": {AttrDistance, false},
+	"farthest": {AttrDistance, false}, "furthest": {AttrDistance, false},
+	"earlier": {AttrTime, true}, "earliest": {AttrTime, true},
+	"sooner": {AttrTime, true}, "soonest": {AttrTime, true},
+	"later": {AttrTime, false}, "latest": {AttrTime, false},
+}
+
+// selectionFillers are words in selection phrases that don't refer to any
+// particular option, e.g. "I'll take the one with the patio."
+var selectionFillers = map[string]struct{}{
+	"the": {}, "a": {}, "an": {}, "one": {}, "ones": {}, "option": {},
+	"place": {}, "i": {}, "ll": {}, "take": {}, "want": {}, "like": {},
+	"d": {}, "would": {}, "please": {}, "let": {}, "s": {}, "go": {},
+	"with": {}, "do": {}, "that": {}, "this": {}, "choose": {}, "pick": {},
+	"number": {}, "no": {}, "more": {}, "less": {}, "most": {}, "least": {},
+	"of": {}, "them": {}, "is": {}, "it": {}, "how": {}, "about": {},
+	"sounds": {}, "good": {}, "and": {}, "or": {}, "for": {}, "me": {},
+	"at": {}, "in": {}, "on": {}, "to": {}, "my": {},
+}
+
+// ExtractSelection returns the index of the option a user selected from a
+// list, or -1 if the reply doesn't identify exactly one. Users may select an
+// option by number or position, e.g. "2," "the second one" or "the last
+// one," by comparison, e.g. "the cheaper option," or by name, e.g. "the
+// Italian place":
+//
+//	Ava>  I found Luigi's, an Italian restaurant, and Sakura, a sushi bar.
+//	User> The Italian place.
+func ExtractSelection(s string, opts []Option) int {
+	if len(opts) == 0 {
+		return -1
+	}
+	s = strings.ToLower(s)
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	nums := nlp.ExtractNumbers(s)
+	for _, n := range nums {
+		if n.Ordinal && n.Value >= 1 && int(n.Value) <= len(opts) {
+			return int(n.Value) - 1
+		}
+	}
+	for _, w := range words {
+		if w == "last" {
+			return len(opts) - 1
+		}
+	}
+	// Match names before bare numbers, since names like "Route 66"
+	// contain them.
+	if i := matchOption(words, opts); i >= 0 {
+		return i
+	}
+	for _, n := range nums {
+		// "One" in "the cheaper one" refers to an option, not its
+		// number.
+		if n.Ordinal || n.Text == "one" && len(words) > 1 {
+			continue
+		}
+		if n.Value >= 1 && n.Value <= float64(len(opts)) &&
+			n.Value == float64(int(n.Value)) {
+			return int(n.Value) - 1
+		}
+		break
+	}
+	for i, w := range words {
+		c, ok := comparatives[w]
+		if !ok {
+			continue
+		}
+		lowest := c.Lowest
+		// "Less expensive" and "least expensive" flip "expensive."
+		if i > 0 && (words[i-1] == "less" || words[i-1] == "least") {
+			lowest = !lowest
+		}
+		if j := extremeOption(opts, c.Attr, lowest); j >= 0 {
+			return j
+		}
+	}
+	return -1
+}
+
+// matchOption returns the index of the option whose name and description
+// share the most words with a reply, or -1 if no option or several share the
+// most.
+func matchOption(words []string, opts []Option) int {
+	best, bestN, tied := -1, 0, false
+	for i, opt := range opts {
+		text := strings.ToLower(opt.Name + " " + opt.Description)
+		optWords := map[string]struct{}{}
+		for _, w := range strings.FieldsFunc(text, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			optWords[w] = struct{}{}
+		}
+		var n int
+		for _, w := range words {
+			if _, ok := selectionFillers[w]; ok {
+				continue
+			}
+			if _, ok := optWords[w]; ok {
+				n++
+			}
+		}
+		switch {
+		case n > bestN:
+			best, bestN, tied = i, n, false
+		case n == bestN && n > 0:
+			tied = true
+		}
+	}
+	if tied {
+		return -1
+	}
+	return best
+}
+
+// extremeOption returns the index of the option with the lowest or highest
+// value of an attribute. -1 is returned if any option lacks the attribute or
+// several share the extreme value.
+func extremeOption(opts []Option, attr string, lowest bool) int {
+	best, tied := -1, false
+	var bestVal float64
+	for i, opt := range opts {
+		v, ok := opt.Attrs[attr]
+		if !ok {
+			return -1
+		}
+		switch {
+		case best < 0 || (lowest && v < bestVal) || (!lowest && v > bestVal):
+			best, bestVal, tied = i, v, false
+		case v == bestVal:
+			tied = true
+		}
+	}
+	if tied {
+		return -1
+	}
+	return best
+}
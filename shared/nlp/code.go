@@ -0,0 +1,44 @@
+package nlp
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Lengths of the one-time codes ExtractCode finds, in digits.
+const (
+	minCodeLen = 4
+	maxCodeLen = 8
+)
+
+// regexCode matches runs of digits separated by single spaces or hyphens,
+// like "489 221" or "48-92-21," that aren't part of a price, time, decimal or
+// larger word.
+var regexCode = regexp.MustCompile(`(?:^|[^\w$#.:/-])(\d{1,8}(?:[ -]\d{1,8}){0,7})(?:$|[^\w%:/.-]|\.(?:\s|$))`)
+
+// ExtractCode finds a one-time code, like a verification code, that a user
+// pasted or typed into a sentence, e.g. "my code is 489 221" or "489-221."
+// The code is returned as digits alone, e.g. "489221," along with the text it
+// was found in. Sentences holding no codes, or more than one, return empty
+// strings, since which is the code can't be known.
+func ExtractCode(s string) (code, found string) {
+	// Candidates may share a boundary, like "1234 5678," so search for
+	// each after the last.
+	for i := 0; i < len(s); {
+		m := regexCode.FindStringSubmatchIndex(s[i:])
+		if m == nil {
+			break
+		}
+		text := s[i+m[2] : i+m[3]]
+		i += m[3]
+		digits := strings.NewReplacer(" ", "", "-", "").Replace(text)
+		if len(digits) < minCodeLen || len(digits) > maxCodeLen {
+			continue
+		}
+		if len(code) > 0 {
+			return "", ""
+		}
+		code, found = digits, text
+	}
+	return code, found
+}
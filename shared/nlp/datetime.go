@@ -0,0 +1,739 @@
+package nlp
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateTime is a date or time in a sentence, like "tomorrow at 5," "in 20
+// minutes," "from 3 to 5pm" or "every other Tuesday."
+type DateTime struct {
+	// Text is the expression as matched, lowercased and with numbers in
+	// digits, e.g. "in 20 minutes" for "in twenty minutes."
+	Text string
+
+	// Start is when the date or time begins, or for recurring times, the
+	// first occurrence.
+	Start time.Time
+
+	// End is set for ranges, like "from 3 to 5pm" or "next week," and is
+	// zero otherwise. Ranges of days end at midnight after the last day.
+	End time.Time
+
+	// AllDay is true when no time of day was given, like "next Tuesday,"
+	// so Start is midnight.
+	AllDay bool
+
+	// Recurrence is set for recurring times, like "every Monday at 9am."
+	Recurrence *Recurrence
+}
+
+// Frequency is the unit in which a recurring time repeats.
+type Frequency int
+
+// Frequencies of recurring times.
+const (
+	Minutely Frequency = iota + 1
+	Hourly
+	Daily
+	Weekly
+	Monthly
+	Yearly
+)
+
+// Recurrence is how often a recurring time repeats, e.g. every 2 weeks on
+// Monday and Wednesday.
+type Recurrence struct {
+	Frequency Frequency
+
+	// Interval is the number of Frequency units between occurrences, e.g.
+	// 2 for "every other week."
+	Interval int
+
+	// Weekdays are the days of the week weekly times recur on, if named,
+	// like Monday for "every Monday."
+	Weekdays []time.Weekday
+}
+
+type atomKind int
+
+const (
+	atomDay atomKind = iota + 1
+	atomClock
+	atomRelative
+	atomRecurrence
+	atomFrom
+	atomTo
+)
+
+// timeAtom is a piece of a date or time expression, like "tomorrow" or "5pm,"
+// found in a sentence before pieces are combined into DateTimes.
+type timeAtom struct {
+	kind       atomKind
+	start, end int
+
+	// date is the midnight a day begins, and days the number of days
+	// spanned, e.g. 7 for "next week." evening is set by "tonight."
+	date    time.Time
+	days    int
+	evening bool
+
+	// mins are a clock's minutes after midnight. strong clocks had "am,"
+	// "pm," minutes or a word like "at," so they're unlikely to be other
+	// numbers.
+	mins     int
+	meridiem bool
+	strong   bool
+
+	// t is a relative time.
+	t time.Time
+
+	rec Recurrence
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday,
+	"wednesday": time.Wednesday, "thursday": time.Thursday,
+	"friday": time.Friday, "saturday": time.Saturday,
+}
+
+var monthNames = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March,
+	"apr": time.April, "may": time.May, "jun": time.June,
+	"jul": time.July, "aug": time.August, "sep": time.September,
+	"oct": time.October, "nov": time.November, "dec": time.December,
+}
+
+var frequencyWords = map[string]Frequency{
+	"minute": Minutely, "min": Minutely, "hour": Hourly, "hr": Hourly,
+	"day": Daily, "night": Daily, "week": Weekly, "month": Monthly,
+	"year": Yearly, "hourly": Hourly, "daily": Daily, "nightly": Daily,
+	"weekly": Weekly, "monthly": Monthly, "yearly": Yearly,
+	"annually": Yearly,
+}
+
+const (
+	reWeekday = `(?:sun|mon|tues|wednes|thurs|fri|satur)day`
+	reMonth   = `(?:jan(?:uary)?|feb(?:ruary)?|mar(?:ch)?|apr(?:il)?|may|june?|july?|aug(?:ust)?|sep(?:t(?:ember)?)?|oct(?:ober)?|nov(?:ember)?|dec(?:ember)?)`
+	reUnit    = `(minute|min|hour|hr|day|week|month|year)s?`
+)
+
+var (
+	regexRecurUnit    = regexp.MustCompile(`\b(?:every|each)\s+(other\s+)?(?:(\d+)\s+)?(minute|hour|day|night|week|month|year)s?\b`)
+	regexRecurWeekday = regexp.MustCompile(`\b(?:every|each)\s+(other\s+)?(` + reWeekday + `s?(?:\s*(?:,|and|,\s*and)\s*` + reWeekday + `s?)*)\b`)
+	regexRecurWeek    = regexp.MustCompile(`\b(?:every|each)\s+(weekday|weekend)s?\b`)
+	regexRecurWord    = regexp.MustCompile(`\b(hourly|daily|nightly|weekly|monthly|yearly|annually)\b`)
+	regexRelIn        = regexp.MustCompile(`\bin\s+(\d+(?:\.\d+)?|an?|half an?)\s+` + reUnit + `\b`)
+	regexRelAgo       = regexp.MustCompile(`\b(\d+(?:\.\d+)?|an?)\s+` + reUnit + `\s+(from now|ago)\b`)
+	regexDayWord      = regexp.MustCompile(`\b(today|tonight|tomorrow|tmrw|yesterday)\b`)
+	regexWeekdayWord  = regexp.MustCompile(reWeekday)
+	regexWeekdayName  = regexp.MustCompile(`\b(?:(this|next|last|on)\s+)?(` + reWeekday + `)\b`)
+	regexPeriod       = regexp.MustCompile(`\b(?:(this|next|last)\s+(week|month|year|weekend)|(?:the\s+)?weekend)\b`)
+	regexMonthDay     = regexp.MustCompile(`\b(` + reMonth + `)\.?\s+(\d{1,2})(?:st|nd|rd|th)?(?:,?\s+(\d{4}))?\b`)
+	regexDayMonth     = regexp.MustCompile(`\b(\d{1,2})(?:st|nd|rd|th)?\s+(?:of\s+)?(` + reMonth + `)(?:,?\s+(\d{4}))?\b`)
+	regexISODate      = regexp.MustCompile(`\b(\d{4})-(\d{1,2})-(\d{1,2})\b`)
+	regexSlashDate    = regexp.MustCompile(`\b(\d{1,2})/(\d{1,2})(?:/(\d{2}|\d{4}))?\b`)
+	regexTimeClock    = regexp.MustCompile(`\b(?:(at|by|around)\s+)?(?:(noon|midnight)|(\d{1,2})(?::(\d{2}))?(?:\s?([ap])\.?m\b\.?|\b)(\s?o'?clock\b)?)`)
+	regexTimeFrom     = regexp.MustCompile(`\b(?:from|between)\b`)
+	regexTimeTo       = regexp.MustCompile(`\b(?:to|until|till|through|thru|and)\b|[-–]`)
+
+	// regexAtGap and regexRangeGap match what may come between the
+	// pieces of a time, as in "tomorrow at 5" and "from 3 to 5."
+	regexAtGap    = regexp.MustCompile(`^[\s,]*(?:(?:at|on|@)\s*)?$`)
+	regexRangeGap = regexp.MustCompile(`^\s*$`)
+)
+
+// ParseTimes finds the dates and times in a sentence, like "tomorrow at 5,"
+// "in 20 minutes," "next Tuesday," "May 20," "from 3 to 5pm," "Monday through
+// Friday" and "every other Monday at 9am," relative to the current time in
+// loc. They're returned in the order written.
+func ParseTimes(sent string, loc *time.Location) []DateTime {
+	return ParseTimesFrom(sent, time.Now().In(loc))
+}
+
+// ParseTimesFrom finds the dates and times in a sentence like ParseTimes,
+// relative to now.
+//
+// Days of the week refer to their next occurrence, including today, and "next
+// Tuesday" to the first after today. Times without a day refer to their next
+// occurrence. Hours without "am" or "pm" refer to the next occurrence of
+// either when no day is given, and otherwise to the afternoon from 1 to 7,
+// or after "tonight," and as written otherwise.
+func ParseTimesFrom(sent string, now time.Time) []DateTime {
+	p := &timeParser{s: strings.ToLower(NormalizeNumbers(sent)), now: now}
+	p.findAtoms()
+	var dts []DateTime
+	for i := 0; i < len(p.atoms); {
+		dt, n := p.expr(i)
+		if n == 0 {
+			i++
+			continue
+		}
+		dt.Text = p.s[p.atoms[i].start:p.atoms[i+n-1].end]
+		dts = append(dts, dt)
+		i += n
+	}
+	return dts
+}
+
+// ParseTimes sets ParsedTimes to when each of the input's DateTimes starts, in
+// loc. If it has no DateTimes yet, they're first parsed from RawSentence
+// relative to the current time in loc.
+func (si *StructuredInput) ParseTimes(loc *time.Location) {
+	if len(si.DateTimes) == 0 {
+		si.DateTimes = ParseTimes(si.RawSentence, loc)
+	}
+	si.ParsedTimes = nil
+	for _, d := range si.DateTimes {
+		si.ParsedTimes = append(si.ParsedTimes, d.Start.In(loc))
+	}
+}
+
+type timeParser struct {
+	s     string
+	now   time.Time
+	atoms []timeAtom
+}
+
+// findAtoms finds the pieces of date and time expressions in the sentence,
+// preferring the earliest and then longest where they overlap.
+func (p *timeParser) findAtoms() {
+	var as []timeAtom
+	add := func(re *regexp.Regexp, fn func(m []string, a *timeAtom) bool) {
+		for _, loc := range re.FindAllStringSubmatchIndex(p.s, -1) {
+			m := make([]string, len(loc)/2)
+			for i := range m {
+				if loc[2*i] >= 0 {
+					m[i] = p.s[loc[2*i]:loc[2*i+1]]
+				}
+			}
+			a := timeAtom{start: loc[0], end: loc[1]}
+			if fn(m, &a) {
+				as = append(as, a)
+			}
+		}
+	}
+	add(regexRecurUnit, p.recurUnit)
+	add(regexRecurWeekday, p.recurWeekday)
+	add(regexRecurWeek, p.recurWeek)
+	add(regexRecurWord, p.recurWord)
+	add(regexRelIn, p.relative)
+	add(regexRelAgo, p.relative)
+	add(regexDayWord, p.dayWord)
+	add(regexWeekdayName, p.weekday)
+	add(regexPeriod, p.period)
+	add(regexMonthDay, p.monthDay)
+	add(regexDayMonth, p.dayMonth)
+	add(regexISODate, p.isoDate)
+	add(regexSlashDate, p.slashDate)
+	add(regexTimeClock, p.clock)
+	add(regexTimeFrom, func(m []string, a *timeAtom) bool {
+		a.kind = atomFrom
+		return true
+	})
+	add(regexTimeTo, func(m []string, a *timeAtom) bool {
+		a.kind = atomTo
+		return true
+	})
+	sort.Stable(byPosition(as))
+	end := 0
+	for _, a := range as {
+		if a.start < end {
+			continue
+		}
+		p.atoms = append(p.atoms, a)
+		end = a.end
+	}
+}
+
+// byPosition sorts atoms by where they start, longest first.
+type byPosition []timeAtom
+
+func (as byPosition) Len() int      { return len(as) }
+func (as byPosition) Swap(i, j int) { as[i], as[j] = as[j], as[i] }
+func (as byPosition) Less(i, j int) bool {
+	if as[i].start != as[j].start {
+		return as[i].start < as[j].start
+	}
+	return as[i].end > as[j].end
+}
+
+func (p *timeParser) today() time.Time {
+	y, m, d := p.now.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, p.now.Location())
+}
+
+func (p *timeParser) recurUnit(m []string, a *timeAtom) bool {
+	a.kind = atomRecurrence
+	a.rec = Recurrence{Frequency: frequencyWords[m[3]], Interval: 1}
+	if len(m[2]) > 0 {
+		a.rec.Interval, _ = strconv.Atoi(m[2])
+	}
+	if len(m[1]) > 0 {
+		a.rec.Interval *= 2
+	}
+	a.evening = m[3] == "night"
+	return a.rec.Interval > 0
+}
+
+func (p *timeParser) recurWeekday(m []string, a *timeAtom) bool {
+	a.kind = atomRecurrence
+	a.rec = Recurrence{Frequency: Weekly, Interval: 1}
+	if len(m[1]) > 0 {
+		a.rec.Interval = 2
+	}
+	for _, w := range regexWeekdayWord.FindAllString(m[2], -1) {
+		a.rec.Weekdays = append(a.rec.Weekdays, weekdayNames[w])
+	}
+	return true
+}
+
+func (p *timeParser) recurWeek(m []string, a *timeAtom) bool {
+	a.kind = atomRecurrence
+	a.rec = Recurrence{Frequency: Weekly, Interval: 1}
+	if m[1] == "weekday" {
+		a.rec.Weekdays = []time.Weekday{time.Monday, time.Tuesday,
+			time.Wednesday, time.Thursday, time.Friday}
+	} else {
+		a.rec.Weekdays = []time.Weekday{time.Saturday, time.Sunday}
+	}
+	return true
+}
+
+func (p *timeParser) recurWord(m []string, a *timeAtom) bool {
+	a.kind = atomRecurrence
+	a.rec = Recurrence{Frequency: frequencyWords[m[1]], Interval: 1}
+	a.evening = m[1] == "nightly"
+	return true
+}
+
+func (p *timeParser) relative(m []string, a *timeAtom) bool {
+	a.kind = atomRelative
+	var n float64
+	switch m[1] {
+	case "a", "an":
+		n = 1
+	case "half a", "half an":
+		n = 0.5
+	default:
+		n, _ = strconv.ParseFloat(m[1], 64)
+	}
+	if len(m) > 3 && m[3] == "ago" {
+		n = -n
+	}
+	switch m[2] {
+	case "minute", "min":
+		a.t = p.now.Add(time.Duration(n * float64(time.Minute)))
+	case "hour", "hr":
+		a.t = p.now.Add(time.Duration(n * float64(time.Hour)))
+	case "day":
+		a.t = p.now.Add(time.Duration(n * 24 * float64(time.Hour)))
+	case "week":
+		a.t = p.now.Add(time.Duration(n * 7 * 24 * float64(time.Hour)))
+	case "month":
+		a.t = p.now.AddDate(0, int(n), 0)
+	case "year":
+		a.t = p.now.AddDate(int(n), 0, 0)
+	}
+	return true
+}
+
+func (p *timeParser) dayWord(m []string, a *timeAtom) bool {
+	a.kind, a.days = atomDay, 1
+	a.date = p.today()
+	switch m[1] {
+	case "tonight":
+		a.evening = true
+	case "tomorrow", "tmrw":
+		a.date = a.date.AddDate(0, 0, 1)
+	case "yesterday":
+		a.date = a.date.AddDate(0, 0, -1)
+	}
+	return true
+}
+
+func (p *timeParser) weekday(m []string, a *timeAtom) bool {
+	a.kind, a.days = atomDay, 1
+	wd := weekdayNames[m[2]]
+	diff := (int(wd) - int(p.now.Weekday()) + 7) % 7
+	switch m[1] {
+	case "next":
+		if diff == 0 {
+			diff = 7
+		}
+	case "last":
+		diff -= 7
+	}
+	a.date = p.today().AddDate(0, 0, diff)
+	return true
+}
+
+func (p *timeParser) period(m []string, a *timeAtom) bool {
+	a.kind = atomDay
+	today := p.today()
+	which, unit := m[1], m[2]
+	if len(unit) == 0 {
+		unit = "weekend"
+	}
+	offset := map[string]int{"next": 1, "last": -1}[which]
+	switch unit {
+	case "week":
+		// Weeks begin on Monday. This week begins today.
+		monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		a.date, a.days = monday.AddDate(0, 0, 7*offset), 7
+		if offset == 0 {
+			a.date = today
+			a.days = 7 - (int(today.Weekday())+6)%7
+		}
+	case "weekend":
+		// This weekend is the coming one, or the one under way. Next
+		// weekend is the coming one on a weekday, and the one after
+		// during a weekend.
+		sat := today.AddDate(0, 0, (int(time.Saturday)-
+			int(today.Weekday())+7)%7)
+		if today.Weekday() == time.Sunday {
+			sat = today.AddDate(0, 0, -1)
+		}
+		a.date, a.days = sat, 2
+		weekend := sat.Equal(today) || sat.Before(today)
+		switch {
+		case offset > 0 && weekend:
+			a.date = sat.AddDate(0, 0, 7)
+		case offset < 0:
+			a.date = sat.AddDate(0, 0, -7)
+		case offset == 0 && today.Weekday() == time.Sunday:
+			a.date, a.days = today, 1
+		}
+	case "month":
+		y, mo, _ := today.Date()
+		a.date = time.Date(y, mo+time.Month(offset), 1, 0, 0, 0, 0,
+			today.Location())
+		if offset == 0 {
+			a.date = today
+		}
+		next := time.Date(y, mo+time.Month(offset)+1, 1, 0, 0, 0, 0,
+			today.Location())
+		a.days = int(next.Sub(a.date).Hours()/24 + 0.5)
+	case "year":
+		y := today.Year()
+		a.date = time.Date(y+offset, 1, 1, 0, 0, 0, 0, today.Location())
+		if offset == 0 {
+			a.date = today
+		}
+		next := time.Date(y+offset+1, 1, 1, 0, 0, 0, 0, today.Location())
+		a.days = int(next.Sub(a.date).Hours()/24 + 0.5)
+	}
+	return true
+}
+
+func (p *timeParser) monthDay(m []string, a *timeAtom) bool {
+	return p.date(a, m[3], monthNames[m[1][:3]], m[2])
+}
+
+func (p *timeParser) dayMonth(m []string, a *timeAtom) bool {
+	return p.date(a, m[3], monthNames[m[2][:3]], m[1])
+}
+
+func (p *timeParser) isoDate(m []string, a *timeAtom) bool {
+	mo, _ := strconv.Atoi(m[2])
+	return p.date(a, m[1], time.Month(mo), m[3])
+}
+
+func (p *timeParser) slashDate(m []string, a *timeAtom) bool {
+	mo, _ := strconv.Atoi(m[1])
+	year := m[3]
+	if len(year) == 2 {
+		year = "20" + year
+	}
+	return p.date(a, year, time.Month(mo), m[2])
+}
+
+// date sets a day given its year, which may be empty for the next occurrence,
+// month and day of the month.
+func (p *timeParser) date(a *timeAtom, year string, mo time.Month,
+	day string) bool {
+
+	d, _ := strconv.Atoi(day)
+	if mo < time.January || mo > time.December || d < 1 || d > 31 {
+		return false
+	}
+	today := p.today()
+	y := today.Year()
+	if len(year) > 0 {
+		y, _ = strconv.Atoi(year)
+	}
+	a.kind, a.days = atomDay, 1
+	a.date = time.Date(y, mo, d, 0, 0, 0, 0, today.Location())
+	if a.date.Day() != d {
+		// There's no such day, e.g. February 30th.
+		return false
+	}
+	if len(year) == 0 && a.date.Before(today) {
+		a.date = a.date.AddDate(1, 0, 0)
+	}
+	return true
+}
+
+func (p *timeParser) clock(m []string, a *timeAtom) bool {
+	a.kind = atomClock
+	switch m[2] {
+	case "noon":
+		a.mins, a.meridiem, a.strong = 12*60, true, true
+		return true
+	case "midnight":
+		a.mins, a.meridiem, a.strong = 0, true, true
+		return true
+	}
+	h, _ := strconv.Atoi(m[3])
+	var min int
+	if len(m[4]) > 0 {
+		min, _ = strconv.Atoi(m[4])
+	}
+	if h > 23 || min > 59 {
+		return false
+	}
+	switch m[5] {
+	case "a":
+		if h > 12 {
+			return false
+		}
+		if h == 12 {
+			h = 0
+		}
+		a.meridiem = true
+	case "p":
+		if h > 12 {
+			return false
+		}
+		if h < 12 {
+			h += 12
+		}
+		a.meridiem = true
+	}
+	if h > 12 {
+		a.meridiem = true
+	}
+	a.mins = h*60 + min
+	a.strong = len(m[1]) > 0 || len(m[4]) > 0 || len(m[5]) > 0 ||
+		len(m[6]) > 0
+	return true
+}
+
+// gap reports whether what comes between atoms i and j matches re.
+func (p *timeParser) gap(i, j int, re *regexp.Regexp) bool {
+	if i < 0 || j >= len(p.atoms) {
+		return false
+	}
+	return re.MatchString(p.s[p.atoms[i].end:p.atoms[j].start])
+}
+
+func (p *timeParser) kind(i int) atomKind {
+	if i < 0 || i >= len(p.atoms) {
+		return 0
+	}
+	return p.atoms[i].kind
+}
+
+// expr combines the atoms beginning at i into a DateTime, returning the
+// number of atoms used, or 0 if they don't begin one.
+func (p *timeParser) expr(i int) (DateTime, int) {
+	if dt, n := p.rangeExpr(i); n > 0 {
+		return dt, n
+	}
+	a := p.atoms[i]
+	switch a.kind {
+	case atomRecurrence:
+		if p.kind(i+1) == atomClock && p.atoms[i+1].strong &&
+			p.gap(i, i+1, regexAtGap) {
+			return p.recurrence(a, &p.atoms[i+1]), 2
+		}
+		return p.recurrence(a, nil), 1
+	case atomRelative:
+		return DateTime{Start: a.t}, 1
+	case atomDay:
+		if p.kind(i+1) == atomClock && p.atoms[i+1].strong &&
+			a.days == 1 && p.gap(i, i+1, regexAtGap) {
+			return DateTime{Start: p.clockOn(a.date, p.atoms[i+1],
+				a.evening)}, 2
+		}
+		return p.day(a), 1
+	case atomClock:
+		if !a.strong {
+			return DateTime{}, 0
+		}
+		if p.kind(i+1) == atomRecurrence && p.gap(i, i+1, regexAtGap) {
+			return p.recurrence(p.atoms[i+1], &a), 2
+		}
+		if p.kind(i+1) == atomDay && p.atoms[i+1].days == 1 &&
+			p.gap(i, i+1, regexAtGap) {
+			d := p.atoms[i+1]
+			return DateTime{Start: p.clockOn(d.date, a, d.evening)}, 2
+		}
+		return DateTime{Start: p.nextClock(a)}, 1
+	}
+	return DateTime{}, 0
+}
+
+// rangeExpr combines the atoms beginning at i into a range, like "tomorrow
+// from 3 to 5pm" or "Monday through Friday," returning the number of atoms
+// used, or 0 if they don't begin one.
+func (p *timeParser) rangeExpr(i int) (DateTime, int) {
+	j := i
+	var day *timeAtom
+	if p.kind(j) == atomDay && p.atoms[j].days == 1 &&
+		p.kind(j+1) == atomFrom && p.gap(j, j+1, regexAtGap) {
+		day = &p.atoms[j]
+		j++
+	}
+	from := p.kind(j) == atomFrom
+	if from {
+		if !p.gap(j, j+1, regexRangeGap) {
+			return DateTime{}, 0
+		}
+		j++
+	}
+	k := p.kind(j)
+	if k != atomClock && k != atomDay || p.kind(j+1) != atomTo ||
+		p.kind(j+2) != k || !p.gap(j, j+1, regexRangeGap) ||
+		!p.gap(j+1, j+2, regexRangeGap) {
+		return DateTime{}, 0
+	}
+	a, b := p.atoms[j], p.atoms[j+2]
+	n := j + 3 - i
+	if k == atomDay {
+		if !from && (p.s[p.atoms[j+1].start:p.atoms[j+1].end] == "and") ||
+			a.days != 1 || b.days != 1 || day != nil {
+			return DateTime{}, 0
+		}
+		end := b.date
+		if end.Before(a.date) {
+			end = end.AddDate(0, 0, 7)
+		}
+		return DateTime{Start: a.date, End: end.AddDate(0, 0, 1),
+			AllDay: true}, n
+	}
+	// Bare numbers, as in "3-5," are only times with "from" or "pm."
+	if !from && !b.meridiem && !a.strong {
+		return DateTime{}, 0
+	}
+	if day == nil && p.kind(i+n) == atomDay && p.atoms[i+n].days == 1 &&
+		p.gap(i+n-1, i+n, regexAtGap) {
+		day = &p.atoms[i+n]
+		n++
+	}
+	var date time.Time
+	var evening bool
+	if day != nil {
+		date, evening = day.date, day.evening
+	}
+	// "3 to 5pm" means 3pm, and "9 to 5" means 5pm.
+	if !a.meridiem && b.meridiem && b.mins >= 12*60 && a.mins+12*60 <= b.mins {
+		a.mins += 12 * 60
+		a.meridiem = true
+	}
+	var start time.Time
+	if day != nil {
+		start = p.clockOn(date, a, evening)
+	} else {
+		start = p.nextClock(a)
+		date = start
+		y, mo, d := date.Date()
+		date = time.Date(y, mo, d, 0, 0, 0, 0, date.Location())
+	}
+	end := p.clockOn(date, b, evening || start.Hour() >= 12)
+	for !end.After(start) {
+		if !b.meridiem && end.Add(12*time.Hour).After(start) {
+			end = end.Add(12 * time.Hour)
+			continue
+		}
+		end = end.AddDate(0, 0, 1)
+	}
+	return DateTime{Start: start, End: end}, n
+}
+
+// day returns the DateTime of a day or range of days.
+func (p *timeParser) day(a timeAtom) DateTime {
+	dt := DateTime{Start: a.date, AllDay: true}
+	if a.days > 1 {
+		dt.End = a.date.AddDate(0, 0, a.days)
+	}
+	return dt
+}
+
+// clockOn returns the time of a clock on a day.
+func (p *timeParser) clockOn(date time.Time, c timeAtom,
+	evening bool) time.Time {
+
+	mins := c.mins
+	if !c.meridiem && mins < 12*60 && (evening ||
+		mins >= 60 && mins < 8*60) {
+		mins += 12 * 60
+	}
+	return date.Add(time.Duration(mins) * time.Minute)
+}
+
+// nextClock returns the next occurrence of a clock. Without "am" or "pm," it's
+// the next occurrence of either.
+func (p *timeParser) nextClock(c timeAtom) time.Time {
+	today := p.today()
+	t := today.Add(time.Duration(c.mins) * time.Minute)
+	if !c.meridiem && c.mins < 12*60 {
+		if !t.After(p.now) {
+			t = t.Add(12 * time.Hour)
+		}
+		if !t.After(p.now) {
+			t = today.AddDate(0, 0, 1).Add(time.Duration(c.mins) *
+				time.Minute)
+		}
+		return t
+	}
+	if !t.After(p.now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// recurrence returns a recurring DateTime beginning at its first occurrence
+// after now, at a clock if one was given.
+func (p *timeParser) recurrence(a timeAtom, c *timeAtom) DateTime {
+	rec := a.rec
+	dt := DateTime{Recurrence: &rec}
+	today := p.today()
+	switch {
+	case len(rec.Weekdays) > 0:
+		for d := 0; d <= 7; d++ {
+			date := today.AddDate(0, 0, d)
+			var ok bool
+			for _, wd := range rec.Weekdays {
+				ok = ok || date.Weekday() == wd
+			}
+			if !ok {
+				continue
+			}
+			if c == nil {
+				dt.Start, dt.AllDay = date, true
+				return dt
+			}
+			if t := p.clockOn(date, *c, a.evening); t.After(p.now) {
+				dt.Start = t
+				return dt
+			}
+		}
+	case rec.Frequency == Minutely:
+		dt.Start = p.now.Add(time.Duration(rec.Interval) * time.Minute)
+	case rec.Frequency == Hourly:
+		dt.Start = p.now.Add(time.Duration(rec.Interval) * time.Hour)
+	case c != nil:
+		dt.Start = p.clockOn(today, *c, a.evening)
+		if !dt.Start.After(p.now) {
+			dt.Start = p.clockOn(today.AddDate(0, 0, 1), *c,
+				a.evening)
+		}
+	default:
+		dt.Start, dt.AllDay = today, true
+	}
+	return dt
+}
@@ -0,0 +1,69 @@
+package nlp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTimesFrom(t *testing.T) {
+	// A Wednesday afternoon.
+	now := time.Date(2016, 5, 18, 14, 30, 0, 0, time.UTC)
+	at := func(d, h, m int) time.Time {
+		return time.Date(2016, 5, d, h, m, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		sent string
+		exp  []DateTime
+	}{
+		{"remind me in twenty minutes", []DateTime{
+			{Text: "in 20 minutes", Start: at(18, 14, 50)}}},
+		{"tomorrow at 5", []DateTime{
+			{Text: "tomorrow at 5", Start: at(19, 17, 0)}}},
+		{"next Tuesday", []DateTime{
+			{Text: "next tuesday", Start: at(24, 0, 0), AllDay: true}}},
+		{"from 3 to 5pm", []DateTime{
+			{Text: "from 3 to 5pm", Start: at(18, 15, 0),
+				End: at(18, 17, 0)}}},
+		{"Monday through Friday", []DateTime{
+			{Text: "monday through friday", Start: at(23, 0, 0),
+				End: at(28, 0, 0), AllDay: true}}},
+		{"every other Monday at 9am", []DateTime{
+			{Text: "every other monday at 9am", Start: at(23, 9, 0),
+				Recurrence: &Recurrence{Frequency: Weekly,
+					Interval: 2,
+					Weekdays: []time.Weekday{time.Monday}}}}},
+		{"May 20", []DateTime{
+			{Text: "may 20", Start: at(20, 0, 0), AllDay: true}}},
+		{"order 3 pizzas", nil},
+	}
+	for _, test := range tests {
+		got := ParseTimesFrom(test.sent, now)
+		if !reflect.DeepEqual(test.exp, got) {
+			t.Errorf("%q: expected %+v, got %+v", test.sent, test.exp,
+				got)
+		}
+	}
+}
+
+func TestStructuredInputParseTimes(t *testing.T) {
+	loc := time.FixedZone("PDT", -7*60*60)
+	start := time.Date(2016, 5, 20, 15, 0, 0, 0, time.UTC)
+	si := &StructuredInput{DateTimes: []DateTime{
+		{Text: "from 3 to 5pm", Start: start}}}
+	si.ParseTimes(loc)
+	if len(si.ParsedTimes) != 1 || !si.ParsedTimes[0].Equal(start) ||
+		si.ParsedTimes[0].Location() != loc {
+		t.Fatal("expected", start.In(loc), "got", si.ParsedTimes)
+	}
+	si = &StructuredInput{RawSentence: "remind me in 20 minutes"}
+	before := time.Now()
+	si.ParseTimes(loc)
+	if len(si.DateTimes) != 1 || len(si.ParsedTimes) != 1 {
+		t.Fatal("expected one time, got", si.DateTimes)
+	}
+	if d := si.ParsedTimes[0].Sub(before); d < 20*time.Minute ||
+		d > 21*time.Minute {
+		t.Fatal("expected a time in 20 minutes, got", si.ParsedTimes[0])
+	}
+}
@@ -0,0 +1,145 @@
+package nlp
+
+import "strings"
+
+// Types of entities. Packages may register entities of their own types, like
+// "restaurant" or "wine."
+const (
+	EntityPerson   = "person"
+	EntityBusiness = "business"
+	EntityProduct  = "product"
+)
+
+// Entity is a named thing in a message, like "John Smith" or "Chateau
+// Margaux," however many words its name has. People and businesses are
+// Actors, and everything else Objects.
+type Entity struct {
+	// Name is the entity's name as it's known, e.g. "John Smith," which
+	// may be capitalized differently than in the message.
+	Name  string
+	Type  string
+	Class SIT
+
+	// Start and Len are the tokens of the message naming the entity.
+	Start int
+	Len   int
+}
+
+// gazetteerEntry is an entity's name tokenized to match a message's tokens.
+type gazetteerEntry struct {
+	words  []string
+	entity Entity
+}
+
+// Gazetteer is a list of known entities, like a business's products or the
+// restaurants in a city, for finding them in messages. See FindEntities.
+type Gazetteer struct {
+	// entries are indexed by their first word, lowercased.
+	entries map[string][]gazetteerEntry
+}
+
+// NewGazetteer returns an empty Gazetteer.
+func NewGazetteer() *Gazetteer {
+	return &Gazetteer{entries: map[string][]gazetteerEntry{}}
+}
+
+// Add an entity to the gazetteer by name. Its class is PersonI for people and
+// businesses, and ObjectI otherwise. Names are matched ignoring case, and
+// adding a name twice replaces the earlier entity.
+func (g *Gazetteer) Add(name, typ string) {
+	var words []string
+	for _, w := range TokenizeSentence(name) {
+		words = append(words, strings.ToLower(w))
+	}
+	if len(words) == 0 {
+		return
+	}
+	class := ObjectI
+	if typ == EntityPerson || typ == EntityBusiness {
+		class = PersonI
+	}
+	e := gazetteerEntry{
+		words:  words,
+		entity: Entity{Name: name, Type: typ, Class: class},
+	}
+	es := g.entries[words[0]]
+	for i := range es {
+		if strings.Join(es[i].words, " ") == strings.Join(words, " ") {
+			es[i] = e
+			return
+		}
+	}
+	g.entries[words[0]] = append(es, e)
+}
+
+// Len returns how many entities the gazetteer lists.
+func (g *Gazetteer) Len() int {
+	var n int
+	for _, es := range g.entries {
+		n += len(es)
+	}
+	return n
+}
+
+// longest returns the longest entity that tokens begin with, or nil if none
+// match.
+func (g *Gazetteer) longest(tokens []string) *gazetteerEntry {
+	var longest *gazetteerEntry
+	es := g.entries[strings.ToLower(tokens[0])]
+	for i := range es {
+		if len(es[i].words) > len(tokens) ||
+			(longest != nil && len(es[i].words) <= len(longest.words)) {
+			continue
+		}
+		match := true
+		for j, w := range es[i].words {
+			if strings.ToLower(tokens[j]) != w {
+				match = false
+				break
+			}
+		}
+		if match {
+			longest = &es[i]
+		}
+	}
+	return longest
+}
+
+// FindEntities finds the entities named in a tokenized sentence, preferring
+// the longest name in the gazetteer, which may be nil. Runs of two or more
+// capitalized words other than the first, like "John Smith" in "Text John
+// Smith," are taken for people's names, as by ExtractParticipants. Entities
+// are returned in the order named.
+func FindEntities(tokens []string, g *Gazetteer) []Entity {
+	var es []Entity
+	for i := 0; i < len(tokens); {
+		if g != nil {
+			if e := g.longest(tokens[i:]); e != nil {
+				ent := e.entity
+				ent.Start, ent.Len = i, len(e.words)
+				es = append(es, ent)
+				i += len(e.words)
+				continue
+			}
+		}
+		n := 0
+		if i > 0 {
+			for i+n < len(tokens) && isName(tokens[i+n]) {
+				n++
+			}
+		}
+		if n < 2 {
+			i++
+			continue
+		}
+		es = append(es, Entity{
+			Name:  strings.Join(tokens[i:i+n], " "),
+			Type:  EntityPerson,
+			Class: PersonI,
+			Start: i,
+			Len:   n,
+		})
+		i += n
+	}
+	return es
+}
@@ -0,0 +1,45 @@
+package nlp
+
+import "testing"
+
+func TestFindEntities(t *testing.T) {
+	g := NewGazetteer()
+	g.Add("Chateau Margaux", "wine")
+	g.Add("Chateau Margaux 2010", "wine")
+	g.Add("Blue Bottle", EntityBusiness)
+	tests := []struct {
+		sentence string
+		exp      []Entity
+	}{
+		{"Text John Smith", []Entity{
+			{Name: "John Smith", Type: EntityPerson, Class: PersonI,
+				Start: 1, Len: 2},
+		}},
+		{"order a bottle of chateau margaux 2010", []Entity{
+			{Name: "Chateau Margaux 2010", Type: "wine", Class: ObjectI,
+				Start: 4, Len: 3},
+		}},
+		{"meet at Blue Bottle with Sarah Jane Lee", []Entity{
+			{Name: "Blue Bottle", Type: EntityBusiness, Class: PersonI,
+				Start: 2, Len: 2},
+			{Name: "Sarah Jane Lee", Type: EntityPerson, Class: PersonI,
+				Start: 5, Len: 3},
+		}},
+		{"Text John", nil},
+		{"John Smith called", nil},
+	}
+	for _, test := range tests {
+		es := FindEntities(TokenizeSentence(test.sentence), g)
+		if len(es) != len(test.exp) {
+			t.Errorf("expected %d entities in %q, got %+v",
+				len(test.exp), test.sentence, es)
+			continue
+		}
+		for i, e := range es {
+			if e != test.exp[i] {
+				t.Errorf("expected %+v in %q, got %+v", test.exp[i],
+					test.sentence, e)
+			}
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package nlp
+
+import "regexp"
+
+// regexHere matches phrases referring to where the user is, like "near me,"
+// "around here" or "the closest pharmacy."
+var regexHere = regexp.MustCompile(`(?i)\b(?:(?:near|around|close to|by) (?:me|here|us)|near ?by|close ?by|nearest|closest|in (?:the|this) area|in my (?:area|neighbou?rhood)|where (?:i|we) (?:am|are)|my (?:current )?location)\b`)
+
+// ExtractHere finds a phrase referring to the user's location, like "near
+// me," "around here" or "the nearest," so a plugin knows to search near
+// them. The phrase is returned with whether one was found.
+func ExtractHere(s string) (string, bool) {
+	m := regexHere.FindString(s)
+	return m, len(m) > 0
+}
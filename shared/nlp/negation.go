@@ -0,0 +1,93 @@
+package nlp
+
+import "strings"
+
+// negationCues begin a negation's scope, as in "don't order the pizza" or
+// "a burger without onions." Contractions like "don't" are tokenized as "don,"
+// "'" and "t," so a "t" after an apostrophe is a cue as well.
+var negationCues = map[string]struct{}{
+	"not": {}, "no": {}, "never": {}, "without": {}, "nor": {},
+	"neither": {}, "cannot": {}, "dont": {}, "doesnt": {}, "didnt": {},
+	"wont": {}, "cant": {}, "shouldnt": {}, "isnt": {}, "arent": {},
+}
+
+// negationEnds end a negation's scope, as in "don't order pizza, order
+// pasta" or "not the pizza but the pasta."
+var negationEnds = map[string]struct{}{
+	",": {}, ".": {}, ";": {}, ":": {}, "!": {}, "?": {}, "but": {},
+	"instead": {}, "rather": {}, "then": {},
+}
+
+// NegatedTokens reports for each token whether it's in the scope of a
+// negation, which runs from a cue like "don't," "never" or "without" to the
+// end of the clause. In "don't order the pizza, order pasta," "order the
+// pizza" is negated, while "order pasta" isn't.
+func NegatedTokens(tokens []string) []bool {
+	negated := make([]bool, len(tokens))
+	var in bool
+	for i, t := range tokens {
+		t = strings.ToLower(t)
+		if _, ok := negationEnds[t]; ok {
+			in = false
+			continue
+		}
+		if _, ok := negationCues[t]; ok {
+			in = true
+			continue
+		}
+		if t == "t" && i > 0 && tokens[i-1] == "'" {
+			in = true
+			continue
+		}
+		negated[i] = in
+	}
+	return negated
+}
+
+// MarkNegations sets Negated on the classified words of a tokenized sentence
+// that are in the scope of a negation. Words are matched to tokens in the
+// order written, so in "don't order pizza, order pasta" only the first
+// "order" is negated. Words not found among the tokens, like those of learned
+// routes, are left alone.
+func MarkNegations(wc []WordClass, tokens []string) {
+	negated := NegatedTokens(tokens)
+	seen := map[string][]bool{}
+	for i, t := range tokens {
+		t = strings.ToLower(t)
+		seen[t] = append(seen[t], negated[i])
+	}
+	// Commands and objects are classified from the same token, so each
+	// class of a word takes its next occurrence separately.
+	next := map[SIT]map[string]int{}
+	for i := range wc {
+		w := &wc[i]
+		if next[w.Class] == nil {
+			next[w.Class] = map[string]int{}
+		}
+		occ := seen[strings.ToLower(w.Word)]
+		if len(occ) == 0 {
+			continue
+		}
+		// A word may be classified more than once, e.g. by a learned
+		// route and the dictionaries, so extra classes take the last
+		// occurrence.
+		n := next[w.Class][w.Word]
+		if n >= len(occ) {
+			n = len(occ) - 1
+		}
+		w.Negated = occ[n]
+		next[w.Class][w.Word] = n + 1
+	}
+}
+
+// Negated reports whether a command or object of the StructuredInput was
+// negated by the user, as "order" is in "don't order the pizza," so plugins
+// don't do the opposite of what was asked.
+func (si *StructuredInput) Negated(word string) bool {
+	for _, w := range si.Classes {
+		if w.Negated && w.Word == word {
+			return true
+		}
+	}
+	return false
+}
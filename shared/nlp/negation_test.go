@@ -0,0 +1,22 @@
+package nlp
+
+import "testing"
+
+func TestMarkNegations(t *testing.T) {
+	tokens := TokenizeSentence("Don't order the pizza, order pasta without onions")
+	wc := []WordClass{
+		{Word: "order", Class: CommandI},
+		{Word: "pizza", Class: ObjectI},
+		{Word: "order", Class: CommandI},
+		{Word: "pasta", Class: ObjectI},
+		{Word: "onions", Class: ObjectI},
+	}
+	MarkNegations(wc, tokens)
+	exp := []bool{true, true, false, false, true}
+	for i, w := range wc {
+		if w.Negated != exp[i] {
+			t.Errorf("expected %s %d negated=%t, got %t", w.Word, i,
+				exp[i], w.Negated)
+		}
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"errors"
 	"regexp"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/dchest/stemmer/porter2"
 	"github.com/itsabot/abot/core/log"
@@ -15,52 +17,204 @@ import (
 
 // StructuredInput is generated by Ava and sent to plugins as a helper tool.
 // Additional fields should be added, covering Times, Places, etc. to
-// make plugin development even easier. Note that right now People is unused.
+// make plugin development even easier.
 type StructuredInput struct {
+	// RawSentence is the sentence as normalized: what the user wrote,
+	// with their aliases expanded and any one-time code removed.
+	// NormalizedSentence is the same in lowercase, with typos fixed,
+	// contractions expanded, quotes straightened and emoji stripped. See
+	// NormalizeTokens.
+	RawSentence        string
+	NormalizedSentence string
+
 	Commands StringSlice
 	Objects  StringSlice
 
-	// TODO
-	// People   StringSlice
-	// Places   StringSlice
-	// Times    []time.Time
+	// Actors are the people and businesses the message names, like "john
+	// smith" in "text John Smith," each a single entry however many words
+	// its name has. Entities are everything named, including the products
+	// and the like in Objects, with their types. See FindEntities.
+	Actors   StringSlice
+	Entities []Entity
+
+	// Classes are the words in Commands, Objects and Actors with the
+	// classifier's confidence in each. See Confidence.
+	Classes []WordClass
+
+	// FromImage is true when some of the input was read from a photo the
+	// user sent rather than typed, so it may contain OCR mistakes.
+	FromImage bool
+
+	// Participants are the people taking part in the request, like the
+	// user ("I," "me") and anyone they mention ("Bob," "my sister").
+	Participants []Participant
+
+	// OnlyTime is true when the message holds nothing but a time, like
+	// "actually make it 7pm," which amends the conversation in progress
+	// or the event the user most recently set a time for. Times holds the
+	// possible times meant, e.g. both 7AM and 7PM for "make it 7."
+	OnlyTime bool
+	Times    []time.Time
+
+	// Code is a one-time code, like "489221," that the user sent while a
+	// plugin awaited one. It's removed from the sentence, so it's neither
+	// classified nor saved.
+	Code string
+
+	// Numbers are the numbers in the message, whether written in digits or
+	// spelled out, like "twenty-three" or "a dozen," in the order written.
+	Numbers []Number
+
+	// References are the pronouns in the message that refer back to
+	// something mentioned earlier in the conversation, like "it" or
+	// "there." See dt.ContextStore.
+	References []Reference
+
+	// DateTimes are the dates and times anywhere in the message, like
+	// "tomorrow at 5," "from 3 to 5pm" or "every other Monday," in the
+	// order written. See ParseTimes.
+	DateTimes []DateTime
+
+	// ParsedTimes are when each of the DateTimes starts. See
+	// StructuredInput.ParseTimes.
+	ParsedTimes []time.Time
+
+	// Places are the names of places in the message, like "Chicago," and
+	// ParsedPlaces those of them that geocoded to a single place. See
+	// ExtractPlaces.
+	Places       StringSlice
+	ParsedPlaces []Place
+
+	// Quantities are the amounts in the message, like "two dozen," "3
+	// lbs" or "$40," in the order written. See ExtractQuantities.
+	Quantities []Quantity
+
+	// Sentiment is how positive the message is, from -1, very negative,
+	// like "this is useless," to 1, very positive, like "thanks, that's
+	// perfect." Plugins can apologize to frustrated users or escalate to
+	// a person when it's low. See core.SetSentimentAnalyzer.
+	Sentiment float64
 }
 
 // SIT is a Structured Input Type. It corresponds to either a Command or an
 // Object with additional Structured Input Types to be added later.
 type SIT int
 
-// TokenizeSentence returns a sentence broken into tokens. Tokens are individual
-// words as well as punctuation. For example, "Hi! How are you?" becomes
-// []string{"Hi", "!", "How", "are", "you", "?"}
-func TokenizeSentence(sent string) []string {
-	tokens := []string{}
-	for _, w := range strings.Fields(sent) {
-		found := []int{}
-		for i, r := range w {
-			switch r {
-			case '\'', '"', ',', '.', ':', ';', '!', '?':
-				found = append(found, i)
-			}
-		}
-		if len(found) == 0 {
-			tokens = append(tokens, w)
-			continue
+// Structured Input Types of classified words. People and businesses are
+// classified as Actors when named. See FindEntities. Places and times are
+// only classified when referred to by pronouns. See Pronouns. Quantities are
+// never classified, but extracted into Quantities.
+const (
+	CommandI SIT = iota + 1
+	PersonI
+	ObjectI
+	PlaceI
+	TimeI
+	QuantityI
+)
+
+// ErrInvalidClass is returned when adding a word classified as anything but a
+// Command, an Object or an Actor to a StructuredInput.
+var ErrInvalidClass = errors.New("invalid class")
+
+// WordClass is a word classified as a Command or an Object. Confidence is how
+// sure the classifier is, from 0 to 1. Negated is true when the user negated
+// the word, as in "don't order the pizza." See MarkNegations.
+type WordClass struct {
+	Word       string
+	Class      SIT
+	Confidence float64
+	Negated    bool
+}
+
+// Add classified words to a StructuredInput, appending each to its Commands,
+// Objects or Actors and keeping its confidence in Classes.
+func (si *StructuredInput) Add(wc []WordClass) error {
+	for _, w := range wc {
+		switch w.Class {
+		case CommandI:
+			si.Commands = append(si.Commands, w.Word)
+		case ObjectI:
+			si.Objects = append(si.Objects, w.Word)
+		case PersonI:
+			si.Actors = append(si.Actors, w.Word)
+		default:
+			return ErrInvalidClass
 		}
-		for i, j := range found {
-			if j > 0 {
-				tokens = append(tokens, w[:j])
-			}
-			tokens = append(tokens, string(w[j]))
-			if i+1 == len(found) {
-				tokens = append(tokens, w[j+1:])
-			}
+		si.Classes = append(si.Classes, w)
+	}
+	return nil
+}
+
+// Confidence returns how sure the classifier is of the StructuredInput's words
+// of a class, e.g. si.Confidence(CommandI), from 0 to 1. It's the confidence
+// of the class's most confident word, since one is enough to route the
+// message, or 0 if there are none. Plugins may ask a clarifying question
+// rather than guess when it's low.
+func (si *StructuredInput) Confidence(class SIT) float64 {
+	var c float64
+	for _, w := range si.Classes {
+		if w.Class == class && w.Confidence > c {
+			c = w.Confidence
 		}
 	}
+	return c
+}
+
+// TokenizeSentence returns a sentence broken into tokens by the Tokenizer set
+// with SetTokenizer, a UnicodeTokenizer by default. Tokens are individual
+// words as well as punctuation. For example, "Hi! How are you?" becomes
+// []string{"Hi", "!", "How", "are", "you", "?"}
+func TokenizeSentence(sent string) []string {
+	tokens := TokenTexts(SentenceTokens(sent))
 	log.Debug("found tokens", tokens)
 	return tokens
 }
 
+// SentenceTokens returns a sentence broken into tokens like TokenizeSentence,
+// along with their offsets in the sentence, so words classified from them can
+// be mapped back to what the user wrote.
+func SentenceTokens(sent string) []Token {
+	tokenizerMu.RLock()
+	t := tokenizer
+	tokenizerMu.RUnlock()
+	return t.Tokenize(sent)
+}
+
+// TokenTexts returns the text of each token.
+func TokenTexts(ts []Token) []string {
+	texts := make([]string, len(ts))
+	for i, t := range ts {
+		texts[i] = t.Text
+	}
+	return texts
+}
+
+// questionWords begin sentences that ask questions, e.g. "Who wrote Moby
+// Dick?" or "Is it raining?"
+var questionWords = map[string]struct{}{
+	"who": struct{}{}, "what": struct{}{}, "when": struct{}{},
+	"where": struct{}{}, "why": struct{}{}, "how": struct{}{},
+	"which": struct{}{}, "whose": struct{}{}, "is": struct{}{},
+	"are": struct{}{}, "was": struct{}{}, "were": struct{}{},
+	"do": struct{}{}, "does": struct{}{}, "did": struct{}{},
+	"can": struct{}{}, "could": struct{}{}, "will": struct{}{},
+	"would": struct{}{}, "should": struct{}{},
+}
+
+// IsQuestion determines whether tokens form a question, either by ending in a
+// question mark or by beginning with a question word like "who" or "is".
+func IsQuestion(tokens []string) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	if tokens[len(tokens)-1] == "?" {
+		return true
+	}
+	_, ok := questionWords[strings.ToLower(tokens[0])]
+	return ok
+}
+
 // StemTokens returns the porter2 (snowball) stems for each token passed into
 // it.
 func StemTokens(tokens []string) []string {
@@ -114,10 +268,13 @@ func (s StringSlice) Value() (driver.Value, error) {
 	// string escapes.
 	// \ => \\\
 	// " => \"
+	// Quote into a new slice, since the receiver shares its backing
+	// array with the caller's.
+	quoted := make([]string, len(s))
 	for i, elem := range s {
-		s[i] = `"` + strings.Replace(strings.Replace(elem, `\`, `\\\`, -1), `"`, `\"`, -1) + `"`
+		quoted[i] = `"` + strings.Replace(strings.Replace(elem, `\`, `\\\`, -1), `"`, `\"`, -1) + `"`
 	}
-	return "{" + strings.Join(s, ",") + "}", nil
+	return "{" + strings.Join(quoted, ",") + "}", nil
 }
 
 // Last safely returns the last item in a StringSlice, which is most often the
@@ -165,34 +322,58 @@ func (s StringSlice) Map() map[string]struct{} {
 	return m
 }
 
-/*
-// TODO with addContext
-const (
-	CommandI SIT = iota + 1
-	PersonI
-	ObjectI
-)
+// Pronouns are the pronouns that refer back to something mentioned earlier in
+// the conversation, by the type of thing they refer to. For example, when a
+// user says "buy it," "it" most likely refers to the last object discussed.
+// Pronouns for the user, like "me," are found by ExtractParticipants instead.
+var Pronouns = map[string]SIT{
+	"him":   PersonI,
+	"her":   PersonI,
+	"it":    ObjectI,
+	"that":  ObjectI,
+	"them":  ObjectI,
+	"those": ObjectI,
+	"there": PlaceI,
+	"then":  TimeI,
+}
+
+// Reference is a pronoun in a message and what it refers to.
+type Reference struct {
+	Pronoun string
+	Class   SIT
 
-// Pronouns converts pronouns to the type of object it represents. This will be
-// useful for adding context into user messages. For example, when a user says,
-// "buy that", Ava should know "that" refers to an Object and is most likely a
-// reference to the most recent object discussed.
-var Pronouns map[string]SIT = map[string]SIT{
-	"me":   PersonI,
-	"us":   PersonI,
-	"you":  PersonI,
-	"him":  PersonI,
-	"her":  PersonI,
-	"them": PersonI,
-	"it":   ObjectI,
-	"that": ObjectI,
-
-	// Ultimately Place and Time would be nice-to-have in a structured
-	// input, but they don't outweigh the cost of training a full NER on
-	// each new plugin at this point. Additional thought should be given as
-	// to how this can be enabled more simply than requiring training an ML
-	// plugin.
-	// "there": PlaceI,
-	// "then":  TimeI,
-}
-*/
+	// Entity is what the pronoun refers to, e.g. "pizza" for "it," once
+	// resolved. Times are formatted as RFC 3339. It's empty if nothing of
+	// the pronoun's class was mentioned recently.
+	Entity string
+}
+
+// ExtractReferences finds the pronouns in a tokenized sentence that refer back
+// to something mentioned earlier, in the order written. "That" and "those"
+// before another word are taken to be determiners, as in "that restaurant,"
+// rather than pronouns.
+func ExtractReferences(tokens []string) []Reference {
+	var refs []Reference
+	for i, t := range tokens {
+		lower := strings.ToLower(t)
+		class, ok := Pronouns[lower]
+		if !ok {
+			continue
+		}
+		if (lower == "that" || lower == "those") && i+1 < len(tokens) &&
+			isWordToken(tokens[i+1]) {
+			continue
+		}
+		refs = append(refs, Reference{Pronoun: lower, Class: class})
+	}
+	return refs
+}
+
+func isWordToken(t string) bool {
+	for _, r := range t {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package nlp
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// typos are common misspellings and shorthand, by the words meant. Words that
+// are also correctly spelled words, like "ill" or "wont," are left out, as are
+// single letters, like "u," which are often initials.
+var typos = map[string]string{
+	"teh": "the", "hte": "the", "adn": "and", "nad": "and", "taht": "that",
+	"recieve": "receive", "recieved": "received", "beleive": "believe",
+	"definately": "definitely", "seperate": "separate", "untill": "until",
+	"tommorow": "tomorrow", "tomorow": "tomorrow", "tommorrow": "tomorrow",
+	"tmrw": "tomorrow", "tmr": "tomorrow", "2moro": "tomorrow",
+	"tonite": "tonight", "2nite": "tonight", "tho": "though",
+	"adress": "address", "resturant": "restaurant", "reservaton": "reservation",
+	"calender": "calendar", "wensday": "wednesday", "wednseday": "wednesday",
+	"febuary": "february", "thx": "thanks", "thnx": "thanks", "ty": "thank you",
+	"pls": "please", "plz": "please", "ur": "your",
+	"b4": "before", "msg": "message", "appt": "appointment", "alot": "a lot",
+	"wanna": "want to", "gonna": "going to", "gotta": "got to",
+	"lemme": "let me", "gimme": "give me", "dont": "do not",
+	"doesnt": "does not", "didnt": "did not", "isnt": "is not",
+	"arent": "are not", "wasnt": "was not", "werent": "were not",
+	"cant": "can not", "couldnt": "could not", "wouldnt": "would not",
+	"shouldnt": "should not", "havent": "have not", "hasnt": "has not",
+	"im": "i am", "ive": "i have", "youre": "you are", "thats": "that is",
+	"whats": "what is", "theres": "there is",
+}
+
+// contractions expand the contractions the tokenizer splits at their
+// apostrophes, like "I'm," by their endings. Endings of "n't" are handled
+// separately, since the word before them changes, and "'s" only when it
+// follows a word in contractedIs, since it's usually possessive.
+var contractions = map[string]string{
+	"m": "am", "re": "are", "ve": "have", "ll": "will",
+}
+
+var contractedIs = map[string]struct{}{
+	"it": {}, "that": {}, "what": {}, "there": {}, "here": {}, "he": {},
+	"she": {}, "who": {}, "where": {}, "when": {}, "how": {},
+}
+
+// quotes maps curly and other typographic quotes to their straight forms.
+var quotes = strings.NewReplacer("‘", "'", "’", "'", "‚", "'", "‛", "'",
+	"ʼ", "'", "′", "'", "“", `"`, "”", `"`, "„", `"`, "‟", `"`, "″", `"`,
+	"«", `"`, "»", `"`)
+
+// IsEmoji reports whether a rune is an emoji, or a modifier or joiner used to
+// build one.
+func IsEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF, r >= 0x2600 && r <= 0x27BF,
+		r == 0xFE0F, r == 0x200D:
+		return true
+	}
+	return false
+}
+
+// NormalizeTokens cleans up a tokenized sentence before it's classified. It
+// straightens curly quotes, strips emoji, fixes common typos and shorthand,
+// like "tmrw" and "plz," and expands contractions, so "I can't" becomes "I,"
+// "can," "not." Corrections keep the case of what the user wrote. Words in
+// keep, which must be lowercase, are never corrected, e.g. for a user whose
+// "ur" is short for a product rather than "your."
+//
+// Tokens keep their offsets in the sentence, so a word expanded into several
+// tokens gives each of them the offsets of the whole word.
+func NormalizeTokens(tokens []Token, keep map[string]struct{}) []Token {
+	var out []Token
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		t.Text = strings.Map(func(r rune) rune {
+			if IsEmoji(r) {
+				return -1
+			}
+			return r
+		}, quotes.Replace(t.Text))
+		if len(t.Text) == 0 {
+			continue
+		}
+		if i+2 < len(tokens) && tokens[i+1].Text == "'" {
+			end := tokens[i+2]
+			word, ok := expandContraction(t.Text, end.Text, keep)
+			if ok {
+				out = append(out, Token{Text: word[0], Start: t.Start,
+					End: t.End})
+				out = append(out, Token{Text: word[1],
+					Start: tokens[i+1].Start, End: end.End})
+				i += 2
+				continue
+			}
+		}
+		lower := strings.ToLower(t.Text)
+		fix, ok := typos[lower]
+		if _, kept := keep[lower]; !ok || kept {
+			out = append(out, t)
+			continue
+		}
+		for j, w := range strings.Fields(fix) {
+			if j == 0 || isUpperWord(t.Text) {
+				w = matchCase(t.Text, w)
+			}
+			out = append(out, Token{Text: w, Start: t.Start, End: t.End})
+		}
+	}
+	return out
+}
+
+// expandContraction returns the two words a contraction split by the
+// tokenizer stands for, like "do" and "not" for "don," "'," "t."
+func expandContraction(word, ending string,
+	keep map[string]struct{}) ([]string, bool) {
+
+	lw, le := strings.ToLower(word), strings.ToLower(ending)
+	if _, ok := keep[lw+"'"+le]; ok {
+		return nil, false
+	}
+	var first, second string
+	switch {
+	case le == "t" && lw == "won":
+		first, second = "will", "not"
+	case le == "t" && lw == "can":
+		first, second = "can", "not"
+	case le == "t" && lw == "ain":
+		return nil, false
+	case le == "t" && strings.HasSuffix(lw, "n") && len(lw) > 1:
+		first, second = lw[:len(lw)-1], "not"
+	case le == "s" && lw == "let":
+		first, second = "let", "us"
+	case le == "s":
+		if _, ok := contractedIs[lw]; !ok {
+			return nil, false
+		}
+		first, second = lw, "is"
+	default:
+		var ok bool
+		if second, ok = contractions[le]; !ok {
+			return nil, false
+		}
+		first = lw
+	}
+	if isUpperWord(word) {
+		second = strings.ToUpper(second)
+	}
+	return []string{matchCase(word, first), second}, true
+}
+
+// matchCase returns a correction in the case of the word it replaces: all
+// uppercase, capitalized or lowercase.
+func matchCase(orig, w string) string {
+	if isUpperWord(orig) {
+		return strings.ToUpper(w)
+	}
+	r, _ := utf8.DecodeRuneInString(orig)
+	if unicode.IsUpper(r) {
+		f, n := utf8.DecodeRuneInString(w)
+		return string(unicode.ToUpper(f)) + w[n:]
+	}
+	return w
+}
+
+// isUpperWord reports whether a word of more than one letter is written in
+// all uppercase, like "TMRW." Single letters, like "I," are only capitalized.
+func isUpperWord(s string) bool {
+	var letters int
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			if !unicode.IsUpper(r) {
+				return false
+			}
+			letters++
+		}
+	}
+	return letters > 1
+}
+
+// NormalizeSentence rejoins normalized tokens into a lowercase sentence, e.g.
+// "i do not want it tomorrow" for "I dont want it tmrw 👍," so plugins can
+// match what the user meant without handling each way it may be written.
+func NormalizeSentence(tokens []Token) string {
+	var b bytes.Buffer
+	for i, t := range tokens {
+		if i > 0 {
+			prev := tokens[i-1]
+			if t.Start > prev.End || (endsWord(prev.Text) &&
+				startsWord(t.Text)) {
+
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(t.Text)
+	}
+	return strings.ToLower(b.String())
+}
+
+func endsWord(s string) bool {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func startsWord(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
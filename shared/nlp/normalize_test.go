@@ -0,0 +1,46 @@
+package nlp
+
+import "testing"
+
+func TestNormalizeSentence(t *testing.T) {
+	tests := []struct {
+		in   string
+		keep map[string]struct{}
+		exp  string
+	}{
+		{"I dont want it tmrw 👍", nil, "i do not want it tomorrow"},
+		{"I’m sure it’s fine, thx!", nil, "i am sure it is fine, thanks!"},
+		{"We won't go to Bob's", nil, "we will not go to bob's"},
+		{"“Book” a table 🍕🍕 at teh resturant", nil,
+			`"book" a table at the restaurant`},
+		{"gonna call ur office", map[string]struct{}{"ur": {}},
+			"going to call ur office"},
+		{"I can't", map[string]struct{}{"can't": {}}, "i can't"},
+	}
+	for _, test := range tests {
+		tokens := NormalizeTokens(SentenceTokens(test.in), test.keep)
+		if got := NormalizeSentence(tokens); got != test.exp {
+			t.Errorf("expected %q for %q, got %q", test.exp, test.in, got)
+		}
+	}
+}
+
+func TestNormalizeTokensCase(t *testing.T) {
+	tokens := NormalizeTokens(SentenceTokens("Dont text Alot, CALL TMRW"), nil)
+	exp := []string{"Do", "not", "text", "A", "lot", ",", "CALL", "TOMORROW"}
+	got := TokenTexts(tokens)
+	if len(got) != len(exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Errorf("expected %v, got %v", exp, got)
+			break
+		}
+	}
+	// Expanded words keep the offsets of what the user wrote.
+	if tokens[1].Start != 0 || tokens[1].End != 4 {
+		t.Errorf("expected not at 0-4, got %d-%d", tokens[1].Start,
+			tokens[1].End)
+	}
+}
@@ -0,0 +1,400 @@
+package nlp
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Number is a number in a sentence, whether written in digits, like "23" or
+// "3rd," or spelled out, like "twenty-three," "a dozen" or "third."
+type Number struct {
+	// Text is the number as the user wrote it, e.g. "twenty-third."
+	Text string
+
+	// Normalized is the number in digits, e.g. "23rd."
+	Normalized string
+
+	Value   float64
+	Ordinal bool
+
+	// start and end are the number's byte offsets in the sentence.
+	start, end int
+}
+
+// regexNumberWord matches words and numbers, keeping hyphenated words and
+// numbers like "1,000" and "3.5" whole.
+var regexNumberWord = regexp.MustCompile(`[\p{L}\p{N}]+(?:[-,.][\p{L}\p{N}]+)*`)
+
+// regexNumeral matches numbers written in digits, like "1,000," "3.5" or
+// "21st."
+var regexNumeral = regexp.MustCompile(`^(\d{1,3}(?:,\d{3})+|\d+(?:\.\d+)?)(st|nd|rd|th)?$`)
+
+var units = map[string]float64{
+	"zero": 0, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+	"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11,
+	"twelve": 12, "thirteen": 13, "fourteen": 14, "fifteen": 15,
+	"sixteen": 16, "seventeen": 17, "eighteen": 18, "nineteen": 19,
+}
+
+var tens = map[string]float64{
+	"twenty": 20, "thirty": 30, "forty": 40, "fifty": 50, "sixty": 60,
+	"seventy": 70, "eighty": 80, "ninety": 90,
+}
+
+var scales = map[string]float64{
+	"thousand": 1e3, "million": 1e6, "billion": 1e9,
+}
+
+var ordinalUnits = map[string]float64{
+	"first": 1, "second": 2, "third": 3, "fourth": 4, "fifth": 5,
+	"sixth": 6, "seventh": 7, "eighth": 8, "ninth": 9, "tenth": 10,
+	"eleventh": 11, "twelfth": 12, "thirteenth": 13, "fourteenth": 14,
+	"fifteenth": 15, "sixteenth": 16, "seventeenth": 17,
+	"eighteenth": 18, "nineteenth": 19,
+}
+
+var ordinalTens = map[string]float64{
+	"twentieth": 20, "thirtieth": 30, "fortieth": 40, "fiftieth": 50,
+	"sixtieth": 60, "seventieth": 70, "eightieth": 80, "ninetieth": 90,
+}
+
+var ordinalScales = map[string]float64{
+	"thousandth": 1e3, "millionth": 1e6, "billionth": 1e9,
+}
+
+// notOrdinalAfter are words after which "second" is a length of time, as in
+// "just a second."
+var notOrdinalAfter = map[string]struct{}{
+	"a": {}, "one": {}, "per": {}, "each": {},
+}
+
+// pronounOneAfter are words after which "one" stands for a thing rather than
+// a number, as in "the one with the patio" or "the last one." Ordinals are
+// checked separately.
+var pronounOneAfter = map[string]struct{}{
+	"the": {}, "this": {}, "that": {}, "which": {}, "each": {},
+	"every": {}, "another": {}, "any": {}, "last": {}, "next": {},
+	"other": {}, "same": {},
+}
+
+// numberWord is a word in a sentence, or part of a hyphenated one like
+// "twenty-three."
+type numberWord struct {
+	text       string
+	start, end int
+
+	// first and last are whether the part begins or ends its word, since
+	// numbers can't begin or end partway through "twenty-three."
+	first, last bool
+}
+
+// ExtractNumbers finds the numbers in a sentence, whether written in digits or
+// spelled out, e.g. "twenty-three," "one hundred and five," "two dozen,"
+// "half a dozen," "3rd" or "twenty-first." Numbers are returned in the order
+// they appear.
+func ExtractNumbers(s string) []Number {
+	ws := numberWords(s)
+	var nums []Number
+	for i := 0; i < len(ws); i++ {
+		if !ws[i].first {
+			continue
+		}
+		n, ok := parseNumber(ws[i:])
+		if !ok {
+			continue
+		}
+		if n == 1 && i > 0 && !numberAfter(ws[i-1].text, ws[i].text) {
+			continue
+		}
+		num := numberOf(s, ws[i:i+n])
+		nums = append(nums, num)
+		i += n - 1
+	}
+	return nums
+}
+
+// numberAfter reports whether a word is a number after the word before it,
+// since "second" is a length of time in "just a second" and "one" a thing in
+// "the second one."
+func numberAfter(prev, w string) bool {
+	prev, w = strings.ToLower(prev), strings.ToLower(w)
+	switch w {
+	case "second":
+		_, ok := notOrdinalAfter[prev]
+		return !ok
+	case "one":
+		if _, ok := pronounOneAfter[prev]; ok {
+			return false
+		}
+		_, ok := ordinalUnits[prev]
+		if m := regexNumeral.FindStringSubmatch(prev); m != nil {
+			ok = len(m[2]) > 0
+		}
+		return !ok && ordinalTens[prev] == 0
+	}
+	return true
+}
+
+// ParseNumber parses a string holding nothing but a number, like
+// "twenty-three" or "3rd."
+func ParseNumber(s string) (Number, bool) {
+	s = strings.TrimSpace(s)
+	nums := ExtractNumbers(s)
+	if len(nums) != 1 || nums[0].start != 0 || nums[0].end != len(s) {
+		return Number{}, false
+	}
+	return nums[0], true
+}
+
+// NormalizeNumbers rewrites the numbers in a sentence in digits, so "twenty
+// three days from the third" becomes "23 days from the 3rd."
+func NormalizeNumbers(s string) string {
+	nums := ExtractNumbers(s)
+	for i := len(nums) - 1; i >= 0; i-- {
+		n := nums[i]
+		s = s[:n.start] + n.Normalized + s[n.end:]
+	}
+	return s
+}
+
+// numberWords splits a sentence into words, splitting hyphenated words made
+// of number words, like "twenty-three," into their parts.
+func numberWords(s string) []numberWord {
+	var ws []numberWord
+	for _, loc := range regexNumberWord.FindAllStringIndex(s, -1) {
+		text := s[loc[0]:loc[1]]
+		parts := strings.Split(text, "-")
+		split := len(parts) > 1
+		for _, p := range parts {
+			if !isNumberWord(strings.ToLower(p)) {
+				split = false
+				break
+			}
+		}
+		if !split {
+			ws = append(ws, numberWord{text: text, start: loc[0],
+				end: loc[1], first: true, last: true})
+			continue
+		}
+		start := loc[0]
+		for i, p := range parts {
+			ws = append(ws, numberWord{text: p, start: start,
+				end: start + len(p), first: i == 0,
+				last: i == len(parts)-1})
+			start += len(p) + 1
+		}
+	}
+	return ws
+}
+
+func isNumberWord(w string) bool {
+	if _, ok := units[w]; ok {
+		return true
+	}
+	if _, ok := tens[w]; ok {
+		return true
+	}
+	if _, ok := ordinalUnits[w]; ok {
+		return true
+	}
+	_, ok := ordinalTens[w]
+	return ok
+}
+
+// parseNumber returns how many words at the start of ws make up a number, or
+// false if they don't begin with one.
+func parseNumber(ws []numberWord) (int, bool) {
+	var current, lastScale float64
+	var a, half, numeral, afterHundred bool
+	var n int
+	for i, w := range ws {
+		lw := strings.ToLower(w.text)
+		var done, hundred bool
+		switch {
+		case i == 0 && regexNumeral.MatchString(lw):
+			m := regexNumeral.FindStringSubmatch(lw)
+			v, err := strconv.ParseFloat(strings.Replace(m[1], ",",
+				"", -1), 64)
+			if err != nil {
+				return 0, false
+			}
+			current, numeral = v, true
+			done = len(m[2]) > 0
+		case (lw == "a" || lw == "an") && i == 0,
+			(lw == "a" || lw == "an") && half && i == 1:
+			a = true
+			continue
+		case lw == "half" && i == 0:
+			half = true
+			continue
+		case lw == "and" && afterHundred:
+			// As in "one hundred and five." A trailing "and" isn't
+			// part of the number, since n isn't advanced.
+			continue
+		case lw == "dozen":
+			if a {
+				current = 1
+			}
+			if current == 0 {
+				return n, n > 0
+			}
+			done = true
+		case half:
+			// "Half" only makes numbers of dozens.
+			return n, n > 0
+		case lw == "hundred" || lw == "hundredth":
+			if a {
+				current = 1
+			}
+			if current <= 0 || current >= 100 || !whole(current) {
+				return n, n > 0
+			}
+			current *= 100
+			hundred = true
+			done = lw == "hundredth"
+		case scales[lw] > 0 || ordinalScales[lw] > 0:
+			v := scales[lw] + ordinalScales[lw]
+			if a {
+				current = 1
+			}
+			if current <= 0 || lastScale > 0 && v >= lastScale {
+				return n, n > 0
+			}
+			current, lastScale = 0, v
+			hundred = true
+			done = ordinalScales[lw] > 0
+		case a || numeral:
+			// Only scales may follow "a" or digits, as in "a
+			// hundred" or "3 million."
+			return n, n > 0
+		default:
+			v, ok := units[lw]
+			if ov, ord := ordinalUnits[lw]; ord {
+				v, ok, done = ov, true, true
+			}
+			if ok && canAddUnit(current, v, n) {
+				current += v
+				done = done || v == 0
+				break
+			}
+			v, ok = tens[lw]
+			if ov, ord := ordinalTens[lw]; ord {
+				v, ok, done = ov, true, true
+			}
+			if ok && whole(current) && int64(current)%100 == 0 {
+				current += v
+				break
+			}
+			return n, n > 0
+		}
+		a, afterHundred = false, hundred
+		if w.last {
+			n = i + 1
+		}
+		if done {
+			return n, n > 0
+		}
+	}
+	return n, n > 0
+}
+
+// canAddUnit reports whether a unit may follow the words of a number so far,
+// as in "twenty three" or "one hundred five," but not "seven three."
+func canAddUnit(current, unit float64, words int) bool {
+	if unit == 0 {
+		return words == 0
+	}
+	if !whole(current) {
+		return false
+	}
+	rem := int64(current) % 100
+	return rem == 0 || rem >= 20 && rem%10 == 0 && unit < 10
+}
+
+func whole(f float64) bool {
+	return f == float64(int64(f))
+}
+
+// numberOf returns the Number made by words, parsed by parseNumber.
+func numberOf(s string, ws []numberWord) Number {
+	num := Number{
+		Text:  s[ws[0].start:ws[len(ws)-1].end],
+		start: ws[0].start,
+		end:   ws[len(ws)-1].end,
+	}
+	num.Value, num.Ordinal = numberValue(ws)
+	if num.Value == float64(int64(num.Value)) {
+		num.Normalized = strconv.FormatInt(int64(num.Value), 10)
+	} else {
+		num.Normalized = strconv.FormatFloat(num.Value, 'f', -1, 64)
+	}
+	if num.Ordinal {
+		num.Normalized += ordinalSuffix(int64(num.Value))
+	}
+	return num
+}
+
+// numberValue evaluates words known to make up a number.
+func numberValue(ws []numberWord) (float64, bool) {
+	var total, current float64
+	var half, ordinal bool
+	for _, w := range ws {
+		lw := strings.ToLower(w.text)
+		if m := regexNumeral.FindStringSubmatch(lw); m != nil {
+			current, _ = strconv.ParseFloat(strings.Replace(m[1], ",",
+				"", -1), 64)
+			ordinal = len(m[2]) > 0
+			continue
+		}
+		switch lw {
+		case "a", "an":
+			current = 1
+		case "half":
+			half = true
+		case "and":
+		case "dozen":
+			current *= 12
+			if half {
+				current /= 2
+			}
+		case "hundred", "hundredth":
+			current *= 100
+			ordinal = lw == "hundredth"
+		default:
+			if v, ok := scales[lw]; ok {
+				total += current * v
+				current = 0
+			} else if v, ok = ordinalScales[lw]; ok {
+				total += current * v
+				current, ordinal = 0, true
+			} else if v, ok = units[lw]; ok {
+				current += v
+			} else if v, ok = tens[lw]; ok {
+				current += v
+			} else if v, ok = ordinalUnits[lw]; ok {
+				current += v
+				ordinal = true
+			} else if v, ok = ordinalTens[lw]; ok {
+				current += v
+				ordinal = true
+			}
+		}
+	}
+	return total + current, ordinal
+}
+
+func ordinalSuffix(n int64) string {
+	if n%100 >= 11 && n%100 <= 13 {
+		return "th"
+	}
+	switch n % 10 {
+	case 1:
+		return "st"
+	case 2:
+		return "nd"
+	case 3:
+		return "rd"
+	}
+	return "th"
+}
@@ -0,0 +1,122 @@
+package nlp
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Role distinguishes the user speaking from other people they mention.
+type Role int
+
+// Roles of participants in a message.
+const (
+	// RoleSpeaker is the user sending the message, referred to as "I,"
+	// "me," "my" and so on.
+	RoleSpeaker Role = iota + 1
+
+	// RoleThirdParty is someone else the user mentions, like "Bob" or "my
+	// sister."
+	RoleThirdParty
+)
+
+// Participant is a person taking part in what the user asks for. Abot
+// resolves the speaker to the current user and third parties against the
+// user's contacts.
+type Participant struct {
+	Role Role
+
+	// Mention is how the user referred to the participant, e.g. "Bob" or
+	// "my sister." It's empty for the speaker.
+	Mention string
+
+	// Relationship is set when the participant was mentioned by their
+	// relationship to the user, e.g. "sister" in "my sister."
+	Relationship string
+
+	// UserID is set for the speaker, and ContactID is set for third
+	// parties found in the user's contacts.
+	UserID    uint64
+	ContactID uint64
+}
+
+// firstPerson are words by which users refer to themselves.
+var firstPerson = map[string]struct{}{
+	"i": {}, "me": {}, "my": {}, "mine": {}, "myself": {}, "we": {},
+	"us": {}, "our": {}, "ours": {},
+}
+
+// Relationships are the words people use to refer to others by their
+// relationship, as in "my sister."
+var Relationships = map[string]struct{}{
+	"mom": {}, "mother": {}, "dad": {}, "father": {}, "sister": {},
+	"brother": {}, "wife": {}, "husband": {}, "partner": {}, "son": {},
+	"daughter": {}, "grandma": {}, "grandmother": {}, "grandpa": {},
+	"grandfather": {}, "aunt": {}, "uncle": {}, "cousin": {}, "friend": {},
+	"boyfriend": {}, "girlfriend": {}, "fiance": {}, "fiancee": {},
+	"boss": {}, "manager": {}, "coworker": {}, "assistant": {},
+	"roommate": {}, "neighbor": {}, "doctor": {}, "dentist": {},
+}
+
+// notNames are capitalized words that aren't people's names.
+var notNames = map[string]struct{}{
+	"i": {}, "monday": {}, "tuesday": {}, "wednesday": {}, "thursday": {},
+	"friday": {}, "saturday": {}, "sunday": {}, "january": {},
+	"february": {}, "march": {}, "april": {}, "may": {}, "june": {},
+	"july": {}, "august": {}, "september": {}, "october": {},
+	"november": {}, "december": {}, "ava": {}, "abot": {},
+}
+
+// ExtractParticipants finds the people taking part in a tokenized sentence:
+// the speaker if the user refers to themselves, people mentioned by
+// relationship, like "my sister," and people mentioned by name. Names are
+// capitalized words other than the first, so "Set up lunch with Sarah Smith"
+// finds "Sarah Smith." Participants are returned in the order mentioned.
+func ExtractParticipants(tokens []string) []Participant {
+	var ps []Participant
+	var speaker bool
+	for i := 0; i < len(tokens); i++ {
+		lower := strings.ToLower(tokens[i])
+		if _, ok := firstPerson[lower]; ok {
+			if i+1 < len(tokens) && (lower == "my" || lower == "our") {
+				rel := strings.ToLower(tokens[i+1])
+				if _, ok = Relationships[rel]; ok {
+					ps = append(ps, Participant{
+						Role:         RoleThirdParty,
+						Mention:      tokens[i] + " " + tokens[i+1],
+						Relationship: rel,
+					})
+					i++
+					continue
+				}
+			}
+			if !speaker {
+				ps = append(ps, Participant{Role: RoleSpeaker})
+				speaker = true
+			}
+			continue
+		}
+		if i == 0 || !isName(tokens[i]) {
+			continue
+		}
+		name := []string{tokens[i]}
+		for i+1 < len(tokens) && isName(tokens[i+1]) {
+			i++
+			name = append(name, tokens[i])
+		}
+		ps = append(ps, Participant{
+			Role:    RoleThirdParty,
+			Mention: strings.Join(name, " "),
+		})
+	}
+	return ps
+}
+
+// isName reports whether a token could be part of a person's name.
+func isName(t string) bool {
+	r := []rune(t)
+	if len(r) < 2 || !unicode.IsUpper(r[0]) {
+		return false
+	}
+	_, ok := notNames[strings.ToLower(t)]
+	return !ok
+}
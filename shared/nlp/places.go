@@ -0,0 +1,72 @@
+package nlp
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/itsabot/abot/shared/interface/places/driver"
+)
+
+// Place is a place named in a message, like "Chicago" in "flights to Chicago,"
+// resolved to coordinates by geocoding its name.
+type Place struct {
+	// Name is the place as named in the message.
+	Name    string
+	Address string
+	Lat     float64
+	Lng     float64
+
+	// Accuracy is how precisely Lat and Lng locate the place, e.g. only
+	// to the city center for "Chicago."
+	Accuracy driver.Accuracy
+}
+
+// regexPlace matches capitalized place names after a preposition, like "in
+// San Francisco," "from Union Square" or "at Nopa."
+var regexPlace = regexp.MustCompile(`\b(?i:in|at|to|from|near|around|visiting)\s+([A-Z][\w.'-]*(?:\s+(?:[A-Z][\w.'-]*|of|de|del|la)){0,4})`)
+
+// notPlaces are capitalized words that follow prepositions but aren't places,
+// like "at Noon" or "in May."
+var notPlaces = map[string]struct{}{
+	"i": {}, "noon": {}, "midnight": {}, "me": {}, "us": {},
+	"monday": {}, "tuesday": {}, "wednesday": {}, "thursday": {},
+	"friday": {}, "saturday": {}, "sunday": {}, "january": {},
+	"february": {}, "march": {}, "april": {}, "may": {}, "june": {},
+	"july": {}, "august": {}, "september": {}, "october": {},
+	"november": {}, "december": {},
+}
+
+// ExtractPlaces finds the names of places in a sentence, like "Chicago" and
+// "Union Square" in "directions from Union Square to Chicago," in the order
+// written. Names must be capitalized and follow a preposition, so people
+// mentioned the same way, as in "send it to Bob," are found too. Abot drops
+// those who are the user's contacts.
+func ExtractPlaces(sent string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, m := range regexPlace.FindAllStringSubmatch(sent, -1) {
+		name := strings.TrimRight(m[1], ".")
+		words := strings.Fields(name)
+		for len(words) > 0 {
+			last := words[len(words)-1]
+			if last != "of" && last != "de" && last != "del" &&
+				last != "la" {
+				break
+			}
+			words = words[:len(words)-1]
+		}
+		if len(words) == 0 {
+			continue
+		}
+		if _, ok := notPlaces[strings.ToLower(words[0])]; ok {
+			continue
+		}
+		name = strings.Join(words, " ")
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,235 @@
+package nlp
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Quantity is an amount in a sentence, like "3 lbs," "two dozen," "a pound"
+// or "$40," for plugins that order or buy things. Quantities are of class
+// QuantityI.
+type Quantity struct {
+	// Text is the quantity as the user wrote it, e.g. "3 lbs."
+	Text string
+
+	// Value is the amount in Unit or Currency, e.g. 24 for "two dozen"
+	// and 0.5 for "50 cents."
+	Value float64
+
+	// Unit is the quantity's unit, normalized, like "lb" for "lbs" or
+	// "pounds." It's empty for counts, like "two dozen," and money.
+	Unit string
+
+	// Currency is the ISO 4217 code of amounts of money, like "USD" for
+	// "$40" or "forty bucks."
+	Currency string
+
+	// start and end are the quantity's byte offsets in the sentence.
+	start, end int
+}
+
+// quantityUnits maps the ways units are written to their normalized names.
+var quantityUnits = map[string]string{
+	"lb": "lb", "lbs": "lb", "pound": "lb", "pounds": "lb",
+	"oz": "oz", "ounce": "oz", "ounces": "oz",
+	"kg": "kg", "kgs": "kg", "kilo": "kg", "kilos": "kg",
+	"kilogram": "kg", "kilograms": "kg",
+	"g": "g", "gram": "g", "grams": "g",
+	"l": "l", "liter": "l", "liters": "l", "litre": "l", "litres": "l",
+	"ml": "ml", "milliliter": "ml", "milliliters": "ml",
+	"gal": "gal", "gallon": "gal", "gallons": "gal",
+	"qt": "qt", "quart": "qt", "quarts": "qt",
+	"pt": "pt", "pint": "pt", "pints": "pt",
+	"cup": "cup", "cups": "cup",
+	"tbsp": "tbsp", "tablespoon": "tbsp", "tablespoons": "tbsp",
+	"tsp": "tsp", "teaspoon": "tsp", "teaspoons": "tsp",
+	"inch": "in", "inches": "in",
+	"ft": "ft", "foot": "ft", "feet": "ft",
+	"cm": "cm", "km": "km", "mi": "mi", "mile": "mi", "miles": "mi",
+	"pack": "pack", "packs": "pack", "box": "box", "boxes": "box",
+	"bag": "bag", "bags": "bag", "bottle": "bottle", "bottles": "bottle",
+	"carton": "carton", "cartons": "carton", "slice": "slice",
+	"slices": "slice", "piece": "piece", "pieces": "piece",
+	"loaf": "loaf", "loaves": "loaf",
+}
+
+// currencyWords maps the names of currencies written after amounts to their
+// ISO 4217 codes. "Pounds" are weights unless written with "£."
+var currencyWords = map[string]string{
+	"dollar": "USD", "dollars": "USD", "buck": "USD", "bucks": "USD",
+	"usd": "USD", "cent": "USD", "cents": "USD",
+	"euro": "EUR", "euros": "EUR", "eur": "EUR",
+	"gbp": "GBP", "quid": "GBP",
+	"yen": "JPY", "jpy": "JPY",
+}
+
+// currencySymbols maps the symbols written before amounts to their ISO 4217
+// codes.
+var currencySymbols = map[string]string{
+	"$": "USD", "€": "EUR", "£": "GBP", "¥": "JPY",
+}
+
+// clockBefore are words after which a bare number is likely a time, as in "at
+// 5."
+var clockBefore = map[string]struct{}{
+	"at": {}, "by": {}, "until": {}, "till": {}, "til": {},
+}
+
+// regexNextWord matches the word after a number.
+var regexNextWord = regexp.MustCompile(`^\s*([\p{L}]+)\b`)
+
+// regexPrevWord matches the word before a number.
+var regexPrevWord = regexp.MustCompile(`\b([\p{L}]+)\s*$`)
+
+// regexClockAfter matches what follows a number that's a time, like "5pm,"
+// "5 a.m.," "5:30" or "5 o'clock."
+var regexClockAfter = regexp.MustCompile(`^(?i:\s*[ap]\.?m\b|:\d|\s*o'?clock\b)`)
+
+// regexGluedQuantity matches amounts written without a space before their
+// unit, like "3lbs" or "500ml."
+var regexGluedQuantity = regexp.MustCompile(`\b(\d+(?:\.\d+)?)(\pL+)\b`)
+
+// regexOneUnit matches a single unit written with an article, like "a pound,"
+// or half of one, like "half a pound."
+var regexOneUnit = regexp.MustCompile(`\b(?i:(half\s+)?an?)\s+(\pL+)\b`)
+
+// ExtractQuantities finds the amounts in a sentence, in the order written:
+// counts, like "two dozen," measures, like "3 lbs" or "half a gallon," and
+// money, like "$40" or "fifty cents." Ordinals and numbers that are likely
+// times, like "at 5" or "5:30," aren't quantities.
+func ExtractQuantities(s string) []Quantity {
+	var qs []Quantity
+	for _, n := range ExtractNumbers(s) {
+		if n.Ordinal {
+			continue
+		}
+		q := Quantity{Value: n.Value, start: n.start, end: n.end}
+		if !q.measure(s) && isClockNumber(s, n.start, n.end) {
+			continue
+		}
+		qs = append(qs, q)
+	}
+	for _, m := range regexGluedQuantity.FindAllStringSubmatchIndex(s, -1) {
+		if m[0] > 0 && strings.ContainsAny(s[m[0]-1:m[0]], ",.") {
+			// Part of a number like "1,000lbs," which isn't
+			// supported.
+			continue
+		}
+		v, err := strconv.ParseFloat(s[m[2]:m[3]], 64)
+		if err != nil {
+			continue
+		}
+		q := Quantity{Value: v, start: m[0], end: m[1]}
+		if q.unit(strings.ToLower(s[m[4]:m[5]])) {
+			q.currencySymbol(s)
+			qs = append(qs, q)
+		}
+	}
+	for _, m := range regexOneUnit.FindAllStringSubmatchIndex(s, -1) {
+		q := Quantity{Value: 1, start: m[0], end: m[1]}
+		if m[2] >= 0 {
+			q.Value = 0.5
+		}
+		if q.unit(strings.ToLower(s[m[4]:m[5]])) && !overlaps(qs, q) {
+			qs = append(qs, q)
+		}
+	}
+	sort.Sort(quantitiesByStart(qs))
+	for i := range qs {
+		qs[i].Text = s[qs[i].start:qs[i].end]
+	}
+	return qs
+}
+
+// measure sets a quantity's currency from a symbol before it, or its unit or
+// currency from the word after it, extending the quantity over them. It
+// reports whether either was found.
+func (q *Quantity) measure(s string) bool {
+	sym := q.currencySymbol(s)
+	m := regexNextWord.FindStringSubmatchIndex(s[q.end:])
+	if m == nil {
+		return sym
+	}
+	w := strings.ToLower(s[q.end+m[2] : q.end+m[3]])
+	if sym {
+		// Only the currency's name may follow its symbol, as in "$40
+		// USD," since "£5 pounds" are money rather than weight.
+		if _, ok := currencyWords[w]; ok || w == "pounds" {
+			q.end += m[1]
+		}
+		return true
+	}
+	end := q.end
+	q.end += m[1]
+	if q.unit(w) {
+		return true
+	}
+	q.end = end
+	return false
+}
+
+// unit sets a quantity's unit or currency from a word, reporting whether it's
+// either.
+func (q *Quantity) unit(w string) bool {
+	if u, ok := quantityUnits[w]; ok {
+		q.Unit = u
+		return true
+	}
+	c, ok := currencyWords[w]
+	if !ok {
+		return false
+	}
+	q.Currency = c
+	if w == "cent" || w == "cents" {
+		q.Value /= 100
+	}
+	return true
+}
+
+// currencySymbol sets a quantity's currency from a symbol before it, like "$"
+// in "$40," extending the quantity over it. It reports whether one was found.
+func (q *Quantity) currencySymbol(s string) bool {
+	before := strings.TrimRight(s[:q.start], " ")
+	for sym, c := range currencySymbols {
+		if strings.HasSuffix(before, sym) {
+			q.Currency = c
+			q.start = len(before) - len(sym)
+			return true
+		}
+	}
+	return false
+}
+
+// isClockNumber reports whether the number between start and end is likely a
+// time, as in "at 5," "5pm" or "5:30."
+func isClockNumber(s string, start, end int) bool {
+	if regexClockAfter.MatchString(s[end:]) {
+		return true
+	}
+	if strings.HasSuffix(s[:start], ":") {
+		return true
+	}
+	m := regexPrevWord.FindStringSubmatch(s[:start])
+	if m == nil {
+		return false
+	}
+	_, ok := clockBefore[strings.ToLower(m[1])]
+	return ok
+}
+
+func overlaps(qs []Quantity, q Quantity) bool {
+	for _, o := range qs {
+		if q.start < o.end && o.start < q.end {
+			return true
+		}
+	}
+	return false
+}
+
+type quantitiesByStart []Quantity
+
+func (q quantitiesByStart) Len() int           { return len(q) }
+func (q quantitiesByStart) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q quantitiesByStart) Less(i, j int) bool { return q[i].start < q[j].start }
@@ -0,0 +1,44 @@
+package nlp
+
+import "testing"
+
+func TestExtractQuantities(t *testing.T) {
+	tests := []struct {
+		sent string
+		exp  []Quantity
+	}{
+		{"order two dozen bagels", []Quantity{
+			{Text: "two dozen", Value: 24}}},
+		{"3 lbs of coffee and 500ml of milk", []Quantity{
+			{Text: "3 lbs", Value: 3, Unit: "lb"},
+			{Text: "500ml", Value: 500, Unit: "ml"}}},
+		{"half a pound of ham", []Quantity{
+			{Text: "half a pound", Value: 0.5, Unit: "lb"}}},
+		{"keep it under $40", []Quantity{
+			{Text: "$40", Value: 40, Currency: "USD"}}},
+		{"twenty bucks or £5.50", []Quantity{
+			{Text: "twenty bucks", Value: 20, Currency: "USD"},
+			{Text: "£5.50", Value: 5.5, Currency: "GBP"}}},
+		{"fifty cents", []Quantity{
+			{Text: "fifty cents", Value: 0.5, Currency: "USD"}}},
+		{"a table for 4 at 7:30", []Quantity{
+			{Text: "4", Value: 4}}},
+		{"the third one at 5", nil},
+	}
+	for _, test := range tests {
+		got := ExtractQuantities(test.sent)
+		if len(got) != len(test.exp) {
+			t.Errorf("%q: expected %+v, got %+v", test.sent, test.exp,
+				got)
+			continue
+		}
+		for i, q := range got {
+			exp := test.exp[i]
+			if q.Text != exp.Text || q.Value != exp.Value ||
+				q.Unit != exp.Unit || q.Currency != exp.Currency {
+				t.Errorf("%q: expected %+v, got %+v", test.sent,
+					exp, q)
+			}
+		}
+	}
+}
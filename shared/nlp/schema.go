@@ -0,0 +1,440 @@
+package nlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/itsabot/abot/shared/interface/places/driver"
+)
+
+// SchemaVersion is the version of StructuredInput's JSON schema. It's
+// incremented whenever the schema changes, and a migration from the previous
+// version added to schemaMigrations.
+//
+// Changes are additive: fields are added but never renamed, retyped or
+// removed, so packages built against an older schema can decode inputs of a
+// newer one, ignoring the fields they don't know.
+//
+// Version 1 is StructuredInput as encoded by encoding/json before the schema
+// was versioned, with Go's field names and no version field. Version 3 adds
+// the classified words in classes, version 4 the pronouns in references,
+// version 5 the dates and times in date_times, version 6 the named places in
+// places and parsed_places, version 7 negated classes, version 8 the amounts
+// in quantities, version 9 the message's sentiment, version 10 the named
+// people and businesses in actors and everything named in entities, version
+// 11 the sentence as written and normalized in raw_sentence and
+// normalized_sentence, and version 12 the start of each date and time in
+// parsed_times.
+const SchemaVersion = 12
+
+// schemaMigrations upgrade a decoded StructuredInput document from the version
+// at their index + 1 to the next version, so schemaMigrations[0] upgrades
+// version 1 to version 2.
+var schemaMigrations = []func(map[string]json.RawMessage) error{
+	migrateSchemaV1,
+	migrateSchemaV2,
+	migrateSchemaV3,
+	migrateSchemaV4,
+	migrateSchemaV5,
+	migrateSchemaV6,
+	migrateSchemaV7,
+	migrateSchemaV8,
+	migrateSchemaV9,
+	migrateSchemaV10,
+	migrateSchemaV11,
+}
+
+// siJSON is StructuredInput's wire format.
+type siJSON struct {
+	Version            int               `json:"version"`
+	RawSentence        string            `json:"raw_sentence,omitempty"`
+	NormalizedSentence string            `json:"normalized_sentence,omitempty"`
+	Commands           []string          `json:"commands"`
+	Objects            []string          `json:"objects"`
+	Actors             []string          `json:"actors,omitempty"`
+	Entities           []entityJSON      `json:"entities,omitempty"`
+	Classes            []wordClassJSON   `json:"classes,omitempty"`
+	FromImage          bool              `json:"from_image,omitempty"`
+	Participants       []participantJSON `json:"participants,omitempty"`
+	OnlyTime           bool              `json:"only_time,omitempty"`
+	Times              []time.Time       `json:"times,omitempty"`
+	Code               string            `json:"code,omitempty"`
+	Numbers            []numberJSON      `json:"numbers,omitempty"`
+	References         []referenceJSON   `json:"references,omitempty"`
+	DateTimes          []dateTimeJSON    `json:"date_times,omitempty"`
+	ParsedTimes        []time.Time       `json:"parsed_times,omitempty"`
+	Places             []string          `json:"places,omitempty"`
+	ParsedPlaces       []placeJSON       `json:"parsed_places,omitempty"`
+	Quantities         []quantityJSON    `json:"quantities,omitempty"`
+	Sentiment          float64           `json:"sentiment,omitempty"`
+}
+
+type participantJSON struct {
+	Role         Role   `json:"role"`
+	Mention      string `json:"mention,omitempty"`
+	Relationship string `json:"relationship,omitempty"`
+	UserID       uint64 `json:"user_id,omitempty"`
+	ContactID    uint64 `json:"contact_id,omitempty"`
+}
+
+type entityJSON struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class SIT    `json:"class"`
+	Start int    `json:"start"`
+	Len   int    `json:"len"`
+}
+
+type wordClassJSON struct {
+	Word       string  `json:"word"`
+	Class      SIT     `json:"class"`
+	Confidence float64 `json:"confidence"`
+	Negated    bool    `json:"negated,omitempty"`
+}
+
+type referenceJSON struct {
+	Pronoun string `json:"pronoun"`
+	Class   SIT    `json:"class"`
+	Entity  string `json:"entity,omitempty"`
+}
+
+type dateTimeJSON struct {
+	Text       string          `json:"text"`
+	Start      time.Time       `json:"start"`
+	End        *time.Time      `json:"end,omitempty"`
+	AllDay     bool            `json:"all_day,omitempty"`
+	Recurrence *recurrenceJSON `json:"recurrence,omitempty"`
+}
+
+type recurrenceJSON struct {
+	Frequency Frequency      `json:"frequency"`
+	Interval  int            `json:"interval"`
+	Weekdays  []time.Weekday `json:"weekdays,omitempty"`
+}
+
+type placeJSON struct {
+	Name     string          `json:"name"`
+	Address  string          `json:"address,omitempty"`
+	Lat      float64         `json:"lat"`
+	Lng      float64         `json:"lon"`
+	Accuracy driver.Accuracy `json:"accuracy,omitempty"`
+}
+
+type quantityJSON struct {
+	Text     string  `json:"text"`
+	Value    float64 `json:"value"`
+	Unit     string  `json:"unit,omitempty"`
+	Currency string  `json:"currency,omitempty"`
+}
+
+type numberJSON struct {
+	Text       string  `json:"text"`
+	Normalized string  `json:"normalized"`
+	Value      float64 `json:"value"`
+	Ordinal    bool    `json:"ordinal,omitempty"`
+}
+
+// MarshalJSON encodes the StructuredInput in the current SchemaVersion.
+func (si StructuredInput) MarshalJSON() ([]byte, error) {
+	j := siJSON{
+		Version:            SchemaVersion,
+		RawSentence:        si.RawSentence,
+		NormalizedSentence: si.NormalizedSentence,
+		Commands:           []string(si.Commands),
+		Objects:            []string(si.Objects),
+		Actors:             []string(si.Actors),
+		FromImage:          si.FromImage,
+		OnlyTime:           si.OnlyTime,
+		Times:              si.Times,
+		ParsedTimes:        si.ParsedTimes,
+		Code:               si.Code,
+		Places:             []string(si.Places),
+		Sentiment:          si.Sentiment,
+	}
+	if j.Commands == nil {
+		j.Commands = []string{}
+	}
+	if j.Objects == nil {
+		j.Objects = []string{}
+	}
+	for _, e := range si.Entities {
+		j.Entities = append(j.Entities, entityJSON(e))
+	}
+	for _, w := range si.Classes {
+		j.Classes = append(j.Classes, wordClassJSON(w))
+	}
+	for _, p := range si.Participants {
+		j.Participants = append(j.Participants, participantJSON(p))
+	}
+	for _, n := range si.Numbers {
+		j.Numbers = append(j.Numbers, numberJSON{
+			Text:       n.Text,
+			Normalized: n.Normalized,
+			Value:      n.Value,
+			Ordinal:    n.Ordinal,
+		})
+	}
+	for _, r := range si.References {
+		j.References = append(j.References, referenceJSON(r))
+	}
+	for _, d := range si.DateTimes {
+		dj := dateTimeJSON{Text: d.Text, Start: d.Start, AllDay: d.AllDay}
+		if !d.End.IsZero() {
+			end := d.End
+			dj.End = &end
+		}
+		if d.Recurrence != nil {
+			dj.Recurrence = &recurrenceJSON{
+				Frequency: d.Recurrence.Frequency,
+				Interval:  d.Recurrence.Interval,
+				Weekdays:  d.Recurrence.Weekdays,
+			}
+		}
+		j.DateTimes = append(j.DateTimes, dj)
+	}
+	for _, p := range si.ParsedPlaces {
+		j.ParsedPlaces = append(j.ParsedPlaces, placeJSON(p))
+	}
+	for _, q := range si.Quantities {
+		j.Quantities = append(j.Quantities, quantityJSON{
+			Text:     q.Text,
+			Value:    q.Value,
+			Unit:     q.Unit,
+			Currency: q.Currency,
+		})
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes a StructuredInput of any SchemaVersion, migrating
+// older versions to the current one. Fields added by versions newer than
+// SchemaVersion are ignored.
+func (si *StructuredInput) UnmarshalJSON(b []byte) error {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	version := 1
+	if raw, ok := doc["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return err
+		}
+	}
+	if version < 1 {
+		return fmt.Errorf("invalid structured input schema version %d",
+			version)
+	}
+	for v := version; v < SchemaVersion; v++ {
+		if err := schemaMigrations[v-1](doc); err != nil {
+			return fmt.Errorf("migrate structured input from v%d: %s",
+				v, err)
+		}
+	}
+	byt, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	var j siJSON
+	if err = json.Unmarshal(byt, &j); err != nil {
+		return err
+	}
+	*si = StructuredInput{
+		RawSentence:        j.RawSentence,
+		NormalizedSentence: j.NormalizedSentence,
+		Commands:           StringSlice(j.Commands),
+		Objects:            StringSlice(j.Objects),
+		Actors:             StringSlice(j.Actors),
+		FromImage:          j.FromImage,
+		OnlyTime:           j.OnlyTime,
+		Times:              j.Times,
+		ParsedTimes:        j.ParsedTimes,
+		Code:               j.Code,
+		Places:             StringSlice(j.Places),
+		Sentiment:          j.Sentiment,
+	}
+	for _, e := range j.Entities {
+		si.Entities = append(si.Entities, Entity(e))
+	}
+	for _, w := range j.Classes {
+		si.Classes = append(si.Classes, WordClass(w))
+	}
+	for _, p := range j.Participants {
+		si.Participants = append(si.Participants, Participant(p))
+	}
+	for _, n := range j.Numbers {
+		si.Numbers = append(si.Numbers, Number{
+			Text:       n.Text,
+			Normalized: n.Normalized,
+			Value:      n.Value,
+			Ordinal:    n.Ordinal,
+		})
+	}
+	for _, r := range j.References {
+		si.References = append(si.References, Reference(r))
+	}
+	for _, dj := range j.DateTimes {
+		d := DateTime{Text: dj.Text, Start: dj.Start, AllDay: dj.AllDay}
+		if dj.End != nil {
+			d.End = *dj.End
+		}
+		if dj.Recurrence != nil {
+			d.Recurrence = &Recurrence{
+				Frequency: dj.Recurrence.Frequency,
+				Interval:  dj.Recurrence.Interval,
+				Weekdays:  dj.Recurrence.Weekdays,
+			}
+		}
+		si.DateTimes = append(si.DateTimes, d)
+	}
+	for _, p := range j.ParsedPlaces {
+		si.ParsedPlaces = append(si.ParsedPlaces, Place(p))
+	}
+	for _, q := range j.Quantities {
+		si.Quantities = append(si.Quantities, Quantity{
+			Text:     q.Text,
+			Value:    q.Value,
+			Unit:     q.Unit,
+			Currency: q.Currency,
+		})
+	}
+	return nil
+}
+
+// migrateSchemaV1 renames the Go field names encoding/json used before the
+// schema was versioned.
+func migrateSchemaV1(doc map[string]json.RawMessage) error {
+	renames := map[string]string{
+		"Commands": "commands", "Objects": "objects",
+		"FromImage": "from_image", "OnlyTime": "only_time",
+		"Times": "times", "Code": "code", "Numbers": "numbers",
+	}
+	for from, to := range renames {
+		if raw, ok := doc[from]; ok {
+			doc[to] = raw
+			delete(doc, from)
+		}
+	}
+	raw, ok := doc["Participants"]
+	if !ok {
+		return nil
+	}
+	delete(doc, "Participants")
+	var ps []Participant
+	if err := json.Unmarshal(raw, &ps); err != nil {
+		return err
+	}
+	js := make([]participantJSON, len(ps))
+	for i, p := range ps {
+		js[i] = participantJSON(p)
+	}
+	byt, err := json.Marshal(js)
+	if err != nil {
+		return err
+	}
+	doc["participants"] = byt
+	return nil
+}
+
+// migrateSchemaV2 adds classes for the commands and objects of inputs that
+// predate confidences. They're given full confidence, since they were acted
+// on without question.
+func migrateSchemaV2(doc map[string]json.RawMessage) error {
+	var cmds, objs []string
+	if raw, ok := doc["commands"]; ok {
+		if err := json.Unmarshal(raw, &cmds); err != nil {
+			return err
+		}
+	}
+	if raw, ok := doc["objects"]; ok {
+		if err := json.Unmarshal(raw, &objs); err != nil {
+			return err
+		}
+	}
+	var cs []wordClassJSON
+	for _, w := range cmds {
+		cs = append(cs, wordClassJSON{Word: w, Class: CommandI,
+			Confidence: 1})
+	}
+	for _, w := range objs {
+		cs = append(cs, wordClassJSON{Word: w, Class: ObjectI,
+			Confidence: 1})
+	}
+	byt, err := json.Marshal(cs)
+	if err != nil {
+		return err
+	}
+	doc["classes"] = byt
+	return nil
+}
+
+// migrateSchemaV3 does nothing, since inputs that predate references had none
+// to record.
+func migrateSchemaV3(doc map[string]json.RawMessage) error {
+	return nil
+}
+
+// migrateSchemaV4 does nothing, since inputs that predate date_times weren't
+// parsed for them.
+func migrateSchemaV4(doc map[string]json.RawMessage) error {
+	return nil
+}
+
+// migrateSchemaV5 does nothing, since inputs that predate places weren't
+// parsed for them.
+func migrateSchemaV5(doc map[string]json.RawMessage) error {
+	return nil
+}
+
+// migrateSchemaV6 does nothing, since inputs that predate negation are read
+// as they were acted on, with nothing negated.
+func migrateSchemaV6(doc map[string]json.RawMessage) error {
+	return nil
+}
+
+// migrateSchemaV7 does nothing, since inputs that predate quantities weren't
+// parsed for them.
+func migrateSchemaV7(doc map[string]json.RawMessage) error {
+	return nil
+}
+
+// migrateSchemaV8 does nothing, since inputs that predate sentiment weren't
+// scored, and are read as neutral.
+func migrateSchemaV8(doc map[string]json.RawMessage) error {
+	return nil
+}
+
+// migrateSchemaV9 does nothing, since inputs that predate actors and entities
+// weren't searched for names.
+func migrateSchemaV9(doc map[string]json.RawMessage) error {
+	return nil
+}
+
+// migrateSchemaV10 does nothing, since the sentences of inputs that predate
+// raw_sentence weren't kept with them.
+func migrateSchemaV10(doc map[string]json.RawMessage) error {
+	return nil
+}
+
+// migrateSchemaV11 sets parsed_times to the start of each of the date_times of
+// inputs that predate it.
+func migrateSchemaV11(doc map[string]json.RawMessage) error {
+	raw, ok := doc["date_times"]
+	if !ok {
+		return nil
+	}
+	var dts []dateTimeJSON
+	if err := json.Unmarshal(raw, &dts); err != nil {
+		return err
+	}
+	var ts []time.Time
+	for _, d := range dts {
+		ts = append(ts, d.Start)
+	}
+	byt, err := json.Marshal(ts)
+	if err != nil {
+		return err
+	}
+	doc["parsed_times"] = byt
+	return nil
+}
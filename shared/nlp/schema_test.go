@@ -0,0 +1,194 @@
+package nlp
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/itsabot/abot/shared/interface/places/driver"
+)
+
+func TestStructuredInputRoundTrip(t *testing.T) {
+	si := StructuredInput{
+		RawSentence:        "Book a table w/ John Smith, not the resturant",
+		NormalizedSentence: "book a table with john smith, not the restaurant",
+		Commands:           StringSlice{"book"},
+		Objects:            StringSlice{"table", "restaurant"},
+		Actors:             StringSlice{"john smith"},
+		Entities: []Entity{
+			{Name: "John Smith", Type: EntityPerson, Class: PersonI,
+				Start: 3, Len: 2},
+		},
+		Classes: []WordClass{
+			{Word: "book", Class: CommandI, Confidence: 0.5},
+			{Word: "table", Class: ObjectI, Confidence: 0.9},
+			{Word: "restaurant", Class: ObjectI, Confidence: 0.9,
+				Negated: true},
+		},
+		FromImage: true,
+		Participants: []Participant{
+			{Role: RoleSpeaker, UserID: 1},
+			{Role: RoleThirdParty, Mention: "my sister",
+				Relationship: "sister", ContactID: 7},
+		},
+		OnlyTime: true,
+		Times:    []time.Time{time.Date(2016, 5, 20, 19, 0, 0, 0, time.UTC)},
+		Code:     "489221",
+		Numbers: []Number{
+			{Text: "twenty-three", Normalized: "23", Value: 23},
+			{Text: "third", Normalized: "3rd", Value: 3, Ordinal: true},
+		},
+		References: []Reference{
+			{Pronoun: "there", Class: PlaceI, Entity: "Nopa"},
+		},
+		DateTimes: []DateTime{
+			{Text: "from 3 to 5pm",
+				Start: time.Date(2016, 5, 20, 15, 0, 0, 0, time.UTC),
+				End:   time.Date(2016, 5, 20, 17, 0, 0, 0, time.UTC)},
+			{Text: "every other monday",
+				Start:  time.Date(2016, 5, 23, 0, 0, 0, 0, time.UTC),
+				AllDay: true,
+				Recurrence: &Recurrence{Frequency: Weekly, Interval: 2,
+					Weekdays: []time.Weekday{time.Monday}}},
+		},
+		ParsedTimes: []time.Time{
+			time.Date(2016, 5, 20, 15, 0, 0, 0, time.UTC),
+			time.Date(2016, 5, 23, 0, 0, 0, 0, time.UTC),
+		},
+		Places: StringSlice{"Nopa", "San Francisco"},
+		ParsedPlaces: []Place{
+			{Name: "San Francisco", Address: "San Francisco, CA, USA",
+				Lat: 37.7749, Lng: -122.4194,
+				Accuracy: driver.AccuracyLocality},
+		},
+		Quantities: []Quantity{
+			{Text: "two dozen", Value: 24},
+			{Text: "$40", Value: 40, Currency: "USD"},
+			{Text: "3 lbs", Value: 3, Unit: "lb"},
+		},
+		Sentiment: -0.5,
+	}
+	byt, err := json.Marshal(si)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err = json.Unmarshal(byt, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["version"] != float64(SchemaVersion) {
+		t.Fatal("expected version", SchemaVersion, "got", doc["version"])
+	}
+	var got StructuredInput
+	if err = json.Unmarshal(byt, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(si, got) {
+		t.Fatalf("expected %+v, got %+v", si, got)
+	}
+}
+
+func TestStructuredInputEmpty(t *testing.T) {
+	byt, err := json.Marshal(StructuredInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"version":12,"commands":[],"objects":[]}`
+	if string(byt) != exp {
+		t.Fatal("expected", exp, "got", string(byt))
+	}
+	var got StructuredInput
+	if err = json.Unmarshal(byt, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Commands) != 0 || len(got.Objects) != 0 {
+		t.Fatalf("expected empty input, got %+v", got)
+	}
+}
+
+func TestStructuredInputMigrateV1(t *testing.T) {
+	v1 := `{"Commands":["find"],"Objects":["sushi"],"FromImage":false,
+		"Participants":[{"Role":2,"Mention":"Bob","Relationship":"",
+		"UserID":0,"ContactID":3}],"OnlyTime":false,"Times":null,
+		"Code":""}`
+	var got StructuredInput
+	if err := json.Unmarshal([]byte(v1), &got); err != nil {
+		t.Fatal(err)
+	}
+	exp := StructuredInput{
+		Commands: StringSlice{"find"},
+		Objects:  StringSlice{"sushi"},
+		Classes: []WordClass{
+			{Word: "find", Class: CommandI, Confidence: 1},
+			{Word: "sushi", Class: ObjectI, Confidence: 1},
+		},
+		Participants: []Participant{
+			{Role: RoleThirdParty, Mention: "Bob", ContactID: 3},
+		},
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected %+v, got %+v", exp, got)
+	}
+}
+
+func TestStructuredInputMigrateV11(t *testing.T) {
+	v11 := `{"version":11,"commands":[],"objects":[],"date_times":[
+		{"text":"may 20","start":"2016-05-20T00:00:00Z","all_day":true}]}`
+	var got StructuredInput
+	if err := json.Unmarshal([]byte(v11), &got); err != nil {
+		t.Fatal(err)
+	}
+	exp := []time.Time{time.Date(2016, 5, 20, 0, 0, 0, 0, time.UTC)}
+	if !reflect.DeepEqual(exp, got.ParsedTimes) {
+		t.Fatal("expected parsed times", exp, "got", got.ParsedTimes)
+	}
+}
+
+func TestStructuredInputNewerVersion(t *testing.T) {
+	newer := `{"version":99,"commands":["find"],"objects":[],
+		"weather":["rain"]}`
+	var got StructuredInput
+	if err := json.Unmarshal([]byte(newer), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Commands.Last() != "find" {
+		t.Fatal("expected command find, got", got.Commands)
+	}
+}
+
+func TestStructuredInputConfidence(t *testing.T) {
+	var si StructuredInput
+	err := si.Add([]WordClass{
+		{Word: "book", Class: CommandI, Confidence: 0.5},
+		{Word: "book", Class: ObjectI, Confidence: 0.5},
+		{Word: "table", Class: ObjectI, Confidence: 0.9},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if si.Commands.Last() != "book" || si.Objects.Last() != "table" {
+		t.Fatal("expected book and table, got", si.Commands, si.Objects)
+	}
+	if c := si.Confidence(CommandI); c != 0.5 {
+		t.Fatal("expected command confidence 0.5, got", c)
+	}
+	if c := si.Confidence(ObjectI); c != 0.9 {
+		t.Fatal("expected object confidence 0.9, got", c)
+	}
+	if c := si.Confidence(PersonI); c != 0 {
+		t.Fatal("expected no person confidence, got", c)
+	}
+	err = si.Add([]WordClass{{Word: "bob smith", Class: PersonI,
+		Confidence: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if si.Actors.Last() != "bob smith" || si.Confidence(PersonI) != 1 {
+		t.Fatal("expected actor bob smith, got", si.Actors)
+	}
+	err = si.Add([]WordClass{{Word: "chicago", Class: PlaceI}})
+	if err != ErrInvalidClass {
+		t.Fatal("expected", ErrInvalidClass, "got", err)
+	}
+}
@@ -0,0 +1,257 @@
+package nlp
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// regexSegmentSep matches where one request may end and another begin, as in
+// "cancel my 2pm and book dinner at 7."
+var regexSegmentSep = regexp.MustCompile(`(?i)\s*;\s*|,?\s+(?:and then|and also|and|then|also)\s+`)
+
+// Segment splits an input holding several requests, like "book a table for 7
+// and text Sarah the address," into an input for each of its Commands, in
+// the order written. A clause of RawSentence is only split off when it holds
+// a command, so "book dinner and drinks" stays whole, and clauses that don't
+// are kept with the request before them. Segments share the context
+// mentioned only once, as by ShareContext. Inputs holding fewer than two
+// requests return nil.
+func (si *StructuredInput) Segment() []*StructuredInput {
+	return si.SegmentFunc(func(clause string) bool {
+		words := lowerTexts(SentenceTokens(clause))
+		for _, cmd := range si.Commands {
+			if start, _ := findWords(words, cmd); start >= 0 {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// SegmentFunc splits an input like Segment, splitting off the clauses of
+// RawSentence for which split returns true, e.g. those that route to a plugin
+// on their own. split is called once for each clause, in order.
+func (si *StructuredInput) SegmentFunc(
+	split func(clause string) bool) []*StructuredInput {
+
+	s := si.RawSentence
+	locs := regexSegmentSep.FindAllStringIndex(s, -1)
+	if len(locs) == 0 {
+		return nil
+	}
+	var starts []int
+	start := 0
+	for i := 0; i <= len(locs); i++ {
+		end := len(s)
+		if i < len(locs) {
+			end = locs[i][0]
+		}
+		if split(s[start:end]) {
+			starts = append(starts, start)
+		}
+		if i < len(locs) {
+			start = locs[i][1]
+		}
+	}
+	if len(starts) < 2 {
+		return nil
+	}
+	// Leading clauses that aren't split off belong to the first request.
+	starts[0] = 0
+	segs := make([]*StructuredInput, len(starts))
+	for i, start := range starts {
+		end := len(s)
+		if i+1 < len(starts) {
+			end = sepBefore(locs, starts[i+1])
+		}
+		segs[i] = si.clause(strings.TrimSpace(s[start:end]))
+	}
+	if len(segs[0].DateTimes) == 0 {
+		segs[0].Times = copyTimes(si.Times)
+	}
+	ShareContext(segs)
+	return segs
+}
+
+// ShareContext gives inputs split from the same message the context they
+// share but mention only once. The speaker is a participant in every input if
+// they referred to themselves in any, and inputs without a time happen at the
+// time of the input before them, so in "book a table at 7 and text Sarah the
+// address," Sarah is texted about the table at 7. Times are copied, so
+// changing one input's doesn't change another's.
+func ShareContext(sis []*StructuredInput) {
+	var speaker *Participant
+	for _, si := range sis {
+		for i, p := range si.Participants {
+			if p.Role == RoleSpeaker {
+				speaker = &si.Participants[i]
+				break
+			}
+		}
+		if speaker != nil {
+			break
+		}
+	}
+	var prev *StructuredInput
+	for _, si := range sis {
+		if speaker != nil && !hasSpeaker(si.Participants) {
+			si.Participants = append([]Participant{*speaker},
+				si.Participants...)
+		}
+		if len(si.Times) == 0 && len(si.DateTimes) == 0 && prev != nil {
+			si.Times = copyTimes(prev.Times)
+			si.DateTimes = append([]DateTime(nil), prev.DateTimes...)
+			si.ParsedTimes = copyTimes(prev.ParsedTimes)
+		}
+		if len(si.Times) > 0 || len(si.DateTimes) > 0 {
+			prev = si
+		}
+	}
+}
+
+// clause returns the part of the input written in a clause of its sentence.
+// The clause's Entities are located in its own tokens.
+func (si *StructuredInput) clause(text string) *StructuredInput {
+	tokens := NormalizeTokens(SentenceTokens(text), nil)
+	words := lowerTexts(tokens)
+	lower := strings.ToLower(text)
+	c := &StructuredInput{
+		RawSentence:        text,
+		NormalizedSentence: NormalizeSentence(tokens),
+		FromImage:          si.FromImage,
+		Code:               si.Code,
+		Sentiment:          si.Sentiment,
+	}
+	c.Commands = clauseWords(si.Commands, words)
+	c.Objects = clauseWords(si.Objects, words)
+	c.Actors = clauseWords(si.Actors, words)
+	for _, w := range si.Classes {
+		if start, _ := findWords(words, w.Word); start >= 0 {
+			c.Classes = append(c.Classes, w)
+		}
+	}
+	for _, e := range si.Entities {
+		if start, n := findWords(words, e.Name); start >= 0 {
+			e.Start, e.Len = start, n
+			c.Entities = append(c.Entities, e)
+		}
+	}
+	for _, p := range si.Participants {
+		if p.Role == RoleSpeaker && hasFirstPerson(words) {
+			c.Participants = append(c.Participants, p)
+		} else if p.Role != RoleSpeaker && len(p.Mention) > 0 &&
+			strings.Contains(lower, strings.ToLower(p.Mention)) {
+			c.Participants = append(c.Participants, p)
+		}
+	}
+	for _, r := range si.References {
+		if start, _ := findWords(words, r.Pronoun); start >= 0 {
+			c.References = append(c.References, r)
+		}
+	}
+	numbered := strings.ToLower(NormalizeNumbers(text))
+	for i, d := range si.DateTimes {
+		if !strings.Contains(numbered, d.Text) {
+			continue
+		}
+		c.DateTimes = append(c.DateTimes, d)
+		if i < len(si.ParsedTimes) {
+			c.ParsedTimes = append(c.ParsedTimes, si.ParsedTimes[i])
+		}
+	}
+	for _, n := range si.Numbers {
+		if strings.Contains(lower, strings.ToLower(n.Text)) {
+			c.Numbers = append(c.Numbers, n)
+		}
+	}
+	for _, q := range si.Quantities {
+		if strings.Contains(lower, strings.ToLower(q.Text)) {
+			c.Quantities = append(c.Quantities, q)
+		}
+	}
+	for _, p := range si.Places {
+		if strings.Contains(lower, strings.ToLower(p)) {
+			c.Places = append(c.Places, p)
+		}
+	}
+	for _, p := range si.ParsedPlaces {
+		if strings.Contains(lower, strings.ToLower(p.Name)) {
+			c.ParsedPlaces = append(c.ParsedPlaces, p)
+		}
+	}
+	return c
+}
+
+// clauseWords returns those of ws found in a clause's lowercase words.
+func clauseWords(ws StringSlice, words []string) StringSlice {
+	var found StringSlice
+	for _, w := range ws {
+		if start, _ := findWords(words, w); start >= 0 {
+			found = append(found, w)
+		}
+	}
+	return found
+}
+
+// findWords returns the index and length of a phrase's words in a sentence's
+// lowercase words, or -1 if the phrase isn't there.
+func findWords(words []string, phrase string) (int, int) {
+	ws := lowerTexts(SentenceTokens(phrase))
+	if len(ws) == 0 {
+		return -1, 0
+	}
+	for i := 0; i+len(ws) <= len(words); i++ {
+		match := true
+		for j, w := range ws {
+			if words[i+j] != w {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i, len(ws)
+		}
+	}
+	return -1, 0
+}
+
+func lowerTexts(ts []Token) []string {
+	words := make([]string, len(ts))
+	for i, t := range ts {
+		words[i] = strings.ToLower(t.Text)
+	}
+	return words
+}
+
+func hasFirstPerson(words []string) bool {
+	for _, w := range words {
+		if _, ok := firstPerson[w]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hasSpeaker(ps []Participant) bool {
+	for _, p := range ps {
+		if p.Role == RoleSpeaker {
+			return true
+		}
+	}
+	return false
+}
+
+func copyTimes(ts []time.Time) []time.Time {
+	return append([]time.Time(nil), ts...)
+}
+
+// sepBefore returns where the separator ending just before a clause starts.
+func sepBefore(locs [][]int, start int) int {
+	for _, l := range locs {
+		if l[1] == start {
+			return l[0]
+		}
+	}
+	return start
+}
@@ -0,0 +1,67 @@
+package nlp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStructuredInputSegment(t *testing.T) {
+	at7 := time.Date(2016, 5, 20, 19, 0, 0, 0, time.UTC)
+	si := &StructuredInput{
+		RawSentence: "Book me a table at 7 and text Sarah the address",
+		Commands:    StringSlice{"book", "text"},
+		Objects:     StringSlice{"table", "address"},
+		Actors:      StringSlice{"sarah"},
+		Participants: []Participant{
+			{Role: RoleSpeaker, UserID: 1},
+			{Role: RoleThirdParty, Mention: "Sarah", ContactID: 7},
+		},
+		DateTimes:   []DateTime{{Text: "at 7", Start: at7}},
+		ParsedTimes: []time.Time{at7},
+	}
+	segs := si.Segment()
+	if len(segs) != 2 {
+		t.Fatal("expected 2 segments, got", len(segs))
+	}
+	book, text := segs[0], segs[1]
+	if book.RawSentence != "Book me a table at 7" ||
+		text.RawSentence != "text Sarah the address" {
+		t.Fatalf("expected two clauses, got %q and %q", book.RawSentence,
+			text.RawSentence)
+	}
+	if book.Commands.Last() != "book" || text.Commands.Last() != "text" ||
+		book.Objects.Last() != "table" || len(book.Actors) != 0 ||
+		text.Actors.Last() != "sarah" {
+		t.Fatalf("expected each clause's words, got %+v and %+v", book,
+			text)
+	}
+	exp := []Participant{si.Participants[0], si.Participants[1]}
+	if !reflect.DeepEqual(exp, text.Participants) {
+		t.Fatal("expected speaker and Sarah, got", text.Participants)
+	}
+	if !reflect.DeepEqual(book.DateTimes, text.DateTimes) ||
+		!reflect.DeepEqual(si.ParsedTimes, text.ParsedTimes) {
+		t.Fatal("expected shared time, got", text.DateTimes,
+			text.ParsedTimes)
+	}
+	text.DateTimes[0].Text = "at 8"
+	text.ParsedTimes[0] = at7.Add(time.Hour)
+	if book.DateTimes[0].Text != "at 7" || !book.ParsedTimes[0].Equal(at7) {
+		t.Fatal("expected segments' times to be copied")
+	}
+}
+
+func TestStructuredInputSegmentWhole(t *testing.T) {
+	for _, sent := range []string{"book dinner and drinks", "book dinner"} {
+		si := &StructuredInput{
+			RawSentence: sent,
+			Commands:    StringSlice{"book"},
+			Objects:     StringSlice{"dinner", "drinks"},
+		}
+		if segs := si.Segment(); segs != nil {
+			t.Fatalf("%q: expected no segments, got %d", sent,
+				len(segs))
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package nlp
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Token is a word or punctuation mark in a sentence.
+type Token struct {
+	Text string
+
+	// Start and End are the byte offsets of the token in the sentence, so
+	// sent[Start:End] is what the user wrote. It may differ from Text,
+	// e.g. when a curly apostrophe is tokenized as "'".
+	Start, End int
+}
+
+// Tokenizer breaks a sentence into tokens: its words and punctuation.
+// Languages that need more than the default UnicodeTokenizer, e.g. with
+// dictionary-based segmentation, can replace it with SetTokenizer.
+type Tokenizer interface {
+	Tokenize(sent string) []Token
+}
+
+var tokenizerMu sync.RWMutex
+var tokenizer Tokenizer = &UnicodeTokenizer{}
+
+// SetTokenizer replaces the tokenizer used by TokenizeSentence. It should be
+// called before Abot begins processing messages, e.g. in a plugin's init.
+func SetTokenizer(t Tokenizer) {
+	tokenizerMu.Lock()
+	defer tokenizerMu.Unlock()
+	tokenizer = t
+}
+
+// UnicodeTokenizer is the default Tokenizer. Words are broken on spaces and
+// punctuation in any script, so "¿Qué tal?" becomes "¿", "Qué", "tal", "?".
+// Hyphenated words stay whole, as do numbers like "3.5," "1,000" and "7:30."
+// Apostrophes are split off, whether straight or curly, so "I’m" becomes "I",
+// "'", "m" and matches the same dictionaries as "I'm." Symbols like "$" and
+// "@" remain part of their words.
+//
+// Scripts written without spaces between words, like Chinese, Japanese and
+// Thai, are passed in runs to Segment, which returns the run's words in
+// order. By default each character in a run becomes its own token.
+type UnicodeTokenizer struct {
+	Segment func(run string) []string
+}
+
+// Tokenize breaks a sentence into tokens.
+func (t *UnicodeTokenizer) Tokenize(sent string) []Token {
+	tokens := []Token{}
+	// at holds the byte offset of each rune, and of the sentence's end.
+	var rs []rune
+	var at []int
+	for i, r := range sent {
+		rs = append(rs, r)
+		at = append(at, i)
+	}
+	at = append(at, len(sent))
+	var word []rune
+	var wordStart, wordEnd, runStart, runEnd int
+	var inRun bool
+	flush := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, Token{Text: string(word),
+				Start: wordStart, End: wordEnd})
+			word = word[:0]
+		}
+		if inRun {
+			tokens = append(tokens, t.segment(sent, runStart,
+				runEnd)...)
+			inRun = false
+		}
+	}
+	addWord := func(i int) {
+		if len(word) == 0 {
+			wordStart = at[i]
+		}
+		word = append(word, rs[i])
+		wordEnd = at[i+1]
+	}
+	for i, r := range rs {
+		var prev, next rune
+		if i > 0 {
+			prev = rs[i-1]
+		}
+		if i+1 < len(rs) {
+			next = rs[i+1]
+		}
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case r == '\u00ad':
+			// Soft hyphens only mark where a word may break.
+		case unsegmented(r):
+			if len(word) > 0 {
+				flush()
+			}
+			if !inRun {
+				runStart, inRun = at[i], true
+			}
+			runEnd = at[i+1]
+		case unicode.IsLetter(r) || unicode.IsDigit(r) ||
+			unicode.IsMark(r):
+			if inRun {
+				flush()
+			}
+			addWord(i)
+		case r == '\'' || r == '\u2019':
+			flush()
+			tokens = append(tokens, Token{Text: "'", Start: at[i],
+				End: at[i+1]})
+		case isHyphen(r) && len(word) > 0 && isWordRune(next):
+			addWord(i)
+		case (r == '.' || r == ',' || r == ':') &&
+			unicode.IsDigit(prev) && unicode.IsDigit(next) &&
+			len(word) > 0:
+			addWord(i)
+		case unicode.IsPunct(r) && !joins(r):
+			flush()
+			tokens = append(tokens, Token{Text: string(r), Start: at[i],
+				End: at[i+1]})
+		default:
+			if inRun {
+				flush()
+			}
+			addWord(i)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// segment tokenizes the run of unsegmented script at sent[start:end].
+func (t *UnicodeTokenizer) segment(sent string, start, end int) []Token {
+	run := sent[start:end]
+	if t.Segment == nil {
+		var tokens []Token
+		for i, r := range run {
+			tokens = append(tokens, Token{Text: string(r),
+				Start: start + i, End: start + i + len(string(r))})
+		}
+		return tokens
+	}
+	var tokens []Token
+	cur := start
+	for _, w := range t.Segment(run) {
+		// Segmenters may normalize words, in which case they're given
+		// the offsets of what's left of the run.
+		tok := Token{Text: w, Start: cur, End: end}
+		if i := strings.Index(sent[cur:end], w); i >= 0 {
+			tok.Start, tok.End = cur+i, cur+i+len(w)
+		}
+		tokens = append(tokens, tok)
+		cur = tok.End
+	}
+	return tokens
+}
+
+// unsegmented reports whether a rune belongs to a script written without
+// spaces between words.
+func unsegmented(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana,
+		unicode.Thai, unicode.Lao, unicode.Khmer, unicode.Myanmar)
+}
+
+func isHyphen(r rune) bool {
+	return r == '-' || r == '\u2010' || r == '\u2011'
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// joins reports whether a punctuation rune stays part of the word it's in,
+// as in emails, hashtags and paths.
+func joins(r rune) bool {
+	switch r {
+	case '@', '#', '%', '&', '/', '_', '*', '\\':
+		return true
+	}
+	return false
+}
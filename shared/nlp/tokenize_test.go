@@ -0,0 +1,60 @@
+package nlp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnicodeTokenizerOffsets(t *testing.T) {
+	pairs := func(run string) []string {
+		var ws []string
+		rs := []rune(run)
+		for i := 0; i < len(rs); i += 2 {
+			end := i + 2
+			if end > len(rs) {
+				end = len(rs)
+			}
+			ws = append(ws, string(rs[i:end]))
+		}
+		return ws
+	}
+	tests := []struct {
+		sent    string
+		segment func(string) []string
+		texts   []string
+		written []string
+	}{
+		{"I’m here", nil,
+			[]string{"I", "'", "m", "here"},
+			[]string{"I", "’", "m", "here"}},
+		{"a well-known café", nil,
+			[]string{"a", "well-known", "café"},
+			[]string{"a", "well-known", "café"}},
+		{"add 3.5 cups, please", nil,
+			[]string{"add", "3.5", "cups", ",", "please"},
+			[]string{"add", "3.5", "cups", ",", "please"}},
+		{"我爱北京 ok", nil,
+			[]string{"我", "爱", "北", "京", "ok"},
+			[]string{"我", "爱", "北", "京", "ok"}},
+		{"去北京吗?", pairs,
+			[]string{"去北", "京吗", "?"},
+			[]string{"去北", "京吗", "?"}},
+	}
+	for _, test := range tests {
+		tok := &UnicodeTokenizer{Segment: test.segment}
+		tokens := tok.Tokenize(test.sent)
+		var texts, written []string
+		for _, tk := range tokens {
+			texts = append(texts, tk.Text)
+			written = append(written, test.sent[tk.Start:tk.End])
+		}
+		if !reflect.DeepEqual(test.texts, texts) {
+			t.Errorf("%q: expected tokens %q, got %q", test.sent,
+				test.texts, texts)
+		}
+		if !reflect.DeepEqual(test.written, written) {
+			t.Errorf("%q: expected offsets of %q, got %q", test.sent,
+				test.written, written)
+		}
+	}
+}
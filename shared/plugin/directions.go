@@ -0,0 +1,52 @@
+package plugin
+
+import (
+	"time"
+
+	"github.com/itsabot/abot/core"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/directions/driver"
+)
+
+// Waypoint returns the start or end of a trip described by the user, like
+// "home," "work," "here" or an address. If the user refers to their location
+// but it isn't known, dt.ErrNoLocation is returned, and the plugin should ask
+// the user where they are.
+func Waypoint(u *dt.User, s string) (*driver.Waypoint, error) {
+	return core.Waypoint(u, s)
+}
+
+// ETA estimates how long it takes to travel between two waypoints leaving
+// now.
+func ETA(from, to *driver.Waypoint, mode driver.Mode) (time.Duration,
+	error) {
+
+	return core.ETA(from, to, mode)
+}
+
+// LeaveBy returns when a user should leave one waypoint to arrive at another
+// by a given time, with a few minutes to spare.
+func LeaveBy(from, to *driver.Waypoint, mode driver.Mode,
+	arrive time.Time) (time.Time, error) {
+
+	return core.LeaveBy(from, to, mode, arrive)
+}
+
+// DescribeLeaveBy tells a user when to leave for an appointment, e.g. "Leave
+// by 2:40 to make your 3pm."
+func DescribeLeaveBy(leave, arrive time.Time) string {
+	a := clock(arrive)
+	l := leave.Format("3:04")
+	if leave.Format("pm") != arrive.Format("pm") {
+		l = leave.Format("3:04pm")
+	}
+	return "Leave by " + l + " to make your " + a + "."
+}
+
+// clock writes a time of day briefly, e.g. "3pm" or "3:30pm."
+func clock(t time.Time) string {
+	if t.Minute() == 0 {
+		return t.Format("3pm")
+	}
+	return t.Format("3:04pm")
+}
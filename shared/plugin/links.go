@@ -0,0 +1,43 @@
+package plugin
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/itsabot/abot/core"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// Link returns a short link to target for a response to a message, e.g. to a
+// checkout page. Clicks are tracked against the message, which operators can
+// see in the admin console.
+func Link(p *dt.Plugin, in *dt.Msg, target string) (string, error) {
+	return core.ShortLink(in.User, in.ID, p.Config.Name, target)
+}
+
+// MapLink returns a short link to a place on a map.
+func MapLink(p *dt.Plugin, in *dt.Msg, lat, lon float64) (string, error) {
+	v := url.Values{}
+	v.Set("api", "1")
+	v.Set("query", fmt.Sprintf("%f,%f", lat, lon))
+	target := "https://www.google.com/maps/search/?" + v.Encode()
+	return Link(p, in, target)
+}
+
+// CalendarLink returns a short link that adds an event to the user's
+// calendar.
+func CalendarLink(p *dt.Plugin, in *dt.Msg, title, location string, start,
+	end time.Time) (string, error) {
+
+	const layout = "20060102T150405Z"
+	v := url.Values{}
+	v.Set("action", "TEMPLATE")
+	v.Set("text", title)
+	v.Set("dates", start.UTC().Format(layout)+"/"+end.UTC().Format(layout))
+	if len(location) > 0 {
+		v.Set("location", location)
+	}
+	target := "https://calendar.google.com/calendar/render?" + v.Encode()
+	return Link(p, in, target)
+}
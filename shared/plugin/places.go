@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/itsabot/abot/core"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/places/driver"
+)
+
+// NearbySearch finds places matching a keyword, like "coffee," near the
+// location the user most recently shared, nearest first. If the user's
+// location isn't known, dt.ErrNoLocation is returned, and the plugin should
+// ask the user where they are.
+func NearbySearch(u *dt.User, keyword string, limit int) ([]driver.Place,
+	error) {
+
+	return core.NearbySearch(u, keyword, limit)
+}
+
+// ResolveHere returns the location a user means by "near me" or "around
+// here," which nlp.ExtractHere finds in their message. If their location isn't
+// known or has gone stale, it's nil, and the plugin should reply with the
+// prompt, which asks the user to share it.
+func ResolveHere(u *dt.User) (*dt.Location, string, error) {
+	return core.ResolveHere(u)
+}
+
+// NearbySearchAt finds places matching a keyword near a location, nearest
+// first.
+func NearbySearchAt(l *dt.Location, keyword string, limit int) (
+	[]driver.Place, error) {
+
+	return core.NearbySearchAt(l, keyword, limit)
+}
+
+// DescribePlace summarizes a place for a user at time t with whatever the
+// places service knows about it, e.g. "Blue Bottle (4.5 stars, 0.3 miles
+// away, open now)."
+func DescribePlace(p *driver.Place, t time.Time) string {
+	var details []string
+	if p.Rating > 0 {
+		details = append(details, fmt.Sprintf("%.1f stars", p.Rating))
+	}
+	if p.DistanceInMeters > 0 {
+		details = append(details, fmt.Sprintf("%.1f miles away",
+			float64(p.DistanceInMeters)/1609.344))
+	}
+	if open, known := p.OpenAt(t); known {
+		if open {
+			details = append(details, "open now")
+		} else {
+			details = append(details, "closed now")
+		}
+	} else if p.OpenNow.Valid {
+		if p.OpenNow.Bool {
+			details = append(details, "open now")
+		} else {
+			details = append(details, "closed now")
+		}
+	}
+	if len(details) == 0 {
+		return p.Name
+	}
+	return p.Name + " (" + strings.Join(details, ", ") + ")"
+}
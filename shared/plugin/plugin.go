@@ -9,10 +9,12 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/itsabot/abot/core"
 	"github.com/itsabot/abot/core/log"
 	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/emailsender"
 	"github.com/itsabot/abot/shared/nlp"
 	_ "github.com/lib/pq" // Import the pq PostgreSQL driver
 )
@@ -79,22 +81,396 @@ func New(url string, trigger *nlp.StructuredInput,
 }
 
 // RegisterPlugin enables Abot to notify plugins when specific StructuredInput
-// is encountered matching triggers set in the plugins themselves. Note that
-// plugins will only listen when ALL criteria are met and that there's no
-// support currently for duplicate routes (e.g. "find_restaurant" leading to
-// either one of two plugins).
+// is encountered matching triggers set in the plugins themselves. Each
+// command and object pair of the trigger is registered as an intent named
+// like "find_restaurant," which a message triggers with both its command and
+// its object. Plugins can declare richer intents with Intents.
 func RegisterPlugin(p *dt.Plugin) error {
 	log.Debug("registering", p.Config.Name)
+	var intents []dt.Intent
 	for _, c := range p.Trigger.Commands {
 		for _, o := range p.Trigger.Objects {
-			s := strings.ToLower(c + "_" + o)
-			if core.RegPlugins.Get(s) != nil {
-				log.Info("found duplicate plugin or trigger",
-					p.Config.Name, "on", s)
-			}
-			core.RegPlugins.Set(s, p)
+			intents = append(intents, dt.Intent{
+				Name:     strings.ToLower(c + "_" + o),
+				Commands: []string{c},
+				Objects:  []string{o},
+			})
 		}
 	}
+	if err := core.RegisterIntents(p, intents...); err != nil {
+		return err
+	}
+	dt.RequireRoles(p.Config.Name, p.Config.Roles...)
 	core.AllPlugins = append(core.AllPlugins, p)
 	return nil
 }
+
+// Intents declares the intents the plugin handles, like booking a table, with
+// the commands, objects and example sentences expressing each, its priority
+// and the conversations it's likeliest in. Messages are routed to the plugin
+// when one of its intents scores best. See dt.Intent.
+func Intents(p *dt.Plugin, intents ...dt.Intent) error {
+	return core.RegisterIntents(p, intents...)
+}
+
+// Every runs fn at a regular interval on Abot's scheduler, which is useful for
+// polling external services for updates. Errors returned by fn are logged
+// with the plugin's name.
+func Every(p *dt.Plugin, interval time.Duration, fn func() error) {
+	core.RegisterJob(p.Config.Name, interval, fn)
+}
+
+// CacheResponses caches the plugin's replies to identical messages for ttl,
+// e.g. 10 minutes for the weather, cutting the latency and spend of external
+// APIs. Only opt in for idempotent queries, since cached replies are sent
+// without running the plugin. key identifies identical messages; nil treats a
+// user's messages with the same words as identical. See core.CacheResponses.
+func CacheResponses(p *dt.Plugin, ttl time.Duration,
+	key func(in *dt.Msg) string) {
+
+	core.CacheResponses(p, ttl, key)
+}
+
+// Credential returns the plugin's credential for an external API, like an API
+// key, stored for this tenant by an operator or SetCredential. Use it instead
+// of reading keys from environment variables. See core.Credential.
+func Credential(p *dt.Plugin, name string) (string, error) {
+	return core.Credential(p, name)
+}
+
+// PreviousCredential returns the credential's value before it was last
+// rotated.
+func PreviousCredential(p *dt.Plugin, name string) (string, error) {
+	return core.PreviousCredential(p, name)
+}
+
+// SetCredential stores the plugin's credential for this tenant, encrypted.
+func SetCredential(p *dt.Plugin, name, value string) error {
+	return core.SetCredential(p, name, value)
+}
+
+// RotateCredential replaces the plugin's credential with the one fn returns
+// given the current value, e.g. a new API key requested from the provider.
+// Combine it with Every to rotate keys on a schedule.
+func RotateCredential(p *dt.Plugin, name string,
+	fn func(current string) (string, error)) error {
+
+	return core.RotateCredential(p, name, fn)
+}
+
+// Metric adds a value to Abot's analytics, such as the plugin's average
+// rating, computed by fn whenever analytics are viewed. The metric is named
+// after the plugin, e.g. "survey_nps".
+func Metric(p *dt.Plugin, name string, fn func() (interface{}, error)) {
+	core.RegisterMetric(p.Config.Name+"_"+name, fn)
+}
+
+// Segment adds a rule-based segment of users, such as those who ordered in the
+// past 30 days, which operators can query like any other tag. The segment is
+// named after the plugin, e.g. "ride_riders_last_30_days."
+func Segment(p *dt.Plugin, name string, fn func() ([]uint64, error)) {
+	core.RegisterSegment(p.Config.Name+"_"+name, fn)
+}
+
+// Respond formats a response with buttons for the channel the user is on,
+// e.g. a question with "Yes" and "No" buttons. Buttons are listed by number
+// up to the channel's limit, so users can reply "2" or "the second one." Use
+// Markdown and emoji freely; they're stripped on channels that can't show
+// them.
+func Respond(in *dt.Msg, text string, buttons ...string) string {
+	return core.RenderFor(in.User, &dt.Response{Text: text,
+		Buttons: buttons})
+}
+
+// SendProgress sends the user an interim update while the plugin works on a
+// slow request, like "Searching flights...," before it replies.
+func SendProgress(in *dt.Msg, text string) error {
+	return core.SendProgress(in, text)
+}
+
+// SetSentimentAnalyzer replaces how Abot scores the sentiment of messages,
+// e.g. with a machine learning model, for every plugin. See
+// nlp.StructuredInput.Sentiment.
+func SetSentimentAnalyzer(a core.SentimentAnalyzer) {
+	core.SetSentimentAnalyzer(a)
+}
+
+// Stage adds a custom stage to the message pipeline, running after the stage
+// named by after, e.g. core.StageResolve. The stage is named after the
+// plugin, e.g. "flights_airport_codes." See core.Pipeline.
+func Stage(p *dt.Plugin, name, after string, fn core.Stage) {
+	core.RegisterStage(p.Config.Name+"_"+name, after, fn)
+}
+
+// Fallback adds a handler to the fallback chain, which answers messages no
+// plugin claimed, e.g. with a knowledge base lookup. It runs after the handler
+// named by after, e.g. core.FallbackSmallTalk, or just before Abot suggests
+// intents if that's empty. The handler is named after the plugin, e.g.
+// "faq_lookup." See core.FallbackChain.
+func Fallback(p *dt.Plugin, name, after string, h core.FallbackHandler) {
+	core.RegisterFallback(p.Config.Name+"_"+name, after, h)
+}
+
+// ShadowParser runs a candidate parser, such as a newly trained model, in
+// shadow alongside the live one without affecting any responses. Operators
+// can compare how each would have routed messages in the admin console. The
+// parser is named after the plugin, e.g. "ner_v2_classifier."
+func ShadowParser(p *dt.Plugin, name string, fn core.Parser) {
+	core.RegisterShadowParser(p.Config.Name+"_"+name, fn)
+}
+
+// Entities registers a list of the plugin's domain entities, like restaurant
+// names or wine labels, of a type such as "restaurant," so each is recognized
+// as a single word however many it has. People and businesses,
+// nlp.EntityPerson and nlp.EntityBusiness, become Actors, and other types
+// Objects. See nlp.StructuredInput.Entities.
+func Entities(p *dt.Plugin, typ string, names []string) {
+	core.RegisterEntities(typ, names)
+}
+
+// StartVerification creates a one-time code for the plugin to send the user,
+// e.g. by SMS to confirm their phone number. When the user sends the code
+// back, their message continues the conversation on the route of the message
+// passed in, with the code in StructuredInput.Code. Check it with VerifyCode.
+func StartVerification(p *dt.Plugin, in *dt.Msg) (string, error) {
+	return core.StartVerification(in.User.ID, p.Config.Name, in.Route)
+}
+
+// VerifyCode checks the one-time code in a message against the one the plugin
+// awaits. Users get five tries before the code is cancelled, after which
+// core.ErrNoVerification is returned. Messages without a code return false.
+func VerifyCode(p *dt.Plugin, in *dt.Msg) (bool, error) {
+	if len(in.StructuredInput.Code) == 0 {
+		return false, nil
+	}
+	return core.VerifyCode(in.User.ID, p.Config.Name,
+		in.StructuredInput.Code)
+}
+
+// StartLink sends a one-time code to another of the user's channels, like
+// the email address they gave while texting, to confirm it's theirs. When the
+// user sends the code back, their message continues the conversation on the
+// route of the message passed in. Link the channel with ConfirmLink.
+func StartLink(p *dt.Plugin, in *dt.Msg, fid string,
+	fidT dt.FlexIDType) error {
+
+	return core.StartLink(in.User.ID, p.Config.Name, in.Route, fid, fidT)
+}
+
+// ConfirmLink checks the one-time code in a message against the one sent by
+// StartLink and, if it's right, links the channel to the user, merging in any
+// user Abot already knew there. Messages without a code return false.
+func ConfirmLink(p *dt.Plugin, in *dt.Msg) (bool, error) {
+	if len(in.StructuredInput.Code) == 0 {
+		return false, nil
+	}
+	return core.ConfirmLink(in.User.ID, p.Config.Name,
+		in.StructuredInput.Code)
+}
+
+// UsersTagged returns the IDs of users with a tag, whether it was applied
+// manually with dt.User.AddTag or is a rule-based segment.
+func UsersTagged(tag string) ([]uint64, error) {
+	return core.UsersTagged(tag)
+}
+
+// LabelConversation marks the conversation a message belongs to for
+// operators, e.g. "billing_issue" when a user disputes a charge.
+func LabelConversation(p *dt.Plugin, in *dt.Msg, label string) error {
+	return core.LabelConversation(in.User.ID, in.ID, label, p.Config.Name)
+}
+
+// Escalate hands the user's conversation to a person, e.g. when a plugin
+// can't resolve a dispute. Operators are notified and reply to the user
+// directly until they release the conversation back to Abot. See
+// core.Escalate.
+func Escalate(p *dt.Plugin, in *dt.Msg, reason string) error {
+	_, err := core.Escalate(in.User.ID, "", p.Config.Name+": "+reason)
+	return err
+}
+
+// Remember saves something the plugin learned about a user, like "Flying to
+// Denver on June 3," so it or other plugins can recall it later.
+func Remember(p *dt.Plugin, u *dt.User, content string) error {
+	return core.SaveFact(u, p.Config.Name, content)
+}
+
+// Recall finds facts about a user related to a query, like "travel plans,"
+// most related first. Facts are matched by meaning when an embedding driver is
+// imported and by keyword otherwise.
+func Recall(u *dt.User, query string, limit int) ([]dt.Fact, error) {
+	return core.SearchFacts(u, query, limit)
+}
+
+// Mention puts something the plugin mentioned to a user in the conversation's
+// context, like the restaurant it recommended as nlp.PlaceI, so the user can
+// refer to it by a pronoun, as in "book a table there." See
+// nlp.StructuredInput.References.
+func Mention(u *dt.User, class nlp.SIT, entity string) error {
+	cs := dt.NewContextStore(core.DB(), u.ID, u.FlexIDType)
+	return cs.Remember(class, entity)
+}
+
+// FlexIDType adds a FlexIDType for a channel the plugin connects Abot to, like
+// Slack or Telegram, so its users are recognized across channels. Link a
+// channel's IDs to existing users with dt.User.LinkFlexID.
+func FlexIDType(def dt.FlexIDTypeDef) (dt.FlexIDType, error) {
+	return dt.RegisterFlexIDType(core.DB(), def)
+}
+
+// Email returns Abot's connection to the email service, so plugins share it
+// rather than opening their own, or nil if no email driver has been imported.
+func Email() *emailsender.Conn {
+	return core.Email()
+}
+
+// RecordSpend meters money the plugin spent on an external API on a user's
+// behalf, in millionths of a dollar, so operators can bill for usage. For
+// example, record 5000 after a search costing $0.005.
+func RecordSpend(p *dt.Plugin, u *dt.User, micros int64, note string) error {
+	return core.RecordSpend(p.Config.Name, u.ID, micros, note)
+}
+
+// RecordCost records a cost the plugin incurred responding to a user's
+// message, like the tokens sent to a language model as core.CostLLMToken or
+// seconds of speech transcribed as core.CostSTTSecond, so operators can see
+// what each feature costs. It's priced by the cost_{kind}_micros setting.
+func RecordCost(p *dt.Plugin, in *dt.Msg, kind string, quantity int64,
+	note string) error {
+
+	c := &core.Cost{
+		Kind:      kind,
+		Quantity:  quantity,
+		MessageID: in.ID,
+		Plugin:    p.Config.Name,
+		Note:      note,
+	}
+	if in.User != nil {
+		c.UserID = in.User.ID
+	}
+	return core.RecordCost(c)
+}
+
+// RecordIntent records that the plugin carried out a transactional intent for
+// a user, like "book flight UA100 on 2016-05-01." Call it after the action
+// succeeds, so DuplicateIntent or a RequestDuplicateConfirmation task can
+// catch the same request arriving twice.
+func RecordIntent(p *dt.Plugin, u *dt.User, key string) error {
+	return core.RecordIntent(u.ID, p.Config.Name, key)
+}
+
+// DuplicateIntent returns when the plugin last carried out an intent for a
+// user within a window, or nil if it hasn't, to avoid double-executing
+// actions like bookings and purchases.
+func DuplicateIntent(p *dt.Plugin, u *dt.User, key string,
+	window time.Duration) (*time.Time, error) {
+
+	return core.LastIntent(u.ID, p.Config.Name, key, window)
+}
+
+// SetTimeContext records that the user just set a time for an event, like a
+// reservation at 6pm, labeled for the plugin to find it again. A follow-up
+// with only a time, like "actually make it 7pm," is then routed back to the
+// plugin with StructuredInput.OnlyTime set, even after the conversation has
+// moved on.
+func SetTimeContext(p *dt.Plugin, in *dt.Msg, label string,
+	t time.Time) error {
+
+	return core.SetTimeContext(in.User.ID, p.Config.Name, in.Route, label, t)
+}
+
+// TimeContext returns the event the user most recently set a time for, or
+// nil if there isn't one.
+func TimeContext(u *dt.User) (*dt.TimeContext, error) {
+	return core.GetTimeContext(u.ID)
+}
+
+// HandleQuestions routes questions that don't trigger any plugin to p, like
+// "Who wrote Moby Dick?" Only one plugin can handle these questions, so the
+// most recently registered plugin wins.
+func HandleQuestions(p *dt.Plugin) {
+	core.RegPlugins.Set(core.RouteQuestion, p)
+}
+
+// Digests collects the digests published by every plugin for a user, skipping
+// plugins without a Digest function and logging any errors.
+func Digests(u *dt.User) []string {
+	var ds []string
+	for _, p := range core.AllPlugins {
+		if p.PluginFns == nil || p.Digest == nil {
+			continue
+		}
+		d, err := p.Digest(u)
+		if err != nil {
+			p.Log.Info("failed to build digest", err)
+			continue
+		}
+		if len(d) > 0 {
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
+// RequireAuthWithin demands that users authenticated within d for the
+// plugin, e.g. 15 minutes for a plugin changing addresses. Check it with
+// Authenticated.
+func RequireAuthWithin(p *dt.Plugin, d time.Duration) {
+	core.RequireAuthWithin(p.Config.Name, d)
+}
+
+// Authenticated reports whether the user authenticated recently enough for
+// the plugin under the AuthPolicy. If they haven't, challenge them with
+// dt.ChallengeUser.
+func Authenticated(p *dt.Plugin, u *dt.User) bool {
+	return u.IsAuthenticated(core.AuthPolicy(), p.Config.Name, 0)
+}
+
+// Audit records a sensitive action the plugin took in response to a user's
+// message, like changing their preferences, in the audit log. Actions are
+// those of core, e.g. core.AuditPreference.
+func Audit(p *dt.Plugin, in *dt.Msg, action, detail string) {
+	e := core.AuditEntry{
+		Action:   action,
+		Detail:   p.Config.Name + ": " + detail,
+		Sentence: in.Sentence,
+	}
+	if in.User != nil {
+		e.UserID = in.User.ID
+		e.FlexID, e.FlexIDType = in.User.FlexID, in.User.FlexIDType
+	}
+	core.Audit(e)
+}
+
+// Charge a user's primary card, sending the user a receipt. The amount is in
+// cents. If the user has no card on file, dt.ErrNoCard is returned, and the
+// plugin should respond with RequestCard. If the user must authenticate
+// first, dt.ErrAuthRequired is returned.
+func Charge(u *dt.User, amountInCents uint64, desc string) (*dt.Charge,
+	error) {
+
+	return core.Charge(u, amountInCents, desc)
+}
+
+// Refund some or all of a charge. Pass an amount of 0 to refund whatever
+// remains of the charge.
+func Refund(chargeID, amountInCents uint64) error {
+	return core.Refund(chargeID, amountInCents)
+}
+
+// RequestCard returns a response asking the user to add a card through a
+// secure link. Users who haven't signed up are asked to do so first. Once the
+// card is added, Abot lets the user know, and the plugin can retry the charge
+// when the user replies.
+func RequestCard(u *dt.User) (string, error) {
+	if !u.Registered() {
+		return "To do that, you'll need an account first. You can sign up here: " +
+			os.Getenv("ABOT_URL") + "/signup", nil
+	}
+	url, err := core.CardURL(u)
+	if err != nil {
+		return "", err
+	}
+	return "To do that, I'll need a card on file. You can add one securely here: " +
+		url + ". Let me know when you're done!", nil
+}
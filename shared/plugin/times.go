@@ -0,0 +1,19 @@
+package plugin
+
+import (
+	"time"
+
+	"github.com/itsabot/abot/core"
+	"github.com/itsabot/abot/shared/datatypes"
+)
+
+// ResolveFuzzyTime returns the range of time a user means by a fuzzy time in
+// their message, like "this evening," "tomorrow morning" or "after work,"
+// using the day parts they've defined, e.g. by telling Abot "my evening is
+// from 6pm to 10pm," or the defaults otherwise. The range is nil if the
+// message holds no fuzzy time.
+func ResolveFuzzyTime(u *dt.User, s string, now time.Time) (*dt.TimeRange,
+	error) {
+
+	return core.ResolveFuzzyTime(u, s, now)
+}
@@ -0,0 +1,440 @@
+// Package wasm runs third-party plugins compiled to WebAssembly in a sandbox,
+// so operators can install plugins without trusting their code. A WASM plugin
+// can't touch the filesystem, network, database or environment except through
+// the host functions its plugin.json asks for and the operator grants.
+//
+// Import the package to enable it:
+//
+//	import _ "github.com/itsabot/abot/shared/plugin/wasm"
+//
+// Each plugin is a directory in the wasm directory of the Abot project holding
+// a plugin.json and a plugin.wasm, e.g. wasm/weather. Besides the usual
+// Name, Icon and Type, plugin.json lists the plugin's trigger and the
+// capabilities it needs:
+//
+//	{
+//		"Name": "weather",
+//		"Commands": ["what", "show"],
+//		"Objects": ["weather", "forecast"],
+//		"Capabilities": ["memory", "http"],
+//		"Hosts": ["api.weather.gov"]
+//	}
+//
+// Plugins export alloc(size i32) i32, which returns memory Abot may write
+// size bytes to, run(ptr, len i32) i64 and follow_up(ptr, len i32) i64. Abot
+// passes run and follow_up the message as JSON, like {"UserID": 1,
+// "Sentence": "what's the weather?", "Commands": ["what"], "Objects":
+// ["weather"]}, and they return their reply as its pointer in the high 32 bits
+// and its length in the low. Host functions are imported from the "abot"
+// module and exchange strings the same way:
+//
+//	log(ptr, len i32)                         always available
+//	get_memory(kptr, klen i32) i64            "memory"
+//	set_memory(kptr, klen, vptr, vlen i32)    "memory"
+//	http_get(uptr, ulen i32) i64              "http", only to Hosts
+//
+// Memories are JSON, kept per user like those of dt.StateMachine. A plugin
+// importing a host function it wasn't granted fails to load. Every message
+// runs in a fresh instance of the plugin, limited in memory and time.
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/itsabot/abot/core"
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/nlp"
+	"github.com/itsabot/abot/shared/plugin"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Capabilities a plugin may ask for in its plugin.json.
+const (
+	// CapMemory lets a plugin remember things about each user.
+	CapMemory = "memory"
+
+	// CapHTTP lets a plugin make GET requests to the hosts listed in its
+	// plugin.json.
+	CapHTTP = "http"
+)
+
+// maxMemoryPages limits each instance of a plugin to 16 MiB of memory.
+const maxMemoryPages = 256
+
+// callTimeout is the longest a plugin may take to reply to a message,
+// including its HTTP requests, after which it's stopped.
+const callTimeout = 10 * time.Second
+
+// maxHTTPBody is the most of a response body passed to a plugin by http_get.
+const maxHTTPBody = 1 << 20
+
+// ErrMissingExport is returned when a plugin doesn't export a function Abot
+// calls.
+var ErrMissingExport = errors.New("missing wasm export")
+
+// ErrUnknownCapability is returned when a plugin asks for a capability that
+// doesn't exist.
+var ErrUnknownCapability = errors.New("unknown capability")
+
+// Config is a WASM plugin's plugin.json.
+type Config struct {
+	dt.PluginConfig
+
+	// Commands and Objects trigger the plugin. See nlp.StructuredInput.
+	Commands []string
+	Objects  []string
+
+	// Capabilities are the host functions the plugin may import beyond
+	// log, e.g. CapMemory.
+	Capabilities []string
+
+	// Hosts are the only hosts the plugin may request with CapHTTP.
+	Hosts []string
+}
+
+// request is a message passed to a plugin.
+type request struct {
+	UserID   uint64
+	Sentence string
+	Commands []string
+	Objects  []string
+	Followup bool
+}
+
+// msgKey is the context key of the message a plugin's replying to, for host
+// functions.
+type msgKey struct{}
+
+// wasmPlugin is a loaded WASM plugin.
+type wasmPlugin struct {
+	cfg  Config
+	p    *dt.Plugin
+	sm   *dt.StateMachine
+	rt   wazero.Runtime
+	code wazero.CompiledModule
+
+	// client makes the plugin's HTTP requests, following redirects only
+	// to the hosts the plugin may reach.
+	client *http.Client
+}
+
+func init() {
+	if len(os.Getenv("ABOT_PATH")) == 0 {
+		return
+	}
+	dir := filepath.Join(os.Getenv("ABOT_PATH"), "wasm")
+	fis, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Info("failed to read wasm plugins.", err)
+		return
+	}
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		// A broken third-party plugin shouldn't keep Abot from
+		// booting.
+		if _, err = Load(filepath.Join(dir, fi.Name())); err != nil {
+			log.Info("failed to load wasm plugin", fi.Name(), err)
+		}
+	}
+}
+
+// Load compiles the WASM plugin in a directory and registers it with Abot.
+func Load(dir string) (*dt.Plugin, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "plugin.json"))
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err = json.Unmarshal(contents, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Name) == 0 {
+		return nil, plugin.ErrMissingPluginName
+	}
+	if len(cfg.Commands) == 0 || len(cfg.Objects) == 0 {
+		return nil, plugin.ErrMissingTrigger
+	}
+	bin, err := ioutil.ReadFile(filepath.Join(dir, "plugin.wasm"))
+	if err != nil {
+		return nil, err
+	}
+	db, err := core.ConnectDB()
+	if err != nil {
+		return nil, err
+	}
+	wp := &wasmPlugin{cfg: cfg}
+	wp.client = &http.Client{CheckRedirect: wp.checkRedirect}
+	wp.p = &dt.Plugin{
+		Config: cfg.PluginConfig,
+		Trigger: &nlp.StructuredInput{
+			Commands: cfg.Commands,
+			Objects:  cfg.Objects,
+		},
+		DB:  db,
+		Log: log.New(cfg.Name),
+		PluginFns: &dt.PluginFns{
+			Run:      wp.caller("run"),
+			FollowUp: wp.caller("follow_up"),
+		},
+		Events: &dt.PluginEvents{
+			PostReceive:    func(cmd *string) {},
+			PreProcessing:  func(cmd *string, u *dt.User) {},
+			PostProcessing: func(in *dt.Msg) {},
+			PostResponse:   func(in *dt.Msg, resp *string) {},
+		},
+	}
+	wp.p.Log.SetDebug(os.Getenv("ABOT_DEBUG") == "true")
+	wp.sm = dt.NewStateMachine(wp.p)
+	if err = wp.compile(bin); err != nil {
+		return nil, err
+	}
+	if err = plugin.RegisterPlugin(wp.p); err != nil {
+		return nil, err
+	}
+	return wp.p, nil
+}
+
+// compile prepares a plugin's runtime with the host functions it was granted,
+// checking that it can be instantiated and exports what Abot calls.
+func (wp *wasmPlugin) compile(bin []byte) error {
+	ctx := context.Background()
+	wp.rt = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(maxMemoryPages).
+		WithCloseOnContextDone(true))
+	// WASI lets plugins built by common toolchains start. Without mounts,
+	// environment variables or a real clock it exposes nothing of the
+	// host.
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, wp.rt); err != nil {
+		return err
+	}
+	host := wp.rt.NewHostModuleBuilder("abot")
+	host.NewFunctionBuilder().WithFunc(wp.log).Export("log")
+	for _, c := range wp.cfg.Capabilities {
+		switch c {
+		case CapMemory:
+			host.NewFunctionBuilder().WithFunc(wp.getMemory).
+				Export("get_memory")
+			host.NewFunctionBuilder().WithFunc(wp.setMemory).
+				Export("set_memory")
+		case CapHTTP:
+			host.NewFunctionBuilder().WithFunc(wp.httpGet).
+				Export("http_get")
+		default:
+			return fmt.Errorf("%s: %q", ErrUnknownCapability, c)
+		}
+	}
+	if _, err := host.Instantiate(ctx); err != nil {
+		return err
+	}
+	var err error
+	wp.code, err = wp.rt.CompileModule(ctx, bin)
+	if err != nil {
+		return err
+	}
+	// Instantiating fails if the plugin imports host functions it wasn't
+	// granted.
+	mod, err := wp.instantiate(ctx)
+	if err != nil {
+		return err
+	}
+	return mod.Close(ctx)
+}
+
+// instantiate starts a fresh instance of the plugin, so no state leaks
+// between messages or users.
+func (wp *wasmPlugin) instantiate(ctx context.Context) (api.Module, error) {
+	mod, err := wp.rt.InstantiateModule(ctx, wp.code,
+		wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range []string{"alloc", "run", "follow_up"} {
+		if mod.ExportedFunction(fn) == nil {
+			_ = mod.Close(ctx)
+			return nil, fmt.Errorf("%s: %s", ErrMissingExport, fn)
+		}
+	}
+	return mod, nil
+}
+
+// caller returns a plugin function replying to messages with one of the
+// plugin's exports.
+func (wp *wasmPlugin) caller(fn string) func(in *dt.Msg) (string, error) {
+	return func(in *dt.Msg) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			callTimeout)
+		defer cancel()
+		ctx = context.WithValue(ctx, msgKey{}, in)
+		mod, err := wp.instantiate(ctx)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = mod.Close(ctx) }()
+		req := request{Sentence: in.Sentence, Followup: in.Followup}
+		if in.User != nil {
+			req.UserID = in.User.ID
+		}
+		if in.StructuredInput != nil {
+			req.Commands = in.StructuredInput.Commands
+			req.Objects = in.StructuredInput.Objects
+		}
+		byt, err := json.Marshal(req)
+		if err != nil {
+			return "", err
+		}
+		ptr := write(ctx, mod, byt)
+		if ptr == 0 {
+			return "", errors.New("failed to pass message to plugin")
+		}
+		res, err := mod.ExportedFunction(fn).Call(ctx, ptr>>32,
+			ptr&0xffffffff)
+		if err != nil {
+			return "", err
+		}
+		out, ok := read(mod, uint32(res[0]>>32), uint32(res[0]))
+		if !ok {
+			return "", errors.New("plugin replied out of bounds")
+		}
+		return string(out), nil
+	}
+}
+
+func (wp *wasmPlugin) log(ctx context.Context, m api.Module, ptr, n uint32) {
+	if byt, ok := read(m, ptr, n); ok {
+		wp.p.Log.Info(string(byt))
+	}
+}
+
+func (wp *wasmPlugin) getMemory(ctx context.Context, m api.Module, kptr,
+	klen uint32) uint64 {
+
+	in, _ := ctx.Value(msgKey{}).(*dt.Msg)
+	k, ok := read(m, kptr, klen)
+	if !ok || in == nil || in.User == nil {
+		return 0
+	}
+	return write(ctx, m, wp.sm.GetMemory(in, string(k)).Val)
+}
+
+func (wp *wasmPlugin) setMemory(ctx context.Context, m api.Module, kptr, klen,
+	vptr, vlen uint32) {
+
+	in, _ := ctx.Value(msgKey{}).(*dt.Msg)
+	k, ok := read(m, kptr, klen)
+	if !ok || in == nil || in.User == nil {
+		return
+	}
+	v, ok := read(m, vptr, vlen)
+	if !ok || !json.Valid(v) {
+		wp.p.Log.Info("invalid memory for key", string(k))
+		return
+	}
+	wp.sm.SetMemory(in, string(k), json.RawMessage(v))
+}
+
+func (wp *wasmPlugin) httpGet(ctx context.Context, m api.Module, uptr,
+	ulen uint32) uint64 {
+
+	byt, ok := read(m, uptr, ulen)
+	if !ok {
+		return 0
+	}
+	u, err := url.Parse(string(byt))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return 0
+	}
+	if !wp.allowedHost(u.Hostname()) {
+		wp.p.Log.Info("denied request to", u.Hostname())
+		return 0
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return 0
+	}
+	resp, err := wp.client.Do(req.WithContext(ctx))
+	if err != nil {
+		wp.p.Log.Info("failed request to", u.Hostname(), err)
+		return 0
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body,
+		N: maxHTTPBody})
+	if err != nil {
+		return 0
+	}
+	return write(ctx, m, body)
+}
+
+// checkRedirect stops the plugin's requests from being redirected to hosts it
+// didn't list, which would get around its allowlist, e.g. to reach internal
+// addresses.
+func (wp *wasmPlugin) checkRedirect(req *http.Request,
+	via []*http.Request) error {
+
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	u := req.URL
+	if (u.Scheme != "http" && u.Scheme != "https") ||
+		!wp.allowedHost(u.Hostname()) {
+		wp.p.Log.Info("denied redirect to", u.Hostname())
+		return http.ErrUseLastResponse
+	}
+	return nil
+}
+
+// allowedHost reports whether a plugin listed a host in its plugin.json.
+func (wp *wasmPlugin) allowedHost(h string) bool {
+	for _, allowed := range wp.cfg.Hosts {
+		if h == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// read copies n bytes at ptr out of a plugin's memory, reporting whether
+// they're in bounds.
+func read(m api.Module, ptr, n uint32) ([]byte, bool) {
+	byt, ok := m.Memory().Read(ptr, n)
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), byt...), true
+}
+
+// write copies bytes into memory allocated by a plugin, returning their
+// pointer in the high 32 bits and length in the low, or 0 if they can't be
+// written.
+func write(ctx context.Context, m api.Module, byt []byte) uint64 {
+	if len(byt) == 0 {
+		return 0
+	}
+	res, err := m.ExportedFunction("alloc").Call(ctx, uint64(len(byt)))
+	if err != nil {
+		return 0
+	}
+	ptr := uint32(res[0])
+	if !m.Memory().Write(ptr, byt) {
+		return 0
+	}
+	return uint64(ptr)<<32 | uint64(len(byt))
+}
@@ -0,0 +1,47 @@
+package task
+
+import (
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/language"
+)
+
+// KeyConfirmSummary is the memory describing the action awaiting confirmation,
+// e.g. "An UberX can pick you up in 4 minutes for $12-15." Set it before the
+// state machine reaches a RequestConfirmation state.
+const KeyConfirmSummary = "__confirm_summary"
+
+const keyConfirmed = "__confirmed"
+
+// requestConfirmation asks the user to confirm an action described by
+// KeyConfirmSummary. If the user agrees, the state machine continues to the
+// next state, which should take the action. If not, the state machine is
+// reset.
+func requestConfirmation(sm *dt.StateMachine, label string) []dt.State {
+	return []dt.State{
+		{
+			Label: label,
+			OnEntry: func(in *dt.Msg) string {
+				sm.DeleteMemory(in, keyConfirmed)
+				s := sm.GetMemory(in, KeyConfirmSummary).String()
+				return s + " Should I go ahead?"
+			},
+			OnInput: func(in *dt.Msg) {
+				yes := language.ExtractYesNo(in.Sentence)
+				if yes.Valid {
+					sm.SetMemory(in, keyConfirmed, yes.Bool)
+				}
+			},
+			Complete: func(in *dt.Msg) (bool, string) {
+				if !sm.HasMemory(in, keyConfirmed) {
+					return false, "Should I go ahead? Yes or no?"
+				}
+				if !sm.GetMemory(in, keyConfirmed).Bool() {
+					sm.Reset(in)
+					return false, "OK, I won't."
+				}
+				sm.DeleteMemory(in, keyConfirmed)
+				return true, ""
+			},
+		},
+	}
+}
@@ -0,0 +1,100 @@
+package task
+
+import (
+	"time"
+
+	"github.com/itsabot/abot/core"
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/language"
+)
+
+// KeyIntent is the memory identifying a transactional intent, e.g. "book
+// flight UA100 on 2016-05-01." Set it before the state machine reaches a
+// RequestDuplicateConfirmation state, and record the intent with
+// plugin.RecordIntent once it's carried out.
+const KeyIntent = "__intent"
+
+// KeyIntentSummary optionally describes the intent in the past tense for
+// asking the user about duplicates, e.g. "booked flight UA100."
+const KeyIntentSummary = "__intent_summary"
+
+// duplicateWindow is how recently an intent must have been carried out to ask
+// the user before doing it again.
+const duplicateWindow = 24 * time.Hour
+
+const (
+	keyDuplicateAsked     = "__duplicate_asked"
+	keyDuplicateConfirmed = "__duplicate_confirmed"
+)
+
+// requestDuplicateConfirmation asks the user to confirm an intent that was
+// already carried out recently, like booking the same flight twice. If the
+// intent is new or the user confirms, the state machine continues to the next
+// state. If not, the state machine is reset.
+func requestDuplicateConfirmation(sm *dt.StateMachine, label string) []dt.State {
+	return []dt.State{
+		{
+			Label:          label,
+			SkipIfComplete: true,
+			OnEntry: func(in *dt.Msg) string {
+				sm.SetMemory(in, keyDuplicateAsked, true)
+				t := lastIntent(sm, in)
+				if t == nil {
+					return "Should I go ahead? Yes or no?"
+				}
+				when := t.Format("3:04PM")
+				if time.Since(*t) > 12*time.Hour {
+					when = t.Format("Jan 2 at 3:04PM")
+				}
+				s := "You already asked me to do this at " + when + "."
+				summary := sm.GetMemory(in, KeyIntentSummary).String()
+				if len(summary) > 0 {
+					s = "You already " + summary + " at " + when + "."
+				}
+				return s + " Should I do it again?"
+			},
+			OnInput: func(in *dt.Msg) {
+				if !sm.GetMemory(in, keyDuplicateAsked).Bool() {
+					return
+				}
+				yes := language.ExtractYesNo(in.Sentence)
+				if yes.Valid {
+					sm.SetMemory(in, keyDuplicateConfirmed, yes.Bool)
+				}
+			},
+			Complete: func(in *dt.Msg) (bool, string) {
+				if !sm.GetMemory(in, keyDuplicateAsked).Bool() {
+					return lastIntent(sm, in) == nil, ""
+				}
+				if !sm.HasMemory(in, keyDuplicateConfirmed) {
+					return false, "Should I do it again? Yes or no?"
+				}
+				confirmed := sm.GetMemory(in, keyDuplicateConfirmed).Bool()
+				sm.DeleteMemory(in, keyDuplicateAsked)
+				sm.DeleteMemory(in, keyDuplicateConfirmed)
+				if !confirmed {
+					sm.Reset(in)
+					return false, "OK, I won't."
+				}
+				return true, ""
+			},
+		},
+	}
+}
+
+// lastIntent returns when the intent in KeyIntent was last carried out for the
+// user, or nil if it wasn't recently. Errors are logged and treated as the
+// intent being new, so a database hiccup doesn't block the plugin.
+func lastIntent(sm *dt.StateMachine, in *dt.Msg) *time.Time {
+	key := sm.GetMemory(in, KeyIntent).String()
+	if len(key) == 0 {
+		return nil
+	}
+	t, err := core.LastIntent(in.User.ID, in.Plugin, key, duplicateWindow)
+	if err != nil {
+		log.Info("failed to check for duplicate intent", err)
+		return nil
+	}
+	return t
+}
@@ -0,0 +1,142 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/itsabot/abot/core"
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/interface/places/driver"
+	"github.com/itsabot/abot/shared/language"
+)
+
+// KeyPlaceQuery is the memory holding the name of the place to resolve, e.g.
+// "Springfield." Set it before the state machine reaches a RequestPlace state.
+const KeyPlaceQuery = "__place_query"
+
+// KeyPlace is the memory holding the resolved place as a JSON-encoded
+// driver.Place once a RequestPlace state completes.
+const KeyPlace = "__place"
+
+// keyPlaceCandidates is a conversation variable, since candidates are only
+// useful while the user is choosing between them.
+const keyPlaceCandidates = "__place_candidates"
+
+// placeCandidates are the places matching a query, which the user is asked to
+// choose between. Asked is set once the user has been prompted, so replies
+// are treated as choices or, if nothing matched, as a new query.
+type placeCandidates struct {
+	Query  string
+	Places []driver.Place
+	Asked  bool
+}
+
+// requestPlace resolves the name in KeyPlaceQuery into a place stored in
+// KeyPlace. If the name matches several places, like "Springfield," the user
+// is asked which they meant, and their choice is remembered for future
+// references to the same name.
+func requestPlace(sm *dt.StateMachine, label string) []dt.State {
+	return []dt.State{
+		{
+			Label:          label,
+			SkipIfComplete: true,
+			OnEntry: func(in *dt.Msg) string {
+				c := getPlaceCandidates(sm, in)
+				c.Asked = true
+				sm.SetVar(in, keyPlaceCandidates, c)
+				if len(c.Places) == 0 {
+					return fmt.Sprintf("I couldn't find %s. Could you be more specific?",
+						c.Query)
+				}
+				s := fmt.Sprintf("Which %s did you mean?", c.Query)
+				for i, p := range c.Places {
+					s += fmt.Sprintf(" (%d) %s", i+1, p.Address)
+				}
+				return s
+			},
+			OnInput: func(in *dt.Msg) {
+				if sm.HasMemory(in, KeyPlace) {
+					return
+				}
+				c := getPlaceCandidates(sm, in)
+				if c.Asked && len(c.Places) > 0 {
+					p := choosePlace(in.Sentence, c.Places)
+					if p == nil {
+						return
+					}
+					sm.SetMemory(in, KeyPlace, p)
+					if err := core.RememberPlace(in.User, c.Query, p); err != nil {
+						log.Info("failed to remember place", err)
+					}
+					return
+				}
+				if c.Asked {
+					// Nothing matched the original name, so the
+					// reply is a more specific one.
+					c.Query = strings.TrimRight(in.Sentence, ".!?")
+					sm.SetMemory(in, KeyPlaceQuery, c.Query)
+				}
+				ps, err := core.Geocode(in.User, c.Query)
+				if err != nil {
+					log.Info("failed to geocode", c.Query, err)
+				}
+				if len(ps) == 1 {
+					sm.SetMemory(in, KeyPlace, ps[0])
+					return
+				}
+				sm.SetVar(in, keyPlaceCandidates, &placeCandidates{
+					Query:  c.Query,
+					Places: ps,
+				})
+			},
+			Complete: func(in *dt.Msg) (bool, string) {
+				if sm.HasMemory(in, KeyPlace) {
+					sm.DeleteVar(in, keyPlaceCandidates)
+					return true, ""
+				}
+				c := getPlaceCandidates(sm, in)
+				if len(c.Places) > 0 {
+					return false, "Which one? You can reply with its number."
+				}
+				return false, "I couldn't find that. Could you be more specific?"
+			},
+		},
+	}
+}
+
+// getPlaceCandidates returns the candidates for the current KeyPlaceQuery.
+// Candidates left over from an earlier query are discarded.
+func getPlaceCandidates(sm *dt.StateMachine, in *dt.Msg) *placeCandidates {
+	var query string
+	if err := json.Unmarshal(sm.GetMemory(in, KeyPlaceQuery).Val, &query); err != nil {
+		log.Debug("failed to get place query", err)
+	}
+	c := &placeCandidates{}
+	err := json.Unmarshal(sm.GetVar(in, keyPlaceCandidates).Val, c)
+	if err != nil || c.Query != query {
+		return &placeCandidates{Query: query}
+	}
+	return c
+}
+
+// choosePlace returns the place a user chose by number, e.g. "2" or "the
+// second one," or by part of its address, e.g. "Illinois." nil is returned
+// if the reply doesn't identify exactly one place.
+func choosePlace(s string, ps []driver.Place) *driver.Place {
+	opts := make([]language.Option, len(ps))
+	for i, p := range ps {
+		opts[i] = language.Option{
+			Name: p.Address,
+			Attrs: map[string]float64{
+				language.AttrDistance: float64(p.DistanceInMeters),
+			},
+		}
+	}
+	i := language.ExtractSelection(s, opts)
+	if i < 0 {
+		return nil
+	}
+	return &ps[i]
+}
@@ -0,0 +1,71 @@
+package task
+
+import (
+	"encoding/json"
+
+	"github.com/itsabot/abot/core"
+	"github.com/itsabot/abot/core/log"
+	"github.com/itsabot/abot/shared/datatypes"
+	"github.com/itsabot/abot/shared/language"
+)
+
+// KeyOptions is the memory holding the options to choose between as a
+// JSON-encoded []language.Option. Set it before the state machine reaches a
+// RequestSelection state.
+const KeyOptions = "__options"
+
+// KeyOptionsPrompt is the memory holding the question asking the user to
+// choose, e.g. "Which restaurant would you like?" The options are listed
+// after it.
+const KeyOptionsPrompt = "__options_prompt"
+
+// KeySelection is the memory holding the option the user selected as a
+// JSON-encoded language.Option once a RequestSelection state completes.
+const KeySelection = "__selection"
+
+// requestSelection asks the user to choose one of the options in KeyOptions,
+// storing their choice in KeySelection. Replies may refer to an option by
+// position, comparison or name. See language.ExtractSelection.
+func requestSelection(sm *dt.StateMachine, label string) []dt.State {
+	return []dt.State{
+		{
+			Label:          label,
+			SkipIfComplete: true,
+			OnEntry: func(in *dt.Msg) string {
+				s := sm.GetMemory(in, KeyOptionsPrompt).String()
+				if len(s) == 0 {
+					s = "Which would you like?"
+				}
+				var names []string
+				for _, opt := range getOptions(sm, in) {
+					names = append(names, opt.Name)
+				}
+				return core.RenderFor(in.User, &dt.Response{
+					Text:    s,
+					Buttons: names,
+				})
+			},
+			OnInput: func(in *dt.Msg) {
+				opts := getOptions(sm, in)
+				i := language.ExtractSelection(in.Sentence, opts)
+				if i >= 0 {
+					sm.SetMemory(in, KeySelection, opts[i])
+				}
+			},
+			Complete: func(in *dt.Msg) (bool, string) {
+				if sm.HasMemory(in, KeySelection) {
+					return true, ""
+				}
+				return false, "Which one? You can reply with its number."
+			},
+		},
+	}
+}
+
+func getOptions(sm *dt.StateMachine, in *dt.Msg) []language.Option {
+	var opts []language.Option
+	if err := json.Unmarshal(sm.GetMemory(in, KeyOptions).Val, &opts); err != nil {
+		log.Debug("failed to get options", err)
+	}
+	return opts
+}
@@ -22,6 +22,30 @@ const (
 	// RequestSignup requests that a user sign up or add their contact
 	// information via ABOT_URL/signup.
 	RequestSignup
+
+	// RequestConfirmation asks the user to confirm an action, like booking
+	// a ride, before the state machine continues. Describe the action by
+	// setting the KeyConfirmSummary memory beforehand. If the user
+	// declines, the state machine is reset.
+	RequestConfirmation
+
+	// RequestPlace resolves a place name set in the KeyPlaceQuery memory
+	// into a place stored in KeyPlace, asking the user which they meant
+	// when the name is ambiguous.
+	RequestPlace
+
+	// RequestDuplicateConfirmation asks the user to confirm a
+	// transactional intent, set in the KeyIntent memory, that was already
+	// carried out in the past day, e.g. "You already booked flight UA100
+	// at 9:15AM. Should I do it again?" New intents continue without
+	// asking. If the user declines, the state machine is reset.
+	RequestDuplicateConfirmation
+
+	// RequestSelection asks the user to choose one of the options set in
+	// the KeyOptions memory, storing the chosen option in KeySelection.
+	// Users can reply by position, e.g. "the second one," by comparison,
+	// e.g. "the cheaper option," or by name, e.g. "the Italian place."
+	RequestSelection
 )
 
 // New returns a slice of States for inclusion into a StateMachine.SetStates()
@@ -34,6 +58,14 @@ func New(sm *dt.StateMachine, t Type, label string) []dt.State {
 		return getCalendar(sm, label)
 	case RequestSignup:
 		return requestSignup(sm, label)
+	case RequestConfirmation:
+		return requestConfirmation(sm, label)
+	case RequestPlace:
+		return requestPlace(sm, label)
+	case RequestDuplicateConfirmation:
+		return requestDuplicateConfirmation(sm, label)
+	case RequestSelection:
+		return requestSelection(sm, label)
 	}
 	return []dt.State{}
 }